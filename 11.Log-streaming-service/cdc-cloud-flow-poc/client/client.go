@@ -0,0 +1,156 @@
+// Package client is a Go client for the flow API of the manager, generated
+// from openapi.yaml at the module root (api.gen.go); this file is the part
+// written by hand.
+//
+//	c, err := client.New("http://manager-service")
+//	flow, err := c.CreateFlow(ctx, &client.FlowConfiguration{
+//		Metadata: client.ObjectMeta{Name: "orders-to-lake"},
+//		Spec: client.FlowSpec{
+//			Sources:      []string{"kafka-source"},
+//			Destinations: []string{"kafka-destination"},
+//			Resources:    &client.ResourceSpec{CPU: "500m", Memory: "256Mi"},
+//		},
+//	})
+//	if usage, ok := client.QuotaExceeded(err); ok { ... }
+//
+// A response other than 2xx comes back as *APIError.
+package client
+
+//go:generate go run ../internal/openapigen -spec ../openapi.yaml -pkg client -o api.gen.go
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Client calls one manager. It is safe for concurrent use.
+type Client struct {
+	base *url.URL
+	http *http.Client
+}
+
+// Option configures a Client.
+type Option func(*Client)
+
+// WithHTTPClient sends requests through hc instead of a client with a 30s
+// timeout, e.g. for TLS settings or tracing.
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) { c.http = hc }
+}
+
+// New returns a client for the manager at baseURL, e.g.
+// http://manager-service or http://localhost:8080.
+func New(baseURL string, opts ...Option) (*Client, error) {
+	u, err := url.Parse(strings.TrimSuffix(baseURL, "/"))
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return nil, fmt.Errorf("base URL %q: want http(s)://host[:port]", baseURL)
+	}
+	c := &Client{base: u, http: &http.Client{Timeout: 30 * time.Second}}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c, nil
+}
+
+// APIError is a response other than 2xx. Message is the body of a
+// text/plain error; Body is the body as sent.
+type APIError struct {
+	StatusCode int
+	Message    string
+	Body       []byte
+}
+
+func (e *APIError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("flow api: %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+	}
+	return fmt.Sprintf("flow api: %d %s", e.StatusCode, e.Message)
+}
+
+// Decode unmarshals a JSON error body into v.
+func (e *APIError) Decode(v any) error { return json.Unmarshal(e.Body, v) }
+
+// IsNotFound reports whether err is a 404 from the API.
+func IsNotFound(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound
+}
+
+// QuotaExceeded returns the namespace usage when err is a 409 from
+// CreateFlow or UpdateFlow: the flow did not fit into the quota.
+func QuotaExceeded(err error) (*QuotaUsage, bool) {
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusConflict {
+		return nil, false
+	}
+	var u QuotaUsage
+	if apiErr.Decode(&u) != nil {
+		return nil, false
+	}
+	return &u, true
+}
+
+// do sends in as JSON, when not nil, and decodes a 2xx JSON answer into
+// out, when not nil.
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, in, out any) error {
+	u := *c.base
+	u.Path += path
+	u.RawQuery = query.Encode()
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(io.LimitReader(resp.Body, 4<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode/100 != 2 {
+		apiErr := &APIError{StatusCode: resp.StatusCode, Body: b}
+		if strings.HasPrefix(resp.Header.Get("Content-Type"), "text/plain") {
+			apiErr.Message = strings.TrimSpace(string(b))
+		} else {
+			var e struct {
+				Error string `json:"error"`
+			}
+			if json.Unmarshal(b, &e) == nil {
+				apiErr.Message = e.Error
+			}
+		}
+		return apiErr
+	}
+	if out == nil || len(b) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(b, out); err != nil {
+		return fmt.Errorf("%s %s: decode response: %w", method, path, err)
+	}
+	return nil
+}
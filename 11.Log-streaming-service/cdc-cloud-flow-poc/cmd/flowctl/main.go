@@ -0,0 +1,183 @@
+// Command flowctl manages flows through the manager's flow API, using the
+// generated client in client/.
+//
+//	flowctl create -f flow.yaml
+//	flowctl update -f flow.yaml      # metadata.resourceVersion as last returned
+//	flowctl delete sample-flow
+//	flowctl metrics -range 1h sample-flow
+//	flowctl quota
+//	flowctl crd-status
+//
+// The manager is -server, or FLOWCTL_SERVER (default http://localhost:8080).
+// A flow file is YAML or JSON in the API's FlowConfiguration shape; "-"
+// reads it from stdin. Results are printed as JSON.
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/yaml"
+
+	"cdc-cloud-flow-poc/client"
+)
+
+const usage = `usage: flowctl [-server URL] [-timeout D] <command> [flags]
+
+commands:
+  create -f FILE            create the flow in FILE
+  update -f FILE            replace the flow in FILE
+  delete NAME               delete a flow
+  metrics [-range D] [-step D] NAME
+                            throughput and errors of a flow
+  quota                     namespace quota and usage
+  crd-status                state of the FlowConfiguration CRD
+`
+
+func main() {
+	server := os.Getenv("FLOWCTL_SERVER")
+	if server == "" {
+		server = "http://localhost:8080"
+	}
+	flag.StringVar(&server, "server", server, "manager base URL ($FLOWCTL_SERVER)")
+	timeout := flag.Duration("timeout", 30*time.Second, "time limit of the call")
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	c, err := client.New(server)
+	if err != nil {
+		fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	cmd, args := flag.Arg(0), flag.Args()[1:]
+	fs := flag.NewFlagSet("flowctl "+cmd, flag.ExitOnError)
+	var result any
+	switch cmd {
+	case "create", "update":
+		file := fs.String("f", "", "flow file, YAML or JSON; - is stdin")
+		fs.Parse(args)
+		if *file == "" || fs.NArg() > 0 {
+			fatal(fmt.Errorf("usage: flowctl %s -f FILE", cmd))
+		}
+		flow, err := readFlow(*file)
+		if err != nil {
+			fatal(err)
+		}
+		if cmd == "create" {
+			result, err = c.CreateFlow(ctx, flow)
+		} else {
+			result, err = c.UpdateFlow(ctx, flow)
+		}
+		if u, ok := client.QuotaExceeded(err); ok {
+			fmt.Fprintf(os.Stderr, "flowctl: %s\n", u.Error)
+			printJSON(u)
+			os.Exit(1)
+		}
+		if err != nil {
+			fatal(err)
+		}
+	case "delete":
+		fs.Parse(args)
+		if fs.NArg() != 1 {
+			fatal(fmt.Errorf("usage: flowctl delete NAME"))
+		}
+		if err := c.DeleteFlow(ctx, fs.Arg(0)); err != nil {
+			fatal(err)
+		}
+		fmt.Printf("flow %s deleted\n", fs.Arg(0))
+		return
+	case "metrics":
+		var params client.GetFlowMetricsParams
+		fs.StringVar(&params.Range, "range", "", "also return series over this long, e.g. 1h")
+		fs.StringVar(&params.Step, "step", "", "resolution of the series (default range/60)")
+		fs.Parse(args)
+		if fs.NArg() != 1 {
+			fatal(fmt.Errorf("usage: flowctl metrics [-range D] [-step D] NAME"))
+		}
+		if result, err = c.GetFlowMetrics(ctx, fs.Arg(0), &params); err != nil {
+			fatal(err)
+		}
+	case "quota":
+		fs.Parse(args)
+		if result, err = c.GetQuota(ctx); err != nil {
+			fatal(err)
+		}
+	case "crd-status":
+		fs.Parse(args)
+		st, err := c.GetCRDStatus(ctx)
+		var apiErr *client.APIError
+		if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusServiceUnavailable {
+			// not ready: the body is the status all the same
+			st = &client.CRDStatus{}
+			if apiErr.Decode(st) != nil {
+				fatal(err)
+			}
+			printJSON(st)
+			os.Exit(1)
+		}
+		if err != nil {
+			fatal(err)
+		}
+		result = st
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+	printJSON(result)
+}
+
+// readFlow reads a FlowConfiguration from path, YAML or JSON.
+func readFlow(path string) (*client.FlowConfiguration, error) {
+	var b []byte
+	var err error
+	if path == "-" {
+		b, err = io.ReadAll(os.Stdin)
+	} else {
+		b, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	js, err := yaml.ToJSON(b)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	dec := json.NewDecoder(bytes.NewReader(js))
+	dec.DisallowUnknownFields() // a typo should not silently drop a setting
+	var flow client.FlowConfiguration
+	if err := dec.Decode(&flow); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if flow.Metadata.Name == "" {
+		return nil, fmt.Errorf("%s: metadata.name is required", path)
+	}
+	return &flow, nil
+}
+
+func printJSON(v any) {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, "flowctl:", err)
+	os.Exit(1)
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+)
+
+// The flow controller (-controller / FLOW_CONTROLLER=true) keeps each
+// FlowConfiguration's external resources in step with it through a
+// Provisioner. Deletion is guarded by a finalizer: the API server only sets
+// deletionTimestamp, the controller deprovisions, and the object goes away
+// once the controller removes its finalizer. A failing Deprovision is
+// retried with exponential backoff (1s doubling to 5m) for as long as it
+// takes; the Terminating condition shows the attempt and the last error.
+//
+// If the external side is gone for good, annotate the flow with
+// example.com/skip-cleanup=true to drop the finalizer without cleaning up.
+//
+// The controller assumes it is the only one running (replicas: 1).
+
+const (
+	flowFinalizer         = "example.com/cleanup-external-resources"
+	skipCleanupAnnotation = "example.com/skip-cleanup"
+
+	conditionProvisioned = "Provisioned"
+	conditionTerminating = "Terminating"
+)
+
+type flowController struct {
+	client   dynamic.Interface
+	prov     Provisioner
+	informer cache.SharedIndexInformer
+	queue    workqueue.RateLimitingInterface
+}
+
+func newFlowController(client dynamic.Interface, ns string, prov Provisioner) *flowController {
+	factory := dynamicinformer.NewFilteredDynamicSharedInformerFactory(client, 10*time.Minute, ns, nil)
+	c := &flowController{
+		client:   client,
+		prov:     prov,
+		informer: factory.ForResource(flowGVR).Informer(),
+		queue:    workqueue.NewRateLimitingQueue(workqueue.NewItemExponentialFailureRateLimiter(time.Second, 5*time.Minute)),
+	}
+	enqueue := func(obj interface{}) {
+		if key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj); err == nil {
+			c.queue.Add(key)
+		}
+	}
+	c.informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    enqueue,
+		UpdateFunc: func(_, obj interface{}) { enqueue(obj) },
+		DeleteFunc: enqueue,
+	})
+	return c
+}
+
+// run blocks until ctx is done.
+func (c *flowController) run(ctx context.Context, workers int) {
+	defer c.queue.ShutDown()
+	go c.informer.Run(ctx.Done())
+	if !cache.WaitForCacheSync(ctx.Done(), c.informer.HasSynced) {
+		return
+	}
+	log.Printf("[controller] watching FlowConfigurations with %d workers", workers)
+	for i := 0; i < workers; i++ {
+		go wait.UntilWithContext(ctx, c.work, time.Second)
+	}
+	<-ctx.Done()
+}
+
+func (c *flowController) work(ctx context.Context) {
+	for {
+		item, quit := c.queue.Get()
+		if quit {
+			return
+		}
+		key := item.(string)
+		if err := c.reconcile(ctx, key); err != nil {
+			log.Printf("[controller] %s: %v (retry %d)", key, err, c.queue.NumRequeues(key)+1)
+			c.queue.AddRateLimited(key)
+		} else {
+			c.queue.Forget(key)
+		}
+		c.queue.Done(key)
+	}
+}
+
+func (c *flowController) reconcile(ctx context.Context, key string) error {
+	obj, exists, err := c.informer.GetIndexer().GetByKey(key)
+	if err != nil || !exists {
+		return err
+	}
+	flow := obj.(*unstructured.Unstructured).DeepCopy()
+	if flow.GetDeletionTimestamp() != nil {
+		return c.finalize(ctx, key, flow)
+	}
+
+	if !hasFinalizer(flow) {
+		flow.SetFinalizers(append(flow.GetFinalizers(), flowFinalizer))
+		if flow, err = c.update(ctx, flow); err != nil {
+			return fmt.Errorf("add finalizer: %w", err)
+		}
+	}
+	if pc := meta.FindStatusCondition(conditionsOf(flow), conditionProvisioned); pc != nil &&
+		pc.Status == v1.ConditionTrue && pc.ObservedGeneration == flow.GetGeneration() {
+		return nil // nothing changed in the spec since the last success
+	}
+	perr := c.prov.Provision(ctx, flow)
+	cond := v1.Condition{Type: conditionProvisioned, Status: v1.ConditionTrue, Reason: "Provisioned", Message: "external resources are in place"}
+	if perr != nil {
+		cond = v1.Condition{Type: conditionProvisioned, Status: v1.ConditionFalse, Reason: "ProvisionFailed", Message: perr.Error()}
+	}
+	if _, err := c.setCondition(ctx, flow, cond); err != nil {
+		return err
+	}
+	return perr
+}
+
+// finalize deprovisions a flow that is being deleted and then releases it.
+func (c *flowController) finalize(ctx context.Context, key string, flow *unstructured.Unstructured) error {
+	if !hasFinalizer(flow) {
+		return nil
+	}
+	if flow.GetAnnotations()[skipCleanupAnnotation] == "true" {
+		log.Printf("[controller] %s: %s set, leaving external resources behind", key, skipCleanupAnnotation)
+		return c.release(ctx, flow)
+	}
+
+	attempt := c.queue.NumRequeues(key) + 1
+	flow, err := c.setCondition(ctx, flow, v1.Condition{Type: conditionTerminating, Status: v1.ConditionTrue,
+		Reason: "Deprovisioning", Message: fmt.Sprintf("deleting external resources (attempt %d)", attempt)})
+	if err != nil {
+		return err
+	}
+	if err := c.prov.Deprovision(ctx, flow); err != nil {
+		if _, serr := c.setCondition(ctx, flow, v1.Condition{Type: conditionTerminating, Status: v1.ConditionTrue,
+			Reason: "DeprovisionFailed", Message: fmt.Sprintf("attempt %d: %v; retrying", attempt, err)}); serr != nil {
+			log.Printf("[controller] %s: status: %v", key, serr)
+		}
+		return fmt.Errorf("deprovision: %w", err)
+	}
+	log.Printf("[controller] %s: external resources deleted after %d attempt(s)", key, attempt)
+	return c.release(ctx, flow)
+}
+
+// release removes our finalizer, letting the API server delete the flow.
+func (c *flowController) release(ctx context.Context, flow *unstructured.Unstructured) error {
+	var keep []string
+	for _, f := range flow.GetFinalizers() {
+		if f != flowFinalizer {
+			keep = append(keep, f)
+		}
+	}
+	flow.SetFinalizers(keep)
+	_, err := c.update(ctx, flow)
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("remove finalizer: %w", err)
+	}
+	return nil
+}
+
+func (c *flowController) update(ctx context.Context, flow *unstructured.Unstructured) (*unstructured.Unstructured, error) {
+	return c.client.Resource(flowGVR).Namespace(flow.GetNamespace()).Update(ctx, flow, v1.UpdateOptions{FieldManager: fieldManager})
+}
+
+// setCondition writes cond into status.conditions (through the status
+// subresource) and returns the updated object. Writing an unchanged
+// condition is skipped.
+func (c *flowController) setCondition(ctx context.Context, flow *unstructured.Unstructured, cond v1.Condition) (*unstructured.Unstructured, error) {
+	conditions := conditionsOf(flow)
+	cond.ObservedGeneration = flow.GetGeneration()
+	if !meta.SetStatusCondition(&conditions, cond) {
+		return flow, nil
+	}
+	m, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&flowStatus{Conditions: conditions})
+	if err != nil {
+		return nil, err
+	}
+	flow = flow.DeepCopy()
+	if err := unstructured.SetNestedMap(flow.Object, m, "status"); err != nil {
+		return nil, err
+	}
+	out, err := c.client.Resource(flowGVR).Namespace(flow.GetNamespace()).UpdateStatus(ctx, flow, v1.UpdateOptions{FieldManager: fieldManager})
+	if err != nil {
+		return nil, fmt.Errorf("update status: %w", err)
+	}
+	return out, nil
+}
+
+type flowStatus struct {
+	Conditions []v1.Condition `json:"conditions,omitempty"`
+}
+
+// conditionsOf reads status.conditions; a malformed status reads as none.
+func conditionsOf(flow *unstructured.Unstructured) []v1.Condition {
+	var st flowStatus
+	if m, ok, _ := unstructured.NestedMap(flow.Object, "status"); ok {
+		_ = runtime.DefaultUnstructuredConverter.FromUnstructured(m, &st)
+	}
+	return st.Conditions
+}
+
+func hasFinalizer(flow *unstructured.Unstructured) bool {
+	for _, f := range flow.GetFinalizers() {
+		if f == flowFinalizer {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+)
+
+// fakeProvisioner records its calls and fails while err is set.
+type fakeProvisioner struct {
+	provisioned, deprovisioned int
+	err                        error
+}
+
+func (p *fakeProvisioner) Provision(context.Context, *unstructured.Unstructured) error {
+	p.provisioned++
+	return p.err
+}
+
+func (p *fakeProvisioner) Deprovision(context.Context, *unstructured.Unstructured) error {
+	p.deprovisioned++
+	return p.err
+}
+
+func newFlow(name string) *unstructured.Unstructured {
+	u := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "example.com/v1",
+		"kind":       "FlowConfiguration",
+		"metadata":   map[string]interface{}{"name": name, "namespace": "default"},
+		"spec":       map[string]interface{}{"sources": []interface{}{"orders"}, "destinations": []interface{}{"s3"}},
+	}}
+	u.SetGeneration(1)
+	return u
+}
+
+func fakeClient(objs ...runtime.Object) *dynamicfake.FakeDynamicClient {
+	return dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{flowGVR: "FlowConfigurationList"}, objs...)
+}
+
+// harness is a controller whose informer cache is filled by hand, from
+// what the fake API server holds, instead of by a watch.
+type harness struct {
+	t      *testing.T
+	client *dynamicfake.FakeDynamicClient
+	prov   *fakeProvisioner
+	c      *flowController
+}
+
+func newHarness(t *testing.T, flows ...*unstructured.Unstructured) *harness {
+	var objs []runtime.Object
+	for _, f := range flows {
+		objs = append(objs, f)
+	}
+	h := &harness{t: t, client: fakeClient(objs...), prov: &fakeProvisioner{}}
+	h.c = newFlowController(h.client, "default", h.prov)
+	for _, f := range flows {
+		h.sync(f.GetName())
+	}
+	return h
+}
+
+// sync copies flow name from the API server into the informer cache, or
+// drops it there once it is gone.
+func (h *harness) sync(name string) {
+	h.t.Helper()
+	idx := h.c.informer.GetIndexer()
+	got, err := h.client.Resource(flowGVR).Namespace("default").Get(context.Background(), name, v1.GetOptions{})
+	if err != nil {
+		if old, ok, _ := idx.GetByKey("default/" + name); ok {
+			_ = idx.Delete(old)
+		}
+		return
+	}
+	if err := idx.Update(got); err != nil {
+		h.t.Fatal(err)
+	}
+}
+
+func (h *harness) reconcile(name string) error {
+	h.t.Helper()
+	err := h.c.reconcile(context.Background(), "default/"+name)
+	h.sync(name)
+	return err
+}
+
+func (h *harness) get(name string) *unstructured.Unstructured {
+	h.t.Helper()
+	got, err := h.client.Resource(flowGVR).Namespace("default").Get(context.Background(), name, v1.GetOptions{})
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	return got
+}
+
+func condition(t *testing.T, flow *unstructured.Unstructured, typ string) v1.Condition {
+	t.Helper()
+	c := meta.FindStatusCondition(conditionsOf(flow), typ)
+	if c == nil {
+		t.Fatalf("no %s condition in %v", typ, flow.Object["status"])
+	}
+	return *c
+}
+
+func TestReconcileProvisionsOncePerGeneration(t *testing.T) {
+	h := newHarness(t, newFlow("orders"))
+	if err := h.reconcile("orders"); err != nil {
+		t.Fatal(err)
+	}
+	flow := h.get("orders")
+	if !hasFinalizer(flow) {
+		t.Errorf("finalizers = %v", flow.GetFinalizers())
+	}
+	if c := condition(t, flow, conditionProvisioned); c.Status != v1.ConditionTrue || c.ObservedGeneration != 1 {
+		t.Errorf("Provisioned = %+v", c)
+	}
+
+	// a resync of the same generation leaves the provisioner alone
+	if err := h.reconcile("orders"); err != nil {
+		t.Fatal(err)
+	}
+	if h.prov.provisioned != 1 {
+		t.Fatalf("provisioned %d times", h.prov.provisioned)
+	}
+
+	// a spec change does not
+	flow.SetGeneration(2)
+	if _, err := h.client.Resource(flowGVR).Namespace("default").Update(context.Background(), flow, v1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	h.sync("orders")
+	if err := h.reconcile("orders"); err != nil {
+		t.Fatal(err)
+	}
+	if h.prov.provisioned != 2 {
+		t.Fatalf("provisioned %d times after the spec changed", h.prov.provisioned)
+	}
+}
+
+func TestReconcileProvisionFailure(t *testing.T) {
+	h := newHarness(t, newFlow("orders"))
+	h.prov.err = errors.New("kafka unreachable")
+	if err := h.reconcile("orders"); err == nil {
+		t.Fatal("a failed Provision must be retried")
+	}
+	c := condition(t, h.get("orders"), conditionProvisioned)
+	if c.Status != v1.ConditionFalse || c.Reason != "ProvisionFailed" || c.Message != "kafka unreachable" {
+		t.Fatalf("Provisioned = %+v", c)
+	}
+
+	h.prov.err = nil
+	if err := h.reconcile("orders"); err != nil {
+		t.Fatal(err)
+	}
+	if c := condition(t, h.get("orders"), conditionProvisioned); c.Status != v1.ConditionTrue {
+		t.Fatalf("Provisioned = %+v after the retry", c)
+	}
+}
+
+func deleting(f *unstructured.Unstructured) *unstructured.Unstructured {
+	now := v1.Now()
+	f.SetDeletionTimestamp(&now)
+	f.SetFinalizers([]string{"example.com/other", flowFinalizer})
+	return f
+}
+
+func TestFinalizeRetriesDeprovision(t *testing.T) {
+	h := newHarness(t, deleting(newFlow("orders")))
+	h.prov.err = errors.New("sink busy")
+	if err := h.reconcile("orders"); err == nil {
+		t.Fatal("a failed Deprovision must be retried")
+	}
+	flow := h.get("orders")
+	if !hasFinalizer(flow) {
+		t.Fatal("finalizer dropped although the external resources are still there")
+	}
+	if c := condition(t, flow, conditionTerminating); c.Reason != "DeprovisionFailed" {
+		t.Fatalf("Terminating = %+v", c)
+	}
+
+	h.prov.err = nil
+	if err := h.reconcile("orders"); err != nil {
+		t.Fatal(err)
+	}
+	flow = h.get("orders")
+	if hasFinalizer(flow) || len(flow.GetFinalizers()) != 1 {
+		t.Fatalf("finalizers = %v, want only the other controller's", flow.GetFinalizers())
+	}
+	if h.prov.deprovisioned != 2 || h.prov.provisioned != 0 {
+		t.Fatalf("provisioned %d, deprovisioned %d", h.prov.provisioned, h.prov.deprovisioned)
+	}
+}
+
+func TestFinalizeSkipCleanup(t *testing.T) {
+	f := deleting(newFlow("orders"))
+	f.SetAnnotations(map[string]string{skipCleanupAnnotation: "true"})
+	h := newHarness(t, f)
+	h.prov.err = errors.New("never called")
+	if err := h.reconcile("orders"); err != nil {
+		t.Fatal(err)
+	}
+	if hasFinalizer(h.get("orders")) || h.prov.deprovisioned != 0 {
+		t.Fatalf("finalizers %v, deprovisioned %d", h.get("orders").GetFinalizers(), h.prov.deprovisioned)
+	}
+}
+
+func TestReconcileGone(t *testing.T) {
+	h := newHarness(t)
+	if err := h.reconcile("orders"); err != nil {
+		t.Fatal(err)
+	}
+	if h.prov.provisioned+h.prov.deprovisioned != 0 {
+		t.Fatal("a flow no longer in the cache was provisioned")
+	}
+}
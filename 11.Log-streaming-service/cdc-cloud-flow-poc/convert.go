@@ -0,0 +1,196 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Conversion webhook for FlowConfiguration, apiextensions.k8s.io/v1
+// ConversionReview. The review types are declared here rather than pulling
+// in k8s.io/apiextensions-apiserver for three structs.
+//
+// v1alpha1 spec: {source, destination, cpu, memory}
+// v1 spec:       {sources[], destinations[], resources{cpu, memory}}
+//
+// Going down to v1alpha1 keeps only the first source and destination, so the
+// full v1 spec is stashed in an annotation and restored on the way back up;
+// a v1 -> v1alpha1 -> v1 round trip is lossless.
+
+const v1SpecAnnotation = "example.com/v1-spec"
+
+type conversionReview struct {
+	APIVersion string              `json:"apiVersion"`
+	Kind       string              `json:"kind"`
+	Request    *conversionRequest  `json:"request,omitempty"`
+	Response   *conversionResponse `json:"response,omitempty"`
+}
+
+type conversionRequest struct {
+	UID               string                   `json:"uid"`
+	DesiredAPIVersion string                   `json:"desiredAPIVersion"`
+	Objects           []map[string]interface{} `json:"objects"`
+}
+
+type conversionResponse struct {
+	UID              string                   `json:"uid"`
+	ConvertedObjects []map[string]interface{} `json:"convertedObjects"`
+	Result           v1.Status                `json:"result"`
+}
+
+// convertHandler serves POST /convert.
+func convertHandler(w http.ResponseWriter, r *http.Request) {
+	var review conversionReview
+	if err := json.NewDecoder(r.Body).Decode(&review); err != nil || review.Request == nil {
+		http.Error(w, "expected a ConversionReview with a request", http.StatusBadRequest)
+		return
+	}
+	req := review.Request
+	resp := &conversionResponse{UID: req.UID, Result: v1.Status{Status: v1.StatusSuccess}}
+	for _, obj := range req.Objects {
+		out, err := convertFlow(obj, req.DesiredAPIVersion)
+		if err != nil {
+			resp.ConvertedObjects = nil
+			resp.Result = v1.Status{Status: v1.StatusFailure, Message: err.Error()}
+			log.Printf("[convert] %s: %v", req.UID, err)
+			break
+		}
+		resp.ConvertedObjects = append(resp.ConvertedObjects, out)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(conversionReview{APIVersion: review.APIVersion, Kind: review.Kind, Response: resp})
+}
+
+func convertFlow(obj map[string]interface{}, desired string) (map[string]interface{}, error) {
+	from, _ := obj["apiVersion"].(string)
+	if from == desired {
+		return obj, nil
+	}
+	out := map[string]interface{}{}
+	for k, v := range obj {
+		out[k] = v
+	}
+	spec, _ := obj["spec"].(map[string]interface{})
+	meta, _ := obj["metadata"].(map[string]interface{})
+	out["apiVersion"] = desired
+
+	switch {
+	case from == "example.com/v1alpha1" && desired == "example.com/v1":
+		next, meta := upFromAlpha(spec, meta)
+		out["spec"], out["metadata"] = next, meta
+	case from == "example.com/v1" && desired == "example.com/v1alpha1":
+		next, meta, err := downToAlpha(spec, meta)
+		if err != nil {
+			return nil, err
+		}
+		out["spec"], out["metadata"] = next, meta
+	default:
+		return nil, fmt.Errorf("cannot convert %s to %s", from, desired)
+	}
+	return out, nil
+}
+
+func upFromAlpha(spec, meta map[string]interface{}) (map[string]interface{}, map[string]interface{}) {
+	meta = copyMeta(meta)
+	annotations, _ := meta["annotations"].(map[string]interface{})
+	if stashed, ok := annotations[v1SpecAnnotation].(string); ok {
+		var full map[string]interface{}
+		if json.Unmarshal([]byte(stashed), &full) == nil {
+			delete(annotations, v1SpecAnnotation)
+			// the alpha fields win where they were edited since the stash
+			applyAlphaEdits(full, spec)
+			return full, meta
+		}
+	}
+	next := map[string]interface{}{}
+	if s, _ := spec["source"].(string); s != "" {
+		next["sources"] = []interface{}{s}
+	}
+	if d, _ := spec["destination"].(string); d != "" {
+		next["destinations"] = []interface{}{d}
+	}
+	res := map[string]interface{}{}
+	for _, k := range []string{"cpu", "memory"} {
+		if v, ok := spec[k]; ok {
+			res[k] = v
+		}
+	}
+	if len(res) > 0 {
+		next["resources"] = res
+	}
+	return next, meta
+}
+
+func downToAlpha(spec, meta map[string]interface{}) (map[string]interface{}, map[string]interface{}, error) {
+	meta = copyMeta(meta)
+	next := map[string]interface{}{}
+	if s, _ := spec["sources"].([]interface{}); len(s) > 0 {
+		next["source"] = s[0]
+	}
+	if d, _ := spec["destinations"].([]interface{}); len(d) > 0 {
+		next["destination"] = d[0]
+	}
+	if res, _ := spec["resources"].(map[string]interface{}); res != nil {
+		for _, k := range []string{"cpu", "memory"} {
+			if v, ok := res[k]; ok {
+				next[k] = v
+			}
+		}
+	}
+	stash, err := json.Marshal(spec)
+	if err != nil {
+		return nil, nil, err
+	}
+	annotations, _ := meta["annotations"].(map[string]interface{})
+	if annotations == nil {
+		annotations = map[string]interface{}{}
+	}
+	annotations[v1SpecAnnotation] = string(stash)
+	meta["annotations"] = annotations
+	return next, meta, nil
+}
+
+// applyAlphaEdits replaces the first source/destination and the resources
+// of a stashed v1 spec with the alpha values.
+func applyAlphaEdits(full, alpha map[string]interface{}) {
+	for alphaKey, key := range map[string]string{"source": "sources", "destination": "destinations"} {
+		v, ok := alpha[alphaKey]
+		if !ok {
+			continue
+		}
+		list, _ := full[key].([]interface{})
+		if len(list) == 0 {
+			full[key] = []interface{}{v}
+			continue
+		}
+		list[0] = v
+	}
+	res, _ := full["resources"].(map[string]interface{})
+	for _, k := range []string{"cpu", "memory"} {
+		if v, ok := alpha[k]; ok {
+			if res == nil {
+				res = map[string]interface{}{}
+				full["resources"] = res
+			}
+			res[k] = v
+		}
+	}
+}
+
+func copyMeta(meta map[string]interface{}) map[string]interface{} {
+	out := map[string]interface{}{}
+	for k, v := range meta {
+		out[k] = v
+	}
+	if a, ok := meta["annotations"].(map[string]interface{}); ok {
+		ac := map[string]interface{}{}
+		for k, v := range a {
+			ac[k] = v
+		}
+		out["annotations"] = ac
+	}
+	return out
+}
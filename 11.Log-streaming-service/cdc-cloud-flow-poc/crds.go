@@ -0,0 +1,272 @@
+package main
+
+import (
+	"context"
+	_ "embed"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/dynamic"
+)
+
+// The FlowConfiguration CRD ships inside the binary, so the manager can
+// install or upgrade it itself (-install-crds / INSTALL_CRDS=true) instead
+// of relying on someone having run create-crd.sh first. This needs RBAC on
+// customresourcedefinitions; see deployment.yaml.
+//
+// v1 is the storage version. v1alpha1 is served only when a conversion
+// webhook is configured, since the two schemas differ:
+//
+//	CONVERSION_WEBHOOK_SERVICE  namespace/name of the Service in front of
+//	                            this manager's TLS port (e.g. default/manager-service)
+//	CONVERSION_WEBHOOK_PORT     Service port (default 443)
+//	CONVERSION_CA_FILE          PEM CA that signed the serving certificate
+//
+// Without it the CRD is installed with v1alpha1 not served.
+
+//go:embed fln_crd.yaml
+var flowCRDManifest []byte
+
+const (
+	flowCRDName  = "flowconfigurations.example.com"
+	fieldManager = "cdc-cloud-flow-poc"
+)
+
+var (
+	crdGVR  = schema.GroupVersionResource{Group: "apiextensions.k8s.io", Version: "v1", Resource: "customresourcedefinitions"}
+	flowGVR = schema.GroupVersionResource{Group: "example.com", Version: "v1", Resource: "flowconfigurations"}
+)
+
+type crdConfig struct {
+	WebhookNamespace string
+	WebhookService   string
+	WebhookPort      int64
+	CABundle         []byte
+}
+
+func crdConfigFromEnv() (crdConfig, error) {
+	cfg := crdConfig{WebhookPort: 443}
+	svc := os.Getenv("CONVERSION_WEBHOOK_SERVICE")
+	if svc == "" {
+		return cfg, nil
+	}
+	ns, name, ok := strings.Cut(svc, "/")
+	if !ok {
+		return cfg, fmt.Errorf("CONVERSION_WEBHOOK_SERVICE=%q: want namespace/name", svc)
+	}
+	cfg.WebhookNamespace, cfg.WebhookService = ns, name
+	if v := os.Getenv("CONVERSION_WEBHOOK_PORT"); v != "" {
+		p, err := strconv.ParseInt(v, 10, 32)
+		if err != nil {
+			return cfg, fmt.Errorf("CONVERSION_WEBHOOK_PORT: %w", err)
+		}
+		cfg.WebhookPort = p
+	}
+	ca, err := os.ReadFile(os.Getenv("CONVERSION_CA_FILE"))
+	if err != nil {
+		return cfg, fmt.Errorf("CONVERSION_CA_FILE: %w", err)
+	}
+	cfg.CABundle = ca
+	return cfg, nil
+}
+
+// flowCRD renders the embedded manifest for cfg.
+func flowCRD(cfg crdConfig) (*unstructured.Unstructured, error) {
+	js, err := yaml.ToJSON(flowCRDManifest)
+	if err != nil {
+		return nil, fmt.Errorf("embedded crd: %w", err)
+	}
+	crd := &unstructured.Unstructured{}
+	if err := crd.UnmarshalJSON(js); err != nil {
+		return nil, fmt.Errorf("embedded crd: %w", err)
+	}
+
+	if cfg.WebhookService == "" {
+		versions, _, _ := unstructured.NestedSlice(crd.Object, "spec", "versions")
+		for _, v := range versions {
+			if v := v.(map[string]interface{}); v["name"] == "v1alpha1" {
+				v["served"] = false
+			}
+		}
+		if err := unstructured.SetNestedSlice(crd.Object, versions, "spec", "versions"); err != nil {
+			return nil, err
+		}
+		return crd, nil
+	}
+
+	conversion := map[string]interface{}{
+		"strategy": "Webhook",
+		"webhook": map[string]interface{}{
+			"conversionReviewVersions": []interface{}{"v1"},
+			"clientConfig": map[string]interface{}{
+				"caBundle": base64.StdEncoding.EncodeToString(cfg.CABundle),
+				"service": map[string]interface{}{
+					"namespace": cfg.WebhookNamespace,
+					"name":      cfg.WebhookService,
+					"path":      "/convert",
+					"port":      cfg.WebhookPort,
+				},
+			},
+		},
+	}
+	if err := unstructured.SetNestedMap(crd.Object, conversion, "spec", "conversion"); err != nil {
+		return nil, err
+	}
+	return crd, nil
+}
+
+// installCRDs creates or updates the CRD with server-side apply, so fields
+// owned by other managers (or by a previous manual kubectl apply) are left
+// alone unless they conflict with ours.
+func installCRDs(ctx context.Context, client dynamic.Interface, cfg crdConfig) error {
+	crd, err := flowCRD(cfg)
+	if err != nil {
+		return err
+	}
+	data, err := crd.MarshalJSON()
+	if err != nil {
+		return err
+	}
+	force := true
+	_, err = client.Resource(crdGVR).Patch(ctx, flowCRDName, types.ApplyPatchType, data,
+		v1.PatchOptions{FieldManager: fieldManager, Force: &force})
+	if err != nil {
+		return fmt.Errorf("apply crd %s: %w", flowCRDName, err)
+	}
+	log.Printf("[crd] applied %s (conversion: %s)", flowCRDName, conversionStrategy(cfg))
+	return nil
+}
+
+func conversionStrategy(cfg crdConfig) string {
+	if cfg.WebhookService == "" {
+		return "None, v1alpha1 not served"
+	}
+	return "Webhook " + cfg.WebhookNamespace + "/" + cfg.WebhookService
+}
+
+// CRDStatus is what GET /crd/status reports.
+type CRDStatus struct {
+	Name           string    `json:"name"`
+	Installed      bool      `json:"installed"`
+	Established    bool      `json:"established"`
+	NamesAccepted  bool      `json:"names_accepted"`
+	Ready          bool      `json:"ready"`
+	ServedVersions []string  `json:"served_versions,omitempty"`
+	StoredVersions []string  `json:"stored_versions,omitempty"`
+	Conversion     string    `json:"conversion,omitempty"`
+	Message        string    `json:"message,omitempty"`
+	CheckedAt      time.Time `json:"checked_at"`
+}
+
+func checkCRD(ctx context.Context, client dynamic.Interface) CRDStatus {
+	st := CRDStatus{Name: flowCRDName, CheckedAt: time.Now().UTC()}
+	crd, err := client.Resource(crdGVR).Get(ctx, flowCRDName, v1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		st.Message = "not installed; start with -install-crds or apply fln_crd.yaml"
+		return st
+	}
+	if err != nil {
+		st.Message = err.Error()
+		return st
+	}
+	st.Installed = true
+	conditions, _, _ := unstructured.NestedSlice(crd.Object, "status", "conditions")
+	for _, c := range conditions {
+		c, _ := c.(map[string]interface{})
+		ok := c["status"] == "True"
+		switch c["type"] {
+		case "Established":
+			st.Established = ok
+		case "NamesAccepted":
+			st.NamesAccepted = ok
+			if !ok {
+				st.Message, _ = c["message"].(string)
+			}
+		}
+	}
+	versions, _, _ := unstructured.NestedSlice(crd.Object, "spec", "versions")
+	for _, v := range versions {
+		v, _ := v.(map[string]interface{})
+		if served, _ := v["served"].(bool); served {
+			st.ServedVersions = append(st.ServedVersions, fmt.Sprint(v["name"]))
+		}
+	}
+	st.StoredVersions, _, _ = unstructured.NestedStringSlice(crd.Object, "status", "storedVersions")
+	st.Conversion, _, _ = unstructured.NestedString(crd.Object, "spec", "conversion", "strategy")
+	st.Ready = st.Established && st.NamesAccepted
+	return st
+}
+
+// waitCRDReady polls until the CRD is established or timeout passes.
+func waitCRDReady(ctx context.Context, client dynamic.Interface, timeout time.Duration) (CRDStatus, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	for {
+		st := checkCRD(ctx, client)
+		if st.Ready {
+			return st, nil
+		}
+		select {
+		case <-ctx.Done():
+			return st, fmt.Errorf("crd %s not ready after %s: %s", flowCRDName, timeout, st.Message)
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// migrateStorage rewrites every FlowConfiguration so etcd holds it in the
+// storage version (v1), then drops older entries from the CRD's
+// status.storedVersions. Only after that can v1alpha1 be removed from the
+// CRD. Each object is re-read and written back unchanged: the API server
+// re-encodes it on the way in.
+func migrateStorage(ctx context.Context, client dynamic.Interface) (int, error) {
+	list, err := client.Resource(flowGVR).Namespace(v1.NamespaceAll).List(ctx, v1.ListOptions{})
+	if err != nil {
+		return 0, fmt.Errorf("list flowconfigurations: %w", err)
+	}
+	n := 0
+	for i := range list.Items {
+		item := &list.Items[i]
+		_, err := client.Resource(flowGVR).Namespace(item.GetNamespace()).Update(ctx, item, v1.UpdateOptions{FieldManager: fieldManager})
+		switch {
+		case apierrors.IsNotFound(err):
+			continue // deleted meanwhile
+		case apierrors.IsConflict(err):
+			continue // someone else wrote it, which also re-encoded it
+		case err != nil:
+			return n, fmt.Errorf("rewrite %s/%s: %w", item.GetNamespace(), item.GetName(), err)
+		}
+		n++
+	}
+
+	patch := []byte(`{"status":{"storedVersions":["v1"]}}`)
+	_, err = client.Resource(crdGVR).Patch(ctx, flowCRDName, types.MergePatchType, patch, v1.PatchOptions{}, "status")
+	if err != nil {
+		return n, fmt.Errorf("update storedVersions: %w", err)
+	}
+	return n, nil
+}
+
+// crdStatusHandler serves GET /crd/status, 503 until the CRD is ready, so it
+// doubles as a readiness probe.
+func crdStatusHandler(w http.ResponseWriter, r *http.Request) {
+	st := checkCRD(r.Context(), dynamicClient)
+	w.Header().Set("Content-Type", "application/json")
+	if !st.Ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(st)
+}
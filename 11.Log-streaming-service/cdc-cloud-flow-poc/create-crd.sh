@@ -1 +1,3 @@
-kubectl apply -f flowconfiguration-crd.yaml
\ No newline at end of file
+# Optional: the manager applies the embedded CRD itself when started with
+# -install-crds (INSTALL_CRDS=true). This installs it by hand, v1 only.
+kubectl apply -f fln_crd.yaml
\ No newline at end of file
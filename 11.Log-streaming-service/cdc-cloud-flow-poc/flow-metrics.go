@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// Flow metrics are read from Prometheus. The flow's pods are expected to
+// carry a label (FLOW_LABEL, default "flow") whose value is the flow name and
+// which the Prometheus scrape config keeps as a series label.
+type promConfig struct {
+	URL           string
+	Label         string
+	Window        string
+	RecordsMetric string
+	ErrorsMetric  string
+	Client        *http.Client
+}
+
+var prom = promConfig{
+	URL:           os.Getenv("PROMETHEUS_URL"),
+	Label:         envOr("FLOW_LABEL", "flow"),
+	Window:        envOr("FLOW_METRICS_WINDOW", "5m"),
+	RecordsMetric: envOr("FLOW_RECORDS_METRIC", "flow_records_total"),
+	ErrorsMetric:  envOr("FLOW_ERRORS_METRIC", "flow_errors_total"),
+	Client:        &http.Client{Timeout: 10 * time.Second},
+}
+
+// flow names are Kubernetes object names, which also keeps them safe to
+// splice into PromQL
+var flowNameRE = regexp.MustCompile(`^[a-z0-9]([-a-z0-9.]{0,251}[a-z0-9])?$`)
+
+type FlowMetrics struct {
+	Flow           string             `json:"flow"`
+	Window         string             `json:"window"`
+	PodsUp         int                `json:"pods_up"`
+	ThroughputRPS  float64            `json:"throughput_rps"`
+	ErrorsPerSec   float64            `json:"errors_per_sec"`
+	ErrorRate      float64            `json:"error_rate"`
+	PerPod         map[string]float64 `json:"per_pod_throughput_rps,omitempty"`
+	Throughput     [][2]float64       `json:"throughput_series,omitempty"`
+	ErrorRateTrend [][2]float64       `json:"error_rate_series,omitempty"`
+}
+
+// getFlowMetrics serves GET /flows/{name}/metrics[?range=1h&step=1m]. With
+// range the response also carries [unix_ts, value] series for throughput and
+// error rate.
+func getFlowMetrics(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) != 3 || parts[0] != "flows" || parts[2] != "metrics" {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	name := parts[1]
+	if !flowNameRE.MatchString(name) {
+		http.Error(w, "Invalid flow name", http.StatusBadRequest)
+		return
+	}
+	if prom.URL == "" {
+		http.Error(w, "PROMETHEUS_URL is not configured", http.StatusServiceUnavailable)
+		return
+	}
+
+	gvr := schema.GroupVersionResource{
+		Group:    "example.com",
+		Version:  "v1",
+		Resource: "flowconfigurations",
+	}
+	if _, err := dynamicClient.Resource(gvr).Namespace(namespace).Get(r.Context(), name, v1.GetOptions{}); err != nil {
+		if apierrors.IsNotFound(err) {
+			http.Error(w, "Flow not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	sel := fmt.Sprintf(`{namespace=%q,%s=%q}`, namespace, prom.Label, name)
+	records := fmt.Sprintf("sum(rate(%s%s[%s]))", prom.RecordsMetric, sel, prom.Window)
+	errs := fmt.Sprintf("sum(rate(%s%s[%s]))", prom.ErrorsMetric, sel, prom.Window)
+	errRate := fmt.Sprintf("(%s) / clamp_min(%s, 1e-9)", errs, records)
+
+	out := FlowMetrics{Flow: name, Window: prom.Window}
+	ctx := r.Context()
+	var err error
+	var up float64
+	if up, err = promScalar(ctx, fmt.Sprintf("sum(up%s)", sel)); err == nil {
+		out.PodsUp = int(up)
+		out.ThroughputRPS, err = promScalar(ctx, records)
+	}
+	if err == nil {
+		out.ErrorsPerSec, err = promScalar(ctx, errs)
+	}
+	if err == nil && out.ThroughputRPS > 0 {
+		out.ErrorRate = out.ErrorsPerSec / out.ThroughputRPS
+	}
+	if err == nil {
+		out.PerPod, err = promByLabel(ctx, fmt.Sprintf("sum by (pod) (rate(%s%s[%s]))", prom.RecordsMetric, sel, prom.Window), "pod")
+	}
+	if rng := r.URL.Query().Get("range"); err == nil && rng != "" {
+		var span, step time.Duration
+		if span, err = time.ParseDuration(rng); err != nil || span <= 0 || span > 7*24*time.Hour {
+			http.Error(w, "Invalid range", http.StatusBadRequest)
+			return
+		}
+		step = span / 60
+		if v := r.URL.Query().Get("step"); v != "" {
+			if step, err = time.ParseDuration(v); err != nil || step <= 0 || span/step > 1000 {
+				http.Error(w, "Invalid step", http.StatusBadRequest)
+				return
+			}
+		}
+		if out.Throughput, err = promRange(ctx, records, span, step); err == nil {
+			out.ErrorRateTrend, err = promRange(ctx, errRate, span, step)
+		}
+	}
+	if err != nil {
+		http.Error(w, "Prometheus query failed: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+}
+
+// promResponse is the subset of the Prometheus HTTP API response we read.
+type promResponse struct {
+	Status string `json:"status"`
+	Error  string `json:"error"`
+	Data   struct {
+		ResultType string `json:"resultType"`
+		Result     []struct {
+			Metric map[string]string `json:"metric"`
+			Value  [2]interface{}    `json:"value"`
+			Values [][2]interface{}  `json:"values"`
+		} `json:"result"`
+	} `json:"data"`
+}
+
+func promGet(ctx context.Context, path string, params url.Values) (*promResponse, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimRight(prom.URL, "/")+path+"?"+params.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := prom.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var pr promResponse
+	if err := json.NewDecoder(resp.Body).Decode(&pr); err != nil {
+		return nil, fmt.Errorf("decode response (HTTP %d): %w", resp.StatusCode, err)
+	}
+	if pr.Status != "success" {
+		return nil, fmt.Errorf("%s", pr.Error)
+	}
+	return &pr, nil
+}
+
+// promScalar runs an instant query expected to return at most one sample;
+// no sample (no matching pods yet) reads as 0.
+func promScalar(ctx context.Context, query string) (float64, error) {
+	pr, err := promGet(ctx, "/api/v1/query", url.Values{"query": {query}})
+	if err != nil || len(pr.Data.Result) == 0 {
+		return 0, err
+	}
+	return sampleValue(pr.Data.Result[0].Value)
+}
+
+func promByLabel(ctx context.Context, query, label string) (map[string]float64, error) {
+	pr, err := promGet(ctx, "/api/v1/query", url.Values{"query": {query}})
+	if err != nil {
+		return nil, err
+	}
+	out := map[string]float64{}
+	for _, res := range pr.Data.Result {
+		v, err := sampleValue(res.Value)
+		if err != nil {
+			return nil, err
+		}
+		out[res.Metric[label]] = v
+	}
+	return out, nil
+}
+
+func promRange(ctx context.Context, query string, span, step time.Duration) ([][2]float64, error) {
+	end := time.Now()
+	pr, err := promGet(ctx, "/api/v1/query_range", url.Values{
+		"query": {query},
+		"start": {strconv.FormatInt(end.Add(-span).Unix(), 10)},
+		"end":   {strconv.FormatInt(end.Unix(), 10)},
+		"step":  {strconv.FormatFloat(step.Seconds(), 'f', -1, 64)},
+	})
+	if err != nil || len(pr.Data.Result) == 0 {
+		return nil, err
+	}
+	series := make([][2]float64, 0, len(pr.Data.Result[0].Values))
+	for _, s := range pr.Data.Result[0].Values {
+		v, err := sampleValue(s)
+		if err != nil {
+			return nil, err
+		}
+		ts, _ := s[0].(float64)
+		series = append(series, [2]float64{ts, v})
+	}
+	return series, nil
+}
+
+// sampleValue decodes a [timestamp, "value"] pair; Prometheus sends values
+// as strings.
+func sampleValue(s [2]interface{}) (float64, error) {
+	str, ok := s[1].(string)
+	if !ok {
+		return 0, fmt.Errorf("unexpected sample %v", s)
+	}
+	return strconv.ParseFloat(str, 64)
+}
+
+func envOr(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
@@ -0,0 +1,521 @@
+// Command openapigen writes a typed Go client for an OpenAPI 3 spec: one
+// type per component schema and one method per operation. It covers what
+// openapi.yaml uses, not all of OpenAPI:
+//
+//   - schemas: objects with properties or additionalProperties, arrays
+//     (fixed-size when minItems == maxItems), strings (date-time is a
+//     time.Time), integers, numbers, booleans and $refs to other schemas
+//   - parameters: in path or query; required ones become arguments, the
+//     others fields of an <Operation>Params struct
+//   - an application/json request body, and the application/json schema
+//     of the first 2xx response as the result
+//
+// An optional property is a pointer unless it is a string (not a
+// date-time), a slice or a map, so a zero value can be told from a
+// missing one. The methods call
+// Client.do, which the package provides itself (see client/client.go).
+//
+//	go run ./internal/openapigen -spec openapi.yaml -pkg client -o client/api.gen.go
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/format"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode"
+
+	"gopkg.in/yaml.v3"
+)
+
+type schema struct {
+	Ref                  string    `yaml:"$ref"`
+	Type                 string    `yaml:"type"`
+	Format               string    `yaml:"format"`
+	Description          string    `yaml:"description"`
+	Required             []string  `yaml:"required"`
+	Properties           yaml.Node `yaml:"properties"` // a mapping, kept in order
+	Items                *schema   `yaml:"items"`
+	AdditionalProperties *schema   `yaml:"additionalProperties"`
+	Enum                 []string  `yaml:"enum"`
+	MinItems             *int      `yaml:"minItems"`
+	MaxItems             *int      `yaml:"maxItems"`
+	ReadOnly             bool      `yaml:"readOnly"`
+}
+
+type media struct {
+	Schema *schema `yaml:"schema"`
+}
+
+type response struct {
+	Ref         string           `yaml:"$ref"`
+	Description string           `yaml:"description"`
+	Content     map[string]media `yaml:"content"`
+}
+
+type parameter struct {
+	Name        string  `yaml:"name"`
+	In          string  `yaml:"in"`
+	Required    bool    `yaml:"required"`
+	Description string  `yaml:"description"`
+	Schema      *schema `yaml:"schema"`
+}
+
+type operation struct {
+	OperationID string      `yaml:"operationId"`
+	Summary     string      `yaml:"summary"`
+	Description string      `yaml:"description"`
+	Parameters  []parameter `yaml:"parameters"`
+	RequestBody *struct {
+		Required bool             `yaml:"required"`
+		Content  map[string]media `yaml:"content"`
+	} `yaml:"requestBody"`
+	Responses yaml.Node `yaml:"responses"` // status -> response, in order
+}
+
+type document struct {
+	Paths      yaml.Node `yaml:"paths"` // path -> method -> operation
+	Components struct {
+		Schemas   yaml.Node           `yaml:"schemas"`
+		Responses map[string]response `yaml:"responses"`
+	} `yaml:"components"`
+}
+
+// pairs returns the keys and values of mapping n in order.
+func pairs(n *yaml.Node) (keys []string, values []*yaml.Node) {
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		keys = append(keys, n.Content[i].Value)
+		values = append(values, n.Content[i+1])
+	}
+	return keys, values
+}
+
+type generator struct {
+	doc     document
+	schemas map[string]*schema
+	buf     bytes.Buffer
+	imports map[string]bool
+}
+
+func main() {
+	specPath := flag.String("spec", "openapi.yaml", "OpenAPI 3 document")
+	pkg := flag.String("pkg", "client", "package name")
+	out := flag.String("o", "api.gen.go", "file to write")
+	flag.Parse()
+
+	b, err := os.ReadFile(*specPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	g := &generator{schemas: map[string]*schema{}, imports: map[string]bool{"context": true}}
+	if err := yaml.Unmarshal(b, &g.doc); err != nil {
+		log.Fatalf("%s: %v", *specPath, err)
+	}
+	names, nodes := pairs(&g.doc.Components.Schemas)
+	for i, name := range names {
+		var s schema
+		if err := nodes[i].Decode(&s); err != nil {
+			log.Fatalf("schema %s: %v", name, err)
+		}
+		g.schemas[name] = &s
+	}
+
+	for _, name := range names {
+		g.typeDecl(name, g.schemas[name])
+	}
+	paths, pathNodes := pairs(&g.doc.Paths)
+	for i, path := range paths {
+		methods, opNodes := pairs(pathNodes[i])
+		for j, method := range methods {
+			var op operation
+			if err := opNodes[j].Decode(&op); err != nil {
+				log.Fatalf("%s %s: %v", method, path, err)
+			}
+			if err := g.method(strings.ToUpper(method), path, &op); err != nil {
+				log.Fatalf("%s %s: %v", method, path, err)
+			}
+		}
+	}
+
+	var src bytes.Buffer
+	fmt.Fprintf(&src, "// Code generated by openapigen from %s; DO NOT EDIT.\n\n", filepath.Base(*specPath))
+	fmt.Fprintf(&src, "package %s\n\n", *pkg)
+	imports := make([]string, 0, len(g.imports))
+	for imp := range g.imports {
+		imports = append(imports, imp)
+	}
+	sort.Strings(imports)
+	src.WriteString("import (\n")
+	for _, imp := range imports {
+		fmt.Fprintf(&src, "\t%q\n", imp)
+	}
+	src.WriteString(")\n")
+	src.Write(g.buf.Bytes())
+	formatted, err := format.Source(src.Bytes())
+	if err != nil {
+		log.Fatalf("generated code does not parse: %v\n%s", err, src.Bytes())
+	}
+	if err := os.WriteFile(*out, formatted, 0o644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func (g *generator) printf(format string, args ...any) { fmt.Fprintf(&g.buf, format, args...) }
+
+// comment writes text as a doc comment, starting with prefix.
+func (g *generator) comment(indent, prefix, text string) {
+	text = strings.TrimSpace(prefix + text)
+	if text == "" {
+		return
+	}
+	for _, line := range wrap(text, 72) {
+		g.printf("%s// %s\n", indent, line)
+	}
+}
+
+func wrap(text string, width int) []string {
+	var lines []string
+	for _, para := range strings.Split(strings.TrimSpace(text), "\n\n") {
+		if len(lines) > 0 {
+			lines = append(lines, "")
+		}
+		line := ""
+		for _, w := range strings.Fields(para) {
+			if line != "" && len(line)+1+len(w) > width {
+				lines = append(lines, line)
+				line = ""
+			}
+			if line != "" {
+				line += " "
+			}
+			line += w
+		}
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+func (g *generator) typeDecl(name string, s *schema) {
+	g.printf("\n")
+	g.comment("", fmt.Sprintf("%s is the %s schema of the API. ", name, name), s.Description)
+	if s.Type != "object" || s.AdditionalProperties != nil {
+		g.printf("type %s %s\n", name, g.goType(s))
+		return
+	}
+	g.printf("type %s struct {\n", name)
+	g.fields(s)
+	g.printf("}\n")
+}
+
+func (g *generator) fields(s *schema) {
+	required := map[string]bool{}
+	for _, r := range s.Required {
+		required[r] = true
+	}
+	props, nodes := pairs(&s.Properties)
+	for i, prop := range props {
+		var p schema
+		if err := nodes[i].Decode(&p); err != nil {
+			log.Fatalf("property %s: %v", prop, err)
+		}
+		doc := p.Description
+		if len(p.Enum) > 0 {
+			doc = strings.TrimSpace(doc + " One of " + strings.Join(p.Enum, ", ") + ".")
+		}
+		if p.ReadOnly {
+			doc = strings.TrimSpace(doc + " Set by the server.")
+		}
+		g.comment("\t", "", doc)
+		typ, tag := g.goType(&p), prop
+		if !required[prop] {
+			tag += ",omitempty"
+			if g.pointer(&p) {
+				typ = "*" + typ
+			}
+		}
+		g.printf("\t%s %s `json:%q`\n", goName(prop), typ, tag)
+	}
+}
+
+// pointer reports whether an optional property of schema s is a pointer.
+func (g *generator) pointer(s *schema) bool {
+	if s.Ref != "" {
+		t := g.resolve(s)
+		return t.Type == "object" && t.AdditionalProperties == nil
+	}
+	switch s.Type {
+	case "string":
+		return s.Format == "date-time" // omitempty does not leave out a struct
+	case "array":
+		return false
+	case "object":
+		return s.AdditionalProperties == nil
+	}
+	return true
+}
+
+func (g *generator) resolve(s *schema) *schema {
+	name := strings.TrimPrefix(s.Ref, "#/components/schemas/")
+	t, ok := g.schemas[name]
+	if !ok {
+		log.Fatalf("unknown $ref %s", s.Ref)
+	}
+	return t
+}
+
+func (g *generator) goType(s *schema) string {
+	if s.Ref != "" {
+		g.resolve(s)
+		return strings.TrimPrefix(s.Ref, "#/components/schemas/")
+	}
+	switch s.Type {
+	case "string":
+		if s.Format == "date-time" {
+			g.imports["time"] = true
+			return "time.Time"
+		}
+		return "string"
+	case "integer":
+		switch s.Format {
+		case "int32", "int64":
+			return s.Format
+		}
+		return "int"
+	case "number":
+		if s.Format == "float" {
+			return "float32"
+		}
+		return "float64"
+	case "boolean":
+		return "bool"
+	case "array":
+		if s.Items == nil {
+			log.Fatal("array without items")
+		}
+		if s.MinItems != nil && s.MaxItems != nil && *s.MinItems == *s.MaxItems {
+			return fmt.Sprintf("[%d]%s", *s.MinItems, g.goType(s.Items))
+		}
+		return "[]" + g.goType(s.Items)
+	case "object":
+		if s.AdditionalProperties != nil {
+			return "map[string]" + g.goType(s.AdditionalProperties)
+		}
+		if len(s.Properties.Content) == 0 {
+			return "map[string]any"
+		}
+		log.Fatal("inline object schemas are not supported; move it to components")
+	}
+	return "any"
+}
+
+// jsonResult returns the type name of the application/json body of r.
+func (g *generator) jsonResult(r response) string {
+	if r.Ref != "" {
+		name := strings.TrimPrefix(r.Ref, "#/components/responses/")
+		shared, ok := g.doc.Components.Responses[name]
+		if !ok {
+			log.Fatalf("unknown response %s", r.Ref)
+		}
+		r = shared
+	}
+	if m, ok := r.Content["application/json"]; ok && m.Schema != nil {
+		return g.goType(m.Schema)
+	}
+	return ""
+}
+
+func (g *generator) method(httpMethod, path string, op *operation) error {
+	if op.OperationID == "" {
+		return fmt.Errorf("no operationId")
+	}
+	name := goName(op.OperationID)
+
+	var args, optional []parameter
+	for _, p := range op.Parameters {
+		switch {
+		case p.In == "path" || p.Required:
+			args = append(args, p)
+		case p.In == "query":
+			optional = append(optional, p)
+		default:
+			return fmt.Errorf("parameter %s in %s is not supported", p.Name, p.In)
+		}
+	}
+	if len(optional) > 0 {
+		g.printf("\n// %sParams are the optional parameters of %s.\n", name, name)
+		g.printf("type %sParams struct {\n", name)
+		for _, p := range optional {
+			g.comment("\t", "", p.Description)
+			g.printf("\t%s %s\n", goName(p.Name), g.goType(p.Schema))
+		}
+		g.printf("}\n")
+	}
+
+	sig := []string{"ctx context.Context"}
+	for _, p := range args {
+		sig = append(sig, lowerFirst(goName(p.Name))+" "+g.goType(p.Schema))
+	}
+	if len(optional) > 0 {
+		sig = append(sig, "params *"+name+"Params")
+	}
+	body := "nil"
+	if op.RequestBody != nil {
+		m, ok := op.RequestBody.Content["application/json"]
+		if !ok || m.Schema == nil {
+			return fmt.Errorf("only application/json request bodies are supported")
+		}
+		sig = append(sig, "body *"+g.goType(m.Schema))
+		body = "body"
+	}
+
+	result := ""
+	codes, nodes := pairs(&op.Responses)
+	for i, code := range codes {
+		if strings.HasPrefix(code, "2") {
+			var r response
+			if err := nodes[i].Decode(&r); err != nil {
+				return err
+			}
+			result = g.jsonResult(r)
+			break
+		}
+	}
+
+	g.printf("\n")
+	g.comment("", fmt.Sprintf("%s calls %s %s: ", name, httpMethod, path), strings.TrimSuffix(op.Summary, ".")+".")
+	if op.Description != "" {
+		g.printf("//\n")
+		g.comment("", "", op.Description)
+	}
+	if result != "" {
+		g.printf("func (c *Client) %s(%s) (*%s, error) {\n", name, strings.Join(sig, ", "), result)
+	} else {
+		g.printf("func (c *Client) %s(%s) error {\n", name, strings.Join(sig, ", "))
+	}
+
+	// the path, with its parameters escaped in
+	expr := strconvQuote(path)
+	for _, p := range args {
+		if p.In == "path" {
+			g.imports["net/url"] = true
+			expr = strings.Replace(expr, "{"+p.Name+"}", `" + url.PathEscape(`+g.stringOf(lowerFirst(goName(p.Name)), p.Schema)+`) + "`, 1)
+		}
+	}
+	expr = strings.ReplaceAll(expr, ` + ""`, "")
+
+	query := "nil"
+	var queryArgs []parameter
+	for _, p := range args {
+		if p.In == "query" {
+			queryArgs = append(queryArgs, p)
+		}
+	}
+	if len(queryArgs) > 0 || len(optional) > 0 {
+		g.imports["net/url"] = true
+		query = "q"
+		g.printf("\tq := url.Values{}\n")
+		for _, p := range queryArgs {
+			g.printf("\tq.Set(%q, %s)\n", p.Name, g.stringOf(lowerFirst(goName(p.Name)), p.Schema))
+		}
+		if len(optional) > 0 {
+			g.printf("\tif params != nil {\n")
+			for _, p := range optional {
+				field := "params." + goName(p.Name)
+				g.printf("\t\tif %s != %s {\n", field, zero(g.goType(p.Schema)))
+				g.printf("\t\t\tq.Set(%q, %s)\n", p.Name, g.stringOf(field, p.Schema))
+				g.printf("\t\t}\n")
+			}
+			g.printf("\t}\n")
+		}
+	}
+
+	if result != "" {
+		g.printf("\tvar out %s\n", result)
+		g.printf("\tif err := c.do(ctx, %q, %s, %s, %s, &out); err != nil {\n", httpMethod, expr, query, body)
+		g.printf("\t\treturn nil, err\n\t}\n\treturn &out, nil\n}\n")
+	} else {
+		g.printf("\treturn c.do(ctx, %q, %s, %s, %s, nil)\n}\n", httpMethod, expr, query, body)
+	}
+	return nil
+}
+
+// stringOf is the expression formatting v, of schema s, for a URL.
+func (g *generator) stringOf(v string, s *schema) string {
+	if s.Type == "string" && s.Format == "" {
+		return v
+	}
+	g.imports["fmt"] = true
+	return "fmt.Sprint(" + v + ")"
+}
+
+func zero(typ string) string {
+	switch typ {
+	case "string":
+		return `""`
+	case "bool":
+		return "false"
+	case "int", "int32", "int64", "float32", "float64":
+		return "0"
+	}
+	return "nil"
+}
+
+func strconvQuote(s string) string { return fmt.Sprintf("%q", s) }
+
+// initialisms are kept in upper case in Go names, as golint wants them.
+var initialisms = map[string]bool{
+	"API": true, "CPU": true, "CRD": true, "HTTP": true, "ID": true, "JSON": true,
+	"RPS": true, "UID": true, "URL": true,
+}
+
+// goName turns a JSON or operation name (createFlow, pods_up,
+// apiVersion, getCRDStatus) into an exported Go name.
+func goName(s string) string {
+	var words []string
+	for _, part := range strings.FieldsFunc(s, func(r rune) bool { return r == '_' || r == '-' || r == '.' }) {
+		// split before an upper case letter that follows a lower case one
+		start := 0
+		rs := []rune(part)
+		for i := 1; i < len(rs); i++ {
+			if unicode.IsUpper(rs[i]) && unicode.IsLower(rs[i-1]) {
+				words = append(words, string(rs[start:i]))
+				start = i
+			}
+		}
+		words = append(words, string(rs[start:]))
+	}
+	var b strings.Builder
+	for _, w := range words {
+		if up := strings.ToUpper(w); initialisms[up] {
+			b.WriteString(up)
+			continue
+		}
+		rs := []rune(w)
+		b.WriteRune(unicode.ToUpper(rs[0]))
+		b.WriteString(string(rs[1:]))
+	}
+	return b.String()
+}
+
+func lowerFirst(s string) string {
+	rs := []rune(s)
+	// an initialism goes to lower case as a whole: ID -> id
+	i := 0
+	for i < len(rs) && unicode.IsUpper(rs[i]) {
+		i++
+	}
+	if i > 1 && i < len(rs) {
+		i-- // URLPath -> urlPath
+	}
+	if i == 0 {
+		i = 1
+	}
+	return strings.ToLower(string(rs[:i])) + string(rs[i:])
+}
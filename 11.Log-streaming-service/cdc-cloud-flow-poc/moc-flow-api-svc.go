@@ -3,11 +3,16 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"flag"
 	"log"
 	"net/http"
 	"os"
+	"time"
 
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/rest"
@@ -29,6 +34,22 @@ type FlowConfiguration struct {
 	} `json:"spec"`
 }
 
+// unstructured is fc as the dynamic client takes it. apiVersion and kind
+// may be left out of the request body.
+func (fc *FlowConfiguration) unstructured() (*unstructured.Unstructured, error) {
+	if fc.APIVersion == "" {
+		fc.APIVersion = "example.com/v1"
+	}
+	if fc.Kind == "" {
+		fc.Kind = "FlowConfiguration"
+	}
+	m, err := runtime.DefaultUnstructuredConverter.ToUnstructured(fc)
+	if err != nil {
+		return nil, err
+	}
+	return &unstructured.Unstructured{Object: m}, nil
+}
+
 var dynamicClient dynamic.Interface
 var namespace = "default"
 
@@ -55,6 +76,11 @@ func createFlowConfiguration(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	release, ok := checkQuota(w, r, fc.Name, fc.Spec.Resources.CPU, fc.Spec.Resources.Memory)
+	if !ok {
+		return
+	}
+	defer release()
 
 	gvr := schema.GroupVersionResource{
 		Group:    "example.com",
@@ -62,9 +88,14 @@ func createFlowConfiguration(w http.ResponseWriter, r *http.Request) {
 		Resource: "flowconfigurations",
 	}
 
+	obj, err := fc.unstructured()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 	unstructuredFC, err := dynamicClient.Resource(gvr).Namespace(namespace).Create(
 		context.TODO(),
-		&fc,
+		obj,
 		v1.CreateOptions{},
 	)
 	if err != nil {
@@ -83,6 +114,12 @@ func updateFlowConfiguration(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	// scaling a flow replaces its resources, so it is not counted twice
+	release, ok := checkQuota(w, r, fc.Name, fc.Spec.Resources.CPU, fc.Spec.Resources.Memory)
+	if !ok {
+		return
+	}
+	defer release()
 
 	// name := fc.ObjectMeta.Name
 	gvr := schema.GroupVersionResource{
@@ -91,9 +128,14 @@ func updateFlowConfiguration(w http.ResponseWriter, r *http.Request) {
 		Resource: "flowconfigurations",
 	}
 
+	obj, err := fc.unstructured()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 	unstructuredFC, err := dynamicClient.Resource(gvr).Namespace(namespace).Update(
 		context.TODO(),
-		&fc,
+		obj,
 		v1.UpdateOptions{},
 	)
 	if err != nil {
@@ -132,11 +174,75 @@ func deleteFlowConfiguration(w http.ResponseWriter, r *http.Request) {
 }
 
 func main() {
+	install := flag.Bool("install-crds", os.Getenv("INSTALL_CRDS") == "true", "apply the embedded FlowConfiguration CRD at startup")
+	crdTimeout := flag.Duration("crd-timeout", 60*time.Second, "how long to wait for the CRD to become established")
+	migrate := flag.Bool("migrate-crd-storage", false, "rewrite stored FlowConfigurations as v1, then exit")
+	runController := flag.Bool("controller", os.Getenv("FLOW_CONTROLLER") == "true", "provision flows and clean up after them on deletion (see controller.go)")
+	flag.Parse()
+
 	initK8sClient()
 
+	crdCfg, err := crdConfigFromEnv()
+	if err != nil {
+		log.Fatalf("crd config: %v", err)
+	}
+	ctx := context.Background()
+	if *install {
+		if err := installCRDs(ctx, dynamicClient, crdCfg); err != nil {
+			log.Fatalf("install crds: %v", err)
+		}
+	}
+	st, err := waitCRDReady(ctx, dynamicClient, *crdTimeout)
+	switch {
+	case err != nil && *install:
+		log.Fatalf("%v", err)
+	case err != nil:
+		// keep serving; /crd/status reports it until someone installs it
+		log.Printf("[crd] %v", err)
+	default:
+		log.Printf("[crd] %s ready: served=%v stored=%v conversion=%s", st.Name, st.ServedVersions, st.StoredVersions, st.Conversion)
+	}
+	if *migrate {
+		n, err := migrateStorage(ctx, dynamicClient)
+		if err != nil {
+			log.Fatalf("migrate: %v", err)
+		}
+		log.Printf("[crd] rewrote %d FlowConfiguration(s); storedVersions is now [v1]", n)
+		return
+	}
+
+	if quota, err = quotaFromEnv(); err != nil {
+		log.Fatalf("quota: %v", err)
+	}
+	quota.publish(namespace)
+
+	if *runController {
+		prov, err := provisionerFromEnv()
+		if err != nil {
+			log.Fatalf("provisioner: %v", err)
+		}
+		go newFlowController(dynamicClient, namespace, prov).run(ctx, 2)
+	}
+
 	http.HandleFunc("/create", createFlowConfiguration)
 	http.HandleFunc("/update", updateFlowConfiguration)
 	http.HandleFunc("/delete", deleteFlowConfiguration)
+	http.HandleFunc("/flows/", getFlowMetrics)
+	http.HandleFunc("/crd/status", crdStatusHandler)
+	http.HandleFunc("/convert", convertHandler)
+	http.HandleFunc("/quota", quotaHandler)
+	http.HandleFunc("/openapi.yaml", openAPIHandler)
+	http.HandleFunc("/openapi.json", openAPIHandler)
+	http.HandleFunc("/docs", openAPIHandler)
+	http.Handle("/metrics", promhttp.Handler())
+
+	// The API server only calls conversion webhooks over TLS.
+	if cert, key := os.Getenv("WEBHOOK_TLS_CERT"), os.Getenv("WEBHOOK_TLS_KEY"); cert != "" && key != "" {
+		go func() {
+			log.Println("Starting conversion webhook on :9443")
+			log.Fatal(http.ListenAndServeTLS(":9443", cert, key, nil))
+		}()
+	}
 
 	log.Println("Starting server on :8080")
 	log.Fatal(http.ListenAndServe(":8080", nil))
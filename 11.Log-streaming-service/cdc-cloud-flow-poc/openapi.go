@@ -0,0 +1,57 @@
+package main
+
+import (
+	_ "embed"
+	"net/http"
+
+	"k8s.io/apimachinery/pkg/util/yaml"
+)
+
+// The flow API is described by openapi.yaml, which client/ is generated
+// from. The manager serves it as written and as JSON, and /docs renders it
+// with Swagger UI (loaded from unpkg, so the browser needs internet access).
+
+//go:embed openapi.yaml
+var openAPISpec []byte
+
+var openAPIJSON = func() []byte {
+	b, err := yaml.ToJSON(openAPISpec)
+	if err != nil {
+		panic("openapi.yaml: " + err.Error())
+	}
+	return b
+}()
+
+const swaggerUIPage = `<!DOCTYPE html>
+<html>
+<head>
+  <meta charset="utf-8">
+  <title>CDC cloud flow API</title>
+  <link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@5/swagger-ui.css">
+</head>
+<body>
+  <div id="swagger-ui"></div>
+  <script src="https://unpkg.com/swagger-ui-dist@5/swagger-ui-bundle.js"></script>
+  <script>SwaggerUIBundle({url: "openapi.json", dom_id: "#swagger-ui"});</script>
+</body>
+</html>
+`
+
+// openAPIHandler serves GET /openapi.yaml, /openapi.json and /docs.
+func openAPIHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	switch r.URL.Path {
+	case "/openapi.yaml":
+		w.Header().Set("Content-Type", "application/yaml")
+		w.Write(openAPISpec)
+	case "/openapi.json":
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(openAPIJSON)
+	default:
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(swaggerUIPage))
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// Provisioner creates and deletes what a flow needs outside the cluster:
+// the Kafka topics it writes to, sinks in a cloud account, and so on. The
+// controller calls Provision whenever a flow changes and Deprovision once
+// when it is deleted, retrying both with backoff until they succeed, so
+// both must be idempotent: provisioning what exists and deleting what is
+// already gone are successes.
+//
+// Select one with PROVISIONER:
+//
+//	log   (default) only logs what it would do
+//	http  PUT/DELETE {PROVISIONER_URL}/flows/{namespace}/{name}, body flowResources
+type Provisioner interface {
+	Provision(ctx context.Context, flow *unstructured.Unstructured) error
+	Deprovision(ctx context.Context, flow *unstructured.Unstructured) error
+}
+
+func provisionerFromEnv() (Provisioner, error) {
+	switch kind := envOr("PROVISIONER", "log"); kind {
+	case "log":
+		return logProvisioner{}, nil
+	case "http":
+		u := os.Getenv("PROVISIONER_URL")
+		if u == "" {
+			return nil, fmt.Errorf("PROVISIONER=http needs PROVISIONER_URL")
+		}
+		return &httpProvisioner{BaseURL: strings.TrimSuffix(u, "/"), Client: &http.Client{Timeout: 30 * time.Second}}, nil
+	default:
+		return nil, fmt.Errorf("PROVISIONER=%q: want log or http", kind)
+	}
+}
+
+// flowResources names the external resources of a flow. Names derive from
+// the flow's namespace and name only, so Deprovision finds them again even
+// if the spec changed in between.
+type flowResources struct {
+	Namespace    string   `json:"namespace"`
+	Name         string   `json:"name"`
+	Topics       []string `json:"topics"`
+	Destinations []string `json:"destinations"`
+}
+
+func resourcesOf(flow *unstructured.Unstructured) flowResources {
+	r := flowResources{Namespace: flow.GetNamespace(), Name: flow.GetName()}
+	sources, _, _ := unstructured.NestedStringSlice(flow.Object, "spec", "sources")
+	for _, s := range sources {
+		r.Topics = append(r.Topics, fmt.Sprintf("cdc.%s.%s.%s", r.Namespace, r.Name, s))
+	}
+	r.Destinations, _, _ = unstructured.NestedStringSlice(flow.Object, "spec", "destinations")
+	return r
+}
+
+type logProvisioner struct{}
+
+func (logProvisioner) Provision(_ context.Context, flow *unstructured.Unstructured) error {
+	r := resourcesOf(flow)
+	log.Printf("[provision] %s/%s: topics=%v destinations=%v", r.Namespace, r.Name, r.Topics, r.Destinations)
+	return nil
+}
+
+func (logProvisioner) Deprovision(_ context.Context, flow *unstructured.Unstructured) error {
+	log.Printf("[deprovision] %s/%s: deleting cdc.%[1]s.%[2]s.* and sinks", flow.GetNamespace(), flow.GetName())
+	return nil
+}
+
+// httpProvisioner hands the work to a provisioning service. 404 on DELETE
+// means already gone; any other non-2xx answer is retried.
+type httpProvisioner struct {
+	BaseURL string
+	Client  *http.Client
+}
+
+func (p *httpProvisioner) Provision(ctx context.Context, flow *unstructured.Unstructured) error {
+	body, err := json.Marshal(resourcesOf(flow))
+	if err != nil {
+		return err
+	}
+	return p.do(ctx, http.MethodPut, flow, body)
+}
+
+func (p *httpProvisioner) Deprovision(ctx context.Context, flow *unstructured.Unstructured) error {
+	return p.do(ctx, http.MethodDelete, flow, nil)
+}
+
+func (p *httpProvisioner) do(ctx context.Context, method string, flow *unstructured.Unstructured, body []byte) error {
+	u := fmt.Sprintf("%s/flows/%s/%s", p.BaseURL, flow.GetNamespace(), flow.GetName())
+	req, err := http.NewRequestWithContext(ctx, method, u, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := p.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 == 2 || (method == http.MethodDelete && resp.StatusCode == http.StatusNotFound) {
+		return nil
+	}
+	return fmt.Errorf("%s %s: %s", method, u, resp.Status)
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"k8s.io/apimachinery/pkg/api/resource"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/dynamic"
+)
+
+// The namespace quota caps the CPU and memory that all flows in the
+// namespace may request together (FLOW_QUOTA_CPU, FLOW_QUOTA_MEMORY, in
+// Kubernetes quantity syntax: "8", "500m", "16Gi"; unset means unlimited).
+// /create and /update sum spec.resources of the existing flows, the one
+// being updated excluded, add the request's and answer 409 with the current
+// usage if a limit would be exceeded. Flows being deleted still count until
+// they are gone.
+//
+// Checks are serialized within this process only. With several API
+// replicas two requests can both pass; a ResourceQuota on the flows' pods
+// is the authoritative limit, this one gives a clear error before any of
+// them is created.
+
+type namespaceQuota struct {
+	CPU    *resource.Quantity // nil: unlimited
+	Memory *resource.Quantity
+}
+
+var (
+	quota   namespaceQuota
+	quotaMu sync.Mutex // held from a quota check until the flow is written
+)
+
+func quotaFromEnv() (namespaceQuota, error) {
+	var q namespaceQuota
+	for _, l := range []struct {
+		env string
+		dst **resource.Quantity
+	}{{"FLOW_QUOTA_CPU", &q.CPU}, {"FLOW_QUOTA_MEMORY", &q.Memory}} {
+		v := envOr(l.env, "")
+		if v == "" {
+			continue
+		}
+		qty, err := resource.ParseQuantity(v)
+		if err != nil || qty.Sign() < 0 {
+			return q, fmt.Errorf("%s=%q: want a non-negative quantity", l.env, v)
+		}
+		*l.dst = &qty
+	}
+	return q, nil
+}
+
+// quotaAmounts is CPU in cores and memory in bytes, the units of the
+// flow_quota_* metrics.
+type quotaAmounts struct {
+	CPU    float64 `json:"cpu"`
+	Memory float64 `json:"memory_bytes"`
+}
+
+func (a quotaAmounts) add(b quotaAmounts) quotaAmounts {
+	return quotaAmounts{CPU: a.CPU + b.CPU, Memory: a.Memory + b.Memory}
+}
+
+// quotaLimits is a limit per resource; absent ones are unlimited.
+type quotaLimits struct {
+	CPU    *float64 `json:"cpu,omitempty"`
+	Memory *float64 `json:"memory_bytes,omitempty"`
+}
+
+func (q namespaceQuota) limits() quotaLimits {
+	var l quotaLimits
+	if q.CPU != nil {
+		v := q.CPU.AsApproximateFloat64()
+		l.CPU = &v
+	}
+	if q.Memory != nil {
+		v := q.Memory.AsApproximateFloat64()
+		l.Memory = &v
+	}
+	return l
+}
+
+// QuotaUsage is the body of GET /quota and of a 409 from /create or /update.
+type QuotaUsage struct {
+	Namespace string       `json:"namespace"`
+	Flows     int          `json:"flows"`
+	Limits    quotaLimits  `json:"limits"`
+	Used      quotaAmounts `json:"used"`
+	Available quotaLimits  `json:"available"`
+	// set on a rejection
+	Error     string        `json:"error,omitempty"`
+	Flow      string        `json:"flow,omitempty"`
+	Requested *quotaAmounts `json:"requested,omitempty"`
+	Exceeded  []string      `json:"exceeded,omitempty"`
+}
+
+var (
+	quotaLimitGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "flow_quota_limit",
+		Help: "Namespace quota for all flows together: cores for cpu, bytes for memory. Absent when unlimited.",
+	}, []string{"namespace", "resource"})
+	quotaUsedGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "flow_quota_used",
+		Help: "Resources requested by the namespace's flows at the last quota check or GET /quota.",
+	}, []string{"namespace", "resource"})
+	quotaRejections = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "flow_quota_rejections_total",
+		Help: "Flow creates and updates rejected because they would exceed the namespace quota, by the first resource exceeded.",
+	}, []string{"namespace", "resource"})
+)
+
+// publish exports the limits; called once the quota is loaded.
+func (q namespaceQuota) publish(ns string) {
+	l := q.limits()
+	if l.CPU != nil {
+		quotaLimitGauge.WithLabelValues(ns, "cpu").Set(*l.CPU)
+	}
+	if l.Memory != nil {
+		quotaLimitGauge.WithLabelValues(ns, "memory").Set(*l.Memory)
+	}
+}
+
+// flowAmounts parses a flow's spec.resources. Missing values are zero.
+func flowAmounts(cpu, memory string) (quotaAmounts, error) {
+	var a quotaAmounts
+	if cpu != "" {
+		q, err := resource.ParseQuantity(cpu)
+		if err != nil {
+			return a, fmt.Errorf("resources.cpu %q: %v", cpu, err)
+		}
+		a.CPU = q.AsApproximateFloat64()
+	}
+	if memory != "" {
+		q, err := resource.ParseQuantity(memory)
+		if err != nil {
+			return a, fmt.Errorf("resources.memory %q: %v", memory, err)
+		}
+		a.Memory = q.AsApproximateFloat64()
+	}
+	if a.CPU < 0 || a.Memory < 0 {
+		return a, fmt.Errorf("resources must not be negative")
+	}
+	return a, nil
+}
+
+// namespaceUsage sums the resources of the flows in ns, except exclude.
+// Flows with unparsable resources are logged and counted as zero; the API
+// server accepted them, so refusing every other flow would not help.
+func namespaceUsage(ctx context.Context, client dynamic.Interface, ns, exclude string) (QuotaUsage, error) {
+	list, err := client.Resource(flowGVR).Namespace(ns).List(ctx, v1.ListOptions{})
+	if err != nil {
+		return QuotaUsage{}, err
+	}
+	u := QuotaUsage{Namespace: ns, Limits: quota.limits()}
+	for i := range list.Items {
+		item := &list.Items[i]
+		if item.GetName() == exclude {
+			continue
+		}
+		cpu, _, _ := unstructured.NestedString(item.Object, "spec", "resources", "cpu")
+		mem, _, _ := unstructured.NestedString(item.Object, "spec", "resources", "memory")
+		a, err := flowAmounts(cpu, mem)
+		if err != nil {
+			log.Printf("[quota] %s/%s: %v; counted as 0", ns, item.GetName(), err)
+		}
+		u.Used = u.Used.add(a)
+		u.Flows++
+	}
+	u.Available = available(u.Limits, u.Used)
+	quotaUsedGauge.WithLabelValues(ns, "cpu").Set(u.Used.CPU)
+	quotaUsedGauge.WithLabelValues(ns, "memory").Set(u.Used.Memory)
+	return u, nil
+}
+
+func available(l quotaLimits, used quotaAmounts) quotaLimits {
+	var a quotaLimits
+	if l.CPU != nil {
+		v := *l.CPU - used.CPU
+		a.CPU = &v
+	}
+	if l.Memory != nil {
+		v := *l.Memory - used.Memory
+		a.Memory = &v
+	}
+	return a
+}
+
+// checkQuota answers the request itself and returns false when flow name,
+// asking for cpu and memory, does not fit into the namespace quota. On
+// true the caller holds quotaMu and must call the returned release once
+// the flow is written, so a concurrent request sees it.
+func checkQuota(w http.ResponseWriter, r *http.Request, name, cpu, memory string) (release func(), ok bool) {
+	req, err := flowAmounts(cpu, memory)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	quotaMu.Lock()
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+	u, err := namespaceUsage(ctx, dynamicClient, namespace, name)
+	if err != nil {
+		quotaMu.Unlock()
+		http.Error(w, "quota check: "+err.Error(), http.StatusInternalServerError)
+		return nil, false
+	}
+	after := u.Used.add(req)
+	if u.Limits.CPU != nil && after.CPU > *u.Limits.CPU {
+		u.Exceeded = append(u.Exceeded, "cpu")
+	}
+	if u.Limits.Memory != nil && after.Memory > *u.Limits.Memory {
+		u.Exceeded = append(u.Exceeded, "memory")
+	}
+	if len(u.Exceeded) == 0 {
+		return quotaMu.Unlock, true
+	}
+	quotaMu.Unlock()
+
+	quotaRejections.WithLabelValues(namespace, u.Exceeded[0]).Inc()
+	u.Error = fmt.Sprintf("flow %q would exceed the %s quota of namespace %s", name, u.Exceeded[0], namespace)
+	u.Flow, u.Requested = name, &req
+	log.Printf("[quota] rejected %s/%s: cpu=%g memory=%.0f on top of used cpu=%g memory=%.0f", namespace, name, req.CPU, req.Memory, u.Used.CPU, u.Used.Memory)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusConflict)
+	json.NewEncoder(w).Encode(u)
+	return nil, false
+}
+
+// quotaHandler serves GET /quota: limits, usage and what is left.
+func quotaHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	u, err := namespaceUsage(r.Context(), dynamicClient, namespace, "")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(u)
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func flowWith(name, cpu, memory string) *unstructured.Unstructured {
+	f := newFlow(name)
+	_ = unstructured.SetNestedStringMap(f.Object, map[string]string{"cpu": cpu, "memory": memory}, "spec", "resources")
+	return f
+}
+
+// withQuota points the package's client and quota at a fake API server
+// holding flows, for the duration of the test.
+func withQuota(t *testing.T, cpu, memory string, flows ...*unstructured.Unstructured) {
+	t.Helper()
+	var objs []runtime.Object
+	for _, f := range flows {
+		objs = append(objs, f)
+	}
+	oldClient, oldNS, oldQuota := dynamicClient, namespace, quota
+	t.Cleanup(func() { dynamicClient, namespace, quota = oldClient, oldNS, oldQuota })
+	dynamicClient, namespace, quota = fakeClient(objs...), "default", namespaceQuota{}
+	if cpu != "" {
+		q := resource.MustParse(cpu)
+		quota.CPU = &q
+	}
+	if memory != "" {
+		q := resource.MustParse(memory)
+		quota.Memory = &q
+	}
+}
+
+func TestNamespaceUsage(t *testing.T) {
+	withQuota(t, "2", "", flowWith("a", "500m", "1Gi"), flowWith("b", "1", ""), flowWith("broken", "lots", ""))
+	u, err := namespaceUsage(context.Background(), dynamicClient, "default", "b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// b is excluded; broken counts as a flow with nothing requested
+	if u.Flows != 2 || u.Used.CPU != 0.5 || u.Used.Memory != 1<<30 {
+		t.Fatalf("usage = %+v", u)
+	}
+	if u.Limits.CPU == nil || *u.Limits.CPU != 2 || u.Limits.Memory != nil {
+		t.Fatalf("limits = %+v", u.Limits)
+	}
+	if u.Available.CPU == nil || *u.Available.CPU != 1.5 || u.Available.Memory != nil {
+		t.Fatalf("available = %+v", u.Available)
+	}
+}
+
+func TestCheckQuota(t *testing.T) {
+	cases := []struct {
+		name, flow, cpu, memory string
+		ok                      bool
+		exceeded                []string
+	}{
+		{"fits", "new", "500m", "512Mi", true, nil},
+		{"exactly the rest", "new", "1", "1Gi", true, nil},
+		{"over cpu", "new", "1100m", "", false, []string{"cpu"}},
+		{"over both", "new", "2", "2Gi", false, []string{"cpu", "memory"}},
+		// an update replaces what the flow had: a grows from 1 to 2 cores
+		{"update in place", "a", "2", "", true, nil},
+		{"update over", "a", "2100m", "", false, []string{"cpu"}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			withQuota(t, "3", "2Gi", flowWith("a", "1", "512Mi"), flowWith("b", "1", "512Mi"))
+			w := httptest.NewRecorder()
+			release, ok := checkQuota(w, httptest.NewRequest(http.MethodPost, "/create", nil), tc.flow, tc.cpu, tc.memory)
+			if ok != tc.ok {
+				t.Fatalf("ok = %v, status %d %s", ok, w.Code, w.Body)
+			}
+			if ok {
+				release()
+				return
+			}
+			if w.Code != http.StatusConflict {
+				t.Fatalf("status %d", w.Code)
+			}
+			var u QuotaUsage
+			if err := json.Unmarshal(w.Body.Bytes(), &u); err != nil {
+				t.Fatal(err)
+			}
+			if u.Flow != tc.flow || u.Requested == nil || len(u.Exceeded) != len(tc.exceeded) || u.Exceeded[0] != tc.exceeded[0] {
+				t.Fatalf("409 body = %+v", u)
+			}
+		})
+	}
+}
+
+func TestCheckQuotaReleases(t *testing.T) {
+	withQuota(t, "", "")
+	release, ok := checkQuota(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/create", nil), "new", "64", "")
+	if !ok {
+		t.Fatal("an unlimited quota rejected a flow")
+	}
+	release()
+	// quotaMu is free again for the next request
+	if _, ok := checkQuota(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/create", nil), "other", "bad", ""); ok {
+		t.Fatal("unparsable resources accepted")
+	}
+}
@@ -0,0 +1,254 @@
+// Package client is a Go SDK for the Messages API served by this module.
+//
+//	c, err := client.New("http://localhost:8080/v1", client.WithLanguage("fr"))
+//	m, err := c.CreateMessage(ctx, "bonjour")
+//
+// Every method takes a context for cancellation and deadlines. Requests the
+// server turns away with 429 or 503 are retried with exponential backoff,
+// honouring Retry-After; other failures come back as *APIError carrying the
+// stable error code from the response body.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Message mirrors the API's Message model.
+type Message struct {
+	ID      int    `json:"id"`
+	Message string `json:"message"`
+}
+
+// Hello is the body of GET /hello.
+type Hello struct {
+	Message string `json:"message"`
+	Stored  string `json:"stored"`
+}
+
+// APIError is a non-2xx response. Code is the machine-readable key
+// ("not_found", "invalid_payload", ...); Message is the localized text.
+// TraceID identifies the request in the server's traces; quote it when
+// reporting a problem.
+type APIError struct {
+	StatusCode int
+	Code       string `json:"code"`
+	Message    string `json:"error"`
+	TraceID    string `json:"trace_id"`
+}
+
+func (e *APIError) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("messages api: %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+	}
+	return fmt.Sprintf("messages api: %d %s: %s", e.StatusCode, e.Code, e.Message)
+}
+
+// IsNotFound reports whether err is a 404 from the API.
+func IsNotFound(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound
+}
+
+// Client calls the Messages API. It is safe for concurrent use.
+type Client struct {
+	base       *url.URL
+	http       *http.Client
+	language   string
+	token      string
+	userAgent  string
+	maxRetries int
+	minBackoff time.Duration
+	maxBackoff time.Duration
+}
+
+// Option configures a Client.
+type Option func(*Client)
+
+// WithHTTPClient replaces http.DefaultClient, e.g. to set a timeout or
+// transport.
+func WithHTTPClient(hc *http.Client) Option { return func(c *Client) { c.http = hc } }
+
+// WithLanguage sends Accept-Language so error messages come back translated.
+func WithLanguage(tag string) Option { return func(c *Client) { c.language = tag } }
+
+// WithBearerToken sends an Authorization: Bearer header.
+func WithBearerToken(token string) Option { return func(c *Client) { c.token = token } }
+
+// WithUserAgent overrides the User-Agent header.
+func WithUserAgent(ua string) Option { return func(c *Client) { c.userAgent = ua } }
+
+// WithRetry sets how often a 429/503 is retried (0 disables) and the
+// backoff bounds. The defaults are 3 retries between 100ms and 2s.
+func WithRetry(max int, minBackoff, maxBackoff time.Duration) Option {
+	return func(c *Client) { c.maxRetries, c.minBackoff, c.maxBackoff = max, minBackoff, maxBackoff }
+}
+
+// New returns a client for the API rooted at baseURL, including the version
+// prefix, e.g. "http://localhost:8080/v1".
+func New(baseURL string, opts ...Option) (*Client, error) {
+	u, err := url.Parse(strings.TrimSuffix(baseURL, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("client: base url: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("client: base url %q must be http or https", baseURL)
+	}
+	c := &Client{
+		base:       u,
+		http:       http.DefaultClient,
+		userAgent:  "go-swagger-demo-client/1.0",
+		maxRetries: 3,
+		minBackoff: 100 * time.Millisecond,
+		maxBackoff: 2 * time.Second,
+	}
+	for _, o := range opts {
+		o(c)
+	}
+	return c, nil
+}
+
+// Hello calls GET /hello.
+func (c *Client) Hello(ctx context.Context) (*Hello, error) {
+	var out Hello
+	if err := c.do(ctx, http.MethodGet, "/hello", nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// ListMessages calls GET /messages. The order is unspecified.
+func (c *Client) ListMessages(ctx context.Context) ([]Message, error) {
+	var out []Message
+	if err := c.do(ctx, http.MethodGet, "/messages", nil, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// GetMessage calls GET /message/{id}.
+func (c *Client) GetMessage(ctx context.Context, id int) (*Message, error) {
+	var out Message
+	if err := c.do(ctx, http.MethodGet, "/message/"+strconv.Itoa(id), nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// CreateMessage calls POST /message; the server assigns the ID.
+func (c *Client) CreateMessage(ctx context.Context, text string) (*Message, error) {
+	var out Message
+	if err := c.do(ctx, http.MethodPost, "/message", Message{Message: text}, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// UpdateMessage calls PUT /message/{id}.
+func (c *Client) UpdateMessage(ctx context.Context, id int, text string) (*Message, error) {
+	var out Message
+	if err := c.do(ctx, http.MethodPut, "/message/"+strconv.Itoa(id), Message{Message: text}, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// DeleteMessage calls DELETE /message/{id}.
+func (c *Client) DeleteMessage(ctx context.Context, id int) error {
+	return c.do(ctx, http.MethodDelete, "/message/"+strconv.Itoa(id), nil, nil)
+}
+
+// do sends one API call, retrying 429 and 503. Those mean the server did
+// not act on the request, so retrying is safe even for POST.
+func (c *Client) do(ctx context.Context, method, path string, in, out interface{}) error {
+	var body []byte
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return fmt.Errorf("client: encode request: %w", err)
+		}
+		body = b
+	}
+	u := *c.base
+	u.Path += path
+
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+		if err != nil {
+			return fmt.Errorf("client: %w", err)
+		}
+		req.Header.Set("Accept", "application/json")
+		req.Header.Set("User-Agent", c.userAgent)
+		if body != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		if c.language != "" {
+			req.Header.Set("Accept-Language", c.language)
+		}
+		if c.token != "" {
+			req.Header.Set("Authorization", "Bearer "+c.token)
+		}
+
+		resp, err := c.http.Do(req)
+		if err != nil {
+			return fmt.Errorf("client: %s %s: %w", method, path, err)
+		}
+		retryable := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable
+		if !retryable || attempt >= c.maxRetries {
+			return decode(resp, out)
+		}
+		wait := c.backoff(attempt, resp.Header.Get("Retry-After"))
+		_, _ = io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+
+		t := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+}
+
+// backoff is full-jitter exponential backoff, or the server's Retry-After
+// (in seconds) when it sent one, capped at maxBackoff either way.
+func (c *Client) backoff(attempt int, retryAfter string) time.Duration {
+	if s, err := strconv.Atoi(retryAfter); err == nil && s >= 0 {
+		return min(time.Duration(s)*time.Second, c.maxBackoff)
+	}
+	d := c.minBackoff << attempt
+	if d <= 0 || d > c.maxBackoff {
+		d = c.maxBackoff
+	}
+	if d <= c.minBackoff {
+		return d
+	}
+	return c.minBackoff + time.Duration(rand.Int63n(int64(d-c.minBackoff)))
+}
+
+func decode(resp *http.Response, out interface{}) error {
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		apiErr := &APIError{StatusCode: resp.StatusCode}
+		_ = json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(apiErr)
+		return apiErr
+	}
+	if out == nil || resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("client: decode response: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,227 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeAPI is an in-memory stand-in for the Messages API with the same routes
+// and error bodies.
+type fakeAPI struct {
+	mu    sync.Mutex
+	store map[int]Message
+	last  http.Header
+}
+
+func newFakeAPI() *fakeAPI {
+	return &fakeAPI{store: map[int]Message{1: {ID: 1, Message: "hello"}}}
+}
+
+func (f *fakeAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.last = r.Header.Clone()
+	writeJSON := func(status int, v interface{}) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		_ = json.NewEncoder(w).Encode(v)
+	}
+	notFound := func() {
+		writeJSON(http.StatusNotFound, map[string]string{"code": "not_found", "error": "message not found"})
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, "/v1")
+	switch {
+	case path == "/messages" && r.Method == http.MethodGet:
+		out := []Message{}
+		for _, m := range f.store {
+			out = append(out, m)
+		}
+		writeJSON(http.StatusOK, out)
+	case path == "/message" && r.Method == http.MethodPost:
+		var in Message
+		if err := json.NewDecoder(r.Body).Decode(&in); err != nil || in.Message == "" {
+			writeJSON(http.StatusBadRequest, map[string]string{"code": "message_required", "error": "message is required",
+				"trace_id": "4bf92f3577b34da6a3ce929d0e0e4736"})
+			return
+		}
+		in.ID = len(f.store) + 1
+		f.store[in.ID] = in
+		writeJSON(http.StatusCreated, in)
+	case strings.HasPrefix(path, "/message/"):
+		id, _ := strconv.Atoi(strings.TrimPrefix(path, "/message/"))
+		m, ok := f.store[id]
+		if !ok {
+			notFound()
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			writeJSON(http.StatusOK, m)
+		case http.MethodPut:
+			var in Message
+			_ = json.NewDecoder(r.Body).Decode(&in)
+			in.ID = id
+			f.store[id] = in
+			writeJSON(http.StatusOK, in)
+		case http.MethodDelete:
+			delete(f.store, id)
+			w.WriteHeader(http.StatusNoContent)
+		}
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func newTestClient(t *testing.T, h http.Handler, opts ...Option) *Client {
+	t.Helper()
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+	c, err := New(srv.URL+"/v1", append([]Option{WithRetry(3, time.Millisecond, 5*time.Millisecond)}, opts...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestCRUD(t *testing.T) {
+	api := newFakeAPI()
+	c := newTestClient(t, api, WithLanguage("fr"), WithBearerToken("s3cr3t"))
+	ctx := context.Background()
+
+	created, err := c.CreateMessage(ctx, "bonjour")
+	if err != nil || created.ID != 2 || created.Message != "bonjour" {
+		t.Fatalf("create = %+v, %v", created, err)
+	}
+	if got := api.last.Get("Accept-Language"); got != "fr" {
+		t.Errorf("Accept-Language = %q", got)
+	}
+	if got := api.last.Get("Authorization"); got != "Bearer s3cr3t" {
+		t.Errorf("Authorization = %q", got)
+	}
+
+	got, err := c.GetMessage(ctx, 2)
+	if err != nil || *got != *created {
+		t.Fatalf("get = %+v, %v", got, err)
+	}
+	updated, err := c.UpdateMessage(ctx, 2, "salut")
+	if err != nil || updated.Message != "salut" {
+		t.Fatalf("update = %+v, %v", updated, err)
+	}
+	list, err := c.ListMessages(ctx)
+	if err != nil || len(list) != 2 {
+		t.Fatalf("list = %+v, %v", list, err)
+	}
+	if err := c.DeleteMessage(ctx, 2); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if _, err := c.GetMessage(ctx, 2); !IsNotFound(err) {
+		t.Fatalf("get after delete: %v", err)
+	}
+}
+
+func TestAPIError(t *testing.T) {
+	c := newTestClient(t, newFakeAPI())
+	_, err := c.CreateMessage(context.Background(), "")
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("err = %v, want *APIError", err)
+	}
+	if apiErr.StatusCode != http.StatusBadRequest || apiErr.Code != "message_required" || apiErr.Message != "message is required" ||
+		apiErr.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Fatalf("APIError = %+v", apiErr)
+	}
+	if IsNotFound(err) {
+		t.Fatal("400 reported as not found")
+	}
+}
+
+// flaky answers status for the first n requests, then hands over to next.
+func flaky(n int32, status int, retryAfter string, next http.Handler) (http.Handler, *atomic.Int32) {
+	var calls atomic.Int32
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) <= n {
+			if retryAfter != "" {
+				w.Header().Set("Retry-After", retryAfter)
+			}
+			w.WriteHeader(status)
+			return
+		}
+		next.ServeHTTP(w, r)
+	}), &calls
+}
+
+func TestRetries(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		failures   int32
+		status     int
+		retryAfter string
+		wantCalls  int32
+		wantStatus int // 0: success
+	}{
+		{name: "503 then ok", failures: 2, status: http.StatusServiceUnavailable, wantCalls: 3},
+		{name: "429 with Retry-After", failures: 1, status: http.StatusTooManyRequests, retryAfter: "0", wantCalls: 2},
+		{name: "gives up after max retries", failures: 10, status: http.StatusServiceUnavailable, wantCalls: 4, wantStatus: http.StatusServiceUnavailable},
+		{name: "500 is not retried", failures: 1, status: http.StatusInternalServerError, wantCalls: 1, wantStatus: http.StatusInternalServerError},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			h, calls := flaky(tc.failures, tc.status, tc.retryAfter, newFakeAPI())
+			c := newTestClient(t, h)
+			_, err := c.CreateMessage(context.Background(), "again")
+			if calls.Load() != tc.wantCalls {
+				t.Errorf("calls = %d, want %d", calls.Load(), tc.wantCalls)
+			}
+			var apiErr *APIError
+			switch {
+			case tc.wantStatus == 0 && err != nil:
+				t.Fatalf("err = %v", err)
+			case tc.wantStatus != 0 && (!errors.As(err, &apiErr) || apiErr.StatusCode != tc.wantStatus):
+				t.Fatalf("err = %v, want status %d", err, tc.wantStatus)
+			}
+		})
+	}
+}
+
+func TestRetryStopsOnContextCancel(t *testing.T) {
+	h, _ := flaky(100, http.StatusServiceUnavailable, "", newFakeAPI())
+	c := newTestClient(t, h, WithRetry(5, time.Hour, time.Hour))
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := c.ListMessages(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("err = %v, want deadline exceeded", err)
+	}
+	if time.Since(start) > time.Second {
+		t.Fatal("backoff ignored the context")
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	c := &Client{minBackoff: 100 * time.Millisecond, maxBackoff: time.Second}
+	for attempt := 0; attempt < 8; attempt++ {
+		if d := c.backoff(attempt, ""); d < c.minBackoff || d > c.maxBackoff {
+			t.Errorf("attempt %d: backoff %s outside [%s, %s]", attempt, d, c.minBackoff, c.maxBackoff)
+		}
+	}
+	if d := c.backoff(0, "30"); d != time.Second {
+		t.Errorf("Retry-After above the cap: %s, want %s", d, time.Second)
+	}
+}
+
+func TestNewRejectsBadURL(t *testing.T) {
+	for _, u := range []string{"localhost:8080", "ftp://x", "://"} {
+		if _, err := New(u); err == nil {
+			t.Errorf("New(%q) succeeded", u)
+		}
+	}
+}
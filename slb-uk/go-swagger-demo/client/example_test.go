@@ -0,0 +1,71 @@
+package client_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/slb-uk/go-swagger-demo/client"
+)
+
+// server fakes two endpoints of the API for the examples; point New at
+// http://localhost:8080/v1 to use the real service.
+func server() *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/message", func(w http.ResponseWriter, r *http.Request) {
+		var m client.Message
+		_ = json.NewDecoder(r.Body).Decode(&m)
+		m.ID = 3
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(m)
+	})
+	mux.HandleFunc("/v1/message/9", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		_ = json.NewEncoder(w).Encode(map[string]string{"code": "not_found", "error": "Message 9 introuvable"})
+	})
+	return httptest.NewServer(mux)
+}
+
+func Example() {
+	srv := server()
+	defer srv.Close()
+
+	c, err := client.New(srv.URL+"/v1", client.WithLanguage("fr"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	m, err := c.CreateMessage(ctx, "bonjour")
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(m.ID, m.Message)
+	// Output: 3 bonjour
+}
+
+func ExampleIsNotFound() {
+	srv := server()
+	defer srv.Close()
+
+	c, _ := client.New(srv.URL + "/v1")
+	_, err := c.GetMessage(context.Background(), 9)
+	if client.IsNotFound(err) {
+		fmt.Println("gone:", err)
+	}
+	// Output: gone: messages api: 404 not_found: Message 9 introuvable
+}
+
+func ExampleWithRetry() {
+	// Retry 429/503 up to 5 times, backing off between 200ms and 5s.
+	c, _ := client.New("http://localhost:8080/v1",
+		client.WithRetry(5, 200*time.Millisecond, 5*time.Second),
+		client.WithHTTPClient(&http.Client{Timeout: 10 * time.Second}),
+	)
+	_ = c
+}
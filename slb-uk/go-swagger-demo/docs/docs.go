@@ -26,7 +26,7 @@ const docTemplate = `{
     "paths": {
         "/hello": {
             "get": {
-                "description": "Returns a friendly welcome message.",
+                "description": "Returns a friendly welcome message in the negotiated language (en, fr, hi).",
                 "produces": [
                     "application/json"
                 ],
@@ -34,6 +34,20 @@ const docTemplate = `{
                     "misc"
                 ],
                 "summary": "Welcome",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Preferred languages, e.g. fr-CA, hi;q=0.8",
+                        "name": "Accept-Language",
+                        "in": "header"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Overrides Accept-Language",
+                        "name": "lang",
+                        "in": "query"
+                    }
+                ],
                 "responses": {
                     "200": {
                         "description": "OK",
@@ -47,6 +61,86 @@ const docTemplate = `{
                 }
             }
         },
+        "/jobs/{id}": {
+            "get": {
+                "description": "Returns the job's status and progress, and its result once it has succeeded. Finished jobs are kept for 10 minutes.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "jobs"
+                ],
+                "summary": "Get job status",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Job ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.Job"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "description": "Cancels a queued or running job. Canceling a job that has already finished returns 409 with the job unchanged.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "jobs"
+                ],
+                "summary": "Cancel a job",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Job ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.Job"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/main.Job"
+                        }
+                    }
+                }
+            }
+        },
         "/message": {
             "post": {
                 "description": "Create and store a new message.",
@@ -240,9 +334,127 @@ const docTemplate = `{
                     }
                 }
             }
+        },
+        "/messages/stream": {
+            "get": {
+                "description": "Upgrades to a WebSocket and pushes a JSON MessageEvent for every create, update and delete. Clients that fall more than 64 events behind are disconnected with close code 1008 and should reconnect and re-list.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "messages"
+                ],
+                "summary": "Stream message changes",
+                "responses": {
+                    "101": {
+                        "description": "Switching Protocols; each frame is a MessageEvent",
+                        "schema": {
+                            "$ref": "#/definitions/main.MessageEvent"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/reports": {
+            "post": {
+                "description": "Starts an asynchronous aggregation over the messages as they are now and returns 202 with the job to poll. Follow the Location header (or GET /jobs/{id}) until status is succeeded, failed or canceled; Retry-After suggests the polling interval.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "jobs"
+                ],
+                "summary": "Start a report",
+                "parameters": [
+                    {
+                        "description": "Filter and options",
+                        "name": "payload",
+                        "in": "body",
+                        "schema": {
+                            "$ref": "#/definitions/main.ReportRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "202": {
+                        "description": "Accepted",
+                        "schema": {
+                            "$ref": "#/definitions/main.Job"
+                        },
+                        "headers": {
+                            "Location": {
+                                "type": "string",
+                                "description": "URL of the job status resource"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
         }
     },
     "definitions": {
+        "main.Job": {
+            "type": "object",
+            "properties": {
+                "created_at": {
+                    "type": "string"
+                },
+                "error": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "string",
+                    "example": "9f86d081884c7d65"
+                },
+                "kind": {
+                    "type": "string",
+                    "example": "report"
+                },
+                "progress": {
+                    "description": "percent",
+                    "type": "integer",
+                    "example": 40
+                },
+                "result": {
+                    "$ref": "#/definitions/main.Report"
+                },
+                "status": {
+                    "type": "string",
+                    "enum": [
+                        "queued",
+                        "running",
+                        "succeeded",
+                        "failed",
+                        "canceled"
+                    ],
+                    "example": "running"
+                },
+                "updated_at": {
+                    "type": "string"
+                }
+            }
+        },
         "main.Message": {
             "type": "object",
             "properties": {
@@ -255,6 +467,80 @@ const docTemplate = `{
                     "example": "hello world"
                 }
             }
+        },
+        "main.MessageEvent": {
+            "type": "object",
+            "properties": {
+                "at": {
+                    "type": "string"
+                },
+                "message": {
+                    "$ref": "#/definitions/main.Message"
+                },
+                "type": {
+                    "type": "string",
+                    "enum": [
+                        "created",
+                        "updated",
+                        "deleted"
+                    ],
+                    "example": "created"
+                }
+            }
+        },
+        "main.Report": {
+            "type": "object",
+            "properties": {
+                "avg_length": {
+                    "type": "number",
+                    "example": 6
+                },
+                "longest": {
+                    "$ref": "#/definitions/main.Message"
+                },
+                "messages": {
+                    "type": "integer",
+                    "example": 2
+                },
+                "top_words": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/main.WordCount"
+                    }
+                },
+                "words": {
+                    "type": "integer",
+                    "example": 2
+                }
+            }
+        },
+        "main.ReportRequest": {
+            "type": "object",
+            "properties": {
+                "contains": {
+                    "description": "only messages containing this, case-insensitive; empty means all",
+                    "type": "string",
+                    "example": "hello"
+                },
+                "top_words": {
+                    "description": "default 5",
+                    "type": "integer",
+                    "example": 5
+                }
+            }
+        },
+        "main.WordCount": {
+            "type": "object",
+            "properties": {
+                "count": {
+                    "type": "integer",
+                    "example": 1
+                },
+                "word": {
+                    "type": "string",
+                    "example": "hello"
+                }
+            }
         }
     }
 }`
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+// MessageEvent is pushed to stream subscribers whenever the store changes.
+type MessageEvent struct {
+	Type    string    `json:"type" example:"created" enums:"created,updated,deleted"`
+	Message Message   `json:"message"`
+	At      time.Time `json:"at"`
+}
+
+// hub fans store changes out to subscribers. Each subscriber has its own
+// buffer; publishing never blocks, and a subscriber whose buffer is full is
+// evicted so one slow client can't hold up the others or the API.
+type hub struct {
+	mu      sync.Mutex
+	clients map[*subscriber]struct{}
+	buffer  int
+}
+
+type subscriber struct {
+	events  chan MessageEvent
+	evicted bool // set when dropped for being too slow
+}
+
+func newHub(buffer int) *hub {
+	return &hub{clients: map[*subscriber]struct{}{}, buffer: buffer}
+}
+
+func (h *hub) subscribe() *subscriber {
+	s := &subscriber{events: make(chan MessageEvent, h.buffer)}
+	h.mu.Lock()
+	h.clients[s] = struct{}{}
+	h.mu.Unlock()
+	return s
+}
+
+func (h *hub) unsubscribe(s *subscriber) {
+	h.mu.Lock()
+	if _, ok := h.clients[s]; ok {
+		delete(h.clients, s)
+		close(s.events)
+	}
+	h.mu.Unlock()
+}
+
+func (h *hub) publish(typ string, m Message) {
+	ev := MessageEvent{Type: typ, Message: m, At: time.Now().UTC()}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for s := range h.clients {
+		select {
+		case s.events <- ev:
+		default:
+			s.evicted = true
+			delete(h.clients, s)
+			close(s.events)
+		}
+	}
+}
+
+var (
+	events   = newHub(64)
+	upgrader = websocket.Upgrader{
+		ReadBufferSize:  1024,
+		WriteBufferSize: 1024,
+		// demo only: accept any origin so the Swagger UI host and local pages can connect
+		CheckOrigin: func(r *http.Request) bool { return true },
+	}
+)
+
+const (
+	writeWait  = 5 * time.Second
+	pongWait   = 60 * time.Second
+	pingPeriod = pongWait * 9 / 10
+)
+
+// @Summary      Stream message changes
+// @Description  Upgrades to a WebSocket and pushes a JSON MessageEvent for every create, update and delete. Clients that fall more than 64 events behind are disconnected with close code 1008 and should reconnect and re-list.
+// @Tags         messages
+// @Produce      json
+// @Success      101 {object} MessageEvent "Switching Protocols; each frame is a MessageEvent"
+// @Failure      400 {object} map[string]string
+// @Router       /messages/stream [get]
+func streamMessages(c *gin.Context) {
+	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		return // Upgrade has already written the error response
+	}
+	sub := events.subscribe()
+	defer events.unsubscribe(sub)
+	defer conn.Close()
+
+	// The reader only handles control frames; it ends when the client goes away.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		conn.SetReadLimit(512)
+		_ = conn.SetReadDeadline(time.Now().Add(pongWait))
+		conn.SetPongHandler(func(string) error { return conn.SetReadDeadline(time.Now().Add(pongWait)) })
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	ping := time.NewTicker(pingPeriod)
+	defer ping.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case ev, ok := <-sub.events:
+			_ = conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if !ok {
+				if sub.evicted {
+					log.Printf("stream: evicting slow client %s", c.ClientIP())
+				}
+				_ = conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "too slow"))
+				return
+			}
+			if err := conn.WriteJSON(ev); err != nil {
+				return
+			}
+		case <-ping.C:
+			_ = conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
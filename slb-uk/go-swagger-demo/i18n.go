@@ -0,0 +1,133 @@
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/text/language"
+)
+
+// Message catalogs live in locales/<lang>.json. Each key has plural forms;
+// "other" is required, "one" is used where the language's plural rule says
+// so. A key missing from a catalog falls back to English, and an unknown key
+// renders as the key itself, so a half-translated catalog still works.
+
+//go:embed locales/*.json
+var localeFiles embed.FS
+
+type forms struct {
+	One   string `json:"one"`
+	Other string `json:"other"`
+}
+
+type catalog map[string]forms
+
+var (
+	fallbackLang = language.English
+	catalogs     = map[language.Tag]catalog{}
+	matcher      language.Matcher
+)
+
+func init() {
+	entries, err := localeFiles.ReadDir("locales")
+	if err != nil {
+		panic(err)
+	}
+	// the fallback must be first: the matcher returns it when nothing matches
+	tags := []language.Tag{fallbackLang}
+	for _, e := range entries {
+		b, err := localeFiles.ReadFile(path.Join("locales", e.Name()))
+		if err != nil {
+			panic(err)
+		}
+		var cat catalog
+		if err := json.Unmarshal(b, &cat); err != nil {
+			panic(fmt.Sprintf("locales/%s: %v", e.Name(), err))
+		}
+		tag := language.MustParse(strings.TrimSuffix(e.Name(), ".json"))
+		catalogs[tag] = cat
+		if tag != fallbackLang {
+			tags = append(tags, tag)
+		}
+	}
+	matcher = language.NewMatcher(tags)
+}
+
+// pluralOne reports whether n takes the "one" form. CLDR puts 0 in "one"
+// for French and Hindi but not for English.
+func pluralOne(lang language.Tag, n int) bool {
+	switch base, _ := lang.Base(); base.String() {
+	case "fr", "hi":
+		return n == 0 || n == 1
+	default:
+		return n == 1
+	}
+}
+
+// localizer picks the response language from ?lang= or Accept-Language
+// (e.g. "fr-CA, hi;q=0.8" -> fr) and sets Content-Language.
+func localizer() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		prefs := []string{c.Query("lang"), c.GetHeader("Accept-Language")}
+		tag, _ := language.MatchStrings(matcher, prefs...)
+		base, _ := tag.Base()
+		lang := language.Make(base.String())
+		if _, ok := catalogs[lang]; !ok {
+			lang = fallbackLang
+		}
+		c.Set("lang", lang)
+		c.Header("Content-Language", lang.String())
+		c.Header("Vary", "Accept-Language")
+		c.Next()
+	}
+}
+
+func langOf(c *gin.Context) language.Tag {
+	if v, ok := c.Get("lang"); ok {
+		return v.(language.Tag)
+	}
+	return fallbackLang
+}
+
+// T translates key for the request's language.
+func T(c *gin.Context, key string, args ...interface{}) string {
+	return lookup(langOf(c), key, false, args...)
+}
+
+// Tn translates key using the plural form for n; n is the first format arg.
+func Tn(c *gin.Context, key string, n int) string {
+	lang := langOf(c)
+	return lookup(lang, key, pluralOne(lang, n), n)
+}
+
+func lookup(lang language.Tag, key string, one bool, args ...interface{}) string {
+	for _, l := range []language.Tag{lang, fallbackLang} {
+		f, ok := catalogs[l][key]
+		if !ok {
+			continue
+		}
+		s := f.Other
+		if one && f.One != "" {
+			s = f.One
+		}
+		if len(args) > 0 {
+			return fmt.Sprintf(s, args...)
+		}
+		return s
+	}
+	return key
+}
+
+// apiError renders a localized error with a stable, untranslated code and
+// the request's trace ID.
+func apiError(c *gin.Context, status int, key string, args ...interface{}) {
+	body := gin.H{"code": key, "error": T(c, key, args...)}
+	if id := traceID(c); id != "" {
+		body["trace_id"] = id // to quote to support
+	}
+	c.JSON(status, body)
+}
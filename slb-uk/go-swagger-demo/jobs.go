@@ -0,0 +1,290 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Job states. queued and running are the only ones that still change.
+const (
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+	JobCanceled  = "canceled"
+)
+
+// Job is the status resource of a long-running operation. Result is set
+// once the job has succeeded, Error once it has failed.
+type Job struct {
+	ID        string    `json:"id" example:"9f86d081884c7d65"`
+	Kind      string    `json:"kind" example:"report"`
+	Status    string    `json:"status" example:"running" enums:"queued,running,succeeded,failed,canceled"`
+	Progress  int       `json:"progress" example:"40"` // percent
+	Result    *Report   `json:"result,omitempty"`
+	Error     string    `json:"error,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func (j Job) done() bool { return j.Status != JobQueued && j.Status != JobRunning }
+
+// ReportRequest selects what a report aggregates.
+type ReportRequest struct {
+	Contains string `json:"contains" example:"hello"` // only messages containing this, case-insensitive; empty means all
+	TopWords int    `json:"top_words" example:"5"`    // default 5
+}
+
+// Report is the result of a report job.
+type Report struct {
+	Messages  int         `json:"messages" example:"2"`
+	Words     int         `json:"words" example:"2"`
+	AvgLength float64     `json:"avg_length" example:"6"`
+	Longest   *Message    `json:"longest,omitempty"`
+	TopWords  []WordCount `json:"top_words"`
+}
+
+// WordCount is one entry of Report.TopWords.
+type WordCount struct {
+	Word  string `json:"word" example:"hello"`
+	Count int    `json:"count" example:"1"`
+}
+
+// jobManager runs jobs in their own goroutines and keeps their status for
+// polling. Finished jobs are kept for ttl so clients that poll late still
+// get the result, then dropped on the next submit.
+type jobManager struct {
+	mu      sync.Mutex
+	jobs    map[string]*jobEntry
+	ttl     time.Duration
+	perItem time.Duration // simulated cost of aggregating one message
+}
+
+type jobEntry struct {
+	Job
+	cancel context.CancelFunc
+}
+
+func newJobManager(ttl, perItem time.Duration) *jobManager {
+	return &jobManager{jobs: map[string]*jobEntry{}, ttl: ttl, perItem: perItem}
+}
+
+// submit registers a job and starts run. run reports progress through the
+// callback and must return promptly once ctx is canceled.
+func (m *jobManager) submit(kind string, run func(ctx context.Context, progress func(int)) (*Report, error)) Job {
+	ctx, cancel := context.WithCancel(context.Background())
+	now := time.Now().UTC()
+	e := &jobEntry{Job: Job{ID: newJobID(), Kind: kind, Status: JobQueued, CreatedAt: now, UpdatedAt: now}, cancel: cancel}
+
+	m.mu.Lock()
+	m.prune(now)
+	m.jobs[e.ID] = e
+	m.mu.Unlock()
+
+	go func() {
+		defer cancel()
+		m.update(e.ID, func(j *Job) { j.Status = JobRunning })
+		res, err := run(ctx, func(p int) {
+			m.update(e.ID, func(j *Job) { j.Progress = p })
+		})
+		m.update(e.ID, func(j *Job) {
+			switch {
+			case ctx.Err() != nil:
+				j.Status = JobCanceled
+			case err != nil:
+				j.Status, j.Error = JobFailed, err.Error()
+			default:
+				j.Status, j.Progress, j.Result = JobSucceeded, 100, res
+			}
+		})
+	}()
+	return e.Job
+}
+
+// update applies f unless the job has already finished (e.g. it was
+// canceled while run was still returning).
+func (m *jobManager) update(id string, f func(*Job)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if e, ok := m.jobs[id]; ok && !e.done() {
+		f(&e.Job)
+		e.UpdatedAt = time.Now().UTC()
+	}
+}
+
+func (m *jobManager) get(id string) (Job, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	return e.Job, true
+}
+
+// cancel stops a queued or running job. canceled is false when the job
+// had already finished; it is then returned unchanged.
+func (m *jobManager) cancel(id string) (j Job, found, canceled bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.jobs[id]
+	if !ok {
+		return Job{}, false, false
+	}
+	if e.done() {
+		return e.Job, true, false
+	}
+	e.cancel()
+	e.Status = JobCanceled
+	e.UpdatedAt = time.Now().UTC()
+	return e.Job, true, true
+}
+
+func (m *jobManager) prune(now time.Time) {
+	for id, e := range m.jobs {
+		if e.done() && now.Sub(e.UpdatedAt) > m.ttl {
+			delete(m.jobs, id)
+		}
+	}
+}
+
+func newJobID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+var jobs = newJobManager(10*time.Minute, 500*time.Millisecond)
+
+// buildReport aggregates msgs, pausing perItem per message to stand in for
+// real work (a scan over a large table, a call to another service, ...).
+func buildReport(ctx context.Context, msgs []Message, req ReportRequest, perItem time.Duration, progress func(int)) (*Report, error) {
+	r := &Report{TopWords: []WordCount{}}
+	counts := map[string]int{}
+	totalLen := 0
+	for i, m := range msgs {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(perItem):
+		}
+		progress((i + 1) * 100 / (len(msgs) + 1))
+
+		if req.Contains != "" && !strings.Contains(strings.ToLower(m.Message), strings.ToLower(req.Contains)) {
+			continue
+		}
+		r.Messages++
+		totalLen += len([]rune(m.Message))
+		if r.Longest == nil || len([]rune(m.Message)) > len([]rune(r.Longest.Message)) {
+			m := m
+			r.Longest = &m
+		}
+		for _, w := range strings.Fields(strings.ToLower(m.Message)) {
+			r.Words++
+			counts[w]++
+		}
+	}
+	if r.Messages > 0 {
+		r.AvgLength = float64(totalLen) / float64(r.Messages)
+	}
+	for w, n := range counts {
+		r.TopWords = append(r.TopWords, WordCount{w, n})
+	}
+	sort.Slice(r.TopWords, func(i, j int) bool {
+		if r.TopWords[i].Count != r.TopWords[j].Count {
+			return r.TopWords[i].Count > r.TopWords[j].Count
+		}
+		return r.TopWords[i].Word < r.TopWords[j].Word
+	})
+	if len(r.TopWords) > req.TopWords {
+		r.TopWords = r.TopWords[:req.TopWords]
+	}
+	return r, nil
+}
+
+// writeJob renders a job with the headers pollers need: Location always,
+// Retry-After while it is still running.
+func writeJob(c *gin.Context, status int, j Job) {
+	c.Header("Location", "/v1/jobs/"+j.ID)
+	if !j.done() {
+		c.Header("Retry-After", "1")
+	}
+	c.JSON(status, j)
+}
+
+// @Summary      Start a report
+// @Description  Starts an asynchronous aggregation over the messages as they are now and returns 202 with the job to poll. Follow the Location header (or GET /jobs/{id}) until status is succeeded, failed or canceled; Retry-After suggests the polling interval.
+// @Tags         jobs
+// @Accept       json
+// @Produce      json
+// @Param        payload body ReportRequest false "Filter and options"
+// @Success      202 {object} Job
+// @Header       202 {string} Location "URL of the job status resource"
+// @Failure      400 {object} map[string]string
+// @Router       /reports [post]
+func createReport(c *gin.Context) {
+	var req ReportRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil || req.TopWords < 0 {
+			apiError(c, http.StatusBadRequest, "invalid_payload")
+			return
+		}
+	}
+	if req.TopWords == 0 {
+		req.TopWords = 5
+	}
+
+	// snapshot now: the job must not read the store while handlers write it
+	msgs := store.List(c.Request.Context())
+
+	j := jobs.submit("report", func(ctx context.Context, progress func(int)) (*Report, error) {
+		return buildReport(ctx, msgs, req, jobs.perItem, progress)
+	})
+	writeJob(c, http.StatusAccepted, j)
+}
+
+// @Summary      Get job status
+// @Description  Returns the job's status and progress, and its result once it has succeeded. Finished jobs are kept for 10 minutes.
+// @Tags         jobs
+// @Produce      json
+// @Param        id   path      string  true  "Job ID"
+// @Success      200 {object} Job
+// @Failure      404 {object} map[string]string
+// @Router       /jobs/{id} [get]
+func getJob(c *gin.Context) {
+	j, ok := jobs.get(c.Param("id"))
+	if !ok {
+		apiError(c, http.StatusNotFound, "job_not_found", c.Param("id"))
+		return
+	}
+	writeJob(c, http.StatusOK, j)
+}
+
+// @Summary      Cancel a job
+// @Description  Cancels a queued or running job. Canceling a job that has already finished returns 409 with the job unchanged.
+// @Tags         jobs
+// @Produce      json
+// @Param        id   path      string  true  "Job ID"
+// @Success      200 {object} Job
+// @Failure      404 {object} map[string]string
+// @Failure      409 {object} Job
+// @Router       /jobs/{id} [delete]
+func cancelJob(c *gin.Context) {
+	j, found, canceled := jobs.cancel(c.Param("id"))
+	switch {
+	case !found:
+		apiError(c, http.StatusNotFound, "job_not_found", c.Param("id"))
+	case !canceled:
+		writeJob(c, http.StatusConflict, j)
+	default:
+		writeJob(c, http.StatusOK, j)
+	}
+}
@@ -2,8 +2,15 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"log"
 	"net/http"
+	"os"
+	"os/signal"
 	"strconv"
+	"syscall"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	_ "github.com/slb-uk/go-swagger-demo/docs"
@@ -16,11 +23,6 @@ type Message struct {
     Message string `json:"message" example:"hello world"`
 }
 
-var store = map[int]Message{
-    1: {ID: 1, Message: "hello"},
-    2: {ID: 2, Message: "namaste"},
-}
-
 // @title           Messages API
 // @version         1.0
 // @description     A simple demo API documented with Swagger 2.0 annotations.
@@ -33,30 +35,56 @@ var store = map[int]Message{
 // @host      localhost:8080
 // @BasePath  /v1
 func main() {
+    ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+    defer stop()
+    shutdownTracing := initTracing(ctx)
+    defer shutdownTracing()
+
     r := gin.Default()
+    r.Use(tracing()...)
+    r.Use(localizer())
     r.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
 
     v1 := r.Group("/v1")
     {
         v1.GET("/hello", helloHandler)
         v1.GET("/messages", listMessages)
+        v1.GET("/messages/stream", streamMessages)
         v1.GET("/message/:id", getMessageByID)
         v1.POST("/message", createMessage)
         v1.PUT("/message/:id", updateMessage)
         v1.DELETE("/message/:id", deleteMessage)
+        v1.POST("/reports", createReport)
+        v1.GET("/jobs/:id", getJob)
+        v1.DELETE("/jobs/:id", cancelJob)
     }
 
-    r.Run(":8080")
+    srv := &http.Server{Addr: ":8080", Handler: r}
+    go func() {
+        <-ctx.Done()
+        sctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+        defer cancel()
+        srv.Shutdown(sctx)
+    }()
+    log.Printf("listening on %s", srv.Addr)
+    if err := srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+        log.Fatal(err)
+    }
 }
 
 // @Summary      Welcome
-// @Description  Returns a friendly welcome message.
+// @Description  Returns a friendly welcome message in the negotiated language (en, fr, hi).
 // @Tags         misc
 // @Produce      json
+// @Param        Accept-Language header string false "Preferred languages, e.g. fr-CA, hi;q=0.8"
+// @Param        lang query string false "Overrides Accept-Language"
 // @Success      200 {object} map[string]string
 // @Router       /hello [get]
 func helloHandler(c *gin.Context) {
-    c.JSON(http.StatusOK, gin.H{"message": "Welcome to Messages API"})
+    c.JSON(http.StatusOK, gin.H{
+        "message": T(c, "welcome"),
+        "stored":  Tn(c, "messages_stored", store.Count(c.Request.Context())),
+    })
 }
 
 // @securityDefinitions.apikey BearerAuth
@@ -67,11 +95,7 @@ func helloHandler(c *gin.Context) {
 // @Success      200 {array} Message
 // @Router       /messages [get]
 func listMessages(c *gin.Context) {
-    out := make([]Message, 0, len(store))
-    for _, m := range store {
-        out = append(out, m)
-    }
-    c.JSON(http.StatusOK, out)
+    c.JSON(http.StatusOK, store.List(c.Request.Context()))
 }
 
 // @securityDefinitions.apikey BearerAuth
@@ -85,14 +109,28 @@ func listMessages(c *gin.Context) {
 // @Router       /message/{id} [get]
 func getMessageByID(c *gin.Context) {
     id, _ := strconv.Atoi(c.Param("id"))
-    m, ok := store[id]
+    m, ok := store.Get(c.Request.Context(), id)
     if !ok {
-        c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+        apiError(c, http.StatusNotFound, "not_found", id)
         return
     }
     c.JSON(http.StatusOK, m)
 }
 
+// bindMessage decodes and validates a Message body, writing a localized 400
+// when it is unusable.
+func bindMessage(c *gin.Context, in *Message) bool {
+    if err := c.ShouldBindJSON(in); err != nil {
+        apiError(c, http.StatusBadRequest, "invalid_payload")
+        return false
+    }
+    if in.Message == "" {
+        apiError(c, http.StatusBadRequest, "message_required")
+        return false
+    }
+    return true
+}
+
 // @securityDefinitions.apikey BearerAuth
 // @Summary      Create message
 // @Description  Create and store a new message.
@@ -105,13 +143,11 @@ func getMessageByID(c *gin.Context) {
 // @Router       /message [post]
 func createMessage(c *gin.Context) {
     var in Message
-    if err := c.ShouldBindJSON(&in); err != nil || in.Message == "" {
-        c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload"})
+    if !bindMessage(c, &in) {
         return
     }
-    next := len(store) + 1
-    in.ID = next
-    store[next] = in
+    in = store.Create(c.Request.Context(), in)
+    events.publish("created", in)
     c.JSON(http.StatusCreated, in)
 }
 
@@ -129,18 +165,20 @@ func createMessage(c *gin.Context) {
 // @Router       /message/{id} [put]
 func updateMessage(c *gin.Context) {
     id, _ := strconv.Atoi(c.Param("id"))
-    _, ok := store[id]
-    if !ok {
-        c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+    if _, ok := store.Get(c.Request.Context(), id); !ok {
+        apiError(c, http.StatusNotFound, "not_found", id)
         return
     }
     var in Message
-    if err := c.ShouldBindJSON(&in); err != nil || in.Message == "" {
-        c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload"})
+    if !bindMessage(c, &in) {
         return
     }
     in.ID = id
-    store[id] = in
+    if !store.Update(c.Request.Context(), in) {
+        apiError(c, http.StatusNotFound, "not_found", id)
+        return
+    }
+    events.publish("updated", in)
     c.JSON(http.StatusOK, in)
 }
 
@@ -155,10 +193,11 @@ func updateMessage(c *gin.Context) {
 // @Router       /message/{id} [delete]
 func deleteMessage(c *gin.Context) {
     id, _ := strconv.Atoi(c.Param("id"))
-    if _, ok := store[id]; !ok {
-        c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+    m, ok := store.Delete(c.Request.Context(), id)
+    if !ok {
+        apiError(c, http.StatusNotFound, "not_found", id)
         return
     }
-    delete(store, id)
+    events.publish("deleted", m)
     c.Status(http.StatusNoContent)
 }
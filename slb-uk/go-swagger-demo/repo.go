@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// messageRepo is the message store. It stands in for a database table, and
+// each operation gets a client span the way a database driver's would, so
+// a trace shows the storage work under the request that caused it.
+type messageRepo struct {
+	mu     sync.RWMutex
+	rows   map[int]Message
+	nextID int
+}
+
+func newMessageRepo(seed ...Message) *messageRepo {
+	r := &messageRepo{rows: map[int]Message{}}
+	for _, m := range seed {
+		r.rows[m.ID] = m
+		r.nextID = max(r.nextID, m.ID)
+	}
+	return r
+}
+
+var store = newMessageRepo(
+	Message{ID: 1, Message: "hello"},
+	Message{ID: 2, Message: "namaste"},
+)
+
+// span starts the span of one operation on the messages table; end records
+// how many rows it returned (select, count) or changed.
+func (r *messageRepo) span(ctx context.Context, op string, attrs ...attribute.KeyValue) (context.Context, func(rows int)) {
+	ctx, span := tracer.Start(ctx, "messages."+op, trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(append(attrs,
+			attribute.String("db.system", "memory"),
+			attribute.String("db.operation.name", op),
+			attribute.String("db.collection.name", "messages"))...))
+	rowsKey := "db.rows_affected"
+	if op == "select" || op == "count" {
+		rowsKey = "db.response.returned_rows"
+	}
+	return ctx, func(rows int) {
+		span.SetAttributes(attribute.Int(rowsKey, rows))
+		span.End()
+	}
+}
+
+// List returns every message, ordered by ID.
+func (r *messageRepo) List(ctx context.Context) []Message {
+	_, end := r.span(ctx, "select")
+	r.mu.RLock()
+	out := make([]Message, 0, len(r.rows))
+	for _, m := range r.rows {
+		out = append(out, m)
+	}
+	r.mu.RUnlock()
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	end(len(out))
+	return out
+}
+
+// Count returns how many messages there are.
+func (r *messageRepo) Count(ctx context.Context) int {
+	_, end := r.span(ctx, "count")
+	r.mu.RLock()
+	n := len(r.rows)
+	r.mu.RUnlock()
+	end(1)
+	return n
+}
+
+func (r *messageRepo) Get(ctx context.Context, id int) (Message, bool) {
+	_, end := r.span(ctx, "select", attribute.Int("message.id", id))
+	r.mu.RLock()
+	m, ok := r.rows[id]
+	r.mu.RUnlock()
+	end(b2i(ok))
+	return m, ok
+}
+
+// Create stores m under the next free ID and returns it.
+func (r *messageRepo) Create(ctx context.Context, m Message) Message {
+	ctx, end := r.span(ctx, "insert")
+	r.mu.Lock()
+	r.nextID++
+	m.ID = r.nextID
+	r.rows[m.ID] = m
+	r.mu.Unlock()
+	trace.SpanFromContext(ctx).SetAttributes(attribute.Int("message.id", m.ID))
+	end(1)
+	return m
+}
+
+// Update replaces message m.ID; it reports false when there is none.
+func (r *messageRepo) Update(ctx context.Context, m Message) bool {
+	_, end := r.span(ctx, "update", attribute.Int("message.id", m.ID))
+	r.mu.Lock()
+	_, ok := r.rows[m.ID]
+	if ok {
+		r.rows[m.ID] = m
+	}
+	r.mu.Unlock()
+	end(b2i(ok))
+	return ok
+}
+
+// Delete removes message id and returns it.
+func (r *messageRepo) Delete(ctx context.Context, id int) (Message, bool) {
+	_, end := r.span(ctx, "delete", attribute.Int("message.id", id))
+	r.mu.Lock()
+	m, ok := r.rows[id]
+	delete(r.rows, id)
+	r.mu.Unlock()
+	end(b2i(ok))
+	return m, ok
+}
+
+func b2i(ok bool) int {
+	if ok {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const serviceName = "go-swagger-demo"
+
+var tracer = otel.Tracer("github.com/slb-uk/go-swagger-demo")
+
+// initTracing installs the global tracer provider and returns its shutdown,
+// which flushes spans not exported yet.
+//
+// Spans are always recorded, so every response has a trace ID to quote to
+// support. They are only exported when an OTLP endpoint is configured with
+// the standard variables (OTEL_EXPORTER_OTLP_ENDPOINT or
+// OTEL_EXPORTER_OTLP_TRACES_ENDPOINT, plus OTEL_EXPORTER_OTLP_HEADERS etc.),
+// over OTLP/HTTP. OTEL_SERVICE_NAME, OTEL_RESOURCE_ATTRIBUTES and
+// OTEL_TRACES_SAMPLER apply as usual.
+func initTracing(ctx context.Context) func() {
+	res, err := resource.New(ctx,
+		resource.WithAttributes(semconv.ServiceName(serviceName)),
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK())
+	if err != nil {
+		log.Printf("tracing: resource: %v", err)
+	}
+	opts := []sdktrace.TracerProviderOption{sdktrace.WithResource(res)}
+	if os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") != "" || os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") != "" {
+		exp, err := otlptracehttp.New(ctx)
+		if err != nil {
+			log.Fatalf("tracing: otlp exporter: %v", err)
+		}
+		opts = append(opts, sdktrace.WithBatcher(exp))
+		log.Printf("tracing: exporting spans over OTLP/HTTP")
+	}
+	tp := sdktrace.NewTracerProvider(opts...)
+	otel.SetTracerProvider(tp)
+	// a caller's traceparent continues its trace
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := tp.Shutdown(ctx); err != nil {
+			log.Printf("tracing: shutdown: %v", err)
+		}
+	}
+}
+
+// tracing starts a server span per request, named after the route, and
+// sends its trace ID back in X-Trace-Id. The Swagger UI is left out.
+func tracing() []gin.HandlerFunc {
+	return []gin.HandlerFunc{
+		otelgin.Middleware(serviceName, otelgin.WithFilter(func(r *http.Request) bool {
+			return !strings.HasPrefix(r.URL.Path, "/swagger/")
+		})),
+		func(c *gin.Context) {
+			if id := traceID(c); id != "" {
+				c.Header("X-Trace-Id", id)
+			}
+			c.Next()
+		},
+	}
+}
+
+// traceID is the ID of the request's trace, or "" outside one.
+func traceID(c *gin.Context) string {
+	if sc := trace.SpanContextFromContext(c.Request.Context()); sc.HasTraceID() {
+		return sc.TraceID().String()
+	}
+	return ""
+}
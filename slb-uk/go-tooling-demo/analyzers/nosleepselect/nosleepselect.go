@@ -0,0 +1,130 @@
+// Package nosleepselect defines an Analyzer that reports time.Sleep inside
+// consumer loops.
+//
+// A consumer loop is a for/range loop that pulls work: it ranges over a
+// channel, receives from one, selects, or calls a read method such as
+// ReadMessage or Recv. Sleeping there (usually as a crude backoff after an
+// error) blocks shutdown for the whole sleep, because time.Sleep cannot be
+// interrupted. Select on the context and a timer instead:
+//
+//	select {
+//	case <-ctx.Done():
+//		return ctx.Err()
+//	case <-time.After(backoff):
+//	}
+package nosleepselect
+
+import (
+	"go/ast"
+	"go/token"
+	"go/types"
+	"strings"
+
+	"golang.org/x/tools/go/analysis"
+	"golang.org/x/tools/go/analysis/passes/inspect"
+	"golang.org/x/tools/go/ast/inspector"
+	"golang.org/x/tools/go/types/typeutil"
+)
+
+var Analyzer = &analysis.Analyzer{
+	Name:     "nosleepselect",
+	Doc:      "report time.Sleep in consumer loops, where it blocks cancellation; use select with ctx.Done() and a timer",
+	URL:      "https://pkg.go.dev/example.com/go-tooling-demo/analyzers/nosleepselect",
+	Requires: []*analysis.Analyzer{inspect.Analyzer},
+	Run:      run,
+}
+
+// methods names the calls that make a loop a consumer loop; -methods
+// replaces the list.
+var methods = "ReadMessage,FetchMessage,Consume,ConsumePartition,Recv,Receive,Poll,ReadFrom"
+
+func init() {
+	Analyzer.Flags.StringVar(&methods, "methods", methods, "comma-separated method names that read work in a consumer loop")
+}
+
+func run(pass *analysis.Pass) (any, error) {
+	readers := map[string]bool{}
+	for _, m := range strings.Split(methods, ",") {
+		if m = strings.TrimSpace(m); m != "" {
+			readers[m] = true
+		}
+	}
+
+	insp := pass.ResultOf[inspect.Analyzer].(*inspector.Inspector)
+	reported := map[token.Pos]bool{} // nested consumer loops see the same call
+	insp.Preorder([]ast.Node{(*ast.ForStmt)(nil), (*ast.RangeStmt)(nil)}, func(n ast.Node) {
+		var body *ast.BlockStmt
+		consumer := false
+		switch loop := n.(type) {
+		case *ast.ForStmt:
+			body = loop.Body
+		case *ast.RangeStmt:
+			body = loop.Body
+			if t, ok := pass.TypesInfo.TypeOf(loop.X).Underlying().(*types.Chan); ok && t.Dir() != types.SendOnly {
+				consumer = true
+			}
+		}
+		if !consumer {
+			consumer = consumes(pass, body, readers)
+		}
+		if !consumer {
+			return
+		}
+		inspectBody(body, func(call *ast.CallExpr) {
+			if isTimeSleep(pass, call) && !reported[call.Pos()] {
+				reported[call.Pos()] = true
+				pass.Report(analysis.Diagnostic{
+					Pos:     call.Pos(),
+					End:     call.End(),
+					Message: "time.Sleep in consumer loop ignores cancellation; select on ctx.Done() and time.After instead",
+				})
+			}
+		})
+	})
+	return nil, nil
+}
+
+// consumes reports whether body receives from a channel, selects, or calls
+// one of the reader methods (outside nested function literals).
+func consumes(pass *analysis.Pass, body *ast.BlockStmt, readers map[string]bool) bool {
+	found := false
+	ast.Inspect(body, func(n ast.Node) bool {
+		if found {
+			return false
+		}
+		switch n := n.(type) {
+		case *ast.FuncLit:
+			return false
+		case *ast.SelectStmt:
+			found = true
+		case *ast.UnaryExpr:
+			found = n.Op == token.ARROW
+		case *ast.CallExpr:
+			if fn, ok := typeutil.Callee(pass.TypesInfo, n).(*types.Func); ok {
+				sig := fn.Type().(*types.Signature)
+				found = sig.Recv() != nil && readers[fn.Name()]
+			}
+		}
+		return !found
+	})
+	return found
+}
+
+// inspectBody calls fn for every call in body, skipping function literals:
+// a goroutine or callback started from the loop is not the loop itself.
+func inspectBody(body *ast.BlockStmt, fn func(*ast.CallExpr)) {
+	ast.Inspect(body, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.FuncLit:
+			return false
+		case *ast.CallExpr:
+			fn(n)
+		}
+		return true
+	})
+}
+
+func isTimeSleep(pass *analysis.Pass, call *ast.CallExpr) bool {
+	fn, ok := typeutil.Callee(pass.TypesInfo, call).(*types.Func)
+	return ok && fn.Pkg() != nil && fn.Pkg().Path() == "time" && fn.Name() == "Sleep"
+}
@@ -0,0 +1,13 @@
+package nosleepselect_test
+
+import (
+	"testing"
+
+	"golang.org/x/tools/go/analysis/analysistest"
+
+	"example.com/go-tooling-demo/analyzers/nosleepselect"
+)
+
+func TestAnalyzer(t *testing.T) {
+	analysistest.Run(t, analysistest.TestData(), nosleepselect.Analyzer, "a")
+}
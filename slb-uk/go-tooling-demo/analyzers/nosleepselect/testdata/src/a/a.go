@@ -0,0 +1,115 @@
+package a
+
+import (
+	"context"
+	"time"
+)
+
+type reader struct{}
+
+func (reader) ReadMessage(ctx context.Context) (string, error) { return "", nil }
+
+type stream struct{}
+
+func (stream) Recv() (string, error) { return "", nil }
+
+func readLoop(ctx context.Context, r reader) {
+	for {
+		if _, err := r.ReadMessage(ctx); err != nil {
+			time.Sleep(time.Second) // want `time.Sleep in consumer loop`
+			continue
+		}
+	}
+}
+
+func recvLoop(s stream) {
+	for {
+		if _, err := s.Recv(); err != nil {
+			return
+		}
+		time.Sleep(10 * time.Millisecond) // want `time.Sleep in consumer loop`
+	}
+}
+
+type group struct{}
+
+func (group) Consume(ctx context.Context, topics []string) error { return nil }
+
+// The sarama consumer-group loop in rest-go-webservice's consumersvc.
+func consumeLoop(g group) {
+	for {
+		if err := g.Consume(nil, []string{"cmd"}); err != nil {
+			time.Sleep(time.Second) // want `time.Sleep in consumer loop`
+		}
+	}
+}
+
+func rangeChan(ch <-chan int) {
+	for range ch {
+		time.Sleep(time.Millisecond) // want `time.Sleep in consumer loop`
+	}
+}
+
+func receiveInBody(ch chan int) {
+	for i := 0; i < 3; i++ {
+		<-ch
+		time.Sleep(time.Millisecond) // want `time.Sleep in consumer loop`
+	}
+}
+
+func selectLoop(ctx context.Context, ch chan int) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ch:
+		}
+		for j := 0; j < 2; j++ {
+			time.Sleep(time.Millisecond) // want `time.Sleep in consumer loop`
+		}
+	}
+}
+
+// Not consumer loops.
+
+func retries() {
+	for i := 0; i < 3; i++ {
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func rangeSlice(xs []int) {
+	for range xs {
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func goroutinePerMessage(ch chan int) {
+	for range ch {
+		go func() {
+			time.Sleep(time.Millisecond) // runs off the loop
+		}()
+	}
+}
+
+func cancellableBackoff(ctx context.Context, r reader) {
+	for {
+		if _, err := r.ReadMessage(ctx); err != nil {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(time.Second):
+			}
+		}
+	}
+}
+
+type sleeper struct{}
+
+func (sleeper) Sleep(d time.Duration) {}
+
+func notTimeSleep(ch chan int, s sleeper) {
+	for range ch {
+		s.Sleep(time.Second)
+	}
+}
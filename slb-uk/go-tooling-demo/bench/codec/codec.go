@@ -0,0 +1,193 @@
+// Package codec compares the wire formats the sub-projects use for their
+// Kafka messages: encoding/json, json-iterator and protobuf, on the
+// Command, Ack and Event shapes they actually send (see fixtures/).
+//
+//	go test ./bench/codec -run '^$' -bench . -benchmem -count 6 | go run ./cmd/benchsummary
+//
+// The benchmarks marshal and unmarshal each fixture with each Codec and
+// report ns/op, B/op, allocs/op and the encoded size (B/msg); benchsummary
+// turns that output into a table relative to encoding/json.
+package codec
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"example.com/go-tooling-demo/bench/codec/codecpb"
+)
+
+// Command is a request published by rest-go-webservice's apisvc.
+type Command struct {
+	TraceID  string         `json:"trace_id"`
+	Command  string         `json:"command"`
+	Resource string         `json:"resource"`
+	Payload  map[string]any `json:"payload"`
+	Metadata map[string]any `json:"metadata,omitempty"`
+}
+
+// Ack is consumersvc's reply to a Command.
+type Ack struct {
+	TraceID  string         `json:"trace_id"`
+	Status   string         `json:"status"`
+	Event    string         `json:"event"`
+	Payload  map[string]any `json:"payload,omitempty"`
+	Error    *AckError      `json:"error,omitempty"`
+	Replayed bool           `json:"replayed,omitempty"`
+	TenantID string         `json:"tenant_id,omitempty"`
+}
+
+// AckError is the failure of an Ack; its keys are capitalised on the wire.
+type AckError struct {
+	Code, Detail string
+}
+
+// Event is a step of a saga-choreo-lab saga.
+type Event struct {
+	SagaID        string         `json:"saga_id"`
+	Step          int            `json:"step"`
+	SchemaVersion int            `json:"schema_version"`
+	Ts            time.Time      `json:"ts"`
+	Payload       map[string]any `json:"payload"`
+	Priority      string         `json:"priority,omitempty"`
+}
+
+// A Codec encodes the message types above. Unmarshal takes a pointer to
+// one of them.
+type Codec interface {
+	Name() string
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte, v any) error
+}
+
+// Codecs are the codecs compared, encoding/json first: the summary is
+// relative to it.
+var Codecs = []Codec{JSON{}, JSONIter{}, Protobuf{}}
+
+// JSON is encoding/json, what every sub-project uses today.
+type JSON struct{}
+
+func (JSON) Name() string                       { return "json" }
+func (JSON) Marshal(v any) ([]byte, error)      { return json.Marshal(v) }
+func (JSON) Unmarshal(data []byte, v any) error { return json.Unmarshal(data, v) }
+
+// jsoniterStd behaves like encoding/json (sorted map keys, HTML escaping),
+// so it is a drop-in replacement and its output is byte-identical.
+var jsoniterStd = jsoniter.ConfigCompatibleWithStandardLibrary
+
+// JSONIter is github.com/json-iterator/go in its encoding/json compatible
+// configuration.
+type JSONIter struct{}
+
+func (JSONIter) Name() string                       { return "jsoniter" }
+func (JSONIter) Marshal(v any) ([]byte, error)      { return jsoniterStd.Marshal(v) }
+func (JSONIter) Unmarshal(data []byte, v any) error { return jsoniterStd.Unmarshal(data, v) }
+
+// Protobuf encodes through the codecpb messages. Payloads stay maps in
+// the Go types, so like rest-go-webservice's contracts.ProtobufCodec it
+// pays for converting them to and from google.protobuf.Struct; that cost
+// is part of what adopting protobuf means for these services.
+type Protobuf struct{}
+
+func (Protobuf) Name() string { return "protobuf" }
+
+func (Protobuf) Marshal(v any) ([]byte, error) {
+	m, err := toProto(v)
+	if err != nil {
+		return nil, err
+	}
+	return proto.Marshal(m)
+}
+
+func (Protobuf) Unmarshal(data []byte, v any) error {
+	switch v := v.(type) {
+	case *Command:
+		var m codecpb.Command
+		if err := proto.Unmarshal(data, &m); err != nil {
+			return err
+		}
+		*v = Command{TraceID: m.TraceId, Command: m.Command, Resource: m.Resource,
+			Payload: asMap(m.Payload), Metadata: asMap(m.Metadata)}
+	case *Ack:
+		var m codecpb.Ack
+		if err := proto.Unmarshal(data, &m); err != nil {
+			return err
+		}
+		*v = Ack{TraceID: m.TraceId, Status: m.Status, Event: m.Event, Payload: asMap(m.Payload),
+			Replayed: m.Replayed, TenantID: m.TenantId}
+		if m.Error != nil {
+			v.Error = &AckError{Code: m.Error.Code, Detail: m.Error.Detail}
+		}
+	case *Event:
+		var m codecpb.Event
+		if err := proto.Unmarshal(data, &m); err != nil {
+			return err
+		}
+		*v = Event{SagaID: m.SagaId, Step: int(m.Step), SchemaVersion: int(m.SchemaVersion),
+			Payload: asMap(m.Payload), Priority: m.Priority}
+		if m.Ts != nil {
+			v.Ts = m.Ts.AsTime()
+		}
+	default:
+		return fmt.Errorf("codec: protobuf cannot decode into %T", v)
+	}
+	return nil
+}
+
+func toProto(v any) (proto.Message, error) {
+	switch v := v.(type) {
+	case *Command:
+		payload, err := toStruct(v.Payload)
+		if err != nil {
+			return nil, err
+		}
+		meta, err := toStruct(v.Metadata)
+		if err != nil {
+			return nil, err
+		}
+		return &codecpb.Command{TraceId: v.TraceID, Command: v.Command, Resource: v.Resource,
+			Payload: payload, Metadata: meta}, nil
+	case *Ack:
+		payload, err := toStruct(v.Payload)
+		if err != nil {
+			return nil, err
+		}
+		m := &codecpb.Ack{TraceId: v.TraceID, Status: v.Status, Event: v.Event, Payload: payload,
+			Replayed: v.Replayed, TenantId: v.TenantID}
+		if v.Error != nil {
+			m.Error = &codecpb.Error{Code: v.Error.Code, Detail: v.Error.Detail}
+		}
+		return m, nil
+	case *Event:
+		payload, err := toStruct(v.Payload)
+		if err != nil {
+			return nil, err
+		}
+		m := &codecpb.Event{SagaId: v.SagaID, Step: int32(v.Step), SchemaVersion: int32(v.SchemaVersion),
+			Payload: payload, Priority: v.Priority}
+		if !v.Ts.IsZero() {
+			m.Ts = timestamppb.New(v.Ts)
+		}
+		return m, nil
+	}
+	return nil, fmt.Errorf("codec: protobuf cannot encode %T", v)
+}
+
+func toStruct(m map[string]any) (*structpb.Struct, error) {
+	if m == nil {
+		return nil, nil
+	}
+	return structpb.NewStruct(m)
+}
+
+func asMap(s *structpb.Struct) map[string]any {
+	if s == nil {
+		return nil
+	}
+	return s.AsMap()
+}
@@ -0,0 +1,105 @@
+package codec
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func fixtures(tb testing.TB) []Fixture {
+	tb.Helper()
+	fs, err := Fixtures()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return fs
+}
+
+func TestRoundTrip(t *testing.T) {
+	fs := fixtures(t)
+	if len(fs) == 0 {
+		t.Fatal("no fixtures")
+	}
+	for _, f := range fs {
+		for _, c := range Codecs {
+			data, err := c.Marshal(f.Value)
+			if err != nil {
+				t.Fatalf("%s/%s: marshal: %v", f.Name, c.Name(), err)
+			}
+			got := f.New()
+			if err := c.Unmarshal(data, got); err != nil {
+				t.Fatalf("%s/%s: unmarshal: %v", f.Name, c.Name(), err)
+			}
+			if !reflect.DeepEqual(got, f.Value) {
+				t.Fatalf("%s/%s: round trip changed the message:\n got %+v\nwant %+v", f.Name, c.Name(), got, f.Value)
+			}
+		}
+	}
+}
+
+// json-iterator is only a drop-in replacement if other services cannot
+// tell its output from encoding/json's.
+func TestJSONIterMatchesEncodingJSON(t *testing.T) {
+	for _, f := range fixtures(t) {
+		want, _ := JSON{}.Marshal(f.Value)
+		got, err := JSONIter{}.Marshal(f.Value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Fatalf("%s: jsoniter wrote\n%s\nencoding/json wrote\n%s", f.Name, got, want)
+		}
+	}
+}
+
+func TestProtobufUnknownType(t *testing.T) {
+	if _, err := (Protobuf{}).Marshal(struct{}{}); err == nil {
+		t.Fatal("Marshal(struct{}{}) succeeded")
+	}
+	if err := (Protobuf{}).Unmarshal(nil, new(int)); err == nil {
+		t.Fatal("Unmarshal into *int succeeded")
+	}
+}
+
+func BenchmarkMarshal(b *testing.B) {
+	for _, f := range fixtures(b) {
+		for _, c := range Codecs {
+			b.Run(f.Name+"/"+c.Name(), func(b *testing.B) {
+				data, err := c.Marshal(f.Value)
+				if err != nil {
+					b.Fatal(err)
+				}
+				b.ReportAllocs()
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					if _, err := c.Marshal(f.Value); err != nil {
+						b.Fatal(err)
+					}
+				}
+				b.ReportMetric(float64(len(data)), "B/msg")
+			})
+		}
+	}
+}
+
+func BenchmarkUnmarshal(b *testing.B) {
+	for _, f := range fixtures(b) {
+		for _, c := range Codecs {
+			b.Run(f.Name+"/"+c.Name(), func(b *testing.B) {
+				data, err := c.Marshal(f.Value)
+				if err != nil {
+					b.Fatal(err)
+				}
+				b.ReportAllocs()
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					// a fresh value each time, as a consumer decoding records does
+					if err := c.Unmarshal(data, f.New()); err != nil {
+						b.Fatal(err)
+					}
+				}
+				b.ReportMetric(float64(len(data)), "B/msg")
+			})
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package codec
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// Fixtures are captured messages, one per file, named <kind>_<case>.json
+// where kind is command, ack or event. They cover the common cases (a
+// create, a read result, an error) and the large ones (an audit page,
+// a saga event with line items).
+//
+//go:embed fixtures/*.json
+var fixtureFS embed.FS
+
+// A Fixture is a decoded message. Value is a *Command, *Ack or *Event;
+// New returns an empty one of the same type to decode into.
+type Fixture struct {
+	Name  string
+	Value any
+	New   func() any
+}
+
+var kinds = map[string]func() any{
+	"command": func() any { return new(Command) },
+	"ack":     func() any { return new(Ack) },
+	"event":   func() any { return new(Event) },
+}
+
+// Fixtures decodes every fixture, in file name order.
+func Fixtures() ([]Fixture, error) {
+	entries, err := fixtureFS.ReadDir("fixtures")
+	if err != nil {
+		return nil, err
+	}
+	var out []Fixture
+	for _, e := range entries {
+		name := strings.TrimSuffix(e.Name(), ".json")
+		kind, _, _ := strings.Cut(name, "_")
+		newFn, ok := kinds[kind]
+		if !ok {
+			return nil, fmt.Errorf("codec: fixture %s: unknown kind %q", e.Name(), kind)
+		}
+		data, err := fixtureFS.ReadFile(path.Join("fixtures", e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		v := newFn()
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(v); err != nil {
+			return nil, fmt.Errorf("codec: fixture %s: %w", e.Name(), err)
+		}
+		out = append(out, Fixture{Name: name, Value: v, New: newFn})
+	}
+	return out, nil
+}
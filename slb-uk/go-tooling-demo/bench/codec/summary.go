@@ -0,0 +1,156 @@
+package codec
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// A Result is one benchmark, Benchmark<Op>/<Fixture>/<Codec>, summarised
+// over its runs (-count) by the median of each metric.
+type Result struct {
+	Op, Fixture, Codec string
+	Runs               int
+	NsPerOp            float64
+	BytesPerOp         float64 // B/op, with -benchmem
+	AllocsPerOp        float64 // allocs/op, with -benchmem
+	MsgBytes           float64 // B/msg, the encoded size
+}
+
+// procSuffix is the -GOMAXPROCS suffix go test adds to benchmark names.
+var procSuffix = regexp.MustCompile(`-\d+$`)
+
+// ParseResults reads `go test -bench` output and returns one Result per
+// benchmark of this package, in the order they first appear. Other lines,
+// and benchmarks not named Op/Fixture/Codec, are skipped.
+func ParseResults(r io.Reader) ([]Result, error) {
+	type key struct{ op, fixture, codec string }
+	runs := map[key]map[string][]float64{}
+	var order []key
+
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		f := strings.Fields(sc.Text())
+		if len(f) < 4 || !strings.HasPrefix(f[0], "Benchmark") {
+			continue
+		}
+		parts := strings.Split(procSuffix.ReplaceAllString(strings.TrimPrefix(f[0], "Benchmark"), ""), "/")
+		if len(parts) != 3 {
+			continue
+		}
+		if _, err := strconv.Atoi(f[1]); err != nil {
+			continue // a "--- FAIL" or log line that happens to start with the name
+		}
+		k := key{parts[0], parts[1], parts[2]}
+		if runs[k] == nil {
+			runs[k] = map[string][]float64{}
+			order = append(order, k)
+		}
+		// after the iteration count come value-unit pairs
+		for i := 2; i+1 < len(f); i += 2 {
+			v, err := strconv.ParseFloat(f[i], 64)
+			if err != nil {
+				return nil, fmt.Errorf("codec: %s: bad value %q", f[0], f[i])
+			}
+			runs[k][f[i+1]] = append(runs[k][f[i+1]], v)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+
+	out := make([]Result, 0, len(order))
+	for _, k := range order {
+		m := runs[k]
+		out = append(out, Result{
+			Op: k.op, Fixture: k.fixture, Codec: k.codec,
+			Runs:        len(m["ns/op"]),
+			NsPerOp:     median(m["ns/op"]),
+			BytesPerOp:  median(m["B/op"]),
+			AllocsPerOp: median(m["allocs/op"]),
+			MsgBytes:    median(m["B/msg"]),
+		})
+	}
+	return out, nil
+}
+
+func median(xs []float64) float64 {
+	if len(xs) == 0 {
+		return 0
+	}
+	s := append([]float64(nil), xs...)
+	sort.Float64s(s)
+	if n := len(s); n%2 == 0 {
+		return (s[n/2-1] + s[n/2]) / 2
+	}
+	return s[len(s)/2]
+}
+
+// WriteSummary writes results as Markdown: a table per Op with each
+// codec's time against the baseline codec's for the same fixture, then
+// the geometric mean of those ratios per codec, the one number to compare
+// codecs by.
+func WriteSummary(w io.Writer, results []Result, baseline string) error {
+	var ops, codecs []string
+	seenOp, seenCodec := map[string]bool{}, map[string]bool{}
+	base := map[[2]string]float64{} // op, fixture -> baseline ns/op
+	for _, r := range results {
+		if !seenOp[r.Op] {
+			seenOp[r.Op] = true
+			ops = append(ops, r.Op)
+		}
+		if !seenCodec[r.Codec] {
+			seenCodec[r.Codec] = true
+			codecs = append(codecs, r.Codec)
+		}
+		if r.Codec == baseline {
+			base[[2]string{r.Op, r.Fixture}] = r.NsPerOp
+		}
+	}
+
+	bw := &errWriter{w: w}
+	for _, op := range ops {
+		bw.printf("### %s\n\n", op)
+		bw.printf("| fixture | codec | ns/op | vs %s | B/op | allocs/op | B/msg |\n", baseline)
+		bw.printf("|---|---|---:|---:|---:|---:|---:|\n")
+		logSum, n := map[string]float64{}, map[string]int{}
+		for _, r := range results {
+			if r.Op != op {
+				continue
+			}
+			ratio := "–"
+			if b := base[[2]string{op, r.Fixture}]; b > 0 && r.NsPerOp > 0 {
+				ratio = fmt.Sprintf("%.2fx", r.NsPerOp/b)
+				logSum[r.Codec] += math.Log(r.NsPerOp / b)
+				n[r.Codec]++
+			}
+			bw.printf("| %s | %s | %.0f | %s | %.0f | %.0f | %.0f |\n",
+				r.Fixture, r.Codec, r.NsPerOp, ratio, r.BytesPerOp, r.AllocsPerOp, r.MsgBytes)
+		}
+		bw.printf("\nGeomean time vs %s:", baseline)
+		for _, c := range codecs {
+			if n[c] > 0 {
+				bw.printf(" %s %.2fx", c, math.Exp(logSum[c]/float64(n[c])))
+			}
+		}
+		bw.printf("\n\n")
+	}
+	return bw.err
+}
+
+// errWriter keeps the first write error so WriteSummary can check once.
+type errWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (e *errWriter) printf(format string, args ...any) {
+	if e.err == nil {
+		_, e.err = fmt.Fprintf(e.w, format, args...)
+	}
+}
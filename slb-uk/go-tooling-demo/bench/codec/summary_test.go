@@ -0,0 +1,72 @@
+package codec
+
+import (
+	"strings"
+	"testing"
+)
+
+const benchOutput = `goos: linux
+goarch: amd64
+pkg: example.com/go-tooling-demo/bench/codec
+BenchmarkMarshal/ack_read/json-8         	  500000	      2000 ns/op	       400.0 B/msg	     640 B/op	      12 allocs/op
+BenchmarkMarshal/ack_read/json-8         	  500000	      2200 ns/op	       400.0 B/msg	     640 B/op	      12 allocs/op
+BenchmarkMarshal/ack_read/json-8         	  500000	      9000 ns/op	       400.0 B/msg	     640 B/op	      12 allocs/op
+BenchmarkMarshal/ack_read/protobuf-8     	  900000	      1000 ns/op	       300.0 B/msg	     512 B/op	      20 allocs/op
+BenchmarkMarshal/ack_error/json-8        	 2000000	       500 ns/op	       120.0 B/msg	     128 B/op	       2 allocs/op
+BenchmarkMarshal/ack_error/protobuf-8    	 1000000	      2000 ns/op	        80.0 B/msg	      96 B/op	       3 allocs/op
+BenchmarkUnmarshal/ack_read/json-8       	  200000	      6000 ns/op	       400.0 B/msg	    1800 B/op	      40 allocs/op
+BenchmarkOther-8                         	 1000000	      1000 ns/op
+--- FAIL: BenchmarkMarshal/ack_read/jsoniter-8
+PASS
+ok  	example.com/go-tooling-demo/bench/codec	12.3s
+`
+
+func TestParseResults(t *testing.T) {
+	rs, err := ParseResults(strings.NewReader(benchOutput))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rs) != 5 {
+		t.Fatalf("got %d results; want 5: %+v", len(rs), rs)
+	}
+	want := Result{Op: "Marshal", Fixture: "ack_read", Codec: "json", Runs: 3,
+		NsPerOp: 2200, BytesPerOp: 640, AllocsPerOp: 12, MsgBytes: 400}
+	if rs[0] != want {
+		t.Fatalf("rs[0] = %+v; want %+v (the median, not the 9000 ns outlier)", rs[0], want)
+	}
+	if rs[4].Op != "Unmarshal" || rs[4].Codec != "json" {
+		t.Fatalf("rs[4] = %+v", rs[4])
+	}
+}
+
+func TestParseResultsBadValue(t *testing.T) {
+	_, err := ParseResults(strings.NewReader("BenchmarkMarshal/a/json-8 10 fast ns/op\n"))
+	if err == nil {
+		t.Fatal("want an error for a non-numeric value")
+	}
+}
+
+func TestWriteSummary(t *testing.T) {
+	rs, err := ParseResults(strings.NewReader(benchOutput))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b strings.Builder
+	if err := WriteSummary(&b, rs, "json"); err != nil {
+		t.Fatal(err)
+	}
+	out := b.String()
+	for _, s := range []string{
+		"### Marshal\n",
+		"| ack_read | json | 2200 | 1.00x | 640 | 12 | 400 |",
+		"| ack_read | protobuf | 1000 | 0.45x | 512 | 20 | 300 |",
+		"| ack_error | protobuf | 2000 | 4.00x | 96 | 3 | 80 |",
+		// sqrt(1000/2200 * 2000/500)
+		"Geomean time vs json: json 1.00x protobuf 1.35x\n",
+		"### Unmarshal\n",
+	} {
+		if !strings.Contains(out, s) {
+			t.Errorf("summary lacks %q:\n%s", s, out)
+		}
+	}
+}
@@ -0,0 +1,198 @@
+// Package buildinfo reports what a binary was built from: the main module's
+// version, the VCS revision the go command stamped in, build tags and other
+// build settings, and feature flags fixed at link time. Serve it on
+// /debug/buildinfo and as a Prometheus info metric, so "which build is this
+// pod running?" has an answer without shelling into it.
+//
+// Feature flags are set with the linker:
+//
+//	go build -ldflags "-X example.com/go-tooling-demo/buildinfo.Flags=fastpath=on,cache=off"
+//
+// VCS fields are only filled by go build in a checkout; go run and go test
+// leave them empty.
+package buildinfo
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Flags is a comma-separated list of name=value feature flags, set at link
+// time with -ldflags -X. A bare name means "on".
+var Flags string
+
+// Info is the introspected build.
+type Info struct {
+	Path      string `json:"path"`    // main module path
+	Version   string `json:"version"` // "(devel)" unless built from a tagged module
+	GoVersion string `json:"go_version"`
+
+	VCS      string `json:"vcs,omitempty"`
+	Revision string `json:"revision,omitempty"`
+	Time     string `json:"time,omitempty"` // commit time, RFC 3339
+	Modified bool   `json:"modified"`       // built with uncommitted changes
+
+	Tags     []string          `json:"tags"`
+	Race     bool              `json:"race"`
+	Flags    map[string]string `json:"flags"`
+	Settings map[string]string `json:"settings"` // all of -gcflags, -ldflags, GOOS, CGO_ENABLED, ...
+	Deps     []Module          `json:"deps,omitempty"`
+}
+
+// Module is a dependency compiled into the binary.
+type Module struct {
+	Path    string `json:"path"`
+	Version string `json:"version"`
+	Replace string `json:"replace,omitempty"`
+}
+
+var (
+	once sync.Once
+	info Info
+)
+
+// Read returns the build info of the running binary. It is computed once;
+// the result is shared and must not be modified.
+func Read() Info {
+	once.Do(func() {
+		bi, ok := debug.ReadBuildInfo()
+		if !ok {
+			bi = &debug.BuildInfo{}
+		}
+		info = fromBuildInfo(bi, Flags)
+	})
+	return info
+}
+
+func fromBuildInfo(bi *debug.BuildInfo, flags string) Info {
+	in := Info{
+		Path:      bi.Main.Path,
+		Version:   bi.Main.Version,
+		GoVersion: bi.GoVersion,
+		Tags:      []string{},
+		Race:      raceEnabled,
+		Flags:     ParseFlags(flags),
+		Settings:  map[string]string{},
+	}
+	for _, s := range bi.Settings {
+		in.Settings[s.Key] = s.Value
+		switch s.Key {
+		case "vcs":
+			in.VCS = s.Value
+		case "vcs.revision":
+			in.Revision = s.Value
+		case "vcs.time":
+			in.Time = s.Value
+		case "vcs.modified":
+			in.Modified = s.Value == "true"
+		case "-tags":
+			for _, t := range strings.Split(s.Value, ",") {
+				if t = strings.TrimSpace(t); t != "" {
+					in.Tags = append(in.Tags, t)
+				}
+			}
+		}
+	}
+	for _, d := range bi.Deps {
+		m := Module{Path: d.Path, Version: d.Version}
+		if d.Replace != nil {
+			m.Replace = d.Replace.Path + "@" + d.Replace.Version
+		}
+		in.Deps = append(in.Deps, m)
+	}
+	return in
+}
+
+// ParseFlags parses "a=1,b,c=off" into {a:1, b:on, c:off}.
+func ParseFlags(s string) map[string]string {
+	flags := map[string]string{}
+	for _, f := range strings.Split(s, ",") {
+		name, value, ok := strings.Cut(strings.TrimSpace(f), "=")
+		if name == "" {
+			continue
+		}
+		if !ok {
+			value = "on"
+		}
+		flags[name] = value
+	}
+	return flags
+}
+
+// Enabled reports whether the feature flag name is set to anything but
+// "off", "false" or "0".
+func (in Info) Enabled(name string) bool {
+	v, ok := in.Flags[name]
+	return ok && v != "off" && v != "false" && v != "0"
+}
+
+// Short is a one-line summary, for logs and -version flags.
+func (in Info) Short() string {
+	rev := in.Revision
+	if len(rev) > 12 {
+		rev = rev[:12]
+	}
+	if rev == "" {
+		rev = "unknown"
+	} else if in.Modified {
+		rev += "-dirty"
+	}
+	return fmt.Sprintf("%s %s (rev %s, %s)", in.Path, in.Version, rev, in.GoVersion)
+}
+
+// Handler serves the Info as JSON; ?deps=0 leaves out the dependency list.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		in := Read()
+		if r.URL.Query().Get("deps") == "0" {
+			in.Deps = nil
+		}
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		_ = enc.Encode(in)
+	})
+}
+
+// MetricsHandler serves the Info in the Prometheus text format: a constant
+// build_info series whose labels carry the build, and one build_flag series
+// per feature flag. Join them onto other series with group_left.
+func MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		_ = WriteMetrics(w, Read())
+	})
+}
+
+// WriteMetrics writes in's info metrics in the Prometheus text format.
+func WriteMetrics(w io.Writer, in Info) error {
+	var b strings.Builder
+	b.WriteString("# HELP build_info Build of the running binary; the value is always 1.\n")
+	b.WriteString("# TYPE build_info gauge\n")
+	fmt.Fprintf(&b, "build_info{path=\"%s\",version=\"%s\",goversion=\"%s\",revision=\"%s\",modified=\"%t\",tags=\"%s\",race=\"%t\"} 1\n",
+		label(in.Path), label(in.Version), label(in.GoVersion), label(in.Revision), in.Modified, label(strings.Join(in.Tags, ",")), in.Race)
+
+	names := make([]string, 0, len(in.Flags))
+	for n := range in.Flags {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	b.WriteString("# HELP build_flag Feature flags fixed at link time; the value is always 1.\n")
+	b.WriteString("# TYPE build_flag gauge\n")
+	for _, n := range names {
+		fmt.Fprintf(&b, "build_flag{name=\"%s\",value=\"%s\"} 1\n", label(n), label(in.Flags[n]))
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// label escapes a label value as the text format requires.
+func label(v string) string { return labelEscaper.Replace(v) }
@@ -0,0 +1,106 @@
+package buildinfo
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"reflect"
+	"runtime/debug"
+	"strings"
+	"testing"
+)
+
+func testBuildInfo() *debug.BuildInfo {
+	return &debug.BuildInfo{
+		GoVersion: "go1.24.6",
+		Main:      debug.Module{Path: "example.com/go-tooling-demo", Version: "(devel)"},
+		Deps: []*debug.Module{
+			{Path: "golang.org/x/sync", Version: "v0.14.0"},
+			{Path: "golang.org/x/tools", Version: "v0.33.0", Replace: &debug.Module{Path: "../tools", Version: ""}},
+		},
+		Settings: []debug.BuildSetting{
+			{Key: "-tags", Value: "netgo,osusergo"},
+			{Key: "-ldflags", Value: "-X example.com/go-tooling-demo/buildinfo.Flags=fastpath"},
+			{Key: "GOOS", Value: "linux"},
+			{Key: "vcs", Value: "git"},
+			{Key: "vcs.revision", Value: "0123456789abcdef0123"},
+			{Key: "vcs.time", Value: "2024-05-01T10:00:00Z"},
+			{Key: "vcs.modified", Value: "true"},
+		},
+	}
+}
+
+func TestFromBuildInfo(t *testing.T) {
+	in := fromBuildInfo(testBuildInfo(), "fastpath, cache=off,,beta=2")
+
+	if in.Path != "example.com/go-tooling-demo" || in.Version != "(devel)" || in.GoVersion != "go1.24.6" {
+		t.Fatalf("module fields = %q %q %q", in.Path, in.Version, in.GoVersion)
+	}
+	if in.VCS != "git" || in.Revision != "0123456789abcdef0123" || in.Time != "2024-05-01T10:00:00Z" || !in.Modified {
+		t.Fatalf("vcs fields = %+v", in)
+	}
+	if want := []string{"netgo", "osusergo"}; !reflect.DeepEqual(in.Tags, want) {
+		t.Fatalf("Tags = %v; want %v", in.Tags, want)
+	}
+	if want := map[string]string{"fastpath": "on", "cache": "off", "beta": "2"}; !reflect.DeepEqual(in.Flags, want) {
+		t.Fatalf("Flags = %v; want %v", in.Flags, want)
+	}
+	if in.Settings["GOOS"] != "linux" {
+		t.Fatalf("Settings = %v", in.Settings)
+	}
+	if len(in.Deps) != 2 || in.Deps[1].Replace != "../tools@" {
+		t.Fatalf("Deps = %+v", in.Deps)
+	}
+	if in.Race != raceEnabled {
+		t.Fatalf("Race = %v under a build with race=%v", in.Race, raceEnabled)
+	}
+	if got, want := in.Short(), "example.com/go-tooling-demo (devel) (rev 0123456789ab-dirty, go1.24.6)"; got != want {
+		t.Fatalf("Short = %q; want %q", got, want)
+	}
+}
+
+func TestEnabled(t *testing.T) {
+	in := Info{Flags: ParseFlags("a,b=off,c=false,d=0,e=1")}
+	for name, want := range map[string]bool{"a": true, "b": false, "c": false, "d": false, "e": true, "missing": false} {
+		if got := in.Enabled(name); got != want {
+			t.Errorf("Enabled(%q) = %v; want %v", name, got, want)
+		}
+	}
+}
+
+func TestWriteMetrics(t *testing.T) {
+	in := fromBuildInfo(testBuildInfo(), `fastpath,note=say "hi"`)
+	var b strings.Builder
+	if err := WriteMetrics(&b, in); err != nil {
+		t.Fatal(err)
+	}
+	out := b.String()
+	for _, want := range []string{
+		"# TYPE build_info gauge\n",
+		`build_info{path="example.com/go-tooling-demo",version="(devel)",goversion="go1.24.6",revision="0123456789abcdef0123",modified="true",tags="netgo,osusergo",race=`,
+		`build_flag{name="fastpath",value="on"} 1` + "\n",
+		`build_flag{name="note",value="say \"hi\""} 1` + "\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("metrics missing %q:\n%s", want, out)
+		}
+	}
+	if strings.Index(out, `name="fastpath"`) > strings.Index(out, `name="note"`) {
+		t.Errorf("flags not sorted:\n%s", out)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	rec := httptest.NewRecorder()
+	Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/debug/buildinfo?deps=0", nil))
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Fatalf("Content-Type = %q", ct)
+	}
+	var in Info
+	if err := json.Unmarshal(rec.Body.Bytes(), &in); err != nil {
+		t.Fatal(err)
+	}
+	// under go test only the toolchain is known for sure
+	if in.GoVersion == "" || in.Deps != nil {
+		t.Fatalf("Info = %+v", in)
+	}
+}
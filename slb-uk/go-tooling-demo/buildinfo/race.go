@@ -0,0 +1,7 @@
+//go:build race
+
+package buildinfo
+
+// raceEnabled shows a build tag turning into a flag: this file is only
+// compiled under -race.
+const raceEnabled = true
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"log"
+	"os"
+
+	"example.com/go-tooling-demo/bench/codec"
+)
+
+// benchsummary turns the output of the bench/codec benchmarks into a
+// Markdown comparison of the codecs, each against -baseline.
+//
+//	go test ./bench/codec -run '^$' -bench . -benchmem -count 6 | tee codec.txt
+//	go run ./cmd/benchsummary codec.txt > codec.md
+
+func main() {
+	baseline := flag.String("baseline", "json", "codec the others are compared with")
+	flag.Parse()
+	log.SetFlags(0)
+
+	var in io.Reader = os.Stdin
+	if flag.NArg() > 0 {
+		var readers []io.Reader
+		for _, name := range flag.Args() {
+			f, err := os.Open(name)
+			if err != nil {
+				log.Fatal(err)
+			}
+			defer f.Close()
+			readers = append(readers, f)
+		}
+		in = io.MultiReader(readers...)
+	}
+
+	results, err := codec.ParseResults(in)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(results) == 0 {
+		log.Fatal("benchsummary: no bench/codec results in the input")
+	}
+	if err := codec.WriteSummary(os.Stdout, results, *baseline); err != nil {
+		log.Fatal(err)
+	}
+}
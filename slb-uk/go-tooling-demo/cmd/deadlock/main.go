@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"example.com/go-tooling-demo/diag"
+)
+
+// deadlock runs two transfer workers that take the same two locks in
+// opposite order. After a while they catch each other holding one lock and
+// wait forever for the other. The HTTP listener keeps the runtime's own
+// deadlock detector quiet, so only the diag watchdog notices.
+//
+//	go run ./cmd/deadlock
+//	curl localhost:6061/debug/diag                 # heartbeats and the last report
+//	curl 'localhost:6061/debug/diag?goroutines=1'  # both workers in sync.Mutex.Lock
+
+type account struct {
+	mu      sync.Mutex
+	balance int
+}
+
+// transfer locks from, then to: the classic lock-ordering bug.
+func transfer(from, to *account, amount int) {
+	from.mu.Lock()
+	defer from.mu.Unlock()
+	time.Sleep(time.Millisecond) // widen the window
+	to.mu.Lock()
+	defer to.mu.Unlock()
+	from.balance -= amount
+	to.balance += amount
+}
+
+func main() {
+	stall := flag.Duration("stall", 2*time.Second, "report a worker without progress for this long")
+	dir := flag.String("dump-dir", os.TempDir(), "where goroutine dumps are written")
+	addr := flag.String("addr", "localhost:6061", "serve /debug/diag here")
+	keep := flag.Bool("keep-running", false, "keep serving after the deadlock is reported")
+	flag.Parse()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan diag.Report, 1)
+	wd := diag.New(diag.Config{Stall: *stall, DumpDir: *dir, OnStall: func(r diag.Report) {
+		select {
+		case done <- r:
+		default:
+		}
+	}})
+	go wd.Run(ctx)
+
+	http.Handle("/debug/diag", wd.Handler())
+	go func() { log.Println(http.ListenAndServe(*addr, nil)) }()
+	log.Printf("serving http://%s/debug/diag; waiting for the workers to deadlock", *addr)
+
+	a, b := &account{balance: 100}, &account{balance: 100}
+	for _, w := range []struct {
+		name     string
+		from, to *account
+	}{{"a->b", a, b}, {"b->a", b, a}} {
+		hb := wd.Register(w.name)
+		go func() {
+			for {
+				transfer(w.from, w.to, 1)
+				hb.Beat()
+			}
+		}()
+	}
+
+	r := <-done
+	for _, s := range r.Stalled {
+		log.Printf("stalled: %s after %d transfers", s.Name, s.Beats)
+	}
+	log.Printf("goroutine states: %v", r.States)
+	log.Printf("stacks: %s", r.DumpPath)
+	if *keep {
+		select {}
+	}
+	os.Exit(1)
+}
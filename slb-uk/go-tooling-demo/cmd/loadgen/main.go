@@ -0,0 +1,177 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"example.com/go-tooling-demo/buildinfo"
+)
+
+// loadgen drives the pprof demo server's /work endpoint with a controlled,
+// reproducible request pattern so CPU profiles can be captured under load.
+//
+//	go run ./pprof
+//	go run ./cmd/loadgen -c 8 -d 30s -rate 20 -arrival poisson
+//	go tool pprof http://localhost:6060/debug/pprof/profile?seconds=20
+
+type result struct {
+	latency time.Duration
+	err     error
+}
+
+func main() {
+	target := flag.String("url", "http://localhost:6060/work?sec=0.05", "endpoint to hit")
+	concurrency := flag.Int("c", 4, "number of concurrent workers")
+	duration := flag.Duration("d", 10*time.Second, "how long to generate load")
+	rate := flag.Float64("rate", 10, "average requests per second (all workers combined)")
+	arrival := flag.String("arrival", "constant", "arrival distribution: constant, poisson, burst")
+	burst := flag.Int("burst", 10, "requests per burst when -arrival=burst")
+	seed := flag.Int64("seed", 1, "random seed for poisson arrivals (same seed => same schedule)")
+	version := flag.Bool("version", false, "print build info and exit")
+	flag.Parse()
+
+	if *version {
+		fmt.Println(buildinfo.Read().Short())
+		return
+	}
+
+	if *concurrency < 1 || *rate <= 0 || *burst < 1 {
+		log.Fatal("-c, -rate and -burst must be positive")
+	}
+	next, err := newSchedule(*arrival, *rate, *burst, *seed)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	jobs := make(chan struct{}, *concurrency)
+	results := make(chan result, *concurrency)
+
+	var wg sync.WaitGroup
+	for i := 0; i < *concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range jobs {
+				results <- hit(client, *target)
+			}
+		}()
+	}
+
+	var all []result
+	collected := make(chan struct{})
+	go func() {
+		for r := range results {
+			all = append(all, r)
+		}
+		close(collected)
+	}()
+
+	log.Printf("loadgen: %s for %s, c=%d rate=%.1f/s arrival=%s", *target, *duration, *concurrency, *rate, *arrival)
+	start := time.Now()
+	deadline := start.Add(*duration)
+	dropped := 0
+	for t := start; ; {
+		t = t.Add(next())
+		if t.After(deadline) {
+			break
+		}
+		time.Sleep(time.Until(t))
+		select {
+		case jobs <- struct{}{}:
+		default:
+			// all workers busy: record it instead of silently queueing, so the
+			// offered rate stays honest
+			dropped++
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	close(results)
+	<-collected
+
+	report(all, dropped, time.Since(start))
+}
+
+// newSchedule returns a function yielding the gap before the next request.
+func newSchedule(kind string, rate float64, burst int, seed int64) (func() time.Duration, error) {
+	mean := time.Duration(float64(time.Second) / rate)
+	switch kind {
+	case "constant":
+		return func() time.Duration { return mean }, nil
+	case "poisson":
+		rnd := rand.New(rand.NewSource(seed))
+		return func() time.Duration {
+			// exponential inter-arrival times give a Poisson process
+			return time.Duration(rnd.ExpFloat64() * float64(mean))
+		}, nil
+	case "burst":
+		// fire `burst` requests back to back, then idle long enough to keep
+		// the average rate
+		i := 0
+		return func() time.Duration {
+			i++
+			if i%burst == 1 || burst == 1 {
+				return mean * time.Duration(burst)
+			}
+			return 0
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown arrival distribution %q", kind)
+	}
+}
+
+func hit(client *http.Client, url string) result {
+	start := time.Now()
+	resp, err := client.Get(url)
+	if err != nil {
+		return result{latency: time.Since(start), err: err}
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		err = fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return result{latency: time.Since(start), err: err}
+}
+
+func report(all []result, dropped int, elapsed time.Duration) {
+	var lat []time.Duration
+	errs := 0
+	for _, r := range all {
+		if r.err != nil {
+			errs++
+			continue
+		}
+		lat = append(lat, r.latency)
+	}
+	sort.Slice(lat, func(i, j int) bool { return lat[i] < lat[j] })
+
+	fmt.Printf("requests: %d  ok: %d  errors: %d  dropped: %d  elapsed: %s\n",
+		len(all), len(lat), errs, dropped, elapsed.Round(time.Millisecond))
+	if len(lat) == 0 {
+		return
+	}
+	fmt.Printf("throughput: %.1f req/s\n", float64(len(lat))/elapsed.Seconds())
+	fmt.Printf("latency: min=%s p50=%s p90=%s p99=%s max=%s\n",
+		lat[0], percentile(lat, 50), percentile(lat, 90), percentile(lat, 99), lat[len(lat)-1])
+}
+
+// percentile uses nearest-rank on an already sorted slice.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	idx := int(p/100*float64(len(sorted)) + 0.5)
+	if idx < 1 {
+		idx = 1
+	}
+	if idx > len(sorted) {
+		idx = len(sorted)
+	}
+	return sorted[idx-1]
+}
@@ -0,0 +1,16 @@
+// Command vetdemo runs the nosleepselect analyzer, either standalone
+//
+//	go run ./cmd/vetdemo ./...
+//
+// or as a vet tool next to the standard checks
+//
+//	go build -o vetdemo ./cmd/vetdemo && go vet -vettool=$(pwd)/vetdemo ./...
+package main
+
+import (
+	"golang.org/x/tools/go/analysis/singlechecker"
+
+	"example.com/go-tooling-demo/analyzers/nosleepselect"
+)
+
+func main() { singlechecker.Main(nosleepselect.Analyzer) }
@@ -0,0 +1,256 @@
+// Package diag catches the deadlocks the runtime does not: "fatal error: all
+// goroutines are asleep" only fires when every goroutine is blocked, so two
+// workers stuck on each other's mutex in a server with a live HTTP listener
+// just hang. Workers register a Heartbeat and Beat it as they make progress;
+// a Watchdog that sees a heartbeat stall writes a full goroutine dump to a
+// file and can serve the current state and stacks over HTTP.
+//
+//	wd := diag.New(diag.Config{Stall: 10 * time.Second, DumpDir: "/tmp"})
+//	hb := wd.Register("consumer")
+//	go wd.Run(ctx)
+//	for msg := range msgs { handle(msg); hb.Beat() }
+package diag
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime/pprof"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Heartbeat is one unit of expected progress, usually a worker loop.
+type Heartbeat struct {
+	name     string
+	last     atomic.Int64 // unix nanos of the last Beat
+	beats    atomic.Uint64
+	reported atomic.Bool // stall already dumped; cleared by the next Beat
+}
+
+// Beat records progress. It is cheap enough to call per item.
+func (h *Heartbeat) Beat() {
+	h.last.Store(time.Now().UnixNano())
+	h.beats.Add(1)
+	h.reported.Store(false)
+}
+
+func (h *Heartbeat) Name() string { return h.name }
+
+// Config tunes a Watchdog; zero values get the defaults noted.
+type Config struct {
+	Stall    time.Duration // no Beat for this long is a suspected deadlock; default 10s
+	Interval time.Duration // how often to check; default Stall/4
+	DumpDir  string        // where dumps are written; default os.TempDir()
+	// OnStall runs after the dump is written, e.g. to alert or exit.
+	OnStall func(Report)
+}
+
+// Report describes one detection.
+type Report struct {
+	At       time.Time      `json:"at"`
+	Stalled  []BeatStatus   `json:"stalled"`
+	DumpPath string         `json:"dump_path,omitempty"`
+	States   map[string]int `json:"goroutine_states"` // "semacquire": 2, "chan receive": 1, ...
+	Err      string         `json:"error,omitempty"`
+}
+
+// BeatStatus is a heartbeat as seen by the watchdog.
+type BeatStatus struct {
+	Name    string        `json:"name"`
+	Beats   uint64        `json:"beats"`
+	Since   time.Duration `json:"since_last_beat_ns"`
+	Stalled bool          `json:"stalled"`
+}
+
+type Watchdog struct {
+	cfg   Config
+	mu    sync.Mutex
+	beats map[string]*Heartbeat
+	last  *Report
+}
+
+func New(cfg Config) *Watchdog {
+	if cfg.Stall <= 0 {
+		cfg.Stall = 10 * time.Second
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = cfg.Stall / 4
+	}
+	if cfg.DumpDir == "" {
+		cfg.DumpDir = os.TempDir()
+	}
+	return &Watchdog{cfg: cfg, beats: map[string]*Heartbeat{}}
+}
+
+// Register adds a heartbeat that counts as beaten now. Registering a name
+// again replaces the old heartbeat.
+func (w *Watchdog) Register(name string) *Heartbeat {
+	h := &Heartbeat{name: name}
+	h.last.Store(time.Now().UnixNano())
+	w.mu.Lock()
+	w.beats[name] = h
+	w.mu.Unlock()
+	return h
+}
+
+// Unregister stops watching h, e.g. when its worker exits normally.
+func (w *Watchdog) Unregister(h *Heartbeat) {
+	w.mu.Lock()
+	if w.beats[h.name] == h {
+		delete(w.beats, h.name)
+	}
+	w.mu.Unlock()
+}
+
+// Run checks every Interval until ctx is done.
+func (w *Watchdog) Run(ctx context.Context) {
+	t := time.NewTicker(w.cfg.Interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-t.C:
+			w.Check(now)
+		}
+	}
+}
+
+// Status lists the heartbeats as of now, sorted by name.
+func (w *Watchdog) Status(now time.Time) []BeatStatus {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	out := make([]BeatStatus, 0, len(w.beats))
+	for _, h := range w.beats {
+		since := now.Sub(time.Unix(0, h.last.Load()))
+		out = append(out, BeatStatus{Name: h.name, Beats: h.beats.Load(), Since: since, Stalled: since >= w.cfg.Stall})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// Check looks for heartbeats that stalled since the last check and, if
+// there are any, dumps the goroutines. A stall is reported once; the
+// heartbeat has to Beat again before it can be reported again.
+func (w *Watchdog) Check(now time.Time) *Report {
+	var fresh []BeatStatus
+	for _, s := range w.Status(now) {
+		if !s.Stalled {
+			continue
+		}
+		w.mu.Lock()
+		h := w.beats[s.Name]
+		w.mu.Unlock()
+		if h != nil && h.reported.CompareAndSwap(false, true) {
+			fresh = append(fresh, s)
+		}
+	}
+	if len(fresh) == 0 {
+		return nil
+	}
+
+	r := &Report{At: now, Stalled: fresh}
+	var dump bytes.Buffer
+	if err := DumpGoroutines(&dump); err != nil {
+		r.Err = err.Error()
+	}
+	r.States = GoroutineStates(dump.Bytes())
+	names := make([]string, len(fresh))
+	for i, s := range fresh {
+		names[i] = s.Name
+	}
+	path, err := writeDump(w.cfg.DumpDir, now, names, dump.Bytes())
+	if err != nil {
+		r.Err = err.Error()
+	}
+	r.DumpPath = path
+	log.Printf("diag: suspected deadlock, no progress on %s for %s; goroutines %v; dump %s",
+		strings.Join(names, ", "), w.cfg.Stall, r.States, path)
+
+	w.mu.Lock()
+	w.last = r
+	w.mu.Unlock()
+	if w.cfg.OnStall != nil {
+		w.cfg.OnStall(*r)
+	}
+	return r
+}
+
+// LastReport is the most recent detection, nil if there was none.
+func (w *Watchdog) LastReport() *Report {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.last
+}
+
+// DumpGoroutines writes every goroutine's stack in panic format (what
+// /debug/pprof/goroutine?debug=2 shows), including how long each has been
+// blocked.
+func DumpGoroutines(w io.Writer) error {
+	return pprof.Lookup("goroutine").WriteTo(w, 2)
+}
+
+func writeDump(dir string, now time.Time, names []string, dump []byte) (string, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, fmt.Sprintf("goroutines-%s.txt", now.UTC().Format("20060102T150405.000Z")))
+	header := fmt.Sprintf("# diag: no progress on %s at %s\n\n", strings.Join(names, ", "), now.UTC().Format(time.RFC3339Nano))
+	return path, os.WriteFile(path, append([]byte(header), dump...), 0o644)
+}
+
+// GoroutineStates counts goroutines by wait reason in a debug=2 dump, from
+// headers like "goroutine 7 [sync.Mutex.Lock, 2 minutes]:". A pile-up in
+// sync.Mutex.Lock or chan receive is what a deadlock usually looks like.
+func GoroutineStates(dump []byte) map[string]int {
+	states := map[string]int{}
+	sc := bufio.NewScanner(bytes.NewReader(dump))
+	sc.Buffer(make([]byte, 64<<10), 1<<20)
+	for sc.Scan() {
+		line := sc.Text()
+		if !strings.HasPrefix(line, "goroutine ") {
+			continue
+		}
+		open, end := strings.IndexByte(line, '['), strings.LastIndexByte(line, ']')
+		if open < 0 || end < open {
+			continue
+		}
+		state, _, _ := strings.Cut(line[open+1:end], ",")
+		states[state]++
+	}
+	return states
+}
+
+// Handler serves the watchdog state as JSON, or with ?goroutines=1 a live
+// goroutine dump as text:
+//
+//	curl localhost:6061/debug/diag
+//	curl 'localhost:6061/debug/diag?goroutines=1'
+func (w *Watchdog) Handler() http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("goroutines") != "" {
+			rw.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			if err := DumpGoroutines(rw); err != nil {
+				http.Error(rw, err.Error(), http.StatusInternalServerError)
+			}
+			return
+		}
+		rw.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(rw).Encode(struct {
+			Stall      time.Duration `json:"stall_ns"`
+			Heartbeats []BeatStatus  `json:"heartbeats"`
+			Last       *Report       `json:"last_report"`
+		}{w.cfg.Stall, w.Status(time.Now()), w.LastReport()})
+	})
+}
@@ -0,0 +1,110 @@
+package diag
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCheckReportsStallOnce(t *testing.T) {
+	dir := t.TempDir()
+	var reports []Report
+	wd := New(Config{Stall: time.Minute, DumpDir: dir, OnStall: func(r Report) { reports = append(reports, r) }})
+	busy, stuck := wd.Register("busy"), wd.Register("stuck")
+	now := time.Now()
+
+	if r := wd.Check(now); r != nil {
+		t.Fatalf("fresh heartbeats reported: %+v", r)
+	}
+	busy.Beat()
+	later := time.Unix(0, busy.last.Load()).Add(59 * time.Second)
+	stuck.last.Store(later.Add(-2 * time.Minute).UnixNano())
+
+	r := wd.Check(later)
+	if r == nil || len(r.Stalled) != 1 || r.Stalled[0].Name != "stuck" {
+		t.Fatalf("Check = %+v; want only stuck", r)
+	}
+	b, err := os.ReadFile(r.DumpPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(b), "# diag: no progress on stuck") || !strings.Contains(string(b), "goroutine ") {
+		t.Fatalf("dump:\n%.300s", b)
+	}
+	if r.States["running"] == 0 {
+		t.Errorf("States = %v; want the checking goroutine running", r.States)
+	}
+
+	if r := wd.Check(later); r != nil {
+		t.Fatalf("stall reported twice: %+v", r)
+	}
+	stuck.Beat()
+	if r := wd.Check(time.Now().Add(2 * time.Minute)); r == nil || len(r.Stalled) != 2 {
+		t.Fatalf("after another stall Check = %+v; want both", r)
+	}
+	if len(reports) != 2 {
+		t.Errorf("OnStall ran %d times; want 2", len(reports))
+	}
+}
+
+func TestUnregister(t *testing.T) {
+	wd := New(Config{Stall: time.Millisecond, DumpDir: t.TempDir()})
+	h := wd.Register("worker")
+	wd.Unregister(h)
+	if r := wd.Check(time.Now().Add(time.Hour)); r != nil {
+		t.Fatalf("unregistered heartbeat reported: %+v", r)
+	}
+}
+
+func TestGoroutineStatesFindsDeadlock(t *testing.T) {
+	var a, b sync.Mutex
+	a.Lock()
+	b.Lock()
+	go func() { a.Lock() }()
+	go func() { b.Lock() }()
+	defer a.Unlock() // releases the goroutines at the end
+	defer b.Unlock()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		var dump strings.Builder
+		if err := DumpGoroutines(&dump); err != nil {
+			t.Fatal(err)
+		}
+		if n := GoroutineStates([]byte(dump.String()))["sync.Mutex.Lock"]; n >= 2 {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("no goroutines in sync.Mutex.Lock:\n%s", dump.String())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	wd := New(Config{Stall: time.Hour})
+	wd.Register("worker").Beat()
+
+	rec := httptest.NewRecorder()
+	wd.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/debug/diag", nil))
+	var got struct {
+		Heartbeats []BeatStatus `json:"heartbeats"`
+		Last       *Report      `json:"last_report"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Heartbeats) != 1 || got.Heartbeats[0].Beats != 1 || got.Heartbeats[0].Stalled || got.Last != nil {
+		t.Fatalf("status = %s", rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	wd.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/debug/diag?goroutines=1", nil))
+	if !strings.Contains(rec.Body.String(), "diag.TestHandler") {
+		t.Fatalf("goroutine dump lacks the test:\n%.300s", rec.Body.String())
+	}
+}
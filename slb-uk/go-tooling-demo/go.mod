@@ -1,3 +1,16 @@
 module example.com/go-tooling-demo
 
 go 1.24.6
+
+require (
+	github.com/json-iterator/go v1.1.12
+	golang.org/x/sync v0.14.0
+	golang.org/x/tools v0.33.0
+	google.golang.org/protobuf v1.36.7
+)
+
+require (
+	github.com/modern-go/concurrent v0.0.0-20180306012644-bacd9c7ef1dd // indirect
+	github.com/modern-go/reflect2 v1.0.2 // indirect
+	golang.org/x/mod v0.24.0 // indirect
+)
@@ -0,0 +1,95 @@
+// Package pipeline shows fan-out/fan-in stages wired together with
+// errgroup: every goroutine belongs to the group, channels are bounded, and
+// the first error cancels the shared context so all stages drain and exit.
+package pipeline
+
+import (
+	"context"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// Stage transforms one input into one output.
+type Stage[In, Out any] func(ctx context.Context, in In) (Out, error)
+
+// Source emits items on a channel with capacity buf and closes it when done
+// or when ctx is cancelled.
+func Source[T any](ctx context.Context, g *errgroup.Group, items []T, buf int) <-chan T {
+	out := make(chan T, buf)
+	g.Go(func() error {
+		defer close(out)
+		for _, it := range items {
+			select {
+			case out <- it:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		return nil
+	})
+	return out
+}
+
+// Map fans in out to workers goroutines running fn and fans their results
+// back into a single channel of capacity buf. Output order is not
+// preserved. The returned channel is closed once every worker has exited.
+func Map[In, Out any](ctx context.Context, g *errgroup.Group, in <-chan In, workers, buf int, fn Stage[In, Out]) <-chan Out {
+	if workers < 1 {
+		workers = 1
+	}
+	out := make(chan Out, buf)
+	// workers run in their own group so we know when to close out; the
+	// first worker error cancels its siblings and is forwarded to g
+	wg, wctx := errgroup.WithContext(ctx)
+	for i := 0; i < workers; i++ {
+		wg.Go(func() error {
+			for {
+				var v In
+				var ok bool
+				select {
+				case v, ok = <-in:
+					if !ok {
+						return nil
+					}
+				case <-wctx.Done():
+					return wctx.Err()
+				}
+				res, err := fn(wctx, v)
+				if err != nil {
+					return err
+				}
+				select {
+				case out <- res:
+				case <-wctx.Done():
+					return wctx.Err()
+				}
+			}
+		})
+	}
+	g.Go(func() error {
+		defer close(out)
+		return wg.Wait()
+	})
+	return out
+}
+
+// Run wires Source -> Map -> collect. It returns the first error from any
+// stage (or the parent context's error); on error the partial results are
+// discarded.
+func Run[In, Out any](ctx context.Context, items []In, workers int, fn Stage[In, Out]) ([]Out, error) {
+	g, ctx := errgroup.WithContext(ctx)
+	src := Source(ctx, g, items, workers)
+	results := Map(ctx, g, src, workers, workers, fn)
+
+	var out []Out
+	g.Go(func() error {
+		for r := range results {
+			out = append(out, r)
+		}
+		return nil
+	})
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
@@ -0,0 +1,112 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"sort"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func square(_ context.Context, n int) (int, error) { return n * n, nil }
+
+func seq(n int) []int {
+	s := make([]int, n)
+	for i := range s {
+		s[i] = i
+	}
+	return s
+}
+
+func TestRunSuccess(t *testing.T) {
+	got, err := Run(context.Background(), seq(100), 8, square)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Ints(got)
+	if len(got) != 100 {
+		t.Fatalf("len = %d; want 100", len(got))
+	}
+	for i, v := range got {
+		if v != i*i {
+			t.Fatalf("got[%d] = %d; want %d", i, v, i*i)
+		}
+	}
+}
+
+func TestRunErrorCancelsOtherStages(t *testing.T) {
+	boom := errors.New("boom")
+	var processed atomic.Int64
+	fn := func(ctx context.Context, n int) (int, error) {
+		processed.Add(1)
+		if n == 10 {
+			return 0, boom
+		}
+		select {
+		case <-time.After(time.Millisecond):
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		}
+		return n, nil
+	}
+
+	_, err := Run(context.Background(), seq(10_000), 4, fn)
+	if !errors.Is(err, boom) {
+		t.Fatalf("err = %v; want %v", err, boom)
+	}
+	if p := processed.Load(); p >= 10_000 {
+		t.Fatalf("processed %d items; cancellation did not stop the pipeline", p)
+	}
+}
+
+func TestRunParentCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+	fn := func(ctx context.Context, n int) (int, error) {
+		// every item blocks until the parent context goes away
+		<-ctx.Done()
+		return 0, ctx.Err()
+	}
+	_, err := Run(ctx, seq(1000), 4, fn)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("err = %v; want context.Canceled", err)
+	}
+}
+
+func TestRunNoGoroutineLeak(t *testing.T) {
+	before := runtime.NumGoroutine()
+	boom := errors.New("boom")
+	for i := 0; i < 20; i++ {
+		_, _ = Run(context.Background(), seq(500), 8, func(_ context.Context, n int) (int, error) {
+			if n == 3 {
+				return 0, boom
+			}
+			return n, nil
+		})
+	}
+	// give exiting goroutines a moment to be reaped
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if after := runtime.NumGoroutine(); after > before {
+		t.Fatalf("goroutines: before=%d after=%d", before, after)
+	}
+}
+
+func BenchmarkRun(b *testing.B) {
+	items := seq(1000)
+	for _, workers := range []int{1, 4, 16} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := Run(context.Background(), items, workers, square); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// profilingConfig is the runtime-tunable part of profiling. Full-rate block
+// and mutex profiling is too expensive to leave on, so it can be switched on
+// for an investigation and off again without a restart.
+type profilingConfig struct {
+	BlockProfileRate     int `json:"block_profile_rate"`     // ns; 0 disables, 1 records every event
+	MutexProfileFraction int `json:"mutex_profile_fraction"` // 1/n events; 0 disables
+	CPUProfileSeconds    int `json:"cpu_profile_seconds"`    // default for /debug/profiling/cpu
+}
+
+const maxCPUProfileSeconds = 300
+
+func (c profilingConfig) validate() error {
+	if c.BlockProfileRate < 0 {
+		return fmt.Errorf("block_profile_rate must be >= 0, got %d", c.BlockProfileRate)
+	}
+	if c.MutexProfileFraction < 0 {
+		return fmt.Errorf("mutex_profile_fraction must be >= 0, got %d", c.MutexProfileFraction)
+	}
+	if c.CPUProfileSeconds < 1 || c.CPUProfileSeconds > maxCPUProfileSeconds {
+		return fmt.Errorf("cpu_profile_seconds must be between 1 and %d, got %d", maxCPUProfileSeconds, c.CPUProfileSeconds)
+	}
+	return nil
+}
+
+// configChange is one entry of the audit log.
+type configChange struct {
+	At     time.Time       `json:"at"`
+	Remote string          `json:"remote"`
+	From   profilingConfig `json:"from"`
+	To     profilingConfig `json:"to"`
+}
+
+type profilingAdmin struct {
+	mu      sync.Mutex
+	cfg     profilingConfig
+	changes []configChange
+}
+
+const maxAuditEntries = 50
+
+func newProfilingAdmin(cfg profilingConfig) *profilingAdmin {
+	a := &profilingAdmin{cfg: cfg}
+	a.apply(cfg)
+	return a
+}
+
+// apply pushes the settings into the runtime. The runtime has no getter for
+// the block rate, so a.cfg is the source of truth.
+func (a *profilingAdmin) apply(cfg profilingConfig) {
+	runtime.SetBlockProfileRate(cfg.BlockProfileRate)
+	runtime.SetMutexProfileFraction(cfg.MutexProfileFraction)
+}
+
+// configHandler serves GET and PUT /debug/profiling/config.
+//
+//	curl localhost:6060/debug/profiling/config
+//	curl -X PUT localhost:6060/debug/profiling/config \
+//	  -d '{"block_profile_rate":0,"mutex_profile_fraction":0,"cpu_profile_seconds":30}'
+func (a *profilingAdmin) configHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		a.mu.Lock()
+		resp := struct {
+			Config  profilingConfig `json:"config"`
+			Changes []configChange  `json:"changes"`
+		}{a.cfg, append([]configChange(nil), a.changes...)}
+		a.mu.Unlock()
+		writeJSON(w, http.StatusOK, resp)
+
+	case http.MethodPut:
+		a.mu.Lock()
+		next := a.cfg // fields missing from the body keep their current value
+		a.mu.Unlock()
+		dec := json.NewDecoder(r.Body)
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&next); err != nil {
+			http.Error(w, "bad json: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := next.validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		a.mu.Lock()
+		prev := a.cfg
+		a.cfg = next
+		a.apply(next)
+		a.changes = append(a.changes, configChange{At: time.Now().UTC(), Remote: r.RemoteAddr, From: prev, To: next})
+		if len(a.changes) > maxAuditEntries {
+			a.changes = a.changes[len(a.changes)-maxAuditEntries:]
+		}
+		a.mu.Unlock()
+
+		log.Printf("profiling config changed by %s: %+v -> %+v", r.RemoteAddr, prev, next)
+		writeJSON(w, http.StatusOK, next)
+
+	default:
+		w.Header().Set("Allow", "GET, PUT")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// cpuHandler is /debug/pprof/profile with the configured default duration
+// when the caller doesn't pass ?seconds=.
+func (a *profilingAdmin) cpuHandler(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Get("seconds") == "" {
+		a.mu.Lock()
+		secs := a.cfg.CPUProfileSeconds
+		a.mu.Unlock()
+		q := r.URL.Query()
+		q.Set("seconds", fmt.Sprint(secs))
+		r.URL.RawQuery = q.Encode()
+	}
+	pprof.Profile(w, r)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
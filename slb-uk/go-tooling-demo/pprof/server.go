@@ -6,8 +6,9 @@ import (
 	"log"
 	"math"
 	"net/http"
-	"runtime"
 	"time"
+
+	"example.com/go-tooling-demo/buildinfo"
 )
 
 // burnCPU spins on some floating point work for a duration to simulate load.
@@ -34,16 +35,29 @@ func workHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func main() {
-	// Enable additional profiles
-	runtime.SetBlockProfileRate(1)
-	runtime.SetMutexProfileFraction(1)
+	// Enable additional profiles; both can be changed at runtime via
+	// /debug/profiling/config
+	admin := newProfilingAdmin(profilingConfig{
+		BlockProfileRate:     1,
+		MutexProfileFraction: 1,
+		CPUProfileSeconds:    30,
+	})
+	http.HandleFunc("/debug/profiling/config", admin.configHandler)
+	http.HandleFunc("/debug/profiling/cpu", admin.cpuHandler)
+
+	// What this binary was built from, as JSON and as an info metric
+	http.Handle("/debug/buildinfo", buildinfo.Handler())
+	http.Handle("/metrics", buildinfo.MetricsHandler())
 
 	// Register a simple workload handler on the default mux (same mux pprof uses)
 	http.HandleFunc("/work", workHandler)
 
+	log.Println("Build:", buildinfo.Read().Short())
 	log.Println("Serving pprof + demo at http://localhost:6060")
 	log.Println("Try: curl http://localhost:6060/work")
 	log.Println("CPU profile: go tool pprof http://localhost:6060/debug/pprof/profile?seconds=30")
+	log.Println("Profiling config: curl http://localhost:6060/debug/profiling/config")
+	log.Println("Build info: curl http://localhost:6060/debug/buildinfo")
 
 	// Start HTTP server with pprof endpoints on :6060 using the default mux
 	if err := http.ListenAndServe("localhost:6060", nil); err != nil {
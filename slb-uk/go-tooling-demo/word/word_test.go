@@ -1,6 +1,9 @@
 package word
 
-import "testing"
+import (
+	"strings"
+	"testing"
+)
 
 func TestCount(t *testing.T) {
 	cases := []struct {
@@ -11,24 +14,90 @@ func TestCount(t *testing.T) {
 		{"  ", 0},
 		{"hello", 1},
 		{"go is fun", 3},
+		{"\tgo\nis\r\nfun  ", 3},
+		{"héllo wörld", 2},
+		{"go\u00a0is\u2003fun", 3}, // non-breaking and em space
 	}
 	for _, c := range cases {
 		if got := Count(c.in); got != c.want {
 			t.Fatalf("Count(%q) = %d; want %d", c.in, got, c.want)
 		}
+		if got := countFields(c.in); got != c.want {
+			t.Fatalf("countFields(%q) = %d; want %d", c.in, got, c.want)
+		}
+	}
+}
+
+func TestCountMemo(t *testing.T) {
+	s := "memo test input"
+	if got := CountMemo(s); got != 3 {
+		t.Fatalf("CountMemo(%q) = %d; want 3", s, got)
+	}
+	if got := CountMemo(s); got != 3 {
+		t.Fatalf("CountMemo(%q) cached = %d; want 3", s, got)
+	}
+}
+
+func TestCountAllocs(t *testing.T) {
+	s := "go is simple and fast"
+	if n := testing.AllocsPerRun(100, func() { _ = Count(s) }); n != 0 {
+		t.Fatalf("Count allocated %v times per run; want 0", n)
 	}
 }
 
 func TestRepeatCount(t *testing.T) {
 	got := RepeatCount("go is fun", 10000)
-	if got <= 0 {
-		t.Fatal("unexpected result")
+	if got != 30000 {
+		t.Fatalf("RepeatCount = %d; want 30000", got)
 	}
 }
 
+var benchInput = "go is simple and fast"
+
 func BenchmarkCount(b *testing.B) {
-	s := "go is simple and fast"
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_ = Count(benchInput)
+	}
+}
+
+func BenchmarkCountFields(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_ = countFields(benchInput)
+	}
+}
+
+func BenchmarkCountLong(b *testing.B) {
+	s := strings.Repeat(benchInput+" ", 100)
+	b.ReportAllocs()
 	for i := 0; i < b.N; i++ {
 		_ = Count(s)
 	}
 }
+
+func BenchmarkCountFieldsLong(b *testing.B) {
+	s := strings.Repeat(benchInput+" ", 100)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_ = countFields(s)
+	}
+}
+
+func BenchmarkRepeatCount(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_ = RepeatCount(benchInput, 1000)
+	}
+}
+
+func BenchmarkRepeatCountNoMemo(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		total := 0
+		for j := 0; j < 1000; j++ {
+			total += countFields(benchInput)
+		}
+		_ = total
+	}
+}
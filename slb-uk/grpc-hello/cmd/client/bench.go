@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/slb-uk/grpc-hello/api/hellopb"
+)
+
+// retryServiceConfig retries SayHello on UNAVAILABLE with exponential
+// backoff. grpc-go implements retryPolicy but ignores hedgingPolicy, so
+// hedging is done by hand in hedged below.
+const retryServiceConfig = `{
+  "methodConfig": [{
+    "name": [{"service": "hello.v1.Greeter", "method": "SayHello"}],
+    "retryPolicy": {
+      "maxAttempts": 4,
+      "initialBackoff": "0.05s",
+      "maxBackoff": "0.5s",
+      "backoffMultiplier": 2,
+      "retryableStatusCodes": ["UNAVAILABLE"]
+    }
+  }]
+}`
+
+// hedged sends SayHello and, if no answer arrives within delay, up to
+// maxHedges more copies spaced delay apart. The first success wins and the
+// rest are cancelled; the call fails only once every attempt has. Only safe
+// for idempotent methods.
+func hedged(ctx context.Context, client hellopb.GreeterClient, req *hellopb.HelloRequest, delay time.Duration, maxHedges int) (*hellopb.HelloResponse, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	type result struct {
+		res *hellopb.HelloResponse
+		err error
+	}
+	results := make(chan result, maxHedges+1)
+	launch := func(n int) {
+		actx := ctx
+		if n > 0 {
+			actx = metadata.AppendToOutgoingContext(ctx, "x-hedge", strconv.Itoa(n))
+		}
+		go func() {
+			res, err := client.SayHello(actx, req)
+			results <- result{res, err}
+		}()
+	}
+
+	launch(0)
+	inflight, sent := 1, 1
+	t := time.NewTimer(delay)
+	defer t.Stop()
+	var lastErr error
+	for inflight > 0 {
+		select {
+		case r := <-results:
+			inflight--
+			if r.err == nil {
+				return r.res, nil
+			}
+			lastErr = r.err
+			if inflight == 0 && sent <= maxHedges && ctx.Err() == nil {
+				launch(sent) // nothing left running: hedge now rather than wait
+				inflight, sent = inflight+1, sent+1
+			}
+		case <-t.C:
+			if sent <= maxHedges {
+				launch(sent)
+				inflight, sent = inflight+1, sent+1
+				t.Reset(delay)
+			}
+		}
+	}
+	return nil, lastErr
+}
+
+// bench makes n SayHello calls one after another and prints the latency
+// distribution and errors by code, for comparing retry and hedge settings
+// against the server's injected faults.
+func bench(ctx context.Context, client hellopb.GreeterClient, name string, n int, timeout, hedge time.Duration) {
+	lat := make([]time.Duration, 0, n)
+	errs := map[codes.Code]int{}
+	for i := 0; i < n; i++ {
+		cctx, cancel := context.WithTimeout(ctx, timeout)
+		start := time.Now()
+		var err error
+		if hedge > 0 {
+			_, err = hedged(cctx, client, &hellopb.HelloRequest{Name: name}, hedge, 2)
+		} else {
+			_, err = client.SayHello(cctx, &hellopb.HelloRequest{Name: name})
+		}
+		cancel()
+		if err != nil {
+			errs[status.Code(err)]++
+			continue
+		}
+		lat = append(lat, time.Since(start))
+	}
+	sort.Slice(lat, func(i, j int) bool { return lat[i] < lat[j] })
+	pct := func(p float64) time.Duration {
+		if len(lat) == 0 {
+			return 0
+		}
+		return lat[int(p*float64(len(lat)-1))].Round(time.Millisecond)
+	}
+	fmt.Printf("calls=%d ok=%d p50=%s p90=%s p99=%s max=%s\n", n, len(lat), pct(.5), pct(.9), pct(.99), pct(1))
+	for c, k := range errs {
+		fmt.Printf("  %s: %d\n", c, k)
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/slb-uk/grpc-hello/api/hellopb"
+)
+
+// greetEveryone sends n names on one GreetEveryone stream, acknowledging
+// each greeting after spending ackDelay on it, and prints how the server's
+// window settles. window > 0 caps the server's window from the first ack.
+func greetEveryone(ctx context.Context, client hellopb.GreeterClient, name string, n int, ackDelay time.Duration, window uint32) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stream, err := client.GreetEveryone(ctx)
+	if err != nil {
+		log.Fatalf("GreetEveryone: %s", describe(err))
+	}
+	// The name sender and the ack loop share the stream; gRPC allows only
+	// one Send at a time.
+	var mu sync.Mutex
+	send := func(req *hellopb.GreetEveryoneRequest) error {
+		mu.Lock()
+		defer mu.Unlock()
+		return stream.Send(req)
+	}
+
+	go func() {
+		for i := 1; i <= n; i++ {
+			req := &hellopb.GreetEveryoneRequest{Kind: &hellopb.GreetEveryoneRequest_Hello{
+				Hello: &hellopb.HelloRequest{Name: fmt.Sprintf("%s #%d", name, i)}}}
+			if err := send(req); err != nil {
+				return // Recv below reports why
+			}
+		}
+	}()
+
+	start := time.Now()
+	for got := 0; got < n; got++ {
+		res, err := stream.Recv()
+		if err == io.EOF {
+			log.Fatalf("stream ended after %d of %d greetings", got, n)
+		}
+		if err != nil {
+			log.Fatalf("GreetEveryone recv: %s", describe(err))
+		}
+		fmt.Printf("  %4d  window=%-3d buffered=%-4d %s\n", res.GetSeq(), res.GetWindow(), res.GetBuffered(), res.GetMessage())
+		time.Sleep(ackDelay) // "processing"
+		ack := &hellopb.GreetEveryoneRequest{Kind: &hellopb.GreetEveryoneRequest_Ack{
+			Ack: &hellopb.Ack{Seq: res.GetSeq(), Window: window}}}
+		// On io.EOF the server has ended the stream; the next Recv says why.
+		if err := send(ack); err != nil && err != io.EOF {
+			log.Fatalf("ack: %v", err)
+		}
+	}
+	mu.Lock()
+	_ = stream.CloseSend()
+	mu.Unlock()
+	if _, err := stream.Recv(); err != io.EOF {
+		log.Fatalf("GreetEveryone close: %s", describe(err))
+	}
+	elapsed := time.Since(start)
+	fmt.Printf("%d greetings in %s (%.1f/s)\n", n, elapsed.Round(time.Millisecond), float64(n)/elapsed.Seconds())
+}
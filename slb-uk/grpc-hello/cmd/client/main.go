@@ -2,25 +2,71 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
 	"io"
 	"log"
 	"os"
+	"strings"
 	"time"
 
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/encoding/gzip"
+	"google.golang.org/grpc/keepalive"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 
 	"github.com/slb-uk/grpc-hello/api/hellopb"
+	"github.com/slb-uk/grpc-hello/oauth"
+	"github.com/slb-uk/grpc-hello/transport"
 )
 
 func main() {
+	compress := flag.Bool("gzip", false, "send requests gzip-compressed (and ask for compressed responses)")
+	maxRecv := flag.Int("max-recv", 4<<20, "largest response message accepted, in bytes")
+	name := flag.String("name", "Rahul", "name to greet (empty or over 64 characters to see a structured error)")
+	lang := flag.String("lang", langFromEnv(), "preferred languages sent as accept-language, e.g. \"fr,en\"")
+	ping := flag.Duration("keepalive", 0, "client keepalive ping interval (0 disables; must be >= the server's GRPC_KEEPALIVE_MIN_TIME)")
+	calls := flag.Int("n", 0, "benchmark: make n SayHello calls and print latency percentiles instead of the demo")
+	retry := flag.Bool("retry", false, "retry SayHello on UNAVAILABLE (service config retryPolicy)")
+	hedge := flag.Duration("hedge", 0, "benchmark: send up to 2 extra copies of a call this long apart until one answers (0 disables)")
+	timeout := flag.Duration("timeout", 2*time.Second, "deadline per SayHello call")
+	everyone := flag.Int("everyone", 0, "greet n names over the flow-controlled GreetEveryone stream instead of the demo")
+	ackDelay := flag.Duration("ack-delay", 50*time.Millisecond, "everyone: time spent on each greeting before acknowledging it")
+	window := flag.Uint("window", 0, "everyone: cap the server's window at this many unacknowledged greetings (0: server's choice)")
+	cacheControl := flag.String("cache-control", "", "cache-control metadata for SayHello, e.g. no-cache, no-store or max-age=5")
+	flag.Parse()
+
 	addr := "localhost:50051"
 	if v := os.Getenv("GRPC_ADDR"); v != "" {
 		addr = v
 	}
-	conn, err := grpc.Dial(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	callOpts := []grpc.CallOption{grpc.MaxCallRecvMsgSize(*maxRecv)}
+	if *compress {
+		callOpts = append(callOpts, grpc.UseCompressor(gzip.Name))
+	}
+	dialOpts := []grpc.DialOption{
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithDefaultCallOptions(callOpts...),
+	}
+	if *retry {
+		dialOpts = append(dialOpts, grpc.WithDefaultServiceConfig(retryServiceConfig))
+	}
+	src, err := oauth.FromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if src != nil {
+		// the interceptor supplies authorization; GREETER_TOKEN is ignored
+		dialOpts = append(dialOpts,
+			grpc.WithChainUnaryInterceptor(src.UnaryClientInterceptor()),
+			grpc.WithChainStreamInterceptor(src.StreamClientInterceptor()))
+	}
+	if *ping > 0 {
+		dialOpts = append(dialOpts, grpc.WithKeepaliveParams(keepalive.ClientParameters{Time: *ping, Timeout: 10 * time.Second}))
+	}
+	conn, err := transport.Dial(addr, dialOpts...)
 	if err != nil {
 		log.Fatalf("dial: %v", err)
 	}
@@ -29,26 +75,49 @@ func main() {
 	client := hellopb.NewGreeterClient(conn)
 
 	// Prepare metadata (auth token optional)
-	var ctx context.Context = context.Background()
-	if tok := os.Getenv("GREETER_TOKEN"); tok != "" {
-		md := metadata.New(map[string]string{"authorization": "Bearer " + tok})
-		ctx = metadata.NewOutgoingContext(ctx, md)
+	md := metadata.New(map[string]string{"accept-language": *lang})
+	if *cacheControl != "" {
+		md.Set("cache-control", *cacheControl)
+	}
+	if tok := os.Getenv("GREETER_TOKEN"); tok != "" && src == nil {
+		md.Set("authorization", "Bearer "+tok)
+		if roles := os.Getenv("GREETER_ROLES"); roles != "" {
+			md.Set("x-roles", roles) // only honoured with the static token
+		}
+	}
+	ctx := metadata.NewOutgoingContext(context.Background(), md)
+
+	if *calls > 0 {
+		bench(ctx, client, *name, *calls, *timeout, *hedge)
+		return
+	}
+	if *everyone > 0 {
+		greetEveryone(ctx, client, *name, *everyone, *ackDelay, uint32(*window))
+		return
 	}
 
 	// Unary with timeout
-	uctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	uctx, cancel := context.WithTimeout(ctx, *timeout)
 	defer cancel()
 
-	res, err := client.SayHello(uctx, &hellopb.HelloRequest{Name: "Rahul"})
+	var header metadata.MD
+	res, err := client.SayHello(uctx, &hellopb.HelloRequest{Name: *name}, grpc.Header(&header))
 	if err != nil {
-		log.Fatalf("SayHello: %v", err)
+		log.Fatalf("SayHello: %s", describe(err))
 	}
 	fmt.Println("Unary:", res.GetMessage())
+	if v := header.Get("x-cache"); len(v) > 0 {
+		line := "  cache: " + v[0]
+		if age := header.Get("age"); len(age) > 0 {
+			line += ", age " + age[0] + "s"
+		}
+		fmt.Println(line)
+	}
 
 	// Server-streaming
-	stream, err := client.GreetManyTimes(ctx, &hellopb.HelloRequest{Name: "Rahul"})
+	stream, err := client.GreetManyTimes(ctx, &hellopb.HelloRequest{Name: *name})
 	if err != nil {
-		log.Fatalf("GreetManyTimes: %v", err)
+		log.Fatalf("GreetManyTimes: %s", describe(err))
 	}
 	fmt.Println("Stream:")
 	for {
@@ -57,8 +126,43 @@ func main() {
 			break
 		}
 		if err != nil {
-			log.Fatalf("stream recv: %v", err)
+			log.Fatalf("stream recv: %s", describe(err))
 		}
 		fmt.Println(" ", msg.GetMessage())
 	}
+}
+
+// describe renders an RPC error from its HelloError detail when present,
+// branching on the reason code rather than the status text.
+func describe(err error) string {
+	st, ok := status.FromError(err)
+	if !ok {
+		return err.Error()
+	}
+	for _, d := range st.Details() {
+		he, ok := d.(*hellopb.HelloError)
+		if !ok {
+			continue
+		}
+		msg := fmt.Sprintf("%s [%s, %s]", he.GetMessage(), he.GetReason(), he.GetLocale())
+		switch he.GetReason() {
+		case hellopb.HelloError_NAME_REQUIRED, hellopb.HelloError_NAME_TOO_LONG:
+			msg += " — try -name"
+		case hellopb.HelloError_UNAUTHENTICATED:
+			msg += " — set GREETER_TOKEN or OAUTH_TOKEN_URL"
+		case hellopb.HelloError_MISSING_ROLE:
+			msg += " — mint a token with one of: " + he.GetParams()["required"]
+		}
+		return msg
+	}
+	return fmt.Sprintf("%s (%s)", st.Message(), st.Code())
+}
+
+// langFromEnv turns LANG=fr_FR.UTF-8 into "fr-FR", defaulting to English.
+func langFromEnv() string {
+	v, _, _ := strings.Cut(os.Getenv("LANG"), ".")
+	if v == "" || v == "C" || v == "POSIX" {
+		return "en"
+	}
+	return strings.ReplaceAll(v, "_", "-")
 }
\ No newline at end of file
@@ -0,0 +1,93 @@
+// Command faultctl sets the server's injected latency and errors through the
+// FaultAdmin service (server started with GREETER_FAULT_ADMIN=true):
+//
+//	go run ./cmd/faultctl -delay 20ms -jitter 10ms -tail 5 -tail-delay 400ms
+//	go run ./cmd/faultctl -errors 20 -code UNAVAILABLE
+//	go run ./cmd/faultctl -show
+//	go run ./cmd/faultctl -clear
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/encoding/protojson"
+
+	"github.com/slb-uk/grpc-hello/api/hellopb"
+	"github.com/slb-uk/grpc-hello/transport"
+)
+
+func main() {
+	method := flag.String("method", hellopb.Greeter_SayHello_FullMethodName, "full method name, or * for every Greeter method")
+	delay := flag.Duration("delay", 0, "fixed delay per call")
+	jitter := flag.Duration("jitter", 0, "extra uniform delay, 0..jitter")
+	tail := flag.Float64("tail", 0, "percent of calls that take -tail-delay instead")
+	tailDelay := flag.Duration("tail-delay", 0, "delay of the slow tail")
+	errPct := flag.Float64("errors", 0, "percent of calls that fail")
+	code := flag.String("code", "UNAVAILABLE", "status code of injected failures")
+	show := flag.Bool("show", false, "print the current rules and exit")
+	clear := flag.Bool("clear", false, "remove every rule")
+	flag.Parse()
+
+	addr := "localhost:50051"
+	if v := os.Getenv("GRPC_ADDR"); v != "" {
+		addr = v
+	}
+	conn, err := transport.Dial(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		log.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	admin := hellopb.NewFaultAdminClient(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if tok := os.Getenv("GREETER_TOKEN"); tok != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+tok)
+		if roles := os.Getenv("GREETER_ROLES"); roles != "" {
+			ctx = metadata.AppendToOutgoingContext(ctx, "x-roles", roles)
+		}
+	}
+
+	var cfg *hellopb.FaultConfig
+	switch {
+	case *show:
+		cfg, err = admin.GetFaults(ctx, &hellopb.GetFaultsRequest{})
+	case *clear:
+		cfg, err = admin.SetFaults(ctx, &hellopb.SetFaultsRequest{})
+	default:
+		// Other methods keep their rules; this one is replaced.
+		cfg, err = admin.GetFaults(ctx, &hellopb.GetFaultsRequest{})
+		if err != nil {
+			break
+		}
+		rules := []*hellopb.FaultRule{{
+			Method:       *method,
+			DelayMs:      ms(*delay),
+			JitterMs:     ms(*jitter),
+			TailPercent:  *tail,
+			TailDelayMs:  ms(*tailDelay),
+			ErrorPercent: *errPct,
+			ErrorCode:    *code,
+		}}
+		for _, r := range cfg.GetRules() {
+			if r.GetMethod() != *method {
+				rules = append(rules, r)
+			}
+		}
+		cfg, err = admin.SetFaults(ctx, &hellopb.SetFaultsRequest{Rules: rules})
+	}
+	if err != nil {
+		log.Fatalf("fault admin: %v", err)
+	}
+	fmt.Println(protojson.MarshalOptions{Multiline: true}.Format(cfg))
+}
+
+func ms(d time.Duration) uint32 { return uint32(d / time.Millisecond) }
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/slb-uk/grpc-hello/api/hellopb"
+)
+
+// policy maps full method names to the roles allowed to call them; a caller
+// needs any one of the listed roles. Methods not listed only require an
+// authenticated caller.
+//
+//	{"methods": {"/hello.v1.Greeter/GreetManyTimes": ["streamer"]}}
+type policy struct {
+	Methods map[string][]string `json:"methods"`
+}
+
+func (p *policy) allowed(method string, roles []string) (required []string, ok bool) {
+	required, listed := p.Methods[method]
+	if !listed {
+		return nil, true
+	}
+	for _, want := range required {
+		for _, have := range roles {
+			if have == want {
+				return required, true
+			}
+		}
+	}
+	return required, false
+}
+
+// authorizer authenticates the bearer token and checks the caller's roles
+// against the current policy. Two kinds of token are accepted:
+//   - an HS256 JWT signed with GREETER_JWT_SECRET; roles come from its
+//     "roles" claim
+//   - the static GREETER_TOKEN; roles come from the x-roles metadata
+//     (comma separated), which is only trusted alongside that token
+type authorizer struct {
+	staticToken string
+	jwtSecret   []byte
+	policy      atomic.Pointer[policy]
+}
+
+func (a *authorizer) enabled() bool { return a.staticToken != "" || len(a.jwtSecret) > 0 }
+
+func (a *authorizer) check(ctx context.Context, method string) error {
+	if !a.enabled() {
+		return nil
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	vals := md.Get("authorization")
+	if len(vals) == 0 || !strings.HasPrefix(vals[0], "Bearer ") {
+		return helloError(ctx, codes.Unauthenticated, hellopb.HelloError_UNAUTHENTICATED, "", map[string]string{"cause": "missing bearer token"})
+	}
+	token := strings.TrimPrefix(vals[0], "Bearer ")
+
+	var roles []string
+	switch {
+	case a.staticToken != "" && hmac.Equal([]byte(token), []byte(a.staticToken)):
+		for _, v := range md.Get("x-roles") {
+			for _, r := range strings.Split(v, ",") {
+				if r = strings.TrimSpace(r); r != "" {
+					roles = append(roles, r)
+				}
+			}
+		}
+	case len(a.jwtSecret) > 0:
+		claims, err := verifyJWT(token, a.jwtSecret)
+		if err != nil {
+			return helloError(ctx, codes.Unauthenticated, hellopb.HelloError_UNAUTHENTICATED, "", map[string]string{"cause": "invalid token: " + err.Error()})
+		}
+		roles = claims.Roles
+	default:
+		return helloError(ctx, codes.Unauthenticated, hellopb.HelloError_UNAUTHENTICATED, "", map[string]string{"cause": "invalid token"})
+	}
+
+	required, ok := a.policy.Load().allowed(method, roles)
+	if ok {
+		return nil
+	}
+	params := map[string]string{"method": method, "required": strings.Join(required, ", ")}
+	return helloError(ctx, codes.PermissionDenied, hellopb.HelloError_MISSING_ROLE, "", params,
+		&errdetails.ErrorInfo{
+			Reason: "MISSING_ROLE",
+			Domain: "hello.v1",
+			Metadata: map[string]string{
+				"method":   method,
+				"required": strings.Join(required, ","),
+				"roles":    strings.Join(roles, ","),
+			},
+		})
+}
+
+func (a *authorizer) unary() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if err := a.check(ctx, info.FullMethod); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+func (a *authorizer) stream() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := a.check(ss.Context(), info.FullMethod); err != nil {
+			return err
+		}
+		return handler(srv, ss)
+	}
+}
+
+func loadPolicy(path string) (*policy, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var p policy
+	if err := json.Unmarshal(b, &p); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	for m := range p.Methods {
+		if !strings.HasPrefix(m, "/") {
+			return nil, fmt.Errorf("%s: method %q must be a full name like /hello.v1.Greeter/SayHello", path, m)
+		}
+	}
+	return &p, nil
+}
+
+// watchPolicy reloads the policy file whenever its modification time
+// changes. A file that fails to parse is logged and the previous policy
+// stays in force.
+func (a *authorizer) watchPolicy(ctx context.Context, path string, every time.Duration) {
+	var last time.Time
+	if fi, err := os.Stat(path); err == nil {
+		last = fi.ModTime() // already loaded at startup
+	}
+	t := time.NewTicker(every)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+		fi, err := os.Stat(path)
+		if err != nil || !fi.ModTime().After(last) {
+			continue
+		}
+		last = fi.ModTime()
+		p, err := loadPolicy(path)
+		if err != nil {
+			log.Printf("[authz] reload failed, keeping previous policy: %v", err)
+			continue
+		}
+		a.policy.Store(p)
+		log.Printf("[authz] policy reloaded from %s (%d methods)", path, len(p.Methods))
+	}
+}
+
+type jwtClaims struct {
+	Subject string   `json:"sub"`
+	Roles   []string `json:"roles"`
+	Expiry  int64    `json:"exp"`
+}
+
+// verifyJWT checks an HS256 compact JWT and its exp claim.
+func verifyJWT(token string, secret []byte) (*jwtClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed jwt")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil || header.Alg != "HS256" {
+		return nil, errors.New("unsupported jwt header")
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(parts[0] + "." + parts[1]))
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || !hmac.Equal(sig, mac.Sum(nil)) {
+		return nil, errors.New("bad signature")
+	}
+	var c jwtClaims
+	if err := decodeSegment(parts[1], &c); err != nil {
+		return nil, errors.New("bad claims")
+	}
+	if c.Expiry != 0 && time.Now().Unix() > c.Expiry {
+		return nil, errors.New("token expired")
+	}
+	return &c, nil
+}
+
+func decodeSegment(seg string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"expvar"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+)
+
+// cacheableMethods are the unary methods whose responses may be reused. A
+// method belongs here only if it is idempotent and its answer depends on
+// nothing but the request message: SayHello greets whoever is named, the
+// same way for every caller, so one caller's cached greeting is right for
+// the next. A method that reads state, or answers differently per caller or
+// per call, must not be listed.
+var cacheableMethods = map[string]bool{
+	"/hello.v1.Greeter/SayHello": true,
+}
+
+// Totals published with expvar at GREETER_METRICS_ADDR/debug/vars, under
+// response_cache.
+var cacheStats = expvar.NewMap("response_cache")
+
+// responseCache answers repeated calls to cacheableMethods from memory for
+// GREETER_CACHE_TTL. It sits behind authz, so a cached answer is only ever
+// served to callers allowed to make the call, and it never stores errors:
+// a failed or rejected call is retried for real next time.
+//
+// Callers steer it with the cache-control request metadata, as in HTTP:
+// no-cache skips the lookup but stores the fresh answer, no-store bypasses
+// the cache entirely, and max-age=N only accepts an entry at most N
+// seconds old. The response header x-cache says whether the answer was a
+// hit, a miss or a bypass; a hit also carries its age in seconds.
+type responseCache struct {
+	ttl time.Duration // 0 disables the cache
+	max int           // most entries kept; the least recently used goes first
+	now func() time.Time
+
+	mu    sync.Mutex
+	items map[string]*list.Element
+	lru   *list.List // of *cacheEntry, most recently used first
+}
+
+type cacheEntry struct {
+	key    string
+	resp   proto.Message
+	stored time.Time
+}
+
+// responseCacheFromEnv reads GREETER_CACHE_TTL (default 0, off) and
+// GREETER_CACHE_MAX_ENTRIES (default 1024).
+func responseCacheFromEnv() (*responseCache, error) {
+	var ttl time.Duration
+	if v := os.Getenv("GREETER_CACHE_TTL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("GREETER_CACHE_TTL=%q: want a duration such as 30s, or 0 to disable", v)
+		}
+		ttl = d
+	}
+	max := 1024
+	if v := os.Getenv("GREETER_CACHE_MAX_ENTRIES"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("GREETER_CACHE_MAX_ENTRIES=%q: want a positive number", v)
+		}
+		max = n
+	}
+	return newResponseCache(ttl, max), nil
+}
+
+func newResponseCache(ttl time.Duration, max int) *responseCache {
+	return &responseCache{ttl: ttl, max: max, now: time.Now, items: map[string]*list.Element{}, lru: list.New()}
+}
+
+// cacheKey names a call by its method and a hash of the request. The
+// encoding is deterministic, so equal requests give equal keys whatever
+// order their map fields were filled in.
+func cacheKey(method string, req interface{}) (string, bool) {
+	m, ok := req.(proto.Message)
+	if !ok {
+		return "", false
+	}
+	b, err := proto.MarshalOptions{Deterministic: true}.Marshal(m)
+	if err != nil {
+		return "", false
+	}
+	sum := sha256.Sum256(b)
+	return method + "|" + hex.EncodeToString(sum[:]), true
+}
+
+// cacheControl holds the directives of a cache-control request header.
+type cacheControl struct {
+	noCache, noStore bool
+	maxAge           time.Duration // -1 when not given
+}
+
+func parseCacheControl(ctx context.Context) cacheControl {
+	cc := cacheControl{maxAge: -1}
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, v := range md.Get("cache-control") {
+		for _, d := range strings.Split(v, ",") {
+			name, arg, _ := strings.Cut(strings.ToLower(strings.TrimSpace(d)), "=")
+			switch name {
+			case "no-cache":
+				cc.noCache = true
+			case "no-store":
+				cc.noStore = true
+			case "max-age":
+				if n, err := strconv.Atoi(arg); err == nil && n >= 0 {
+					cc.maxAge = time.Duration(n) * time.Second
+				}
+			}
+		}
+	}
+	return cc
+}
+
+// get returns a copy of the entry under key when it is fresh, along with
+// its age. Expired entries are dropped as they are found.
+func (c *responseCache) get(key string, maxAge time.Duration) (proto.Message, time.Duration, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.items[key]
+	if !ok {
+		return nil, 0, false
+	}
+	e := el.Value.(*cacheEntry)
+	age := c.now().Sub(e.stored)
+	if age >= c.ttl {
+		c.remove(el)
+		cacheStats.Add("expired_total", 1)
+		return nil, 0, false
+	}
+	if maxAge >= 0 && age > maxAge {
+		return nil, 0, false // too old for this caller, still fine for others
+	}
+	c.lru.MoveToFront(el)
+	return proto.Clone(e.resp), age, true
+}
+
+func (c *responseCache) put(key string, resp proto.Message) {
+	e := &cacheEntry{key: key, resp: proto.Clone(resp), stored: c.now()}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[key]; ok {
+		el.Value = e
+		c.lru.MoveToFront(el)
+	} else {
+		c.items[key] = c.lru.PushFront(e)
+		cacheStats.Add("entries", 1)
+	}
+	cacheStats.Add("stores_total", 1)
+	for c.lru.Len() > c.max {
+		c.remove(c.lru.Back())
+		cacheStats.Add("evictions_total", 1)
+	}
+}
+
+// remove drops el; c.mu is held.
+func (c *responseCache) remove(el *list.Element) {
+	c.lru.Remove(el)
+	delete(c.items, el.Value.(*cacheEntry).key)
+	cacheStats.Add("entries", -1)
+}
+
+func (c *responseCache) unary() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if c.ttl <= 0 || !cacheableMethods[info.FullMethod] {
+			return handler(ctx, req)
+		}
+		key, ok := cacheKey(info.FullMethod, req)
+		if !ok {
+			return handler(ctx, req)
+		}
+		cc := parseCacheControl(ctx)
+		if cc.noStore {
+			cacheStats.Add("bypass_total", 1)
+			grpc.SetHeader(ctx, metadata.Pairs("x-cache", "bypass"))
+			return handler(ctx, req)
+		}
+		if !cc.noCache {
+			if resp, age, ok := c.get(key, cc.maxAge); ok {
+				cacheStats.Add("hits_total", 1)
+				grpc.SetHeader(ctx, metadata.Pairs("x-cache", "hit", "age", strconv.Itoa(int(age/time.Second))))
+				return resp, nil
+			}
+		}
+		cacheStats.Add("misses_total", 1)
+		grpc.SetHeader(ctx, metadata.Pairs("x-cache", "miss"))
+		resp, err := handler(ctx, req)
+		if err != nil {
+			return resp, err
+		}
+		if m, ok := resp.(proto.Message); ok {
+			c.put(key, m)
+		}
+		return resp, nil
+	}
+}
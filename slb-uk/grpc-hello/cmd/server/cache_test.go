@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/slb-uk/grpc-hello/api/hellopb"
+	"github.com/slb-uk/grpc-hello/greetertest"
+)
+
+// countingGreeter counts the SayHello calls that reach the handler.
+type countingGreeter struct {
+	*greeterServer
+	calls atomic.Int32
+}
+
+func (g *countingGreeter) SayHello(ctx context.Context, req *hellopb.HelloRequest) (*hellopb.HelloResponse, error) {
+	g.calls.Add(1)
+	return g.greeterServer.SayHello(ctx, req)
+}
+
+func startCached(t *testing.T, c *responseCache) (hellopb.GreeterClient, *countingGreeter) {
+	t.Helper()
+	g := &countingGreeter{greeterServer: &greeterServer{}}
+	conn := greetertest.Start(t, g, grpc.ChainUnaryInterceptor(c.unary()))
+	return hellopb.NewGreeterClient(conn), g
+}
+
+// sayHello calls SayHello for name with the given cache-control, if any,
+// and returns the x-cache response header.
+func sayHello(t *testing.T, c hellopb.GreeterClient, name, cacheControl string) (string, error) {
+	t.Helper()
+	ctx := context.Background()
+	if cacheControl != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, "cache-control", cacheControl)
+	}
+	var header metadata.MD
+	res, err := c.SayHello(ctx, &hellopb.HelloRequest{Name: name}, grpc.Header(&header))
+	if err == nil && res.GetMessage() != "Hello, "+name+"! 👋" {
+		t.Fatalf("got %q", res.GetMessage())
+	}
+	if v := header.Get("x-cache"); len(v) > 0 {
+		return v[0], err
+	}
+	return "", err
+}
+
+func TestCacheHitsAndBypass(t *testing.T) {
+	client, g := startCached(t, newResponseCache(time.Minute, 8))
+	steps := []struct {
+		name, cacheControl, want string
+		calls                    int32 // handler calls so far
+	}{
+		{"Ada", "", "miss", 1},
+		{"Ada", "", "hit", 1},
+		{"Grace", "", "miss", 2}, // another request, another key
+		{"Ada", "no-cache", "miss", 3},
+		{"Ada", "no-store", "bypass", 4},
+		{"Ada", "max-age=60", "hit", 4},
+		{"Ada", "", "hit", 4},
+	}
+	for i, s := range steps {
+		got, err := sayHello(t, client, s.name, s.cacheControl)
+		if err != nil {
+			t.Fatalf("step %d: %v", i, err)
+		}
+		if got != s.want || g.calls.Load() != s.calls {
+			t.Fatalf("step %d (%s, %q): x-cache=%q calls=%d, want %q and %d", i, s.name, s.cacheControl, got, g.calls.Load(), s.want, s.calls)
+		}
+	}
+}
+
+func TestCacheSkipsErrors(t *testing.T) {
+	client, g := startCached(t, newResponseCache(time.Minute, 8))
+	for i := 0; i < 2; i++ {
+		if _, err := sayHello(t, client, "", ""); err == nil {
+			t.Fatal("empty name accepted")
+		}
+	}
+	if n := g.calls.Load(); n != 2 {
+		t.Fatalf("handler called %d times, want an error never served from the cache", n)
+	}
+}
+
+func TestCacheExpiresAndEvicts(t *testing.T) {
+	c := newResponseCache(time.Minute, 2)
+	now := time.Now()
+	c.now = func() time.Time { return now }
+	client, g := startCached(t, c)
+	call := func(name, want string) {
+		t.Helper()
+		if got, err := sayHello(t, client, name, ""); err != nil || got != want {
+			t.Fatalf("%s: x-cache=%q err=%v, want %q", name, got, err, want)
+		}
+	}
+
+	call("Ada", "miss")
+	now = now.Add(30 * time.Second)
+	call("Ada", "hit")
+	if _, err := sayHello(t, client, "Ada", "max-age=10"); err != nil || g.calls.Load() != 2 {
+		t.Fatalf("max-age=10 on a 30s old entry: calls=%d err=%v", g.calls.Load(), err)
+	}
+	now = now.Add(time.Minute)
+	call("Ada", "miss")
+
+	// only two entries fit: the least recently used one goes
+	call("Grace", "miss")
+	call("Ada", "hit")
+	call("Linus", "miss")
+	call("Ada", "hit")
+	call("Grace", "miss")
+}
+
+func TestCacheOffByDefault(t *testing.T) {
+	t.Setenv("GREETER_CACHE_TTL", "")
+	c, err := responseCacheFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, g := startCached(t, c)
+	for i := 0; i < 2; i++ {
+		if got, err := sayHello(t, client, "Ada", ""); err != nil || got != "" {
+			t.Fatalf("x-cache=%q err=%v", got, err)
+		}
+	}
+	if g.calls.Load() != 2 {
+		t.Fatalf("handler called %d times", g.calls.Load())
+	}
+
+	t.Setenv("GREETER_CACHE_TTL", "-1s")
+	if _, err := responseCacheFromEnv(); err == nil {
+		t.Fatal("negative TTL accepted")
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/encoding/gzip"
+	"google.golang.org/grpc/keepalive"
+)
+
+// serverConfig holds the transport knobs that matter in production but are
+// all left at their defaults by a bare grpc.NewServer().
+type serverConfig struct {
+	MaxRecvMsgSize int // bytes accepted per request message
+	MaxSendMsgSize int // bytes allowed per response message
+	GzipLevel      int // 0 keeps the gzip default
+
+	// Enforcement: clients pinging more often than KeepaliveMinTime (or with
+	// no active stream unless PermitWithoutStream) get GOAWAY too_many_pings.
+	KeepaliveMinTime    time.Duration
+	PermitWithoutStream bool
+
+	// Server-side pings and connection lifetime. MaxConnectionAge forces
+	// clients to reconnect periodically so load spreads over new replicas.
+	KeepaliveTime         time.Duration
+	KeepaliveTimeout      time.Duration
+	MaxConnectionIdle     time.Duration
+	MaxConnectionAge      time.Duration
+	MaxConnectionAgeGrace time.Duration
+
+	// GreetEveryone flow control, see flow.go.
+	FlowMaxWindow int
+	FlowMaxBuffer int
+	FlowTargetRTT time.Duration
+}
+
+func loadConfig() (serverConfig, error) {
+	c := serverConfig{
+		MaxRecvMsgSize:        4 << 20,
+		MaxSendMsgSize:        4 << 20,
+		KeepaliveMinTime:      30 * time.Second,
+		KeepaliveTime:         2 * time.Hour,
+		KeepaliveTimeout:      20 * time.Second,
+		MaxConnectionAgeGrace: 10 * time.Second,
+		FlowMaxWindow:         32,
+		FlowMaxBuffer:         256,
+		FlowTargetRTT:         200 * time.Millisecond,
+	}
+	var err error
+	set := func(dst interface{}, key string) {
+		v := os.Getenv(key)
+		if v == "" || err != nil {
+			return
+		}
+		switch p := dst.(type) {
+		case *int:
+			*p, err = strconv.Atoi(v)
+		case *bool:
+			*p, err = strconv.ParseBool(v)
+		case *time.Duration:
+			*p, err = time.ParseDuration(v)
+		}
+		if err != nil {
+			err = fmt.Errorf("%s=%q: %w", key, v, err)
+		}
+	}
+	set(&c.MaxRecvMsgSize, "GRPC_MAX_RECV_MSG_BYTES")
+	set(&c.MaxSendMsgSize, "GRPC_MAX_SEND_MSG_BYTES")
+	set(&c.GzipLevel, "GRPC_GZIP_LEVEL")
+	set(&c.KeepaliveMinTime, "GRPC_KEEPALIVE_MIN_TIME")
+	set(&c.PermitWithoutStream, "GRPC_KEEPALIVE_PERMIT_WITHOUT_STREAM")
+	set(&c.KeepaliveTime, "GRPC_KEEPALIVE_TIME")
+	set(&c.KeepaliveTimeout, "GRPC_KEEPALIVE_TIMEOUT")
+	set(&c.MaxConnectionIdle, "GRPC_MAX_CONNECTION_IDLE")
+	set(&c.MaxConnectionAge, "GRPC_MAX_CONNECTION_AGE")
+	set(&c.MaxConnectionAgeGrace, "GRPC_MAX_CONNECTION_AGE_GRACE")
+	set(&c.FlowMaxWindow, "GREETER_FLOW_MAX_WINDOW")
+	set(&c.FlowMaxBuffer, "GREETER_FLOW_MAX_BUFFER")
+	set(&c.FlowTargetRTT, "GREETER_FLOW_TARGET_RTT")
+	if err == nil && (c.FlowMaxWindow < 1 || c.FlowMaxBuffer < 1 || c.FlowTargetRTT <= 0) {
+		err = fmt.Errorf("GREETER_FLOW_MAX_WINDOW, GREETER_FLOW_MAX_BUFFER and GREETER_FLOW_TARGET_RTT must be positive")
+	}
+	return c, err
+}
+
+// options turns the config into server options. Importing the gzip package
+// registers the compressor: the server then accepts gzip requests and
+// answers compressed clients in kind.
+func (c serverConfig) options() ([]grpc.ServerOption, error) {
+	if c.GzipLevel != 0 {
+		if err := gzip.SetLevel(c.GzipLevel); err != nil {
+			return nil, fmt.Errorf("GRPC_GZIP_LEVEL: %w", err)
+		}
+	}
+	return []grpc.ServerOption{
+		grpc.MaxRecvMsgSize(c.MaxRecvMsgSize),
+		grpc.MaxSendMsgSize(c.MaxSendMsgSize),
+		grpc.KeepaliveEnforcementPolicy(keepalive.EnforcementPolicy{
+			MinTime:             c.KeepaliveMinTime,
+			PermitWithoutStream: c.PermitWithoutStream,
+		}),
+		grpc.KeepaliveParams(keepalive.ServerParameters{
+			MaxConnectionIdle:     c.MaxConnectionIdle,
+			MaxConnectionAge:      c.MaxConnectionAge,
+			MaxConnectionAgeGrace: c.MaxConnectionAgeGrace,
+			Time:                  c.KeepaliveTime,
+			Timeout:               c.KeepaliveTimeout,
+		}),
+	}, nil
+}
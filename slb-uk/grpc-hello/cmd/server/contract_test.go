@@ -0,0 +1,18 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/slb-uk/grpc-hello/api/hellopb"
+	"github.com/slb-uk/grpc-hello/greetertest"
+)
+
+// The server must keep the contract greetertest's mock is tested against.
+func TestServerHonoursContract(t *testing.T) {
+	cfg, err := loadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := greetertest.Start(t, &greeterServer{cfg: cfg})
+	greetertest.Contract(t, hellopb.NewGreeterClient(conn))
+}
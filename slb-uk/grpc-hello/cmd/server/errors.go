@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/protoadapt"
+
+	"github.com/slb-uk/grpc-hello/api/hellopb"
+)
+
+const defaultLocale = "en"
+
+// catalog holds the human text for each reason per locale; {key} is replaced
+// with the matching param. English is the fallback and must cover every
+// reason.
+var catalog = map[string]map[hellopb.HelloError_Reason]string{
+	"en": {
+		hellopb.HelloError_NAME_REQUIRED:   "Please tell us your name.",
+		hellopb.HelloError_NAME_TOO_LONG:   "Names can be at most {max} characters (got {len}).",
+		hellopb.HelloError_UNAUTHENTICATED: "Sign in to be greeted.",
+		hellopb.HelloError_MISSING_ROLE:    "You need one of the roles {required} to do that.",
+	},
+	"fr": {
+		hellopb.HelloError_NAME_REQUIRED:   "Merci d'indiquer votre nom.",
+		hellopb.HelloError_NAME_TOO_LONG:   "Un nom ne peut dépasser {max} caractères ({len} reçus).",
+		hellopb.HelloError_UNAUTHENTICATED: "Connectez-vous pour être salué.",
+		hellopb.HelloError_MISSING_ROLE:    "Il vous faut l'un des rôles {required} pour cela.",
+	},
+	"hi": {
+		hellopb.HelloError_NAME_REQUIRED:   "कृपया अपना नाम बताएं।",
+		hellopb.HelloError_NAME_TOO_LONG:   "नाम अधिकतम {max} अक्षरों का हो सकता है ({len} मिले)।",
+		hellopb.HelloError_UNAUTHENTICATED: "अभिवादन के लिए साइन इन करें।",
+		hellopb.HelloError_MISSING_ROLE:    "इसके लिए {required} में से कोई एक भूमिका चाहिए।",
+	},
+}
+
+// negotiateLocale picks the first supported language from the caller's
+// accept-language metadata ("fr-CA, en;q=0.8"), matching on the base
+// language. Quality values are ignored; callers list preferences in order.
+func negotiateLocale(ctx context.Context) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, v := range md.Get("accept-language") {
+		for _, tag := range strings.Split(v, ",") {
+			tag, _, _ = strings.Cut(strings.TrimSpace(tag), ";")
+			base, _, _ := strings.Cut(strings.ToLower(tag), "-")
+			if _, ok := catalog[base]; ok {
+				return base
+			}
+		}
+	}
+	return defaultLocale
+}
+
+func localize(locale string, reason hellopb.HelloError_Reason, params map[string]string) string {
+	text, ok := catalog[locale][reason]
+	if !ok {
+		text = catalog[defaultLocale][reason]
+	}
+	for k, v := range params {
+		text = strings.ReplaceAll(text, "{"+k+"}", v)
+	}
+	return text
+}
+
+// helloError builds a status whose message is the English text (for logs and
+// clients that ignore details) and whose details carry a HelloError in the
+// caller's language. extra details, such as an ErrorInfo, are appended.
+func helloError(ctx context.Context, code codes.Code, reason hellopb.HelloError_Reason, field string, params map[string]string, extra ...protoadapt.MessageV1) error {
+	locale := negotiateLocale(ctx)
+	detail := &hellopb.HelloError{
+		Reason:  reason,
+		Locale:  locale,
+		Message: localize(locale, reason, params),
+		Field:   field,
+		Params:  params,
+	}
+	st := status.New(code, localize(defaultLocale, reason, params))
+	withDetails, err := st.WithDetails(append([]protoadapt.MessageV1{detail}, extra...)...)
+	if err != nil {
+		return st.Err()
+	}
+	return withDetails.Err()
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/slb-uk/grpc-hello/api/hellopb"
+)
+
+// faultInjector delays and fails Greeter calls according to rules set over
+// the FaultAdmin service. The rule set is swapped atomically, so a change
+// applies to the next call without restarting the server.
+type faultInjector struct {
+	hellopb.UnimplementedFaultAdminServer
+	rules atomic.Pointer[map[string]*hellopb.FaultRule]
+}
+
+func newFaultInjector() *faultInjector {
+	f := &faultInjector{}
+	f.rules.Store(&map[string]*hellopb.FaultRule{})
+	return f
+}
+
+func (f *faultInjector) SetFaults(ctx context.Context, req *hellopb.SetFaultsRequest) (*hellopb.FaultConfig, error) {
+	next := map[string]*hellopb.FaultRule{}
+	for _, r := range req.GetRules() {
+		if err := validateFault(r); err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		next[r.GetMethod()] = proto.Clone(r).(*hellopb.FaultRule)
+	}
+	f.rules.Store(&next)
+	log.Printf("[faults] %d rule(s) set", len(next))
+	return f.GetFaults(ctx, nil)
+}
+
+func (f *faultInjector) GetFaults(context.Context, *hellopb.GetFaultsRequest) (*hellopb.FaultConfig, error) {
+	cfg := &hellopb.FaultConfig{}
+	for _, r := range *f.rules.Load() {
+		cfg.Rules = append(cfg.Rules, r)
+	}
+	sort.Slice(cfg.Rules, func(i, j int) bool { return cfg.Rules[i].GetMethod() < cfg.Rules[j].GetMethod() })
+	return cfg, nil
+}
+
+func validateFault(r *hellopb.FaultRule) error {
+	if m := r.GetMethod(); m != "*" && !strings.HasPrefix(m, "/") {
+		return fmt.Errorf("method %q must be a full name like /hello.v1.Greeter/SayHello, or *", m)
+	}
+	for _, p := range []float64{r.GetTailPercent(), r.GetErrorPercent()} {
+		if p < 0 || p > 100 {
+			return fmt.Errorf("percentages must be within 0..100, got %v", p)
+		}
+	}
+	_, err := faultCode(r.GetErrorCode())
+	return err
+}
+
+func faultCode(name string) (codes.Code, error) {
+	if name == "" {
+		return codes.Unavailable, nil
+	}
+	var c codes.Code
+	if err := c.UnmarshalJSON([]byte(strconv.Quote(strings.ToUpper(name)))); err != nil || c == codes.OK {
+		return 0, fmt.Errorf("unknown error code %q", name)
+	}
+	return c, nil
+}
+
+// inject sleeps and maybe fails for method. The sleep ends early when the
+// caller gives up, which is what a hedged or timed-out attempt does.
+func (f *faultInjector) inject(ctx context.Context, method string) error {
+	if strings.HasPrefix(method, "/hello.v1.FaultAdmin/") {
+		return nil
+	}
+	rules := *f.rules.Load()
+	r, ok := rules[method]
+	if !ok {
+		if r, ok = rules["*"]; !ok {
+			return nil
+		}
+	}
+	delay := time.Duration(r.GetDelayMs()) * time.Millisecond
+	if j := r.GetJitterMs(); j > 0 {
+		delay += time.Duration(rand.Int63n(int64(j)+1)) * time.Millisecond
+	}
+	if rand.Float64()*100 < r.GetTailPercent() {
+		delay = time.Duration(r.GetTailDelayMs()) * time.Millisecond
+	}
+	fail := rand.Float64()*100 < r.GetErrorPercent()
+	if delay > 0 || fail {
+		log.Printf("[faults] method=%s attempt=%s delay=%s fail=%t", method, attempt(ctx), delay, fail)
+	}
+	if delay > 0 {
+		t := time.NewTimer(delay)
+		defer t.Stop()
+		select {
+		case <-ctx.Done():
+			return status.FromContextError(ctx.Err()).Err()
+		case <-t.C:
+		}
+	}
+	if fail {
+		code, _ := faultCode(r.GetErrorCode())
+		return status.Errorf(code, "injected fault on %s", method)
+	}
+	return nil
+}
+
+// attempt names the try this is: grpc-go's retry policy sends
+// grpc-previous-rpc-attempts, the demo client's hedges send x-hedge.
+func attempt(ctx context.Context) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if v := md.Get("x-hedge"); len(v) > 0 {
+		return "hedge-" + v[0]
+	}
+	if v := md.Get("grpc-previous-rpc-attempts"); len(v) > 0 {
+		return "retry-" + v[0]
+	}
+	return "first"
+}
+
+func (f *faultInjector) unary() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if err := f.inject(ctx, info.FullMethod); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+func (f *faultInjector) stream() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := f.inject(ss.Context(), info.FullMethod); err != nil {
+			return err
+		}
+		return handler(srv, ss)
+	}
+}
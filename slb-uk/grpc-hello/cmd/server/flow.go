@@ -0,0 +1,200 @@
+package main
+
+import (
+	"expvar"
+	"fmt"
+	"io"
+	"log"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/slb-uk/grpc-hello/api/hellopb"
+)
+
+// GreetEveryone shows flow control on top of a gRPC stream. HTTP/2 flow
+// control only bounds bytes sitting in transport buffers: a slow client
+// still receives as fast as the network allows, and whatever it has not
+// handled piles up in its own memory. Here the client acknowledges each
+// greeting once it has processed it, and the server keeps at most a window
+// of greetings unacknowledged.
+//
+// The window adapts like TCP's congestion window (AIMD). When a whole
+// window comes back acknowledged within GREETER_FLOW_TARGET_RTT, the window
+// grows by one. When an ack is slower than that, the window halves, at
+// most once per window's worth of greetings, as in TCP. The
+// window stays between 1 and GREETER_FLOW_MAX_WINDOW, or the smaller cap a
+// client sends in Ack.window. A client that takes d per greeting ends up
+// with a window of about target/d, so the send rate follows the client.
+//
+// The server has to keep reading the request stream to see acks, so it
+// cannot push back on names by not reading. Names are buffered instead, up
+// to GREETER_FLOW_MAX_BUFFER per stream, and a client that sends more fails
+// with RESOURCE_EXHAUSTED. When the client half-closes, the stream ends and
+// names still buffered are dropped.
+//
+// Totals over all streams are published with expvar at
+// GREETER_METRICS_ADDR/debug/vars, under greet_everyone.
+var flowStats = expvar.NewMap("greet_everyone")
+
+// flowWindow is the send state of one stream. It is only used by the
+// handler goroutine.
+type flowWindow struct {
+	maxWindow, clientCap int
+	targetRTT            time.Duration
+
+	window      int
+	buffer      []string    // names not sent yet
+	sentAt      []time.Time // send times of unacknowledged greetings, oldest first
+	sent, acked uint64
+	growCredit  int    // acks towards the next window increase
+	shrunkAt    uint64 // last sent seq when the window last shrank
+}
+
+func newFlowWindow(maxWindow int, targetRTT time.Duration) *flowWindow {
+	return &flowWindow{maxWindow: maxWindow, targetRTT: targetRTT, window: min(4, maxWindow)}
+}
+
+func (f *flowWindow) limit() int {
+	if f.clientCap > 0 && f.clientCap < f.maxWindow {
+		return f.clientCap
+	}
+	return f.maxWindow
+}
+
+func (f *flowWindow) canSend() bool { return len(f.buffer) > 0 && len(f.sentAt) < f.window }
+
+func (f *flowWindow) push(name string) {
+	f.buffer = append(f.buffer, name)
+	flowStats.Add("buffered", 1)
+}
+
+// next takes the oldest buffered name and records it as sent.
+func (f *flowWindow) next(now time.Time) (uint64, string) {
+	name := f.buffer[0]
+	f.buffer = f.buffer[1:]
+	f.sentAt = append(f.sentAt, now)
+	f.sent++
+	flowStats.Add("buffered", -1)
+	flowStats.Add("in_flight", 1)
+	flowStats.Add("sent_total", 1)
+	return f.sent, name
+}
+
+// ack applies a cumulative ack and resizes the window.
+func (f *flowWindow) ack(seq uint64, clientCap uint32, now time.Time) error {
+	if seq > f.sent {
+		return status.Errorf(codes.InvalidArgument, "ack %d for a greeting not sent yet (last sent %d)", seq, f.sent)
+	}
+	if clientCap > 0 {
+		f.clientCap = int(clientCap)
+	}
+	if seq > f.acked {
+		n := int(seq - f.acked)
+		rtt := now.Sub(f.sentAt[n-1])
+		f.sentAt = f.sentAt[n:]
+		f.acked = seq
+		flowStats.Add("in_flight", int64(-n))
+		flowStats.Add("acked_total", int64(n))
+
+		if rtt > f.targetRTT {
+			// Greetings sent before the last shrink were still sent
+			// into the bigger window; don't punish their acks again.
+			if f.window > 1 && seq > f.shrunkAt {
+				f.window /= 2
+				f.shrunkAt = f.sent
+				flowStats.Add("window_shrinks_total", 1)
+			}
+			f.growCredit = 0
+		} else if f.growCredit += n; f.growCredit >= f.window {
+			f.growCredit = 0
+			if f.window < f.limit() {
+				f.window++
+				flowStats.Add("window_grows_total", 1)
+			}
+		}
+	}
+	if f.window > f.limit() {
+		f.window = f.limit()
+	}
+	return nil
+}
+
+// close takes the stream's remaining messages off the gauges.
+func (f *flowWindow) close() {
+	flowStats.Add("buffered", -int64(len(f.buffer)))
+	flowStats.Add("in_flight", -int64(len(f.sentAt)))
+	flowStats.Add("streams", -1)
+}
+
+// Bidirectional streaming RPC with flow control
+func (g *greeterServer) GreetEveryone(stream hellopb.Greeter_GreetEveryoneServer) error {
+	ctx := stream.Context()
+	f := newFlowWindow(g.cfg.FlowMaxWindow, g.cfg.FlowTargetRTT)
+	flowStats.Add("streams", 1)
+	defer f.close()
+
+	// Recv blocks, so it runs in its own goroutine; the handler owns f and
+	// is the only one calling Send.
+	reqs := make(chan *hellopb.GreetEveryoneRequest)
+	recvErr := make(chan error, 1)
+	go func() {
+		for {
+			req, err := stream.Recv()
+			if err != nil {
+				recvErr <- err
+				return
+			}
+			select {
+			case reqs <- req:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	for {
+		for f.canSend() {
+			seq, name := f.next(time.Now())
+			err := stream.Send(&hellopb.GreetEveryoneResponse{
+				Seq:      seq,
+				Message:  fmt.Sprintf("Hello, %s!", name),
+				Window:   uint32(f.window),
+				Buffered: uint32(len(f.buffer)),
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case err := <-recvErr:
+			if err != io.EOF {
+				return err
+			}
+			log.Printf("[FLOW] stream done: sent=%d acked=%d window=%d dropped=%d", f.sent, f.acked, f.window, len(f.buffer))
+			return nil
+		case req := <-reqs:
+			switch k := req.GetKind().(type) {
+			case *hellopb.GreetEveryoneRequest_Hello:
+				name := k.Hello.GetName()
+				if err := validateName(ctx, name); err != nil {
+					return err
+				}
+				if len(f.buffer) >= g.cfg.FlowMaxBuffer {
+					flowStats.Add("buffer_overflows_total", 1)
+					return status.Errorf(codes.ResourceExhausted,
+						"more than %d names waiting; wait for greetings before sending more names", g.cfg.FlowMaxBuffer)
+				}
+				f.push(name)
+			case *hellopb.GreetEveryoneRequest_Ack:
+				if err := f.ack(k.Ack.GetSeq(), k.Ack.GetWindow(), time.Now()); err != nil {
+					return err
+				}
+			}
+		}
+	}
+}
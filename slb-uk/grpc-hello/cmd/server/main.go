@@ -5,19 +5,39 @@ import (
 	"fmt"
 	"log"
 	"net"
+	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
+	"unicode/utf8"
 
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/codes"
 
 	"github.com/slb-uk/grpc-hello/api/hellopb"
+	"github.com/slb-uk/grpc-hello/transport"
 )
 
 type greeterServer struct {
 	hellopb.UnimplementedGreeterServer
+	cfg serverConfig
+}
+
+const maxNameLen = 64
+
+func validateName(ctx context.Context, name string) error {
+	n := utf8.RuneCountInString(strings.TrimSpace(name))
+	switch {
+	case n == 0:
+		return helloError(ctx, codes.InvalidArgument, hellopb.HelloError_NAME_REQUIRED, "name", nil)
+	case n > maxNameLen:
+		return helloError(ctx, codes.InvalidArgument, hellopb.HelloError_NAME_TOO_LONG, "name",
+			map[string]string{"max": strconv.Itoa(maxNameLen), "len": strconv.Itoa(n)})
+	}
+	return nil
 }
 
 // Unary RPC
@@ -28,12 +48,18 @@ func (g *greeterServer) SayHello(ctx context.Context, req *hellopb.HelloRequest)
 	default:
 	}
 	name := req.GetName()
+	if err := validateName(ctx, name); err != nil {
+		return nil, err
+	}
 	return &hellopb.HelloResponse{Message: fmt.Sprintf("Hello, %s! 👋", name)}, nil
 }
 
 // Server-streaming RPC
 func (g *greeterServer) GreetManyTimes(req *hellopb.HelloRequest, stream hellopb.Greeter_GreetManyTimesServer) error {
 	name := req.GetName()
+	if err := validateName(stream.Context(), name); err != nil {
+		return err
+	}
 	for i := 1; i <= 5; i++ {
 		select {
 		case <-stream.Context().Done():
@@ -62,44 +88,91 @@ func unaryLoggerInterceptor(
 	return resp, err
 }
 
-func authUnaryInterceptor(validToken string) grpc.UnaryServerInterceptor {
-	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
-		if validToken == "" { // auth disabled
-			return handler(ctx, req)
-		}
-		md, ok := metadata.FromIncomingContext(ctx)
-		if !ok {
-			return nil, fmt.Errorf("missing metadata")
+func main() {
+	// GRPC_ADDR may list several addresses, e.g. ":50051,unix:///run/greeter.sock"
+	// to serve a sidecar over the socket while keeping TCP for everyone else.
+	addrs := []string{":50051"}
+	if v := transport.SplitAddrs(os.Getenv("GRPC_ADDR")); len(v) > 0 {
+		addrs = v
+	}
+	authz := &authorizer{
+		staticToken: os.Getenv("GREETER_TOKEN"),              // optional
+		jwtSecret:   []byte(os.Getenv("GREETER_JWT_SECRET")), // optional
+	}
+	authz.policy.Store(&policy{})
+	if path := os.Getenv("GREETER_POLICY_FILE"); path != "" {
+		p, err := loadPolicy(path)
+		if err != nil {
+			log.Fatalf("policy: %v", err)
 		}
-		vals := md.Get("authorization")
-		expected := "Bearer " + validToken
-		if len(vals) == 0 || vals[0] != expected {
-			return nil, fmt.Errorf("unauthorized")
+		authz.policy.Store(p)
+		ctx, stop := context.WithCancel(context.Background())
+		defer stop()
+		go authz.watchPolicy(ctx, path, 2*time.Second)
+	}
+
+	var listeners []net.Listener
+	for _, addr := range addrs {
+		lis, err := transport.Listen(addr)
+		if err != nil {
+			log.Fatalf("listen %s: %v", addr, err)
 		}
-		return handler(ctx, req)
+		listeners = append(listeners, lis)
 	}
-}
 
-func main() {
-	addr := ":50051"
-	if v := os.Getenv("GRPC_ADDR"); v != "" {
-		addr = v
+	cfg, err := loadConfig()
+	if err != nil {
+		log.Fatalf("config: %v", err)
+	}
+	opts, err := cfg.options()
+	if err != nil {
+		log.Fatalf("config: %v", err)
+	}
+	log.Printf("transport: max_recv=%d max_send=%d keepalive_min=%s max_conn_age=%s", cfg.MaxRecvMsgSize, cfg.MaxSendMsgSize, cfg.KeepaliveMinTime, cfg.MaxConnectionAge)
+
+	payloads, err := payloadLoggerFromEnv()
+	if err != nil {
+		log.Fatalf("config: %v", err)
+	}
+	if payloads.rate > 0 {
+		log.Printf("payload logging: %.0f%% of calls, sensitive fields redacted", payloads.rate*100)
 	}
-	token := os.Getenv("GREETER_TOKEN") // optional
 
-	lis, err := net.Listen("tcp", addr)
+	cache, err := responseCacheFromEnv()
 	if err != nil {
-		log.Fatalf("listen: %v", err)
+		log.Fatalf("config: %v", err)
+	}
+	if cache.ttl > 0 {
+		log.Printf("response cache: ttl=%s max_entries=%d", cache.ttl, cache.max)
 	}
 
-	s := grpc.NewServer(
+	faults := newFaultInjector()
+	s := grpc.NewServer(append(opts,
 		grpc.ChainUnaryInterceptor(
 			unaryLoggerInterceptor,
-			authUnaryInterceptor(token),
+			authz.unary(),
+			payloads.unary(),
+			cache.unary(), // a hit skips injected faults, as it would a slow backend
+			faults.unary(),
 		),
-	)
+		grpc.ChainStreamInterceptor(authz.stream(), payloads.stream(), faults.stream()),
+	)...)
 
-	hellopb.RegisterGreeterServer(s, &greeterServer{})
+	hellopb.RegisterGreeterServer(s, &greeterServer{cfg: cfg})
+	if os.Getenv("GREETER_FAULT_ADMIN") == "true" {
+		hellopb.RegisterFaultAdminServer(s, faults)
+		log.Println("fault injection admin enabled")
+	}
+
+	// expvar registers /debug/vars on the default mux (flow.go)
+	if addr := os.Getenv("GREETER_METRICS_ADDR"); addr != "" {
+		go func() {
+			log.Printf("metrics on http://%s/debug/vars", addr)
+			if err := http.ListenAndServe(addr, nil); err != nil {
+				log.Printf("metrics: %v", err)
+			}
+		}()
+	}
 
 	// Graceful shutdown
 	go func() {
@@ -110,8 +183,16 @@ func main() {
 		s.GracefulStop()
 	}()
 
-	log.Printf("gRPC server listening on %s", addr)
-	if err := s.Serve(lis); err != nil {
-		log.Fatalf("serve: %v", err)
+	// Serve returns nil after GracefulStop; the first real error stops the rest.
+	errc := make(chan error, len(listeners))
+	for _, lis := range listeners {
+		log.Printf("gRPC server listening on %s", lis.Addr())
+		go func(lis net.Listener) { errc <- s.Serve(lis) }(lis)
+	}
+	for range listeners {
+		if err := <-errc; err != nil {
+			s.Stop()
+			log.Fatalf("serve: %v", err)
+		}
 	}
 }
\ No newline at end of file
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"strconv"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+
+	"github.com/slb-uk/grpc-hello/api/hellopb"
+)
+
+const redacted = "[REDACTED]"
+
+// payloadLogger logs whole request and response messages for a sample of
+// calls, with every field marked [(hello.v1.sensitive) = true] redacted.
+// The sampling decision is made once per call, so a sampled stream logs all
+// of its messages.
+type payloadLogger struct {
+	rate float64 // 0 disables, 1 logs every call
+}
+
+// payloadLoggerFromEnv reads GREETER_PAYLOAD_LOG_RATE, a fraction of calls
+// between 0 (default, off) and 1.
+func payloadLoggerFromEnv() (*payloadLogger, error) {
+	p := &payloadLogger{}
+	if v := os.Getenv("GREETER_PAYLOAD_LOG_RATE"); v != "" {
+		r, err := strconv.ParseFloat(v, 64)
+		if err != nil || r < 0 || r > 1 {
+			return nil, fmt.Errorf("GREETER_PAYLOAD_LOG_RATE=%q: want a fraction between 0 and 1", v)
+		}
+		p.rate = r
+	}
+	return p, nil
+}
+
+func (p *payloadLogger) sampled() bool {
+	return p.rate > 0 && (p.rate >= 1 || rand.Float64() < p.rate)
+}
+
+func (p *payloadLogger) unary() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if !p.sampled() {
+			return handler(ctx, req)
+		}
+		start := time.Now()
+		resp, err := handler(ctx, req)
+		log.Printf("[PAYLOAD] method=%s dur=%s code=%s req=%s resp=%s",
+			info.FullMethod, time.Since(start), status.Code(err), redactedJSON(req), redactedJSON(resp))
+		return resp, err
+	}
+}
+
+func (p *payloadLogger) stream() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if !p.sampled() {
+			return handler(srv, ss)
+		}
+		start := time.Now()
+		err := handler(srv, &loggedStream{ServerStream: ss, method: info.FullMethod})
+		log.Printf("[PAYLOAD] method=%s dur=%s code=%s stream closed", info.FullMethod, time.Since(start), status.Code(err))
+		return err
+	}
+}
+
+type loggedStream struct {
+	grpc.ServerStream
+	method string
+}
+
+func (s *loggedStream) RecvMsg(m interface{}) error {
+	err := s.ServerStream.RecvMsg(m)
+	if err == nil {
+		log.Printf("[PAYLOAD] method=%s recv=%s", s.method, redactedJSON(m))
+	}
+	return err
+}
+
+func (s *loggedStream) SendMsg(m interface{}) error {
+	log.Printf("[PAYLOAD] method=%s send=%s", s.method, redactedJSON(m))
+	return s.ServerStream.SendMsg(m)
+}
+
+// redactedJSON renders m as one-line JSON with sensitive fields redacted.
+// The message itself is not modified.
+func redactedJSON(m interface{}) string {
+	msg, ok := m.(proto.Message)
+	if !ok || msg == nil || !msg.ProtoReflect().IsValid() {
+		return "null"
+	}
+	c := proto.Clone(msg)
+	redact(c.ProtoReflect())
+	b, err := protojson.MarshalOptions{}.Marshal(c)
+	if err != nil {
+		return fmt.Sprintf("%q", err.Error())
+	}
+	return string(b)
+}
+
+// redact rewrites sensitive fields in place: strings and bytes become
+// "[REDACTED]" (so the log still shows the field was set), anything else is
+// cleared. Nested messages, lists and maps are walked.
+func redact(m protoreflect.Message) {
+	m.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		if isSensitive(fd) {
+			switch {
+			case fd.IsList() || fd.IsMap():
+				m.Clear(fd)
+			case fd.Kind() == protoreflect.StringKind:
+				m.Set(fd, protoreflect.ValueOfString(redacted))
+			case fd.Kind() == protoreflect.BytesKind:
+				m.Set(fd, protoreflect.ValueOfBytes([]byte(redacted)))
+			default:
+				m.Clear(fd)
+			}
+			return true
+		}
+		switch {
+		case fd.IsList() && fd.Message() != nil:
+			l := v.List()
+			for i := 0; i < l.Len(); i++ {
+				redact(l.Get(i).Message())
+			}
+		case fd.IsMap() && fd.MapValue().Message() != nil:
+			v.Map().Range(func(_ protoreflect.MapKey, mv protoreflect.Value) bool {
+				redact(mv.Message())
+				return true
+			})
+		case !fd.IsList() && !fd.IsMap() && fd.Message() != nil:
+			redact(v.Message())
+		}
+		return true
+	})
+}
+
+func isSensitive(fd protoreflect.FieldDescriptor) bool {
+	s, _ := proto.GetExtension(fd.Options(), hellopb.E_Sensitive).(bool)
+	return s
+}
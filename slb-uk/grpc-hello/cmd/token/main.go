@@ -0,0 +1,95 @@
+// Command token mints an HS256 JWT for trying out the server's role checks:
+//
+//	export GREETER_JWT_SECRET=dev-secret
+//	GREETER_TOKEN=$(go run ./cmd/token -sub rahul -roles streamer) go run ./cmd/client
+//
+// With -serve it is instead a stand-in for an identity provider's token
+// endpoint: it answers client-credentials requests at POST /token with a
+// token for the client (sub = client id, the -roles, the -ttl), so the
+// client's oauth interceptor can be tried end to end:
+//
+//	go run ./cmd/token -serve :9000 -client greeter-client:dev-client-secret -roles greeter,streamer -ttl 2m
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+func main() {
+	sub := flag.String("sub", "demo", "subject claim")
+	roles := flag.String("roles", "", "comma separated roles claim")
+	ttl := flag.Duration("ttl", time.Hour, "token lifetime")
+	serve := flag.String("serve", "", "run a client-credentials token endpoint on this address instead")
+	client := flag.String("client", "greeter-client:dev-client-secret", "serve: the one accepted client, as id:secret")
+	flag.Parse()
+
+	secret := os.Getenv("GREETER_JWT_SECRET")
+	if secret == "" {
+		log.Fatal("GREETER_JWT_SECRET is required")
+	}
+	if *serve == "" {
+		fmt.Println(mint(secret, *sub, *roles, *ttl))
+		return
+	}
+	id, clientSecret, ok := strings.Cut(*client, ":")
+	if !ok || id == "" {
+		log.Fatalf("-client: %q is not id:secret", *client)
+	}
+	http.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		deny := func(code int, e, desc string) {
+			w.WriteHeader(code)
+			json.NewEncoder(w).Encode(map[string]string{"error": e, "error_description": desc})
+		}
+		if r.Method != http.MethodPost {
+			deny(http.StatusMethodNotAllowed, "invalid_request", "use POST")
+			return
+		}
+		if gt := r.PostFormValue("grant_type"); gt != "client_credentials" {
+			deny(http.StatusBadRequest, "unsupported_grant_type", fmt.Sprintf("grant_type %q", gt))
+			return
+		}
+		// client credentials are form-encoded before Basic auth (RFC 6749 2.3.1)
+		u, p, ok := r.BasicAuth()
+		u, _ = url.QueryUnescape(u)
+		p, _ = url.QueryUnescape(p)
+		if !ok || !hmac.Equal([]byte(u), []byte(id)) || !hmac.Equal([]byte(p), []byte(clientSecret)) {
+			w.Header().Set("WWW-Authenticate", `Basic realm="token"`)
+			deny(http.StatusUnauthorized, "invalid_client", "unknown client or wrong secret")
+			return
+		}
+		log.Printf("token: issued to %s for %s (scope %q)", u, *ttl, r.PostFormValue("scope"))
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": mint(secret, u, *roles, *ttl),
+			"token_type":   "Bearer",
+			"expires_in":   int64(ttl.Seconds()),
+		})
+	})
+	log.Printf("token endpoint on %s/token for client %s", *serve, id)
+	log.Fatal(http.ListenAndServe(*serve, nil))
+}
+
+func mint(secret, sub, roles string, ttl time.Duration) string {
+	claims := map[string]interface{}{"sub": sub, "exp": time.Now().Add(ttl).Unix(), "roles": []string{}}
+	if roles != "" {
+		claims["roles"] = strings.Split(roles, ",")
+	}
+	header, _ := json.Marshal(map[string]string{"alg": "HS256", "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signing := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(signing))
+	return signing + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
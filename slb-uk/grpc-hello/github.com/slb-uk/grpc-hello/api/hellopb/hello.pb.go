@@ -9,6 +9,7 @@ package hellopb
 import (
 	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
 	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	descriptorpb "google.golang.org/protobuf/types/descriptorpb"
 	reflect "reflect"
 	sync "sync"
 	unsafe "unsafe"
@@ -21,6 +22,61 @@ const (
 	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
 )
 
+type HelloError_Reason int32
+
+const (
+	HelloError_REASON_UNSPECIFIED HelloError_Reason = 0
+	HelloError_NAME_REQUIRED      HelloError_Reason = 1
+	HelloError_NAME_TOO_LONG      HelloError_Reason = 2
+	HelloError_UNAUTHENTICATED    HelloError_Reason = 3
+	HelloError_MISSING_ROLE       HelloError_Reason = 4
+)
+
+// Enum value maps for HelloError_Reason.
+var (
+	HelloError_Reason_name = map[int32]string{
+		0: "REASON_UNSPECIFIED",
+		1: "NAME_REQUIRED",
+		2: "NAME_TOO_LONG",
+		3: "UNAUTHENTICATED",
+		4: "MISSING_ROLE",
+	}
+	HelloError_Reason_value = map[string]int32{
+		"REASON_UNSPECIFIED": 0,
+		"NAME_REQUIRED":      1,
+		"NAME_TOO_LONG":      2,
+		"UNAUTHENTICATED":    3,
+		"MISSING_ROLE":       4,
+	}
+)
+
+func (x HelloError_Reason) Enum() *HelloError_Reason {
+	p := new(HelloError_Reason)
+	*p = x
+	return p
+}
+
+func (x HelloError_Reason) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (HelloError_Reason) Descriptor() protoreflect.EnumDescriptor {
+	return file_api_hello_proto_enumTypes[0].Descriptor()
+}
+
+func (HelloError_Reason) Type() protoreflect.EnumType {
+	return &file_api_hello_proto_enumTypes[0]
+}
+
+func (x HelloError_Reason) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use HelloError_Reason.Descriptor instead.
+func (HelloError_Reason) EnumDescriptor() ([]byte, []int) {
+	return file_api_hello_proto_rawDescGZIP(), []int{5, 0}
+}
+
 type HelloRequest struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	Name          string                 `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
@@ -109,18 +165,577 @@ func (x *HelloResponse) GetMessage() string {
 	return ""
 }
 
+type GreetEveryoneRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Types that are valid to be assigned to Kind:
+	//
+	//	*GreetEveryoneRequest_Hello
+	//	*GreetEveryoneRequest_Ack
+	Kind          isGreetEveryoneRequest_Kind `protobuf_oneof:"kind"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GreetEveryoneRequest) Reset() {
+	*x = GreetEveryoneRequest{}
+	mi := &file_api_hello_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GreetEveryoneRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GreetEveryoneRequest) ProtoMessage() {}
+
+func (x *GreetEveryoneRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_api_hello_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GreetEveryoneRequest.ProtoReflect.Descriptor instead.
+func (*GreetEveryoneRequest) Descriptor() ([]byte, []int) {
+	return file_api_hello_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *GreetEveryoneRequest) GetKind() isGreetEveryoneRequest_Kind {
+	if x != nil {
+		return x.Kind
+	}
+	return nil
+}
+
+func (x *GreetEveryoneRequest) GetHello() *HelloRequest {
+	if x != nil {
+		if x, ok := x.Kind.(*GreetEveryoneRequest_Hello); ok {
+			return x.Hello
+		}
+	}
+	return nil
+}
+
+func (x *GreetEveryoneRequest) GetAck() *Ack {
+	if x != nil {
+		if x, ok := x.Kind.(*GreetEveryoneRequest_Ack); ok {
+			return x.Ack
+		}
+	}
+	return nil
+}
+
+type isGreetEveryoneRequest_Kind interface {
+	isGreetEveryoneRequest_Kind()
+}
+
+type GreetEveryoneRequest_Hello struct {
+	Hello *HelloRequest `protobuf:"bytes,1,opt,name=hello,proto3,oneof"`
+}
+
+type GreetEveryoneRequest_Ack struct {
+	Ack *Ack `protobuf:"bytes,2,opt,name=ack,proto3,oneof"`
+}
+
+func (*GreetEveryoneRequest_Hello) isGreetEveryoneRequest_Kind() {}
+
+func (*GreetEveryoneRequest_Ack) isGreetEveryoneRequest_Kind() {}
+
+type Ack struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Seq           uint64                 `protobuf:"varint,1,opt,name=seq,proto3" json:"seq,omitempty"`
+	Window        uint32                 `protobuf:"varint,2,opt,name=window,proto3" json:"window,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Ack) Reset() {
+	*x = Ack{}
+	mi := &file_api_hello_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Ack) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Ack) ProtoMessage() {}
+
+func (x *Ack) ProtoReflect() protoreflect.Message {
+	mi := &file_api_hello_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Ack.ProtoReflect.Descriptor instead.
+func (*Ack) Descriptor() ([]byte, []int) {
+	return file_api_hello_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *Ack) GetSeq() uint64 {
+	if x != nil {
+		return x.Seq
+	}
+	return 0
+}
+
+func (x *Ack) GetWindow() uint32 {
+	if x != nil {
+		return x.Window
+	}
+	return 0
+}
+
+type GreetEveryoneResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Seq           uint64                 `protobuf:"varint,1,opt,name=seq,proto3" json:"seq,omitempty"`
+	Message       string                 `protobuf:"bytes,2,opt,name=message,proto3" json:"message,omitempty"`
+	Window        uint32                 `protobuf:"varint,3,opt,name=window,proto3" json:"window,omitempty"`
+	Buffered      uint32                 `protobuf:"varint,4,opt,name=buffered,proto3" json:"buffered,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GreetEveryoneResponse) Reset() {
+	*x = GreetEveryoneResponse{}
+	mi := &file_api_hello_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GreetEveryoneResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GreetEveryoneResponse) ProtoMessage() {}
+
+func (x *GreetEveryoneResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_api_hello_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GreetEveryoneResponse.ProtoReflect.Descriptor instead.
+func (*GreetEveryoneResponse) Descriptor() ([]byte, []int) {
+	return file_api_hello_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *GreetEveryoneResponse) GetSeq() uint64 {
+	if x != nil {
+		return x.Seq
+	}
+	return 0
+}
+
+func (x *GreetEveryoneResponse) GetMessage() string {
+	if x != nil {
+		return x.Message
+	}
+	return ""
+}
+
+func (x *GreetEveryoneResponse) GetWindow() uint32 {
+	if x != nil {
+		return x.Window
+	}
+	return 0
+}
+
+func (x *GreetEveryoneResponse) GetBuffered() uint32 {
+	if x != nil {
+		return x.Buffered
+	}
+	return 0
+}
+
+type HelloError struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Reason        HelloError_Reason      `protobuf:"varint,1,opt,name=reason,proto3,enum=hello.v1.HelloError_Reason" json:"reason,omitempty"`
+	Locale        string                 `protobuf:"bytes,2,opt,name=locale,proto3" json:"locale,omitempty"`
+	Message       string                 `protobuf:"bytes,3,opt,name=message,proto3" json:"message,omitempty"`
+	Field         string                 `protobuf:"bytes,4,opt,name=field,proto3" json:"field,omitempty"`
+	Params        map[string]string      `protobuf:"bytes,5,rep,name=params,proto3" json:"params,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *HelloError) Reset() {
+	*x = HelloError{}
+	mi := &file_api_hello_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *HelloError) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*HelloError) ProtoMessage() {}
+
+func (x *HelloError) ProtoReflect() protoreflect.Message {
+	mi := &file_api_hello_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use HelloError.ProtoReflect.Descriptor instead.
+func (*HelloError) Descriptor() ([]byte, []int) {
+	return file_api_hello_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *HelloError) GetReason() HelloError_Reason {
+	if x != nil {
+		return x.Reason
+	}
+	return HelloError_REASON_UNSPECIFIED
+}
+
+func (x *HelloError) GetLocale() string {
+	if x != nil {
+		return x.Locale
+	}
+	return ""
+}
+
+func (x *HelloError) GetMessage() string {
+	if x != nil {
+		return x.Message
+	}
+	return ""
+}
+
+func (x *HelloError) GetField() string {
+	if x != nil {
+		return x.Field
+	}
+	return ""
+}
+
+func (x *HelloError) GetParams() map[string]string {
+	if x != nil {
+		return x.Params
+	}
+	return nil
+}
+
+type FaultRule struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Method        string                 `protobuf:"bytes,1,opt,name=method,proto3" json:"method,omitempty"`
+	DelayMs       uint32                 `protobuf:"varint,2,opt,name=delay_ms,json=delayMs,proto3" json:"delay_ms,omitempty"`
+	JitterMs      uint32                 `protobuf:"varint,3,opt,name=jitter_ms,json=jitterMs,proto3" json:"jitter_ms,omitempty"`
+	TailPercent   float64                `protobuf:"fixed64,4,opt,name=tail_percent,json=tailPercent,proto3" json:"tail_percent,omitempty"`
+	TailDelayMs   uint32                 `protobuf:"varint,5,opt,name=tail_delay_ms,json=tailDelayMs,proto3" json:"tail_delay_ms,omitempty"`
+	ErrorPercent  float64                `protobuf:"fixed64,6,opt,name=error_percent,json=errorPercent,proto3" json:"error_percent,omitempty"`
+	ErrorCode     string                 `protobuf:"bytes,7,opt,name=error_code,json=errorCode,proto3" json:"error_code,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *FaultRule) Reset() {
+	*x = FaultRule{}
+	mi := &file_api_hello_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *FaultRule) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*FaultRule) ProtoMessage() {}
+
+func (x *FaultRule) ProtoReflect() protoreflect.Message {
+	mi := &file_api_hello_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use FaultRule.ProtoReflect.Descriptor instead.
+func (*FaultRule) Descriptor() ([]byte, []int) {
+	return file_api_hello_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *FaultRule) GetMethod() string {
+	if x != nil {
+		return x.Method
+	}
+	return ""
+}
+
+func (x *FaultRule) GetDelayMs() uint32 {
+	if x != nil {
+		return x.DelayMs
+	}
+	return 0
+}
+
+func (x *FaultRule) GetJitterMs() uint32 {
+	if x != nil {
+		return x.JitterMs
+	}
+	return 0
+}
+
+func (x *FaultRule) GetTailPercent() float64 {
+	if x != nil {
+		return x.TailPercent
+	}
+	return 0
+}
+
+func (x *FaultRule) GetTailDelayMs() uint32 {
+	if x != nil {
+		return x.TailDelayMs
+	}
+	return 0
+}
+
+func (x *FaultRule) GetErrorPercent() float64 {
+	if x != nil {
+		return x.ErrorPercent
+	}
+	return 0
+}
+
+func (x *FaultRule) GetErrorCode() string {
+	if x != nil {
+		return x.ErrorCode
+	}
+	return ""
+}
+
+type SetFaultsRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Rules         []*FaultRule           `protobuf:"bytes,1,rep,name=rules,proto3" json:"rules,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SetFaultsRequest) Reset() {
+	*x = SetFaultsRequest{}
+	mi := &file_api_hello_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SetFaultsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SetFaultsRequest) ProtoMessage() {}
+
+func (x *SetFaultsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_api_hello_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SetFaultsRequest.ProtoReflect.Descriptor instead.
+func (*SetFaultsRequest) Descriptor() ([]byte, []int) {
+	return file_api_hello_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *SetFaultsRequest) GetRules() []*FaultRule {
+	if x != nil {
+		return x.Rules
+	}
+	return nil
+}
+
+type GetFaultsRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetFaultsRequest) Reset() {
+	*x = GetFaultsRequest{}
+	mi := &file_api_hello_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetFaultsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetFaultsRequest) ProtoMessage() {}
+
+func (x *GetFaultsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_api_hello_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetFaultsRequest.ProtoReflect.Descriptor instead.
+func (*GetFaultsRequest) Descriptor() ([]byte, []int) {
+	return file_api_hello_proto_rawDescGZIP(), []int{8}
+}
+
+type FaultConfig struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Rules         []*FaultRule           `protobuf:"bytes,1,rep,name=rules,proto3" json:"rules,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *FaultConfig) Reset() {
+	*x = FaultConfig{}
+	mi := &file_api_hello_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *FaultConfig) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*FaultConfig) ProtoMessage() {}
+
+func (x *FaultConfig) ProtoReflect() protoreflect.Message {
+	mi := &file_api_hello_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use FaultConfig.ProtoReflect.Descriptor instead.
+func (*FaultConfig) Descriptor() ([]byte, []int) {
+	return file_api_hello_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *FaultConfig) GetRules() []*FaultRule {
+	if x != nil {
+		return x.Rules
+	}
+	return nil
+}
+
+var file_api_hello_proto_extTypes = []protoimpl.ExtensionInfo{
+	{
+		ExtendedType:  (*descriptorpb.FieldOptions)(nil),
+		ExtensionType: (*bool)(nil),
+		Field:         50001,
+		Name:          "hello.v1.sensitive",
+		Tag:           "varint,50001,opt,name=sensitive",
+		Filename:      "api/hello.proto",
+	},
+}
+
+// Extension fields to descriptorpb.FieldOptions.
+var (
+	// optional bool sensitive = 50001;
+	E_Sensitive = &file_api_hello_proto_extTypes[0]
+)
+
 var File_api_hello_proto protoreflect.FileDescriptor
 
 const file_api_hello_proto_rawDesc = "" +
 	"\n" +
-	"\x0fapi/hello.proto\x12\bhello.v1\"\"\n" +
-	"\fHelloRequest\x12\x12\n" +
-	"\x04name\x18\x01 \x01(\tR\x04name\")\n" +
-	"\rHelloResponse\x12\x18\n" +
-	"\amessage\x18\x01 \x01(\tR\amessage2\x8b\x01\n" +
+	"\x0fapi/hello.proto\x12\bhello.v1\x1a google/protobuf/descriptor.proto\"(\n" +
+	"\fHelloRequest\x12\x18\n" +
+	"\x04name\x18\x01 \x01(\tB\x04\x88\xb5\x18\x01R\x04name\"/\n" +
+	"\rHelloResponse\x12\x1e\n" +
+	"\amessage\x18\x01 \x01(\tB\x04\x88\xb5\x18\x01R\amessage\"q\n" +
+	"\x14GreetEveryoneRequest\x12.\n" +
+	"\x05hello\x18\x01 \x01(\v2\x16.hello.v1.HelloRequestH\x00R\x05hello\x12!\n" +
+	"\x03ack\x18\x02 \x01(\v2\r.hello.v1.AckH\x00R\x03ackB\x06\n" +
+	"\x04kind\"/\n" +
+	"\x03Ack\x12\x10\n" +
+	"\x03seq\x18\x01 \x01(\x04R\x03seq\x12\x16\n" +
+	"\x06window\x18\x02 \x01(\rR\x06window\"}\n" +
+	"\x15GreetEveryoneResponse\x12\x10\n" +
+	"\x03seq\x18\x01 \x01(\x04R\x03seq\x12\x1e\n" +
+	"\amessage\x18\x02 \x01(\tB\x04\x88\xb5\x18\x01R\amessage\x12\x16\n" +
+	"\x06window\x18\x03 \x01(\rR\x06window\x12\x1a\n" +
+	"\bbuffered\x18\x04 \x01(\rR\bbuffered\"\xed\x02\n" +
+	"\n" +
+	"HelloError\x123\n" +
+	"\x06reason\x18\x01 \x01(\x0e2\x1b.hello.v1.HelloError.ReasonR\x06reason\x12\x16\n" +
+	"\x06locale\x18\x02 \x01(\tR\x06locale\x12\x18\n" +
+	"\amessage\x18\x03 \x01(\tR\amessage\x12\x14\n" +
+	"\x05field\x18\x04 \x01(\tR\x05field\x128\n" +
+	"\x06params\x18\x05 \x03(\v2 .hello.v1.HelloError.ParamsEntryR\x06params\x1a9\n" +
+	"\vParamsEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\"m\n" +
+	"\x06Reason\x12\x16\n" +
+	"\x12REASON_UNSPECIFIED\x10\x00\x12\x11\n" +
+	"\rNAME_REQUIRED\x10\x01\x12\x11\n" +
+	"\rNAME_TOO_LONG\x10\x02\x12\x13\n" +
+	"\x0fUNAUTHENTICATED\x10\x03\x12\x10\n" +
+	"\fMISSING_ROLE\x10\x04\"\xe6\x01\n" +
+	"\tFaultRule\x12\x16\n" +
+	"\x06method\x18\x01 \x01(\tR\x06method\x12\x19\n" +
+	"\bdelay_ms\x18\x02 \x01(\rR\adelayMs\x12\x1b\n" +
+	"\tjitter_ms\x18\x03 \x01(\rR\bjitterMs\x12!\n" +
+	"\ftail_percent\x18\x04 \x01(\x01R\vtailPercent\x12\"\n" +
+	"\rtail_delay_ms\x18\x05 \x01(\rR\vtailDelayMs\x12#\n" +
+	"\rerror_percent\x18\x06 \x01(\x01R\ferrorPercent\x12\x1d\n" +
+	"\n" +
+	"error_code\x18\a \x01(\tR\terrorCode\"=\n" +
+	"\x10SetFaultsRequest\x12)\n" +
+	"\x05rules\x18\x01 \x03(\v2\x13.hello.v1.FaultRuleR\x05rules\"\x12\n" +
+	"\x10GetFaultsRequest\"8\n" +
+	"\vFaultConfig\x12)\n" +
+	"\x05rules\x18\x01 \x03(\v2\x13.hello.v1.FaultRuleR\x05rules2\xe1\x01\n" +
 	"\aGreeter\x12;\n" +
 	"\bSayHello\x12\x16.hello.v1.HelloRequest\x1a\x17.hello.v1.HelloResponse\x12C\n" +
-	"\x0eGreetManyTimes\x12\x16.hello.v1.HelloRequest\x1a\x17.hello.v1.HelloResponse0\x01B2Z0github.com/slb-uk/grpc-hello/api/hellopb;hellopbb\x06proto3"
+	"\x0eGreetManyTimes\x12\x16.hello.v1.HelloRequest\x1a\x17.hello.v1.HelloResponse0\x01\x12T\n" +
+	"\rGreetEveryone\x12\x1e.hello.v1.GreetEveryoneRequest\x1a\x1f.hello.v1.GreetEveryoneResponse(\x010\x012\x8c\x01\n" +
+	"\n" +
+	"FaultAdmin\x12>\n" +
+	"\tSetFaults\x12\x1a.hello.v1.SetFaultsRequest\x1a\x15.hello.v1.FaultConfig\x12>\n" +
+	"\tGetFaults\x12\x1a.hello.v1.GetFaultsRequest\x1a\x15.hello.v1.FaultConfig:=\n" +
+	"\tsensitive\x12\x1d.google.protobuf.FieldOptions\x18ц\x03 \x01(\bR\tsensitiveB2Z0github.com/slb-uk/grpc-hello/api/hellopb;hellopbb\x06proto3"
 
 var (
 	file_api_hello_proto_rawDescOnce sync.Once
@@ -134,21 +749,46 @@ func file_api_hello_proto_rawDescGZIP() []byte {
 	return file_api_hello_proto_rawDescData
 }
 
-var file_api_hello_proto_msgTypes = make([]protoimpl.MessageInfo, 2)
+var file_api_hello_proto_enumTypes = make([]protoimpl.EnumInfo, 1)
+var file_api_hello_proto_msgTypes = make([]protoimpl.MessageInfo, 11)
 var file_api_hello_proto_goTypes = []any{
-	(*HelloRequest)(nil),  // 0: hello.v1.HelloRequest
-	(*HelloResponse)(nil), // 1: hello.v1.HelloResponse
+	(HelloError_Reason)(0),            // 0: hello.v1.HelloError.Reason
+	(*HelloRequest)(nil),              // 1: hello.v1.HelloRequest
+	(*HelloResponse)(nil),             // 2: hello.v1.HelloResponse
+	(*GreetEveryoneRequest)(nil),      // 3: hello.v1.GreetEveryoneRequest
+	(*Ack)(nil),                       // 4: hello.v1.Ack
+	(*GreetEveryoneResponse)(nil),     // 5: hello.v1.GreetEveryoneResponse
+	(*HelloError)(nil),                // 6: hello.v1.HelloError
+	(*FaultRule)(nil),                 // 7: hello.v1.FaultRule
+	(*SetFaultsRequest)(nil),          // 8: hello.v1.SetFaultsRequest
+	(*GetFaultsRequest)(nil),          // 9: hello.v1.GetFaultsRequest
+	(*FaultConfig)(nil),               // 10: hello.v1.FaultConfig
+	nil,                               // 11: hello.v1.HelloError.ParamsEntry
+	(*descriptorpb.FieldOptions)(nil), // 12: google.protobuf.FieldOptions
 }
 var file_api_hello_proto_depIdxs = []int32{
-	0, // 0: hello.v1.Greeter.SayHello:input_type -> hello.v1.HelloRequest
-	0, // 1: hello.v1.Greeter.GreetManyTimes:input_type -> hello.v1.HelloRequest
-	1, // 2: hello.v1.Greeter.SayHello:output_type -> hello.v1.HelloResponse
-	1, // 3: hello.v1.Greeter.GreetManyTimes:output_type -> hello.v1.HelloResponse
-	2, // [2:4] is the sub-list for method output_type
-	0, // [0:2] is the sub-list for method input_type
-	0, // [0:0] is the sub-list for extension type_name
-	0, // [0:0] is the sub-list for extension extendee
-	0, // [0:0] is the sub-list for field type_name
+	1,  // 0: hello.v1.GreetEveryoneRequest.hello:type_name -> hello.v1.HelloRequest
+	4,  // 1: hello.v1.GreetEveryoneRequest.ack:type_name -> hello.v1.Ack
+	0,  // 2: hello.v1.HelloError.reason:type_name -> hello.v1.HelloError.Reason
+	11, // 3: hello.v1.HelloError.params:type_name -> hello.v1.HelloError.ParamsEntry
+	7,  // 4: hello.v1.SetFaultsRequest.rules:type_name -> hello.v1.FaultRule
+	7,  // 5: hello.v1.FaultConfig.rules:type_name -> hello.v1.FaultRule
+	12, // 6: hello.v1.sensitive:extendee -> google.protobuf.FieldOptions
+	1,  // 7: hello.v1.Greeter.SayHello:input_type -> hello.v1.HelloRequest
+	1,  // 8: hello.v1.Greeter.GreetManyTimes:input_type -> hello.v1.HelloRequest
+	3,  // 9: hello.v1.Greeter.GreetEveryone:input_type -> hello.v1.GreetEveryoneRequest
+	8,  // 10: hello.v1.FaultAdmin.SetFaults:input_type -> hello.v1.SetFaultsRequest
+	9,  // 11: hello.v1.FaultAdmin.GetFaults:input_type -> hello.v1.GetFaultsRequest
+	2,  // 12: hello.v1.Greeter.SayHello:output_type -> hello.v1.HelloResponse
+	2,  // 13: hello.v1.Greeter.GreetManyTimes:output_type -> hello.v1.HelloResponse
+	5,  // 14: hello.v1.Greeter.GreetEveryone:output_type -> hello.v1.GreetEveryoneResponse
+	10, // 15: hello.v1.FaultAdmin.SetFaults:output_type -> hello.v1.FaultConfig
+	10, // 16: hello.v1.FaultAdmin.GetFaults:output_type -> hello.v1.FaultConfig
+	12, // [12:17] is the sub-list for method output_type
+	7,  // [7:12] is the sub-list for method input_type
+	7,  // [7:7] is the sub-list for extension type_name
+	6,  // [6:7] is the sub-list for extension extendee
+	0,  // [0:6] is the sub-list for field type_name
 }
 
 func init() { file_api_hello_proto_init() }
@@ -156,19 +796,25 @@ func file_api_hello_proto_init() {
 	if File_api_hello_proto != nil {
 		return
 	}
+	file_api_hello_proto_msgTypes[2].OneofWrappers = []any{
+		(*GreetEveryoneRequest_Hello)(nil),
+		(*GreetEveryoneRequest_Ack)(nil),
+	}
 	type x struct{}
 	out := protoimpl.TypeBuilder{
 		File: protoimpl.DescBuilder{
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_api_hello_proto_rawDesc), len(file_api_hello_proto_rawDesc)),
-			NumEnums:      0,
-			NumMessages:   2,
-			NumExtensions: 0,
-			NumServices:   1,
+			NumEnums:      1,
+			NumMessages:   11,
+			NumExtensions: 1,
+			NumServices:   2,
 		},
 		GoTypes:           file_api_hello_proto_goTypes,
 		DependencyIndexes: file_api_hello_proto_depIdxs,
+		EnumInfos:         file_api_hello_proto_enumTypes,
 		MessageInfos:      file_api_hello_proto_msgTypes,
+		ExtensionInfos:    file_api_hello_proto_extTypes,
 	}.Build()
 	File_api_hello_proto = out.File
 	file_api_hello_proto_goTypes = nil
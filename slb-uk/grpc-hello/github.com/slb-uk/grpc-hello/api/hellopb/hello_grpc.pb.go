@@ -21,6 +21,7 @@ const _ = grpc.SupportPackageIsVersion9
 const (
 	Greeter_SayHello_FullMethodName       = "/hello.v1.Greeter/SayHello"
 	Greeter_GreetManyTimes_FullMethodName = "/hello.v1.Greeter/GreetManyTimes"
+	Greeter_GreetEveryone_FullMethodName  = "/hello.v1.Greeter/GreetEveryone"
 )
 
 // GreeterClient is the client API for Greeter service.
@@ -29,6 +30,7 @@ const (
 type GreeterClient interface {
 	SayHello(ctx context.Context, in *HelloRequest, opts ...grpc.CallOption) (*HelloResponse, error)
 	GreetManyTimes(ctx context.Context, in *HelloRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[HelloResponse], error)
+	GreetEveryone(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[GreetEveryoneRequest, GreetEveryoneResponse], error)
 }
 
 type greeterClient struct {
@@ -68,12 +70,26 @@ func (c *greeterClient) GreetManyTimes(ctx context.Context, in *HelloRequest, op
 // This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
 type Greeter_GreetManyTimesClient = grpc.ServerStreamingClient[HelloResponse]
 
+func (c *greeterClient) GreetEveryone(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[GreetEveryoneRequest, GreetEveryoneResponse], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Greeter_ServiceDesc.Streams[1], Greeter_GreetEveryone_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[GreetEveryoneRequest, GreetEveryoneResponse]{ClientStream: stream}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Greeter_GreetEveryoneClient = grpc.BidiStreamingClient[GreetEveryoneRequest, GreetEveryoneResponse]
+
 // GreeterServer is the server API for Greeter service.
 // All implementations must embed UnimplementedGreeterServer
 // for forward compatibility.
 type GreeterServer interface {
 	SayHello(context.Context, *HelloRequest) (*HelloResponse, error)
 	GreetManyTimes(*HelloRequest, grpc.ServerStreamingServer[HelloResponse]) error
+	GreetEveryone(grpc.BidiStreamingServer[GreetEveryoneRequest, GreetEveryoneResponse]) error
 	mustEmbedUnimplementedGreeterServer()
 }
 
@@ -90,6 +106,9 @@ func (UnimplementedGreeterServer) SayHello(context.Context, *HelloRequest) (*Hel
 func (UnimplementedGreeterServer) GreetManyTimes(*HelloRequest, grpc.ServerStreamingServer[HelloResponse]) error {
 	return status.Errorf(codes.Unimplemented, "method GreetManyTimes not implemented")
 }
+func (UnimplementedGreeterServer) GreetEveryone(grpc.BidiStreamingServer[GreetEveryoneRequest, GreetEveryoneResponse]) error {
+	return status.Errorf(codes.Unimplemented, "method GreetEveryone not implemented")
+}
 func (UnimplementedGreeterServer) mustEmbedUnimplementedGreeterServer() {}
 func (UnimplementedGreeterServer) testEmbeddedByValue()                 {}
 
@@ -140,6 +159,13 @@ func _Greeter_GreetManyTimes_Handler(srv interface{}, stream grpc.ServerStream)
 // This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
 type Greeter_GreetManyTimesServer = grpc.ServerStreamingServer[HelloResponse]
 
+func _Greeter_GreetEveryone_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(GreeterServer).GreetEveryone(&grpc.GenericServerStream[GreetEveryoneRequest, GreetEveryoneResponse]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Greeter_GreetEveryoneServer = grpc.BidiStreamingServer[GreetEveryoneRequest, GreetEveryoneResponse]
+
 // Greeter_ServiceDesc is the grpc.ServiceDesc for Greeter service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -158,6 +184,152 @@ var Greeter_ServiceDesc = grpc.ServiceDesc{
 			Handler:       _Greeter_GreetManyTimes_Handler,
 			ServerStreams: true,
 		},
+		{
+			StreamName:    "GreetEveryone",
+			Handler:       _Greeter_GreetEveryone_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "api/hello.proto",
+}
+
+const (
+	FaultAdmin_SetFaults_FullMethodName = "/hello.v1.FaultAdmin/SetFaults"
+	FaultAdmin_GetFaults_FullMethodName = "/hello.v1.FaultAdmin/GetFaults"
+)
+
+// FaultAdminClient is the client API for FaultAdmin service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type FaultAdminClient interface {
+	SetFaults(ctx context.Context, in *SetFaultsRequest, opts ...grpc.CallOption) (*FaultConfig, error)
+	GetFaults(ctx context.Context, in *GetFaultsRequest, opts ...grpc.CallOption) (*FaultConfig, error)
+}
+
+type faultAdminClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewFaultAdminClient(cc grpc.ClientConnInterface) FaultAdminClient {
+	return &faultAdminClient{cc}
+}
+
+func (c *faultAdminClient) SetFaults(ctx context.Context, in *SetFaultsRequest, opts ...grpc.CallOption) (*FaultConfig, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(FaultConfig)
+	err := c.cc.Invoke(ctx, FaultAdmin_SetFaults_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *faultAdminClient) GetFaults(ctx context.Context, in *GetFaultsRequest, opts ...grpc.CallOption) (*FaultConfig, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(FaultConfig)
+	err := c.cc.Invoke(ctx, FaultAdmin_GetFaults_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// FaultAdminServer is the server API for FaultAdmin service.
+// All implementations must embed UnimplementedFaultAdminServer
+// for forward compatibility.
+type FaultAdminServer interface {
+	SetFaults(context.Context, *SetFaultsRequest) (*FaultConfig, error)
+	GetFaults(context.Context, *GetFaultsRequest) (*FaultConfig, error)
+	mustEmbedUnimplementedFaultAdminServer()
+}
+
+// UnimplementedFaultAdminServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedFaultAdminServer struct{}
+
+func (UnimplementedFaultAdminServer) SetFaults(context.Context, *SetFaultsRequest) (*FaultConfig, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SetFaults not implemented")
+}
+func (UnimplementedFaultAdminServer) GetFaults(context.Context, *GetFaultsRequest) (*FaultConfig, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetFaults not implemented")
+}
+func (UnimplementedFaultAdminServer) mustEmbedUnimplementedFaultAdminServer() {}
+func (UnimplementedFaultAdminServer) testEmbeddedByValue()                    {}
+
+// UnsafeFaultAdminServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to FaultAdminServer will
+// result in compilation errors.
+type UnsafeFaultAdminServer interface {
+	mustEmbedUnimplementedFaultAdminServer()
+}
+
+func RegisterFaultAdminServer(s grpc.ServiceRegistrar, srv FaultAdminServer) {
+	// If the following call pancis, it indicates UnimplementedFaultAdminServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&FaultAdmin_ServiceDesc, srv)
+}
+
+func _FaultAdmin_SetFaults_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SetFaultsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FaultAdminServer).SetFaults(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: FaultAdmin_SetFaults_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FaultAdminServer).SetFaults(ctx, req.(*SetFaultsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _FaultAdmin_GetFaults_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetFaultsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FaultAdminServer).GetFaults(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: FaultAdmin_GetFaults_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FaultAdminServer).GetFaults(ctx, req.(*GetFaultsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// FaultAdmin_ServiceDesc is the grpc.ServiceDesc for FaultAdmin service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var FaultAdmin_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "hello.v1.FaultAdmin",
+	HandlerType: (*FaultAdminServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "SetFaults",
+			Handler:    _FaultAdmin_SetFaults_Handler,
+		},
+		{
+			MethodName: "GetFaults",
+			Handler:    _FaultAdmin_GetFaults_Handler,
+		},
 	},
+	Streams:  []grpc.StreamDesc{},
 	Metadata: "api/hello.proto",
 }
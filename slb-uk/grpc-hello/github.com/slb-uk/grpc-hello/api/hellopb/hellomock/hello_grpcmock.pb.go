@@ -0,0 +1,88 @@
+// Code generated by protoc-gen-go-grpcmock. DO NOT EDIT.
+// source: api/hello.proto
+
+// Package hellomock has programmable mocks of the services in api/hello.proto,
+// for testing clients without a live server. See package grpcmock.
+package hellomock
+
+import (
+	context "context"
+	hellopb "github.com/slb-uk/grpc-hello/api/hellopb"
+	grpcmock "github.com/slb-uk/grpc-hello/grpcmock"
+	grpc "google.golang.org/grpc"
+)
+
+// Greeter is a programmable hellopb.GreeterServer. Each On<Method> stub answers
+// codes.Unimplemented until it is programmed.
+type Greeter struct {
+	hellopb.UnimplementedGreeterServer
+	OnSayHello       *grpcmock.Unary[hellopb.HelloRequest, hellopb.HelloResponse]
+	OnGreetManyTimes *grpcmock.ServerStream[hellopb.HelloRequest, hellopb.HelloResponse]
+	OnGreetEveryone  *grpcmock.BidiStream[hellopb.GreetEveryoneRequest, hellopb.GreetEveryoneResponse]
+}
+
+func NewGreeter() *Greeter {
+	return &Greeter{
+		OnSayHello:       grpcmock.NewUnary[hellopb.HelloRequest, hellopb.HelloResponse]("/hello.v1.Greeter/SayHello"),
+		OnGreetManyTimes: grpcmock.NewServerStream[hellopb.HelloRequest, hellopb.HelloResponse]("/hello.v1.Greeter/GreetManyTimes"),
+		OnGreetEveryone:  grpcmock.NewBidiStream[hellopb.GreetEveryoneRequest, hellopb.GreetEveryoneResponse]("/hello.v1.Greeter/GreetEveryone"),
+	}
+}
+
+// Register registers the mock on s.
+func (m *Greeter) Register(s grpc.ServiceRegistrar) {
+	hellopb.RegisterGreeterServer(s, m)
+}
+
+// Reset forgets what every stub was programmed with and received.
+func (m *Greeter) Reset() {
+	m.OnSayHello.Reset()
+	m.OnGreetManyTimes.Reset()
+	m.OnGreetEveryone.Reset()
+}
+
+func (m *Greeter) SayHello(ctx context.Context, req *hellopb.HelloRequest) (*hellopb.HelloResponse, error) {
+	return m.OnSayHello.Call(ctx, req)
+}
+
+func (m *Greeter) GreetManyTimes(req *hellopb.HelloRequest, stream grpc.ServerStreamingServer[hellopb.HelloResponse]) error {
+	return m.OnGreetManyTimes.Call(req, stream)
+}
+
+func (m *Greeter) GreetEveryone(stream grpc.BidiStreamingServer[hellopb.GreetEveryoneRequest, hellopb.GreetEveryoneResponse]) error {
+	return m.OnGreetEveryone.Call(stream)
+}
+
+// FaultAdmin is a programmable hellopb.FaultAdminServer. Each On<Method> stub answers
+// codes.Unimplemented until it is programmed.
+type FaultAdmin struct {
+	hellopb.UnimplementedFaultAdminServer
+	OnSetFaults *grpcmock.Unary[hellopb.SetFaultsRequest, hellopb.FaultConfig]
+	OnGetFaults *grpcmock.Unary[hellopb.GetFaultsRequest, hellopb.FaultConfig]
+}
+
+func NewFaultAdmin() *FaultAdmin {
+	return &FaultAdmin{
+		OnSetFaults: grpcmock.NewUnary[hellopb.SetFaultsRequest, hellopb.FaultConfig]("/hello.v1.FaultAdmin/SetFaults"),
+		OnGetFaults: grpcmock.NewUnary[hellopb.GetFaultsRequest, hellopb.FaultConfig]("/hello.v1.FaultAdmin/GetFaults"),
+	}
+}
+
+// Register registers the mock on s.
+func (m *FaultAdmin) Register(s grpc.ServiceRegistrar) {
+	hellopb.RegisterFaultAdminServer(s, m)
+}
+
+// Reset forgets what every stub was programmed with and received.
+func (m *FaultAdmin) Reset() {
+	m.OnSetFaults.Reset()
+	m.OnGetFaults.Reset()
+}
+
+func (m *FaultAdmin) SetFaults(ctx context.Context, req *hellopb.SetFaultsRequest) (*hellopb.FaultConfig, error) {
+	return m.OnSetFaults.Call(ctx, req)
+}
+
+func (m *FaultAdmin) GetFaults(ctx context.Context, req *hellopb.GetFaultsRequest) (*hellopb.FaultConfig, error) {
+	return m.OnGetFaults.Call(ctx, req)
+}
@@ -3,6 +3,7 @@ module github.com/slb-uk/grpc-hello
 go 1.21
 
 require (
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20240318140521-94a12d6c2237
 	google.golang.org/grpc v1.64.0
 	google.golang.org/protobuf v1.34.1
 )
\ No newline at end of file
@@ -0,0 +1,270 @@
+// Package greetertest lets client code be tested against the Greeter API
+// without a live server. NewGreeter is a hellomock.Greeter that already
+// behaves like cmd/server (auth and faults off); reprogram any of its stubs
+// for the case at hand and serve it in-process with Start:
+//
+//	g := greetertest.NewGreeter()
+//	g.OnSayHello.FailNext(status.Error(codes.Unavailable, "down"))
+//	conn := greetertest.Start(t, g)
+//	client := hellopb.NewGreeterClient(conn) // or the client code under test
+//
+// Contract holds the behaviour clients may rely on. It runs against
+// NewGreeter here and against the real server in cmd/server, so a client
+// tested against the mock is tested against what the server does.
+package greetertest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+
+	"github.com/slb-uk/grpc-hello/api/hellopb"
+	"github.com/slb-uk/grpc-hello/api/hellopb/hellomock"
+	"github.com/slb-uk/grpc-hello/transport"
+)
+
+// MaxNameLen is the longest name the Greeter accepts, in characters.
+const MaxNameLen = 64
+
+// Error returns the status error the Greeter fails with: code, with d in
+// the details and d.Message as the status message. Use it to program
+// failures a client is expected to branch on:
+//
+//	g.OnSayHello.Fail(greetertest.Error(codes.PermissionDenied,
+//		&hellopb.HelloError{Reason: hellopb.HelloError_MISSING_ROLE, Locale: "en", Message: "..."}))
+func Error(code codes.Code, d *hellopb.HelloError) error {
+	st, err := status.New(code, d.GetMessage()).WithDetails(d)
+	if err != nil {
+		return status.Error(code, d.GetMessage())
+	}
+	return st.Err()
+}
+
+// validateName mirrors cmd/server, in English only.
+func validateName(name string) error {
+	n := utf8.RuneCountInString(strings.TrimSpace(name))
+	switch {
+	case n == 0:
+		return Error(codes.InvalidArgument, &hellopb.HelloError{Reason: hellopb.HelloError_NAME_REQUIRED,
+			Locale: "en", Message: "Please tell us your name.", Field: "name"})
+	case n > MaxNameLen:
+		params := map[string]string{"max": strconv.Itoa(MaxNameLen), "len": strconv.Itoa(n)}
+		return Error(codes.InvalidArgument, &hellopb.HelloError{Reason: hellopb.HelloError_NAME_TOO_LONG, Locale: "en",
+			Message: fmt.Sprintf("Names can be at most %s characters (got %s).", params["max"], params["len"]), Field: "name", Params: params})
+	}
+	return nil
+}
+
+// NewGreeter returns a mock programmed like the real server: names are
+// validated, SayHello greets, GreetManyTimes sends five greetings without
+// the pauses and GreetEveryone greets each name with a window of 32 that
+// never changes.
+func NewGreeter() *hellomock.Greeter {
+	g := hellomock.NewGreeter()
+	g.OnSayHello.Handle(func(_ context.Context, req *hellopb.HelloRequest) (*hellopb.HelloResponse, error) {
+		if err := validateName(req.GetName()); err != nil {
+			return nil, err
+		}
+		return &hellopb.HelloResponse{Message: fmt.Sprintf("Hello, %s! 👋", req.GetName())}, nil
+	})
+	g.OnGreetManyTimes.Handle(func(req *hellopb.HelloRequest, stream grpc.ServerStreamingServer[hellopb.HelloResponse]) error {
+		if err := validateName(req.GetName()); err != nil {
+			return err
+		}
+		for i := 1; i <= 5; i++ {
+			if err := stream.Send(&hellopb.HelloResponse{Message: fmt.Sprintf("[%d/5] Hello, %s!", i, req.GetName())}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	g.OnGreetEveryone.Handle(func(stream grpc.BidiStreamingServer[hellopb.GreetEveryoneRequest, hellopb.GreetEveryoneResponse]) error {
+		var seq uint64
+		for {
+			req, err := stream.Recv()
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if req.GetHello() == nil {
+				continue // acks
+			}
+			seq++
+			if err := stream.Send(&hellopb.GreetEveryoneResponse{Seq: seq, Message: fmt.Sprintf("Hello, %s!", req.GetHello().GetName()),
+				Window: 32}); err != nil {
+				return err
+			}
+		}
+	})
+	return g
+}
+
+var servers atomic.Int64
+
+// Start serves srv on an in-process listener until the test ends and
+// returns a connection to it. opts are added to the server.
+func Start(t testing.TB, srv hellopb.GreeterServer, opts ...grpc.ServerOption) *grpc.ClientConn {
+	t.Helper()
+	return StartWith(t, func(s *grpc.Server) { hellopb.RegisterGreeterServer(s, srv) }, opts...)
+}
+
+// StartWith is Start for a server with more than the Greeter: register
+// adds the services.
+func StartWith(t testing.TB, register func(*grpc.Server), opts ...grpc.ServerOption) *grpc.ClientConn {
+	t.Helper()
+	addr := fmt.Sprintf("inproc:greetertest-%d", servers.Add(1))
+	lis, err := transport.Listen(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := grpc.NewServer(opts...)
+	register(s)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		_ = s.Serve(lis)
+	}()
+	conn, err := transport.Dial(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		s.Stop()
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		conn.Close()
+		s.Stop()
+		wg.Wait()
+	})
+	return conn
+}
+
+// Contract runs the Greeter's contract against c as subtests. The name
+// greeted is "Ada"; a server with auth on must let c call every method.
+func Contract(t *testing.T, c hellopb.GreeterClient) {
+	ctx := func(t *testing.T) context.Context {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		t.Cleanup(cancel)
+		return ctx
+	}
+
+	t.Run("SayHello greets by name", func(t *testing.T) {
+		resp, err := c.SayHello(ctx(t), &hellopb.HelloRequest{Name: "Ada"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(resp.GetMessage(), "Ada") {
+			t.Errorf("message %q lacks the name", resp.GetMessage())
+		}
+	})
+
+	t.Run("SayHello rejects a blank name", func(t *testing.T) {
+		_, err := c.SayHello(ctx(t), &hellopb.HelloRequest{Name: "  "})
+		wantError(t, err, codes.InvalidArgument, hellopb.HelloError_NAME_REQUIRED)
+	})
+
+	t.Run("SayHello rejects a long name", func(t *testing.T) {
+		_, err := c.SayHello(ctx(t), &hellopb.HelloRequest{Name: strings.Repeat("é", MaxNameLen+1)})
+		d := wantError(t, err, codes.InvalidArgument, hellopb.HelloError_NAME_TOO_LONG)
+		if d.GetParams()["max"] != strconv.Itoa(MaxNameLen) || d.GetParams()["len"] != strconv.Itoa(MaxNameLen+1) {
+			t.Errorf("params %v", d.GetParams())
+		}
+		if _, err := c.SayHello(ctx(t), &hellopb.HelloRequest{Name: strings.Repeat("é", MaxNameLen)}); err != nil {
+			t.Errorf("%d characters: %v", MaxNameLen, err)
+		}
+	})
+
+	t.Run("GreetManyTimes sends five greetings", func(t *testing.T) {
+		stream, err := c.GreetManyTimes(ctx(t), &hellopb.HelloRequest{Name: "Ada"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 1; ; i++ {
+			resp, err := stream.Recv()
+			if errors.Is(err, io.EOF) {
+				if i != 6 {
+					t.Errorf("%d greetings, want 5", i-1)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(resp.GetMessage(), "Ada") || !strings.Contains(resp.GetMessage(), fmt.Sprintf("%d/5", i)) {
+				t.Errorf("greeting %d = %q", i, resp.GetMessage())
+			}
+		}
+	})
+
+	t.Run("GreetManyTimes rejects a blank name", func(t *testing.T) {
+		stream, err := c.GreetManyTimes(ctx(t), &hellopb.HelloRequest{})
+		if err == nil {
+			_, err = stream.Recv()
+		}
+		wantError(t, err, codes.InvalidArgument, hellopb.HelloError_NAME_REQUIRED)
+	})
+
+	t.Run("GreetEveryone greets each name in order", func(t *testing.T) {
+		stream, err := c.GreetEveryone(ctx(t))
+		if err != nil {
+			t.Fatal(err)
+		}
+		names := []string{"Ada", "Grace", "Barbara"}
+		for _, n := range names {
+			if err := stream.Send(&hellopb.GreetEveryoneRequest{Kind: &hellopb.GreetEveryoneRequest_Hello{Hello: &hellopb.HelloRequest{Name: n}}}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for i, n := range names {
+			resp, err := stream.Recv()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.GetSeq() != uint64(i+1) || !strings.Contains(resp.GetMessage(), n) || resp.GetWindow() == 0 {
+				t.Errorf("greeting %d = %v", i+1, resp)
+			}
+			if err := stream.Send(&hellopb.GreetEveryoneRequest{Kind: &hellopb.GreetEveryoneRequest_Ack{Ack: &hellopb.Ack{Seq: resp.GetSeq()}}}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := stream.CloseSend(); err != nil {
+			t.Fatal(err)
+		}
+		if resp, err := stream.Recv(); !errors.Is(err, io.EOF) {
+			t.Errorf("after close: %v, %v; want EOF", resp, err)
+		}
+	})
+}
+
+// wantError checks err is code with a HelloError of reason and returns it.
+func wantError(t *testing.T, err error, code codes.Code, reason hellopb.HelloError_Reason) *hellopb.HelloError {
+	t.Helper()
+	st := status.Convert(err)
+	if st.Code() != code {
+		t.Fatalf("err = %v, want %v", err, code)
+	}
+	for _, d := range st.Details() {
+		if he, ok := d.(*hellopb.HelloError); ok {
+			if he.GetReason() != reason || he.GetField() != "name" || he.GetMessage() == "" {
+				t.Errorf("detail %v, want reason %v on name", he, reason)
+			}
+			return he
+		}
+	}
+	t.Fatalf("%v: no HelloError in details", err)
+	return nil
+}
@@ -0,0 +1,64 @@
+package greetertest
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/slb-uk/grpc-hello/api/hellopb"
+)
+
+func TestMockHonoursContract(t *testing.T) {
+	Contract(t, hellopb.NewGreeterClient(Start(t, NewGreeter())))
+}
+
+func TestProgrammedMock(t *testing.T) {
+	g := NewGreeter()
+	c := hellopb.NewGreeterClient(Start(t, g))
+	ctx := context.Background()
+
+	g.OnSayHello.FailNext(status.Error(codes.Unavailable, "down"), status.Error(codes.Unavailable, "down"))
+	for i, want := range []codes.Code{codes.Unavailable, codes.Unavailable, codes.OK} {
+		if _, err := c.SayHello(ctx, &hellopb.HelloRequest{Name: "Ada"}); status.Code(err) != want {
+			t.Errorf("call %d: %v, want %v", i+1, err, want)
+		}
+	}
+	if calls := g.OnSayHello.Calls(); len(calls) != 3 || calls[2].GetName() != "Ada" {
+		t.Errorf("calls %v", calls)
+	}
+
+	g.OnSayHello.Return(&hellopb.HelloResponse{Message: "canned"})
+	if resp, err := c.SayHello(ctx, &hellopb.HelloRequest{}); err != nil || resp.GetMessage() != "canned" {
+		t.Errorf("Return: %v, %v", resp, err)
+	}
+
+	g.OnSayHello.Delay(time.Second)
+	short, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	if _, err := c.SayHello(short, &hellopb.HelloRequest{Name: "Ada"}); status.Code(err) != codes.DeadlineExceeded {
+		t.Errorf("Delay: %v", err)
+	}
+
+	g.OnGreetManyTimes.FailAfter(status.Error(codes.Internal, "boom"), &hellopb.HelloResponse{Message: "one"})
+	stream, err := c.GreetManyTimes(ctx, &hellopb.HelloRequest{Name: "Ada"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp, err := stream.Recv(); err != nil || resp.GetMessage() != "one" {
+		t.Errorf("first: %v, %v", resp, err)
+	}
+	if _, err := stream.Recv(); status.Code(err) != codes.Internal {
+		t.Errorf("then: %v, want Internal", err)
+	}
+
+	g.Reset()
+	if _, err := c.SayHello(ctx, &hellopb.HelloRequest{Name: "Ada"}); status.Code(err) != codes.Unimplemented {
+		t.Errorf("after Reset: %v, want Unimplemented", err)
+	}
+	if len(g.OnSayHello.Calls()) != 1 {
+		t.Errorf("Reset kept the calls")
+	}
+}
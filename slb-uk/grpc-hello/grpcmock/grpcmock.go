@@ -0,0 +1,363 @@
+// Package grpcmock is the runtime of the mock servers protoc-gen-go-grpcmock
+// generates (see api/hellopb/hellomock). A generated mock has one stub per
+// RPC; a stub is programmed with a canned response, a handler or injected
+// failures, and records the requests it received.
+//
+//	g := hellomock.NewGreeter()
+//	g.OnSayHello.Return(&hellopb.HelloResponse{Message: "hi"})
+//	g.OnSayHello.FailNext(status.Error(codes.Unavailable, "down")) // the retry gets "hi"
+//	g.OnSayHello.Delay(50 * time.Millisecond)
+//
+// Stubs are safe for concurrent use and can be reprogrammed between calls.
+// A stub that has not been programmed fails with codes.Unimplemented.
+package grpcmock
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// stub is what every kind of RPC stub has in common.
+type stub[Req any] struct {
+	method string
+
+	mu    sync.Mutex
+	next  []error // injected failures, one per call
+	delay time.Duration
+	calls []*Req
+}
+
+// FailNext makes the next len(errs) calls fail, in order, before the
+// stub's programmed behaviour is reached. Use status errors so the client
+// sees the intended code.
+func (s *stub[Req]) FailNext(errs ...error) {
+	s.mu.Lock()
+	s.next = append(s.next, errs...)
+	s.mu.Unlock()
+}
+
+// Delay makes every call wait d before it is answered, or until the
+// caller gives up.
+func (s *stub[Req]) Delay(d time.Duration) {
+	s.mu.Lock()
+	s.delay = d
+	s.mu.Unlock()
+}
+
+// Calls returns the requests received so far, oldest first: one per call
+// for unary and server-streaming RPCs, every message for client and
+// bidirectional streams.
+func (s *stub[Req]) Calls() []*Req {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*Req(nil), s.calls...)
+}
+
+// Method is the full method name, e.g. /hello.v1.Greeter/SayHello.
+func (s *stub[Req]) Method() string { return s.method }
+
+func (s *stub[Req]) record(req *Req) {
+	s.mu.Lock()
+	s.calls = append(s.calls, req)
+	s.mu.Unlock()
+}
+
+// begin applies the delay and returns the next injected failure, if any.
+func (s *stub[Req]) begin(ctx context.Context) error {
+	s.mu.Lock()
+	d := s.delay
+	var err error
+	if len(s.next) > 0 {
+		err, s.next = s.next[0], s.next[1:]
+	}
+	s.mu.Unlock()
+	if d > 0 {
+		t := time.NewTimer(d)
+		defer t.Stop()
+		select {
+		case <-ctx.Done():
+			return status.FromContextError(ctx.Err()).Err()
+		case <-t.C:
+		}
+	}
+	return err
+}
+
+func (s *stub[Req]) reset() {
+	s.mu.Lock()
+	s.next, s.delay, s.calls = nil, 0, nil
+	s.mu.Unlock()
+}
+
+func (s *stub[Req]) unprogrammed() error {
+	return status.Errorf(codes.Unimplemented, "grpcmock: %s is not programmed", s.method)
+}
+
+// Unary stubs a unary RPC.
+type Unary[Req, Resp any] struct {
+	stub[Req]
+	handler func(context.Context, *Req) (*Resp, error)
+}
+
+func NewUnary[Req, Resp any](method string) *Unary[Req, Resp] {
+	return &Unary[Req, Resp]{stub: stub[Req]{method: method}}
+}
+
+// Return answers every call with resp.
+func (u *Unary[Req, Resp]) Return(resp *Resp) {
+	u.Handle(func(context.Context, *Req) (*Resp, error) { return resp, nil })
+}
+
+// Fail answers every call with err.
+func (u *Unary[Req, Resp]) Fail(err error) {
+	u.Handle(func(context.Context, *Req) (*Resp, error) { return nil, err })
+}
+
+// Handle answers every call with h.
+func (u *Unary[Req, Resp]) Handle(h func(context.Context, *Req) (*Resp, error)) {
+	u.mu.Lock()
+	u.handler = h
+	u.mu.Unlock()
+}
+
+// Reset forgets the programming and the recorded calls.
+func (u *Unary[Req, Resp]) Reset() {
+	u.Handle(nil)
+	u.reset()
+}
+
+// Call serves one call; the generated mock's method calls it.
+func (u *Unary[Req, Resp]) Call(ctx context.Context, req *Req) (*Resp, error) {
+	u.record(req)
+	if err := u.begin(ctx); err != nil {
+		return nil, err
+	}
+	u.mu.Lock()
+	h := u.handler
+	u.mu.Unlock()
+	if h == nil {
+		return nil, u.unprogrammed()
+	}
+	return h(ctx, req)
+}
+
+// ServerStream stubs a server-streaming RPC.
+type ServerStream[Req, Resp any] struct {
+	stub[Req]
+	handler func(*Req, grpc.ServerStreamingServer[Resp]) error
+}
+
+func NewServerStream[Req, Resp any](method string) *ServerStream[Req, Resp] {
+	return &ServerStream[Req, Resp]{stub: stub[Req]{method: method}}
+}
+
+// Return sends resps on every call and ends the stream.
+func (s *ServerStream[Req, Resp]) Return(resps ...*Resp) { s.FailAfter(nil, resps...) }
+
+// Fail ends every call with err before anything is sent.
+func (s *ServerStream[Req, Resp]) Fail(err error) { s.FailAfter(err) }
+
+// FailAfter sends resps on every call and then fails the stream with err,
+// as a server dying halfway through would.
+func (s *ServerStream[Req, Resp]) FailAfter(err error, resps ...*Resp) {
+	s.Handle(func(_ *Req, stream grpc.ServerStreamingServer[Resp]) error {
+		for _, r := range resps {
+			if err := stream.Send(r); err != nil {
+				return err
+			}
+		}
+		return err
+	})
+}
+
+// Handle serves every call with h.
+func (s *ServerStream[Req, Resp]) Handle(h func(*Req, grpc.ServerStreamingServer[Resp]) error) {
+	s.mu.Lock()
+	s.handler = h
+	s.mu.Unlock()
+}
+
+// Reset forgets the programming and the recorded calls.
+func (s *ServerStream[Req, Resp]) Reset() {
+	s.Handle(nil)
+	s.reset()
+}
+
+// Call serves one call; the generated mock's method calls it.
+func (s *ServerStream[Req, Resp]) Call(req *Req, stream grpc.ServerStreamingServer[Resp]) error {
+	s.record(req)
+	if err := s.begin(stream.Context()); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	h := s.handler
+	s.mu.Unlock()
+	if h == nil {
+		return s.unprogrammed()
+	}
+	return h(req, stream)
+}
+
+// ClientStream stubs a client-streaming RPC.
+type ClientStream[Req, Resp any] struct {
+	stub[Req]
+	handler func(grpc.ClientStreamingServer[Req, Resp]) error
+}
+
+func NewClientStream[Req, Resp any](method string) *ClientStream[Req, Resp] {
+	return &ClientStream[Req, Resp]{stub: stub[Req]{method: method}}
+}
+
+// Return reads every message of a call and then answers with resp.
+func (s *ClientStream[Req, Resp]) Return(resp *Resp) {
+	s.Handle(func(stream grpc.ClientStreamingServer[Req, Resp]) error {
+		if err := drain(stream.Recv); err != nil {
+			return err
+		}
+		return stream.SendAndClose(resp)
+	})
+}
+
+// Handle serves every call with h. Messages h receives are recorded.
+func (s *ClientStream[Req, Resp]) Handle(h func(grpc.ClientStreamingServer[Req, Resp]) error) {
+	s.mu.Lock()
+	s.handler = h
+	s.mu.Unlock()
+}
+
+// Reset forgets the programming and the recorded calls.
+func (s *ClientStream[Req, Resp]) Reset() {
+	s.Handle(nil)
+	s.reset()
+}
+
+type recordingClientStream[Req, Resp any] struct {
+	grpc.ClientStreamingServer[Req, Resp]
+	s *stub[Req]
+}
+
+func (r recordingClientStream[Req, Resp]) Recv() (*Req, error) {
+	m, err := r.ClientStreamingServer.Recv()
+	if err == nil {
+		r.s.record(m)
+	}
+	return m, err
+}
+
+// Call serves one call; the generated mock's method calls it.
+func (s *ClientStream[Req, Resp]) Call(stream grpc.ClientStreamingServer[Req, Resp]) error {
+	if err := s.begin(stream.Context()); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	h := s.handler
+	s.mu.Unlock()
+	if h == nil {
+		return s.unprogrammed()
+	}
+	return h(recordingClientStream[Req, Resp]{stream, &s.stub})
+}
+
+// BidiStream stubs a bidirectional-streaming RPC.
+type BidiStream[Req, Resp any] struct {
+	stub[Req]
+	handler func(grpc.BidiStreamingServer[Req, Resp]) error
+}
+
+func NewBidiStream[Req, Resp any](method string) *BidiStream[Req, Resp] {
+	return &BidiStream[Req, Resp]{stub: stub[Req]{method: method}}
+}
+
+// Reply answers each message of a call with what f returns for it, which
+// may be nothing. An error from f ends the stream with it; the stream
+// ends cleanly when the client closes its side.
+func (s *BidiStream[Req, Resp]) Reply(f func(*Req) ([]*Resp, error)) {
+	s.Handle(func(stream grpc.BidiStreamingServer[Req, Resp]) error {
+		for {
+			req, err := stream.Recv()
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			resps, err := f(req)
+			if err != nil {
+				return err
+			}
+			for _, r := range resps {
+				if err := stream.Send(r); err != nil {
+					return err
+				}
+			}
+		}
+	})
+}
+
+// Fail ends every call with err once the client has sent its first
+// message.
+func (s *BidiStream[Req, Resp]) Fail(err error) {
+	s.Handle(func(stream grpc.BidiStreamingServer[Req, Resp]) error {
+		if _, rerr := stream.Recv(); rerr != nil && !errors.Is(rerr, io.EOF) {
+			return rerr
+		}
+		return err
+	})
+}
+
+// Handle serves every call with h. Messages h receives are recorded.
+func (s *BidiStream[Req, Resp]) Handle(h func(grpc.BidiStreamingServer[Req, Resp]) error) {
+	s.mu.Lock()
+	s.handler = h
+	s.mu.Unlock()
+}
+
+// Reset forgets the programming and the recorded calls.
+func (s *BidiStream[Req, Resp]) Reset() {
+	s.Handle(nil)
+	s.reset()
+}
+
+type recordingBidiStream[Req, Resp any] struct {
+	grpc.BidiStreamingServer[Req, Resp]
+	s *stub[Req]
+}
+
+func (r recordingBidiStream[Req, Resp]) Recv() (*Req, error) {
+	m, err := r.BidiStreamingServer.Recv()
+	if err == nil {
+		r.s.record(m)
+	}
+	return m, err
+}
+
+// Call serves one call; the generated mock's method calls it.
+func (s *BidiStream[Req, Resp]) Call(stream grpc.BidiStreamingServer[Req, Resp]) error {
+	if err := s.begin(stream.Context()); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	h := s.handler
+	s.mu.Unlock()
+	if h == nil {
+		return s.unprogrammed()
+	}
+	return h(recordingBidiStream[Req, Resp]{stream, &s.stub})
+}
+
+func drain[Req any](recv func() (*Req, error)) error {
+	for {
+		if _, err := recv(); errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,246 @@
+// Package oauth gets access tokens with the OAuth2 client-credentials grant
+// (RFC 6749 section 4.4) and attaches them to outgoing gRPC calls, the way a
+// service behind an API gateway authenticates itself:
+//
+//	src := &oauth.ClientCredentials{TokenURL: "https://idp/token", ClientID: "greeter-client", ClientSecret: "..."}
+//	conn, _ := grpc.Dial(addr,
+//		grpc.WithChainUnaryInterceptor(src.UnaryClientInterceptor()),
+//		grpc.WithChainStreamInterceptor(src.StreamClientInterceptor()))
+//
+// The token is cached and shared by all calls. It is refreshed EarlyRefresh
+// before it expires, so calls do not race the expiry; if the token endpoint
+// is down at that point, the cached token is used until it actually expires.
+package oauth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// ClientCredentials is a cached token source. The zero values of
+// EarlyRefresh and Client mean 30s and a client with a 10s timeout.
+type ClientCredentials struct {
+	TokenURL     string
+	ClientID     string
+	ClientSecret string
+	Scopes       []string
+	Audience     string // sent as "audience", which many gateways require
+	EarlyRefresh time.Duration
+	Client       *http.Client
+
+	now func() time.Time // for tests
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+	fetches int
+}
+
+// Error is an error response from the token endpoint.
+type Error struct {
+	Status      int
+	Code        string `json:"error"`
+	Description string `json:"error_description"`
+}
+
+func (e *Error) Error() string {
+	msg := fmt.Sprintf("oauth: token endpoint: %d %s", e.Status, e.Code)
+	if e.Description != "" {
+		msg += ": " + e.Description
+	}
+	return msg
+}
+
+// FromEnv configures a source from OAUTH_TOKEN_URL, OAUTH_CLIENT_ID,
+// OAUTH_CLIENT_SECRET, OAUTH_SCOPES (space or comma separated),
+// OAUTH_AUDIENCE and OAUTH_EARLY_REFRESH. It returns nil when
+// OAUTH_TOKEN_URL is unset.
+func FromEnv() (*ClientCredentials, error) {
+	tokenURL := os.Getenv("OAUTH_TOKEN_URL")
+	if tokenURL == "" {
+		return nil, nil
+	}
+	c := &ClientCredentials{
+		TokenURL:     tokenURL,
+		ClientID:     os.Getenv("OAUTH_CLIENT_ID"),
+		ClientSecret: os.Getenv("OAUTH_CLIENT_SECRET"),
+		Scopes:       strings.FieldsFunc(os.Getenv("OAUTH_SCOPES"), func(r rune) bool { return r == ' ' || r == ',' }),
+		Audience:     os.Getenv("OAUTH_AUDIENCE"),
+	}
+	if c.ClientID == "" {
+		return nil, errors.New("OAUTH_TOKEN_URL needs OAUTH_CLIENT_ID")
+	}
+	if v := os.Getenv("OAUTH_EARLY_REFRESH"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("OAUTH_EARLY_REFRESH: %q is not a duration", v)
+		}
+		c.EarlyRefresh = d
+	}
+	return c, nil
+}
+
+// Token returns a valid access token, fetching a new one when the cached
+// one is missing or due for refresh. Concurrent callers wait for a single
+// fetch.
+func (c *ClientCredentials) Token(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.clock()
+	if c.token != "" && now.Before(c.expires.Add(-c.earlyRefresh())) {
+		return c.token, nil
+	}
+	tok, expires, err := c.fetch(ctx, now)
+	if err != nil {
+		if c.token != "" && now.Before(c.expires) {
+			return c.token, nil // not expired yet; try again on the next call
+		}
+		return "", err
+	}
+	c.token, c.expires = tok, expires
+	return tok, nil
+}
+
+// Invalidate drops the cached token, e.g. after the server rejected it.
+func (c *ClientCredentials) Invalidate() {
+	c.mu.Lock()
+	c.token = ""
+	c.mu.Unlock()
+}
+
+// Fetches is how many times the token endpoint has been called.
+func (c *ClientCredentials) Fetches() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.fetches
+}
+
+func (c *ClientCredentials) fetch(ctx context.Context, now time.Time) (string, time.Time, error) {
+	c.fetches++
+	form := url.Values{"grant_type": {"client_credentials"}}
+	if len(c.Scopes) > 0 {
+		form.Set("scope", strings.Join(c.Scopes, " "))
+	}
+	if c.Audience != "" {
+		form.Set("audience", c.Audience)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(c.ClientID), url.QueryEscape(c.ClientSecret))
+
+	client := c.Client
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("oauth: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("oauth: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		e := &Error{Status: resp.StatusCode}
+		json.Unmarshal(body, e)
+		return "", time.Time{}, e
+	}
+	var tr struct {
+		AccessToken string `json:"access_token"`
+		TokenType   string `json:"token_type"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	if err := json.Unmarshal(body, &tr); err != nil {
+		return "", time.Time{}, fmt.Errorf("oauth: token response: %w", err)
+	}
+	if tr.AccessToken == "" {
+		return "", time.Time{}, errors.New("oauth: token response without access_token")
+	}
+	if tr.TokenType != "" && !strings.EqualFold(tr.TokenType, "bearer") {
+		return "", time.Time{}, fmt.Errorf("oauth: unsupported token_type %q", tr.TokenType)
+	}
+	// expires_in is optional; without it, refresh after an hour
+	ttl := time.Hour
+	if tr.ExpiresIn > 0 {
+		ttl = time.Duration(tr.ExpiresIn) * time.Second
+	}
+	return tr.AccessToken, now.Add(ttl), nil
+}
+
+func (c *ClientCredentials) clock() time.Time {
+	if c.now != nil {
+		return c.now()
+	}
+	return time.Now()
+}
+
+func (c *ClientCredentials) earlyRefresh() time.Duration {
+	if c.EarlyRefresh > 0 {
+		return c.EarlyRefresh
+	}
+	return 30 * time.Second
+}
+
+// withToken replaces any authorization metadata on ctx with the token.
+func (c *ClientCredentials) withToken(ctx context.Context) (context.Context, error) {
+	tok, err := c.Token(ctx)
+	if err != nil {
+		return nil, status.Errorf(codes.Unauthenticated, "getting access token: %v", err)
+	}
+	md, _ := metadata.FromOutgoingContext(ctx)
+	md = md.Copy()
+	md.Set("authorization", "Bearer "+tok)
+	return metadata.NewOutgoingContext(ctx, md), nil
+}
+
+// UnaryClientInterceptor attaches the token to unary calls. A call the
+// server rejects as UNAUTHENTICATED (a token revoked before its expiry) is
+// retried once with a freshly fetched token.
+func (c *ClientCredentials) UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		tctx, err := c.withToken(ctx)
+		if err != nil {
+			return err
+		}
+		err = invoker(tctx, method, req, reply, cc, opts...)
+		if status.Code(err) != codes.Unauthenticated {
+			return err
+		}
+		c.Invalidate()
+		if tctx, err = c.withToken(ctx); err != nil {
+			return err
+		}
+		return invoker(tctx, method, req, reply, cc, opts...)
+	}
+}
+
+// StreamClientInterceptor attaches the token when a stream is opened. A
+// stream keeps the token it started with, even if it outlives it.
+func (c *ClientCredentials) StreamClientInterceptor() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		tctx, err := c.withToken(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return streamer(tctx, desc, cc, method, opts...)
+	}
+}
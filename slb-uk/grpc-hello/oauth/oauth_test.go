@@ -0,0 +1,266 @@
+package oauth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/slb-uk/grpc-hello/transport"
+)
+
+// tokenServer is a fake token endpoint issuing tok-1, tok-2, ...
+type tokenServer struct {
+	*httptest.Server
+	issued    atomic.Int32
+	expiresIn int64
+	down      atomic.Bool
+	lastForm  atomic.Value // url.Values
+}
+
+func newTokenServer(t *testing.T, expiresIn int64) *tokenServer {
+	ts := &tokenServer{expiresIn: expiresIn}
+	ts.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ts.down.Load() {
+			http.Error(w, "", http.StatusServiceUnavailable)
+			return
+		}
+		r.ParseForm()
+		ts.lastForm.Store(r.PostForm)
+		if id, secret, _ := r.BasicAuth(); id != "client" || secret != "s%3Acret" || r.PostForm.Get("grant_type") != "client_credentials" {
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, `{"error":"invalid_client","error_description":"bad secret"}`)
+			return
+		}
+		n := ts.issued.Add(1)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": fmt.Sprintf("tok-%d", n), "token_type": "bearer", "expires_in": ts.expiresIn,
+		})
+	}))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+// clock is a settable time source.
+type clock struct{ t atomic.Int64 }
+
+func (c *clock) now() time.Time          { return time.Unix(0, c.t.Load()) }
+func (c *clock) advance(d time.Duration) { c.t.Add(int64(d)) }
+
+func newSource(ts *tokenServer, c *clock) *ClientCredentials {
+	c.t.Store(time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC).UnixNano())
+	return &ClientCredentials{TokenURL: ts.URL, ClientID: "client", ClientSecret: "s:cret",
+		Scopes: []string{"greeter.read", "greeter.stream"}, Audience: "greeter", EarlyRefresh: time.Minute, now: c.now}
+}
+
+func TestTokenIsCachedAndRefreshedEarly(t *testing.T) {
+	ts := newTokenServer(t, 600)
+	var c clock
+	src := newSource(ts, &c)
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		if tok, err := src.Token(ctx); err != nil || tok != "tok-1" {
+			t.Fatalf("Token = %q, %v", tok, err)
+		}
+	}
+	form := ts.lastForm.Load().(url.Values)
+	if form.Get("scope") != "greeter.read greeter.stream" || form.Get("audience") != "greeter" {
+		t.Fatalf("form = %v", form)
+	}
+
+	c.advance(8*time.Minute + 59*time.Second)
+	if tok, _ := src.Token(ctx); tok != "tok-1" {
+		t.Fatalf("refreshed too early: %q", tok)
+	}
+	c.advance(time.Second) // one minute before expiry
+	if tok, _ := src.Token(ctx); tok != "tok-2" {
+		t.Fatalf("not refreshed EarlyRefresh before expiry: %q", tok)
+	}
+	if src.Fetches() != 2 {
+		t.Fatalf("Fetches = %d", src.Fetches())
+	}
+}
+
+func TestStaleTokenServesWhileEndpointIsDown(t *testing.T) {
+	ts := newTokenServer(t, 600)
+	var c clock
+	src := newSource(ts, &c)
+	ctx := context.Background()
+	src.Token(ctx)
+
+	ts.down.Store(true)
+	c.advance(9*time.Minute + 30*time.Second)
+	if tok, err := src.Token(ctx); err != nil || tok != "tok-1" {
+		t.Fatalf("in refresh window with endpoint down: %q, %v", tok, err)
+	}
+	c.advance(time.Minute) // expired
+	var e *Error
+	if _, err := src.Token(ctx); !errors.As(err, &e) || e.Status != http.StatusServiceUnavailable {
+		t.Fatalf("after expiry: %v", err)
+	}
+	ts.down.Store(false)
+	if tok, err := src.Token(ctx); err != nil || tok != "tok-2" {
+		t.Fatalf("after recovery: %q, %v", tok, err)
+	}
+}
+
+func TestTokenEndpointError(t *testing.T) {
+	ts := newTokenServer(t, 600)
+	var c clock
+	src := newSource(ts, &c)
+	src.ClientSecret = "wrong"
+	_, err := src.Token(context.Background())
+	var e *Error
+	if !errors.As(err, &e) || e.Code != "invalid_client" || e.Description != "bad secret" {
+		t.Fatalf("err = %v", err)
+	}
+}
+
+func TestConcurrentCallersShareOneFetch(t *testing.T) {
+	ts := newTokenServer(t, 600)
+	var c clock
+	src := newSource(ts, &c)
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := src.Token(context.Background()); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := ts.issued.Load(); n != 1 {
+		t.Fatalf("token endpoint called %d times", n)
+	}
+}
+
+func TestFromEnv(t *testing.T) {
+	t.Setenv("OAUTH_TOKEN_URL", "")
+	if src, err := FromEnv(); src != nil || err != nil {
+		t.Fatalf("unset: %v, %v", src, err)
+	}
+	t.Setenv("OAUTH_TOKEN_URL", "http://idp/token")
+	t.Setenv("OAUTH_CLIENT_ID", "")
+	if _, err := FromEnv(); err == nil {
+		t.Fatal("missing client id accepted")
+	}
+	t.Setenv("OAUTH_CLIENT_ID", "client")
+	t.Setenv("OAUTH_SCOPES", "a, b c")
+	t.Setenv("OAUTH_EARLY_REFRESH", "2m")
+	src, err := FromEnv()
+	if err != nil || len(src.Scopes) != 3 || src.EarlyRefresh != 2*time.Minute {
+		t.Fatalf("%+v, %v", src, err)
+	}
+}
+
+// authHealth is a health server that records the authorization metadata
+// and rejects tokens listed in revoked.
+type authHealth struct {
+	*health.Server
+	mu      sync.Mutex
+	seen    []string
+	revoked map[string]bool
+}
+
+func (h *authHealth) record(ctx context.Context) error {
+	md, _ := metadata.FromIncomingContext(ctx)
+	auth := md.Get("authorization")
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(auth) != 1 {
+		return status.Errorf(codes.Unauthenticated, "want one authorization value, got %q", auth)
+	}
+	h.seen = append(h.seen, auth[0])
+	if h.revoked[auth[0]] {
+		return status.Error(codes.Unauthenticated, "token revoked")
+	}
+	return nil
+}
+
+func (h *authHealth) Check(ctx context.Context, req *healthpb.HealthCheckRequest) (*healthpb.HealthCheckResponse, error) {
+	if err := h.record(ctx); err != nil {
+		return nil, err
+	}
+	return h.Server.Check(ctx, req)
+}
+
+func (h *authHealth) Watch(req *healthpb.HealthCheckRequest, stream healthpb.Health_WatchServer) error {
+	if err := h.record(stream.Context()); err != nil {
+		return err
+	}
+	return h.Server.Watch(req, stream)
+}
+
+func TestInterceptorsAttachToken(t *testing.T) {
+	ts := newTokenServer(t, 600)
+	var c clock
+	src := newSource(ts, &c)
+
+	h := &authHealth{Server: health.NewServer(), revoked: map[string]bool{}}
+	s := grpc.NewServer()
+	healthpb.RegisterHealthServer(s, h)
+	lis, err := transport.Listen("inproc:oauth-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve(lis)
+	defer s.Stop()
+
+	conn, err := transport.Dial("inproc:oauth-test",
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithChainUnaryInterceptor(src.UnaryClientInterceptor()),
+		grpc.WithChainStreamInterceptor(src.StreamClientInterceptor()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client := healthpb.NewHealthClient(conn)
+
+	// a caller's own authorization is replaced, not duplicated
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer stale")
+	if _, err := client.Check(ctx, &healthpb.HealthCheckRequest{}); err != nil {
+		t.Fatal(err)
+	}
+	wctx, cancel := context.WithCancel(ctx)
+	stream, err := client.Watch(wctx, &healthpb.HealthCheckRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stream.Recv(); err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+
+	// a revoked token is dropped and the call retried once with a new one
+	h.mu.Lock()
+	h.revoked["Bearer tok-1"] = true
+	h.mu.Unlock()
+	if _, err := client.Check(context.Background(), &healthpb.HealthCheckRequest{}); err != nil {
+		t.Fatal(err)
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	want := []string{"Bearer tok-1", "Bearer tok-1", "Bearer tok-1", "Bearer tok-2"}
+	if fmt.Sprint(h.seen) != fmt.Sprint(want) {
+		t.Fatalf("server saw %q, want %q", h.seen, want)
+	}
+}
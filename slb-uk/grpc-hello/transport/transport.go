@@ -0,0 +1,130 @@
+// Package transport picks the listener and dialer for an address, so the
+// server, the clients and tests all accept the same GRPC_ADDR forms:
+//
+//	:50051, localhost:50051   TCP
+//	unix:///run/greeter.sock  Unix domain socket (also unix:relative.sock)
+//	inproc:greeter            in-memory, same process only
+//
+// In-process listeners are a registry of bufconn listeners by name: a test
+// or a program embedding the server calls Listen("inproc:x") and serves on
+// it, then Dial("inproc:x") connects without touching the network stack.
+package transport
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"strings"
+	"sync"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+const inprocBufSize = 1 << 20
+
+var (
+	mu     sync.Mutex
+	inproc = map[string]*inprocListener{}
+)
+
+// inprocListener unregisters its name on Close, so a name can be reused
+// once the server using it has stopped.
+type inprocListener struct {
+	*bufconn.Listener
+	name string
+}
+
+func (l *inprocListener) Close() error {
+	mu.Lock()
+	if inproc[l.name] == l {
+		delete(inproc, l.name)
+	}
+	mu.Unlock()
+	return l.Listener.Close()
+}
+
+// Addr reports the inproc address instead of bufconn's placeholder.
+func (l *inprocListener) Addr() net.Addr { return inprocAddr(l.name) }
+
+type inprocAddr string
+
+func (a inprocAddr) Network() string { return "inproc" }
+func (a inprocAddr) String() string  { return "inproc:" + string(a) }
+
+// unixPath returns the socket path of a unix: address, as grpc-go parses
+// it for dialing: unix:///abs/path, unix:/abs/path or unix:relative/path.
+func unixPath(addr string) (string, bool) {
+	rest, ok := strings.CutPrefix(addr, "unix:")
+	if !ok {
+		return "", false
+	}
+	if p, ok := strings.CutPrefix(rest, "//"); ok {
+		return p, true
+	}
+	return rest, true
+}
+
+// Listen opens a listener for addr.
+func Listen(addr string) (net.Listener, error) {
+	if name, ok := strings.CutPrefix(addr, "inproc:"); ok {
+		if name == "" {
+			return nil, errors.New("inproc: address needs a name")
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if _, taken := inproc[name]; taken {
+			return nil, fmt.Errorf("%s: already listening", addr)
+		}
+		l := &inprocListener{Listener: bufconn.Listen(inprocBufSize), name: name}
+		inproc[name] = l
+		return l, nil
+	}
+	if path, ok := unixPath(addr); ok {
+		if path == "" {
+			return nil, fmt.Errorf("%s: empty socket path", addr)
+		}
+		// a server that crashed leaves its socket behind; only ever remove sockets
+		if fi, err := os.Lstat(path); err == nil && fi.Mode().Type() == fs.ModeSocket {
+			if err := os.Remove(path); err != nil {
+				return nil, err
+			}
+		}
+		return net.Listen("unix", path)
+	}
+	return net.Listen("tcp", addr)
+}
+
+// Dial connects to addr. unix: targets are resolved by grpc-go itself;
+// inproc: targets need a listener registered in this process.
+func Dial(addr string, opts ...grpc.DialOption) (*grpc.ClientConn, error) {
+	name, ok := strings.CutPrefix(addr, "inproc:")
+	if !ok {
+		return grpc.Dial(addr, opts...)
+	}
+	dialer := func(ctx context.Context, _ string) (net.Conn, error) {
+		mu.Lock()
+		l := inproc[name]
+		mu.Unlock()
+		if l == nil {
+			return nil, fmt.Errorf("inproc:%s: no listener in this process", name)
+		}
+		return l.DialContext(ctx)
+	}
+	// passthrough keeps the name away from the DNS resolver
+	return grpc.Dial("passthrough:///"+name, append(opts, grpc.WithContextDialer(dialer))...)
+}
+
+// SplitAddrs splits a comma-separated GRPC_ADDR into its addresses.
+func SplitAddrs(s string) []string {
+	var out []string
+	for _, a := range strings.Split(s, ",") {
+		if a = strings.TrimSpace(a); a != "" {
+			out = append(out, a)
+		}
+	}
+	return out
+}
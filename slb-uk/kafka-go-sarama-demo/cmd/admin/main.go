@@ -5,6 +5,8 @@ import (
 	"time"
 
 	"github.com/IBM/sarama"
+
+	"example.com/kafka-go-sarama-demo/internal/schedule"
 )
 
 func str(s string) *string { return &s }
@@ -34,6 +36,17 @@ func main() {
 		"events.v1.dlq":            {NumPartitions: 3, ReplicationFactor: 1, ConfigEntries: map[string]*string{
 			"retention.ms": str("1209600000"), // 14 days
 		}},
+		// offset syncs written by cmd/mirror; only the latest per partition matters
+		"mirror.checkpoints":       {NumPartitions: 1, ReplicationFactor: 1, ConfigEntries: map[string]*string{
+			"cleanup.policy": str("compact"),
+		}},
+	}
+
+	// delay topics of internal/schedule; retention covers a stopped worker
+	for _, l := range schedule.Levels {
+		topics[l.Topic] = &sarama.TopicDetail{NumPartitions: 3, ReplicationFactor: 1, ConfigEntries: map[string]*string{
+			"retention.ms": str("604800000"),
+		}}
 	}
 
 	for t, d := range topics {
@@ -0,0 +1,436 @@
+// Command mirror copies topics from one Kafka cluster to another, a small
+// MirrorMaker: records keep their key, value, timestamp, headers and (by
+// default) partition, and get an x-mirrored-from header naming their source
+// topic/partition/offset.
+//
+//	go run ./cmd/mirror -source-brokers localhost:9092 -target-brokers dr:9092 \
+//	  -topics events.v1,events.v1.dlq -rate 500
+//
+// Progress is a consumer group on the source cluster (-group), and a source
+// offset is committed only after the target acked the copy, so a restart
+// repeats records rather than losing them. Every -checkpoint-interval the
+// latest source->target offset pair of each partition is written to
+// -checkpoint-topic on the target. With those, -translate maps a group's
+// committed source offsets to target offsets for a failover:
+//
+//	go run ./cmd/mirror -target-brokers dr:9092 -translate processor.v1          # print
+//	go run ./cmd/mirror -target-brokers dr:9092 -translate processor.v1 -apply   # commit on target
+//
+// Metrics, including lag on both sides, are served in Prometheus text
+// format on -metrics-addr/metrics.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/IBM/sarama"
+
+	"example.com/kafka-go-sarama-demo/internal/group"
+	"example.com/kafka-go-sarama-demo/internal/logging"
+	"example.com/kafka-go-sarama-demo/internal/mirror"
+	"example.com/kafka-go-sarama-demo/internal/validate"
+)
+
+type mirrorer struct {
+	prod     sarama.SyncProducer
+	prefix   string
+	keepPart bool
+	limit    *mirror.Limiter
+	syncs    *mirror.Syncs
+	metrics  *metrics
+	track    *group.Tracker
+	log      *slog.Logger
+}
+
+func main() {
+	logger := logging.New("mirror")
+
+	source := flag.String("source-brokers", "localhost:9092", "comma-separated source bootstrap brokers")
+	target := flag.String("target-brokers", "", "comma-separated target bootstrap brokers (required)")
+	topics := flag.String("topics", "events.v1", "comma-separated topics to mirror")
+	groupID := flag.String("group", "mirror", "consumer group on the source cluster")
+	prefix := flag.String("target-prefix", "", `prepended to target topic names, e.g. "eu." (required when source and target are the same cluster)`)
+	keepPart := flag.Bool("keep-partition", true, "write to the source partition number instead of partitioning by key")
+	rate := flag.Float64("rate", 0, "records per second across all partitions (0 = unlimited)")
+	burst := flag.Int("burst", 100, "records allowed through at once before -rate applies")
+	cpTopic := flag.String("checkpoint-topic", "mirror.checkpoints", "topic on the target that offset syncs are written to")
+	cpEvery := flag.Duration("checkpoint-interval", 10*time.Second, "how often offset syncs are written")
+	metricsAddr := flag.String("metrics-addr", ":9308", "serve /metrics here (empty disables)")
+	translate := flag.String("translate", "", "instead of mirroring, translate this group's committed source offsets to target offsets")
+	apply := flag.Bool("apply", false, "with -translate, commit the translated offsets for the group on the target")
+	flag.Parse()
+
+	var err error
+	switch {
+	case *target == "":
+		err = errors.New("-target-brokers is required")
+	case *topics == "" && *translate == "":
+		err = errors.New("-topics is required")
+	case *apply && *translate == "":
+		err = errors.New("-apply needs -translate")
+	case *prefix == "" && sameCluster(*source, *target):
+		err = errors.New("source and target are the same cluster: set -target-prefix or the mirror copies its own output")
+	}
+	if err != nil {
+		logging.Fatal(logger, "flags", err)
+	}
+
+	cfg := sarama.NewConfig()
+	cfg.Version, _ = sarama.ParseKafkaVersion("3.8.0")
+	sourceAddrs, targetAddrs := strings.Split(*source, ","), strings.Split(*target, ",")
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if *translate != "" {
+		if err := runTranslate(ctx, logger, cfg, sourceAddrs, targetAddrs, *translate, *prefix, *cpTopic, *apply); err != nil {
+			logging.Fatal(logger, "translate", err)
+		}
+		return
+	}
+
+	names := strings.Split(*topics, ",")
+	if err := checkTopics(cfg, sourceAddrs, targetAddrs, names, *prefix, *cpTopic, *keepPart); err != nil {
+		logging.Fatal(logger, "topics", err)
+	}
+
+	pcfg := sarama.NewConfig()
+	pcfg.Version = cfg.Version
+	pcfg.Producer.RequiredAcks = sarama.WaitForAll
+	pcfg.Producer.Idempotent = true
+	pcfg.Net.MaxOpenRequests = 1
+	pcfg.Producer.Return.Successes = true
+	pcfg.Producer.Partitioner = sarama.NewHashPartitioner
+	if *keepPart {
+		pcfg.Producer.Partitioner = sarama.NewManualPartitioner
+	}
+	raw, err := sarama.NewSyncProducer(targetAddrs, pcfg)
+	if err != nil {
+		logging.Fatal(logger, "producer", err)
+	}
+	prod, vcfg, err := validate.FromEnv(raw)
+	if err != nil {
+		logging.Fatal(logger, "produce validation", err)
+	}
+	logger.Info("produce validation", vcfg.Describe()...)
+	defer prod.Close()
+
+	ccfg := sarama.NewConfig()
+	ccfg.Version = cfg.Version
+	ccfg.Consumer.Offsets.Initial = sarama.OffsetOldest
+	ccfg.Consumer.Return.Errors = true
+	if err := group.Configure(ccfg); err != nil {
+		logging.Fatal(logger, "consumer group config", err)
+	}
+	logger.Info("consumer group", group.Describe(ccfg)...)
+	cg, err := sarama.NewConsumerGroup(sourceAddrs, *groupID, ccfg)
+	if err != nil {
+		logging.Fatal(logger, "consumer group", err)
+	}
+	defer cg.Close()
+	go func() {
+		for err := range cg.Errors() {
+			logger.Error("consumer group", "error", err)
+		}
+	}()
+
+	m := &mirrorer{prod: prod, prefix: *prefix, keepPart: *keepPart,
+		limit: mirror.NewLimiter(*rate, *burst), syncs: mirror.NewSyncs(1),
+		metrics: newMetrics(), track: group.NewTracker(logger), log: logger}
+
+	if *metricsAddr != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", m.metrics)
+		go func() {
+			logger.Info("metrics listening", "addr", *metricsAddr)
+			if err := http.ListenAndServe(*metricsAddr, mux); err != nil {
+				logger.Error("metrics server", "error", err)
+			}
+		}()
+	}
+
+	cpDone := make(chan struct{})
+	go func() {
+		defer close(cpDone)
+		m.checkpointLoop(ctx, *cpTopic, *cpEvery)
+	}()
+
+	logger.Info("mirroring", "topics", names, "from", *source, "to", *target, "prefix", *prefix, "rate", *rate)
+	for ctx.Err() == nil {
+		if err := cg.Consume(ctx, names, m); err != nil {
+			logger.Error("consume", "error", err)
+			time.Sleep(time.Second)
+		}
+	}
+	<-cpDone
+	logger.Info("mirror stopped")
+}
+
+func sameCluster(a, b string) bool {
+	set := map[string]bool{}
+	for _, s := range strings.Split(a, ",") {
+		set[strings.TrimSpace(s)] = true
+	}
+	for _, s := range strings.Split(b, ",") {
+		if set[strings.TrimSpace(s)] {
+			return true
+		}
+	}
+	return false
+}
+
+// checkTopics makes sure the checkpoint topic and every target topic exist
+// (the brokers do not auto-create them) and, when partitions are kept, that
+// each target has at least as many partitions as its source.
+func checkTopics(cfg *sarama.Config, source, target, topics []string, prefix, cpTopic string, keepPart bool) error {
+	sc, err := sarama.NewClient(source, cfg)
+	if err != nil {
+		return err
+	}
+	defer sc.Close()
+	tc, err := sarama.NewClient(target, cfg)
+	if err != nil {
+		return err
+	}
+	defer tc.Close()
+	if _, err := tc.Partitions(cpTopic); err != nil {
+		return fmt.Errorf("checkpoint topic %s: %w", cpTopic, err)
+	}
+	for _, t := range topics {
+		sp, err := sc.Partitions(t)
+		if err != nil {
+			return fmt.Errorf("source %s: %w", t, err)
+		}
+		tp, err := tc.Partitions(prefix + t)
+		if err != nil {
+			return fmt.Errorf("target %s: %w", prefix+t, err)
+		}
+		if keepPart && len(tp) < len(sp) {
+			return fmt.Errorf("target %s has %d partitions, source %s has %d: add partitions or pass -keep-partition=false",
+				prefix+t, len(tp), t, len(sp))
+		}
+	}
+	return nil
+}
+
+func (m *mirrorer) Setup(s sarama.ConsumerGroupSession) error {
+	m.track.Setup(s)
+	return nil
+}
+
+func (m *mirrorer) Cleanup(sarama.ConsumerGroupSession) error { return nil }
+
+func (m *mirrorer) ConsumeClaim(sess sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	for msg := range claim.Messages() {
+		waited, err := m.limit.Wait(sess.Context())
+		if err != nil {
+			return nil // shutting down; the record stays unmarked
+		}
+		m.metrics.throttled(waited)
+
+		tp, toff, err := m.prod.SendMessage(m.copyOf(msg))
+		if err != nil {
+			// end the session without marking: the next one starts here again
+			m.metrics.failed(msg.Topic)
+			return fmt.Errorf("mirror %s/%d/%d: %w", msg.Topic, msg.Partition, msg.Offset, err)
+		}
+		now := time.Now()
+		m.syncs.Add(mirror.Sync{SourceTopic: msg.Topic, SourcePartition: msg.Partition, SourceOffset: msg.Offset,
+			TargetTopic: m.prefix + msg.Topic, TargetPartition: tp, TargetOffset: toff, At: now})
+		m.metrics.mirrored(msg, claim.HighWaterMarkOffset(), now)
+		sess.MarkMessage(msg, "")
+	}
+	return nil
+}
+
+func (m *mirrorer) copyOf(msg *sarama.ConsumerMessage) *sarama.ProducerMessage {
+	out := &sarama.ProducerMessage{Topic: m.prefix + msg.Topic, Timestamp: msg.Timestamp}
+	for _, h := range msg.Headers {
+		out.Headers = append(out.Headers, *h)
+	}
+	out.Headers = append(out.Headers, sarama.RecordHeader{
+		Key:   []byte(mirror.HeaderMirroredFrom),
+		Value: []byte(fmt.Sprintf("%s/%d/%d", msg.Topic, msg.Partition, msg.Offset)),
+	})
+	if msg.Key != nil {
+		out.Key = sarama.ByteEncoder(msg.Key)
+	}
+	if msg.Value != nil { // keep tombstones tombstones
+		out.Value = sarama.ByteEncoder(msg.Value)
+	}
+	if m.keepPart {
+		out.Partition = msg.Partition
+	}
+	// copies of records from producers that do not set them
+	validate.Stamp(out, "application/octet-stream")
+	return out
+}
+
+// checkpointLoop writes the partitions that moved since the last checkpoint,
+// and once more on shutdown.
+func (m *mirrorer) checkpointLoop(ctx context.Context, topic string, every time.Duration) {
+	t := time.NewTicker(every)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			m.checkpoint(topic)
+			return
+		case <-t.C:
+			m.checkpoint(topic)
+		}
+	}
+}
+
+func (m *mirrorer) checkpoint(topic string) {
+	for _, s := range m.syncs.Pending() {
+		b, err := s.Encode()
+		if err == nil {
+			cp := &sarama.ProducerMessage{Topic: topic, Partition: 0,
+				Key: sarama.StringEncoder(s.Key()), Value: sarama.ByteEncoder(b)}
+			validate.Stamp(cp, "application/json")
+			_, _, err = m.prod.SendMessage(cp)
+		}
+		if err != nil {
+			// the next sync of this partition supersedes it anyway
+			m.log.Warn("checkpoint", "partition", s.Key(), "error", err)
+			continue
+		}
+		m.metrics.checkpointed(s)
+	}
+}
+
+// runTranslate reads the checkpoint topic and maps group's committed source
+// offsets to target offsets, committing them on the target with apply.
+func runTranslate(ctx context.Context, l *slog.Logger, cfg *sarama.Config, source, target []string, groupID, prefix, cpTopic string, apply bool) error {
+	tc, err := sarama.NewClient(target, cfg)
+	if err != nil {
+		return err
+	}
+	defer tc.Close()
+	syncs, n, err := loadCheckpoints(ctx, tc, cpTopic)
+	if err != nil {
+		return fmt.Errorf("read %s: %w", cpTopic, err)
+	}
+	l.Info("checkpoints loaded", "topic", cpTopic, "records", n)
+
+	admin, err := sarama.NewClusterAdmin(source, cfg)
+	if err != nil {
+		return err
+	}
+	defer admin.Close()
+	committed, err := admin.ListConsumerGroupOffsets(groupID, nil)
+	if err != nil {
+		return err
+	}
+
+	var om sarama.OffsetManager
+	if apply {
+		if om, err = sarama.NewOffsetManagerFromClient(groupID, tc); err != nil {
+			return err
+		}
+		defer om.Close()
+	}
+
+	topics := make([]string, 0, len(committed.Blocks))
+	for t := range committed.Blocks {
+		topics = append(topics, t)
+	}
+	sort.Strings(topics)
+	for _, t := range topics {
+		parts := make([]int32, 0, len(committed.Blocks[t]))
+		for p := range committed.Blocks[t] {
+			parts = append(parts, p)
+		}
+		sort.Slice(parts, func(i, j int) bool { return parts[i] < parts[j] })
+		for _, p := range parts {
+			off := committed.Blocks[t][p].Offset
+			if off < 0 {
+				continue // nothing committed
+			}
+			s, to, ok := syncs.Translate(t, p, off)
+			if !ok {
+				l.Warn("no checkpoint at or before the committed offset; start from the oldest target offset",
+					"topic", t, "partition", p, "source_offset", off)
+				continue
+			}
+			fmt.Printf("%s/%d@%d -> %s/%d@%d (sync %d->%d at %s)\n", t, p, off,
+				s.TargetTopic, s.TargetPartition, to, s.SourceOffset, s.TargetOffset, s.At.Format(time.RFC3339))
+			if om == nil {
+				continue
+			}
+			pom, err := om.ManagePartition(prefix+t, s.TargetPartition)
+			if err != nil {
+				return err
+			}
+			pom.ResetOffset(to, "translated by mirror") // may move backwards, unlike MarkOffset
+			pom.AsyncClose()
+		}
+	}
+	if om != nil {
+		om.Commit()
+		l.Info("translated offsets committed", "group", groupID)
+	}
+	return nil
+}
+
+// loadCheckpoints reads every checkpoint partition up to its high water mark.
+func loadCheckpoints(ctx context.Context, c sarama.Client, topic string) (*mirror.Syncs, int, error) {
+	syncs := mirror.NewSyncs(1024)
+	consumer, err := sarama.NewConsumerFromClient(c)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer consumer.Close()
+	parts, err := c.Partitions(topic)
+	if err != nil {
+		return nil, 0, err
+	}
+	n := 0
+	for _, p := range parts {
+		end, err := c.GetOffset(topic, p, sarama.OffsetNewest)
+		if err != nil {
+			return nil, 0, err
+		}
+		start, err := c.GetOffset(topic, p, sarama.OffsetOldest)
+		if err != nil {
+			return nil, 0, err
+		}
+		if start >= end {
+			continue
+		}
+		pc, err := consumer.ConsumePartition(topic, p, start)
+		if err != nil {
+			return nil, 0, err
+		}
+		for done := false; !done; {
+			select {
+			case <-ctx.Done():
+				pc.Close()
+				return nil, 0, ctx.Err()
+			case err := <-pc.Errors():
+				pc.Close()
+				return nil, 0, err
+			case msg := <-pc.Messages():
+				if s, err := mirror.DecodeSync(msg.Value); err == nil {
+					syncs.Add(s)
+					n++
+				}
+				done = msg.Offset+1 >= end
+			}
+		}
+		pc.Close()
+	}
+	return syncs, n, nil
+}
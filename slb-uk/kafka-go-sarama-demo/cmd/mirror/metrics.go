@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/IBM/sarama"
+
+	"example.com/kafka-go-sarama-demo/internal/mirror"
+)
+
+// metrics is written out in the Prometheus text format by hand; the demo has
+// no Prometheus dependency and this is all the mirror needs.
+//
+// Lag is reported from both ends: source lag is how many records the source
+// partition holds that have not been copied yet, target lag is how old the
+// last copied record was when the target acked it. Checkpoint lag is how
+// far the last written checkpoint trails the last copied record, i.e. how
+// many records a failover right now would deliver twice.
+type metrics struct {
+	mu           sync.Mutex
+	records      map[string]float64 // by source topic
+	bytes        map[string]float64
+	failures     map[string]float64
+	parts        map[partKey]*partMetrics
+	throttledSec float64
+	checkpoints  float64
+}
+
+type partKey struct {
+	topic     string
+	partition int32
+}
+
+type partMetrics struct {
+	sourceLag     int64   // high water mark - next offset
+	targetLagSec  float64 // ack time - record timestamp
+	lastOffset    int64   // last copied source offset
+	checkpointOff int64   // source offset of the last checkpoint, -1 before the first
+}
+
+func newMetrics() *metrics {
+	return &metrics{records: map[string]float64{}, bytes: map[string]float64{}, failures: map[string]float64{},
+		parts: map[partKey]*partMetrics{}}
+}
+
+func (m *metrics) part(topic string, p int32) *partMetrics {
+	k := partKey{topic, p}
+	pm, ok := m.parts[k]
+	if !ok {
+		pm = &partMetrics{checkpointOff: -1}
+		m.parts[k] = pm
+	}
+	return pm
+}
+
+func (m *metrics) mirrored(msg *sarama.ConsumerMessage, hwm int64, acked time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.records[msg.Topic]++
+	m.bytes[msg.Topic] += float64(len(msg.Key) + len(msg.Value))
+	pm := m.part(msg.Topic, msg.Partition)
+	pm.sourceLag = max(0, hwm-msg.Offset-1)
+	pm.lastOffset = msg.Offset
+	if !msg.Timestamp.IsZero() {
+		pm.targetLagSec = acked.Sub(msg.Timestamp).Seconds()
+	}
+}
+
+func (m *metrics) failed(topic string) {
+	m.mu.Lock()
+	m.failures[topic]++
+	m.mu.Unlock()
+}
+
+func (m *metrics) throttled(d time.Duration) {
+	if d <= 0 {
+		return
+	}
+	m.mu.Lock()
+	m.throttledSec += d.Seconds()
+	m.mu.Unlock()
+}
+
+func (m *metrics) checkpointed(s mirror.Sync) {
+	m.mu.Lock()
+	m.checkpoints++
+	m.part(s.SourceTopic, s.SourcePartition).checkpointOff = s.SourceOffset
+	m.mu.Unlock()
+}
+
+func (m *metrics) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	counter := func(name, help string, byTopic map[string]float64) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", name, help, name)
+		topics := make([]string, 0, len(byTopic))
+		for t := range byTopic {
+			topics = append(topics, t)
+		}
+		sort.Strings(topics)
+		for _, t := range topics {
+			fmt.Fprintf(w, "%s{topic=%q} %g\n", name, t, byTopic[t])
+		}
+	}
+	counter("mirror_records_total", "Records copied to the target.", m.records)
+	counter("mirror_bytes_total", "Key and value bytes copied to the target.", m.bytes)
+	counter("mirror_produce_failures_total", "Records the target did not accept.", m.failures)
+
+	keys := make([]partKey, 0, len(m.parts))
+	for k := range m.parts {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].topic != keys[j].topic {
+			return keys[i].topic < keys[j].topic
+		}
+		return keys[i].partition < keys[j].partition
+	})
+	gauge := func(name, help string, value func(*partMetrics) (float64, bool)) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", name, help, name)
+		for _, k := range keys {
+			if v, ok := value(m.parts[k]); ok {
+				fmt.Fprintf(w, "%s{topic=%q,partition=\"%d\"} %g\n", name, k.topic, k.partition, v)
+			}
+		}
+	}
+	gauge("mirror_source_lag_records", "Records in the source partition not copied yet.",
+		func(p *partMetrics) (float64, bool) { return float64(p.sourceLag), true })
+	gauge("mirror_target_lag_seconds", "Age of the last copied record when the target acked it.",
+		func(p *partMetrics) (float64, bool) { return p.targetLagSec, true })
+	gauge("mirror_checkpoint_lag_records", "Source records copied since the last checkpoint.",
+		func(p *partMetrics) (float64, bool) {
+			return float64(p.lastOffset - p.checkpointOff), p.checkpointOff >= 0
+		})
+
+	fmt.Fprintf(w, "# HELP mirror_throttled_seconds_total Time spent waiting for -rate.\n# TYPE mirror_throttled_seconds_total counter\nmirror_throttled_seconds_total %g\n", m.throttledSec)
+	fmt.Fprintf(w, "# HELP mirror_checkpoints_total Offset syncs written to the checkpoint topic.\n# TYPE mirror_checkpoints_total counter\nmirror_checkpoints_total %g\n", m.checkpoints)
+}
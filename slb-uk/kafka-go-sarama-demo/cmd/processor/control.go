@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/IBM/sarama"
+)
+
+// control lets operators pause and resume consumption without stopping the
+// process (e.g. while a downstream dependency drains), and reports what this
+// instance currently owns.
+//
+//	curl -XPOST localhost:8082/pause
+//	curl localhost:8082/status   # wait for in_flight == 0
+//	curl -XPOST localhost:8082/resume
+type control struct {
+	cg  sarama.ConsumerGroup
+	log *slog.Logger
+
+	paused   atomic.Bool
+	inFlight atomic.Int64
+	skipped  atomic.Int64
+	filter   string
+
+	mu         sync.Mutex
+	memberID   string
+	generation int32
+	parts      map[topicPartition]*partitionState
+}
+
+type topicPartition struct {
+	topic     string
+	partition int32
+}
+
+type partitionState struct {
+	next int64 // next offset to process, -1 until known
+	hwm  int64 // high water mark last reported by the claim
+}
+
+type assignment struct {
+	Topic         string `json:"topic"`
+	Partition     int32  `json:"partition"`
+	NextOffset    int64  `json:"next_offset"`
+	HighWaterMark int64  `json:"high_water_mark"`
+	Lag           *int64 `json:"lag,omitempty"`
+}
+
+type status struct {
+	Paused      bool         `json:"paused"`
+	MemberID    string       `json:"member_id,omitempty"`
+	Generation  int32        `json:"generation"`
+	InFlight    int64        `json:"in_flight"`
+	Filter      string       `json:"filter,omitempty"`
+	Skipped     int64        `json:"skipped"`
+	TotalLag    int64        `json:"total_lag"`
+	Assignments []assignment `json:"assignments"`
+}
+
+func newControl(cg sarama.ConsumerGroup, l *slog.Logger, filter string) *control {
+	return &control{cg: cg, log: l, filter: filter, parts: map[topicPartition]*partitionState{}}
+}
+
+// assigned records the partitions handed to this member by a rebalance.
+func (c *control) assigned(s sarama.ConsumerGroupSession) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.memberID, c.generation = s.MemberID(), s.GenerationID()
+	c.parts = map[topicPartition]*partitionState{}
+	for topic, partitions := range s.Claims() {
+		for _, p := range partitions {
+			c.parts[topicPartition{topic, p}] = &partitionState{next: -1, hwm: -1}
+		}
+	}
+}
+
+func (c *control) revoked() {
+	c.mu.Lock()
+	c.parts = map[topicPartition]*partitionState{}
+	c.mu.Unlock()
+}
+
+// claimed is called when a partition starts consuming. Sarama's PauseAll only
+// affects partitions that already exist, so a pause has to be re-applied to
+// claims created by a rebalance.
+func (c *control) claimed(claim sarama.ConsumerGroupClaim) {
+	c.mu.Lock()
+	if ps, ok := c.parts[topicPartition{claim.Topic(), claim.Partition()}]; ok && claim.InitialOffset() >= 0 {
+		ps.next = claim.InitialOffset()
+	}
+	c.mu.Unlock()
+	if c.paused.Load() {
+		c.cg.PauseAll()
+	}
+}
+
+func (c *control) begin() { c.inFlight.Add(1) }
+
+// skip counts a record CONSUME_FILTER did not match.
+func (c *control) skip() { c.skipped.Add(1) }
+
+// done records that msg has been handled; hwm is the claim's current high
+// water mark.
+func (c *control) done(msg *sarama.ConsumerMessage, hwm int64) {
+	c.inFlight.Add(-1)
+	c.mu.Lock()
+	if ps, ok := c.parts[topicPartition{msg.Topic, msg.Partition}]; ok {
+		ps.next, ps.hwm = msg.Offset+1, hwm
+	}
+	c.mu.Unlock()
+}
+
+func (c *control) status() status {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	st := status{
+		Paused:      c.paused.Load(),
+		MemberID:    c.memberID,
+		Generation:  c.generation,
+		InFlight:    c.inFlight.Load(),
+		Filter:      c.filter,
+		Skipped:     c.skipped.Load(),
+		Assignments: make([]assignment, 0, len(c.parts)),
+	}
+	for tp, ps := range c.parts {
+		a := assignment{Topic: tp.topic, Partition: tp.partition, NextOffset: ps.next, HighWaterMark: ps.hwm}
+		if ps.next >= 0 && ps.hwm >= ps.next {
+			lag := ps.hwm - ps.next
+			a.Lag = &lag
+			st.TotalLag += lag
+		}
+		st.Assignments = append(st.Assignments, a)
+	}
+	sort.Slice(st.Assignments, func(i, j int) bool {
+		a, b := st.Assignments[i], st.Assignments[j]
+		if a.Topic != b.Topic {
+			return a.Topic < b.Topic
+		}
+		return a.Partition < b.Partition
+	})
+	return st
+}
+
+func (c *control) routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/pause", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		c.paused.Store(true)
+		c.cg.PauseAll()
+		c.log.Warn("consumption paused", "remote", r.RemoteAddr)
+		writeStatus(w, c.status())
+	})
+	mux.HandleFunc("/resume", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		c.paused.Store(false)
+		c.cg.ResumeAll()
+		c.log.Info("consumption resumed", "remote", r.RemoteAddr)
+		writeStatus(w, c.status())
+	})
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		writeStatus(w, c.status())
+	})
+	return mux
+}
+
+func writeStatus(w http.ResponseWriter, st status) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(st)
+}
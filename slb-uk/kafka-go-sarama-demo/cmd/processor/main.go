@@ -3,7 +3,9 @@ package main
 import (
 	"context"
 	"errors"
-	"log"
+	"fmt"
+	"log/slog"
+	"net/http"
 	"os"
 	"os/signal"
 	"strconv"
@@ -13,67 +15,79 @@ import (
 	"github.com/IBM/sarama"
 	"github.com/dnwe/otelsarama"
 
+	"example.com/kafka-go-sarama-demo/internal/filter"
+	"example.com/kafka-go-sarama-demo/internal/group"
+	"example.com/kafka-go-sarama-demo/internal/kafka"
+	"example.com/kafka-go-sarama-demo/internal/logging"
+	"example.com/kafka-go-sarama-demo/internal/poison"
 	"example.com/kafka-go-sarama-demo/internal/retry"
 	"example.com/kafka-go-sarama-demo/internal/tracing"
+	"example.com/kafka-go-sarama-demo/internal/validate"
 
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/codes"
-	"go.opentelemetry.io/otel/propagation"
 )
 
-type handler struct{ prod sarama.SyncProducer }
-
-func (h *handler) Setup(s sarama.ConsumerGroupSession) error   { return nil }
-func (h *handler) Cleanup(s sarama.ConsumerGroupSession) error { return nil }
+type handler struct {
+	prod   kafka.Producer
+	log    *slog.Logger
+	ctl    *control
+	track  *group.Tracker
+	poison *poison.Tracker
+	filter *filter.Filter
+}
 
-func parseAttempt(msg *sarama.ConsumerMessage) int {
-	for _, h := range msg.Headers {
-		if string(h.Key) == retry.HeaderAttempt {
-			if n, err := strconv.Atoi(string(h.Value)); err == nil { return n }
-		}
-	}
-	return 0
+func (h *handler) Setup(s sarama.ConsumerGroupSession) error {
+	h.track.Setup(s)
+	h.poison.Reset()
+	h.ctl.assigned(s)
+	return nil
 }
+func (h *handler) Cleanup(s sarama.ConsumerGroupSession) error { h.ctl.revoked(); return nil }
+
+// fallbackContentType is stamped on retry/DLQ copies of records that were
+// produced without a content-type; the payload rule does not inspect it.
+const fallbackContentType = "application/octet-stream"
 
+// publishNextRetry sends msg to its next retry stage, or to the DLQ once
+// the stages are used up; see retry.Route.
 func (h *handler) publishNextRetry(msg *sarama.ConsumerMessage, err error) error {
-	attempt := parseAttempt(msg)
-	if stage, ok := retry.Next(attempt); ok {
-		out := &sarama.ProducerMessage{
-			Topic: stage.Topic,
-			Key:   sarama.ByteEncoder(msg.Key),
-			Value: sarama.ByteEncoder(msg.Value),
-			Headers: append([]sarama.RecordHeader{}, append(msg.Headers,
-				sarama.RecordHeader{Key: []byte(retry.HeaderAttempt), Value: []byte(strconv.Itoa(attempt + 1))},
-				sarama.RecordHeader{Key: []byte(retry.HeaderError),   Value: []byte(err.Error())},
-			)...),
-		}
-		_, _, e := h.prod.SendMessage(out)
-		return e
+	return retry.Publish(h.prod, msg, err, fallbackContentType)
+}
+
+// publishPoison sends a record that failed failures times at its offset
+// straight to the DLQ, skipping the retry stages.
+func (h *handler) publishPoison(msg *sarama.ConsumerMessage, err error, failures int) error {
+	var headers []sarama.RecordHeader
+	for _, hdr := range msg.Headers {
+		headers = append(headers, *hdr)
 	}
-	// Exhausted → DLQ
 	out := &sarama.ProducerMessage{
-		Topic: "events.v1.dlq",
+		Topic: retry.DLQTopic,
 		Key:   sarama.ByteEncoder(msg.Key),
 		Value: sarama.ByteEncoder(msg.Value),
-		Headers: append(msg.Headers,
-			sarama.RecordHeader{Key: []byte(retry.HeaderAttempt), Value: []byte(strconv.Itoa(attempt))},
-			sarama.RecordHeader{Key: []byte(retry.HeaderError),   Value: []byte(err.Error())},
+		Headers: append(headers,
+			sarama.RecordHeader{Key: []byte(poison.Header),         Value: []byte("true")},
+			sarama.RecordHeader{Key: []byte(poison.HeaderFailures), Value: []byte(strconv.Itoa(failures))},
+			sarama.RecordHeader{Key: []byte(retry.HeaderAttempt),   Value: []byte(strconv.Itoa(retry.Attempt(msg)))},
+			sarama.RecordHeader{Key: []byte(retry.HeaderError),     Value: []byte(err.Error())},
 		),
 	}
+	validate.Stamp(out, fallbackContentType)
 	_, _, e := h.prod.SendMessage(out)
 	return e
 }
 
-// businessLogic demonstrates a manual child span (e.g., simulating a DB write).
-func businessLogic(msg *sarama.ConsumerMessage) error {
-	// Extract context from message headers for proper span parenting.
-	carrier := propagation.HeaderCarrier{}
-	for _, h := range msg.Headers {
-		carrier.Set(string(h.Key), string(h.Value))
-	}
-	ctx := otel.GetTextMapPropagator().Extract(context.Background(), carrier)
+// process runs businessLogic, turning a panic into a *poison.PanicError.
+func process(ctx context.Context, msg *sarama.ConsumerMessage) (err error) {
+	defer poison.Recover(&err)
+	return businessLogic(ctx, msg)
+}
 
+// businessLogic demonstrates a manual child span (e.g., simulating a DB write).
+// ctx carries the span context extracted from the message headers.
+func businessLogic(ctx context.Context, msg *sarama.ConsumerMessage) error {
 	ctx, span := otel.Tracer("processor").Start(ctx, "businessLogic")
 	defer span.End()
 
@@ -83,7 +97,10 @@ func businessLogic(msg *sarama.ConsumerMessage) error {
 		attribute.Int64("kafka.offset", msg.Offset),
 	)
 
-	// Very basic demo: fail when payload starts with "fail:"
+	// Very basic demo: panic on "panic:", fail on "fail:"
+	if len(msg.Value) >= 6 && string(msg.Value[:6]) == "panic:" {
+		panic("simulated handler bug")
+	}
 	if len(msg.Value) >= 5 && string(msg.Value[:5]) == "fail:" {
 		err := errors.New("downstream: simulated failure")
 		span.RecordError(err)
@@ -99,36 +116,92 @@ func businessLogic(msg *sarama.ConsumerMessage) error {
 }
 
 func (h *handler) ConsumeClaim(s sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	h.ctl.claimed(claim)
 	for msg := range claim.Messages() {
-		if err := businessLogic(msg); err != nil {
-			log.Printf("process error, routing to retry/DLQ: %v", err)
-			if e := h.publishNextRetry(msg, err); e != nil {
-				log.Printf("retry publish failed: %v", e)
-				continue // don't mark => will be retried
-			}
-			s.MarkMessage(msg, "forwarded")
-			continue
+		h.ctl.begin()
+		h.handle(s, msg)
+		h.ctl.done(msg, claim.HighWaterMarkOffset())
+		if s.Context().Err() != nil {
+			return nil
 		}
-		s.MarkMessage(msg, "")
 	}
 	return nil
 }
 
-func newSyncProducer(cfg *sarama.Config) sarama.SyncProducer {
+// handle processes msg until it is marked: processed, forwarded to a retry
+// stage, or, after failing poison.Max times at this offset, sent to the DLQ
+// as a poison pill. Failures that leave the record unmarked (a panic, a
+// failed retry publish) are retried in place with a short backoff. A record
+// CONSUME_FILTER does not match is marked without being processed.
+func (h *handler) handle(s kafka.Session, msg *sarama.ConsumerMessage) {
+	if ok, reason := h.filter.Match(msg.Headers); !ok {
+		h.ctl.skip()
+		h.log.Debug("skipped by filter", append(logging.Message(msg), "condition", reason)...)
+		s.MarkMessage(msg, "filtered")
+		return
+	}
+	defer h.poison.Done(msg.Topic, msg.Partition, msg.Offset)
+	for {
+		start := time.Now()
+		ctx := tracing.ContextFromMessage(context.Background(), msg)
+		l := h.log.With(logging.Message(msg)...).With("attempt", retry.Attempt(msg))
+		err := process(ctx, msg)
+		if err == nil {
+			l.InfoContext(ctx, "processed", "duration_ms", time.Since(start).Milliseconds())
+			s.MarkMessage(msg, "")
+			return
+		}
+
+		var pe *poison.PanicError
+		if errors.As(err, &pe) {
+			l.ErrorContext(ctx, "handler panic", "panic", fmt.Sprint(pe.Value), "stack", string(pe.Stack))
+		} else {
+			l.WarnContext(ctx, "process error, routing to retry/DLQ", "error", err, "duration_ms", time.Since(start).Milliseconds())
+			e := h.publishNextRetry(msg, err)
+			if e == nil {
+				s.MarkMessage(msg, "forwarded")
+				return
+			}
+			l.ErrorContext(ctx, "retry publish failed", "error", e)
+			err = fmt.Errorf("retry publish: %w", e)
+		}
+
+		failures, isPoison := h.poison.Fail(msg.Topic, msg.Partition, msg.Offset)
+		if isPoison {
+			if e := h.publishPoison(msg, err, failures); e != nil {
+				l.ErrorContext(ctx, "poison DLQ publish failed, leaving unmarked", "error", e, "failures", failures)
+				return
+			}
+			l.WarnContext(ctx, "poison pill routed to DLQ", "error", err, "failures", failures)
+			s.MarkMessage(msg, "poison")
+			return
+		}
+		l.WarnContext(ctx, "retrying in place", "failures", failures, "max_failures", h.poison.Max())
+		select {
+		case <-s.Context().Done():
+			return
+		case <-time.After(time.Duration(failures) * 500 * time.Millisecond):
+		}
+	}
+}
+
+func newSyncProducer(l *slog.Logger, cfg *sarama.Config) sarama.SyncProducer {
 	p, err := sarama.NewSyncProducer([]string{"localhost:9092"}, cfg)
-	if err != nil { log.Fatalf("producer: %v", err) }
+	if err != nil { logging.Fatal(l, "producer", err) }
 	return p
 }
 
 func main() {
+	logger := logging.New("processor")
 	shutdown, err := tracing.Init("processor")
-	if err != nil { log.Fatalf("otel init: %v", err) }
+	if err != nil { logging.Fatal(logger, "otel init", err) }
 	defer shutdown(context.Background())
 
 	cfg := sarama.NewConfig()
 	cfg.Version, _ = sarama.ParseKafkaVersion("3.8.0")
-	cfg.Consumer.Group.Rebalance.Strategy = sarama.BalanceStrategyRange
 	cfg.Consumer.Offsets.Initial = sarama.OffsetOldest
+	if err := group.Configure(cfg); err != nil { logging.Fatal(logger, "consumer group config", err) }
+	logger.Info("consumer group", group.Describe(cfg)...)
 	cfg.Metadata.RefreshFrequency = time.Minute
 
 	// producer for retry/DLQ publishing and instrument it.
@@ -140,20 +213,39 @@ func main() {
 	pcfg.Producer.Return.Successes = true
 	pcfg.Producer.Retry.Max = 10
 
-	rawProd := newSyncProducer(pcfg)
-	prod := otelsarama.WrapSyncProducer(pcfg, rawProd)
+	rawProd := newSyncProducer(logger, pcfg)
+	prod, vcfg, err := validate.FromEnv(otelsarama.WrapSyncProducer(pcfg, rawProd))
+	if err != nil { logging.Fatal(logger, "produce validation", err) }
+	logger.Info("produce validation", vcfg.Describe()...)
 	defer prod.Close()
 
-	cg, err := sarama.NewConsumerGroup([]string{"localhost:9092"}, "processor.v1", cfg)
-	if err != nil { log.Fatalf("consumer group: %v", err) }
+	// processors with different CONSUME_FILTERs each need their own group
+	groupID := os.Getenv("PROCESSOR_GROUP")
+	if groupID == "" { groupID = "processor.v1" }
+	cg, err := sarama.NewConsumerGroup([]string{"localhost:9092"}, groupID, cfg)
+	if err != nil { logging.Fatal(logger, "consumer group", err) }
 	defer cg.Close()
 
-	h := otelsarama.WrapConsumerGroupHandler(&handler{prod: prod})
+	pt, err := poison.FromEnv()
+	if err != nil { logging.Fatal(logger, "poison config", err) }
+	flt, err := filter.FromEnv()
+	if err != nil { logging.Fatal(logger, "consume filter", err) }
+	logger.Info("consume filter", "group", groupID, "filter", flt.String())
+
+	ctl := newControl(cg, logger, flt.String())
+	h := otelsarama.WrapConsumerGroupHandler(&handler{prod: prod, log: logger, ctl: ctl, track: group.NewTracker(logger), poison: pt, filter: flt})
+
+	controlAddr := os.Getenv("CONTROL_ADDR")
+	if controlAddr == "" { controlAddr = ":8082" }
+	go func() {
+		logger.Info("control server listening", "addr", controlAddr)
+		if err := http.ListenAndServe(controlAddr, ctl.routes()); err != nil { logger.Error("control server", "error", err) }
+	}()
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	go func() { for err := range cg.Errors() { log.Printf("consumer error: %v", err) } }()
+	go func() { for err := range cg.Errors() { logger.Error("consumer error", "error", err) } }()
 
 	go func() {
 		sig := make(chan os.Signal, 1)
@@ -164,7 +256,7 @@ func main() {
 
 	for ctx.Err() == nil {
 		if err := cg.Consume(ctx, []string{"events.v1"}, h); err != nil {
-			log.Printf("consume: %v", err)
+			logger.Error("consume", "error", err)
 			time.Sleep(time.Second)
 		}
 	}
@@ -1,27 +1,43 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"log"
+	"os"
+	"log/slog"
 	"time"
 
 	"github.com/IBM/sarama"
 	"github.com/dnwe/otelsarama"
+	"example.com/kafka-go-sarama-demo/internal/keys"
+	"example.com/kafka-go-sarama-demo/internal/logging"
+	"example.com/kafka-go-sarama-demo/internal/schedule"
 	"example.com/kafka-go-sarama-demo/internal/tracing"
+	"example.com/kafka-go-sarama-demo/internal/validate"
 )
 
-func mustParse(v string) sarama.KafkaVersion {
-	ver, err := sarama.ParseKafkaVersion(v); if err != nil { log.Fatal(err) }
+func mustParse(l *slog.Logger, v string) sarama.KafkaVersion {
+	ver, err := sarama.ParseKafkaVersion(v); if err != nil { logging.Fatal(l, "kafka version", err) }
 	return ver
 }
 
+func recordHeaders(msg *sarama.ProducerMessage) []*sarama.RecordHeader {
+	out := make([]*sarama.RecordHeader, len(msg.Headers))
+	for i := range msg.Headers {
+		out[i] = &msg.Headers[i]
+	}
+	return out
+}
+
 func main() {
+	logger := logging.New("producer")
 	shutdown, err := tracing.Init("producer")
-	if err != nil { log.Fatalf("otel init: %v", err) }
+	if err != nil { logging.Fatal(logger, "otel init", err) }
 	defer shutdown(nil)
 
 	cfg := sarama.NewConfig()
-	cfg.Version = mustParse("3.8.0")
+	cfg.Version = mustParse(logger, "3.8.0")
 	cfg.Producer.Idempotent = true
 	cfg.Producer.RequiredAcks = sarama.WaitForAll
 	cfg.Net.MaxOpenRequests = 1
@@ -29,27 +45,56 @@ func main() {
 	cfg.Producer.Retry.Max = 10
 	cfg.Producer.Compression = sarama.CompressionSnappy
 	cfg.Metadata.RefreshFrequency = time.Minute
+	// murmur2 matches JVM producers; jump keeps most keys in place when partitions are added
+	switch os.Getenv("PARTITIONER") {
+	case "jump":
+		cfg.Producer.Partitioner = keys.NewPartitioner(keys.Jump, nil)
+	case "", "murmur2":
+		cfg.Producer.Partitioner = keys.NewPartitioner(keys.Murmur2, nil)
+	default:
+		logging.Fatal(logger, "partitioner", fmt.Errorf("unknown PARTITIONER %q (murmur2, jump)", os.Getenv("PARTITIONER")))
+	}
 
 	raw, err := sarama.NewSyncProducer([]string{"localhost:9092"}, cfg)
-	if err != nil { log.Fatalf("new producer: %v", err) }
-	prod := otelsarama.WrapSyncProducer(cfg, raw)
+	if err != nil { logging.Fatal(logger, "new producer", err) }
+	// validate outermost: a rejected record gets no produce span
+	prod, vcfg, err := validate.FromEnv(otelsarama.WrapSyncProducer(cfg, raw))
+	if err != nil { logging.Fatal(logger, "produce validation", err) }
+	logger.Info("produce validation", vcfg.Describe()...)
 	defer prod.Close()
 
-	send := func(val string) {
+	// x-event-type lets processors pick records with CONSUME_FILTER
+	send := func(eventType, val string) {
 		msg := &sarama.ProducerMessage{
-			Topic: "events.v1",
-			Key:   sarama.StringEncoder("user-42"),
-			Value: sarama.StringEncoder(val),
-			Headers: []sarama.RecordHeader{
-				{Key: []byte("content-type"), Value: []byte("text/plain")},
-			},
+			Topic:   "events.v1",
+			Key:     sarama.StringEncoder("user-42"),
+			Value:   sarama.StringEncoder(val),
+			Headers: []sarama.RecordHeader{{Key: []byte("x-event-type"), Value: []byte(eventType)}},
 		}
+		validate.Stamp(msg, "text/plain; charset=utf-8")
+		start := time.Now()
 		p, o, err := prod.SendMessage(msg)
-		if err != nil { log.Printf("send error: %v", err); return }
-		log.Printf("sent partition=%d offset=%d val=%q", p, o, val)
+		l := logger.With("topic", msg.Topic, "key", "user-42", "duration_ms", time.Since(start).Milliseconds())
+		// otelsarama injects the produce span into the headers; log with it
+		ctx := tracing.ContextFromMessage(context.Background(), &sarama.ConsumerMessage{Headers: recordHeaders(msg)})
+		var ve *validate.Error
+		if errors.As(err, &ve) { l.ErrorContext(ctx, "rejected", "rule", ve.Rule, "error", err); return }
+		if err != nil { l.ErrorContext(ctx, "send error", "error", err); return }
+		l.InfoContext(ctx, "sent", "partition", p, "offset", o, "value", val)
 	}
 
-	send("ok: welcome")
-	send("fail: simulate downstream error")
+	send("demo.ok", "ok: welcome")
+	send("demo.fail", "fail: simulate downstream error")
+	send("demo.panic", "panic: simulate a handler bug")
+
+	// reaches events.v1 in 75s via the delay topics, moved on by the retry worker
+	at := time.Now().Add(75 * time.Second)
+	msg := &sarama.ProducerMessage{Topic: "events.v1", Key: sarama.StringEncoder("user-42"), Value: sarama.StringEncoder("ok: scheduled")}
+	validate.Stamp(msg, "text/plain; charset=utf-8")
+	if p, o, err := schedule.NewProducer(prod).SendAt(msg, at); err != nil {
+		logger.Error("schedule error", "error", err)
+	} else {
+		logger.Info("scheduled", "topic", msg.Topic, "partition", p, "offset", o, "deliver_at", at.Format(time.RFC3339))
+	}
 	fmt.Println("done.")
 }
@@ -0,0 +1,368 @@
+// Command replay re-produces a range of records from one topic to another,
+// e.g. the DLQ back onto events.v1 once the downstream bug is fixed:
+//
+//	go run ./cmd/replay -from events.v1.dlq -to events.v1 \
+//	  -since 2024-05-01T10:00:00Z -until 2024-05-01T11:00:00Z \
+//	  -transform 'select(.type == "order") | del(.debug)'
+//
+// The range applies to every source partition: -start-offset/-end-offset
+// or -since/-until (timestamps resolved per partition with ListOffsets).
+// End bounds are exclusive; without one the replay stops at the high
+// watermark seen at start, so it terminates even while producers keep
+// writing. Keys, headers and timestamps are kept, and each record gets an
+// x-replayed-from header naming its source topic/partition/offset.
+//
+// The producer is not wrapped with otelsarama on purpose: the original
+// traceparent header is kept, so replayed records join their first trace.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/IBM/sarama"
+
+	"example.com/kafka-go-sarama-demo/internal/keys"
+	"example.com/kafka-go-sarama-demo/internal/logging"
+	"example.com/kafka-go-sarama-demo/internal/transform"
+	"example.com/kafka-go-sarama-demo/internal/validate"
+)
+
+// HeaderReplayedFrom is added to every replayed record: "<topic>/<partition>/<offset>".
+const HeaderReplayedFrom = "x-replayed-from"
+
+// bounds is the requested range; unset fields are -1 / zero.
+type bounds struct {
+	startOffset, endOffset int64
+	since, until           time.Time
+}
+
+type stats struct {
+	partition                 int32
+	start, end                int64
+	replayed, dropped, failed int
+}
+
+type replayer struct {
+	client     sarama.Client
+	consumer   sarama.Consumer
+	prod       sarama.SyncProducer // nil in dry-run mode
+	fn         transform.Func
+	from, to   string
+	keepPart   bool
+	skipErrors bool
+	idle       time.Duration
+	log        *slog.Logger
+}
+
+func main() {
+	logger := logging.New("replay")
+
+	brokers := flag.String("brokers", "localhost:9092", "comma-separated bootstrap brokers")
+	from := flag.String("from", "", "source topic (required)")
+	to := flag.String("to", "", "destination topic (required)")
+	parts := flag.String("partitions", "", "comma-separated source partitions (default all)")
+	startOffset := flag.Int64("start-offset", -1, "first offset to replay in each partition (default oldest)")
+	endOffset := flag.Int64("end-offset", -1, "stop before this offset in each partition")
+	since := flag.String("since", "", "RFC 3339 time; start at the first record at or after it")
+	until := flag.String("until", "", "RFC 3339 time; stop before the first record at or after it")
+	expr := flag.String("transform", "", `jq-like expression or "plugin:<file.so>" applied to each record`)
+	keepPart := flag.Bool("keep-partition", false, "write to the source partition number instead of partitioning by key")
+	onError := flag.String("on-error", "stop", "when the transform fails or a record fails validation: stop or skip")
+	idle := flag.Duration("idle-timeout", 10*time.Second, "give up on a partition after this long without records")
+	dryRun := flag.Bool("dry-run", false, "log what would be produced without producing")
+	flag.Parse()
+
+	b, err := parseBounds(*startOffset, *endOffset, *since, *until)
+	if err == nil && (*from == "" || *to == "") {
+		err = errors.New("-from and -to are required")
+	}
+	if err == nil && *onError != "stop" && *onError != "skip" {
+		err = fmt.Errorf("-on-error %q: want stop or skip", *onError)
+	}
+	if err != nil {
+		logging.Fatal(logger, "flags", err)
+	}
+	fn, err := transform.Load(*expr)
+	if err != nil {
+		logging.Fatal(logger, "transform", err)
+	}
+
+	cfg := sarama.NewConfig()
+	cfg.Version, _ = sarama.ParseKafkaVersion("3.8.0")
+	cfg.Consumer.Return.Errors = true
+	addrs := strings.Split(*brokers, ",")
+	client, err := sarama.NewClient(addrs, cfg)
+	if err != nil {
+		logging.Fatal(logger, "client", err)
+	}
+	defer client.Close()
+	consumer, err := sarama.NewConsumerFromClient(client)
+	if err != nil {
+		logging.Fatal(logger, "consumer", err)
+	}
+	defer consumer.Close()
+
+	r := &replayer{client: client, consumer: consumer, fn: fn, from: *from, to: *to,
+		keepPart: *keepPart, skipErrors: *onError == "skip", idle: *idle, log: logger}
+	if !*dryRun {
+		pcfg := sarama.NewConfig()
+		pcfg.Version = cfg.Version
+		pcfg.Producer.RequiredAcks = sarama.WaitForAll
+		pcfg.Producer.Idempotent = true
+		pcfg.Net.MaxOpenRequests = 1
+		pcfg.Producer.Return.Successes = true
+		// same placement as cmd/producer, so a key lands where it would have
+		pcfg.Producer.Partitioner = keys.NewPartitioner(keys.Murmur2, nil)
+		if *keepPart {
+			pcfg.Producer.Partitioner = sarama.NewManualPartitioner
+		}
+		raw, err := sarama.NewSyncProducer(addrs, pcfg)
+		if err != nil {
+			logging.Fatal(logger, "producer", err)
+		}
+		prod, vcfg, err := validate.FromEnv(raw)
+		if err != nil {
+			logging.Fatal(logger, "produce validation", err)
+		}
+		logger.Info("produce validation", vcfg.Describe()...)
+		r.prod = prod
+		defer r.prod.Close()
+	}
+
+	partitions, err := r.partitions(*parts)
+	if err != nil {
+		logging.Fatal(logger, "partitions", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	var wg sync.WaitGroup
+	results := make([]stats, len(partitions))
+	for i, p := range partitions {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			st, err := r.replayPartition(ctx, p, b)
+			results[i] = st
+			if err != nil {
+				cancel(fmt.Errorf("partition %d: %w", p, err))
+			}
+		}()
+	}
+	wg.Wait()
+
+	var replayed, dropped, failed int
+	for _, st := range results {
+		replayed, dropped, failed = replayed+st.replayed, dropped+st.dropped, failed+st.failed
+	}
+	logger.Info("replay finished", "from", r.from, "to", r.to, "dry_run", *dryRun,
+		"replayed", replayed, "dropped", dropped, "failed", failed)
+	if err := context.Cause(ctx); err != nil {
+		logging.Fatal(logger, "replay aborted", err)
+	}
+}
+
+func parseBounds(startOffset, endOffset int64, since, until string) (bounds, error) {
+	b := bounds{startOffset: startOffset, endOffset: endOffset}
+	var err error
+	if since != "" {
+		if startOffset >= 0 {
+			return b, errors.New("use -start-offset or -since, not both")
+		}
+		if b.since, err = time.Parse(time.RFC3339, since); err != nil {
+			return b, fmt.Errorf("-since: %w", err)
+		}
+	}
+	if until != "" {
+		if endOffset >= 0 {
+			return b, errors.New("use -end-offset or -until, not both")
+		}
+		if b.until, err = time.Parse(time.RFC3339, until); err != nil {
+			return b, fmt.Errorf("-until: %w", err)
+		}
+	}
+	if startOffset >= 0 && endOffset >= 0 && endOffset < startOffset {
+		return b, errors.New("-end-offset is before -start-offset")
+	}
+	if !b.since.IsZero() && !b.until.IsZero() && !b.until.After(b.since) {
+		return b, errors.New("-until must be after -since")
+	}
+	return b, nil
+}
+
+func (r *replayer) partitions(list string) ([]int32, error) {
+	all, err := r.client.Partitions(r.from)
+	if err != nil {
+		return nil, err
+	}
+	if list == "" {
+		return all, nil
+	}
+	exists := map[int32]bool{}
+	for _, p := range all {
+		exists[p] = true
+	}
+	var out []int32
+	for _, s := range strings.Split(list, ",") {
+		n, err := strconv.ParseInt(strings.TrimSpace(s), 10, 32)
+		if err != nil || !exists[int32(n)] {
+			return nil, fmt.Errorf("%s has no partition %q", r.from, s)
+		}
+		out = append(out, int32(n))
+	}
+	return out, nil
+}
+
+// offsets turns b into [start, end) for one partition, clamped to what the
+// partition still holds.
+func (r *replayer) offsets(p int32, b bounds) (start, end int64, err error) {
+	oldest, err := r.client.GetOffset(r.from, p, sarama.OffsetOldest)
+	if err != nil {
+		return 0, 0, err
+	}
+	newest, err := r.client.GetOffset(r.from, p, sarama.OffsetNewest)
+	if err != nil {
+		return 0, 0, err
+	}
+	start, end = oldest, newest
+	switch {
+	case !b.since.IsZero():
+		// the first offset whose timestamp is >= since, -1 if there is none
+		if start, err = r.client.GetOffset(r.from, p, b.since.UnixMilli()); err != nil {
+			return 0, 0, err
+		}
+		if start < 0 {
+			start = newest
+		}
+	case b.startOffset >= 0:
+		start = max(b.startOffset, oldest)
+	}
+	switch {
+	case !b.until.IsZero():
+		off, err := r.client.GetOffset(r.from, p, b.until.UnixMilli())
+		if err != nil {
+			return 0, 0, err
+		}
+		if off >= 0 {
+			end = off
+		}
+	case b.endOffset >= 0:
+		end = min(b.endOffset, newest)
+	}
+	return max(start, oldest), end, nil
+}
+
+func (r *replayer) replayPartition(ctx context.Context, p int32, b bounds) (stats, error) {
+	st := stats{partition: p}
+	var err error
+	if st.start, st.end, err = r.offsets(p, b); err != nil {
+		return st, err
+	}
+	l := r.log.With("topic", r.from, "partition", p, "start", st.start, "end", st.end)
+	defer func() {
+		l.Info("partition done", "replayed", st.replayed, "dropped", st.dropped, "failed", st.failed)
+	}()
+	if st.start >= st.end {
+		return st, nil
+	}
+
+	pc, err := r.consumer.ConsumePartition(r.from, p, st.start)
+	if err != nil {
+		return st, err
+	}
+	defer pc.Close()
+
+	idle := time.NewTimer(r.idle)
+	defer idle.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return st, nil
+		case err := <-pc.Errors():
+			return st, err
+		case <-idle.C:
+			// compaction or transaction markers can leave the last offsets empty
+			l.Warn("no records before end offset, giving up", "idle", r.idle.String())
+			return st, nil
+		case msg := <-pc.Messages():
+			idle.Reset(r.idle)
+			if msg.Offset >= st.end {
+				return st, nil
+			}
+			if err := r.replay(p, msg, &st, l); err != nil {
+				return st, err
+			}
+			if msg.Offset+1 >= st.end {
+				return st, nil
+			}
+		}
+	}
+}
+
+func (r *replayer) replay(p int32, msg *sarama.ConsumerMessage, st *stats, l *slog.Logger) error {
+	rec := &transform.Record{Key: msg.Key, Value: msg.Value, Timestamp: msg.Timestamp}
+	for _, h := range msg.Headers {
+		rec.Headers = append(rec.Headers, *h)
+	}
+	keep, err := r.fn(rec)
+	if err != nil {
+		if !r.skipErrors {
+			return fmt.Errorf("transform offset %d: %w", msg.Offset, err)
+		}
+		st.failed++
+		l.Warn("transform failed, skipping", "offset", msg.Offset, "error", err)
+		return nil
+	}
+	if !keep {
+		st.dropped++
+		return nil
+	}
+
+	out := &sarama.ProducerMessage{
+		Topic:     r.to,
+		Value:     sarama.ByteEncoder(rec.Value),
+		Timestamp: rec.Timestamp,
+		Headers: append(rec.Headers, sarama.RecordHeader{
+			Key:   []byte(HeaderReplayedFrom),
+			Value: []byte(fmt.Sprintf("%s/%d/%d", msg.Topic, p, msg.Offset)),
+		}),
+	}
+	if rec.Key != nil {
+		out.Key = sarama.ByteEncoder(rec.Key)
+	}
+	if r.keepPart {
+		out.Partition = p
+	}
+	// records from before producers set message-id/content-type
+	validate.Stamp(out, "application/octet-stream")
+	if r.prod == nil {
+		l.Info("dry run", "offset", msg.Offset, "key", string(rec.Key), "value", string(rec.Value), "to", r.to)
+		st.replayed++
+		return nil
+	}
+	if _, _, err := r.prod.SendMessage(out); err != nil {
+		var ve *validate.Error
+		if errors.As(err, &ve) && r.skipErrors {
+			st.failed++
+			l.Warn("record rejected, skipping", "offset", msg.Offset, "rule", ve.Rule, "error", err)
+			return nil
+		}
+		return fmt.Errorf("produce offset %d: %w", msg.Offset, err)
+	}
+	st.replayed++
+	return nil
+}
@@ -2,7 +2,8 @@ package main
 
 import (
 	"context"
-	"log"
+	"log/slog"
+	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
@@ -11,7 +12,13 @@ import (
 	"github.com/IBM/sarama"
 	"github.com/dnwe/otelsarama"
 
+	"example.com/kafka-go-sarama-demo/internal/group"
+	"example.com/kafka-go-sarama-demo/internal/kafka"
+	"example.com/kafka-go-sarama-demo/internal/logging"
+	"example.com/kafka-go-sarama-demo/internal/retry"
+	"example.com/kafka-go-sarama-demo/internal/schedule"
 	"example.com/kafka-go-sarama-demo/internal/tracing"
+	"example.com/kafka-go-sarama-demo/internal/validate"
 )
 
 var topicDelay = map[string]time.Duration{
@@ -20,41 +27,58 @@ var topicDelay = map[string]time.Duration{
 	"events.v1.retry.2m":  2 * time.Minute,
 }
 
-type handler struct{ prod sarama.SyncProducer }
+type handler struct {
+	prod  kafka.Producer
+	log   *slog.Logger
+	track *group.Tracker
+	sched *schedule.Metrics
+}
 
-func (h *handler) Setup(s sarama.ConsumerGroupSession) error   { return nil }
+func (h *handler) Setup(s sarama.ConsumerGroupSession) error   { h.track.Setup(s); return nil }
 func (h *handler) Cleanup(s sarama.ConsumerGroupSession) error { return nil }
 
 func (h *handler) ConsumeClaim(s sarama.ConsumerGroupSession, c sarama.ConsumerGroupClaim) error {
+	if l, ok := schedule.LevelFor(c.Topic()); ok {
+		return h.forwardScheduled(s, c, l)
+	}
 	delay := topicDelay[c.Topic()]
 	for msg := range c.Messages() {
+		start := time.Now()
+		ctx := tracing.ContextFromMessage(context.Background(), msg)
+		l := h.log.With(logging.Message(msg)...).With("attempt", retry.Attempt(msg))
 		time.Sleep(delay) // backoff window
 
 		out := &sarama.ProducerMessage{
 			Topic: "events.v1",
 			Key:   sarama.ByteEncoder(msg.Key),
 			Value: sarama.ByteEncoder(msg.Value),
-			Headers: msg.Headers, // keep headers (including x-retry-attempt & x-error)
 		}
+		for _, hdr := range msg.Headers {
+			out.Headers = append(out.Headers, *hdr) // keep headers (including x-retry-attempt & x-error)
+		}
+		validate.Stamp(out, "application/octet-stream")
 		if _, _, err := h.prod.SendMessage(out); err != nil {
 			// If we fail to requeue, we won't mark => message will be retried by this group
-			log.Printf("requeue failed: %v", err)
+			l.ErrorContext(ctx, "requeue failed", "error", err)
 			continue
 		}
+		l.InfoContext(ctx, "requeued", "to", "events.v1", "delay", delay.String(), "duration_ms", time.Since(start).Milliseconds())
 		s.MarkMessage(msg, "requeued")
 	}
 	return nil
 }
 
 func main() {
+	logger := logging.New("retryworker")
 	shutdown, err := tracing.Init("retryworker")
-	if err != nil { log.Fatalf("otel init: %v", err) }
+	if err != nil { logging.Fatal(logger, "otel init", err) }
 	defer shutdown(context.Background())
 
 	cfg := sarama.NewConfig()
 	cfg.Version, _ = sarama.ParseKafkaVersion("3.8.0")
-	cfg.Consumer.Group.Rebalance.Strategy = sarama.BalanceStrategyRange
 	cfg.Consumer.Offsets.Initial = sarama.OffsetOldest
+	if err := group.Configure(cfg); err != nil { logging.Fatal(logger, "consumer group config", err) }
+	logger.Info("consumer group", group.Describe(cfg)...)
 
 	pcfg := sarama.NewConfig()
 	pcfg.Version = cfg.Version
@@ -64,21 +88,42 @@ func main() {
 	pcfg.Producer.Return.Successes = true
 
 	rawProd, err := sarama.NewSyncProducer([]string{"localhost:9092"}, pcfg)
-	if err != nil { log.Fatalf("producer: %v", err) }
-	prod := otelsarama.WrapSyncProducer(pcfg, rawProd)
+	if err != nil { logging.Fatal(logger, "producer", err) }
+	prod, vcfg, err := validate.FromEnv(otelsarama.WrapSyncProducer(pcfg, rawProd))
+	if err != nil { logging.Fatal(logger, "produce validation", err) }
+	logger.Info("produce validation", vcfg.Describe()...)
 	defer prod.Close()
 
 	cg, err := sarama.NewConsumerGroup([]string{"localhost:9092"}, "retryworker.v1", cfg)
-	if err != nil { log.Fatalf("consumer group: %v", err) }
+	if err != nil { logging.Fatal(logger, "consumer group", err) }
 	defer cg.Close()
 
 	topics := []string{"events.v1.retry.5s", "events.v1.retry.30s", "events.v1.retry.2m"}
-	h := otelsarama.WrapConsumerGroupHandler(&handler{prod: prod})
+	// the delay topics of schedule.Producer use the same requeue loop
+	for _, l := range schedule.Levels {
+		topics = append(topics, l.Topic)
+	}
+	sched := schedule.NewMetrics()
+	h := otelsarama.WrapConsumerGroupHandler(&handler{prod: prod, log: logger, track: group.NewTracker(logger), sched: sched})
+
+	if addr := os.Getenv("METRICS_ADDR"); addr != "-" {
+		if addr == "" {
+			addr = ":9309"
+		}
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", sched)
+		go func() {
+			logger.Info("metrics listening", "addr", addr)
+			if err := http.ListenAndServe(addr, mux); err != nil {
+				logger.Error("metrics server", "error", err)
+			}
+		}()
+	}
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	go func() { for err := range cg.Errors() { log.Printf("cg error: %v", err) } }()
+	go func() { for err := range cg.Errors() { logger.Error("cg error", "error", err) } }()
 	go func() {
 		sig := make(chan os.Signal, 1)
 		signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
@@ -87,7 +132,7 @@ func main() {
 
 	for ctx.Err() == nil {
 		if err := cg.Consume(ctx, topics, h); err != nil {
-			log.Printf("consume: %v", err)
+			logger.Error("consume", "error", err)
 			time.Sleep(time.Second)
 		}
 	}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/IBM/sarama"
+
+	"example.com/kafka-go-sarama-demo/internal/kafka"
+	"example.com/kafka-go-sarama-demo/internal/logging"
+	"example.com/kafka-go-sarama-demo/internal/schedule"
+	"example.com/kafka-go-sarama-demo/internal/tracing"
+	"example.com/kafka-go-sarama-demo/internal/validate"
+)
+
+// forwardScheduled moves the records of one delay-topic partition on once
+// their level's delay has passed; see internal/schedule. The wait is cut
+// short by a rebalance, which leaves the record unmarked for whoever gets
+// the partition next.
+func (h *handler) forwardScheduled(s kafka.Session, c sarama.ConsumerGroupClaim, l schedule.Level) error {
+	for msg := range c.Messages() {
+		ctx := tracing.ContextFromMessage(context.Background(), msg)
+		lg := h.log.With(logging.Message(msg)...)
+		sc, err := schedule.Parse(msg)
+		if err != nil {
+			h.sched.Invalid()
+			lg.ErrorContext(ctx, "skipping unschedulable record", "error", err)
+			s.MarkMessage(msg, "invalid")
+			continue
+		}
+		// only the head waits: everything behind it in this topic is due later
+		if !sleepUntil(s.Context(), sc.WaitUntil(msg, l)) {
+			return nil
+		}
+
+		now := time.Now()
+		out, due := sc.Next(msg, now)
+		for {
+			_, _, err := h.prod.SendMessage(out)
+			if err == nil {
+				break
+			}
+			h.sched.Failed(l.Topic)
+			var ve *validate.Error
+			if errors.As(err, &ve) {
+				// will never pass; marked so it does not hold up the partition
+				lg.ErrorContext(ctx, "scheduled record rejected, dropped", "to", out.Topic, "rule", ve.Rule, "error", err)
+				break
+			}
+			lg.ErrorContext(ctx, "schedule forward failed", "to", out.Topic, "error", err)
+			if !sleepUntil(s.Context(), time.Now().Add(time.Second)) {
+				return nil
+			}
+		}
+		if due {
+			late := time.Since(sc.DeliverAt)
+			h.sched.Delivered(out.Topic, late, sc.Hops)
+			lg.InfoContext(ctx, "delivered", "to", out.Topic, "hops", sc.Hops, "late_ms", late.Milliseconds())
+		} else {
+			h.sched.Hopped(out.Topic)
+			lg.InfoContext(ctx, "rescheduled", "to", out.Topic, "due_in", sc.DeliverAt.Sub(now).Round(time.Millisecond).String())
+		}
+		s.MarkMessage(msg, "scheduled")
+	}
+	return nil
+}
+
+// sleepUntil waits for t and reports false if ctx ended first.
+func sleepUntil(ctx context.Context, t time.Time) bool {
+	timer := time.NewTimer(time.Until(t))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,165 @@
+// Package filter selects the records a processor handles by their headers,
+// so several specialized processors can share one topic, each in its own
+// consumer group, and skip what is not theirs without failing it:
+//
+//	x-event-type in (order.created,order.paid)
+//	x-event-type in (order.created,order.paid); x-tenant != test
+//
+// A filter is a list of conditions separated by ";", all of which must
+// hold. A condition compares the first header with the key:
+//
+//	key = v            the header is v
+//	key != v           the header is missing or not v
+//	key in (a,b,...)   the header is one of the values
+//	key not in (a,...) the header is missing or none of the values
+//
+// Keys and values are matched exactly; spaces around them are ignored.
+// A skipped record is still marked, so it is committed like a processed
+// one and never reaches the retry topics.
+//
+// Environment:
+//
+//	CONSUME_FILTER  the filter; empty handles every record
+package filter
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/IBM/sarama"
+)
+
+type op int
+
+const (
+	opIn op = iota
+	opNotIn
+)
+
+type condition struct {
+	key    string
+	op     op
+	values []string
+	src    string // as written, normalized, for Skip reasons
+}
+
+// Filter is a parsed filter. The zero value and nil match every record.
+type Filter struct {
+	conds []condition
+}
+
+// Parse reads a filter in the syntax of the package comment.
+func Parse(s string) (*Filter, error) {
+	f := &Filter{}
+	for _, part := range strings.Split(s, ";") {
+		if strings.TrimSpace(part) == "" {
+			continue
+		}
+		c, err := parseCondition(part)
+		if err != nil {
+			return nil, fmt.Errorf("filter %q: %w", strings.TrimSpace(part), err)
+		}
+		f.conds = append(f.conds, c)
+	}
+	return f, nil
+}
+
+func parseCondition(s string) (condition, error) {
+	// "!=" before "=", "not in" before "in"
+	if k, v, ok := strings.Cut(s, "!="); ok {
+		return newCondition(k, opNotIn, []string{v}, "!=")
+	}
+	if k, v, ok := strings.Cut(s, "="); ok {
+		return newCondition(k, opIn, []string{v}, "=")
+	}
+	fields := strings.Fields(s)
+	if len(fields) < 3 {
+		return condition{}, errors.New("want key = v, key != v, key in (...) or key not in (...)")
+	}
+	key, rest, o, word := fields[0], "", opIn, "in"
+	switch {
+	case fields[1] == "in":
+		_, rest, _ = strings.Cut(s, " in ")
+	case fields[1] == "not" && fields[2] == "in":
+		_, rest, _ = strings.Cut(s, " not in ")
+		o, word = opNotIn, "not in"
+	default:
+		return condition{}, fmt.Errorf("unknown operator %q", fields[1])
+	}
+	rest = strings.TrimSpace(rest)
+	if !strings.HasPrefix(rest, "(") || !strings.HasSuffix(rest, ")") {
+		return condition{}, fmt.Errorf("want a list in parentheses after %q", word)
+	}
+	return newCondition(key, o, strings.Split(rest[1:len(rest)-1], ","), word)
+}
+
+func newCondition(key string, o op, values []string, word string) (condition, error) {
+	key = strings.TrimSpace(key)
+	if key == "" {
+		return condition{}, errors.New("empty header key")
+	}
+	for i, v := range values {
+		if values[i] = strings.TrimSpace(v); values[i] == "" {
+			return condition{}, errors.New("empty value")
+		}
+	}
+	src := key + " " + word + " " + values[0]
+	if word == "in" || word == "not in" {
+		src = key + " " + word + " (" + strings.Join(values, ",") + ")"
+	}
+	return condition{key: key, op: o, values: values, src: src}, nil
+}
+
+// FromEnv parses CONSUME_FILTER. It returns nil when the variable is empty.
+func FromEnv() (*Filter, error) {
+	v := os.Getenv("CONSUME_FILTER")
+	if strings.TrimSpace(v) == "" {
+		return nil, nil
+	}
+	f, err := Parse(v)
+	if err != nil {
+		return nil, fmt.Errorf("CONSUME_FILTER: %w", err)
+	}
+	return f, nil
+}
+
+// Match reports whether a record with headers passes every condition.
+// When it does not, reason is the first condition that failed.
+func (f *Filter) Match(headers []*sarama.RecordHeader) (ok bool, reason string) {
+	if f == nil {
+		return true, ""
+	}
+	for _, c := range f.conds {
+		v, found := header(headers, c.key)
+		in := found && slices.Contains(c.values, v)
+		if in != (c.op == opIn) {
+			return false, c.src
+		}
+	}
+	return true, ""
+}
+
+// String is the filter in normalized form, "" for one that matches
+// everything.
+func (f *Filter) String() string {
+	if f == nil {
+		return ""
+	}
+	srcs := make([]string, len(f.conds))
+	for i, c := range f.conds {
+		srcs[i] = c.src
+	}
+	return strings.Join(srcs, "; ")
+}
+
+func header(headers []*sarama.RecordHeader, key string) (string, bool) {
+	for _, h := range headers {
+		if h != nil && string(h.Key) == key {
+			return string(h.Value), true
+		}
+	}
+	return "", false
+}
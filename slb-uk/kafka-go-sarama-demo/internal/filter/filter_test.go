@@ -0,0 +1,120 @@
+package filter
+
+import (
+	"testing"
+
+	"github.com/IBM/sarama"
+)
+
+func headers(kv ...string) []*sarama.RecordHeader {
+	var hs []*sarama.RecordHeader
+	for i := 0; i+1 < len(kv); i += 2 {
+		hs = append(hs, &sarama.RecordHeader{Key: []byte(kv[i]), Value: []byte(kv[i+1])})
+	}
+	return hs
+}
+
+func TestMatch(t *testing.T) {
+	f, err := Parse(" x-event-type in ( order.created, order.paid ) ; x-tenant != test ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		headers []*sarama.RecordHeader
+		ok      bool
+		reason  string
+	}{
+		{headers("x-event-type", "order.created"), true, ""},
+		{headers("x-event-type", "order.paid", "x-tenant", "acme"), true, ""},
+		{headers("x-event-type", "order.shipped"), false, "x-event-type in (order.created,order.paid)"},
+		{headers("x-tenant", "acme"), false, "x-event-type in (order.created,order.paid)"},
+		{headers("x-event-type", "order.paid", "x-tenant", "test"), false, "x-tenant != test"},
+		// values are exact: no case folding, no prefixes
+		{headers("x-event-type", "Order.Created"), false, "x-event-type in (order.created,order.paid)"},
+	} {
+		ok, reason := f.Match(tc.headers)
+		if ok != tc.ok || reason != tc.reason {
+			t.Errorf("Match(%s) = %v, %q; want %v, %q", describe(tc.headers), ok, reason, tc.ok, tc.reason)
+		}
+	}
+}
+
+func TestOperators(t *testing.T) {
+	for _, tc := range []struct {
+		filter  string
+		headers []*sarama.RecordHeader
+		ok      bool
+	}{
+		{"k=v", headers("k", "v"), true},
+		{"k=v", headers("k", "w"), false},
+		{"k=v", nil, false},
+		{"k!=v", nil, true},
+		{"k not in (a,b)", headers("k", "c"), true},
+		{"k not in (a,b)", headers("k", "b"), false},
+		{"k not in (a,b)", nil, true},
+		// only the first header with the key counts
+		{"k=v", headers("k", "w", "k", "v"), false},
+	} {
+		f, err := Parse(tc.filter)
+		if err != nil {
+			t.Fatalf("%q: %v", tc.filter, err)
+		}
+		if ok, _ := f.Match(tc.headers); ok != tc.ok {
+			t.Errorf("%q on %s: got %v", tc.filter, describe(tc.headers), ok)
+		}
+	}
+}
+
+func TestEmptyMatchesEverything(t *testing.T) {
+	var nilFilter *Filter
+	for _, f := range []*Filter{nilFilter, {}} {
+		if ok, _ := f.Match(headers("x", "y")); !ok {
+			t.Fatalf("%#v rejected a record", f)
+		}
+	}
+	f, err := Parse(" ; ")
+	if err != nil || f.String() != "" {
+		t.Fatalf("Parse(\" ; \") = %q, %v", f, err)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, s := range []string{
+		"x-event-type",
+		"x-event-type in order.created",
+		"x-event-type in (a,,b)",
+		"x-event-type like (a)",
+		"=v",
+		"k=",
+	} {
+		if _, err := Parse(s); err == nil {
+			t.Errorf("Parse(%q) succeeded", s)
+		}
+	}
+}
+
+func TestFromEnv(t *testing.T) {
+	t.Setenv("CONSUME_FILTER", "")
+	if f, err := FromEnv(); f != nil || err != nil {
+		t.Fatalf("empty: %v, %v", f, err)
+	}
+	t.Setenv("CONSUME_FILTER", "x-event-type in (a,b)")
+	if f, err := FromEnv(); err != nil || f.String() != "x-event-type in (a,b)" {
+		t.Fatalf("got %q, %v", f, err)
+	}
+	t.Setenv("CONSUME_FILTER", "x-event-type ~ a")
+	if _, err := FromEnv(); err == nil {
+		t.Fatal("want an error")
+	}
+}
+
+func describe(hs []*sarama.RecordHeader) string {
+	s := "["
+	for i, h := range hs {
+		if i > 0 {
+			s += " "
+		}
+		s += string(h.Key) + "=" + string(h.Value)
+	}
+	return s + "]"
+}
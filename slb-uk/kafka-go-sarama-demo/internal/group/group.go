@@ -0,0 +1,151 @@
+// Package group configures consumer-group membership for the consumer
+// binaries and logs how assignments change between generations.
+//
+// Environment:
+//
+//	GROUP_STRATEGY         range (default), roundrobin, sticky; a comma list
+//	                       gives a preference order, e.g. "sticky,range" while
+//	                       rolling a new strategy out
+//	GROUP_INSTANCE_ID      static membership (group.instance.id), e.g. the pod
+//	                       name; $VARS are expanded
+//	GROUP_SESSION_TIMEOUT  how long the broker waits for a member before
+//	                       rebalancing (default 10s, 45s with static membership)
+//
+// Sarama implements only the eager rebalance protocol: every member stops
+// for every rebalance. "cooperative-sticky" is rejected rather than silently
+// downgraded; sticky keeps partitions where they were, and static membership
+// avoids the rebalance altogether when a member restarts within the session
+// timeout.
+package group
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+// Strategy returns the balance strategy for name.
+func Strategy(name string) (sarama.BalanceStrategy, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "", "range":
+		return sarama.NewBalanceStrategyRange(), nil
+	case "roundrobin", "round-robin":
+		return sarama.NewBalanceStrategyRoundRobin(), nil
+	case "sticky":
+		return sarama.NewBalanceStrategySticky(), nil
+	case "cooperative-sticky", "cooperative":
+		return nil, fmt.Errorf("group strategy %q: sarama has no incremental cooperative rebalancing; use sticky, with GROUP_INSTANCE_ID for restarts", name)
+	default:
+		return nil, fmt.Errorf("unknown group strategy %q (want range, roundrobin or sticky)", name)
+	}
+}
+
+// Configure applies GROUP_STRATEGY, GROUP_INSTANCE_ID and
+// GROUP_SESSION_TIMEOUT to cfg. cfg.Version must already be set.
+func Configure(cfg *sarama.Config) error {
+	var strategies []sarama.BalanceStrategy
+	for _, name := range strings.Split(os.Getenv("GROUP_STRATEGY"), ",") {
+		s, err := Strategy(name)
+		if err != nil {
+			return err
+		}
+		strategies = append(strategies, s)
+	}
+	cfg.Consumer.Group.Rebalance.GroupStrategies = strategies
+
+	if id := os.ExpandEnv(os.Getenv("GROUP_INSTANCE_ID")); id != "" {
+		if !cfg.Version.IsAtLeast(sarama.V2_3_0_0) {
+			return fmt.Errorf("GROUP_INSTANCE_ID needs Kafka version >= 2.3, configured %s", cfg.Version)
+		}
+		cfg.Consumer.Group.InstanceId = id
+		cfg.Consumer.Group.Session.Timeout = 45 * time.Second
+	}
+	if v := os.Getenv("GROUP_SESSION_TIMEOUT"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("GROUP_SESSION_TIMEOUT: %w", err)
+		}
+		cfg.Consumer.Group.Session.Timeout = d
+	}
+	// The heartbeat must stay well inside the session timeout.
+	if hb := cfg.Consumer.Group.Session.Timeout / 3; cfg.Consumer.Group.Heartbeat.Interval > hb {
+		cfg.Consumer.Group.Heartbeat.Interval = hb
+	}
+	return nil
+}
+
+// Describe returns the attributes to log once at startup.
+func Describe(cfg *sarama.Config) []any {
+	names := make([]string, 0, len(cfg.Consumer.Group.Rebalance.GroupStrategies))
+	for _, s := range cfg.Consumer.Group.Rebalance.GroupStrategies {
+		names = append(names, s.Name())
+	}
+	return []any{
+		"strategies", names,
+		"instance_id", cfg.Consumer.Group.InstanceId,
+		"session_timeout", cfg.Consumer.Group.Session.Timeout.String(),
+	}
+}
+
+// Tracker logs what each rebalance changed for this member: partitions
+// gained, lost and kept. Call Setup from the handler's Setup.
+type Tracker struct {
+	log *slog.Logger
+
+	mu   sync.Mutex
+	prev map[string][]int32
+}
+
+func NewTracker(l *slog.Logger) *Tracker { return &Tracker{log: l} }
+
+func (t *Tracker) Setup(s sarama.ConsumerGroupSession) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	next := s.Claims()
+	gained, lost, kept := Diff(t.prev, next)
+	t.log.Info("assignment",
+		"member_id", s.MemberID(),
+		"generation", s.GenerationID(),
+		"gained", gained,
+		"lost", lost,
+		"kept", kept,
+	)
+	t.prev = next
+}
+
+// Diff compares two assignments of one member. gained and lost are
+// "topic/partition" strings in order; kept is a count.
+func Diff(prev, next map[string][]int32) (gained, lost []string, kept int) {
+	before, after := flatten(prev), flatten(next)
+	for tp := range after {
+		if before[tp] {
+			kept++
+		} else {
+			gained = append(gained, tp)
+		}
+	}
+	for tp := range before {
+		if !after[tp] {
+			lost = append(lost, tp)
+		}
+	}
+	sort.Strings(gained)
+	sort.Strings(lost)
+	return gained, lost, kept
+}
+
+func flatten(a map[string][]int32) map[string]bool {
+	out := map[string]bool{}
+	for topic, parts := range a {
+		for _, p := range parts {
+			out[fmt.Sprintf("%s/%d", topic, p)] = true
+		}
+	}
+	return out
+}
@@ -0,0 +1,203 @@
+package group
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+const topic = "events.v1"
+
+// cluster replays rebalances through a strategy's Plan, the same call the
+// group leader makes, feeding each member's previous assignment back in as
+// its user data the way sarama's consumer group does.
+type cluster struct {
+	t        *testing.T
+	strategy sarama.BalanceStrategy
+	parts    []int32
+	gen      int32
+	assigned map[string]map[string][]int32 // member -> topic -> partitions
+	owner    map[int32]string
+	moves    int
+	rounds   int
+}
+
+func newCluster(t *testing.T, s sarama.BalanceStrategy, partitions int) *cluster {
+	c := &cluster{t: t, strategy: s, assigned: map[string]map[string][]int32{}, owner: map[int32]string{}}
+	for p := 0; p < partitions; p++ {
+		c.parts = append(c.parts, int32(p))
+	}
+	return c
+}
+
+func (c *cluster) rebalance(members []string) {
+	c.t.Helper()
+	meta := map[string]sarama.ConsumerGroupMemberMetadata{}
+	for _, id := range members {
+		ud, err := c.strategy.AssignmentData(id, c.assigned[id], c.gen)
+		if err != nil {
+			c.t.Fatal(err)
+		}
+		meta[id] = sarama.ConsumerGroupMemberMetadata{Version: 1, Topics: []string{topic}, UserData: ud}
+	}
+	plan, err := c.strategy.Plan(meta, map[string][]int32{topic: c.parts})
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	c.gen++
+	c.rounds++
+	c.assigned = map[string]map[string][]int32{}
+	for id, topics := range plan {
+		c.assigned[id] = topics
+		for _, p := range topics[topic] {
+			if prev, ok := c.owner[p]; ok && prev != id {
+				c.moves++
+			}
+			c.owner[p] = id
+		}
+	}
+	if len(c.owner) != len(c.parts) {
+		c.t.Fatalf("%d of %d partitions assigned", len(c.owner), len(c.parts))
+	}
+}
+
+// rollingRestart restarts every member in turn. A dynamic member leaves
+// (rebalance), then rejoins under a new member id (rebalance). A static
+// member that is back within the session timeout keeps its assignment and
+// the broker does not rebalance at all (KIP-345), so nothing is replayed.
+func rollingRestart(c *cluster, members []string, static bool) {
+	for i := range members {
+		if static {
+			continue
+		}
+		rest := append(append([]string{}, members[:i]...), members[i+1:]...)
+		c.rebalance(rest)
+		members[i] = fmt.Sprintf("%s-r%d", members[i], c.gen)
+		c.rebalance(members)
+	}
+}
+
+func TestRollingRestartMovement(t *testing.T) {
+	run := func(s sarama.BalanceStrategy, static bool) (moves, rounds int) {
+		c := newCluster(t, s, 12)
+		members := []string{"processor-0", "processor-1", "processor-2", "processor-3"}
+		c.rebalance(members)
+		c.moves, c.rounds = 0, 0
+		rollingRestart(c, members, static)
+		return c.moves, c.rounds
+	}
+
+	rangeMoves, _ := run(sarama.NewBalanceStrategyRange(), false)
+	rrMoves, _ := run(sarama.NewBalanceStrategyRoundRobin(), false)
+	stickyMoves, stickyRounds := run(sarama.NewBalanceStrategySticky(), false)
+	staticMoves, staticRounds := run(sarama.NewBalanceStrategySticky(), true)
+	t.Logf("partition moves over a rolling restart of 4 members / 12 partitions: range=%d roundrobin=%d sticky=%d sticky+static=%d",
+		rangeMoves, rrMoves, stickyMoves, staticMoves)
+
+	// Sticky only moves what it must: the leaving member's 3 partitions
+	// away, then 3 back to the newcomer.
+	if want := 4 * (3 + 3); stickyMoves != want {
+		t.Errorf("sticky moved %d partitions, want %d", stickyMoves, want)
+	}
+	if stickyRounds != 8 {
+		t.Errorf("sticky rebalanced %d times, want 8", stickyRounds)
+	}
+	if rangeMoves <= stickyMoves || rrMoves <= stickyMoves {
+		t.Errorf("range (%d) and roundrobin (%d) should move more than sticky (%d)", rangeMoves, rrMoves, stickyMoves)
+	}
+	if staticMoves != 0 || staticRounds != 0 {
+		t.Errorf("static membership: %d moves in %d rebalances, want none", staticMoves, staticRounds)
+	}
+}
+
+func TestStickyKeepsSurvivorsInPlace(t *testing.T) {
+	c := newCluster(t, sarama.NewBalanceStrategySticky(), 12)
+	c.rebalance([]string{"a", "b", "c", "d"})
+	before := map[string][]int32{}
+	for id, topics := range c.assigned {
+		before[id] = topics[topic]
+	}
+	c.rebalance([]string{"a", "b", "c"})
+	for _, id := range []string{"a", "b", "c"} {
+		_, lost, kept := Diff(map[string][]int32{topic: before[id]}, c.assigned[id])
+		if len(lost) != 0 || kept != len(before[id]) {
+			t.Errorf("%s lost %v after d left", id, lost)
+		}
+	}
+}
+
+func TestDiff(t *testing.T) {
+	gained, lost, kept := Diff(
+		map[string][]int32{"a": {0, 1, 2}},
+		map[string][]int32{"a": {1, 2, 3}, "b": {0}},
+	)
+	if fmt.Sprint(gained) != "[a/3 b/0]" || fmt.Sprint(lost) != "[a/0]" || kept != 2 {
+		t.Fatalf("gained=%v lost=%v kept=%d", gained, lost, kept)
+	}
+}
+
+func TestConfigure(t *testing.T) {
+	newCfg := func() *sarama.Config {
+		cfg := sarama.NewConfig()
+		cfg.Version = sarama.V3_8_0_0
+		return cfg
+	}
+
+	t.Run("defaults to range, dynamic", func(t *testing.T) {
+		t.Setenv("GROUP_STRATEGY", "")
+		t.Setenv("GROUP_INSTANCE_ID", "")
+		cfg := newCfg()
+		if err := Configure(cfg); err != nil {
+			t.Fatal(err)
+		}
+		if got := cfg.Consumer.Group.Rebalance.GroupStrategies; len(got) != 1 || got[0].Name() != "range" {
+			t.Fatalf("strategies = %v", got)
+		}
+		if cfg.Consumer.Group.InstanceId != "" || cfg.Consumer.Group.Session.Timeout != 10*time.Second {
+			t.Fatalf("instance=%q session=%s", cfg.Consumer.Group.InstanceId, cfg.Consumer.Group.Session.Timeout)
+		}
+		if err := cfg.Validate(); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("sticky with static membership", func(t *testing.T) {
+		t.Setenv("GROUP_STRATEGY", "sticky,range")
+		t.Setenv("POD_NAME", "processor-2")
+		t.Setenv("GROUP_INSTANCE_ID", "$POD_NAME")
+		cfg := newCfg()
+		if err := Configure(cfg); err != nil {
+			t.Fatal(err)
+		}
+		got := cfg.Consumer.Group.Rebalance.GroupStrategies
+		if len(got) != 2 || got[0].Name() != "sticky" || got[1].Name() != "range" {
+			t.Fatalf("strategies = %v", got)
+		}
+		if cfg.Consumer.Group.InstanceId != "processor-2" || cfg.Consumer.Group.Session.Timeout != 45*time.Second {
+			t.Fatalf("instance=%q session=%s", cfg.Consumer.Group.InstanceId, cfg.Consumer.Group.Session.Timeout)
+		}
+		if err := cfg.Validate(); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("rejects", func(t *testing.T) {
+		for _, tc := range []struct{ strategy, instance, version string }{
+			{strategy: "cooperative-sticky"},
+			{strategy: "fancy"},
+			{instance: "p-0", version: "2.1.0"},
+		} {
+			t.Setenv("GROUP_STRATEGY", tc.strategy)
+			t.Setenv("GROUP_INSTANCE_ID", tc.instance)
+			cfg := newCfg()
+			if tc.version != "" {
+				cfg.Version, _ = sarama.ParseKafkaVersion(tc.version)
+			}
+			if err := Configure(cfg); err == nil {
+				t.Errorf("%+v: expected an error", tc)
+			}
+		}
+	})
+}
@@ -0,0 +1,32 @@
+// Package kafka is the part of sarama the handlers use, as interfaces:
+// sarama.SyncProducer is a Producer and sarama.ConsumerGroupSession a
+// Session. Handlers that take these run without a broker in tests, against
+// the channel-backed fakes of kafkatest or the gomock mocks of
+// kafka/mocks.
+package kafka
+
+//go:generate mockgen -source=kafka.go -destination=./mocks/kafka_mocks.go -package=mocks
+
+import (
+	"context"
+
+	"github.com/IBM/sarama"
+)
+
+// Producer sends one record and waits for the broker's answer.
+type Producer interface {
+	SendMessage(msg *sarama.ProducerMessage) (partition int32, offset int64, err error)
+}
+
+// Session is a consumer-group generation as a claim handler sees it.
+type Session interface {
+	// MarkMessage marks msg consumed; the offset is committed later.
+	MarkMessage(msg *sarama.ConsumerMessage, metadata string)
+	// Context ends when the generation does, on a rebalance or shutdown.
+	Context() context.Context
+}
+
+var (
+	_ Producer = sarama.SyncProducer(nil)
+	_ Session  = sarama.ConsumerGroupSession(nil)
+)
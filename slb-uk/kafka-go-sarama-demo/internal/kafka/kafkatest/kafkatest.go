@@ -0,0 +1,75 @@
+// Package kafkatest has channel-backed fakes of the kafka interfaces. A
+// test reads what the code under test produced or marked from the
+// channels, in order, instead of from a broker.
+package kafkatest
+
+import (
+	"context"
+	"sync"
+
+	"github.com/IBM/sarama"
+)
+
+// Producer is a fake kafka.Producer. Every record sent goes to Sent,
+// which must have room for it: SendMessage blocks on a full channel.
+type Producer struct {
+	Sent chan *sarama.ProducerMessage
+
+	mu     sync.Mutex
+	err    error
+	offset int64
+}
+
+// NewProducer returns a Producer whose Sent holds up to n records.
+func NewProducer(n int) *Producer {
+	return &Producer{Sent: make(chan *sarama.ProducerMessage, n)}
+}
+
+// Fail makes the following sends fail with err, or succeed again when err
+// is nil. Failed records do not reach Sent.
+func (p *Producer) Fail(err error) {
+	p.mu.Lock()
+	p.err = err
+	p.mu.Unlock()
+}
+
+// SendMessage answers partition 0 and offsets counting up from 0.
+func (p *Producer) SendMessage(msg *sarama.ProducerMessage) (int32, int64, error) {
+	p.mu.Lock()
+	err := p.err
+	offset := p.offset
+	if err == nil {
+		p.offset++
+	}
+	p.mu.Unlock()
+	if err != nil {
+		return -1, -1, err
+	}
+	msg.Partition, msg.Offset = 0, offset
+	p.Sent <- msg
+	return 0, offset, nil
+}
+
+// Mark is one MarkMessage call.
+type Mark struct {
+	Msg      *sarama.ConsumerMessage
+	Metadata string
+}
+
+// Session is a fake kafka.Session. Marks go to Marked, which must have room
+// for them; cancel ctx to end the generation.
+type Session struct {
+	Marked chan Mark
+	ctx    context.Context
+}
+
+// NewSession returns a Session of ctx whose Marked holds up to n marks.
+func NewSession(ctx context.Context, n int) *Session {
+	return &Session{Marked: make(chan Mark, n), ctx: ctx}
+}
+
+func (s *Session) MarkMessage(msg *sarama.ConsumerMessage, metadata string) {
+	s.Marked <- Mark{Msg: msg, Metadata: metadata}
+}
+
+func (s *Session) Context() context.Context { return s.ctx }
@@ -0,0 +1,162 @@
+// Package keys decides which partition a record goes to.
+//
+// Kafka only orders records within a partition, so every record of one
+// entity (user, order, ...) must land on the same partition. Three
+// strategies are provided:
+//
+//   - Murmur2: identical to the Java client's default partitioner, so Go and
+//     JVM producers agree on placement for the same key.
+//   - Jump: consistent hashing by entity ID. When the partition count grows
+//     from n to m, only (m-n)/m of the entities move, and only onto the new
+//     partitions; with Murmur2 (hash mod n) most entities move.
+//   - keyless records are spread round-robin by both.
+//
+// NewPartitioner wraps a strategy as a sarama.PartitionerConstructor.
+package keys
+
+import (
+	"encoding/binary"
+	"sync/atomic"
+
+	"github.com/IBM/sarama"
+)
+
+// Strategy maps a non-empty key to a partition in [0, n).
+type Strategy func(key []byte, n int32) int32
+
+// Murmur2 partitions like Kafka's Java DefaultPartitioner:
+// toPositive(murmur2(key)) % n.
+func Murmur2(key []byte, n int32) int32 {
+	return int32(uint32(Murmur2Hash(key))&0x7fffffff) % n
+}
+
+// Jump partitions with Lamping & Veach's jump consistent hash over a 64-bit
+// FNV-1a hash of the key.
+func Jump(key []byte, n int32) int32 {
+	return JumpHash(fnv64a(key), n)
+}
+
+// Murmur2Hash is Kafka's murmur2 (seed 0x9747b28c), returned as the signed
+// value the Java client computes.
+func Murmur2Hash(data []byte) int32 {
+	const (
+		seed uint32 = 0x9747b28c
+		m    uint32 = 0x5bd1e995
+		r           = 24
+	)
+	length := len(data)
+	h := seed ^ uint32(length)
+	for i := 0; i+4 <= length; i += 4 {
+		k := binary.LittleEndian.Uint32(data[i:])
+		k *= m
+		k ^= k >> r
+		k *= m
+		h *= m
+		h ^= k
+	}
+	tail := data[length&^3:]
+	switch len(tail) {
+	case 3:
+		h ^= uint32(tail[2]) << 16
+		fallthrough
+	case 2:
+		h ^= uint32(tail[1]) << 8
+		fallthrough
+	case 1:
+		h ^= uint32(tail[0])
+		h *= m
+	}
+	h ^= h >> 13
+	h *= m
+	h ^= h >> 15
+	return int32(h)
+}
+
+// JumpHash maps key to a bucket in [0, n).
+func JumpHash(key uint64, n int32) int32 {
+	var b, j int64 = -1, 0
+	for j < int64(n) {
+		b = j
+		key = key*2862933555777941757 + 1
+		j = int64(float64(b+1) * (float64(int64(1)<<31) / float64((key>>33)+1)))
+	}
+	return int32(b)
+}
+
+func fnv64a(data []byte) uint64 {
+	h := uint64(14695981039346656037)
+	for _, c := range data {
+		h ^= uint64(c)
+		h *= 1099511628211
+	}
+	return h
+}
+
+// EntityFunc extracts the entity ID records are ordered by. By default it is
+// the record key; HeaderEntity reads it from a header instead.
+type EntityFunc func(msg *sarama.ProducerMessage) []byte
+
+// KeyEntity uses the record key.
+func KeyEntity(msg *sarama.ProducerMessage) []byte {
+	if msg.Key == nil {
+		return nil
+	}
+	b, err := msg.Key.Encode()
+	if err != nil {
+		return nil
+	}
+	return b
+}
+
+// HeaderEntity uses the value of the named header, falling back to the key.
+func HeaderEntity(name string) EntityFunc {
+	return func(msg *sarama.ProducerMessage) []byte {
+		for _, h := range msg.Headers {
+			if string(h.Key) == name && len(h.Value) > 0 {
+				return h.Value
+			}
+		}
+		return KeyEntity(msg)
+	}
+}
+
+type partitioner struct {
+	strategy Strategy
+	entity   EntityFunc
+	next     atomic.Uint32
+}
+
+// NewPartitioner returns a constructor for sarama's Producer.Partitioner.
+// Records with an entity ID are placed by strategy; records without one go
+// round-robin. entity may be nil to use the key.
+//
+//	cfg.Producer.Partitioner = keys.NewPartitioner(keys.Jump, keys.HeaderEntity("entity-id"))
+func NewPartitioner(strategy Strategy, entity EntityFunc) sarama.PartitionerConstructor {
+	if entity == nil {
+		entity = KeyEntity
+	}
+	return func(topic string) sarama.Partitioner {
+		return &partitioner{strategy: strategy, entity: entity}
+	}
+}
+
+func (p *partitioner) Partition(msg *sarama.ProducerMessage, numPartitions int32) (int32, error) {
+	if numPartitions <= 0 {
+		return -1, sarama.ErrInvalidPartition
+	}
+	if id := p.entity(msg); len(id) > 0 {
+		return p.strategy(id, numPartitions), nil
+	}
+	return int32((p.next.Add(1) - 1) % uint32(numPartitions)), nil
+}
+
+// RequiresConsistency tells sarama to wait for a leader instead of choosing
+// another partition when the hashed one is unavailable; moving a keyed record
+// would break per-entity ordering.
+func (p *partitioner) RequiresConsistency() bool { return true }
+
+// MessageRequiresConsistency lets keyless records skip unavailable
+// partitions, since they carry no ordering promise.
+func (p *partitioner) MessageRequiresConsistency(msg *sarama.ProducerMessage) bool {
+	return len(p.entity(msg)) > 0
+}
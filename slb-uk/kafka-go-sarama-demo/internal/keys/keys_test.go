@@ -0,0 +1,152 @@
+package keys
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/IBM/sarama"
+)
+
+// Values from Kafka's UtilsTest.testMurmur2.
+func TestMurmur2MatchesJavaClient(t *testing.T) {
+	cases := map[string]int32{
+		"21":                         -973932308,
+		"foobar":                     -790332482,
+		"a-little-bit-long-string":   -985981536,
+		"a-little-bit-longer-string": -1486304829,
+		"lkjh234lh9fiuh90y23oiuhsafujhadof229phr9h19h89h8": -58897971,
+		"abc": 479470107,
+	}
+	for in, want := range cases {
+		if got := Murmur2Hash([]byte(in)); got != want {
+			t.Errorf("Murmur2Hash(%q) = %d, want %d", in, got, want)
+		}
+	}
+}
+
+func TestStrategiesStayInRange(t *testing.T) {
+	for name, s := range map[string]Strategy{"murmur2": Murmur2, "jump": Jump} {
+		for n := int32(1); n <= 64; n++ {
+			for i := 0; i < 200; i++ {
+				if p := s([]byte(fmt.Sprintf("entity-%d", i)), n); p < 0 || p >= n {
+					t.Fatalf("%s: partition %d out of [0,%d)", name, p, n)
+				}
+			}
+		}
+	}
+}
+
+// produce routes a stream of (entity, seq) events and returns, per
+// partition, the order in which events were appended.
+func produce(t *testing.T, ctor sarama.PartitionerConstructor, n int32, entities, perEntity int) map[int32][][2]int {
+	t.Helper()
+	p := ctor("events.v1")
+	logs := map[int32][][2]int{}
+	for seq := 0; seq < perEntity; seq++ {
+		for e := 0; e < entities; e++ {
+			msg := &sarama.ProducerMessage{Key: sarama.StringEncoder(fmt.Sprintf("user-%d", e))}
+			part, err := p.Partition(msg, n)
+			if err != nil {
+				t.Fatal(err)
+			}
+			logs[part] = append(logs[part], [2]int{e, seq})
+		}
+	}
+	return logs
+}
+
+func TestPerEntityOrderingWithinPartitionCount(t *testing.T) {
+	for name, s := range map[string]Strategy{"murmur2": Murmur2, "jump": Jump} {
+		logs := produce(t, NewPartitioner(s, nil), 12, 500, 20)
+		home := map[int]int32{}
+		last := map[int]int{}
+		for part, log := range logs {
+			for _, ev := range log {
+				e, seq := ev[0], ev[1]
+				if h, ok := home[e]; ok && h != part {
+					t.Fatalf("%s: entity %d split across partitions %d and %d", name, e, h, part)
+				}
+				home[e] = part
+				if prev, ok := last[e]; ok && seq != prev+1 {
+					t.Fatalf("%s: entity %d out of order: %d after %d", name, e, seq, prev)
+				}
+				last[e] = seq
+			}
+		}
+	}
+}
+
+// When partitions are added, an entity whose partition changes can briefly
+// see new events consumed before old ones. Jump keeps that set minimal and
+// never reshuffles between existing partitions; modulo hashing does (except
+// when the count is exactly multiplied).
+func TestPartitionCountChange(t *testing.T) {
+	const entities = 20000
+	moved := func(s Strategy, from, to int32) (n int, toOld int) {
+		for e := 0; e < entities; e++ {
+			key := []byte(fmt.Sprintf("user-%d", e))
+			a, b := s(key, from), s(key, to)
+			if a != b {
+				n++
+				if b < from {
+					toOld++
+				}
+			}
+		}
+		return n, toOld
+	}
+
+	for _, c := range []struct{ from, to int32 }{{6, 8}, {8, 12}, {12, 16}} {
+		ideal := float64(c.to-c.from) / float64(c.to)
+
+		n, toOld := moved(Jump, c.from, c.to)
+		frac := float64(n) / entities
+		if toOld != 0 {
+			t.Errorf("jump %d->%d: %d entities moved between existing partitions", c.from, c.to, toOld)
+		}
+		if frac > ideal*1.1 {
+			t.Errorf("jump %d->%d: %.1f%% moved, want about %.1f%%", c.from, c.to, 100*frac, 100*ideal)
+		}
+
+		n, toOld = moved(Murmur2, c.from, c.to)
+		if f := float64(n) / entities; f <= frac || toOld == 0 {
+			t.Errorf("murmur2 %d->%d: expected more movement than jump and moves between existing partitions (%.1f%% vs %.1f%%, %d)", c.from, c.to, 100*f, 100*frac, toOld)
+		}
+	}
+}
+
+func TestKeylessRoundRobin(t *testing.T) {
+	p := NewPartitioner(Jump, nil)("events.v1")
+	counts := make([]int, 4)
+	for i := 0; i < 400; i++ {
+		part, err := p.Partition(&sarama.ProducerMessage{}, 4)
+		if err != nil {
+			t.Fatal(err)
+		}
+		counts[part]++
+	}
+	for part, c := range counts {
+		if c != 100 {
+			t.Errorf("partition %d got %d keyless records, want 100", part, c)
+		}
+	}
+	dp := p.(sarama.DynamicConsistencyPartitioner)
+	if dp.MessageRequiresConsistency(&sarama.ProducerMessage{}) {
+		t.Error("keyless records should not require consistency")
+	}
+	if !dp.MessageRequiresConsistency(&sarama.ProducerMessage{Key: sarama.StringEncoder("k")}) {
+		t.Error("keyed records must require consistency")
+	}
+}
+
+func TestHeaderEntity(t *testing.T) {
+	p := NewPartitioner(Jump, HeaderEntity("entity-id"))("events.v1")
+	want := Jump([]byte("order-7"), 16)
+	msg := &sarama.ProducerMessage{
+		Key:     sarama.StringEncoder("some-other-key"),
+		Headers: []sarama.RecordHeader{{Key: []byte("entity-id"), Value: []byte("order-7")}},
+	}
+	if got, _ := p.Partition(msg, 16); got != want {
+		t.Errorf("partition = %d, want %d (from header)", got, want)
+	}
+}
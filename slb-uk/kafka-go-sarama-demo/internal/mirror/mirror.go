@@ -0,0 +1,184 @@
+// Package mirror holds the bookkeeping behind cmd/mirror: offset syncs that
+// map a source offset to the offset the same record got on the target
+// cluster, and the rate limiter that throttles the copy.
+//
+// Offsets differ between clusters (retention, compaction and transaction
+// markers all shift them), so a consumer group failing over to the target
+// cannot reuse its source offsets. The mirror periodically writes the latest
+// Sync per partition to a checkpoint topic on the target; Translate turns a
+// committed source offset into a safe target offset from those syncs, the
+// way MirrorMaker 2's checkpoints do.
+package mirror
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// HeaderMirroredFrom is added to every mirrored record:
+// "<topic>/<partition>/<offset>" on the source cluster.
+const HeaderMirroredFrom = "x-mirrored-from"
+
+// Sync says the source record at SourceOffset was written to the target at
+// TargetOffset.
+type Sync struct {
+	SourceTopic     string    `json:"source_topic"`
+	SourcePartition int32     `json:"source_partition"`
+	SourceOffset    int64     `json:"source_offset"`
+	TargetTopic     string    `json:"target_topic"`
+	TargetPartition int32     `json:"target_partition"`
+	TargetOffset    int64     `json:"target_offset"`
+	At              time.Time `json:"at"`
+}
+
+// Key is the checkpoint record key, so a compacted checkpoint topic keeps
+// the latest sync per source partition.
+func (s Sync) Key() string { return fmt.Sprintf("%s/%d", s.SourceTopic, s.SourcePartition) }
+
+func (s Sync) Encode() ([]byte, error) { return json.Marshal(s) }
+
+func DecodeSync(b []byte) (Sync, error) {
+	var s Sync
+	err := json.Unmarshal(b, &s)
+	return s, err
+}
+
+type partition struct {
+	topic string
+	part  int32
+}
+
+// Syncs keeps the most recent syncs per source partition. It is safe for
+// concurrent use.
+type Syncs struct {
+	keep int
+
+	mu    sync.Mutex
+	parts map[partition][]Sync // ascending SourceOffset
+	dirty map[partition]bool   // newer than the last Pending
+}
+
+// NewSyncs keeps up to keep syncs per partition (at least 1). Older ones
+// only matter for translating groups that lag far behind.
+func NewSyncs(keep int) *Syncs {
+	return &Syncs{keep: max(1, keep), parts: map[partition][]Sync{}, dirty: map[partition]bool{}}
+}
+
+// Add records s. Syncs that do not move the source offset forward are
+// ignored, so replays of an old checkpoint topic cannot go backwards.
+func (m *Syncs) Add(s Sync) {
+	k := partition{s.SourceTopic, s.SourcePartition}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	l := m.parts[k]
+	if n := len(l); n > 0 && l[n-1].SourceOffset >= s.SourceOffset {
+		return
+	}
+	l = append(l, s)
+	if len(l) > m.keep {
+		l = append(l[:0], l[len(l)-m.keep:]...)
+	}
+	m.parts[k] = l
+	m.dirty[k] = true
+}
+
+// Pending returns the latest sync of every partition that changed since the
+// previous call, sorted by key: what the next checkpoint has to write.
+func (m *Syncs) Pending() []Sync {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var out []Sync
+	for k := range m.dirty {
+		l := m.parts[k]
+		out = append(out, l[len(l)-1])
+	}
+	m.dirty = map[partition]bool{}
+	sort.Slice(out, func(i, j int) bool { return out[i].Key() < out[j].Key() })
+	return out
+}
+
+// Latest returns the newest sync for a source partition.
+func (m *Syncs) Latest(topic string, p int32) (Sync, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	l := m.parts[partition{topic, p}]
+	if len(l) == 0 {
+		return Sync{}, false
+	}
+	return l[len(l)-1], true
+}
+
+// Translate maps a committed source offset (the next record the group would
+// read) to the target offset to commit there. It uses the newest sync below
+// committed: everything up to that sync's target offset is known to be
+// processed, and records between the sync and committed are delivered
+// again, so a failover may duplicate but never skip. ok is false when no
+// sync is old enough; the group then has to start from the target's oldest
+// offset.
+func (m *Syncs) Translate(topic string, p int32, committed int64) (Sync, int64, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	l := m.parts[partition{topic, p}]
+	i := sort.Search(len(l), func(i int) bool { return l[i].SourceOffset >= committed })
+	if i == 0 {
+		return Sync{}, 0, false
+	}
+	s := l[i-1]
+	return s, s.TargetOffset + 1, true
+}
+
+// Limiter is a token bucket: Wait lets rate records a second through, with
+// bursts of up to burst. A zero rate never waits.
+type Limiter struct {
+	rate  float64
+	burst float64
+	now   func() time.Time
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+func NewLimiter(rate float64, burst int) *Limiter {
+	l := &Limiter{rate: rate, burst: float64(max(1, burst)), now: time.Now}
+	l.tokens, l.last = l.burst, l.now()
+	return l
+}
+
+// Reserve takes a token and returns how long the caller has to wait before
+// using it.
+func (l *Limiter) Reserve() time.Duration {
+	if l.rate <= 0 {
+		return 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	l.tokens = min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+	l.tokens--
+	if l.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-l.tokens / l.rate * float64(time.Second))
+}
+
+// Wait blocks until a token is available and returns how long it waited.
+func (l *Limiter) Wait(ctx context.Context) (time.Duration, error) {
+	d := l.Reserve()
+	if d <= 0 {
+		return 0, nil
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	case <-t.C:
+		return d, nil
+	}
+}
@@ -0,0 +1,101 @@
+package mirror
+
+import (
+	"testing"
+	"time"
+)
+
+func syncAt(src, dst int64) Sync {
+	return Sync{SourceTopic: "events.v1", SourcePartition: 1, SourceOffset: src,
+		TargetTopic: "dr.events.v1", TargetPartition: 1, TargetOffset: dst}
+}
+
+func TestTranslate(t *testing.T) {
+	s := NewSyncs(10)
+	// the target lost offsets 0..99 to retention before the mirror started
+	for _, x := range []Sync{syncAt(10, 100), syncAt(20, 110), syncAt(30, 118)} {
+		s.Add(x)
+	}
+	cases := []struct {
+		committed int64
+		want      int64
+		ok        bool
+	}{
+		{5, 0, false},  // before the first sync: unknown
+		{10, 0, false}, // record 10 itself not processed yet
+		{11, 101, true},
+		{21, 111, true},
+		{25, 111, true}, // between syncs: 21..24 are delivered again
+		{31, 119, true},
+		{500, 119, true},
+	}
+	for _, c := range cases {
+		_, got, ok := s.Translate("events.v1", 1, c.committed)
+		if ok != c.ok || got != c.want {
+			t.Errorf("Translate(%d) = %d, %v; want %d, %v", c.committed, got, ok, c.want, c.ok)
+		}
+	}
+	if _, _, ok := s.Translate("events.v1", 2, 50); ok {
+		t.Error("partition without syncs translated")
+	}
+}
+
+func TestSyncsKeepAndPending(t *testing.T) {
+	s := NewSyncs(2)
+	s.Add(syncAt(1, 1))
+	s.Add(syncAt(2, 2))
+	s.Add(syncAt(3, 3))
+	s.Add(syncAt(2, 9)) // a re-delivery after restart does not go backwards
+	if l, _ := s.Latest("events.v1", 1); l.TargetOffset != 3 {
+		t.Fatalf("latest = %+v", l)
+	}
+	if _, _, ok := s.Translate("events.v1", 1, 2); ok {
+		t.Error("sync 1 should have been dropped by keep=2")
+	}
+
+	p := s.Pending()
+	if len(p) != 1 || p[0].SourceOffset != 3 || p[0].Key() != "events.v1/1" {
+		t.Fatalf("pending = %+v", p)
+	}
+	if p := s.Pending(); len(p) != 0 {
+		t.Fatalf("pending again = %+v", p)
+	}
+
+	in := syncAt(4, 4)
+	in.At = time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	b, err := in.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := DecodeSync(b)
+	if err != nil || d != in {
+		t.Fatalf("round trip = %+v, %v", d, err)
+	}
+}
+
+func TestLimiter(t *testing.T) {
+	now := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	l := NewLimiter(10, 2)
+	l.now = func() time.Time { return now }
+	l.last = now
+
+	if d := l.Reserve(); d != 0 {
+		t.Fatalf("first = %v", d)
+	}
+	if d := l.Reserve(); d != 0 {
+		t.Fatalf("burst = %v", d)
+	}
+	if d := l.Reserve(); d != 100*time.Millisecond {
+		t.Fatalf("over burst = %v", d)
+	}
+	now = now.Add(time.Second) // refills to the burst, not to 10
+	l.Reserve()
+	l.Reserve()
+	if d := l.Reserve(); d != 100*time.Millisecond {
+		t.Fatalf("after refill = %v", d)
+	}
+
+	if d := NewLimiter(0, 1).Reserve(); d != 0 {
+		t.Fatalf("unlimited = %v", d)
+	}
+}
@@ -0,0 +1,123 @@
+// Package poison spots poison pills: records that fail the same way every
+// time they are read, so that retrying them in place would hold up their
+// partition forever.
+//
+// The retry topics only help with failures the handler reports as errors.
+// A record that makes the handler panic, or that cannot be republished to a
+// retry topic (too large, unserializable headers, ...), stays at its offset
+// and is read again and again. The Tracker counts failures per
+// topic/partition/offset for the current consumer-group session; once a
+// record reaches the limit the processor sends it to the DLQ with
+// x-poison: true and moves on.
+//
+// Environment:
+//
+//	POISON_MAX_FAILURES  failures of one offset before it is a poison pill
+//	                     (default 3)
+package poison
+
+import (
+	"fmt"
+	"os"
+	"runtime/debug"
+	"strconv"
+	"sync"
+)
+
+// Headers added to the DLQ record of a poison pill.
+const (
+	Header         = "x-poison"
+	HeaderFailures = "x-poison-failures"
+)
+
+const DefaultMaxFailures = 3
+
+type offset struct {
+	topic     string
+	partition int32
+	offset    int64
+}
+
+// Tracker counts failures per record. It is safe for concurrent use by the
+// ConsumeClaim goroutines of one session.
+type Tracker struct {
+	max int
+
+	mu    sync.Mutex
+	fails map[offset]int
+}
+
+// New returns a Tracker that declares a record poison at its max-th failure.
+func New(max int) *Tracker {
+	if max < 1 {
+		max = 1
+	}
+	return &Tracker{max: max, fails: map[offset]int{}}
+}
+
+// FromEnv returns a Tracker configured by POISON_MAX_FAILURES.
+func FromEnv() (*Tracker, error) {
+	v := os.Getenv("POISON_MAX_FAILURES")
+	if v == "" {
+		return New(DefaultMaxFailures), nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 1 {
+		return nil, fmt.Errorf("POISON_MAX_FAILURES=%q: want a positive integer", v)
+	}
+	return New(n), nil
+}
+
+// Max is the failure count at which a record becomes poison.
+func (t *Tracker) Max() int { return t.max }
+
+// Fail records a failure of the record at topic/partition/offset and
+// returns how often it has failed, and whether that makes it poison.
+func (t *Tracker) Fail(topic string, partition int32, off int64) (failures int, poison bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	k := offset{topic, partition, off}
+	t.fails[k]++
+	return t.fails[k], t.fails[k] >= t.max
+}
+
+// Done forgets the record once it has been marked, whichever way.
+func (t *Tracker) Done(topic string, partition int32, off int64) {
+	t.mu.Lock()
+	delete(t.fails, offset{topic, partition, off})
+	t.mu.Unlock()
+}
+
+// Reset forgets everything; call it when a new session starts, since the
+// partitions may have moved to other members.
+func (t *Tracker) Reset() {
+	t.mu.Lock()
+	t.fails = map[offset]int{}
+	t.mu.Unlock()
+}
+
+// Len is the number of records that have failed and are not Done yet.
+func (t *Tracker) Len() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.fails)
+}
+
+// PanicError is a recovered handler panic.
+type PanicError struct {
+	Value any
+	Stack []byte
+}
+
+func (e *PanicError) Error() string { return fmt.Sprintf("panic: %v", e.Value) }
+
+// Recover turns a panic into a *PanicError in *err. Use it as
+//
+//	defer poison.Recover(&err)
+//
+// in a function with a named error result.
+func Recover(err *error) {
+	if r := recover(); r != nil {
+		*err = &PanicError{Value: r, Stack: debug.Stack()}
+	}
+}
@@ -0,0 +1,68 @@
+package poison
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestTrackerCountsPerOffset(t *testing.T) {
+	tr := New(3)
+	for i := 1; i <= 2; i++ {
+		if n, p := tr.Fail("events.v1", 0, 42); n != i || p {
+			t.Fatalf("failure %d: got (%d, %v), want (%d, false)", i, n, p, i)
+		}
+	}
+	// Neighbouring records and partitions are counted separately.
+	if n, p := tr.Fail("events.v1", 0, 43); n != 1 || p {
+		t.Fatalf("offset 43: got (%d, %v)", n, p)
+	}
+	if n, p := tr.Fail("events.v1", 1, 42); n != 1 || p {
+		t.Fatalf("partition 1: got (%d, %v)", n, p)
+	}
+	if n, p := tr.Fail("events.v1", 0, 42); n != 3 || !p {
+		t.Fatalf("third failure: got (%d, %v), want (3, true)", n, p)
+	}
+
+	tr.Done("events.v1", 0, 42)
+	if n, _ := tr.Fail("events.v1", 0, 42); n != 1 {
+		t.Fatalf("after Done: got %d failures, want 1", n)
+	}
+	tr.Reset()
+	if tr.Len() != 0 {
+		t.Fatalf("after Reset: %d records tracked", tr.Len())
+	}
+}
+
+func TestFromEnv(t *testing.T) {
+	t.Setenv("POISON_MAX_FAILURES", "")
+	if tr, err := FromEnv(); err != nil || tr.Max() != DefaultMaxFailures {
+		t.Fatalf("default: %v, %v", tr, err)
+	}
+	t.Setenv("POISON_MAX_FAILURES", "5")
+	if tr, err := FromEnv(); err != nil || tr.Max() != 5 {
+		t.Fatalf("5: %v, %v", tr, err)
+	}
+	for _, bad := range []string{"0", "-1", "x"} {
+		t.Setenv("POISON_MAX_FAILURES", bad)
+		if _, err := FromEnv(); err == nil {
+			t.Errorf("%q: want error", bad)
+		}
+	}
+}
+
+func TestRecover(t *testing.T) {
+	run := func() (err error) {
+		defer Recover(&err)
+		var m map[string]int
+		m["boom"]++ // nil map write panics
+		return nil
+	}
+	err := run()
+	var pe *PanicError
+	if !errors.As(err, &pe) {
+		t.Fatalf("got %v, want *PanicError", err)
+	}
+	if len(pe.Stack) == 0 {
+		t.Error("stack not captured")
+	}
+}
@@ -1,12 +1,26 @@
+// Package retry routes records whose processing failed: through Stages,
+// each a topic the retry worker re-queues from after its delay, and then
+// to DLQTopic.
 package retry
 
-import "time"
+import (
+	"strconv"
+	"time"
+
+	"github.com/IBM/sarama"
+
+	"example.com/kafka-go-sarama-demo/internal/kafka"
+	"example.com/kafka-go-sarama-demo/internal/validate"
+)
 
 const (
 	HeaderAttempt = "x-retry-attempt"
 	HeaderError   = "x-error"
 )
 
+// DLQTopic gets the records that failed in every stage.
+const DLQTopic = "events.v1.dlq"
+
 type Stage struct {
 	Topic string
 	Delay time.Duration
@@ -24,3 +38,53 @@ func Next(attempt int) (Stage, bool) {
 	}
 	return Stage{}, false
 }
+
+// Attempt is the x-retry-attempt header of msg: the number of stages it
+// has been through. Without a readable header it is 0.
+func Attempt(msg *sarama.ConsumerMessage) int {
+	for _, h := range msg.Headers {
+		if string(h.Key) == HeaderAttempt {
+			if n, err := strconv.Atoi(string(h.Value)); err == nil {
+				return n
+			}
+		}
+	}
+	return 0
+}
+
+// Route returns the copy of msg, which failed with err, for where it goes
+// next: the stage after Attempt(msg), with the attempt counted up, or
+// DLQTopic once Stages are used up, with the attempt as it was. The copy
+// keeps the headers of msg; x-retry-attempt and x-error are replaced, not
+// added again, so the attempt read from the next copy is this one.
+func Route(msg *sarama.ConsumerMessage, err error) *sarama.ProducerMessage {
+	attempt := Attempt(msg)
+	out := &sarama.ProducerMessage{
+		Topic: DLQTopic,
+		Key:   sarama.ByteEncoder(msg.Key),
+		Value: sarama.ByteEncoder(msg.Value),
+	}
+	if stage, ok := Next(attempt); ok {
+		out.Topic = stage.Topic
+		attempt++
+	}
+	for _, h := range msg.Headers {
+		if k := string(h.Key); k != HeaderAttempt && k != HeaderError {
+			out.Headers = append(out.Headers, *h)
+		}
+	}
+	out.Headers = append(out.Headers,
+		sarama.RecordHeader{Key: []byte(HeaderAttempt), Value: []byte(strconv.Itoa(attempt))},
+		sarama.RecordHeader{Key: []byte(HeaderError), Value: []byte(err.Error())},
+	)
+	return out
+}
+
+// Publish sends Route(msg, err) through p, stamped with contentType when
+// msg had none; see validate.Stamp.
+func Publish(p kafka.Producer, msg *sarama.ConsumerMessage, err error, contentType string) error {
+	out := Route(msg, err)
+	validate.Stamp(out, contentType)
+	_, _, e := p.SendMessage(out)
+	return e
+}
@@ -0,0 +1,149 @@
+package retry
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+
+	"github.com/IBM/sarama"
+	"github.com/golang/mock/gomock"
+
+	"example.com/kafka-go-sarama-demo/internal/kafka/kafkatest"
+	"example.com/kafka-go-sarama-demo/internal/kafka/mocks"
+	"example.com/kafka-go-sarama-demo/internal/validate"
+)
+
+var errDownstream = errors.New("downstream: simulated failure")
+
+// consumed turns a produced copy into the record the next consumer reads.
+func consumed(m *sarama.ProducerMessage) *sarama.ConsumerMessage {
+	c := &sarama.ConsumerMessage{Topic: m.Topic}
+	c.Key, _ = m.Key.Encode()
+	c.Value, _ = m.Value.Encode()
+	for i := range m.Headers {
+		c.Headers = append(c.Headers, &m.Headers[i])
+	}
+	return c
+}
+
+func headers(m *sarama.ProducerMessage, key string) []string {
+	var vs []string
+	for _, h := range m.Headers {
+		if string(h.Key) == key {
+			vs = append(vs, string(h.Value))
+		}
+	}
+	return vs
+}
+
+func TestAttempt(t *testing.T) {
+	cases := []struct {
+		headers []*sarama.RecordHeader
+		want    int
+	}{
+		{nil, 0},
+		{[]*sarama.RecordHeader{{Key: []byte(HeaderAttempt), Value: []byte("2")}}, 2},
+		{[]*sarama.RecordHeader{{Key: []byte(HeaderAttempt), Value: []byte("x")}}, 0},
+		// an unreadable header does not hide a readable one
+		{[]*sarama.RecordHeader{{Key: []byte(HeaderAttempt), Value: []byte("")}, {Key: []byte(HeaderAttempt), Value: []byte("1")}}, 1},
+	}
+	for _, c := range cases {
+		if got := Attempt(&sarama.ConsumerMessage{Headers: c.headers}); got != c.want {
+			t.Errorf("Attempt(%v) = %d, want %d", c.headers, got, c.want)
+		}
+	}
+}
+
+// TestPublishWalksTheStages fails one record again and again, the way the
+// processor and the retry worker pass it around, and follows where each
+// copy goes.
+func TestPublishWalksTheStages(t *testing.T) {
+	p := kafkatest.NewProducer(len(Stages) + 1)
+	msg := &sarama.ConsumerMessage{Topic: "events.v1", Key: []byte("user-42"), Value: []byte("fail: x"),
+		Headers: []*sarama.RecordHeader{{Key: []byte("x-event-type"), Value: []byte("demo.ok")}}}
+	for i, stage := range Stages {
+		if err := Publish(p, msg, errDownstream, "application/octet-stream"); err != nil {
+			t.Fatalf("attempt %d: %v", i, err)
+		}
+		out := <-p.Sent
+		if out.Topic != stage.Topic {
+			t.Fatalf("attempt %d went to %s, want %s", i, out.Topic, stage.Topic)
+		}
+		if got := headers(out, HeaderAttempt); len(got) != 1 || got[0] != strconv.Itoa(i+1) {
+			t.Fatalf("attempt %d: %s = %v, want [%d]", i, HeaderAttempt, got, i+1)
+		}
+		msg = consumed(out)
+	}
+
+	if err := Publish(p, msg, errDownstream, "application/octet-stream"); err != nil {
+		t.Fatal(err)
+	}
+	out := <-p.Sent
+	if out.Topic != DLQTopic {
+		t.Fatalf("after the last stage: went to %s, want %s", out.Topic, DLQTopic)
+	}
+	if got := headers(out, HeaderAttempt); len(got) != 1 || got[0] != strconv.Itoa(len(Stages)) {
+		t.Errorf("DLQ copy: %s = %v, want [%d]", HeaderAttempt, got, len(Stages))
+	}
+	if got := headers(out, HeaderError); len(got) != 1 || got[0] != errDownstream.Error() {
+		t.Errorf("DLQ copy: %s = %v", HeaderError, got)
+	}
+	if got := headers(out, "x-event-type"); len(got) != 1 || got[0] != "demo.ok" {
+		t.Errorf("DLQ copy: x-event-type = %v, want the original", got)
+	}
+	if k, _ := out.Key.Encode(); string(k) != "user-42" {
+		t.Errorf("DLQ copy: key %q", k)
+	}
+	// the id is stamped on the first copy and kept from then on
+	if id := headers(out, validate.HeaderMessageID); len(id) != 1 || id[0] == "" {
+		t.Errorf("DLQ copy: %s = %v", validate.HeaderMessageID, id)
+	}
+}
+
+func TestRouteLeavesTheRecordAlone(t *testing.T) {
+	hdr := &sarama.RecordHeader{Key: []byte(HeaderAttempt), Value: []byte("1")}
+	msg := &sarama.ConsumerMessage{Headers: []*sarama.RecordHeader{hdr}}
+	out := Route(msg, errDownstream)
+	out.Headers[0].Value = []byte("changed")
+	if len(msg.Headers) != 1 || string(hdr.Value) != "1" {
+		t.Fatalf("consumed record changed: %v %q", msg.Headers, hdr.Value)
+	}
+}
+
+func TestRouteAfterTooManyAttempts(t *testing.T) {
+	// a record from before a stage was removed
+	msg := &sarama.ConsumerMessage{Headers: []*sarama.RecordHeader{{Key: []byte(HeaderAttempt), Value: []byte("7")}}}
+	out := Route(msg, errDownstream)
+	if out.Topic != DLQTopic || headers(out, HeaderAttempt)[0] != "7" {
+		t.Fatalf("got %s with attempt %v", out.Topic, headers(out, HeaderAttempt))
+	}
+}
+
+func TestPublishError(t *testing.T) {
+	p := kafkatest.NewProducer(1)
+	p.Fail(sarama.ErrNotLeaderForPartition)
+	err := Publish(p, &sarama.ConsumerMessage{}, errDownstream, "")
+	if !errors.Is(err, sarama.ErrNotLeaderForPartition) {
+		t.Fatalf("got %v", err)
+	}
+	if len(p.Sent) != 0 {
+		t.Fatal("a failed send reached Sent")
+	}
+}
+
+func TestPublishWithMock(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	p := mocks.NewMockProducer(ctrl)
+	p.EXPECT().SendMessage(gomock.Any()).DoAndReturn(func(m *sarama.ProducerMessage) (int32, int64, error) {
+		if m.Topic != Stages[0].Topic {
+			t.Errorf("topic %s", m.Topic)
+		}
+		if ct, _ := validate.Header(m, validate.HeaderContentType); ct != "text/plain" {
+			t.Errorf("content-type %q", ct)
+		}
+		return 0, 1, nil
+	})
+	if err := Publish(p, &sarama.ConsumerMessage{Value: []byte("fail:")}, errDownstream, "text/plain"); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,101 @@
+package schedule
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// LatenessBuckets are the upper bounds, in seconds, of the lateness
+// histogram. Anything above the smallest level's delay means the worker
+// fell behind.
+var LatenessBuckets = []float64{.05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60}
+
+// Metrics counts what the worker did with scheduled records, in the
+// Prometheus text format (no Prometheus dependency, like cmd/mirror).
+// Accuracy is the lateness histogram: delivery time minus x-deliver-at.
+type Metrics struct {
+	mu        sync.Mutex
+	hops      map[string]float64 // by delay topic moved into
+	delivered map[string]float64 // by destination topic
+	failures  map[string]float64 // by delay topic consumed from
+	invalid   float64
+	buckets   []float64 // cumulative counts per LatenessBuckets entry
+	count     float64
+	sum       float64
+	maxHops   int
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{hops: map[string]float64{}, delivered: map[string]float64{}, failures: map[string]float64{},
+		buckets: make([]float64, len(LatenessBuckets))}
+}
+
+// Hopped records a move into the delay topic to.
+func (m *Metrics) Hopped(to string) {
+	m.mu.Lock()
+	m.hops[to]++
+	m.mu.Unlock()
+}
+
+// Delivered records a record reaching topic late after hops delay topics.
+func (m *Metrics) Delivered(topic string, late time.Duration, hops int) {
+	s := late.Seconds()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.delivered[topic]++
+	m.count++
+	m.sum += s
+	for i, le := range LatenessBuckets {
+		if s <= le {
+			m.buckets[i]++
+		}
+	}
+	m.maxHops = max(m.maxHops, hops)
+}
+
+// Failed records a forward from the delay topic from that did not go out.
+func (m *Metrics) Failed(from string) {
+	m.mu.Lock()
+	m.failures[from]++
+	m.mu.Unlock()
+}
+
+// Invalid records a record in a delay topic without usable schedule headers.
+func (m *Metrics) Invalid() {
+	m.mu.Lock()
+	m.invalid++
+	m.mu.Unlock()
+}
+
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	counter := func(name, help, label string, by map[string]float64) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", name, help, name)
+		keys := make([]string, 0, len(by))
+		for k := range by {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			fmt.Fprintf(w, "%s{%s=%q} %g\n", name, label, k, by[k])
+		}
+	}
+	counter("schedule_hops_total", "Scheduled records moved into a delay topic by the worker.", "level", m.hops)
+	counter("schedule_delivered_total", "Scheduled records delivered to their destination.", "topic", m.delivered)
+	counter("schedule_forward_failures_total", "Forwards that could not be produced; the record is read again.", "level", m.failures)
+	fmt.Fprintf(w, "# HELP schedule_invalid_total Records in delay topics without x-deliver-at/x-deliver-to, skipped.\n# TYPE schedule_invalid_total counter\nschedule_invalid_total %g\n", m.invalid)
+
+	fmt.Fprintf(w, "# HELP schedule_lateness_seconds Delivery time minus x-deliver-at.\n# TYPE schedule_lateness_seconds histogram\n")
+	for i, le := range LatenessBuckets {
+		fmt.Fprintf(w, "schedule_lateness_seconds_bucket{le=\"%g\"} %g\n", le, m.buckets[i])
+	}
+	fmt.Fprintf(w, "schedule_lateness_seconds_bucket{le=\"+Inf\"} %g\n", m.count)
+	fmt.Fprintf(w, "schedule_lateness_seconds_sum %g\nschedule_lateness_seconds_count %g\n", m.sum, m.count)
+	fmt.Fprintf(w, "# HELP schedule_max_hops Most delay topics one delivered record passed through.\n# TYPE schedule_max_hops gauge\nschedule_max_hops %d\n", m.maxHops)
+}
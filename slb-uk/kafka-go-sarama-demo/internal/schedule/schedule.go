@@ -0,0 +1,186 @@
+// Package schedule delivers records at a given time. Kafka has no delayed
+// delivery, so a scheduled record travels through a hierarchy of delay
+// topics, a timing wheel made of topics:
+//
+//	schedule.1h  schedule.10m  schedule.1m  schedule.10s  schedule.1s
+//
+// Every record in one of these topics waits the same time, so the worker
+// (cmd/retryworker, next to the retry stages) only ever waits for the head
+// of a partition; nothing behind it is due much earlier. Producer.SendAt
+// puts a record into the largest level that does not overshoot its
+// deliverAt by more than the smallest delay. When the level's delay has
+// passed, or the record is due if that comes first, the worker routes it
+// again from the time left: to a smaller level, or to its destination
+// topic once it is due. A record for 90 minutes from now goes 1h, 10m ×3.
+// Waits are computed from x-deliver-at on every hop, so errors do not add
+// up; a record is late by at most the smallest delay plus the worker's own
+// lag, early never.
+//
+// Headers set on a scheduled record and kept on delivery:
+//
+//	x-deliver-at      due time, Unix milliseconds
+//	x-deliver-to      destination topic
+//	x-schedule-hops   delay topics passed through so far
+package schedule
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+const (
+	HeaderDeliverAt = "x-deliver-at"
+	HeaderDeliverTo = "x-deliver-to"
+	HeaderHops      = "x-schedule-hops"
+)
+
+// Level is one delay topic of the wheel.
+type Level struct {
+	Topic string
+	Delay time.Duration
+}
+
+// Levels is the wheel, largest delay first.
+var Levels = []Level{
+	{Topic: "schedule.1h", Delay: time.Hour},
+	{Topic: "schedule.10m", Delay: 10 * time.Minute},
+	{Topic: "schedule.1m", Delay: time.Minute},
+	{Topic: "schedule.10s", Delay: 10 * time.Second},
+	{Topic: "schedule.1s", Delay: time.Second},
+}
+
+// LevelFor returns the level whose topic is topic.
+func LevelFor(topic string) (Level, bool) {
+	for _, l := range Levels {
+		if l.Topic == topic {
+			return l, true
+		}
+	}
+	return Level{}, false
+}
+
+// Route returns the level a record due at deliverAt goes to next: the
+// largest one whose delay fits into the time left plus the smallest delay.
+// The slack keeps hop latency from pushing a record a level down every
+// time (10m minus a few ms left would otherwise mean 1m ×9, 10s ×5, ...);
+// the worker waits only until the record is due. ok is false when the
+// record is due.
+func Route(deliverAt, now time.Time) (l Level, ok bool) {
+	left := deliverAt.Sub(now)
+	if left <= 0 {
+		return Level{}, false
+	}
+	slack := Levels[len(Levels)-1].Delay
+	for _, l := range Levels {
+		if l.Delay <= left+slack {
+			return l, true
+		}
+	}
+	return Levels[len(Levels)-1], true
+}
+
+// Producer schedules records through the wheel. Records that are already
+// due go straight to their topic.
+type Producer struct {
+	sarama.SyncProducer
+	now func() time.Time
+}
+
+// NewProducer wraps p. Its SendMessage and SendMessages are p's.
+func NewProducer(p sarama.SyncProducer) *Producer {
+	return &Producer{SyncProducer: p, now: time.Now}
+}
+
+// SendAt sends m so that it reaches m.Topic at deliverAt. m.Topic is
+// rewritten to the first delay topic; partition and offset are the ones in
+// that topic.
+func (p *Producer) SendAt(m *sarama.ProducerMessage, deliverAt time.Time) (int32, int64, error) {
+	if m.Topic == "" {
+		return -1, -1, fmt.Errorf("schedule: record without a topic")
+	}
+	setHeader(m, HeaderDeliverAt, strconv.FormatInt(deliverAt.UnixMilli(), 10))
+	setHeader(m, HeaderDeliverTo, m.Topic)
+	if l, ok := Route(deliverAt, p.now()); ok {
+		setHeader(m, HeaderHops, "1")
+		m.Topic = l.Topic
+	}
+	return p.SyncProducer.SendMessage(m)
+}
+
+// Scheduled is what a consumed delay-topic record says about itself.
+type Scheduled struct {
+	DeliverAt time.Time
+	DeliverTo string
+	Hops      int
+}
+
+// Parse reads the schedule headers of msg.
+func Parse(msg *sarama.ConsumerMessage) (Scheduled, error) {
+	var s Scheduled
+	var at string
+	for _, h := range msg.Headers {
+		switch string(h.Key) {
+		case HeaderDeliverAt:
+			at = string(h.Value)
+		case HeaderDeliverTo:
+			s.DeliverTo = string(h.Value)
+		case HeaderHops:
+			s.Hops, _ = strconv.Atoi(string(h.Value))
+		}
+	}
+	ms, err := strconv.ParseInt(at, 10, 64)
+	if err != nil {
+		return s, fmt.Errorf("schedule: %s %q: %w", HeaderDeliverAt, at, err)
+	}
+	if s.DeliverTo == "" {
+		return s, fmt.Errorf("schedule: %s missing", HeaderDeliverTo)
+	}
+	s.DeliverAt = time.UnixMilli(ms)
+	return s, nil
+}
+
+// WaitUntil is when a record consumed from level l moves on: once it has
+// been in the topic for l.Delay, or when it is due if that is earlier.
+func (s Scheduled) WaitUntil(msg *sarama.ConsumerMessage, l Level) time.Time {
+	if msg.Timestamp.IsZero() {
+		return s.DeliverAt
+	}
+	if t := msg.Timestamp.Add(l.Delay); t.Before(s.DeliverAt) {
+		return t
+	}
+	return s.DeliverAt
+}
+
+// Next is the record that carries msg on at now: into the next level, or
+// to its destination (due true).
+func (s Scheduled) Next(msg *sarama.ConsumerMessage, now time.Time) (out *sarama.ProducerMessage, due bool) {
+	out = &sarama.ProducerMessage{
+		Topic: s.DeliverTo,
+		Key:   sarama.ByteEncoder(msg.Key),
+		Value: sarama.ByteEncoder(msg.Value),
+	}
+	for _, h := range msg.Headers {
+		out.Headers = append(out.Headers, *h)
+	}
+	l, ok := Route(s.DeliverAt, now)
+	if !ok {
+		return out, true
+	}
+	out.Topic = l.Topic
+	setHeader(out, HeaderHops, strconv.Itoa(s.Hops+1))
+	return out, false
+}
+
+// setHeader replaces header key, or adds it.
+func setHeader(m *sarama.ProducerMessage, key, v string) {
+	for i, h := range m.Headers {
+		if string(h.Key) == key {
+			m.Headers[i].Value = []byte(v)
+			return
+		}
+	}
+	m.Headers = append(m.Headers, sarama.RecordHeader{Key: []byte(key), Value: []byte(v)})
+}
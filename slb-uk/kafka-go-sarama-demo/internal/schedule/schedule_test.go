@@ -0,0 +1,179 @@
+package schedule
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+func TestRoute(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	cases := []struct {
+		left  time.Duration
+		topic string
+		ok    bool
+	}{
+		{-time.Second, "", false},
+		{0, "", false},
+		{300 * time.Millisecond, "schedule.1s", true}, // under the smallest level: waits for the due time there
+		{time.Second, "schedule.1s", true},
+		{8 * time.Second, "schedule.1s", true},
+		{9500 * time.Millisecond, "schedule.10s", true}, // overshoots by less than 1s
+		{15 * time.Second, "schedule.10s", true},
+		{75 * time.Second, "schedule.1m", true},
+		{59 * time.Minute, "schedule.10m", true},
+		{30 * time.Hour, "schedule.1h", true},
+	}
+	for _, c := range cases {
+		l, ok := Route(now.Add(c.left), now)
+		if ok != c.ok || l.Topic != c.topic {
+			t.Errorf("Route(+%v) = %q, %v; want %q, %v", c.left, l.Topic, ok, c.topic, c.ok)
+		}
+	}
+}
+
+type captureProducer struct {
+	sarama.SyncProducer
+	sent []*sarama.ProducerMessage
+}
+
+func (p *captureProducer) SendMessage(m *sarama.ProducerMessage) (int32, int64, error) {
+	p.sent = append(p.sent, m)
+	return 0, int64(len(p.sent) - 1), nil
+}
+
+// consumed turns a produced record into the record the worker reads.
+func consumed(m *sarama.ProducerMessage, ts time.Time) *sarama.ConsumerMessage {
+	c := &sarama.ConsumerMessage{Topic: m.Topic, Timestamp: ts}
+	c.Key, _ = m.Key.Encode()
+	c.Value, _ = m.Value.Encode()
+	for i := range m.Headers {
+		c.Headers = append(c.Headers, &m.Headers[i])
+	}
+	return c
+}
+
+// ride sends a record due in left through the wheel, playing the worker:
+// wait as long as each level says, then move on. It returns the delay
+// topics passed and how late the record arrived.
+func ride(t *testing.T, left time.Duration) ([]string, time.Duration) {
+	t.Helper()
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	due := now.Add(left)
+	cp := &captureProducer{}
+	p := NewProducer(cp)
+	p.now = func() time.Time { return now }
+
+	m := &sarama.ProducerMessage{Topic: "events.v1", Key: sarama.StringEncoder("user-42"), Value: sarama.StringEncoder("hi")}
+	if _, _, err := p.SendAt(m, due); err != nil {
+		t.Fatal(err)
+	}
+	var path []string
+	for hop := 1; ; hop++ {
+		if hop > 20 {
+			t.Fatalf("not delivered after 20 hops: %v", path)
+		}
+		l, ok := LevelFor(m.Topic)
+		if !ok {
+			t.Fatalf("hop %d: %q is not a level", hop, m.Topic)
+		}
+		path = append(path, l.Topic)
+		msg := consumed(m, now)
+		sc, err := Parse(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sc.Hops != hop || sc.DeliverTo != "events.v1" || !sc.DeliverAt.Equal(due.Truncate(time.Millisecond)) {
+			t.Fatalf("hop %d: parsed %+v", hop, sc)
+		}
+		now = sc.WaitUntil(msg, l).Add(20 * time.Millisecond) // worker and produce latency
+		var last bool
+		m, last = sc.Next(msg, now)
+		if last {
+			break
+		}
+	}
+	if m.Topic != "events.v1" {
+		t.Fatalf("delivered to %q", m.Topic)
+	}
+	if v, _ := m.Value.Encode(); string(v) != "hi" {
+		t.Errorf("value %q", v)
+	}
+	return path, now.Sub(due)
+}
+
+func TestWheel(t *testing.T) {
+	cases := []struct {
+		left time.Duration
+		path string
+	}{
+		{75*time.Second + 500*time.Millisecond, "1m 10s 1s 1s 1s 1s 1s 1s"},
+		{90 * time.Minute, "1h 10m 10m 10m"},
+		{3*time.Hour + 5*time.Second, "1h 1h 1h 1s 1s 1s 1s 1s"},
+	}
+	for _, c := range cases {
+		path, late := ride(t, c.left)
+		if got := strings.ReplaceAll(strings.Join(path, " "), "schedule.", ""); got != c.path {
+			t.Errorf("+%v: path %s, want %s", c.left, got, c.path)
+		}
+		if late < 0 || late > time.Second {
+			t.Errorf("+%v: delivered %v late", c.left, late)
+		}
+	}
+}
+
+func TestSendAtDue(t *testing.T) {
+	cp := &captureProducer{}
+	p := NewProducer(cp)
+	m := &sarama.ProducerMessage{Topic: "events.v1", Value: sarama.StringEncoder("now")}
+	if _, _, err := p.SendAt(m, time.Now().Add(-time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if m.Topic != "events.v1" || len(cp.sent) != 1 {
+		t.Fatalf("sent to %q", m.Topic)
+	}
+	if _, _, err := p.SendAt(&sarama.ProducerMessage{Value: sarama.StringEncoder("x")}, time.Now()); err == nil {
+		t.Error("record without topic accepted")
+	}
+}
+
+func TestParseRejects(t *testing.T) {
+	h := func(k, v string) *sarama.RecordHeader { return &sarama.RecordHeader{Key: []byte(k), Value: []byte(v)} }
+	for _, msg := range []*sarama.ConsumerMessage{
+		{},
+		{Headers: []*sarama.RecordHeader{h(HeaderDeliverAt, "soon"), h(HeaderDeliverTo, "events.v1")}},
+		{Headers: []*sarama.RecordHeader{h(HeaderDeliverAt, "1700000000000")}},
+	} {
+		if _, err := Parse(msg); err == nil {
+			t.Errorf("%v: want error", msg.Headers)
+		}
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	m := NewMetrics()
+	m.Hopped("schedule.10s")
+	m.Delivered("events.v1", 300*time.Millisecond, 4)
+	m.Delivered("events.v1", 3*time.Second, 2)
+	m.Failed("schedule.1s")
+	w := httptest.NewRecorder()
+	m.ServeHTTP(w, nil)
+	for _, line := range []string{
+		`schedule_hops_total{level="schedule.10s"} 1`,
+		`schedule_delivered_total{topic="events.v1"} 2`,
+		`schedule_forward_failures_total{level="schedule.1s"} 1`,
+		`schedule_lateness_seconds_bucket{le="0.25"} 0`,
+		`schedule_lateness_seconds_bucket{le="0.5"} 1`,
+		`schedule_lateness_seconds_bucket{le="5"} 2`,
+		`schedule_lateness_seconds_bucket{le="+Inf"} 2`,
+		`schedule_lateness_seconds_count 2`,
+		`schedule_max_hops 4`,
+	} {
+		if !strings.Contains(w.Body.String(), line+"\n") {
+			t.Errorf("missing %s in\n%s", line, w.Body.String())
+		}
+	}
+}
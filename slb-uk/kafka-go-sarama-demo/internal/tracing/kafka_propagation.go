@@ -1,9 +1,11 @@
 package tracing
 
 import (
+	"context"
 	"strings"
 
 	"github.com/IBM/sarama"
+	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/propagation"
 )
 
@@ -43,3 +45,9 @@ func ExtractContext(headers *[]*sarama.RecordHeader, propagator propagation.Text
 	// Carrier returned for potential further use.
 	return nil, HeaderCarrier{Headers: headers}
 }
+
+// ContextFromMessage returns ctx carrying the span context propagated in
+// msg's headers (by otelsarama or an upstream producer).
+func ContextFromMessage(ctx context.Context, msg *sarama.ConsumerMessage) context.Context {
+	return otel.GetTextMapPropagator().Extract(ctx, HeaderCarrier{Headers: &msg.Headers})
+}
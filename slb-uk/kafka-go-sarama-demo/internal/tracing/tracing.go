@@ -2,7 +2,7 @@ package tracing
 
 import (
 	"context"
-	"log"
+	"log/slog"
 	"os"
 
 	"go.opentelemetry.io/otel"
@@ -44,6 +44,6 @@ func Init(serviceName string) (func(context.Context) error, error) {
 	otel.SetTracerProvider(tp)
 	otel.SetTextMapPropagator(propagation.TraceContext{})
 
-	log.Printf("OTEL initialized for service=%s -> %s", serviceName, endpoint)
+	slog.Info("otel initialized", "endpoint", endpoint)
 	return tp.Shutdown, nil
 }
@@ -0,0 +1,401 @@
+// Package transform rewrites records on their way through cmd/replay, e.g.
+// to fix a bad field in events that went to the DLQ before replaying them.
+//
+// A transform is either a small jq-like expression over the JSON value:
+//
+//	.                          identity
+//	.status = "retry"          set a field (the right side is JSON or a path)
+//	.customer.id = .user_id    copy a field
+//	del(.debug)                remove a field
+//	select(.type == "order")   keep only matching records (also !=)
+//
+// joined with |, e.g. `select(.v == 1) | .v = 2 | del(.legacy)`; or a Go
+// plugin, "plugin:./fix.so", exporting
+//
+//	func Transform(r *transform.Record) (keep bool, err error)
+//
+// built with `go build -buildmode=plugin` against this module.
+package transform
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"plugin"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+// Record is what a transform sees and may modify in place.
+type Record struct {
+	Key       []byte
+	Value     []byte
+	Headers   []sarama.RecordHeader
+	Timestamp time.Time
+}
+
+// Func rewrites r. keep=false drops the record.
+type Func func(r *Record) (keep bool, err error)
+
+// Identity keeps every record unchanged.
+func Identity(*Record) (bool, error) { return true, nil }
+
+// Load returns the transform described by spec: "" for Identity,
+// "plugin:<path>" for a Go plugin, otherwise an expression.
+func Load(spec string) (Func, error) {
+	spec = strings.TrimSpace(spec)
+	switch {
+	case spec == "":
+		return Identity, nil
+	case strings.HasPrefix(spec, "plugin:"):
+		return loadPlugin(strings.TrimPrefix(spec, "plugin:"))
+	default:
+		return Parse(spec)
+	}
+}
+
+func loadPlugin(path string) (Func, error) {
+	p, err := plugin.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	sym, err := p.Lookup("Transform")
+	if err != nil {
+		return nil, err
+	}
+	switch f := sym.(type) {
+	case func(*Record) (bool, error):
+		return f, nil
+	case *func(*Record) (bool, error): // exported variable instead of a function
+		return *f, nil
+	}
+	return nil, fmt.Errorf("%s: Transform is %T, want func(*transform.Record) (bool, error)", path, sym)
+}
+
+// stage is one |-separated part of an expression. It returns the new
+// document, or keep=false to drop the record.
+type stage func(doc any) (out any, keep bool, err error)
+
+// Parse compiles an expression. Values that are not JSON fail every
+// expression except ".".
+func Parse(expr string) (Func, error) {
+	var stages []stage
+	for _, part := range splitTop(expr, '|') {
+		s, err := parseStage(strings.TrimSpace(part))
+		if err != nil {
+			return nil, fmt.Errorf("transform %q: %w", part, err)
+		}
+		if s != nil {
+			stages = append(stages, s)
+		}
+	}
+	if len(stages) == 0 {
+		return Identity, nil
+	}
+	return func(r *Record) (bool, error) {
+		var doc any
+		dec := json.NewDecoder(bytes.NewReader(r.Value))
+		dec.UseNumber() // keep large integers exact
+		if err := dec.Decode(&doc); err != nil {
+			return false, fmt.Errorf("value is not JSON: %w", err)
+		}
+		for _, s := range stages {
+			var keep bool
+			var err error
+			if doc, keep, err = s(doc); err != nil || !keep {
+				return keep, err
+			}
+		}
+		b, err := json.Marshal(doc)
+		if err != nil {
+			return false, err
+		}
+		r.Value = b
+		return true, nil
+	}, nil
+}
+
+func parseStage(s string) (stage, error) {
+	switch {
+	case s == ".":
+		return nil, nil
+	case strings.HasPrefix(s, "del(") && strings.HasSuffix(s, ")"):
+		p, err := parsePath(strings.TrimSpace(s[4 : len(s)-1]))
+		if err != nil {
+			return nil, err
+		}
+		if len(p) == 0 {
+			return nil, errors.New("cannot delete the whole value")
+		}
+		return func(doc any) (any, bool, error) { return del(doc, p), true, nil }, nil
+	case strings.HasPrefix(s, "select(") && strings.HasSuffix(s, ")"):
+		return parseSelect(strings.TrimSpace(s[7 : len(s)-1]))
+	}
+	lhs, rhs, ok := cutTop(s, "=")
+	if !ok {
+		return nil, errors.New(`want ".", "path = value", "del(path)" or "select(path == value)"`)
+	}
+	p, err := parsePath(strings.TrimSpace(lhs))
+	if err != nil {
+		return nil, err
+	}
+	val, err := parseOperand(strings.TrimSpace(rhs))
+	if err != nil {
+		return nil, err
+	}
+	return func(doc any) (any, bool, error) {
+		v, _ := val(doc)
+		out, err := set(doc, p, v)
+		return out, true, err
+	}, nil
+}
+
+func parseSelect(s string) (stage, error) {
+	op := "=="
+	lhs, rhs, ok := cutTop(s, "==")
+	if !ok {
+		op = "!="
+		if lhs, rhs, ok = cutTop(s, "!="); !ok {
+			return nil, errors.New("select needs == or !=")
+		}
+	}
+	left, err := parseOperand(strings.TrimSpace(lhs))
+	if err != nil {
+		return nil, err
+	}
+	right, err := parseOperand(strings.TrimSpace(rhs))
+	if err != nil {
+		return nil, err
+	}
+	return func(doc any) (any, bool, error) {
+		a, _ := left(doc)
+		b, _ := right(doc)
+		return doc, equal(a, b) == (op == "=="), nil
+	}, nil
+}
+
+// operand yields a value for doc: a path lookup or a constant.
+type operand func(doc any) (any, bool)
+
+func parseOperand(s string) (operand, error) {
+	if strings.HasPrefix(s, ".") {
+		p, err := parsePath(s)
+		if err != nil {
+			return nil, err
+		}
+		return func(doc any) (any, bool) { return get(doc, p) }, nil
+	}
+	dec := json.NewDecoder(strings.NewReader(s))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil, fmt.Errorf("%q is neither a path nor JSON", s)
+	}
+	if dec.More() {
+		return nil, fmt.Errorf("trailing data after %q", s)
+	}
+	return func(any) (any, bool) { return v, true }, nil
+}
+
+// A path is a list of object keys (string) and array indexes (int).
+type path []any
+
+// parsePath reads ".a.b[0]", `."odd key".c` or ".".
+func parsePath(s string) (path, error) {
+	if !strings.HasPrefix(s, ".") {
+		return nil, fmt.Errorf("path %q must start with .", s)
+	}
+	var p path
+	rest := s[1:]
+	for rest != "" {
+		switch {
+		case rest[0] == '[':
+			end := strings.IndexByte(rest, ']')
+			if end < 0 {
+				return nil, fmt.Errorf("path %q: missing ]", s)
+			}
+			i, err := strconv.Atoi(rest[1:end])
+			if err != nil || i < 0 {
+				return nil, fmt.Errorf("path %q: bad index %q", s, rest[1:end])
+			}
+			p = append(p, i)
+			rest = rest[end+1:]
+		case rest[0] == '.':
+			rest = rest[1:]
+		case rest[0] == '"':
+			key, n, err := quoted(rest)
+			if err != nil {
+				return nil, fmt.Errorf("path %q: %w", s, err)
+			}
+			p = append(p, key)
+			rest = rest[n:]
+		default:
+			end := strings.IndexAny(rest, ".[")
+			if end < 0 {
+				end = len(rest)
+			}
+			p = append(p, rest[:end])
+			rest = rest[end:]
+		}
+	}
+	return p, nil
+}
+
+// quoted decodes the JSON string at the start of s and returns its length.
+func quoted(s string) (string, int, error) {
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			v, err := strconv.Unquote(s[:i+1])
+			return v, i + 1, err
+		}
+	}
+	return "", 0, errors.New("unterminated string")
+}
+
+func get(doc any, p path) (any, bool) {
+	for _, k := range p {
+		switch k := k.(type) {
+		case string:
+			m, ok := doc.(map[string]any)
+			if !ok {
+				return nil, false
+			}
+			if doc, ok = m[k]; !ok {
+				return nil, false
+			}
+		case int:
+			a, ok := doc.([]any)
+			if !ok || k >= len(a) {
+				return nil, false
+			}
+			doc = a[k]
+		}
+	}
+	return doc, true
+}
+
+// set stores v at p, creating objects on the way like jq does.
+func set(doc any, p path, v any) (any, error) {
+	if len(p) == 0 {
+		return v, nil
+	}
+	switch k := p[0].(type) {
+	case string:
+		m, ok := doc.(map[string]any)
+		if doc == nil {
+			m, ok = map[string]any{}, true
+		}
+		if !ok {
+			return nil, fmt.Errorf("cannot set key %q on %T", k, doc)
+		}
+		child, err := set(m[k], p[1:], v)
+		if err != nil {
+			return nil, err
+		}
+		m[k] = child
+		return m, nil
+	default:
+		i := k.(int)
+		a, ok := doc.([]any)
+		if !ok || i >= len(a) {
+			return nil, fmt.Errorf("index %d out of range", i)
+		}
+		child, err := set(a[i], p[1:], v)
+		if err != nil {
+			return nil, err
+		}
+		a[i] = child
+		return a, nil
+	}
+}
+
+func del(doc any, p path) any {
+	parent, ok := get(doc, p[:len(p)-1])
+	if !ok {
+		return doc
+	}
+	switch k := p[len(p)-1].(type) {
+	case string:
+		if m, ok := parent.(map[string]any); ok {
+			delete(m, k)
+		}
+	case int:
+		if a, ok := parent.([]any); ok && k < len(a) {
+			// slices cannot shrink through the parent reference
+			out, _ := set(doc, p[:len(p)-1], append(a[:k:k], a[k+1:]...))
+			return out
+		}
+	}
+	return doc
+}
+
+// equal compares decoded JSON, treating 1 and 1.0 as the same number.
+func equal(a, b any) bool {
+	if x, ok := a.(json.Number); ok {
+		if y, ok := b.(json.Number); ok {
+			fx, err1 := x.Float64()
+			fy, err2 := y.Float64()
+			return err1 == nil && err2 == nil && fx == fy
+		}
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// splitTop splits s at sep outside of strings and parentheses.
+func splitTop(s string, sep byte) []string {
+	var parts []string
+	depth, inStr, start := 0, false, 0
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case inStr && c == '\\':
+			i++
+		case c == '"':
+			inStr = !inStr
+		case inStr:
+		case c == '(' || c == '[' || c == '{':
+			depth++
+		case c == ')' || c == ']' || c == '}':
+			depth--
+		case c == sep && depth == 0:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// cutTop is strings.Cut for the first op outside of strings and brackets.
+// A bare "=" does not match inside "==" or "!=".
+func cutTop(s, op string) (string, string, bool) {
+	depth, inStr := 0, false
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case inStr && c == '\\':
+			i++
+		case c == '"':
+			inStr = !inStr
+		case inStr:
+		case c == '(' || c == '[' || c == '{':
+			depth++
+		case c == ')' || c == ']' || c == '}':
+			depth--
+		case depth == 0 && strings.HasPrefix(s[i:], op):
+			if op == "=" && (strings.HasPrefix(s[i:], "==") || i > 0 && (s[i-1] == '!' || s[i-1] == '=')) {
+				i++
+				continue
+			}
+			return s[:i], s[i+len(op):], true
+		}
+	}
+	return s, "", false
+}
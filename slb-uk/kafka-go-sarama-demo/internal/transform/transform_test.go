@@ -0,0 +1,68 @@
+package transform
+
+import (
+	"testing"
+)
+
+func apply(t *testing.T, expr, in string) (string, bool) {
+	t.Helper()
+	f, err := Load(expr)
+	if err != nil {
+		t.Fatalf("Load(%q): %v", expr, err)
+	}
+	r := &Record{Value: []byte(in)}
+	keep, err := f(r)
+	if err != nil {
+		t.Fatalf("%q on %s: %v", expr, in, err)
+	}
+	return string(r.Value), keep
+}
+
+func TestExpressions(t *testing.T) {
+	cases := []struct{ expr, in, want string }{
+		{``, `not json`, `not json`},
+		{`.`, `{"a":1}`, `{"a":1}`},
+		{`.status = "retry"`, `{"status":"failed"}`, `{"status":"retry"}`},
+		{`.a.b = {"c":[1,2]}`, `{}`, `{"a":{"b":{"c":[1,2]}}}`},
+		{`.customer.id = .user_id | del(.user_id)`, `{"user_id":7}`, `{"customer":{"id":7}}`},
+		{`.items[1].qty = 3`, `{"items":[{"qty":1},{"qty":2}]}`, `{"items":[{"qty":1},{"qty":3}]}`},
+		{`del(.items[0])`, `{"items":[1,2,3]}`, `{"items":[2,3]}`},
+		{`."odd key" = "a|b=c"`, `{}`, `{"odd key":"a|b=c"}`},
+		{`.n = 12345678901234567890`, `{}`, `{"n":12345678901234567890}`},
+		{`select(.v == 1) | .v = 2`, `{"v":1.0}`, `{"v":2}`},
+	}
+	for _, c := range cases {
+		got, keep := apply(t, c.expr, c.in)
+		if !keep || got != c.want {
+			t.Errorf("%q on %s = %s (keep %v), want %s", c.expr, c.in, got, keep, c.want)
+		}
+	}
+}
+
+func TestSelectDrops(t *testing.T) {
+	for _, c := range []struct{ expr, in string }{
+		{`select(.type == "order")`, `{"type":"refund"}`},
+		{`select(.type != "order")`, `{"type":"order"}`},
+		{`select(.missing == 1)`, `{}`},
+	} {
+		if _, keep := apply(t, c.expr, c.in); keep {
+			t.Errorf("%q kept %s", c.expr, c.in)
+		}
+	}
+}
+
+func TestErrors(t *testing.T) {
+	for _, expr := range []string{`status = 1`, `.a = `, `.a[x] = 1`, `del(.)`, `select(.a)`, `.a = "x" "y"`} {
+		if _, err := Load(expr); err == nil {
+			t.Errorf("Load(%q): want error", expr)
+		}
+	}
+	f, _ := Load(`.a = 1`)
+	if _, err := f(&Record{Value: []byte("plain text")}); err == nil {
+		t.Error("non-JSON value: want error")
+	}
+	f, _ = Load(`.a.b = 1`)
+	if _, err := f(&Record{Value: []byte(`{"a":"str"}`)}); err == nil {
+		t.Error("set below a string: want error")
+	}
+}
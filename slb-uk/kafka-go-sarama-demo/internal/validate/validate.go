@@ -0,0 +1,293 @@
+// Package validate checks records before they are produced, so that a bad
+// record fails in the binary that built it with a typed error instead of at
+// the broker (MESSAGE_TOO_LARGE) or, worse, in every consumer downstream.
+//
+// Wrap puts a chain of Rules in front of a sarama.SyncProducer. The rules
+// run in order and the first failure rejects the record; nothing is sent.
+// The default chain (FromEnv) checks, in this order:
+//
+//   - size: the encoded record, headers included, is at most MaxBytes
+//   - headers: message-id and content-type are present and not empty
+//   - payload: the value parses as its content-type says (JSON for
+//     application/json and */*+json, UTF-8 for text/*); other content types
+//     and tombstones pass
+//
+// Records copied from a consumed record (retry, DLQ, replay, mirror) keep
+// the headers of the original; Stamp fills in the ones that records written
+// before this check existed may lack.
+//
+// Environment:
+//
+//	PRODUCE_MAX_BYTES         largest record accepted (default 1000000,
+//	                          sarama's Producer.MaxMessageBytes)
+//	PRODUCE_REQUIRED_HEADERS  comma-separated header keys, "-" for none
+//	                          (default message-id,content-type)
+//	PRODUCE_CHECK_PAYLOAD     false to skip the payload check (default true)
+package validate
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/IBM/sarama"
+)
+
+// Headers every producer in this repo sets.
+const (
+	HeaderMessageID   = "message-id"
+	HeaderContentType = "content-type"
+)
+
+// DefaultMaxBytes matches sarama's default Producer.MaxMessageBytes.
+const DefaultMaxBytes = 1000000
+
+// Sentinels wrapped by *Error, one per rule.
+var (
+	ErrTooLarge      = errors.New("record too large")
+	ErrMissingHeader = errors.New("required header missing")
+	ErrPayload       = errors.New("payload does not match content-type")
+)
+
+// Error is a record rejected by a Rule. errors.Is matches it against the
+// rule's sentinel.
+type Error struct {
+	Rule   string // "size", "headers" or "payload"
+	Topic  string
+	Reason string
+	Err    error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("validate %s: %s: %s", e.Rule, e.Topic, e.Reason)
+}
+
+func (e *Error) Unwrap() error { return e.Err }
+
+// A Rule returns a non-nil error, normally an *Error, for a record that
+// must not be produced.
+type Rule func(*sarama.ProducerMessage) error
+
+// MaxBytes rejects records whose encoded size, with record-batch overhead
+// and headers, exceeds n.
+func MaxBytes(n int) Rule {
+	return func(m *sarama.ProducerMessage) error {
+		if size := m.ByteSize(2); size > n {
+			return &Error{Rule: "size", Topic: m.Topic, Err: ErrTooLarge,
+				Reason: fmt.Sprintf("%d bytes, limit %d", size, n)}
+		}
+		return nil
+	}
+}
+
+// RequireHeaders rejects records that lack one of keys or carry it empty.
+func RequireHeaders(keys ...string) Rule {
+	return func(m *sarama.ProducerMessage) error {
+		for _, k := range keys {
+			if v, ok := Header(m, k); !ok || v == "" {
+				return &Error{Rule: "headers", Topic: m.Topic, Err: ErrMissingHeader, Reason: k}
+			}
+		}
+		return nil
+	}
+}
+
+// Payload rejects values that do not parse as their content-type.
+func Payload() Rule {
+	return func(m *sarama.ProducerMessage) error {
+		if m.Value == nil {
+			return nil // tombstone
+		}
+		ct, _ := Header(m, HeaderContentType)
+		mt, _, err := mime.ParseMediaType(ct)
+		if err != nil {
+			return nil // no content-type is the headers rule's business
+		}
+		b, err := m.Value.Encode()
+		if err != nil {
+			return &Error{Rule: "payload", Topic: m.Topic, Err: ErrPayload, Reason: "encode: " + err.Error()}
+		}
+		switch {
+		case mt == "application/json" || strings.HasSuffix(mt, "+json"):
+			if !json.Valid(b) {
+				return &Error{Rule: "payload", Topic: m.Topic, Err: ErrPayload, Reason: mt + ": invalid JSON"}
+			}
+		case strings.HasPrefix(mt, "text/"):
+			if !utf8.Valid(b) {
+				return &Error{Rule: "payload", Topic: m.Topic, Err: ErrPayload, Reason: mt + ": invalid UTF-8"}
+			}
+		}
+		return nil
+	}
+}
+
+// Config selects the rules of the default chain.
+type Config struct {
+	MaxBytes        int      // 0 disables the size rule
+	RequiredHeaders []string // empty disables the headers rule
+	CheckPayload    bool
+}
+
+// DefaultConfig is the configuration FromEnv starts from.
+func DefaultConfig() Config {
+	return Config{
+		MaxBytes:        DefaultMaxBytes,
+		RequiredHeaders: []string{HeaderMessageID, HeaderContentType},
+		CheckPayload:    true,
+	}
+}
+
+// ConfigFromEnv reads PRODUCE_MAX_BYTES, PRODUCE_REQUIRED_HEADERS and
+// PRODUCE_CHECK_PAYLOAD over DefaultConfig.
+func ConfigFromEnv() (Config, error) {
+	c := DefaultConfig()
+	if v := os.Getenv("PRODUCE_MAX_BYTES"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return c, fmt.Errorf("PRODUCE_MAX_BYTES=%q: want a non-negative integer", v)
+		}
+		c.MaxBytes = n
+	}
+	switch v := os.Getenv("PRODUCE_REQUIRED_HEADERS"); v {
+	case "":
+	case "-":
+		c.RequiredHeaders = nil
+	default:
+		c.RequiredHeaders = nil
+		for _, k := range strings.Split(v, ",") {
+			if k = strings.TrimSpace(k); k != "" {
+				c.RequiredHeaders = append(c.RequiredHeaders, k)
+			}
+		}
+	}
+	if v := os.Getenv("PRODUCE_CHECK_PAYLOAD"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return c, fmt.Errorf("PRODUCE_CHECK_PAYLOAD=%q: want true or false", v)
+		}
+		c.CheckPayload = b
+	}
+	return c, nil
+}
+
+// Rules is the chain c describes.
+func (c Config) Rules() []Rule {
+	var rules []Rule
+	if c.MaxBytes > 0 {
+		rules = append(rules, MaxBytes(c.MaxBytes))
+	}
+	if len(c.RequiredHeaders) > 0 {
+		rules = append(rules, RequireHeaders(c.RequiredHeaders...))
+	}
+	if c.CheckPayload {
+		rules = append(rules, Payload())
+	}
+	return rules
+}
+
+// Describe returns the configuration as slog key/value pairs.
+func (c Config) Describe() []any {
+	return []any{"max_bytes", c.MaxBytes, "required_headers", strings.Join(c.RequiredHeaders, ","),
+		"check_payload", c.CheckPayload}
+}
+
+// Producer is a SyncProducer that runs its rules before sending.
+type Producer struct {
+	sarama.SyncProducer
+	rules []Rule
+}
+
+// Wrap returns p with rules in front of SendMessage and SendMessages.
+func Wrap(p sarama.SyncProducer, rules ...Rule) *Producer {
+	return &Producer{SyncProducer: p, rules: rules}
+}
+
+// FromEnv wraps p with the chain configured by the environment.
+func FromEnv(p sarama.SyncProducer) (*Producer, Config, error) {
+	c, err := ConfigFromEnv()
+	if err != nil {
+		return nil, c, err
+	}
+	return Wrap(p, c.Rules()...), c, nil
+}
+
+// Check runs the rules against m and returns the first failure.
+func (p *Producer) Check(m *sarama.ProducerMessage) error {
+	for _, r := range p.rules {
+		if err := r(m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SendMessage sends m if it passes the rules. A rejected record returns the
+// rule's error and partition/offset -1.
+func (p *Producer) SendMessage(m *sarama.ProducerMessage) (int32, int64, error) {
+	if err := p.Check(m); err != nil {
+		return -1, -1, err
+	}
+	return p.SyncProducer.SendMessage(m)
+}
+
+// SendMessages sends the batch only if every record passes; otherwise
+// nothing is sent and the error is a sarama.ProducerErrors of the rejected
+// records.
+func (p *Producer) SendMessages(msgs []*sarama.ProducerMessage) error {
+	var errs sarama.ProducerErrors
+	for _, m := range msgs {
+		if err := p.Check(m); err != nil {
+			errs = append(errs, &sarama.ProducerError{Msg: m, Err: err})
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return p.SyncProducer.SendMessages(msgs)
+}
+
+// Header returns the value of the first header called key.
+func Header(m *sarama.ProducerMessage, key string) (string, bool) {
+	for _, h := range m.Headers {
+		if string(h.Key) == key {
+			return string(h.Value), true
+		}
+	}
+	return "", false
+}
+
+// Stamp adds a fresh message-id and the given content-type to m unless it
+// already has them. Copies of consumed records keep their original id.
+func Stamp(m *sarama.ProducerMessage, contentType string) {
+	setDefault(m, HeaderMessageID, NewID())
+	if contentType != "" {
+		setDefault(m, HeaderContentType, contentType)
+	}
+}
+
+// setDefault sets header key to v if it is missing or empty.
+func setDefault(m *sarama.ProducerMessage, key, v string) {
+	for i, h := range m.Headers {
+		if string(h.Key) == key {
+			if len(h.Value) == 0 {
+				m.Headers[i].Value = []byte(v)
+			}
+			return
+		}
+	}
+	m.Headers = append(m.Headers, sarama.RecordHeader{Key: []byte(key), Value: []byte(v)})
+}
+
+// NewID returns a random 128-bit message id in hex.
+func NewID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
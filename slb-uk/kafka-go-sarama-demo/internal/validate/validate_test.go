@@ -0,0 +1,161 @@
+package validate
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/IBM/sarama"
+)
+
+// recorder is a SyncProducer that remembers what reached it.
+type recorder struct {
+	sarama.SyncProducer
+	sent []*sarama.ProducerMessage
+}
+
+func (r *recorder) SendMessage(m *sarama.ProducerMessage) (int32, int64, error) {
+	r.sent = append(r.sent, m)
+	return 0, int64(len(r.sent) - 1), nil
+}
+
+func (r *recorder) SendMessages(msgs []*sarama.ProducerMessage) error {
+	r.sent = append(r.sent, msgs...)
+	return nil
+}
+
+func record(value, contentType string, stamp bool) *sarama.ProducerMessage {
+	m := &sarama.ProducerMessage{Topic: "events.v1", Key: sarama.StringEncoder("user-42"),
+		Value: sarama.StringEncoder(value)}
+	if stamp {
+		Stamp(m, contentType)
+	}
+	return m
+}
+
+func TestRules(t *testing.T) {
+	p := Wrap(&recorder{}, DefaultConfig().Rules()...)
+	tombstone := record("", "application/json", true)
+	tombstone.Value = nil
+
+	for _, tc := range []struct {
+		name string
+		msg  *sarama.ProducerMessage
+		want error
+	}{
+		{"text", record("ok: welcome", "text/plain; charset=utf-8", true), nil},
+		{"json", record(`{"id":1}`, "application/json", true), nil},
+		{"json suffix", record(`[1,2]`, "application/cloudevents+json", true), nil},
+		{"opaque", record("\xff\x00", "application/octet-stream", true), nil},
+		{"tombstone", tombstone, nil},
+		{"no headers", record("ok", "", false), ErrMissingHeader},
+		{"bad json", record(`{"id":`, "application/json", true), ErrPayload},
+		{"bad utf-8", record("\xff", "text/plain", true), ErrPayload},
+		{"too large", record(strings.Repeat("x", DefaultMaxBytes), "text/plain", true), ErrTooLarge},
+	} {
+		err := p.Check(tc.msg)
+		if !errors.Is(err, tc.want) {
+			t.Errorf("%s: got %v, want %v", tc.name, err, tc.want)
+		}
+		var ve *Error
+		if tc.want != nil && (!errors.As(err, &ve) || ve.Topic != "events.v1") {
+			t.Errorf("%s: got %#v, want an *Error for events.v1", tc.name, err)
+		}
+	}
+}
+
+func TestRequireHeadersNamesTheMissingOne(t *testing.T) {
+	m := record("ok", "", false)
+	m.Headers = []sarama.RecordHeader{{Key: []byte(HeaderMessageID), Value: []byte("1")}}
+	var ve *Error
+	if err := RequireHeaders(HeaderMessageID, HeaderContentType)(m); !errors.As(err, &ve) || ve.Reason != HeaderContentType {
+		t.Fatalf("got %v, want missing %s", err, HeaderContentType)
+	}
+}
+
+func TestProducerRejectsBeforeSending(t *testing.T) {
+	rec := &recorder{}
+	p := Wrap(rec, DefaultConfig().Rules()...)
+
+	if part, off, err := p.SendMessage(record("ok", "", false)); !errors.Is(err, ErrMissingHeader) || part != -1 || off != -1 {
+		t.Fatalf("invalid: got (%d, %d, %v)", part, off, err)
+	}
+	if _, _, err := p.SendMessage(record("ok", "text/plain", true)); err != nil {
+		t.Fatalf("valid: %v", err)
+	}
+	if len(rec.sent) != 1 {
+		t.Fatalf("%d records reached the producer, want 1", len(rec.sent))
+	}
+
+	// one bad record holds back the whole batch
+	good, bad := record("ok", "text/plain", true), record(`{`, "application/json", true)
+	err := p.SendMessages([]*sarama.ProducerMessage{good, bad})
+	var pe sarama.ProducerErrors
+	if !errors.As(err, &pe) || len(pe) != 1 || pe[0].Msg != bad || !errors.Is(pe[0].Err, ErrPayload) {
+		t.Fatalf("batch: got %v", err)
+	}
+	if len(rec.sent) != 1 {
+		t.Fatalf("batch: %d records reached the producer, want still 1", len(rec.sent))
+	}
+	if err := p.SendMessages([]*sarama.ProducerMessage{good}); err != nil || len(rec.sent) != 2 {
+		t.Fatalf("valid batch: %v, %d sent", err, len(rec.sent))
+	}
+}
+
+func TestStampKeepsExistingHeaders(t *testing.T) {
+	m := record("ok", "", false)
+	m.Headers = []sarama.RecordHeader{
+		{Key: []byte(HeaderMessageID), Value: []byte("original")},
+		{Key: []byte(HeaderContentType), Value: nil},
+	}
+	Stamp(m, "text/plain")
+	if id, _ := Header(m, HeaderMessageID); id != "original" {
+		t.Errorf("message-id %q, want the original", id)
+	}
+	if ct, _ := Header(m, HeaderContentType); ct != "text/plain" {
+		t.Errorf("content-type %q, want the empty one filled in", ct)
+	}
+	if len(m.Headers) != 2 {
+		t.Errorf("%d headers, want 2", len(m.Headers))
+	}
+
+	n := record("ok", "", false)
+	Stamp(n, "")
+	if id, _ := Header(n, HeaderMessageID); len(id) != 32 {
+		t.Errorf("fresh message-id %q, want 32 hex digits", id)
+	}
+	if _, ok := Header(n, HeaderContentType); ok {
+		t.Error("empty content-type was stamped")
+	}
+}
+
+func TestConfigFromEnv(t *testing.T) {
+	t.Setenv("PRODUCE_MAX_BYTES", "")
+	t.Setenv("PRODUCE_REQUIRED_HEADERS", "")
+	t.Setenv("PRODUCE_CHECK_PAYLOAD", "")
+	c, err := ConfigFromEnv()
+	if err != nil || c.MaxBytes != DefaultMaxBytes || len(c.RequiredHeaders) != 2 || !c.CheckPayload || len(c.Rules()) != 3 {
+		t.Fatalf("default: %+v, %v", c, err)
+	}
+
+	t.Setenv("PRODUCE_MAX_BYTES", "0")
+	t.Setenv("PRODUCE_REQUIRED_HEADERS", "-")
+	t.Setenv("PRODUCE_CHECK_PAYLOAD", "false")
+	if c, err := ConfigFromEnv(); err != nil || len(c.Rules()) != 0 {
+		t.Fatalf("all off: %+v, %v", c, err)
+	}
+
+	t.Setenv("PRODUCE_REQUIRED_HEADERS", " message-id , tenant ")
+	if c, _ := ConfigFromEnv(); strings.Join(c.RequiredHeaders, ",") != "message-id,tenant" {
+		t.Fatalf("headers: %q", c.RequiredHeaders)
+	}
+
+	for k, bad := range map[string]string{"PRODUCE_MAX_BYTES": "-1", "PRODUCE_CHECK_PAYLOAD": "maybe"} {
+		t.Run(k, func(t *testing.T) {
+			t.Setenv(k, bad)
+			if _, err := ConfigFromEnv(); err == nil {
+				t.Errorf("%s=%q: want error", k, bad)
+			}
+		})
+	}
+}
@@ -0,0 +1,33 @@
+// Command instrumentgen writes the Instrumented<Interface> decorator for an
+// interface of the package in the current directory. Run through go:generate:
+//
+//	//go:generate go run github.com/slb-uk/mockegen/cmd/instrumentgen -type Repository
+package main
+
+import (
+    "flag"
+    "log"
+    "os"
+    "strings"
+
+    "github.com/slb-uk/mockegen/internal/instrumentgen"
+)
+
+func main() {
+    typ := flag.String("type", "", "interface to decorate (required)")
+    out := flag.String("out", "", "output file (default instrumented_<type>.go)")
+    flag.Parse()
+    if *typ == "" {
+        log.Fatal("-type is required")
+    }
+    if *out == "" {
+        *out = "instrumented_" + strings.ToLower(*typ) + ".go"
+    }
+    src, err := instrumentgen.Generate(".", *typ)
+    if err != nil {
+        log.Fatal(err)
+    }
+    if err := os.WriteFile(*out, src, 0o644); err != nil {
+        log.Fatal(err)
+    }
+}
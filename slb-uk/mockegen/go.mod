@@ -3,6 +3,7 @@ module github.com/slb-uk/mockegen
 go 1.24.6
 
 require (
+	github.com/DATA-DOG/go-sqlmock v1.5.2
 	github.com/golang/mock v1.6.0
 	github.com/stretchr/testify v1.10.0
 )
@@ -0,0 +1,246 @@
+// Package instrumentgen writes an Instrumented<Interface> decorator for an
+// interface: every method that takes a context.Context first and returns an
+// error last is traced and measured, anything else is delegated as is.
+// Regenerating after the interface grows keeps the wrapper complete; the
+// compiler flags a stale one because it no longer satisfies the interface.
+package instrumentgen
+
+import (
+    "bytes"
+    "fmt"
+    "go/ast"
+    "go/format"
+    "go/parser"
+    "go/printer"
+    "go/token"
+    "os"
+    "path/filepath"
+    "strings"
+    "text/template"
+)
+
+type param struct{ Name, Type string }
+
+type method struct {
+    Name            string
+    Params, Results []param
+    Instrumented    bool // ctx first, error last
+    Variadic        bool
+}
+
+// Args is the argument list forwarding the parameters.
+func (m method) Args() string {
+    names := make([]string, len(m.Params))
+    for i, p := range m.Params {
+        names[i] = p.Name
+    }
+    s := strings.Join(names, ", ")
+    if m.Variadic {
+        s += "..."
+    }
+    return s
+}
+
+// Vars names the results r0, r1, ... with the trailing error called err.
+func (m method) Vars() string {
+    names := make([]string, len(m.Results))
+    for i := range m.Results {
+        names[i] = fmt.Sprintf("r%d", i)
+        if m.Instrumented && i == len(m.Results)-1 {
+            names[i] = "err"
+        }
+    }
+    return strings.Join(names, ", ")
+}
+
+func (m method) Signature() string {
+    ps := make([]string, len(m.Params))
+    for i, p := range m.Params {
+        ps[i] = p.Name + " " + p.Type
+    }
+    rs := make([]string, len(m.Results))
+    for i, r := range m.Results {
+        rs[i] = r.Type
+    }
+    sig := m.Name + "(" + strings.Join(ps, ", ") + ")"
+    switch len(rs) {
+    case 0:
+    case 1:
+        sig += " " + rs[0]
+    default:
+        sig += " (" + strings.Join(rs, ", ") + ")"
+    }
+    return sig
+}
+
+// Generate parses the non-test files of the package in dir and returns the
+// formatted source of the decorator for the interface named iface.
+func Generate(dir, iface string) ([]byte, error) {
+    fset := token.NewFileSet()
+    files, err := filepath.Glob(filepath.Join(dir, "*.go"))
+    if err != nil {
+        return nil, err
+    }
+    var pkg string
+    var it *ast.InterfaceType
+    var imports []*ast.ImportSpec // of the file declaring the interface
+    for _, path := range files {
+        if strings.HasSuffix(path, "_test.go") {
+            continue
+        }
+        src, err := os.ReadFile(path)
+        if err != nil {
+            return nil, err
+        }
+        if bytes.Contains(src, []byte("// Code generated by instrumentgen")) {
+            continue
+        }
+        f, err := parser.ParseFile(fset, path, src, 0)
+        if err != nil {
+            return nil, err
+        }
+        pkg = f.Name.Name
+        ast.Inspect(f, func(n ast.Node) bool {
+            if ts, ok := n.(*ast.TypeSpec); ok && ts.Name.Name == iface {
+                it, _ = ts.Type.(*ast.InterfaceType)
+                imports = f.Imports
+            }
+            return it == nil
+        })
+        if it != nil {
+            break
+        }
+    }
+    if it == nil {
+        return nil, fmt.Errorf("no interface %s in %s", iface, dir)
+    }
+
+    expr := func(e ast.Expr) string {
+        var b bytes.Buffer
+        _ = printer.Fprint(&b, fset, e)
+        return b.String()
+    }
+    var methods []method
+    for _, f := range it.Methods.List {
+        ft, ok := f.Type.(*ast.FuncType)
+        if !ok {
+            return nil, fmt.Errorf("%s: embedded interfaces are not supported", iface)
+        }
+        m := method{Name: f.Names[0].Name}
+        for _, p := range ft.Params.List {
+            typ := expr(p.Type)
+            if e, ok := p.Type.(*ast.Ellipsis); ok {
+                m.Variadic = true
+                typ = "..." + expr(e.Elt)
+            }
+            // own names, so a parameter cannot clash with r, done or err
+            for i := 0; i < max(1, len(p.Names)); i++ {
+                m.Params = append(m.Params, param{fmt.Sprintf("arg%d", len(m.Params)), typ})
+            }
+        }
+        if ft.Results != nil {
+            for _, r := range ft.Results.List {
+                for i := 0; i < max(1, len(r.Names)); i++ {
+                    m.Results = append(m.Results, param{Type: expr(r.Type)})
+                }
+            }
+        }
+        m.Instrumented = len(m.Params) > 0 && m.Params[0].Type == "context.Context" &&
+            len(m.Results) > 0 && m.Results[len(m.Results)-1].Type == "error"
+        if m.Instrumented {
+            m.Params[0].Name = "ctx"
+        }
+        methods = append(methods, m)
+    }
+
+    // keep the imports the signatures use; context and time are always needed
+    used := []string{`"context"`, `"time"`}
+    for _, spec := range imports {
+        name := strings.Trim(spec.Path.Value, `"`)
+        name = name[strings.LastIndex(name, "/")+1:]
+        if spec.Name != nil {
+            name = spec.Name.Name
+        }
+        if spec.Path.Value == `"context"` || spec.Path.Value == `"time"` {
+            continue
+        }
+        for _, m := range methods {
+            if strings.Contains(m.Signature(), name+".") {
+                used = append(used, expr(spec.Path))
+                if spec.Name != nil {
+                    used[len(used)-1] = spec.Name.Name + " " + used[len(used)-1]
+                }
+                break
+            }
+        }
+    }
+
+    var b bytes.Buffer
+    err = tmpl.Execute(&b, struct {
+        Package, Interface string
+        Imports            []string
+        Methods            []method
+    }{pkg, iface, used, methods})
+    if err != nil {
+        return nil, err
+    }
+    out, err := format.Source(b.Bytes())
+    if err != nil {
+        return nil, fmt.Errorf("format: %w\n%s", err, b.Bytes())
+    }
+    return out, nil
+}
+
+var tmpl = template.Must(template.New("decorator").Parse(`// Code generated by instrumentgen -type {{.Interface}}. DO NOT EDIT.
+
+package {{.Package}}
+
+import (
+{{- range .Imports}}
+	{{.}}
+{{- end}}
+)
+
+// Instrumented{{.Interface}} decorates a {{.Interface}}: every call runs in a
+// span named "{{.Interface}}.<Method>" and is reported to Metrics with its
+// duration and error.
+type Instrumented{{.Interface}} struct {
+	next    {{.Interface}}
+	metrics Metrics
+	tracer  Tracer
+}
+
+var _ {{.Interface}} = (*Instrumented{{.Interface}})(nil)
+
+// NewInstrumented{{.Interface}} wraps next. nil metrics or tracer disable that half.
+func NewInstrumented{{.Interface}}(next {{.Interface}}, metrics Metrics, tracer Tracer) *Instrumented{{.Interface}} {
+	if metrics == nil {
+		metrics = nopMetrics{}
+	}
+	if tracer == nil {
+		tracer = nopTracer{}
+	}
+	return &Instrumented{{.Interface}}{next: next, metrics: metrics, tracer: tracer}
+}
+
+// observe starts the span and returns the func that ends it and records the call.
+func (r *Instrumented{{.Interface}}) observe(ctx context.Context, method string) (context.Context, func(error)) {
+	ctx, end := r.tracer.Start(ctx, method)
+	start := time.Now()
+	return ctx, func(err error) {
+		r.metrics.ObserveCall(method, time.Since(start), err)
+		end(err)
+	}
+}
+{{range .Methods}}
+func (r *Instrumented{{$.Interface}}) {{.Signature}} {
+{{- if .Instrumented}}
+	ctx, done := r.observe(ctx, "{{$.Interface}}.{{.Name}}")
+	{{.Vars}} := r.next.{{.Name}}({{.Args}})
+	done(err)
+	return {{.Vars}}
+{{- else}}
+	{{if .Results}}return {{end}}r.next.{{.Name}}({{.Args}})
+{{- end}}
+}
+{{end}}`))
@@ -0,0 +1,54 @@
+package instrumentgen
+
+import (
+    "os"
+    "path/filepath"
+    "testing"
+
+    "github.com/stretchr/testify/require"
+)
+
+func TestGenerate_Signatures(t *testing.T) {
+    t.Parallel()
+    dir := t.TempDir()
+    src := `package store
+
+import (
+    "context"
+    "database/sql"
+    "io"
+)
+
+type Store interface {
+    Get(ctx context.Context, key string) ([]byte, bool, error)
+    Put(context.Context, string, ...[]byte) error
+    Tx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error)
+    Name() string
+    Reset(ctx context.Context)
+}
+
+var _ io.Reader
+`
+    require.NoError(t, os.WriteFile(filepath.Join(dir, "store.go"), []byte(src), 0o644))
+
+    out, err := Generate(dir, "Store")
+    require.NoError(t, err)
+    code := string(out)
+
+    require.Contains(t, code, "package store")
+    require.Contains(t, code, `"database/sql"`)
+    require.NotContains(t, code, `"io"`)
+    require.Contains(t, code, "r0, r1, err := r.next.Get(ctx, arg1)")
+    require.Contains(t, code, "func (r *InstrumentedStore) Put(ctx context.Context, arg1 string, arg2 ...[]byte) error {")
+    require.Contains(t, code, "err := r.next.Put(ctx, arg1, arg2...)")
+    require.Contains(t, code, `r.observe(ctx, "Store.Tx")`)
+    // no error to record: plain delegation
+    require.Contains(t, code, "return r.next.Name()")
+    require.Contains(t, code, "\tr.next.Reset(arg0)\n")
+}
+
+func TestGenerate_UnknownInterface(t *testing.T) {
+    t.Parallel()
+    _, err := Generate(t.TempDir(), "Nope")
+    require.Error(t, err)
+}
@@ -0,0 +1,107 @@
+// Package cursor makes the page tokens of the List APIs. A token is opaque
+// to clients: the position it encodes is base64url JSON followed by an
+// HMAC-SHA256 of it, so a client cannot forge or edit one, and it carries
+// an expiry so an old one cannot be replayed forever.
+//
+//    c := cursor.New(key, time.Hour)
+//    next := c.Encode(cursor.Cursor{SortKey: last.Name, LastID: last.ID})
+//    ...
+//    pos, err := c.Decode(next) // ErrInvalid or ErrExpired
+package cursor
+
+import (
+    "crypto/hmac"
+    "crypto/sha256"
+    "encoding/base64"
+    "encoding/json"
+    "errors"
+    "time"
+)
+
+var (
+    ErrInvalid = errors.New("cursor: invalid token")
+    ErrExpired = errors.New("cursor: token expired")
+)
+
+// DefaultTTL is how long a token stays valid when New is given no TTL.
+const DefaultTTL = time.Hour
+
+// MinKeyLen is the shortest HMAC key New accepts.
+const MinKeyLen = 16
+
+// Cursor is the position just after the last item of a page: that item's
+// sort key and, to order items with equal keys, its ID.
+type Cursor struct {
+    SortKey string
+    LastID  int
+}
+
+// Codec encodes and checks tokens. All replicas serving one List API must
+// share the key.
+type Codec struct {
+    key []byte
+    ttl time.Duration
+
+    // Now is the clock expiries are stamped and checked with; nil is
+    // time.Now.
+    Now func() time.Time
+}
+
+// New returns a Codec signing with key, whose tokens expire after ttl (or
+// DefaultTTL when ttl <= 0). It panics if key is shorter than MinKeyLen.
+func New(key []byte, ttl time.Duration) *Codec {
+    if len(key) < MinKeyLen {
+        panic("cursor: key shorter than MinKeyLen")
+    }
+    if ttl <= 0 {
+        ttl = DefaultTTL
+    }
+    return &Codec{key: append([]byte(nil), key...), ttl: ttl}
+}
+
+type payload struct {
+    SortKey string `json:"k,omitempty"`
+    LastID  int    `json:"id"`
+    Expires int64  `json:"exp"`
+}
+
+func (c *Codec) now() time.Time {
+    if c.Now != nil {
+        return c.Now()
+    }
+    return time.Now()
+}
+
+func (c *Codec) sign(b []byte) []byte {
+    mac := hmac.New(sha256.New, c.key)
+    mac.Write(b)
+    return mac.Sum(nil)
+}
+
+// Encode returns the token for cur, valid for the Codec's TTL from now.
+func (c *Codec) Encode(cur Cursor) string {
+    b, _ := json.Marshal(payload{SortKey: cur.SortKey, LastID: cur.LastID, Expires: c.now().Add(c.ttl).Unix()})
+    return base64.RawURLEncoding.EncodeToString(append(b, c.sign(b)...))
+}
+
+// Decode returns the position in token. A token that is malformed or was
+// not signed with this Codec's key fails with ErrInvalid, an expired one
+// with ErrExpired.
+func (c *Codec) Decode(token string) (Cursor, error) {
+    raw, err := base64.RawURLEncoding.DecodeString(token)
+    if err != nil || len(raw) <= sha256.Size {
+        return Cursor{}, ErrInvalid
+    }
+    b, sig := raw[:len(raw)-sha256.Size], raw[len(raw)-sha256.Size:]
+    if !hmac.Equal(sig, c.sign(b)) {
+        return Cursor{}, ErrInvalid
+    }
+    var p payload
+    if err := json.Unmarshal(b, &p); err != nil || p.LastID < 0 {
+        return Cursor{}, ErrInvalid
+    }
+    if !c.now().Before(time.Unix(p.Expires, 0)) {
+        return Cursor{}, ErrExpired
+    }
+    return Cursor{SortKey: p.SortKey, LastID: p.LastID}, nil
+}
@@ -0,0 +1,65 @@
+package cursor
+
+import (
+    "encoding/base64"
+    "testing"
+    "time"
+
+    "github.com/stretchr/testify/require"
+)
+
+var key = []byte("0123456789abcdef")
+
+func TestCodec_RoundTrip(t *testing.T) {
+    c := New(key, time.Minute)
+    for _, cur := range []Cursor{{LastID: 1}, {SortKey: "2024-05-01 ünïcode", LastID: 42}} {
+        got, err := c.Decode(c.Encode(cur))
+        require.NoError(t, err)
+        require.Equal(t, cur, got)
+    }
+}
+
+func TestCodec_Tampered(t *testing.T) {
+    c := New(key, time.Minute)
+    token := c.Encode(Cursor{SortKey: "a", LastID: 7})
+    raw, err := base64.RawURLEncoding.DecodeString(token)
+    require.NoError(t, err)
+
+    flipped := append([]byte(nil), raw...)
+    flipped[5] ^= 1 // inside the JSON
+    truncated := raw[:len(raw)-1]
+
+    for name, tok := range map[string]string{
+        "empty":          "",
+        "not base64":     "!!!",
+        "too short":      base64.RawURLEncoding.EncodeToString([]byte("{}")),
+        "edited":         base64.RawURLEncoding.EncodeToString(flipped),
+        "truncated":      base64.RawURLEncoding.EncodeToString(truncated),
+        "other key":      New([]byte("fedcba9876543210"), time.Minute).Encode(Cursor{SortKey: "a", LastID: 7}),
+        "forged payload": base64.RawURLEncoding.EncodeToString(append([]byte(`{"id":1,"exp":9999999999}`), make([]byte, 32)...)),
+    } {
+        t.Run(name, func(t *testing.T) {
+            _, err := c.Decode(tok)
+            require.ErrorIs(t, err, ErrInvalid)
+        })
+    }
+}
+
+func TestCodec_Expired(t *testing.T) {
+    now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+    c := New(key, time.Minute)
+    c.Now = func() time.Time { return now }
+    token := c.Encode(Cursor{LastID: 3})
+
+    now = now.Add(59 * time.Second)
+    _, err := c.Decode(token)
+    require.NoError(t, err)
+
+    now = now.Add(time.Second)
+    _, err = c.Decode(token)
+    require.ErrorIs(t, err, ErrExpired)
+}
+
+func TestNew_ShortKey(t *testing.T) {
+    require.Panics(t, func() { New([]byte("short"), 0) })
+}
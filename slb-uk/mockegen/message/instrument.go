@@ -0,0 +1,107 @@
+package message
+
+import (
+    "context"
+    "encoding/json"
+    "log/slog"
+    "sync"
+    "time"
+)
+
+//go:generate go run github.com/slb-uk/mockegen/cmd/instrumentgen -type Repository
+
+// Metrics receives one observation per decorated call. Method is
+// "<Interface>.<Method>", e.g. "Repository.Create".
+type Metrics interface {
+    ObserveCall(method string, d time.Duration, err error)
+}
+
+// Tracer starts a span around a decorated call; end receives its error.
+// An OpenTelemetry adapter is three lines around otel.Tracer(...).Start.
+type Tracer interface {
+    Start(ctx context.Context, name string) (_ context.Context, end func(err error))
+}
+
+type nopMetrics struct{}
+
+func (nopMetrics) ObserveCall(string, time.Duration, error) {}
+
+type nopTracer struct{}
+
+func (nopTracer) Start(ctx context.Context, _ string) (context.Context, func(error)) {
+    return ctx, func(error) {}
+}
+
+// MethodStats are the totals CallStats keeps per method.
+type MethodStats struct {
+    Calls  int           `json:"calls"`
+    Errors int           `json:"errors"`
+    Total  time.Duration `json:"total_ns"`
+    Max    time.Duration `json:"max_ns"`
+}
+
+// CallStats is an in-memory Metrics. It is an expvar.Var, so
+// expvar.Publish("repository", stats) shows it on /debug/vars.
+type CallStats struct {
+    mu      sync.Mutex
+    methods map[string]MethodStats
+}
+
+func NewCallStats() *CallStats { return &CallStats{methods: map[string]MethodStats{}} }
+
+func (s *CallStats) ObserveCall(method string, d time.Duration, err error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    m := s.methods[method]
+    m.Calls++
+    if err != nil {
+        m.Errors++
+    }
+    m.Total += d
+    m.Max = max(m.Max, d)
+    s.methods[method] = m
+}
+
+// Snapshot returns a copy of the totals.
+func (s *CallStats) Snapshot() map[string]MethodStats {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    out := make(map[string]MethodStats, len(s.methods))
+    for k, v := range s.methods {
+        out[k] = v
+    }
+    return out
+}
+
+func (s *CallStats) String() string {
+    b, _ := json.Marshal(s.Snapshot())
+    return string(b)
+}
+
+// SlogTracer logs one line per span with its parent, so nested decorated
+// calls (a service over an instrumented repository) read as a tree.
+type SlogTracer struct {
+    Logger *slog.Logger
+}
+
+type spanKey struct{}
+
+func (t SlogTracer) Start(ctx context.Context, name string) (context.Context, func(error)) {
+    parent, _ := ctx.Value(spanKey{}).(string)
+    start := time.Now()
+    return context.WithValue(ctx, spanKey{}, name), func(err error) {
+        l := t.Logger
+        if l == nil {
+            l = slog.Default()
+        }
+        attrs := []any{"span", name, "dur", time.Since(start)}
+        if parent != "" {
+            attrs = append(attrs, "parent", parent)
+        }
+        if err != nil {
+            l.Error("span", append(attrs, "error", err)...)
+            return
+        }
+        l.Info("span", attrs...)
+    }
+}
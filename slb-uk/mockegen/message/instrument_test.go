@@ -0,0 +1,102 @@
+package message
+
+import (
+    "bytes"
+    "context"
+    "errors"
+    "log/slog"
+    "os"
+    "strings"
+    "testing"
+
+    gomock "github.com/golang/mock/gomock"
+    "github.com/stretchr/testify/require"
+
+    "github.com/slb-uk/mockegen/internal/instrumentgen"
+)
+
+type recordingTracer struct {
+    spans []string
+    errs  []error
+}
+
+func (t *recordingTracer) Start(ctx context.Context, name string) (context.Context, func(error)) {
+    t.spans = append(t.spans, name)
+    return ctx, func(err error) { t.errs = append(t.errs, err) }
+}
+
+func TestInstrumentedRepository(t *testing.T) {
+    t.Parallel()
+    ctrl := gomock.NewController(t)
+    defer ctrl.Finish()
+    ctx := context.Background()
+
+    mockRepo := NewMockRepository(ctrl)
+    stats := NewCallStats()
+    tracer := &recordingTracer{}
+    svc := NewService(NewInstrumentedRepository(mockRepo, stats, tracer))
+
+    dbDown := errors.New("db down")
+    mockRepo.EXPECT().Create(gomock.Any(), Message{Content: "hello"}).Return(Message{ID: 1, Content: "hello"}, nil)
+    mockRepo.EXPECT().GetByID(gomock.Any(), 1).Return(Message{}, dbDown)
+    mockRepo.EXPECT().Delete(gomock.Any(), 1).Return(nil)
+
+    m, err := svc.Create(ctx, "hello")
+    require.NoError(t, err)
+    require.Equal(t, 1, m.ID)
+    _, err = svc.Get(ctx, 1)
+    require.ErrorIs(t, err, dbDown)
+    require.NoError(t, svc.Delete(ctx, 1))
+
+    require.Equal(t, []string{"Repository.Create", "Repository.GetByID", "Repository.Delete"}, tracer.spans)
+    require.Equal(t, []error{nil, dbDown, nil}, tracer.errs)
+
+    snap := stats.Snapshot()
+    require.Equal(t, 1, snap["Repository.Create"].Calls)
+    require.Equal(t, 0, snap["Repository.Create"].Errors)
+    require.Equal(t, 1, snap["Repository.GetByID"].Errors)
+    require.NotContains(t, snap, "Repository.Update")
+    require.Contains(t, stats.String(), `"Repository.Delete":{"calls":1`)
+}
+
+func TestInstrumentedRepository_NilObservers(t *testing.T) {
+    t.Parallel()
+    ctrl := gomock.NewController(t)
+    defer ctrl.Finish()
+
+    mockRepo := NewMockRepository(ctrl)
+    mockRepo.EXPECT().Update(gomock.Any(), Message{ID: 2, Content: "x"}).Return(Message{ID: 2, Content: "x"}, nil)
+
+    got, err := NewInstrumentedRepository(mockRepo, nil, nil).Update(context.Background(), Message{ID: 2, Content: "x"})
+    require.NoError(t, err)
+    require.Equal(t, 2, got.ID)
+}
+
+func TestSlogTracer_NestedSpans(t *testing.T) {
+    t.Parallel()
+    var buf bytes.Buffer
+    tracer := SlogTracer{Logger: slog.New(slog.NewTextHandler(&buf, nil))}
+
+    ctx, endOuter := tracer.Start(context.Background(), "Service.Create")
+    _, endInner := tracer.Start(ctx, "Repository.Create")
+    endInner(errors.New("boom"))
+    endOuter(nil)
+
+    lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+    require.Len(t, lines, 2)
+    require.Contains(t, lines[0], "span=Repository.Create")
+    require.Contains(t, lines[0], "parent=Service.Create")
+    require.Contains(t, lines[0], "error=boom")
+    require.NotContains(t, lines[1], "parent=")
+}
+
+// The checked-in decorator must match the interface; run go generate after
+// changing Repository.
+func TestInstrumentedRepository_UpToDate(t *testing.T) {
+    t.Parallel()
+    want, err := instrumentgen.Generate(".", "Repository")
+    require.NoError(t, err)
+    got, err := os.ReadFile("instrumented_repository.go")
+    require.NoError(t, err)
+    require.Equal(t, string(want), string(got), "run go generate ./message")
+}
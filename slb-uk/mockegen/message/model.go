@@ -1,6 +1,13 @@
 package message
 
+import "time"
+
 type Message struct {
     ID      int
     Content string
-}
\ No newline at end of file
+    // DeletedAt is set while the message is soft-deleted; Restore clears it.
+    DeletedAt *time.Time
+}
+
+// Deleted reports whether the message is soft-deleted.
+func (m Message) Deleted() bool { return m.DeletedAt != nil }
\ No newline at end of file
@@ -2,9 +2,15 @@ package message
 
 import "context"
 
+// Repository stores messages. Delete is a soft delete: GetByID still finds
+// the message, with DeletedAt set, until Restore brings it back. Delete only
+// sees live messages and Restore only deleted ones; otherwise they return
+// ErrNotFound.
 type Repository interface {
     Create(ctx context.Context, m Message) (Message, error)
     GetByID(ctx context.Context, id int) (Message, error)
     Update(ctx context.Context, m Message) (Message, error)
     Delete(ctx context.Context, id int) error
+    Restore(ctx context.Context, id int) (Message, error)
+    ListDeleted(ctx context.Context) ([]Message, error)
 }
\ No newline at end of file
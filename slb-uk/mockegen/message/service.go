@@ -2,34 +2,113 @@ package message
 
 import (
 	"context"
+	"crypto/rand"
 	"errors"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+
+	"github.com/slb-uk/mockegen/message/cursor"
 )
 
 var (
     ErrEmptyContent = errors.New("content cannot be empty")
     ErrInvalidID    = errors.New("id must be > 0")
+    ErrDeleted      = errors.New("message is deleted")
+    ErrNotDeleted   = errors.New("message is not deleted")
 )
 
 type Service struct {
-    repo Repository
+    repo    Repository
+    uow     UnitOfWork
+    cursors *cursor.Codec
 }
 
 func NewService(r Repository) *Service { return &Service{repo: r} }
 
+// NewTransactionalService runs every operation in a unit of work; writes
+// also append to the audit log, and either both land or neither does.
+func NewTransactionalService(u UnitOfWork) *Service { return &Service{uow: u} }
+
+// WithCursors sets the codec of the List APIs' page tokens. Without one the
+// Service signs with a key made up at startup, so its tokens only work in
+// this process.
+func (s *Service) WithCursors(c *cursor.Codec) *Service {
+    s.cursors = c
+    return s
+}
+
+var processCursors = sync.OnceValue(func() *cursor.Codec {
+    key := make([]byte, 32)
+    _, _ = rand.Read(key)
+    return cursor.New(key, cursor.DefaultTTL)
+})
+
+func (s *Service) cursorCodec() *cursor.Codec {
+    if s.cursors != nil {
+        return s.cursors
+    }
+    return processCursors()
+}
+
+// run hands fn the repositories for one operation. Without a unit of work
+// there is no audit repository.
+func (s *Service) run(ctx context.Context, fn func(Repos) error) error {
+    if s.uow == nil {
+        return fn(Repos{Messages: s.repo})
+    }
+    return s.uow.Do(ctx, fn)
+}
+
+func audit(ctx context.Context, r Repos, id int, action string) error {
+    if r.Audit == nil {
+        return nil
+    }
+    return r.Audit.Append(ctx, AuditEntry{MessageID: id, Action: action})
+}
+
 func (s *Service) Create(ctx context.Context, content string) (Message, error) {
     content = strings.TrimSpace(content)
     if content == "" {
         return Message{}, ErrEmptyContent
     }
-    return s.repo.Create(ctx, Message{Content: content})
+    var out Message
+    err := s.run(ctx, func(r Repos) error {
+        m, err := r.Messages.Create(ctx, Message{Content: content})
+        if err != nil {
+            return err
+        }
+        out = m
+        return audit(ctx, r, m.ID, "create")
+    })
+    if err != nil {
+        return Message{}, err
+    }
+    return out, nil
 }
 
+// Get returns a live message; a soft-deleted one fails with ErrDeleted.
 func (s *Service) Get(ctx context.Context, id int) (Message, error) {
     if id <= 0 {
         return Message{}, ErrInvalidID
     }
-    return s.repo.GetByID(ctx, id)
+    var out Message
+    err := s.run(ctx, func(r Repos) error {
+        m, err := r.Messages.GetByID(ctx, id)
+        if err != nil {
+            return err
+        }
+        if m.Deleted() {
+            return ErrDeleted
+        }
+        out = m
+        return nil
+    })
+    if err != nil {
+        return Message{}, err
+    }
+    return out, nil
 }
 
 func (s *Service) Update(ctx context.Context, id int, content string) (Message, error) {
@@ -40,12 +119,151 @@ func (s *Service) Update(ctx context.Context, id int, content string) (Message,
     if content == "" {
         return Message{}, ErrEmptyContent
     }
-    return s.repo.Update(ctx, Message{ID: id, Content: content})
+    var out Message
+    err := s.run(ctx, func(r Repos) error {
+        cur, err := r.Messages.GetByID(ctx, id)
+        if err != nil {
+            return err
+        }
+        if cur.Deleted() {
+            return ErrDeleted
+        }
+        m, err := r.Messages.Update(ctx, Message{ID: id, Content: content})
+        if err != nil {
+            return err
+        }
+        out = m
+        return audit(ctx, r, id, "update")
+    })
+    if err != nil {
+        return Message{}, err
+    }
+    return out, nil
 }
 
 func (s *Service) Delete(ctx context.Context, id int) error {
     if id <= 0 {
         return ErrInvalidID
     }
-    return s.repo.Delete(ctx, id)
+    return s.run(ctx, func(r Repos) error {
+        if err := r.Messages.Delete(ctx, id); err != nil {
+            return err
+        }
+        return audit(ctx, r, id, "delete")
+    })
+}
+
+// Restore undoes a soft delete; a message that is not deleted fails with
+// ErrNotDeleted.
+func (s *Service) Restore(ctx context.Context, id int) (Message, error) {
+    if id <= 0 {
+        return Message{}, ErrInvalidID
+    }
+    var out Message
+    err := s.run(ctx, func(r Repos) error {
+        cur, err := r.Messages.GetByID(ctx, id)
+        if err != nil {
+            return err
+        }
+        if !cur.Deleted() {
+            return ErrNotDeleted
+        }
+        m, err := r.Messages.Restore(ctx, id)
+        if err != nil {
+            return err
+        }
+        out = m
+        return audit(ctx, r, id, "restore")
+    })
+    if err != nil {
+        return Message{}, err
+    }
+    return out, nil
+}
+
+// ListDeleted returns the soft-deleted messages by ID.
+func (s *Service) ListDeleted(ctx context.Context) ([]Message, error) {
+    var out []Message
+    err := s.run(ctx, func(r Repos) error {
+        ms, err := r.Messages.ListDeleted(ctx)
+        out = ms
+        return err
+    })
+    if err != nil {
+        return nil, err
+    }
+    return out, nil
+}
+
+const (
+    DefaultPageSize = 50
+    MaxPageSize     = 500
+)
+
+// Page is one page of a List API. Next is the token of the following page,
+// "" on the last one.
+type Page struct {
+    Messages []Message
+    Next     string
+}
+
+// ListDeletedPage pages through the soft-deleted messages, oldest deletion
+// first. after is the Next of the previous page, "" for the first; a token
+// that was tampered with fails with cursor.ErrInvalid and an old one with
+// cursor.ErrExpired. limit <= 0 means DefaultPageSize and is capped at
+// MaxPageSize.
+func (s *Service) ListDeletedPage(ctx context.Context, after string, limit int) (Page, error) {
+    if limit <= 0 {
+        limit = DefaultPageSize
+    }
+    if limit > MaxPageSize {
+        limit = MaxPageSize
+    }
+    codec := s.cursorCodec()
+    var from struct {
+        deletedAt int64
+        id        int
+    }
+    if after != "" {
+        cur, err := codec.Decode(after)
+        if err != nil {
+            return Page{}, err
+        }
+        if from.deletedAt, err = strconv.ParseInt(cur.SortKey, 10, 64); err != nil {
+            return Page{}, cursor.ErrInvalid
+        }
+        from.id = cur.LastID
+    }
+
+    deleted, err := s.ListDeleted(ctx)
+    if err != nil {
+        return Page{}, err
+    }
+    // deletion time, then ID; a message deleted later can only land at the
+    // end, so paging is not disturbed by deletes in between
+    var ms []Message
+    for _, m := range deleted {
+        if m.Deleted() {
+            ms = append(ms, m)
+        }
+    }
+    sort.Slice(ms, func(i, j int) bool {
+        a, b := ms[i].DeletedAt.UnixNano(), ms[j].DeletedAt.UnixNano()
+        return a < b || a == b && ms[i].ID < ms[j].ID
+    })
+    if after != "" {
+        ms = ms[sort.Search(len(ms), func(i int) bool {
+            at := ms[i].DeletedAt.UnixNano()
+            return at > from.deletedAt || at == from.deletedAt && ms[i].ID > from.id
+        }):]
+    }
+
+    var p Page
+    if len(ms) > limit {
+        ms = ms[:limit]
+        last := ms[limit-1]
+        p.Next = codec.Encode(cursor.Cursor{SortKey: strconv.FormatInt(last.DeletedAt.UnixNano(), 10), LastID: last.ID})
+    }
+    p.Messages = ms
+    return p, nil
 }
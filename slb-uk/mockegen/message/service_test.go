@@ -5,9 +5,12 @@ import (
 	"context"
 	"errors"
 	"testing"
+	"time"
 
 	gomock "github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/require"
+
+	"github.com/slb-uk/mockegen/message/cursor"
 )
 
 func TestService_Create(t *testing.T) {
@@ -114,6 +117,11 @@ func TestService_Update(t *testing.T) {
     t.Run("success", func(t *testing.T) {
         want := Message{ID: 3, Content: "updated"}
 
+        mockRepo.
+            EXPECT().
+            GetByID(gomock.Any(), 3).
+            Return(Message{ID: 3, Content: "old"}, nil).
+            Times(1)
         mockRepo.
             EXPECT().
             Update(gomock.Any(), Message{ID: 3, Content: "updated"}).
@@ -126,6 +134,11 @@ func TestService_Update(t *testing.T) {
     })
 
     t.Run("repo error", func(t *testing.T) {
+        mockRepo.
+            EXPECT().
+            GetByID(gomock.Any(), 5).
+            Return(Message{ID: 5, Content: "x"}, nil).
+            Times(1)
         mockRepo.
             EXPECT().
             Update(gomock.Any(), Message{ID: 5, Content: "xxx"}).
@@ -135,6 +148,18 @@ func TestService_Update(t *testing.T) {
         _, err := svc.Update(ctx, 5, "xxx")
         require.EqualError(t, err, "conflict")
     })
+
+    t.Run("deleted message", func(t *testing.T) {
+        deletedAt := time.Now()
+        mockRepo.
+            EXPECT().
+            GetByID(gomock.Any(), 6).
+            Return(Message{ID: 6, Content: "gone", DeletedAt: &deletedAt}, nil).
+            Times(1)
+
+        _, err := svc.Update(ctx, 6, "again")
+        require.ErrorIs(t, err, ErrDeleted)
+    })
 }
 
 func TestService_Delete(t *testing.T) {
@@ -173,3 +198,180 @@ func TestService_Delete(t *testing.T) {
         require.EqualError(t, err, "foreign key")
     })
 }
+
+func TestService_SoftDeleteLifecycle(t *testing.T) {
+    t.Parallel()
+    ctrl := gomock.NewController(t)
+    defer ctrl.Finish()
+
+    mockRepo := NewMockRepository(ctrl)
+    svc := NewService(mockRepo)
+    ctx := context.Background()
+
+    live := Message{ID: 4, Content: "hi"}
+    deletedAt := time.Now()
+    deleted := Message{ID: 4, Content: "hi", DeletedAt: &deletedAt}
+
+    // delete -> hidden from Get -> listed -> restore -> visible again
+    gomock.InOrder(
+        mockRepo.EXPECT().Delete(gomock.Any(), 4).Return(nil),
+        mockRepo.EXPECT().GetByID(gomock.Any(), 4).Return(deleted, nil),
+        mockRepo.EXPECT().ListDeleted(gomock.Any()).Return([]Message{deleted}, nil),
+        mockRepo.EXPECT().GetByID(gomock.Any(), 4).Return(deleted, nil),
+        mockRepo.EXPECT().Restore(gomock.Any(), 4).Return(live, nil),
+        mockRepo.EXPECT().GetByID(gomock.Any(), 4).Return(live, nil),
+    )
+
+    require.NoError(t, svc.Delete(ctx, 4))
+    _, err := svc.Get(ctx, 4)
+    require.ErrorIs(t, err, ErrDeleted)
+    got, err := svc.ListDeleted(ctx)
+    require.NoError(t, err)
+    require.Equal(t, []Message{deleted}, got)
+    m, err := svc.Restore(ctx, 4)
+    require.NoError(t, err)
+    require.Equal(t, live, m)
+    m, err = svc.Get(ctx, 4)
+    require.NoError(t, err)
+    require.False(t, m.Deleted())
+}
+
+func TestService_Restore(t *testing.T) {
+    t.Parallel()
+    ctrl := gomock.NewController(t)
+    defer ctrl.Finish()
+
+    mockRepo := NewMockRepository(ctrl)
+    svc := NewService(mockRepo)
+    ctx := context.Background()
+
+    t.Run("invalid id", func(t *testing.T) {
+        _, err := svc.Restore(ctx, 0)
+        require.ErrorIs(t, err, ErrInvalidID)
+    })
+
+    t.Run("not deleted", func(t *testing.T) {
+        mockRepo.EXPECT().GetByID(gomock.Any(), 2).Return(Message{ID: 2, Content: "live"}, nil)
+
+        _, err := svc.Restore(ctx, 2)
+        require.ErrorIs(t, err, ErrNotDeleted)
+    })
+
+    t.Run("missing", func(t *testing.T) {
+        mockRepo.EXPECT().GetByID(gomock.Any(), 3).Return(Message{}, ErrNotFound)
+
+        _, err := svc.Restore(ctx, 3)
+        require.ErrorIs(t, err, ErrNotFound)
+    })
+
+    t.Run("repo error", func(t *testing.T) {
+        deletedAt := time.Now()
+        mockRepo.EXPECT().GetByID(gomock.Any(), 5).Return(Message{ID: 5, DeletedAt: &deletedAt}, nil)
+        mockRepo.EXPECT().Restore(gomock.Any(), 5).Return(Message{}, errors.New("db down"))
+
+        _, err := svc.Restore(ctx, 5)
+        require.EqualError(t, err, "db down")
+    })
+}
+
+func TestService_ListDeleted(t *testing.T) {
+    t.Parallel()
+    ctrl := gomock.NewController(t)
+    defer ctrl.Finish()
+
+    mockRepo := NewMockRepository(ctrl)
+    svc := NewService(mockRepo)
+
+    mockRepo.EXPECT().ListDeleted(gomock.Any()).Return(nil, errors.New("db down"))
+
+    _, err := svc.ListDeleted(context.Background())
+    require.EqualError(t, err, "db down")
+}
+
+func TestService_ListDeletedPage(t *testing.T) {
+    t.Parallel()
+    ctrl := gomock.NewController(t)
+    defer ctrl.Finish()
+
+    now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+    codec := cursor.New([]byte("0123456789abcdef"), time.Minute)
+    codec.Now = func() time.Time { return now }
+
+    mockRepo := NewMockRepository(ctrl)
+    svc := NewService(mockRepo).WithCursors(codec)
+    ctx := context.Background()
+
+    at := func(min int) *time.Time {
+        ts := now.Add(time.Duration(min) * time.Minute)
+        return &ts
+    }
+    // by ID, as the repository returns them; 4 and 2 were deleted together
+    deleted := []Message{
+        {ID: 1, Content: "a", DeletedAt: at(-3)},
+        {ID: 2, Content: "b", DeletedAt: at(-1)},
+        {ID: 3, Content: "c", DeletedAt: at(-5)},
+        {ID: 4, Content: "d", DeletedAt: at(-1)},
+        {ID: 5, Content: "e", DeletedAt: at(-2)},
+    }
+    mockRepo.EXPECT().ListDeleted(gomock.Any()).Return(deleted, nil).AnyTimes()
+
+    ids := func(p Page) []int {
+        var out []int
+        for _, m := range p.Messages {
+            out = append(out, m.ID)
+        }
+        return out
+    }
+
+    t.Run("pages in deletion order", func(t *testing.T) {
+        var got [][]int
+        after := ""
+        for {
+            p, err := svc.ListDeletedPage(ctx, after, 2)
+            require.NoError(t, err)
+            got = append(got, ids(p))
+            if p.Next == "" {
+                break
+            }
+            after = p.Next
+        }
+        require.Equal(t, [][]int{{3, 1}, {5, 2}, {4}}, got)
+    })
+
+    t.Run("exact last page has no next", func(t *testing.T) {
+        p, err := svc.ListDeletedPage(ctx, "", 5)
+        require.NoError(t, err)
+        require.Len(t, p.Messages, 5)
+        require.Empty(t, p.Next)
+    })
+
+    t.Run("tampered cursor", func(t *testing.T) {
+        p, err := svc.ListDeletedPage(ctx, "", 2)
+        require.NoError(t, err)
+        b := []byte(p.Next)
+        b[3] ^= 'A' ^ 'B'
+        _, err = svc.ListDeletedPage(ctx, string(b), 2)
+        require.ErrorIs(t, err, cursor.ErrInvalid)
+
+        other := cursor.New([]byte("fedcba9876543210"), time.Minute).Encode(cursor.Cursor{SortKey: "0", LastID: 1})
+        _, err = svc.ListDeletedPage(ctx, other, 2)
+        require.ErrorIs(t, err, cursor.ErrInvalid)
+
+        bad := codec.Encode(cursor.Cursor{SortKey: "not a time", LastID: 1})
+        _, err = svc.ListDeletedPage(ctx, bad, 2)
+        require.ErrorIs(t, err, cursor.ErrInvalid)
+    })
+
+    t.Run("expired cursor", func(t *testing.T) {
+        expiring := cursor.New([]byte("0123456789abcdef"), time.Minute)
+        clock := now
+        expiring.Now = func() time.Time { return clock }
+        svc := NewService(mockRepo).WithCursors(expiring)
+
+        p, err := svc.ListDeletedPage(ctx, "", 2)
+        require.NoError(t, err)
+        clock = clock.Add(2 * time.Minute)
+        _, err = svc.ListDeletedPage(ctx, p.Next, 2)
+        require.ErrorIs(t, err, cursor.ErrExpired)
+    })
+}
@@ -0,0 +1,33 @@
+package message
+
+import (
+    "context"
+    "errors"
+)
+
+var ErrNotFound = errors.New("message not found")
+
+// AuditEntry records a write to a message; it is a second aggregate that
+// must change together with the message it describes.
+type AuditEntry struct {
+    MessageID int
+    Action    string
+}
+
+type AuditRepository interface {
+    Append(ctx context.Context, e AuditEntry) error
+}
+
+// Repos are the repositories bound to one unit of work. They are only valid
+// inside the function passed to Do.
+type Repos struct {
+    Messages Repository
+    Audit    AuditRepository
+}
+
+// UnitOfWork runs fn against repositories sharing one transaction: if fn
+// returns an error (or panics) nothing it wrote is kept, otherwise
+// everything is committed together.
+type UnitOfWork interface {
+    Do(ctx context.Context, fn func(Repos) error) error
+}
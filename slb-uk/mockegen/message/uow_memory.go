@@ -0,0 +1,127 @@
+package message
+
+import (
+    "context"
+    "sort"
+    "sync"
+    "time"
+)
+
+// MemoryUnitOfWork keeps messages and the audit log in memory. Units are
+// serialized; each works on a copy of the state that replaces the original
+// only when fn succeeds.
+type MemoryUnitOfWork struct {
+    mu    sync.Mutex
+    state memoryState
+}
+
+type memoryState struct {
+    nextID   int
+    messages map[int]Message
+    audit    []AuditEntry
+}
+
+func NewMemoryUnitOfWork() *MemoryUnitOfWork {
+    return &MemoryUnitOfWork{state: memoryState{nextID: 1, messages: map[int]Message{}}}
+}
+
+func (u *MemoryUnitOfWork) Do(ctx context.Context, fn func(Repos) error) error {
+    u.mu.Lock()
+    defer u.mu.Unlock()
+
+    staged := u.state.clone()
+    if err := fn(Repos{Messages: (*memoryMessages)(staged), Audit: (*memoryAudit)(staged)}); err != nil {
+        return err
+    }
+    u.state = *staged
+    return nil
+}
+
+// Messages returns the committed messages.
+func (u *MemoryUnitOfWork) Messages() map[int]Message {
+    u.mu.Lock()
+    defer u.mu.Unlock()
+    return u.state.clone().messages
+}
+
+// AuditLog returns the committed audit entries in order.
+func (u *MemoryUnitOfWork) AuditLog() []AuditEntry {
+    u.mu.Lock()
+    defer u.mu.Unlock()
+    return u.state.clone().audit
+}
+
+func (s memoryState) clone() *memoryState {
+    c := &memoryState{nextID: s.nextID, messages: make(map[int]Message, len(s.messages))}
+    for id, m := range s.messages {
+        c.messages[id] = m
+    }
+    c.audit = append([]AuditEntry(nil), s.audit...)
+    return c
+}
+
+type memoryMessages memoryState
+
+func (r *memoryMessages) Create(ctx context.Context, m Message) (Message, error) {
+    m.ID = r.nextID
+    r.nextID++
+    r.messages[m.ID] = m
+    return m, nil
+}
+
+func (r *memoryMessages) GetByID(ctx context.Context, id int) (Message, error) {
+    m, ok := r.messages[id]
+    if !ok {
+        return Message{}, ErrNotFound
+    }
+    return m, nil
+}
+
+func (r *memoryMessages) Update(ctx context.Context, m Message) (Message, error) {
+    cur, ok := r.messages[m.ID]
+    if !ok {
+        return Message{}, ErrNotFound
+    }
+    m.DeletedAt = cur.DeletedAt
+    r.messages[m.ID] = m
+    return m, nil
+}
+
+func (r *memoryMessages) Delete(ctx context.Context, id int) error {
+    m, ok := r.messages[id]
+    if !ok || m.Deleted() {
+        return ErrNotFound
+    }
+    now := time.Now()
+    m.DeletedAt = &now
+    r.messages[id] = m
+    return nil
+}
+
+func (r *memoryMessages) Restore(ctx context.Context, id int) (Message, error) {
+    m, ok := r.messages[id]
+    if !ok || !m.Deleted() {
+        return Message{}, ErrNotFound
+    }
+    m.DeletedAt = nil
+    r.messages[id] = m
+    return m, nil
+}
+
+func (r *memoryMessages) ListDeleted(ctx context.Context) ([]Message, error) {
+    var out []Message
+    for _, m := range r.messages {
+        if m.Deleted() {
+            out = append(out, m)
+        }
+    }
+    sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+    return out, nil
+}
+
+type memoryAudit memoryState
+
+func (r *memoryAudit) Append(ctx context.Context, e AuditEntry) error {
+    r.audit = append(r.audit, e)
+    return nil
+}
@@ -0,0 +1,129 @@
+package message
+
+import (
+    "context"
+    "database/sql"
+    "errors"
+    "fmt"
+    "time"
+)
+
+// SQLUnitOfWork runs each unit in a database transaction. Queries use
+// PostgreSQL placeholders against:
+//
+//	CREATE TABLE messages (id SERIAL PRIMARY KEY, content TEXT NOT NULL, deleted_at TIMESTAMPTZ);
+//	CREATE TABLE message_audit (message_id INT NOT NULL, action TEXT NOT NULL);
+type SQLUnitOfWork struct {
+    db *sql.DB
+}
+
+func NewSQLUnitOfWork(db *sql.DB) *SQLUnitOfWork { return &SQLUnitOfWork{db: db} }
+
+func (u *SQLUnitOfWork) Do(ctx context.Context, fn func(Repos) error) (err error) {
+    tx, err := u.db.BeginTx(ctx, nil)
+    if err != nil {
+        return fmt.Errorf("begin: %w", err)
+    }
+    defer func() {
+        if p := recover(); p != nil {
+            tx.Rollback()
+            panic(p)
+        }
+    }()
+
+    if err := fn(Repos{Messages: sqlMessages{tx}, Audit: sqlAudit{tx}}); err != nil {
+        if rbErr := tx.Rollback(); rbErr != nil {
+            return errors.Join(err, fmt.Errorf("rollback: %w", rbErr))
+        }
+        return err
+    }
+    if err := tx.Commit(); err != nil {
+        return fmt.Errorf("commit: %w", err)
+    }
+    return nil
+}
+
+type sqlMessages struct{ tx *sql.Tx }
+
+func (r sqlMessages) Create(ctx context.Context, m Message) (Message, error) {
+    err := r.tx.QueryRowContext(ctx, `INSERT INTO messages (content) VALUES ($1) RETURNING id`, m.Content).Scan(&m.ID)
+    return m, err
+}
+
+func (r sqlMessages) GetByID(ctx context.Context, id int) (Message, error) {
+    m := Message{ID: id}
+    var deletedAt sql.NullTime
+    err := r.tx.QueryRowContext(ctx, `SELECT content, deleted_at FROM messages WHERE id = $1`, id).Scan(&m.Content, &deletedAt)
+    if errors.Is(err, sql.ErrNoRows) {
+        return Message{}, ErrNotFound
+    }
+    if deletedAt.Valid {
+        m.DeletedAt = &deletedAt.Time
+    }
+    return m, err
+}
+
+func (r sqlMessages) Update(ctx context.Context, m Message) (Message, error) {
+    res, err := r.tx.ExecContext(ctx, `UPDATE messages SET content = $1 WHERE id = $2`, m.Content, m.ID)
+    if err != nil {
+        return Message{}, err
+    }
+    if err := requireRow(res); err != nil {
+        return Message{}, err
+    }
+    return m, nil
+}
+
+func (r sqlMessages) Delete(ctx context.Context, id int) error {
+    res, err := r.tx.ExecContext(ctx, `UPDATE messages SET deleted_at = now() WHERE id = $1 AND deleted_at IS NULL`, id)
+    if err != nil {
+        return err
+    }
+    return requireRow(res)
+}
+
+func (r sqlMessages) Restore(ctx context.Context, id int) (Message, error) {
+    m := Message{ID: id}
+    err := r.tx.QueryRowContext(ctx, `UPDATE messages SET deleted_at = NULL WHERE id = $1 AND deleted_at IS NOT NULL RETURNING content`, id).Scan(&m.Content)
+    if errors.Is(err, sql.ErrNoRows) {
+        return Message{}, ErrNotFound
+    }
+    return m, err
+}
+
+func (r sqlMessages) ListDeleted(ctx context.Context) ([]Message, error) {
+    rows, err := r.tx.QueryContext(ctx, `SELECT id, content, deleted_at FROM messages WHERE deleted_at IS NOT NULL ORDER BY id`)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+    var out []Message
+    for rows.Next() {
+        var m Message
+        var deletedAt time.Time
+        if err := rows.Scan(&m.ID, &m.Content, &deletedAt); err != nil {
+            return nil, err
+        }
+        m.DeletedAt = &deletedAt
+        out = append(out, m)
+    }
+    return out, rows.Err()
+}
+
+func requireRow(res sql.Result) error {
+    n, err := res.RowsAffected()
+    if err != nil {
+        return err
+    }
+    if n == 0 {
+        return ErrNotFound
+    }
+    return nil
+}
+
+type sqlAudit struct{ tx *sql.Tx }
+
+func (r sqlAudit) Append(ctx context.Context, e AuditEntry) error {
+    _, err := r.tx.ExecContext(ctx, `INSERT INTO message_audit (message_id, action) VALUES ($1, $2)`, e.MessageID, e.Action)
+    return err
+}
@@ -0,0 +1,265 @@
+package message
+
+import (
+    "context"
+    "errors"
+    "regexp"
+    "testing"
+    "time"
+
+    "github.com/DATA-DOG/go-sqlmock"
+    gomock "github.com/golang/mock/gomock"
+    "github.com/stretchr/testify/require"
+)
+
+func TestMemoryUnitOfWork(t *testing.T) {
+    t.Parallel()
+    ctx := context.Background()
+
+    t.Run("commit", func(t *testing.T) {
+        uow := NewMemoryUnitOfWork()
+        svc := NewTransactionalService(uow)
+
+        m, err := svc.Create(ctx, " hello ")
+        require.NoError(t, err)
+        require.Equal(t, Message{ID: 1, Content: "hello"}, m)
+        require.Equal(t, map[int]Message{1: m}, uow.Messages())
+        require.Equal(t, []AuditEntry{{MessageID: 1, Action: "create"}}, uow.AuditLog())
+    })
+
+    t.Run("rollback on handler error", func(t *testing.T) {
+        uow := NewMemoryUnitOfWork()
+        boom := errors.New("boom")
+
+        err := uow.Do(ctx, func(r Repos) error {
+            m, err := r.Messages.Create(ctx, Message{Content: "x"})
+            require.NoError(t, err)
+            require.NoError(t, r.Audit.Append(ctx, AuditEntry{MessageID: m.ID, Action: "create"}))
+            return boom
+        })
+        require.ErrorIs(t, err, boom)
+        require.Empty(t, uow.Messages())
+        require.Empty(t, uow.AuditLog())
+
+        // ids handed out by the rolled back unit are not burnt
+        m, err := NewTransactionalService(uow).Create(ctx, "y")
+        require.NoError(t, err)
+        require.Equal(t, 1, m.ID)
+    })
+
+    t.Run("rollback on panic", func(t *testing.T) {
+        uow := NewMemoryUnitOfWork()
+        require.Panics(t, func() {
+            _ = uow.Do(ctx, func(r Repos) error {
+                _, _ = r.Messages.Create(ctx, Message{Content: "x"})
+                panic("handler bug")
+            })
+        })
+        require.Empty(t, uow.Messages())
+
+        // the lock was released
+        _, err := NewTransactionalService(uow).Create(ctx, "y")
+        require.NoError(t, err)
+    })
+
+    t.Run("failed operation leaves earlier commits alone", func(t *testing.T) {
+        uow := NewMemoryUnitOfWork()
+        svc := NewTransactionalService(uow)
+        m, err := svc.Create(ctx, "keep")
+        require.NoError(t, err)
+
+        _, err = svc.Update(ctx, 99, "nope")
+        require.ErrorIs(t, err, ErrNotFound)
+        require.Equal(t, map[int]Message{m.ID: m}, uow.Messages())
+        require.Len(t, uow.AuditLog(), 1)
+    })
+
+    t.Run("delete and restore", func(t *testing.T) {
+        uow := NewMemoryUnitOfWork()
+        svc := NewTransactionalService(uow)
+        m, err := svc.Create(ctx, "hi")
+        require.NoError(t, err)
+
+        require.NoError(t, svc.Delete(ctx, m.ID))
+        require.True(t, uow.Messages()[m.ID].Deleted())
+        _, err = svc.Get(ctx, m.ID)
+        require.ErrorIs(t, err, ErrDeleted)
+        _, err = svc.Update(ctx, m.ID, "edit")
+        require.ErrorIs(t, err, ErrDeleted)
+        require.ErrorIs(t, svc.Delete(ctx, m.ID), ErrNotFound)
+        deleted, err := svc.ListDeleted(ctx)
+        require.NoError(t, err)
+        require.Len(t, deleted, 1)
+        require.Equal(t, m.ID, deleted[0].ID)
+
+        restored, err := svc.Restore(ctx, m.ID)
+        require.NoError(t, err)
+        require.Equal(t, m, restored)
+        _, err = svc.Restore(ctx, m.ID)
+        require.ErrorIs(t, err, ErrNotDeleted)
+        deleted, err = svc.ListDeleted(ctx)
+        require.NoError(t, err)
+        require.Empty(t, deleted)
+
+        require.Equal(t, []AuditEntry{
+            {MessageID: m.ID, Action: "create"},
+            {MessageID: m.ID, Action: "delete"},
+            {MessageID: m.ID, Action: "restore"},
+        }, uow.AuditLog())
+    })
+}
+
+func TestSQLUnitOfWork(t *testing.T) {
+    t.Parallel()
+    ctx := context.Background()
+    insertMessage := regexp.QuoteMeta(`INSERT INTO messages (content) VALUES ($1) RETURNING id`)
+    insertAudit := regexp.QuoteMeta(`INSERT INTO message_audit (message_id, action) VALUES ($1, $2)`)
+
+    t.Run("commit", func(t *testing.T) {
+        db, mock, err := sqlmock.New()
+        require.NoError(t, err)
+        defer db.Close()
+
+        mock.ExpectBegin()
+        mock.ExpectQuery(insertMessage).WithArgs("hello").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(7))
+        mock.ExpectExec(insertAudit).WithArgs(7, "create").WillReturnResult(sqlmock.NewResult(0, 1))
+        mock.ExpectCommit()
+
+        m, err := NewTransactionalService(NewSQLUnitOfWork(db)).Create(ctx, "hello")
+        require.NoError(t, err)
+        require.Equal(t, Message{ID: 7, Content: "hello"}, m)
+        require.NoError(t, mock.ExpectationsWereMet())
+    })
+
+    t.Run("rollback when the second aggregate fails", func(t *testing.T) {
+        db, mock, err := sqlmock.New()
+        require.NoError(t, err)
+        defer db.Close()
+
+        mock.ExpectBegin()
+        mock.ExpectQuery(insertMessage).WithArgs("hello").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(7))
+        mock.ExpectExec(insertAudit).WithArgs(7, "create").WillReturnError(errors.New("audit table locked"))
+        mock.ExpectRollback()
+
+        _, err = NewTransactionalService(NewSQLUnitOfWork(db)).Create(ctx, "hello")
+        require.EqualError(t, err, "audit table locked")
+        require.NoError(t, mock.ExpectationsWereMet())
+    })
+
+    t.Run("rollback on panic", func(t *testing.T) {
+        db, mock, err := sqlmock.New()
+        require.NoError(t, err)
+        defer db.Close()
+
+        mock.ExpectBegin()
+        mock.ExpectRollback()
+
+        require.Panics(t, func() {
+            _ = NewSQLUnitOfWork(db).Do(ctx, func(Repos) error { panic("handler bug") })
+        })
+        require.NoError(t, mock.ExpectationsWereMet())
+    })
+
+    t.Run("delete of missing row", func(t *testing.T) {
+        db, mock, err := sqlmock.New()
+        require.NoError(t, err)
+        defer db.Close()
+
+        mock.ExpectBegin()
+        mock.ExpectExec(regexp.QuoteMeta(`UPDATE messages SET deleted_at = now() WHERE id = $1 AND deleted_at IS NULL`)).WithArgs(3).WillReturnResult(sqlmock.NewResult(0, 0))
+        mock.ExpectRollback()
+
+        err = NewTransactionalService(NewSQLUnitOfWork(db)).Delete(ctx, 3)
+        require.ErrorIs(t, err, ErrNotFound)
+        require.NoError(t, mock.ExpectationsWereMet())
+    })
+
+    t.Run("restore", func(t *testing.T) {
+        db, mock, err := sqlmock.New()
+        require.NoError(t, err)
+        defer db.Close()
+
+        deletedAt := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+        mock.ExpectBegin()
+        mock.ExpectQuery(regexp.QuoteMeta(`SELECT content, deleted_at FROM messages WHERE id = $1`)).WithArgs(4).
+            WillReturnRows(sqlmock.NewRows([]string{"content", "deleted_at"}).AddRow("hi", deletedAt))
+        mock.ExpectQuery(regexp.QuoteMeta(`UPDATE messages SET deleted_at = NULL WHERE id = $1 AND deleted_at IS NOT NULL RETURNING content`)).WithArgs(4).
+            WillReturnRows(sqlmock.NewRows([]string{"content"}).AddRow("hi"))
+        mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO message_audit (message_id, action) VALUES ($1, $2)`)).WithArgs(4, "restore").WillReturnResult(sqlmock.NewResult(0, 1))
+        mock.ExpectCommit()
+
+        m, err := NewTransactionalService(NewSQLUnitOfWork(db)).Restore(ctx, 4)
+        require.NoError(t, err)
+        require.Equal(t, Message{ID: 4, Content: "hi"}, m)
+        require.NoError(t, mock.ExpectationsWereMet())
+    })
+
+    t.Run("list deleted", func(t *testing.T) {
+        db, mock, err := sqlmock.New()
+        require.NoError(t, err)
+        defer db.Close()
+
+        deletedAt := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+        mock.ExpectBegin()
+        mock.ExpectQuery(regexp.QuoteMeta(`SELECT id, content, deleted_at FROM messages WHERE deleted_at IS NOT NULL ORDER BY id`)).
+            WillReturnRows(sqlmock.NewRows([]string{"id", "content", "deleted_at"}).AddRow(2, "a", deletedAt).AddRow(5, "b", deletedAt))
+        mock.ExpectCommit()
+
+        got, err := NewTransactionalService(NewSQLUnitOfWork(db)).ListDeleted(ctx)
+        require.NoError(t, err)
+        require.Equal(t, []Message{{ID: 2, Content: "a", DeletedAt: &deletedAt}, {ID: 5, Content: "b", DeletedAt: &deletedAt}}, got)
+        require.NoError(t, mock.ExpectationsWereMet())
+    })
+}
+
+func TestTransactionalService_WithMocks(t *testing.T) {
+    t.Parallel()
+    ctrl := gomock.NewController(t)
+    defer ctrl.Finish()
+
+    mockUoW := NewMockUnitOfWork(ctrl)
+    mockRepo := NewMockRepository(ctrl)
+    mockAudit := NewMockAuditRepository(ctrl)
+    svc := NewTransactionalService(mockUoW)
+    ctx := context.Background()
+
+    // The fake unit of work just runs the handler against the mocked
+    // repositories and reports what it returned.
+    runHandler := func(_ context.Context, fn func(Repos) error) error {
+        return fn(Repos{Messages: mockRepo, Audit: mockAudit})
+    }
+
+    t.Run("update writes both aggregates in one unit", func(t *testing.T) {
+        want := Message{ID: 3, Content: "updated"}
+        mockUoW.EXPECT().Do(gomock.Any(), gomock.Any()).DoAndReturn(runHandler)
+        mockRepo.EXPECT().GetByID(gomock.Any(), 3).Return(Message{ID: 3, Content: "old"}, nil)
+        mockRepo.EXPECT().Update(gomock.Any(), want).Return(want, nil)
+        mockAudit.EXPECT().Append(gomock.Any(), AuditEntry{MessageID: 3, Action: "update"}).Return(nil)
+
+        got, err := svc.Update(ctx, 3, " updated ")
+        require.NoError(t, err)
+        require.Equal(t, want, got)
+    })
+
+    t.Run("audit failure surfaces as the unit's error", func(t *testing.T) {
+        mockUoW.EXPECT().Do(gomock.Any(), gomock.Any()).DoAndReturn(runHandler)
+        mockRepo.EXPECT().Delete(gomock.Any(), 9).Return(nil)
+        mockAudit.EXPECT().Append(gomock.Any(), AuditEntry{MessageID: 9, Action: "delete"}).Return(errors.New("disk full"))
+
+        err := svc.Delete(ctx, 9)
+        require.EqualError(t, err, "disk full")
+    })
+
+    t.Run("message error skips the audit", func(t *testing.T) {
+        mockUoW.EXPECT().Do(gomock.Any(), gomock.Any()).DoAndReturn(runHandler)
+        mockRepo.EXPECT().Create(gomock.Any(), Message{Content: "x"}).Return(Message{}, errors.New("db down"))
+
+        _, err := svc.Create(ctx, "x")
+        require.EqualError(t, err, "db down")
+    })
+
+    t.Run("validation never opens a unit", func(t *testing.T) {
+        _, err := svc.Create(ctx, "  ")
+        require.ErrorIs(t, err, ErrEmptyContent)
+    })
+}
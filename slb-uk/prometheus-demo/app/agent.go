@@ -0,0 +1,539 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"github.com/prometheus/common/model"
+	"google.golang.org/protobuf/encoding/protowire"
+	"gopkg.in/yaml.v3"
+)
+
+// A miniature Prometheus in agent mode. When AGENT_CONFIG names a YAML
+// file (ops/agent.yaml), the app scrapes its own /metrics like Prometheus
+// would and forwards the result to a remote-write endpoint:
+//
+//   - every scrape_interval it GETs scrape_url and decodes the exposition
+//     into samples, one per series (histograms and summaries become their
+//     _bucket, _sum and _count series);
+//   - each series gets the target labels job and instance (a scraped label
+//     of the same name is kept as exported_job / exported_instance), then
+//     metric_relabel_configs run over it (relabel.go);
+//   - the synthetic series Prometheus adds to every scrape are appended:
+//     up, scrape_duration_seconds, scrape_samples_scraped,
+//     scrape_samples_post_metric_relabeling and scrape_series_added;
+//   - series that were in the previous scrape but not in this one get a
+//     staleness marker, so the receiver ends them right away instead of
+//     after 5 minutes;
+//   - the samples, with external_labels and the HELP/TYPE metadata of the
+//     metric families that survived relabeling, are sent as one snappy
+//     compressed protobuf WriteRequest (remote-write 1.0).
+//
+// Without remote_write.url it runs dry: everything but the send happens
+// and /agent shows the result, which is the quickest way to try rules.
+
+// agentConfig is the AGENT_CONFIG file.
+type agentConfig struct {
+	ScrapeURL            string            `yaml:"scrape_url"`
+	ScrapeInterval       time.Duration     `yaml:"scrape_interval"`
+	ScrapeTimeout        time.Duration     `yaml:"scrape_timeout"`
+	Job                  string            `yaml:"job"`
+	ExternalLabels       map[string]string `yaml:"external_labels"`
+	MetricRelabelConfigs []relabelConfig   `yaml:"metric_relabel_configs"`
+	RemoteWrite          remoteWriteConfig `yaml:"remote_write"`
+}
+
+type remoteWriteConfig struct {
+	URL           string            `yaml:"url"`
+	RemoteTimeout time.Duration     `yaml:"remote_timeout"`
+	Headers       map[string]string `yaml:"headers"`
+	BasicAuth     *struct {
+		Username string `yaml:"username"`
+		Password string `yaml:"password"`
+	} `yaml:"basic_auth"`
+	SendMetadata bool `yaml:"send_metadata"`
+	// MaxRetries is how often a request that failed with a network error,
+	// a 5xx or a 429 is retried, with backoff, before its samples are lost.
+	MaxRetries int `yaml:"max_retries"`
+}
+
+func defaultAgentConfig() *agentConfig {
+	c := &agentConfig{
+		ScrapeURL:      "http://localhost:2112/metrics",
+		ScrapeInterval: 15 * time.Second,
+		ScrapeTimeout:  10 * time.Second,
+		// not prom-demo: the Compose Prometheus scrapes the app under that
+		// job too, and two writers of one series conflict
+		Job: "prom-demo-agent",
+	}
+	c.RemoteWrite.RemoteTimeout = 10 * time.Second
+	c.RemoteWrite.SendMetadata = true
+	c.RemoteWrite.MaxRetries = 3
+	return c
+}
+
+// loadAgentConfig reads path over the defaults; unknown keys are errors,
+// as in the workload config.
+func loadAgentConfig(path string) (*agentConfig, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	c := defaultAgentConfig()
+	dec := yaml.NewDecoder(bytes.NewReader(b))
+	dec.KnownFields(true)
+	if err := dec.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if err := c.validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return c, nil
+}
+
+func (c *agentConfig) validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+	u, err := url.Parse(c.ScrapeURL)
+	check(err == nil && u.Host != "", "scrape_url %q is not an http(s) URL", c.ScrapeURL)
+	check(c.ScrapeInterval >= time.Second, "scrape_interval %s is under 1s", c.ScrapeInterval)
+	check(c.ScrapeTimeout > 0 && c.ScrapeTimeout <= c.ScrapeInterval,
+		"need 0 < scrape_timeout (%s) <= scrape_interval (%s)", c.ScrapeTimeout, c.ScrapeInterval)
+	check(c.Job != "", "job must be set")
+	for name := range c.ExternalLabels {
+		check(model.LabelName(name).IsValidLegacy(), "external_labels: %q is not a label name", name)
+	}
+	for i := range c.MetricRelabelConfigs {
+		if err := c.MetricRelabelConfigs[i].compile(); err != nil {
+			errs = append(errs, fmt.Errorf("metric_relabel_configs[%d]: %w", i, err))
+		}
+	}
+	if c.RemoteWrite.URL != "" {
+		u, err := url.Parse(c.RemoteWrite.URL)
+		check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "",
+			"remote_write: url %q is not an http(s) URL", c.RemoteWrite.URL)
+	}
+	check(c.RemoteWrite.RemoteTimeout > 0, "remote_write: remote_timeout must be positive")
+	check(c.RemoteWrite.MaxRetries >= 0, "remote_write: max_retries must be >= 0")
+	return errors.Join(errs...)
+}
+
+var (
+	agentScrapesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "agent_scrapes_total",
+		Help: "Scrapes of scrape_url by the embedded agent, by result",
+	}, []string{"result"})
+	agentSamplesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "agent_samples_total",
+		Help: "Samples handled by the embedded agent: sent, dropped by relabeling, or failed to send",
+	}, []string{"result"})
+	agentMetadataSentTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "agent_metadata_sent_total",
+		Help: "Metric family metadata entries sent with remote-write requests",
+	})
+	agentRemoteWriteRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "agent_remote_write_requests_total",
+		Help: "Remote-write requests by HTTP status code (\"error\" when there was no response), retries included",
+	}, []string{"code"})
+	agentRemoteWriteDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "agent_remote_write_duration_seconds",
+		Help:    "Time to send one remote-write batch, retries included",
+		Buckets: prometheus.DefBuckets,
+	})
+	agentLastSendSuccess = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "agent_last_send_success_timestamp_seconds",
+		Help: "Unix time of the last batch the remote-write endpoint accepted",
+	})
+)
+
+// staleNaN is the value Prometheus uses as a staleness marker: a NaN that
+// is not the NaN arithmetic produces.
+var staleNaN = math.Float64frombits(0x7ff0000000000002)
+
+// remoteSample is one series with its one sample of a scrape.
+type remoteSample struct {
+	labels model.LabelSet
+	value  float64
+	ts     int64 // milliseconds
+}
+
+type remoteMetadata struct {
+	family string
+	typ    dto.MetricType
+	help   string
+}
+
+// agent scrapes, relabels and forwards; see the top of the file.
+type agent struct {
+	cfg      *agentConfig
+	instance string
+	client   *http.Client
+
+	prev map[model.Fingerprint]model.LabelSet // series of the last scrape
+
+	mu   sync.Mutex
+	last agentStatus
+}
+
+// agentStatus is what /agent shows about the last scrape.
+type agentStatus struct {
+	Time            time.Time `json:"time"`
+	ScrapeDuration  string    `json:"scrape_duration"`
+	ScrapeError     string    `json:"scrape_error,omitempty"`
+	SamplesScraped  int       `json:"samples_scraped"`
+	SamplesKept     int       `json:"samples_post_metric_relabeling"`
+	SeriesAdded     int       `json:"series_added"`
+	StaleMarkers    int       `json:"stale_markers"`
+	Metadata        int       `json:"metadata"`
+	RemoteWriteURL  string    `json:"remote_write_url,omitempty"`
+	SendError       string    `json:"send_error,omitempty"`
+	Series          []string  `json:"series"` // a preview, sorted
+	SeriesTruncated bool      `json:"series_truncated,omitempty"`
+}
+
+func newAgent(cfg *agentConfig) *agent {
+	u, _ := url.Parse(cfg.ScrapeURL) // validated
+	return &agent{cfg: cfg, instance: u.Host, client: &http.Client{}, prev: map[model.Fingerprint]model.LabelSet{}}
+}
+
+func (a *agent) run() {
+	if a.cfg.RemoteWrite.URL == "" {
+		log.Printf("agent: no remote_write.url, dry run: scraping %s, see /agent", a.cfg.ScrapeURL)
+	} else {
+		log.Printf("agent: scraping %s every %s, writing to %s", a.cfg.ScrapeURL, a.cfg.ScrapeInterval, a.cfg.RemoteWrite.URL)
+	}
+	// the first scrape waits one interval, for the listener to be up
+	t := time.NewTicker(a.cfg.ScrapeInterval)
+	defer t.Stop()
+	for range t.C {
+		a.tick(context.Background())
+	}
+}
+
+// tick runs one scrape and sends its samples.
+func (a *agent) tick(ctx context.Context) {
+	start := time.Now()
+	ts := start.UnixMilli()
+	fams, err := a.scrape(ctx)
+	took := time.Since(start)
+
+	st := agentStatus{Time: start, ScrapeDuration: took.Round(time.Microsecond).String(), RemoteWriteURL: a.cfg.RemoteWrite.URL}
+	var out []remoteSample
+	var meta []remoteMetadata
+	seen := map[model.Fingerprint]model.LabelSet{}
+	up := 1.0
+	if err != nil {
+		up = 0
+		st.ScrapeError = err.Error()
+		agentScrapesTotal.WithLabelValues("failure").Inc()
+		log.Printf("agent: scrape %s: %v", a.cfg.ScrapeURL, err)
+	} else {
+		agentScrapesTotal.WithLabelValues("success").Inc()
+		opts := &expfmt.DecodeOptions{Timestamp: model.TimeFromUnixNano(start.UnixNano())}
+		for _, f := range fams {
+			samples, _ := expfmt.ExtractSamples(opts, f)
+			st.SamplesScraped += len(samples)
+			keepMeta := false
+			for _, s := range samples {
+				ls := relabel(a.targetLabels(model.LabelSet(s.Metric)), a.cfg.MetricRelabelConfigs)
+				if ls == nil {
+					agentSamplesTotal.WithLabelValues("dropped").Inc()
+					continue
+				}
+				fp := ls.Fingerprint()
+				if _, dup := seen[fp]; dup {
+					// relabeling made two series equal; Prometheus would
+					// reject the second as a duplicate sample
+					agentSamplesTotal.WithLabelValues("dropped").Inc()
+					continue
+				}
+				seen[fp] = ls
+				out = append(out, remoteSample{labels: ls, value: float64(s.Value), ts: int64(s.Timestamp)})
+				// metadata follows the family only while its name does
+				keepMeta = keepMeta || ls[model.MetricNameLabel] == s.Metric[model.MetricNameLabel]
+			}
+			if keepMeta {
+				meta = append(meta, remoteMetadata{family: f.GetName(), typ: f.GetType(), help: f.GetHelp()})
+			}
+		}
+	}
+	st.SamplesKept = len(out)
+	for fp := range seen {
+		if _, ok := a.prev[fp]; !ok {
+			st.SeriesAdded++
+		}
+	}
+
+	for _, s := range []struct {
+		name  string
+		value float64
+	}{
+		{"up", up},
+		{"scrape_duration_seconds", took.Seconds()},
+		{"scrape_samples_scraped", float64(st.SamplesScraped)},
+		{"scrape_samples_post_metric_relabeling", float64(st.SamplesKept)},
+		{"scrape_series_added", float64(st.SeriesAdded)},
+	} {
+		ls := model.LabelSet{model.MetricNameLabel: model.LabelValue(s.name), model.JobLabel: model.LabelValue(a.cfg.Job),
+			model.InstanceLabel: model.LabelValue(a.instance)}
+		seen[ls.Fingerprint()] = ls
+		out = append(out, remoteSample{labels: ls, value: s.value, ts: ts})
+	}
+
+	// a failed scrape makes every series of the target stale, as in Prometheus
+	for fp, ls := range a.prev {
+		if _, ok := seen[fp]; !ok {
+			out = append(out, remoteSample{labels: ls, value: staleNaN, ts: ts})
+			st.StaleMarkers++
+		}
+	}
+	a.prev = seen
+	if !a.cfg.RemoteWrite.SendMetadata {
+		meta = nil
+	}
+	st.Metadata = len(meta)
+	st.Series, st.SeriesTruncated = previewSeries(out, 50)
+
+	if a.cfg.RemoteWrite.URL != "" {
+		if err := a.send(ctx, out, meta); err != nil {
+			st.SendError = err.Error()
+			agentSamplesTotal.WithLabelValues("failed").Add(float64(len(out)))
+			log.Printf("agent: remote write: %v; %d samples lost", err, len(out))
+		} else {
+			agentSamplesTotal.WithLabelValues("sent").Add(float64(len(out)))
+			agentMetadataSentTotal.Add(float64(len(meta)))
+			agentLastSendSuccess.SetToCurrentTime()
+		}
+	}
+	a.mu.Lock()
+	a.last = st
+	a.mu.Unlock()
+}
+
+func (a *agent) scrape(ctx context.Context) ([]*dto.MetricFamily, error) {
+	ctx, cancel := context.WithTimeout(ctx, a.cfg.ScrapeTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, a.cfg.ScrapeURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "text/plain;version=0.0.4")
+	req.Header.Set("X-Prometheus-Scrape-Timeout-Seconds", strconv.FormatFloat(a.cfg.ScrapeTimeout.Seconds(), 'f', -1, 64))
+	req.Header.Set("User-Agent", "prom-demo-agent")
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("server returned %s", resp.Status)
+	}
+	dec := expfmt.NewDecoder(resp.Body, expfmt.ResponseFormat(resp.Header))
+	var fams []*dto.MetricFamily
+	for {
+		f := &dto.MetricFamily{}
+		if err := dec.Decode(f); errors.Is(err, io.EOF) {
+			return fams, nil
+		} else if err != nil {
+			return nil, err
+		}
+		fams = append(fams, f)
+	}
+}
+
+// targetLabels adds job and instance to a scraped series. Labels of that
+// name the series already has are kept under exported_<name>, which is
+// what Prometheus does without honor_labels.
+func (a *agent) targetLabels(ls model.LabelSet) model.LabelSet {
+	for name, value := range map[model.LabelName]string{model.JobLabel: a.cfg.Job, model.InstanceLabel: a.instance} {
+		if v, ok := ls[name]; ok {
+			ls[model.ExportedLabelPrefix+name] = v
+		}
+		ls[name] = model.LabelValue(value)
+	}
+	return ls
+}
+
+// send posts one WriteRequest, retrying network errors, 5xx and 429 with
+// backoff. Other 4xx answers mean the data itself was refused, so retrying
+// would not help.
+func (a *agent) send(ctx context.Context, samples []remoteSample, meta []remoteMetadata) error {
+	start := time.Now()
+	defer func() { agentRemoteWriteDuration.Observe(time.Since(start).Seconds()) }()
+	body := snappy.Encode(nil, encodeWriteRequest(samples, meta, a.cfg.ExternalLabels))
+	backoff := 500 * time.Millisecond
+	for attempt := 0; ; attempt++ {
+		retry, err := a.post(ctx, body)
+		if err == nil || !retry || attempt == a.cfg.RemoteWrite.MaxRetries {
+			return err
+		}
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		backoff *= 2
+	}
+}
+
+func (a *agent) post(ctx context.Context, body []byte) (retry bool, err error) {
+	ctx, cancel := context.WithTimeout(ctx, a.cfg.RemoteWrite.RemoteTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.cfg.RemoteWrite.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	req.Header.Set("User-Agent", "prom-demo-agent")
+	for k, v := range a.cfg.RemoteWrite.Headers {
+		req.Header.Set(k, v)
+	}
+	if ba := a.cfg.RemoteWrite.BasicAuth; ba != nil {
+		req.SetBasicAuth(ba.Username, ba.Password)
+	}
+	resp, err := a.client.Do(req)
+	if err != nil {
+		agentRemoteWriteRequests.WithLabelValues("error").Inc()
+		return true, err
+	}
+	defer resp.Body.Close()
+	agentRemoteWriteRequests.WithLabelValues(strconv.Itoa(resp.StatusCode)).Inc()
+	if resp.StatusCode/100 == 2 {
+		return false, nil
+	}
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	return resp.StatusCode/100 == 5 || resp.StatusCode == http.StatusTooManyRequests,
+		fmt.Errorf("server returned %s: %s", resp.Status, bytes.TrimSpace(msg))
+}
+
+// Field numbers of prometheus/prompb's remote.proto and types.proto.
+const (
+	pbWriteRequestTimeseries = 1
+	pbWriteRequestMetadata   = 3
+
+	pbTimeSeriesLabels  = 1
+	pbTimeSeriesSamples = 2
+
+	pbLabelName  = 1
+	pbLabelValue = 2
+
+	pbSampleValue     = 1
+	pbSampleTimestamp = 2
+
+	pbMetadataType       = 1
+	pbMetadataFamilyName = 2
+	pbMetadataHelp       = 4
+)
+
+// metadataTypes maps exposition types to MetricMetadata.MetricType.
+var metadataTypes = map[dto.MetricType]uint64{
+	dto.MetricType_UNTYPED:         0, // UNKNOWN
+	dto.MetricType_COUNTER:         1,
+	dto.MetricType_GAUGE:           2,
+	dto.MetricType_HISTOGRAM:       3,
+	dto.MetricType_GAUGE_HISTOGRAM: 4,
+	dto.MetricType_SUMMARY:         5,
+}
+
+// encodeWriteRequest builds a prompb.WriteRequest by hand, one TimeSeries
+// per sample. Receivers expect the labels of a series sorted by name;
+// external labels fill in names the series does not already have.
+func encodeWriteRequest(samples []remoteSample, meta []remoteMetadata, external map[string]string) []byte {
+	var b []byte
+	for _, s := range samples {
+		ls := s.labels
+		if len(external) > 0 {
+			ls = ls.Clone()
+			for name, value := range external {
+				if _, ok := ls[model.LabelName(name)]; !ok {
+					ls[model.LabelName(name)] = model.LabelValue(value)
+				}
+			}
+		}
+		var ts []byte
+		for _, name := range sortedNames(ls) {
+			var l []byte
+			l = protowire.AppendTag(l, pbLabelName, protowire.BytesType)
+			l = protowire.AppendString(l, string(name))
+			l = protowire.AppendTag(l, pbLabelValue, protowire.BytesType)
+			l = protowire.AppendString(l, string(ls[name]))
+			ts = protowire.AppendTag(ts, pbTimeSeriesLabels, protowire.BytesType)
+			ts = protowire.AppendBytes(ts, l)
+		}
+		var sample []byte
+		sample = protowire.AppendTag(sample, pbSampleValue, protowire.Fixed64Type)
+		sample = protowire.AppendFixed64(sample, math.Float64bits(s.value))
+		sample = protowire.AppendTag(sample, pbSampleTimestamp, protowire.VarintType)
+		sample = protowire.AppendVarint(sample, uint64(s.ts))
+		ts = protowire.AppendTag(ts, pbTimeSeriesSamples, protowire.BytesType)
+		ts = protowire.AppendBytes(ts, sample)
+
+		b = protowire.AppendTag(b, pbWriteRequestTimeseries, protowire.BytesType)
+		b = protowire.AppendBytes(b, ts)
+	}
+	for _, m := range meta {
+		var mb []byte
+		mb = protowire.AppendTag(mb, pbMetadataType, protowire.VarintType)
+		mb = protowire.AppendVarint(mb, metadataTypes[m.typ])
+		mb = protowire.AppendTag(mb, pbMetadataFamilyName, protowire.BytesType)
+		mb = protowire.AppendString(mb, m.family)
+		mb = protowire.AppendTag(mb, pbMetadataHelp, protowire.BytesType)
+		mb = protowire.AppendString(mb, m.help)
+		b = protowire.AppendTag(b, pbWriteRequestMetadata, protowire.BytesType)
+		b = protowire.AppendBytes(b, mb)
+	}
+	return b
+}
+
+// previewSeries renders up to n samples as name{labels} value, sorted.
+func previewSeries(samples []remoteSample, n int) ([]string, bool) {
+	out := make([]string, 0, len(samples))
+	for _, s := range samples {
+		v := strconv.FormatFloat(s.value, 'g', -1, 64)
+		if math.Float64bits(s.value) == math.Float64bits(staleNaN) {
+			v = "stale"
+		}
+		out = append(out, model.Metric(s.labels).String()+" "+v)
+	}
+	sort.Strings(out)
+	if len(out) > n {
+		return out[:n], true
+	}
+	return out, false
+}
+
+// ServeHTTP shows the last scrape: counts, errors and a preview of the
+// series as they were (or would have been) sent.
+func (a *agent) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	a.mu.Lock()
+	st := a.last
+	a.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(st)
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/golang/snappy"
+)
+
+// scrapeTarget serves exposition text that the test swaps between scrapes;
+// an empty body answers 500.
+type scrapeTarget struct {
+	mu   sync.Mutex
+	body string
+}
+
+func (s *scrapeTarget) set(body string) {
+	s.mu.Lock()
+	s.body = body
+	s.mu.Unlock()
+}
+
+func (s *scrapeTarget) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.body == "" {
+		http.Error(w, "down", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	_, _ = io.WriteString(w, s.body)
+}
+
+// staleSeries returns the series of the last scrape's preview that were
+// sent a staleness marker.
+func staleSeries(a *agent) []string {
+	var out []string
+	for _, s := range a.last.Series {
+		if name, ok := strings.CutSuffix(s, " stale"); ok {
+			out = append(out, name)
+		}
+	}
+	return out
+}
+
+func TestStalenessMarkers(t *testing.T) {
+	target := &scrapeTarget{}
+	scrapes := httptest.NewServer(target)
+	defer scrapes.Close()
+	var writes [][]byte
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		writes = append(writes, b)
+	}))
+	defer receiver.Close()
+
+	cfg := defaultAgentConfig()
+	cfg.ScrapeURL = scrapes.URL + "/metrics"
+	cfg.RemoteWrite.URL = receiver.URL
+	// tenant="c" is dropped: a series relabeling removes goes stale too
+	cfg.MetricRelabelConfigs = []relabelConfig{{SourceLabels: []string{"tenant"}, Regex: ptr("c"), Action: "drop"}}
+	if err := cfg.validate(); err != nil {
+		t.Fatal(err)
+	}
+	a := newAgent(cfg)
+	instance := strings.TrimPrefix(scrapes.URL, "http://")
+	series := func(tenant string) string {
+		return `app_tenant_requests_total{instance="` + instance + `", job="prom-demo-agent", tenant="` + tenant + `"}`
+	}
+	const head = "# TYPE app_tenant_requests_total counter\n"
+
+	steps := []struct {
+		name  string
+		body  string
+		stale []string
+		up    string
+	}{
+		{"first scrape", head + "app_tenant_requests_total{tenant=\"a\"} 1\napp_tenant_requests_total{tenant=\"b\"} 1\n", nil, "1"},
+		{"b disappears", head + "app_tenant_requests_total{tenant=\"a\"} 2\n", []string{series("b")}, "1"},
+		{"b is back", head + "app_tenant_requests_total{tenant=\"a\"} 3\napp_tenant_requests_total{tenant=\"b\"} 1\n", nil, "1"},
+		{"dropped by relabeling", head + "app_tenant_requests_total{tenant=\"a\"} 4\napp_tenant_requests_total{tenant=\"c\"} 1\n",
+			[]string{series("b")}, "1"},
+		// a failed scrape ends every scraped series; the synthetic ones go on
+		{"target down", "", []string{series("a")}, "0"},
+		{"still down", "", nil, "0"},
+	}
+	for _, step := range steps {
+		target.set(step.body)
+		a.tick(context.Background())
+		if got := staleSeries(a); !slices.Equal(got, step.stale) {
+			t.Fatalf("%s: stale %v, want %v", step.name, got, step.stale)
+		}
+		if a.last.StaleMarkers != len(step.stale) || a.last.SendError != "" {
+			t.Fatalf("%s: status %+v", step.name, a.last)
+		}
+		up := `up{instance="` + instance + `", job="prom-demo-agent"} ` + step.up
+		if !slices.Contains(a.last.Series, up) {
+			t.Fatalf("%s: no %s in %v", step.name, up, a.last.Series)
+		}
+	}
+
+	// the markers are sent as the special NaN, not an ordinary one
+	var stale, nan [8]byte
+	binary.LittleEndian.PutUint64(stale[:], math.Float64bits(staleNaN))
+	binary.LittleEndian.PutUint64(nan[:], math.Float64bits(math.NaN()))
+	for i, w := range writes {
+		body, err := snappy.Decode(nil, w)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := len(steps[i].stale) > 0; bytes.Contains(body, stale[:]) != want || bytes.Contains(body, nan[:]) {
+			t.Errorf("write %d (%s): staleness marker sent = %v, want %v", i, steps[i].name, !want, want)
+		}
+	}
+	if len(writes) != len(steps) {
+		t.Fatalf("%d writes for %d scrapes", len(writes), len(steps))
+	}
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"gopkg.in/yaml.v3"
+)
+
+// The workload of the demo endpoints comes from a YAML file (WORKLOAD_CONFIG)
+// that is reloaded without a restart, the way Prometheus, Alertmanager and
+// most exporters do it:
+//
+//   - on SIGHUP, and when the file's content changes (polled, which also
+//     catches Kubernetes ConfigMap updates that swap a symlink);
+//   - a new config is parsed and validated as a whole; if anything is wrong
+//     the old one stays active, the error is logged and shown on /config,
+//     and config_last_reload_successful drops to 0 until a good file
+//     arrives. A bad edit never takes the app down.
+
+// workloadConfig drives /work, /alloc, /goroutines and the tenant label.
+type workloadConfig struct {
+	Work       workConfig       `yaml:"work"`
+	Alloc      allocConfig      `yaml:"alloc"`
+	Goroutines goroutinesConfig `yaml:"goroutines"`
+	Labels     labelsConfig     `yaml:"labels"`
+}
+
+type workConfig struct {
+	SleepMin      time.Duration `yaml:"sleep_min"`
+	SleepMax      time.Duration `yaml:"sleep_max"`
+	IterationsMin int           `yaml:"iterations_min"`
+	IterationsMax int           `yaml:"iterations_max"`
+	ErrorRate     float64       `yaml:"error_rate"`
+}
+
+type allocConfig struct {
+	Buffers   int `yaml:"buffers"`
+	BufferKiB int `yaml:"buffer_kib"`
+}
+
+type goroutinesConfig struct {
+	Min      int           `yaml:"min"`
+	Max      int           `yaml:"max"`
+	MaxSleep time.Duration `yaml:"max_sleep"`
+}
+
+type labelsConfig struct {
+	// Tenants is the number of distinct tenant label values /work spreads
+	// its requests over, i.e. the series count of app_tenant_requests_total.
+	Tenants int `yaml:"tenants"`
+}
+
+// defaultWorkloadConfig is the workload the demo always had. WORK_ERROR_RATE
+// still seeds the error rate so existing commands keep working.
+func defaultWorkloadConfig() *workloadConfig {
+	c := &workloadConfig{}
+	c.Work.SleepMin, c.Work.SleepMax = 50*time.Millisecond, 200*time.Millisecond
+	c.Work.IterationsMin, c.Work.IterationsMax = 20000, 40000
+	c.Work.ErrorRate, _ = strconv.ParseFloat(os.Getenv("WORK_ERROR_RATE"), 64)
+	c.Alloc.Buffers, c.Alloc.BufferKiB = 100, 64
+	c.Goroutines.Min, c.Goroutines.Max = 100, 300
+	c.Goroutines.MaxSleep = 200 * time.Millisecond
+	c.Labels.Tenants = 5
+	return c
+}
+
+// parseWorkloadConfig decodes b over the defaults, so a file only needs the
+// keys it changes. Unknown keys are errors: a typo should fail the reload,
+// not be silently ignored.
+func parseWorkloadConfig(b []byte) (*workloadConfig, error) {
+	c := defaultWorkloadConfig()
+	dec := yaml.NewDecoder(bytes.NewReader(b))
+	dec.KnownFields(true)
+	if err := dec.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	return c, c.validate()
+}
+
+func (c *workloadConfig) validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+	check(c.Work.SleepMin >= 0 && c.Work.SleepMin <= c.Work.SleepMax,
+		"work: need 0 <= sleep_min (%s) <= sleep_max (%s)", c.Work.SleepMin, c.Work.SleepMax)
+	check(c.Work.SleepMax <= 10*time.Second, "work: sleep_max %s is over 10s", c.Work.SleepMax)
+	check(c.Work.IterationsMin >= 0 && c.Work.IterationsMin <= c.Work.IterationsMax,
+		"work: need 0 <= iterations_min (%d) <= iterations_max (%d)", c.Work.IterationsMin, c.Work.IterationsMax)
+	check(c.Work.ErrorRate >= 0 && c.Work.ErrorRate <= 1, "work: error_rate %g is not between 0 and 1", c.Work.ErrorRate)
+	check(c.Alloc.Buffers > 0 && c.Alloc.BufferKiB > 0, "alloc: buffers and buffer_kib must be positive")
+	check(c.Alloc.Buffers*c.Alloc.BufferKiB <= 512*1024, "alloc: %d x %d KiB is over 512 MiB per request", c.Alloc.Buffers, c.Alloc.BufferKiB)
+	check(c.Goroutines.Min >= 0 && c.Goroutines.Min <= c.Goroutines.Max,
+		"goroutines: need 0 <= min (%d) <= max (%d)", c.Goroutines.Min, c.Goroutines.Max)
+	check(c.Goroutines.Max <= 100000, "goroutines: max %d is over 100000", c.Goroutines.Max)
+	check(c.Goroutines.MaxSleep > 0, "goroutines: max_sleep must be positive")
+	check(c.Labels.Tenants >= 1 && c.Labels.Tenants <= 10000, "labels: tenants %d is not between 1 and 10000", c.Labels.Tenants)
+	return errors.Join(errs...)
+}
+
+// between returns a value in [lo, hi].
+func between(lo, hi int) int { return lo + rand.Intn(hi-lo+1) }
+
+var (
+	configReloadSuccess = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "config_last_reload_successful",
+		Help: "Whether the last workload config reload succeeded (1) or was rejected (0)",
+	})
+	configReloadSuccessTime = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "config_last_reload_success_timestamp_seconds",
+		Help: "Unix time of the last successful workload config reload",
+	})
+	configReloadsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "config_reloads_total",
+		Help: "Workload config reload attempts by result",
+	}, []string{"result"})
+	configInfo = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "config_info",
+		Help: "Always 1; sha256 of the active workload config file",
+	}, []string{"sha256"})
+)
+
+// configLoader owns the active config. Handlers call current(); reloads
+// swap the pointer, so a request sees one consistent config from start to
+// end.
+type configLoader struct {
+	path   string
+	active atomic.Pointer[workloadConfig]
+
+	mu        sync.Mutex // serializes reloads and guards the fields below
+	sum       string     // sha256 of the active file
+	failedSum string     // sha256 of the last rejected file
+	lastErr   error
+	lastOK    time.Time
+}
+
+func newConfigLoader(path string) (*configLoader, error) {
+	l := &configLoader{path: path}
+	l.active.Store(defaultWorkloadConfig())
+	configReloadSuccess.Set(1)
+	if path == "" {
+		return l, nil
+	}
+	// the first load must succeed; there is no previous config to keep
+	if err := l.reload(true); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *configLoader) current() *workloadConfig { return l.active.Load() }
+
+// reload reads and validates the file and activates it if it differs from
+// the active one. Content that already failed is not retried unless force
+// is set, so polling a broken file reports it once instead of every tick.
+func (l *configLoader) reload(force bool) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b, err := os.ReadFile(l.path)
+	if err == nil {
+		raw := sha256.Sum256(b)
+		sum := hex.EncodeToString(raw[:])
+		switch {
+		case sum == l.sum:
+			return nil
+		case sum == l.failedSum && !force:
+			return l.lastErr
+		}
+		var c *workloadConfig
+		if c, err = parseWorkloadConfig(b); err == nil {
+			l.activate(c, sum)
+			return nil
+		}
+		l.failedSum = sum
+	}
+	l.lastErr = fmt.Errorf("%s: %w", l.path, err)
+	configReloadSuccess.Set(0)
+	configReloadsTotal.WithLabelValues("failure").Inc()
+	log.Printf("config: reload rejected, keeping the previous config: %v", l.lastErr)
+	return l.lastErr
+}
+
+func (l *configLoader) activate(c *workloadConfig, sum string) {
+	prev := l.current()
+	l.active.Store(c)
+	if c.Labels.Tenants < prev.Labels.Tenants {
+		// drop the series of tenants that no longer exist
+		appTenantRequests.Reset()
+	}
+	configInfo.Reset()
+	configInfo.WithLabelValues(sum).Set(1)
+	l.sum, l.failedSum, l.lastErr, l.lastOK = sum, "", nil, time.Now()
+	configReloadSuccess.Set(1)
+	configReloadSuccessTime.Set(float64(l.lastOK.Unix()))
+	configReloadsTotal.WithLabelValues("success").Inc()
+	log.Printf("config: loaded %s (sha256 %.12s)", l.path, sum)
+}
+
+// watch reloads on SIGHUP and whenever the file's content changes.
+func (l *configLoader) watch(poll time.Duration) {
+	if l.path == "" {
+		return
+	}
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	t := time.NewTicker(poll)
+	defer t.Stop()
+	for {
+		select {
+		case <-hup:
+			log.Printf("config: SIGHUP, reloading %s", l.path)
+			_ = l.reload(true)
+		case <-t.C:
+			_ = l.reload(false)
+		}
+	}
+}
+
+// ServeHTTP shows the active config as YAML, with the last reload error if
+// the file on disk was rejected. POST reloads right away, like Prometheus'
+// /-/reload.
+func (l *configLoader) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost && l.path != "" {
+		_ = l.reload(true)
+	}
+	l.mu.Lock()
+	out := struct {
+		File         string          `yaml:"file,omitempty"`
+		SHA256       string          `yaml:"sha256,omitempty"`
+		LastReloadOK time.Time       `yaml:"last_reload_success,omitempty"`
+		LastError    string          `yaml:"last_error,omitempty"`
+		Active       *workloadConfig `yaml:"active"`
+	}{File: l.path, SHA256: l.sum, LastReloadOK: l.lastOK, Active: l.current()}
+	if l.lastErr != nil {
+		out.LastError = l.lastErr.Error()
+	}
+	l.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/yaml")
+	if out.LastError != "" {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+	}
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	_ = enc.Encode(out)
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestParseWorkloadConfig(t *testing.T) {
+	cases := []struct {
+		name string
+		yaml string
+		want []string // in the error; none for a valid file
+		ok   func(*workloadConfig) bool
+	}{
+		{name: "empty", yaml: "", ok: func(c *workloadConfig) bool { return c.Labels.Tenants == 5 && c.Work.SleepMax == 200*time.Millisecond }},
+		{name: "override", yaml: "work: { error_rate: 0.2 }\nlabels: { tenants: 50 }",
+			ok: func(c *workloadConfig) bool {
+				return c.Work.ErrorRate == 0.2 && c.Labels.Tenants == 50 && c.Alloc.Buffers == 100
+			}},
+		{name: "unknown key", yaml: "work: { error_ratio: 0.2 }", want: []string{"field error_ratio not found"}},
+		{name: "not yaml", yaml: "work: [", want: []string{"yaml"}},
+		{name: "sleep", yaml: "work: { sleep_min: 300ms }", want: []string{"need 0 <= sleep_min (300ms) <= sleep_max (200ms)"}},
+		{name: "sleep max", yaml: "work: { sleep_max: 11s }", want: []string{"sleep_max 11s is over 10s"}},
+		{name: "error rate", yaml: "work: { error_rate: 1.5 }", want: []string{"error_rate 1.5 is not between 0 and 1"}},
+		{name: "alloc", yaml: "alloc: { buffers: 1024, buffer_kib: 1024 }", want: []string{"over 512 MiB per request"}},
+		{name: "goroutines", yaml: "goroutines: { max_sleep: 0s }", want: []string{"max_sleep must be positive"}},
+		// every problem is reported at once
+		{name: "several", yaml: "labels: { tenants: 0 }\ngoroutines: { min: 500 }",
+			want: []string{"tenants 0 is not between 1 and 10000", "need 0 <= min (500) <= max (300)"}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv("WORK_ERROR_RATE", "")
+			c, err := parseWorkloadConfig([]byte(tc.yaml))
+			if len(tc.want) == 0 {
+				if err != nil || !tc.ok(c) {
+					t.Fatalf("%+v, %v", c, err)
+				}
+				return
+			}
+			for _, want := range tc.want {
+				if err == nil || !strings.Contains(err.Error(), want) {
+					t.Errorf("error %v does not contain %q", err, want)
+				}
+			}
+		})
+	}
+}
+
+func metricValue(t *testing.T, m prometheus.Metric) float64 {
+	t.Helper()
+	var out dto.Metric
+	if err := m.Write(&out); err != nil {
+		t.Fatal(err)
+	}
+	if out.Gauge != nil {
+		return out.Gauge.GetValue()
+	}
+	return out.Counter.GetValue()
+}
+
+func TestConfigReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "workload.yaml")
+	write := func(s string) {
+		if err := os.WriteFile(path, []byte(s), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("work: { error_rate: 0.1 }")
+	l, err := newConfigLoader(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l.current().Work.ErrorRate != 0.1 || metricValue(t, configReloadSuccess) != 1 {
+		t.Fatal("first load not active")
+	}
+	// a marker the next successful reload overwrites
+	configReloadSuccessTime.Set(42)
+	failures := metricValue(t, configReloadsTotal.WithLabelValues("failure"))
+	successes := metricValue(t, configReloadsTotal.WithLabelValues("success"))
+
+	write("work: { error_rate: 2 }")
+	if err := l.reload(false); err == nil || !strings.Contains(err.Error(), "error_rate 2") {
+		t.Fatalf("bad file: %v", err)
+	}
+	if l.current().Work.ErrorRate != 0.1 {
+		t.Fatal("a rejected file replaced the active config")
+	}
+	if metricValue(t, configReloadSuccess) != 0 || metricValue(t, configReloadSuccessTime) != 42 {
+		t.Fatal("a rejected reload counted as a success")
+	}
+	// polling the same broken file does not report it again
+	if err := l.reload(false); err == nil {
+		t.Fatal("the broken file's error was forgotten")
+	}
+	if got := metricValue(t, configReloadsTotal.WithLabelValues("failure")); got != failures+1 {
+		t.Fatalf("%v failures, want %v", got, failures+1)
+	}
+	w := httptest.NewRecorder()
+	l.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/config", nil))
+	if w.Code != http.StatusUnprocessableEntity || !strings.Contains(w.Body.String(), "error_rate: 0.1") {
+		t.Fatalf("/config while rejected: %d %s", w.Code, w.Body)
+	}
+
+	before := time.Now().Unix()
+	write("work: { error_rate: 0.3 }")
+	if err := l.reload(false); err != nil {
+		t.Fatal(err)
+	}
+	if l.current().Work.ErrorRate != 0.3 || metricValue(t, configReloadSuccess) != 1 {
+		t.Fatal("fixed file not active")
+	}
+	if ts := metricValue(t, configReloadSuccessTime); ts < float64(before) {
+		t.Fatalf("success timestamp %v did not move", ts)
+	}
+	// unchanged content is not a reload
+	if err := l.reload(false); err != nil {
+		t.Fatal(err)
+	}
+	if got := metricValue(t, configReloadsTotal.WithLabelValues("success")); got != successes+1 {
+		t.Fatalf("%v successes, want %v", got, successes+1)
+	}
+}
+
+func TestConfigFirstLoadMustSucceed(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "workload.yaml")
+	if err := os.WriteFile(path, []byte("labels: { tenants: -1 }"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newConfigLoader(path); err == nil {
+		t.Fatal("started on an invalid config")
+	}
+	if _, err := newConfigLoader(filepath.Join(t.TempDir(), "missing.yaml")); err == nil {
+		t.Fatal("started on a missing config")
+	}
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Simulated downstream dependencies. Real services export one "is it up"
+// gauge per dependency, fed by a background probe, plus the latency of the
+// calls they make to it:
+//
+//	dependency_up{dependency="db"} 1
+//	dependency_request_duration_seconds_bucket{dependency="db",result="success",le="0.01"} 42
+//
+// Here the db and the external API are fakes whose health, latency and
+// error rate can be flipped at runtime through /dependencies, so the
+// dashboard panels and the DependencyDown alert in ops/alerts.yml have
+// something to show. /order calls both, so a broken dependency also burns
+// the app's own SLIs; /readyz fails while a critical one is down.
+
+var (
+	dependencyUp = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "dependency_up",
+		Help: "Whether the last probe of the dependency succeeded (1) or failed (0)",
+	}, []string{"dependency"})
+
+	dependencyDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "dependency_request_duration_seconds",
+		Help:    "Latency of calls to a dependency, probes included, by result",
+		Buckets: []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+	}, []string{"dependency", "result"})
+
+	dependencyLastProbe = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "dependency_last_probe_timestamp_seconds",
+		Help: "Unix time of the last probe of the dependency",
+	}, []string{"dependency"})
+)
+
+// errDependencyDown is returned by calls to a dependency marked unhealthy.
+var errDependencyDown = errors.New("dependency unavailable")
+
+// dependency is one fake downstream. Calls sleep latency ± jitter and fail
+// with errorRate, or always once the dependency is marked unhealthy.
+type dependency struct {
+	name     string
+	critical bool // a critical dependency being down fails /readyz
+	timeout  time.Duration
+
+	mu        sync.Mutex
+	healthy   bool
+	latency   time.Duration
+	jitter    time.Duration
+	errorRate float64
+	up        bool // result of the last probe
+	lastErr   string
+}
+
+// dependencyStatus is the JSON view of a dependency on /dependencies.
+type dependencyStatus struct {
+	Name      string  `json:"name"`
+	Critical  bool    `json:"critical"`
+	Healthy   bool    `json:"healthy"`
+	Up        bool    `json:"up"`
+	Latency   string  `json:"latency"`
+	Jitter    string  `json:"jitter"`
+	ErrorRate float64 `json:"error_rate"`
+	Timeout   string  `json:"timeout"`
+	LastError string  `json:"last_error,omitempty"`
+}
+
+// call simulates one request and records its latency. A call that would
+// take longer than the timeout gives up at the timeout, like a client
+// with a deadline.
+func (d *dependency) call() error {
+	d.mu.Lock()
+	healthy, latency, errorRate := d.healthy, d.latency, d.errorRate
+	if d.jitter > 0 {
+		latency += time.Duration(rand.Int63n(int64(2*d.jitter)+1)) - d.jitter
+	}
+	d.mu.Unlock()
+
+	var err error
+	switch {
+	case latency > d.timeout:
+		latency, err = d.timeout, fmt.Errorf("%s: timeout after %s", d.name, d.timeout)
+	case !healthy:
+		err = fmt.Errorf("%s: %w", d.name, errDependencyDown)
+	case rand.Float64() < errorRate:
+		err = fmt.Errorf("%s: injected error", d.name)
+	}
+	if latency > 0 {
+		time.Sleep(latency)
+	}
+	result := "success"
+	if err != nil {
+		result = "error"
+	}
+	dependencyDuration.WithLabelValues(d.name, result).Observe(latency.Seconds())
+	return err
+}
+
+// probe calls the dependency and publishes the outcome as dependency_up.
+// One failed probe flips it, so a flaky dependency flaps; alert on
+// avg_over_time or with a for: clause rather than on a single scrape.
+func (d *dependency) probe() {
+	err := d.call()
+	d.mu.Lock()
+	d.up, d.lastErr = err == nil, ""
+	if err != nil {
+		d.lastErr = err.Error()
+	}
+	d.mu.Unlock()
+	up := 0.0
+	if err == nil {
+		up = 1
+	}
+	dependencyUp.WithLabelValues(d.name).Set(up)
+	dependencyLastProbe.WithLabelValues(d.name).Set(float64(time.Now().Unix()))
+}
+
+func (d *dependency) status() dependencyStatus {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return dependencyStatus{
+		Name: d.name, Critical: d.critical, Healthy: d.healthy, Up: d.up,
+		Latency: d.latency.String(), Jitter: d.jitter.String(), ErrorRate: d.errorRate,
+		Timeout: d.timeout.String(), LastError: d.lastErr,
+	}
+}
+
+// dependencies is the set of fakes, probed every interval.
+type dependencies struct {
+	byName   map[string]*dependency
+	interval time.Duration
+}
+
+func newDependencies(interval time.Duration) *dependencies {
+	ds := &dependencies{byName: map[string]*dependency{}, interval: interval}
+	for _, d := range []*dependency{
+		{name: "db", critical: true, timeout: time.Second, latency: 3 * time.Millisecond, jitter: 2 * time.Millisecond},
+		{name: "external_api", timeout: 2 * time.Second, latency: 40 * time.Millisecond, jitter: 20 * time.Millisecond},
+	} {
+		d.healthy, d.up = true, true
+		dependencyUp.WithLabelValues(d.name).Set(1)
+		ds.byName[d.name] = d
+	}
+	return ds
+}
+
+func (ds *dependencies) names() []string {
+	names := make([]string, 0, len(ds.byName))
+	for n := range ds.byName {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// run probes every dependency each interval, concurrently so that one slow
+// dependency does not delay the others' probes.
+func (ds *dependencies) run() {
+	t := time.NewTicker(ds.interval)
+	defer t.Stop()
+	for {
+		for _, d := range ds.byName {
+			go d.probe()
+		}
+		<-t.C
+	}
+}
+
+// ready reports whether every critical dependency passed its last probe.
+func (ds *dependencies) ready() error {
+	for _, n := range ds.names() {
+		if st := ds.byName[n].status(); st.Critical && !st.Up {
+			return fmt.Errorf("%s is down: %s", n, st.LastError)
+		}
+	}
+	return nil
+}
+
+// ServeHTTP lists the dependencies as JSON. POST (or PUT) changes one:
+//
+//	POST /dependencies?name=db&healthy=false
+//	POST /dependencies?name=external_api&latency=800ms&jitter=100ms&error_rate=0.1
+//
+// Only the parameters given change. The dependency is probed right away,
+// so dependency_up reflects the change on the next scrape.
+func (ds *dependencies) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+	case http.MethodPost, http.MethodPut:
+		if err := ds.update(r); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	default:
+		w.Header().Set("Allow", "GET, POST, PUT")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	out := make([]dependencyStatus, 0, len(ds.byName))
+	for _, n := range ds.names() {
+		out = append(out, ds.byName[n].status())
+	}
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(out)
+}
+
+func (ds *dependencies) update(r *http.Request) error {
+	q := r.URL.Query()
+	d, ok := ds.byName[q.Get("name")]
+	if !ok {
+		return fmt.Errorf("unknown dependency %q; have %v", q.Get("name"), ds.names())
+	}
+	// parse everything before changing anything
+	var (
+		healthy                bool
+		latency, jitter        time.Duration
+		errorRate              float64
+		err                    error
+		setH, setL, setJ, setE bool
+	)
+	if v := q.Get("healthy"); v != "" {
+		if healthy, err = strconv.ParseBool(v); err != nil {
+			return fmt.Errorf("healthy: %w", err)
+		}
+		setH = true
+	}
+	if v := q.Get("latency"); v != "" {
+		if latency, err = time.ParseDuration(v); err != nil || latency < 0 || latency > 10*time.Second {
+			return fmt.Errorf("latency %q: want a duration between 0 and 10s", v)
+		}
+		setL = true
+	}
+	if v := q.Get("jitter"); v != "" {
+		if jitter, err = time.ParseDuration(v); err != nil || jitter < 0 || jitter > 10*time.Second {
+			return fmt.Errorf("jitter %q: want a duration between 0 and 10s", v)
+		}
+		setJ = true
+	}
+	if v := q.Get("error_rate"); v != "" {
+		if errorRate, err = strconv.ParseFloat(v, 64); err != nil || errorRate < 0 || errorRate > 1 {
+			return fmt.Errorf("error_rate %q: want a number between 0 and 1", v)
+		}
+		setE = true
+	}
+
+	d.mu.Lock()
+	if setH {
+		d.healthy = healthy
+	}
+	if setL {
+		d.latency = latency
+	}
+	if setJ {
+		d.jitter = jitter
+	}
+	if setE {
+		d.errorRate = errorRate
+	}
+	d.mu.Unlock()
+	st := d.status()
+	log.Printf("dependencies: %s healthy=%t latency=%s jitter=%s error_rate=%g", d.name, st.Healthy, st.Latency, st.Jitter, st.ErrorRate)
+	d.probe()
+	return nil
+}
+
+// orderHandler is a request that needs both dependencies: a read from the
+// db, then a call to the external API. Either failing fails the request
+// with a 503, which shows up in app_responses_total like any other error.
+func (ds *dependencies) orderHandler(w http.ResponseWriter, r *http.Request) {
+	for _, n := range []string{"db", "external_api"} {
+		if err := ds.byName[n].call(); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+	}
+	fmt.Fprintln(w, "order placed")
+}
+
+// readyHandler is a readiness probe: 503 while a critical dependency is
+// down, so a load balancer stops sending traffic the app cannot serve.
+// Liveness (/healthz) stays independent of dependencies; restarting the
+// app does not fix its db.
+func (ds *dependencies) readyHandler(w http.ResponseWriter, r *http.Request) {
+	if err := ds.ready(); err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprintln(w, "ready")
+}
@@ -5,7 +5,9 @@ import (
 	"log"
 	"math/rand"
 	"net/http"
+	"os"
 	"runtime"
+	"strconv"
 	"sync"
 	"time"
 
@@ -39,6 +41,37 @@ var (
 			Help: "In-flight requests for demo endpoints",
 		},
 	)
+
+	// The SLIs: outcomes and latency per endpoint (see slo.go). The buckets
+	// include the latency SLO thresholds exactly.
+	requestDurationBuckets = []float64{.025, .05, .1, .2, .25, .5, 1, 2.5}
+
+	appResponsesTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "app_responses_total",
+			Help: "Demo endpoint responses by status code",
+		},
+		[]string{"path", "code"},
+	)
+
+	appRequestDuration = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "app_request_duration_seconds",
+			Help:    "Demo endpoint latency",
+			Buckets: requestDurationBuckets,
+		},
+		[]string{"path"},
+	)
+
+	// One series per tenant; labels.tenants in the workload config sets how
+	// many, to show what label cardinality does to the scrape.
+	appTenantRequests = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "app_tenant_requests_total",
+			Help: "Demo /work requests by (synthetic) tenant",
+		},
+		[]string{"tenant"},
+	)
 )
 
 func main() {
@@ -46,6 +79,35 @@ func main() {
 	// Default registry already has Go + process collectors.
 	prometheus.MustRegister(collectors.NewBuildInfoCollector())
 
+	sloCfg, err := loadSLOConfig()
+	if err != nil {
+		log.Fatalf("slo config: %v", err)
+	}
+	slo := newSLOEngine(sloCfg, prometheus.DefaultGatherer)
+	go slo.run()
+
+	// Workload parameters; see config.go. The error rate lives there too, so
+	// the availability SLO has something to burn.
+	workload, err := newConfigLoader(os.Getenv("WORKLOAD_CONFIG"))
+	if err != nil {
+		log.Fatalf("workload config: %v", err)
+	}
+	go workload.watch(2 * time.Second)
+
+	// Fake db and external API with up/down gauges; see dependencies.go
+	deps := newDependencies(5 * time.Second)
+	go deps.run()
+
+	// Scrapes /metrics and forwards it over remote write; see agent.go
+	var ag *agent
+	if path := os.Getenv("AGENT_CONFIG"); path != "" {
+		cfg, err := loadAgentConfig(path)
+		if err != nil {
+			log.Fatalf("agent config: %v", err)
+		}
+		ag = newAgent(cfg)
+		go ag.run()
+	}
 
 	mux := http.NewServeMux()
 
@@ -53,44 +115,75 @@ func main() {
 		fmt.Fprintln(w, "ok")
 	})
 
+	// Readiness: fails while a critical dependency is down
+	mux.HandleFunc("/readyz", deps.readyHandler)
+
 	mux.HandleFunc("/work", withMetrics("/work", func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
 		defer func() { appWorkDuration.Observe(time.Since(start).Seconds()) }()
 
-		n := 20000 + rand.Intn(20000)
+		cfg := workload.current()
+		appTenantRequests.WithLabelValues(fmt.Sprintf("tenant-%04d", rand.Intn(cfg.Labels.Tenants))).Inc()
+		if rand.Float64() < cfg.Work.ErrorRate {
+			http.Error(w, "injected failure", http.StatusInternalServerError)
+			return
+		}
+		n := between(cfg.Work.IterationsMin, cfg.Work.IterationsMax)
 		sum := 0
 		for i := 0; i < n; i++ {
 			sum += i * (i % 7)
 		}
-		time.Sleep(time.Duration(50+rand.Intn(150)) * time.Millisecond)
+		time.Sleep(cfg.Work.SleepMin + time.Duration(rand.Int63n(int64(cfg.Work.SleepMax-cfg.Work.SleepMin)+1)))
 		fmt.Fprintf(w, "did some work, sum=%d\n", sum)
 	}))
 
 	mux.HandleFunc("/alloc", withMetrics("/alloc", func(w http.ResponseWriter, r *http.Request) {
-		bufs := make([][]byte, 100)
+		cfg := workload.current()
+		bufs := make([][]byte, cfg.Alloc.Buffers)
 		for i := range bufs {
-			bufs[i] = make([]byte, 1<<16) // 64 KiB
+			bufs[i] = make([]byte, cfg.Alloc.BufferKiB<<10)
 			for j := range bufs[i] {
 				bufs[i][j] = byte(j)
 			}
 		}
-		fmt.Fprintf(w, "allocated ~%d KiB then released\n", len(bufs)*64)
+		fmt.Fprintf(w, "allocated ~%d KiB then released\n", len(bufs)*cfg.Alloc.BufferKiB)
 	}))
 
 	mux.HandleFunc("/goroutines", withMetrics("/goroutines", func(w http.ResponseWriter, r *http.Request) {
+		cfg := workload.current()
 		var wg sync.WaitGroup
-		n := 100 + rand.Intn(200)
+		n := between(cfg.Goroutines.Min, cfg.Goroutines.Max)
 		wg.Add(n)
 		for i := 0; i < n; i++ {
 			go func() {
 				defer wg.Done()
-				time.Sleep(time.Duration(rand.Intn(200)) * time.Millisecond)
+				time.Sleep(time.Duration(rand.Int63n(int64(cfg.Goroutines.MaxSleep))))
 			}()
 		}
 		fmt.Fprintf(w, "spawned %d goroutines; currently: %d\n", n, runtime.NumGoroutine())
 		wg.Wait()
 	}))
 
+	// Needs both dependencies; fails with them
+	mux.HandleFunc("/order", withMetrics("/order", deps.orderHandler))
+
+	// Dependency health; POST flips a dependency unhealthy, slow or flaky
+	mux.Handle("/dependencies", deps)
+
+	// Active workload config and the last reload error; POST reloads
+	mux.Handle("/config", workload)
+
+	// SLO status computed from the metrics above
+	mux.Handle("/slo", slo)
+
+	// The embedded agent's last scrape, after relabeling
+	if ag != nil {
+		mux.Handle("/agent", ag)
+	}
+
+	// Same workload into a histogram and a summary; reports estimation error
+	mux.HandleFunc("/quantiles", withMetrics("/quantiles", quantilesHandler))
+
 	// Instrumented /metrics to expose promhttp_* metrics
 	metricsHandler := promhttp.Handler()
 	mux.Handle("/metrics", promhttp.InstrumentMetricHandler(
@@ -100,7 +193,7 @@ func main() {
 
 	addr := ":2112"
 	log.Printf("Prometheus demo listening on %s", addr)
-	log.Printf("Try: http://localhost%[1]s/metrics, /work, /alloc, /goroutines, /healthz", addr)
+	log.Printf("Try: http://localhost%[1]s/metrics, /work, /alloc, /goroutines, /order, /quantiles, /slo, /config, /dependencies, /agent, /healthz, /readyz", addr)
 	if err := http.ListenAndServe(addr, mux); err != nil {
 		log.Fatal(err)
 	}
@@ -111,6 +204,20 @@ func withMetrics(path string, next http.HandlerFunc) http.HandlerFunc {
 		appInFlight.Inc()
 		defer appInFlight.Dec()
 		appRequestsTotal.WithLabelValues(path).Inc()
-		next.ServeHTTP(w, r)
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, code: http.StatusOK}
+		next.ServeHTTP(rec, r)
+		appRequestDuration.WithLabelValues(path).Observe(time.Since(start).Seconds())
+		appResponsesTotal.WithLabelValues(path, strconv.Itoa(rec.code)).Inc()
 	}
-}
\ No newline at end of file
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	code int
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	r.code = code
+	r.ResponseWriter.WriteHeader(code)
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// Histogram vs summary: the same synthetic latencies are observed by both
+// kinds of metric so their quantile estimates can be compared with the exact
+// values. Histograms are cheap, aggregatable across instances and computed at
+// query time, but only as precise as their buckets. Summaries are precise
+// (within their configured error) but computed per process and can't be
+// aggregated.
+
+var (
+	latencyBuckets = prometheus.DefBuckets
+	objectives     = map[float64]float64{0.5: 0.05, 0.9: 0.01, 0.99: 0.001}
+	quantiles      = []float64{0.5, 0.9, 0.99}
+
+	shapeHistogram = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "app_shape_latency_seconds",
+		Help:    "Synthetic latency per traffic shape, as a histogram",
+		Buckets: latencyBuckets,
+	}, []string{"shape"})
+
+	shapeSummary = promauto.NewSummaryVec(prometheus.SummaryOpts{
+		Name:       "app_shape_latency_summary_seconds",
+		Help:       "Synthetic latency per traffic shape, as a summary",
+		Objectives: objectives,
+	}, []string{"shape"})
+
+	quantileError = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "app_quantile_estimation_error_ratio",
+		Help: "Relative error |estimate-exact|/exact of the last /quantiles run",
+	}, []string{"shape", "quantile", "method"})
+)
+
+// shapes generate latencies in seconds.
+var shapes = map[string]func(r *rand.Rand) float64{
+	// spread evenly over several buckets: both estimates do well
+	"uniform": func(r *rand.Rand) float64 { return 0.05 + 0.2*r.Float64() },
+	// everything inside one bucket (0.1-0.25): interpolation guesses badly
+	"narrow": func(r *rand.Rand) float64 { return 0.118 + 0.004*r.Float64() },
+	// fast path plus a slow 10%: p99 lands between wide buckets
+	"bimodal": func(r *rand.Rand) float64 {
+		if r.Float64() < 0.9 {
+			return 0.015 + 0.01*r.Float64()
+		}
+		return 0.6 + 0.6*r.Float64()
+	},
+	// log-normal around 80ms with a heavy tail
+	"longtail": func(r *rand.Rand) float64 { return math.Exp(math.Log(0.08) + 0.9*r.NormFloat64()) },
+}
+
+type quantileReport struct {
+	Quantile       float64 `json:"quantile"`
+	Exact          float64 `json:"exact"`
+	Histogram      float64 `json:"histogram"`
+	HistogramError float64 `json:"histogram_error"`
+	Summary        float64 `json:"summary"`
+	SummaryError   float64 `json:"summary_error"`
+}
+
+type shapeReport struct {
+	Shape     string           `json:"shape"`
+	Samples   int              `json:"samples"`
+	Quantiles []quantileReport `json:"quantiles"`
+}
+
+// quantilesHandler serves /quantiles?shape=bimodal&n=20000&seed=1. Without
+// shape it runs every shape.
+func quantilesHandler(w http.ResponseWriter, r *http.Request) {
+	n := 20000
+	if v := r.URL.Query().Get("n"); v != "" {
+		var err error
+		if n, err = strconv.Atoi(v); err != nil || n < 100 || n > 1_000_000 {
+			http.Error(w, "n must be between 100 and 1000000", http.StatusBadRequest)
+			return
+		}
+	}
+	seed := rand.Int63()
+	if v := r.URL.Query().Get("seed"); v != "" {
+		seed, _ = strconv.ParseInt(v, 10, 64)
+	}
+	names := []string{r.URL.Query().Get("shape")}
+	if names[0] == "" {
+		names = names[:0]
+		for name := range shapes {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	}
+
+	var out []shapeReport
+	for _, name := range names {
+		gen, ok := shapes[name]
+		if !ok {
+			http.Error(w, fmt.Sprintf("unknown shape %q", name), http.StatusBadRequest)
+			return
+		}
+		out = append(out, compareShape(name, gen, n, rand.New(rand.NewSource(seed))))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(out)
+}
+
+func compareShape(name string, gen func(*rand.Rand) float64, n int, rnd *rand.Rand) shapeReport {
+	// fresh metrics so the comparison covers exactly this run; the exported
+	// vecs get the same samples for Prometheus/Grafana
+	h := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "h", Buckets: latencyBuckets})
+	s := prometheus.NewSummary(prometheus.SummaryOpts{Name: "s", Objectives: objectives})
+	samples := make([]float64, n)
+	for i := range samples {
+		v := gen(rnd)
+		samples[i] = v
+		h.Observe(v)
+		s.Observe(v)
+		shapeHistogram.WithLabelValues(name).Observe(v)
+		shapeSummary.WithLabelValues(name).Observe(v)
+	}
+	sort.Float64s(samples)
+
+	var hm, sm dto.Metric
+	_ = h.Write(&hm)
+	_ = s.Write(&sm)
+	summaryQ := map[float64]float64{}
+	for _, q := range sm.GetSummary().GetQuantile() {
+		summaryQ[q.GetQuantile()] = q.GetValue()
+	}
+
+	rep := shapeReport{Shape: name, Samples: n}
+	for _, q := range quantiles {
+		exact := samples[int(math.Ceil(q*float64(n)))-1]
+		hq := histogramQuantile(q, hm.GetHistogram())
+		sq := summaryQ[q]
+		qr := quantileReport{
+			Quantile: q, Exact: exact,
+			Histogram: hq, HistogramError: relErr(hq, exact),
+			Summary: sq, SummaryError: relErr(sq, exact),
+		}
+		rep.Quantiles = append(rep.Quantiles, qr)
+		qs := strconv.FormatFloat(q, 'f', -1, 64)
+		quantileError.WithLabelValues(name, qs, "histogram").Set(qr.HistogramError)
+		quantileError.WithLabelValues(name, qs, "summary").Set(qr.SummaryError)
+	}
+	return rep
+}
+
+// histogramQuantile mirrors PromQL's histogram_quantile: find the bucket
+// holding the rank and interpolate linearly inside it.
+func histogramQuantile(q float64, h *dto.Histogram) float64 {
+	total := float64(h.GetSampleCount())
+	if total == 0 {
+		return math.NaN()
+	}
+	rank := q * total
+	lower, prevCount := 0.0, 0.0
+	for _, b := range h.GetBucket() {
+		count := float64(b.GetCumulativeCount())
+		if count >= rank {
+			if count == prevCount {
+				return b.GetUpperBound()
+			}
+			return lower + (b.GetUpperBound()-lower)*(rank-prevCount)/(count-prevCount)
+		}
+		lower, prevCount = b.GetUpperBound(), count
+	}
+	// rank falls in the +Inf bucket: PromQL returns the highest finite bound
+	return lower
+}
+
+func relErr(estimate, exact float64) float64 {
+	if exact == 0 {
+		return 0
+	}
+	return math.Abs(estimate-exact) / exact
+}
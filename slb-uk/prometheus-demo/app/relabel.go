@@ -0,0 +1,163 @@
+package main
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/prometheus/common/model"
+)
+
+// relabelConfig is one rule of metric_relabel_configs, with the fields and
+// defaults of Prometheus' relabel_config. Rules run in order over a
+// series' labels, __name__ included; each sees what the one before left.
+//
+//	replace    join source_labels with separator, match regex against it and
+//	           set target_label to replacement ($1, ${name} expand)
+//	keep/drop  keep or drop the series when regex matches (does not match)
+//	hashmod    set target_label to md5(joined source_labels) % modulus
+//	labelmap   copy every label whose name matches regex to replacement
+//	labeldrop  remove the labels whose name matches regex
+//	labelkeep  remove the labels whose name does not match regex
+//	lowercase  set target_label to the joined source_labels in lower case
+//	uppercase  ... in upper case
+type relabelConfig struct {
+	SourceLabels []string `yaml:"source_labels,flow"`
+	Separator    *string  `yaml:"separator"`
+	Regex        *string  `yaml:"regex"`
+	Modulus      uint64   `yaml:"modulus"`
+	TargetLabel  string   `yaml:"target_label"`
+	Replacement  *string  `yaml:"replacement"`
+	Action       string   `yaml:"action"`
+
+	re *regexp.Regexp // Regex, anchored at both ends
+}
+
+var relabelTargetRequired = map[string]bool{"replace": true, "hashmod": true, "lowercase": true, "uppercase": true}
+
+// compile fills in the defaults and checks the rule.
+func (c *relabelConfig) compile() error {
+	if c.Action == "" {
+		c.Action = "replace"
+	}
+	c.Action = strings.ToLower(c.Action)
+	if c.Separator == nil {
+		c.Separator = ptr(";")
+	}
+	if c.Regex == nil {
+		c.Regex = ptr("(.*)")
+	}
+	if c.Replacement == nil {
+		c.Replacement = ptr("$1")
+	}
+	re, err := regexp.Compile("^(?:" + *c.Regex + ")$")
+	if err != nil {
+		return fmt.Errorf("regex %q: %w", *c.Regex, err)
+	}
+	c.re = re
+	switch c.Action {
+	case "replace", "keep", "drop", "hashmod", "labelmap", "labeldrop", "labelkeep", "lowercase", "uppercase":
+	default:
+		return fmt.Errorf("unknown action %q", c.Action)
+	}
+	if relabelTargetRequired[c.Action] && c.TargetLabel == "" {
+		return fmt.Errorf("action %s needs target_label", c.Action)
+	}
+	if c.Action == "hashmod" && c.Modulus == 0 {
+		return fmt.Errorf("action hashmod needs a modulus")
+	}
+	if (c.Action == "keep" || c.Action == "drop" || c.Action == "hashmod") && len(c.SourceLabels) == 0 {
+		return fmt.Errorf("action %s needs source_labels", c.Action)
+	}
+	return nil
+}
+
+func ptr[T any](v T) *T { return &v }
+
+// relabel applies cfgs to a copy of ls. It returns nil when a rule dropped
+// the series or it has no __name__ left. Labels that end up empty are
+// removed, and so are those starting with "__" other than __name__, which
+// makes them usable as temporary labels between rules.
+func relabel(ls model.LabelSet, cfgs []relabelConfig) model.LabelSet {
+	out := ls.Clone()
+	for i := range cfgs {
+		if !cfgs[i].apply(out) {
+			return nil
+		}
+	}
+	for name, value := range out {
+		if value == "" || (strings.HasPrefix(string(name), "__") && name != model.MetricNameLabel) {
+			delete(out, name)
+		}
+	}
+	if out[model.MetricNameLabel] == "" {
+		return nil
+	}
+	return out
+}
+
+// apply runs c on ls in place and reports whether the series is kept.
+func (c *relabelConfig) apply(ls model.LabelSet) bool {
+	values := make([]string, len(c.SourceLabels))
+	for i, name := range c.SourceLabels {
+		values[i] = string(ls[model.LabelName(name)])
+	}
+	val := strings.Join(values, *c.Separator)
+
+	switch c.Action {
+	case "keep":
+		return c.re.MatchString(val)
+	case "drop":
+		return !c.re.MatchString(val)
+	case "replace":
+		m := c.re.FindStringSubmatchIndex(val)
+		if m == nil {
+			return true
+		}
+		target := string(c.re.ExpandString(nil, c.TargetLabel, val, m))
+		if !model.LabelName(target).IsValidLegacy() {
+			return true
+		}
+		value := c.re.ExpandString(nil, *c.Replacement, val, m)
+		if len(value) == 0 {
+			delete(ls, model.LabelName(target))
+			return true
+		}
+		ls[model.LabelName(target)] = model.LabelValue(value)
+	case "hashmod":
+		// the same bucket Prometheus would pick, for sharding by it
+		sum := md5.Sum([]byte(val))
+		ls[model.LabelName(c.TargetLabel)] = model.LabelValue(fmt.Sprint(binary.BigEndian.Uint64(sum[8:]) % c.Modulus))
+	case "lowercase":
+		ls[model.LabelName(c.TargetLabel)] = model.LabelValue(strings.ToLower(val))
+	case "uppercase":
+		ls[model.LabelName(c.TargetLabel)] = model.LabelValue(strings.ToUpper(val))
+	case "labelmap":
+		for _, name := range sortedNames(ls) {
+			if c.re.MatchString(string(name)) {
+				ls[model.LabelName(c.re.ReplaceAllString(string(name), *c.Replacement))] = ls[name]
+			}
+		}
+	case "labeldrop", "labelkeep":
+		for name := range ls {
+			if c.re.MatchString(string(name)) == (c.Action == "labeldrop") {
+				delete(ls, name)
+			}
+		}
+	}
+	return true
+}
+
+// sortedNames returns the label names of ls in order, so labelmap results
+// do not depend on map iteration when two labels map to the same name.
+func sortedNames(ls model.LabelSet) []model.LabelName {
+	names := make([]model.LabelName, 0, len(ls))
+	for name := range ls {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool { return names[i] < names[j] })
+	return names
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/common/model"
+)
+
+func TestRelabel(t *testing.T) {
+	series := model.LabelSet{"__name__": "app_responses_total", "path": "/work", "code": "500", "job": "prom-demo", "instance": "app:2112"}
+	cases := []struct {
+		name string
+		cfgs []relabelConfig
+		want model.LabelSet // nil: dropped
+	}{
+		{"keep match", []relabelConfig{{SourceLabels: []string{"code"}, Regex: ptr("5.."), Action: "keep"}}, series},
+		{"keep no match", []relabelConfig{{SourceLabels: []string{"code"}, Regex: ptr("2.."), Action: "keep"}}, nil},
+		// regexes are anchored: 5 alone does not match 500
+		{"keep anchored", []relabelConfig{{SourceLabels: []string{"code"}, Regex: ptr("5"), Action: "keep"}}, nil},
+		{"drop match", []relabelConfig{{SourceLabels: []string{"__name__"}, Regex: ptr("app_.*"), Action: "drop"}}, nil},
+		{"drop no match", []relabelConfig{{SourceLabels: []string{"__name__"}, Regex: ptr("go_.*"), Action: "drop"}}, series},
+		{"drop joined", []relabelConfig{{SourceLabels: []string{"path", "code"}, Regex: ptr("/work;5.."), Action: "drop"}}, nil},
+		{"replace", []relabelConfig{{SourceLabels: []string{"code"}, Regex: ptr("(\\d).."), TargetLabel: "class", Replacement: ptr("${1}xx")}},
+			with(series, "class", "5xx")},
+		// defaults: regex (.*), replacement $1, action replace
+		{"replace defaults", []relabelConfig{{SourceLabels: []string{"instance"}, TargetLabel: "host"}}, with(series, "host", "app:2112")},
+		{"replace no match", []relabelConfig{{SourceLabels: []string{"code"}, Regex: ptr("2(..)"), TargetLabel: "class"}}, series},
+		{"replace to empty", []relabelConfig{{SourceLabels: []string{"code"}, TargetLabel: "path", Replacement: ptr("")}},
+			without(series, "path")},
+		{"replace separator", []relabelConfig{{SourceLabels: []string{"path", "code"}, Separator: ptr("@"), TargetLabel: "key"}},
+			with(series, "key", "/work@500")},
+		{"labelmap", []relabelConfig{{Regex: ptr("(path|code)"), Replacement: ptr("http_$1"), Action: "labelmap"}},
+			with(with(series, "http_path", "/work"), "http_code", "500")},
+		{"labeldrop", []relabelConfig{{Regex: ptr("job|instance"), Action: "labeldrop"}}, without(without(series, "job"), "instance")},
+		{"labelkeep", []relabelConfig{{Regex: ptr("__name__|code"), Action: "labelkeep"}}, model.LabelSet{"__name__": "app_responses_total", "code": "500"}},
+		{"uppercase", []relabelConfig{{SourceLabels: []string{"path"}, TargetLabel: "path", Action: "UpperCase"}}, with(series, "path", "/WORK")},
+		// temporary labels live between rules only
+		{"temporary label", []relabelConfig{
+			{SourceLabels: []string{"code"}, Regex: ptr("(.).."), TargetLabel: "__tmp_class"},
+			{SourceLabels: []string{"__tmp_class"}, Regex: ptr("5"), Action: "keep"},
+		}, series},
+		// rules see what the ones before them left
+		{"in order", []relabelConfig{
+			{Regex: ptr("code"), Action: "labeldrop"},
+			{SourceLabels: []string{"code"}, Regex: ptr("5.."), Action: "keep"},
+		}, nil},
+		{"no name left", []relabelConfig{{Regex: ptr("__name__"), Action: "labeldrop"}}, nil},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			for i := range tc.cfgs {
+				if err := tc.cfgs[i].compile(); err != nil {
+					t.Fatal(err)
+				}
+			}
+			in := series.Clone()
+			got := relabel(in, tc.cfgs)
+			if !in.Equal(series) {
+				t.Fatalf("relabel changed its input: %v", in)
+			}
+			if (got == nil) != (tc.want == nil) || (got != nil && !got.Equal(tc.want)) {
+				t.Fatalf("got %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func with(ls model.LabelSet, name, value string) model.LabelSet {
+	out := ls.Clone()
+	out[model.LabelName(name)] = model.LabelValue(value)
+	return out
+}
+
+func without(ls model.LabelSet, name string) model.LabelSet {
+	out := ls.Clone()
+	delete(out, model.LabelName(name))
+	return out
+}
+
+func TestRelabelHashmod(t *testing.T) {
+	c := relabelConfig{SourceLabels: []string{"instance"}, TargetLabel: "__tmp_shard", Modulus: 4, Action: "hashmod"}
+	keep := relabelConfig{SourceLabels: []string{"__tmp_shard"}, Regex: ptr("1"), Action: "keep"}
+	for _, r := range []*relabelConfig{&c, &keep} {
+		if err := r.compile(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	shards := map[string]int{}
+	kept := 0
+	for i := range 100 {
+		ls := model.LabelSet{"__name__": "up", "instance": model.LabelValue("app-" + strconv.Itoa(i))}
+		one := ls.Clone()
+		c.apply(one)
+		two := ls.Clone()
+		c.apply(two)
+		if one["__tmp_shard"] != two["__tmp_shard"] {
+			t.Fatal("hashmod is not stable")
+		}
+		shards[string(one["__tmp_shard"])]++
+		if relabel(ls, []relabelConfig{c, keep}) != nil {
+			kept++
+		}
+	}
+	if len(shards) != 4 || kept != shards["1"] {
+		t.Fatalf("shards %v, kept %d", shards, kept)
+	}
+}
+
+func TestRelabelCompileErrors(t *testing.T) {
+	cases := []struct {
+		cfg  relabelConfig
+		want string
+	}{
+		{relabelConfig{Action: "rename"}, `unknown action "rename"`},
+		{relabelConfig{Regex: ptr("(")}, "regex"},
+		{relabelConfig{SourceLabels: []string{"code"}}, "action replace needs target_label"},
+		{relabelConfig{Action: "keep"}, "action keep needs source_labels"},
+		{relabelConfig{SourceLabels: []string{"instance"}, TargetLabel: "shard", Action: "hashmod"}, "needs a modulus"},
+	}
+	for _, tc := range cases {
+		if err := tc.cfg.compile(); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%+v: err = %v, want %q", tc.cfg, err, tc.want)
+		}
+	}
+}
@@ -0,0 +1,345 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// SLOs computed in-process. Usually burn rates live in recording rules:
+//
+//	sum(rate(app_responses_total{code=~"5.."}[1h])) / sum(rate(app_responses_total[1h])) / (1 - 0.99)
+//
+// Here the same math runs in the app: every tick it reads its own counters
+// and histograms from the registry, keeps a history of (good, total) per
+// objective, and differences that history over each window. A burn rate of
+// 1 spends the error budget exactly over the SLO period; 14.4 spends a 30d
+// budget in ~2 days. An alert fires only when both the long and the short
+// window of a pair burn too fast (the multiwindow, multi-burn-rate alerts
+// from the SRE workbook): the long window proves it matters, the short one
+// that it is still happening.
+
+// sloConfig is loaded from SLO_CONFIG (JSON); defaultSLOConfig otherwise.
+type sloConfig struct {
+	Objectives []objective  `json:"objectives"`
+	Alerts     []alertRule  `json:"alerts"`
+	Tick       jsonDuration `json:"tick"`
+}
+
+// objective is either availability (non-5xx responses of path over all of
+// them) or latency (requests of path served within threshold_seconds, which
+// must be a bucket bound of app_request_duration_seconds).
+type objective struct {
+	Name      string  `json:"name"`
+	Kind      string  `json:"kind"` // availability | latency
+	Path      string  `json:"path"`
+	Target    float64 `json:"target"` // e.g. 0.99
+	Threshold float64 `json:"threshold_seconds,omitempty"`
+}
+
+type alertRule struct {
+	Severity string       `json:"severity"`
+	Long     jsonDuration `json:"long"`
+	Short    jsonDuration `json:"short"`
+	Burn     float64      `json:"burn"`
+}
+
+type jsonDuration struct{ time.Duration }
+
+func (d *jsonDuration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	d.Duration = v
+	return err
+}
+
+func (d jsonDuration) MarshalJSON() ([]byte, error) { return json.Marshal(d.String()) }
+
+var defaultSLOConfig = sloConfig{
+	Objectives: []objective{
+		{Name: "work-availability", Kind: "availability", Path: "/work", Target: 0.99},
+		{Name: "work-latency", Kind: "latency", Path: "/work", Target: 0.95, Threshold: 0.2},
+	},
+	Alerts: []alertRule{
+		{Severity: "page", Long: jsonDuration{time.Hour}, Short: jsonDuration{5 * time.Minute}, Burn: 14.4},
+		{Severity: "page", Long: jsonDuration{6 * time.Hour}, Short: jsonDuration{30 * time.Minute}, Burn: 6},
+		{Severity: "ticket", Long: jsonDuration{3 * 24 * time.Hour}, Short: jsonDuration{6 * time.Hour}, Burn: 1},
+	},
+	Tick: jsonDuration{10 * time.Second},
+}
+
+func loadSLOConfig() (sloConfig, error) {
+	path := os.Getenv("SLO_CONFIG")
+	if path == "" {
+		return defaultSLOConfig, nil
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return sloConfig{}, err
+	}
+	cfg := sloConfig{Tick: defaultSLOConfig.Tick}
+	if err := json.Unmarshal(b, &cfg); err != nil {
+		return sloConfig{}, fmt.Errorf("parse %s: %w", path, err)
+	}
+	for _, o := range cfg.Objectives {
+		if o.Target <= 0 || o.Target >= 1 {
+			return sloConfig{}, fmt.Errorf("%s: objective %s: target must be between 0 and 1", path, o.Name)
+		}
+		if o.Kind != "availability" && o.Kind != "latency" {
+			return sloConfig{}, fmt.Errorf("%s: objective %s: unknown kind %q", path, o.Name, o.Kind)
+		}
+		if o.Kind == "latency" && !isBucketBound(o.Threshold) {
+			return sloConfig{}, fmt.Errorf("%s: objective %s: threshold_seconds must be one of the buckets %v", path, o.Name, requestDurationBuckets)
+		}
+	}
+	for _, a := range cfg.Alerts {
+		if a.Short.Duration <= 0 || a.Long.Duration < a.Short.Duration {
+			return sloConfig{}, fmt.Errorf("%s: alert %s: need 0 < short <= long", path, a.Severity)
+		}
+	}
+	return cfg, nil
+}
+
+var (
+	sloBurnRate = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "slo_burn_rate",
+		Help: "Error-budget burn rate over the window (1 = on budget)",
+	}, []string{"slo", "window"})
+
+	sloErrorRatio = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "slo_error_ratio",
+		Help: "Bad events / all events over the window",
+	}, []string{"slo", "window"})
+
+	sloBudgetRemaining = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "slo_error_budget_remaining_ratio",
+		Help: "Share of the error budget left since the process started (negative when overspent)",
+	}, []string{"slo"})
+
+	sloAlert = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "slo_alert_firing",
+		Help: "1 while both windows of a burn-rate alert exceed its threshold",
+	}, []string{"slo", "severity", "long", "short"})
+)
+
+// sample is the cumulative count of good and all events at one instant.
+type sample struct {
+	at          time.Time
+	good, total float64
+}
+
+type windowStatus struct {
+	Window     string  `json:"window"`
+	Events     float64 `json:"events"`
+	ErrorRatio float64 `json:"error_ratio"`
+	BurnRate   float64 `json:"burn_rate"`
+	Partial    bool    `json:"partial,omitempty"` // less history than the window
+}
+
+type alertStatus struct {
+	Severity string  `json:"severity"`
+	Long     string  `json:"long"`
+	Short    string  `json:"short"`
+	Burn     float64 `json:"burn_threshold"`
+	Firing   bool    `json:"firing"`
+}
+
+type sloStatus struct {
+	objective
+	BudgetRemaining float64        `json:"error_budget_remaining"`
+	Windows         []windowStatus `json:"windows"`
+	Alerts          []alertStatus  `json:"alerts"`
+}
+
+// sloEngine samples the registry on every tick and derives the SLO metrics.
+type sloEngine struct {
+	cfg      sloConfig
+	gatherer prometheus.Gatherer
+	keep     time.Duration // longest window
+
+	mu      sync.Mutex
+	history map[string][]sample
+	status  []sloStatus
+}
+
+func newSLOEngine(cfg sloConfig, g prometheus.Gatherer) *sloEngine {
+	e := &sloEngine{cfg: cfg, gatherer: g, history: map[string][]sample{}}
+	for _, a := range cfg.Alerts {
+		if a.Long.Duration > e.keep {
+			e.keep = a.Long.Duration
+		}
+	}
+	return e
+}
+
+// run samples right away, so the first window has a baseline, then on every
+// tick.
+func (e *sloEngine) run() {
+	if err := e.evaluate(time.Now()); err != nil {
+		log.Printf("slo: %v", err)
+	}
+	t := time.NewTicker(e.cfg.Tick.Duration)
+	defer t.Stop()
+	for now := range t.C {
+		if err := e.evaluate(now); err != nil {
+			log.Printf("slo: %v", err)
+		}
+	}
+}
+
+func (e *sloEngine) evaluate(now time.Time) error {
+	mfs, err := e.gatherer.Gather()
+	if err != nil {
+		return err
+	}
+	byName := map[string]*dto.MetricFamily{}
+	for _, mf := range mfs {
+		byName[mf.GetName()] = mf
+	}
+
+	var status []sloStatus
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, o := range e.cfg.Objectives {
+		good, total := countEvents(o, byName)
+		h := append(e.history[o.Name], sample{at: now, good: good, total: total})
+		// keep one sample at or before now-keep so the longest window is whole
+		for len(h) > 1 && now.Sub(h[1].at) >= e.keep {
+			h = h[1:]
+		}
+		e.history[o.Name] = h
+
+		st := sloStatus{objective: o, BudgetRemaining: 1}
+		budget := 1 - o.Target
+		if total > 0 {
+			st.BudgetRemaining = 1 - (total-good)/total/budget
+		}
+		sloBudgetRemaining.WithLabelValues(o.Name).Set(st.BudgetRemaining)
+
+		burns := map[time.Duration]float64{}
+		windowOf := func(w time.Duration) float64 {
+			if b, ok := burns[w]; ok {
+				return b
+			}
+			ws := window(h, now, w)
+			ws.BurnRate = ws.ErrorRatio / budget
+			sloErrorRatio.WithLabelValues(o.Name, ws.Window).Set(ws.ErrorRatio)
+			sloBurnRate.WithLabelValues(o.Name, ws.Window).Set(ws.BurnRate)
+			st.Windows = append(st.Windows, ws)
+			burns[w] = ws.BurnRate
+			return ws.BurnRate
+		}
+		for _, a := range e.cfg.Alerts {
+			long, short := windowOf(a.Long.Duration), windowOf(a.Short.Duration)
+			firing := long > a.Burn && short > a.Burn
+			st.Alerts = append(st.Alerts, alertStatus{Severity: a.Severity, Long: a.Long.String(), Short: a.Short.String(), Burn: a.Burn, Firing: firing})
+			v := 0.0
+			if firing {
+				v = 1
+			}
+			sloAlert.WithLabelValues(o.Name, a.Severity, a.Long.String(), a.Short.String()).Set(v)
+		}
+		status = append(status, st)
+	}
+	e.status = status
+	return nil
+}
+
+// window differences the history over the last w. With less history than
+// w it uses the oldest sample and marks the result partial.
+func window(h []sample, now time.Time, w time.Duration) windowStatus {
+	ws := windowStatus{Window: w.String()}
+	if len(h) == 0 {
+		return ws
+	}
+	last, from := h[len(h)-1], h[0]
+	ws.Partial = now.Sub(from.at) < w
+	for _, s := range h {
+		if now.Sub(s.at) < w {
+			break
+		}
+		from = s
+	}
+	ws.Events = last.total - from.total
+	if ws.Events > 0 {
+		ws.ErrorRatio = ((last.total - last.good) - (from.total - from.good)) / ws.Events
+	}
+	return ws
+}
+
+func isBucketBound(v float64) bool {
+	for _, b := range requestDurationBuckets {
+		if math.Abs(b-v) < 1e-9 {
+			return true
+		}
+	}
+	return false
+}
+
+// countEvents reads the cumulative good and total events for o.
+func countEvents(o objective, byName map[string]*dto.MetricFamily) (good, total float64) {
+	switch o.Kind {
+	case "availability":
+		for _, m := range metricsFor(byName["app_responses_total"], o.Path) {
+			v := m.GetCounter().GetValue()
+			total += v
+			if !strings.HasPrefix(labelValue(m, "code"), "5") {
+				good += v
+			}
+		}
+	case "latency":
+		for _, m := range metricsFor(byName["app_request_duration_seconds"], o.Path) {
+			hist := m.GetHistogram()
+			total += float64(hist.GetSampleCount())
+			for _, b := range hist.GetBucket() {
+				if math.Abs(b.GetUpperBound()-o.Threshold) < 1e-9 {
+					good += float64(b.GetCumulativeCount())
+				}
+			}
+		}
+	}
+	return good, total
+}
+
+func metricsFor(mf *dto.MetricFamily, path string) []*dto.Metric {
+	var out []*dto.Metric
+	for _, m := range mf.GetMetric() {
+		if labelValue(m, "path") == path {
+			out = append(out, m)
+		}
+	}
+	return out
+}
+
+func labelValue(m *dto.Metric, name string) string {
+	for _, l := range m.GetLabel() {
+		if l.GetName() == name {
+			return l.GetValue()
+		}
+	}
+	return ""
+}
+
+// ServeHTTP serves /slo: each objective with its windows and alerts.
+func (e *sloEngine) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	e.mu.Lock()
+	status := e.status
+	e.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(map[string]interface{}{"tick": e.cfg.Tick, "objectives": status})
+}
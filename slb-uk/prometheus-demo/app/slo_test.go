@@ -0,0 +1,198 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var t0 = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+func TestWindow(t *testing.T) {
+	h := []sample{
+		{at: t0, good: 0, total: 0},
+		{at: t0.Add(time.Minute), good: 90, total: 100},
+		{at: t0.Add(2 * time.Minute), good: 180, total: 200},
+		{at: t0.Add(3 * time.Minute), good: 200, total: 300},
+	}
+	now := t0.Add(3 * time.Minute)
+	cases := []struct {
+		w       time.Duration
+		events  float64
+		ratio   float64
+		partial bool
+	}{
+		{time.Minute, 100, 0.8, false},
+		{2 * time.Minute, 200, 0.45, false},
+		{3 * time.Minute, 300, 100.0 / 300, false},
+		// less history than the window: from the oldest sample
+		{time.Hour, 300, 100.0 / 300, true},
+	}
+	for _, tc := range cases {
+		ws := window(h, now, tc.w)
+		if ws.Events != tc.events || math.Abs(ws.ErrorRatio-tc.ratio) > 1e-9 || ws.Partial != tc.partial {
+			t.Errorf("window %s: %+v", tc.w, ws)
+		}
+	}
+	if ws := window(nil, now, time.Hour); ws.Events != 0 || ws.ErrorRatio != 0 {
+		t.Errorf("empty history: %+v", ws)
+	}
+	// no traffic in the window is no errors, not NaN
+	idle := []sample{{at: t0, good: 5, total: 5}, {at: now, good: 5, total: 5}}
+	if ws := window(idle, now, time.Minute); ws.ErrorRatio != 0 {
+		t.Errorf("idle window: %+v", ws)
+	}
+}
+
+// sloRun is an engine on a registry of its own, fed one tick a minute.
+type sloRun struct {
+	e         *sloEngine
+	responses *prometheus.CounterVec
+	now       time.Time
+}
+
+func newSLORun(t *testing.T, alerts ...alertRule) *sloRun {
+	t.Helper()
+	reg := prometheus.NewRegistry()
+	r := &sloRun{now: t0, responses: prometheus.NewCounterVec(prometheus.CounterOpts{Name: "app_responses_total"}, []string{"path", "code"})}
+	reg.MustRegister(r.responses)
+	cfg := sloConfig{
+		Objectives: []objective{{Name: "work-availability", Kind: "availability", Path: "/work", Target: 0.99}},
+		Alerts:     alerts,
+	}
+	r.e = newSLOEngine(cfg, reg)
+	if err := r.e.evaluate(r.now); err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+// minutes serves 1000 requests a minute for n minutes, errorRatio of them
+// 5xx, evaluating after each minute.
+func (r *sloRun) minutes(t *testing.T, n int, errorRatio float64) {
+	t.Helper()
+	bad := math.Round(1000 * errorRatio)
+	for range n {
+		r.responses.WithLabelValues("/work", "200").Add(1000 - bad)
+		r.responses.WithLabelValues("/work", "500").Add(bad)
+		r.responses.WithLabelValues("/other", "500").Add(1000) // not the objective's path
+		r.now = r.now.Add(time.Minute)
+		if err := r.e.evaluate(r.now); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func (r *sloRun) status() sloStatus {
+	r.e.mu.Lock()
+	defer r.e.mu.Unlock()
+	return r.e.status[0]
+}
+
+func (s sloStatus) burn(window string) float64 {
+	for _, w := range s.Windows {
+		if w.Window == window {
+			return w.BurnRate
+		}
+	}
+	return math.NaN()
+}
+
+// The fast-burn page of the SRE workbook: 1h and 5m windows over 14.4.
+func TestBurnRateAlert(t *testing.T) {
+	page := alertRule{Severity: "page", Long: jsonDuration{time.Hour}, Short: jsonDuration{5 * time.Minute}, Burn: 14.4}
+	type phase struct {
+		minutes    int
+		errorRatio float64
+	}
+	cases := []struct {
+		name        string
+		phases      []phase
+		long, short float64 // burn rates
+		firing      bool
+	}{
+		{"healthy", []phase{{60, 0.001}}, 0.1, 0.1, false},
+		{"on budget", []phase{{60, 0.01}}, 1, 1, false},
+		{"just under", []phase{{60, 0.14}}, 14, 14, false},
+		{"sustained", []phase{{60, 0.15}}, 15, 15, true},
+		// a spike the long window has not seen enough of yet
+		{"short spike", []phase{{55, 0}, {5, 1}}, 100.0 * 5 / 60, 100, false},
+		// the long window still remembers, but it is over
+		{"recovered", []phase{{50, 0.5}, {10, 0}}, 50.0 * 50 / 60, 0, false},
+		// errors that fell out of the long window no longer count
+		{"old incident", []phase{{30, 1}, {70, 0.2}}, 20, 20, true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := newSLORun(t, page)
+			for _, p := range tc.phases {
+				r.minutes(t, p.minutes, p.errorRatio)
+			}
+			st := r.status()
+			if long := st.burn("1h0m0s"); math.Abs(long-tc.long) > 1e-6 {
+				t.Errorf("1h burn %v, want %v", long, tc.long)
+			}
+			if short := st.burn("5m0s"); math.Abs(short-tc.short) > 1e-6 {
+				t.Errorf("5m burn %v, want %v", short, tc.short)
+			}
+			if len(st.Alerts) != 1 || st.Alerts[0].Firing != tc.firing {
+				t.Errorf("alerts %+v, want firing %v", st.Alerts, tc.firing)
+			}
+		})
+	}
+}
+
+func TestBurnRateAlertPairs(t *testing.T) {
+	r := newSLORun(t,
+		alertRule{Severity: "page", Long: jsonDuration{time.Hour}, Short: jsonDuration{5 * time.Minute}, Burn: 14.4},
+		alertRule{Severity: "page", Long: jsonDuration{6 * time.Hour}, Short: jsonDuration{30 * time.Minute}, Burn: 6},
+		alertRule{Severity: "ticket", Long: jsonDuration{3 * 24 * time.Hour}, Short: jsonDuration{6 * time.Hour}, Burn: 1})
+	// seven hours at a burn of 8: too slow for the fast page, fast enough
+	// for the slow one; the ticket's 3d window is partial, from the start
+	r.minutes(t, 7*60, 0.08)
+	st := r.status()
+	var firing []bool
+	for _, a := range st.Alerts {
+		firing = append(firing, a.Firing)
+	}
+	if len(firing) != 3 || firing[0] || !firing[1] || !firing[2] {
+		t.Fatalf("alerts %+v", st.Alerts)
+	}
+	// a window shared by two pairs is computed once
+	seen := map[string]bool{}
+	for _, w := range st.Windows {
+		if seen[w.Window] {
+			t.Errorf("window %s twice", w.Window)
+		}
+		seen[w.Window] = true
+		if w.Partial != (w.Window == "72h0m0s") {
+			t.Errorf("window %s partial = %v", w.Window, w.Partial)
+		}
+	}
+	// 8% errors against a 1% budget: seven times the budget spent
+	if math.Abs(st.BudgetRemaining-(-7)) > 1e-6 {
+		t.Errorf("budget remaining %v", st.BudgetRemaining)
+	}
+	// the history is trimmed to the longest window
+	if h := r.e.history["work-availability"]; len(h) != 7*60+1 {
+		t.Errorf("%d samples kept", len(h))
+	}
+}
+
+func TestLatencyEvents(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	d := prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: "app_request_duration_seconds", Buckets: requestDurationBuckets}, []string{"path"})
+	reg.MustRegister(d)
+	for _, v := range []float64{0.01, 0.15, 0.2, 0.3, 2} {
+		d.WithLabelValues("/work").Observe(v)
+	}
+	e := newSLOEngine(sloConfig{Objectives: []objective{{Name: "work-latency", Kind: "latency", Path: "/work", Target: 0.95, Threshold: 0.2}}}, reg)
+	if err := e.evaluate(t0); err != nil {
+		t.Fatal(err)
+	}
+	if h := e.history["work-latency"]; len(h) != 1 || h[0].good != 3 || h[0].total != 5 {
+		t.Fatalf("history %+v", h)
+	}
+}
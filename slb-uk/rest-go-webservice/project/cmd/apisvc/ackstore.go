@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/redis/go-redis/v9"
+
+	"github.com/slb-uk/rest-go-webservice/project/pkg/config"
+)
+
+// ackStore keeps consumer results by trace id for /operations/{trace_id}
+// and the push transports. Acks are consumed by one apisvc replica of the
+// api-acks group, so with more than one replica the store has to be shared
+// (ACK_STORE=redis) for any replica to answer.
+type ackStore interface {
+	Put(ctx context.Context, a Ack)
+	Get(ctx context.Context, traceID string) (Ack, bool)
+}
+
+// results is set from ACK_STORE in main.
+var results ackStore
+
+func openAckStore(conf *config.API) (ackStore, error) {
+	ttl := conf.AckStore.TTL
+	switch conf.AckStore.Store {
+	case "memory":
+		return newMemAckStore(ttl), nil
+	case "redis":
+		c, err := openRedis(conf.RedisAddr)
+		if err != nil {
+			return nil, err
+		}
+		s := &redisAckStore{c: c, ttl: ttl, origin: uuid.NewString()}
+		go s.relay()
+		return s, nil
+	default:
+		return nil, errors.New("unknown ACK_STORE")
+	}
+}
+
+func openRedis(addr string) (*redis.Client, error) {
+	c := redis.NewClient(&redis.Options{Addr: addr})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := c.Ping(ctx).Err(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// putAck stores a and then wakes the handlers waiting for it. Waiters
+// subscribe before they look in the store, so an ack is either found there
+// or delivered to them; nothing polls.
+func putAck(a Ack) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	results.Put(ctx, a)
+	acksBus.publish(a)
+}
+
+func getAck(id string) (Ack, bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	return results.Get(ctx, id)
+}
+
+type memAckStore struct {
+	mu  sync.RWMutex
+	m   map[string]memEntry
+	ttl time.Duration
+}
+
+func newMemAckStore(ttl time.Duration) *memAckStore {
+	s := &memAckStore{m: make(map[string]memEntry), ttl: ttl}
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "apisvc_ack_store_entries",
+		Help: "Acks held by the in-memory ack store (ACK_STORE=memory), expired ones included until the next sweep.",
+	}, func() float64 {
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+		return float64(len(s.m))
+	})
+	go func() {
+		for range time.Tick(30 * time.Second) {
+			s.mu.Lock()
+			for k, e := range s.m {
+				if time.Now().After(e.expires) {
+					delete(s.m, k)
+				}
+			}
+			s.mu.Unlock()
+		}
+	}()
+	return s
+}
+
+func (s *memAckStore) Put(_ context.Context, a Ack) {
+	s.mu.Lock()
+	s.m[a.TraceID] = memEntry{ack: a, expires: time.Now().Add(s.ttl)}
+	s.mu.Unlock()
+}
+
+func (s *memAckStore) Get(_ context.Context, traceID string) (Ack, bool) {
+	s.mu.RLock()
+	e, ok := s.m[traceID]
+	s.mu.RUnlock()
+	if !ok || time.Now().After(e.expires) {
+		return Ack{}, false
+	}
+	return e.ack, true
+}
+
+// ackChannel carries every Put to the other replicas, so a client waiting
+// on one replica hears about an ack another replica consumed.
+const ackChannel = "apisvc:acks"
+
+type relayedAck struct {
+	Origin string `json:"origin"`
+	Ack    Ack    `json:"ack"`
+}
+
+// redisAckStore keeps acks as keys with a TTL and relays them between
+// replicas over pub/sub. A waiter that subscribed after the relay still
+// finds the ack under its key.
+type redisAckStore struct {
+	c      *redis.Client
+	ttl    time.Duration
+	origin string // this replica; its own relays are skipped
+}
+
+func (s *redisAckStore) key(traceID string) string { return "apisvc:ack:" + traceID }
+
+func (s *redisAckStore) Put(ctx context.Context, a Ack) {
+	b, _ := json.Marshal(a)
+	if err := s.c.Set(ctx, s.key(a.TraceID), b, s.ttl).Err(); err != nil {
+		slog.WarnContext(ctx, "ack store set", "trace_id", a.TraceID, "err", err)
+		return
+	}
+	msg, _ := json.Marshal(relayedAck{Origin: s.origin, Ack: a})
+	if err := s.c.Publish(ctx, ackChannel, msg).Err(); err != nil {
+		slog.WarnContext(ctx, "ack store publish", "trace_id", a.TraceID, "err", err)
+	}
+}
+
+func (s *redisAckStore) Get(ctx context.Context, traceID string) (Ack, bool) {
+	b, err := s.c.Get(ctx, s.key(traceID)).Bytes()
+	if err != nil {
+		if !errors.Is(err, redis.Nil) {
+			slog.WarnContext(ctx, "ack store get", "trace_id", traceID, "err", err)
+		}
+		return Ack{}, false
+	}
+	var a Ack
+	if json.Unmarshal(b, &a) != nil {
+		return Ack{}, false
+	}
+	return a, true
+}
+
+// relay hands acks put by other replicas to local waiters. go-redis
+// resubscribes by itself after a dropped connection.
+func (s *redisAckStore) relay() {
+	sub := s.c.Subscribe(context.Background(), ackChannel)
+	for m := range sub.Channel() {
+		var r relayedAck
+		if json.Unmarshal([]byte(m.Payload), &r) != nil || r.Origin == s.origin {
+			continue
+		}
+		acksBus.publish(r.Ack)
+		pending.done(r.Ack)
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/IBM/sarama"
+
+	"github.com/slb-uk/rest-go-webservice/project/pkg/audit"
+	"github.com/slb-uk/rest-go-webservice/project/pkg/bulk"
+	"github.com/slb-uk/rest-go-webservice/project/pkg/deployment"
+	"github.com/slb-uk/rest-go-webservice/project/pkg/problem"
+)
+
+const maxBulkBodyBytes = 64 << 10
+
+// @Summary Delete the messages a query matches
+// @Description Enqueues a DeleteByQuery command. consumersvc deletes the matching messages of the
+// @Description tenant CONSUMER_BULK_CHUNK at a time, each chunk in its own transaction, and sends a
+// @Description PROGRESS ack after every chunk: /operations/{trace_id} and its events report the
+// @Description percent done until the final ack, whose payload has processed and total. Every
+// @Description deleted message gets an audit entry. Unknown query fields are rejected rather than
+// @Description ignored. Needs a token with AUTH_JWT_ADMIN_SCOPE on a route AUTH_JWT_ROUTES covers;
+// @Description without one the route answers 401.
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param X-Tenant-ID header string false "Tenant (defaults to \"default\")"
+// @Param request body bulk.DeleteRequest true "At least one query criterion"
+// @Param Idempotency-Key header string false "Retry-safe key, 1-255 visible ASCII; a retry within IDEMPOTENCY_TTL returns the first trace_id or its ack"
+// @Success 200 {object} acceptedResp
+// @Failure 400 {object} problem.Details "INVALID_BODY or INVALID_HEADER"
+// @Failure 422 {object} problem.Details "IDEMPOTENCY_KEY_REUSED: the key was used for a different request"
+// @Failure 403 {object} problem.Details "UNKNOWN_TENANT; INSUFFICIENT_SCOPE without the admin scope"
+// @Failure 503 {object} problem.Details "KAFKA_UNAVAILABLE or ENQUEUE_FAILED, with Retry-After"
+// @Failure 401 {object} problem.Details "INVALID_REQUEST, also when AUTH_JWT_ROUTES does not cover the route, or INVALID_TOKEN"
+// @Security BearerAuth
+// @Router /admin/messages:deleteByQuery [post]
+func deleteByQueryHandler(producer sarama.SyncProducer, cmdTopic string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		tid, ok := resolveTenant(w, r)
+		if !ok {
+			return
+		}
+		var req bulk.DeleteRequest
+		if err := readBulkBody(w, r, &req, func() error { return req.Query.Validate() }); err != nil {
+			problem.Write(w, r, http.StatusBadRequest, problem.CodeInvalidBody, err.Error())
+			return
+		}
+		enqueueCommand(w, r, producer, cmdTopic, tid, audit.FromRequest(r), deployment.FromContext(r.Context()), "DeleteByQuery", bulkPayload(req))
+	}
+}
+
+// @Summary Set the text of the messages a query matches
+// @Description Enqueues an UpdateByQuery command, which sets every matching message to message.
+// @Description It runs in chunks with PROGRESS acks like deleteByQuery; messages that already have
+// @Description the new text are not counted or changed, so running it again after an interruption
+// @Description only does the rest. Attachments are kept. Needs a token with AUTH_JWT_ADMIN_SCOPE
+// @Description on a route AUTH_JWT_ROUTES covers; without one the route answers 401.
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param X-Tenant-ID header string false "Tenant (defaults to \"default\")"
+// @Param request body bulk.UpdateRequest true "At least one query criterion and a non-empty message"
+// @Param Idempotency-Key header string false "Retry-safe key, 1-255 visible ASCII; a retry within IDEMPOTENCY_TTL returns the first trace_id or its ack"
+// @Success 200 {object} acceptedResp
+// @Failure 400 {object} problem.Details "INVALID_BODY or INVALID_HEADER"
+// @Failure 422 {object} problem.Details "IDEMPOTENCY_KEY_REUSED: the key was used for a different request"
+// @Failure 403 {object} problem.Details "UNKNOWN_TENANT; INSUFFICIENT_SCOPE without the admin scope"
+// @Failure 503 {object} problem.Details "KAFKA_UNAVAILABLE or ENQUEUE_FAILED, with Retry-After"
+// @Failure 401 {object} problem.Details "INVALID_REQUEST, also when AUTH_JWT_ROUTES does not cover the route, or INVALID_TOKEN"
+// @Security BearerAuth
+// @Router /admin/messages:updateByQuery [post]
+func updateByQueryHandler(producer sarama.SyncProducer, cmdTopic string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		tid, ok := resolveTenant(w, r)
+		if !ok {
+			return
+		}
+		var req bulk.UpdateRequest
+		err := readBulkBody(w, r, &req, func() error {
+			if strings.TrimSpace(req.Message) == "" {
+				return errors.New(`a non-empty "message" is required`)
+			}
+			return req.Query.Validate()
+		})
+		if err != nil {
+			problem.Write(w, r, http.StatusBadRequest, problem.CodeInvalidBody, err.Error())
+			return
+		}
+		enqueueCommand(w, r, producer, cmdTopic, tid, audit.FromRequest(r), deployment.FromContext(r.Context()), "UpdateByQuery", bulkPayload(req))
+	}
+}
+
+// readBulkBody decodes the JSON body into v and runs validate on it. A
+// misspelt criterion would widen the query, so unknown fields are errors.
+func readBulkBody(w http.ResponseWriter, r *http.Request, v any, validate func() error) error {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBulkBodyBytes))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return errors.New("invalid JSON body: " + err.Error())
+	}
+	return validate()
+}
+
+// bulkPayload is the command payload of a bulk request.
+func bulkPayload(req any) map[string]any {
+	b, _ := json.Marshal(req)
+	var payload map[string]any
+	_ = json.Unmarshal(b, &payload)
+	return payload
+}
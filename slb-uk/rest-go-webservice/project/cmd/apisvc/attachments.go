@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+
+	"github.com/slb-uk/rest-go-webservice/project/pkg/audit"
+	"github.com/slb-uk/rest-go-webservice/project/pkg/blob"
+	"github.com/slb-uk/rest-go-webservice/project/pkg/config"
+	"github.com/slb-uk/rest-go-webservice/project/pkg/deployment"
+	"github.com/slb-uk/rest-go-webservice/project/pkg/problem"
+)
+
+var (
+	// blobs holds attachment bytes; Kafka commands only carry a blob.Ref
+	blobs blob.Store
+	// maxAttachmentBytes caps a single upload (MAX_ATTACHMENT_BYTES)
+	maxAttachmentBytes int64 = 10 << 20
+)
+
+const maxMessageFieldBytes = 64 << 10
+
+var (
+	errBadBody  = errors.New("invalid body")
+	errTooLarge = errors.New("attachment too large")
+)
+
+func openBlobStore(conf *config.API) (blob.Store, error) {
+	a := conf.Attachments
+	switch a.Store {
+	case "fs":
+		return blob.NewFS(a.Dir)
+	case "s3", "minio":
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		return blob.NewS3(ctx, blob.S3Config{
+			Endpoint:  a.S3Endpoint,
+			Bucket:    a.S3Bucket,
+			AccessKey: a.S3AccessKey,
+			SecretKey: a.S3SecretKey,
+			UseSSL:    a.S3UseSSL,
+		})
+	case "none":
+		return nil, nil
+	default:
+		return nil, errors.New("unknown BLOB_STORE")
+	}
+}
+
+// readMessageBody accepts either the plain JSON body or a multipart form
+// with a "message" field and an optional "attachment" file. The file is
+// streamed straight into the blob store; the returned ref (nil when there
+// was no file) is what goes into the command.
+func readMessageBody(r *http.Request, tenantID string) (messageBody, *blob.Ref, error) {
+	var b messageBody
+	ct, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if ct != "multipart/form-data" {
+		if json.NewDecoder(r.Body).Decode(&b) != nil || strings.TrimSpace(b.Message) == "" {
+			return b, nil, errBadBody
+		}
+		return b, nil, nil
+	}
+
+	if blobs == nil {
+		return b, nil, errors.New("attachments are disabled")
+	}
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return b, nil, errBadBody
+	}
+	var ref *blob.Ref
+	// on any later failure the uploaded blob is orphaned; remove it
+	fail := func(err error) (messageBody, *blob.Ref, error) {
+		if ref != nil {
+			_ = blobs.Delete(r.Context(), ref.Key)
+		}
+		return b, nil, err
+	}
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fail(errBadBody)
+		}
+		switch part.FormName() {
+		case "message":
+			v, err := io.ReadAll(io.LimitReader(part, maxMessageFieldBytes))
+			if err != nil {
+				return fail(errBadBody)
+			}
+			b.Message = string(v)
+		case "attachment":
+			if ref != nil {
+				return fail(errors.New("only one attachment is allowed"))
+			}
+			ref, err = storeAttachment(r.Context(), tenantID, part)
+			if err != nil {
+				return fail(err)
+			}
+		}
+		part.Close()
+	}
+	if strings.TrimSpace(b.Message) == "" {
+		return fail(errBadBody)
+	}
+	return b, ref, nil
+}
+
+func storeAttachment(ctx context.Context, tenantID string, part *multipart.Part) (*blob.Ref, error) {
+	ct := part.Header.Get("Content-Type")
+	if ct == "" {
+		ct = "application/octet-stream"
+	}
+	ref := &blob.Ref{
+		Key:         path.Join(tenantID, "attachments", uuid.NewString()),
+		Filename:    path.Base(part.FileName()),
+		ContentType: ct,
+	}
+	h := sha256.New()
+	// one byte over the limit tells us the upload was too large
+	lr := &io.LimitedReader{R: part, N: maxAttachmentBytes + 1}
+	n, err := blobs.Put(ctx, ref.Key, io.TeeReader(lr, h), ct)
+	if err != nil {
+		_ = blobs.Delete(ctx, ref.Key)
+		return nil, err
+	}
+	if n > maxAttachmentBytes {
+		_ = blobs.Delete(ctx, ref.Key)
+		return nil, errTooLarge
+	}
+	ref.Size = n
+	ref.SHA256 = hex.EncodeToString(h.Sum(nil))
+	return ref, nil
+}
+
+func writeBodyError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, errBadBody):
+		problem.Write(w, r, http.StatusBadRequest, problem.CodeInvalidBody, `a non-empty "message" is required`)
+	case errors.Is(err, errTooLarge):
+		problem.Write(w, r, http.StatusRequestEntityTooLarge, problem.CodeTooLarge,
+			fmt.Sprintf("attachments are limited to %d bytes", maxAttachmentBytes))
+	default:
+		slog.WarnContext(r.Context(), "attachment upload", "err", err)
+		problem.Write(w, r, http.StatusBadRequest, problem.CodeInvalidBody, err.Error())
+	}
+}
+
+// @Summary Download a message's attachment
+// @Tags messages
+// @Produce application/octet-stream
+// @Param id path int true "Message ID"
+// @Param X-Tenant-ID header string false "Tenant (defaults to \"default\")"
+// @Success 200 {file} file
+// @Failure 400 {object} problem.Details "INVALID_PARAM: id is not a positive integer"
+// @Failure 404 {object} problem.Details "NOT_FOUND: no such message or no attachment"
+// @Failure 501 {object} problem.Details "NOT_IMPLEMENTED: attachments are disabled"
+// @Failure 502 {object} problem.Details "STORAGE_ERROR"
+// @Failure 504 {object} problem.Details "TIMEOUT"
+// @Failure 401 {object} problem.Details "INVALID_REQUEST or INVALID_TOKEN (when AUTH_JWT_ROUTES covers the route)"
+// @Security BearerAuth
+// @Router /messages/{id}/attachment [get]
+func attachmentHandler(p sarama.SyncProducer, topic string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		tid, ok := resolveTenant(w, r)
+		if !ok {
+			return
+		}
+		serveAttachment(w, r, p, topic, tid, chi.URLParam(r, "id"))
+	}
+}
+
+// attachmentRef finds the attachment of message idStr in the read model,
+// or else asks the consumer for the message like any other read and takes
+// the blob key from the ack. ok is false once it has answered w.
+func attachmentRef(w http.ResponseWriter, r *http.Request, p sarama.SyncProducer, topic, tenantID, idStr string) (blob.Ref, bool) {
+	m, found, err := readMessage(r.Context(), tenantID, idStr)
+	switch {
+	case found && err != nil:
+		problem.Write(w, r, http.StatusNotFound, problem.CodeNotFound, "id="+idStr)
+		return blob.Ref{}, false
+	case found && m.Attachment == nil:
+		problem.Write(w, r, http.StatusNotFound, problem.CodeNotFound, "message "+idStr+" has no attachment")
+		return blob.Ref{}, false
+	case found:
+		return *m.Attachment, true
+	}
+
+	traceID, err := publishCommand(r.Context(), p, topic, tenantID, audit.FromRequest(r), deployment.FromContext(r.Context()), "Read", map[string]any{"id": idStr})
+	if err != nil {
+		writeEnqueueError(w, r, err)
+		return blob.Ref{}, false
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 15*time.Second)
+	defer cancel()
+	a, ok := awaitAck(ctx, traceID, tenantID)
+	if !ok {
+		timedOut(w, r, traceID)
+		return blob.Ref{}, false
+	}
+	if a.Error != nil {
+		problem.FromAck(traceID, a.Error.Code, a.Error.Detail).Write(w, r)
+		return blob.Ref{}, false
+	}
+	att, _ := a.Payload["attachment"].(map[string]any)
+	ref, ok := blob.RefFromMap(att)
+	if !ok {
+		problem.Write(w, r, http.StatusNotFound, problem.CodeNotFound, "message "+idStr+" has no attachment")
+		return blob.Ref{}, false
+	}
+	return ref, true
+}
+
+func serveAttachment(w http.ResponseWriter, r *http.Request, p sarama.SyncProducer, topic, tenantID, idStr string) {
+	if blobs == nil {
+		problem.Write(w, r, http.StatusNotImplemented, problem.CodeNotImplemented, "attachments are disabled")
+		return
+	}
+	ref, ok := attachmentRef(w, r, p, topic, tenantID, idStr)
+	if !ok {
+		return
+	}
+
+	rc, info, err := blobs.Get(r.Context(), ref.Key)
+	if errors.Is(err, blob.ErrNotFound) {
+		problem.Write(w, r, http.StatusNotFound, problem.CodeNotFound, "attachment of message "+idStr+" is gone from the blob store")
+		return
+	} else if err != nil {
+		slog.ErrorContext(r.Context(), "attachment get", "tenant_id", tenantID, "id", idStr, "err", err)
+		problem.Write(w, r, http.StatusBadGateway, problem.CodeStorageError, "blob store unavailable")
+		return
+	}
+	defer rc.Close()
+	w.Header().Set("Content-Type", info.ContentType)
+	w.Header().Set("Content-Length", strconv.FormatInt(info.Size, 10))
+	if ref.Filename != "" {
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": ref.Filename}))
+	}
+	_, _ = io.Copy(w, rc)
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/IBM/sarama"
+
+	"github.com/slb-uk/rest-go-webservice/project/pkg/audit"
+	"github.com/slb-uk/rest-go-webservice/project/pkg/deployment"
+	"github.com/slb-uk/rest-go-webservice/project/pkg/problem"
+)
+
+// @Summary List audit entries
+// @Description Who changed what and when, newest first. Every Create, Update and Delete is recorded
+// @Description by the consumer with the caller's X-Auth-Subject (set by the gateway), the command's
+// @Description trace id and a field diff; failed commands are listed with their error code. Pass
+// @Description next_cursor back as cursor for the next page. Read from the read model when
+// @Description READ_MODEL_DSN is set.
+// @Tags audit
+// @Produce json
+// @Param resource query string false "Resource, e.g. Message"
+// @Param id query string false "Resource id"
+// @Param actor query string false "Authenticated subject"
+// @Param command query string false "Create, Update or Delete"
+// @Param since query string false "RFC 3339 time, inclusive"
+// @Param until query string false "RFC 3339 time, exclusive"
+// @Param limit query int false "Page size (default 20, max 100)"
+// @Param cursor query int false "next_cursor of the previous page"
+// @Param X-Tenant-ID header string false "Tenant (defaults to \"default\")"
+// @Success 200 {object} audit.Page
+// @Failure 400 {object} problem.Details "INVALID_PARAM or BAD_REQUEST"
+// @Failure 403 {object} problem.Details "UNKNOWN_TENANT"
+// @Failure 504 {object} problem.Details "TIMEOUT"
+// @Failure 401 {object} problem.Details "INVALID_REQUEST or INVALID_TOKEN (when AUTH_JWT_ROUTES covers the route)"
+// @Security BearerAuth
+// @Router /audit [get]
+func auditHandler(producer sarama.SyncProducer, cmdTopic string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		tid, ok := resolveTenant(w, r)
+		if !ok {
+			return
+		}
+		f, err := parseAuditFilter(r)
+		if err != nil {
+			problem.Write(w, r, http.StatusBadRequest, problem.CodeInvalidParam, err.Error())
+			return
+		}
+		if readModel != nil {
+			f.TenantID = tid
+			page, err := readModel.Audit(r.Context(), f)
+			if err == nil {
+				w.Header().Set("Content-Type", "application/json")
+				_ = json.NewEncoder(w).Encode(page)
+				return
+			}
+			slog.WarnContext(r.Context(), "read model audit, falling back to kafka", "tenant_id", tid, "err", err)
+		}
+		b, _ := json.Marshal(f)
+		var payload map[string]any
+		_ = json.Unmarshal(b, &payload)
+
+		// without a read model the consumer answers from audit_log
+		traceID, err := publishCommand(r.Context(), producer, cmdTopic, tid, audit.FromRequest(r), deployment.FromContext(r.Context()), "QueryAudit", payload)
+		if err != nil {
+			writeEnqueueError(w, r, err)
+			return
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), 15*time.Second)
+		defer cancel()
+		a, ok := awaitAck(ctx, traceID, tid)
+		if !ok {
+			timedOut(w, r, traceID)
+			return
+		}
+		if a.Status != "SUCCESS" {
+			code, detail := "", "audit query failed"
+			if a.Error != nil {
+				code, detail = a.Error.Code, a.Error.Detail
+			}
+			problem.FromAck(traceID, code, detail).Write(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(a.Payload)
+	}
+}
+
+func parseAuditFilter(r *http.Request) (audit.Filter, error) {
+	q := r.URL.Query()
+	f := audit.Filter{
+		Resource:   q.Get("resource"),
+		ResourceID: q.Get("id"),
+		Actor:      q.Get("actor"),
+		Command:    q.Get("command"),
+	}
+	var err error
+	if v := q.Get("since"); v != "" {
+		if f.Since, err = time.Parse(time.RFC3339, v); err != nil {
+			return f, errInvalidParam("since")
+		}
+	}
+	if v := q.Get("until"); v != "" {
+		if f.Until, err = time.Parse(time.RFC3339, v); err != nil {
+			return f, errInvalidParam("until")
+		}
+	}
+	if v := q.Get("limit"); v != "" {
+		if f.Limit, err = strconv.Atoi(v); err != nil || f.Limit < 1 || f.Limit > audit.MaxLimit {
+			return f, errInvalidParam("limit")
+		}
+	}
+	if v := q.Get("cursor"); v != "" {
+		if f.Cursor, err = strconv.ParseInt(v, 10, 64); err != nil || f.Cursor < 1 {
+			return f, errInvalidParam("cursor")
+		}
+	}
+	return f, nil
+}
+
+type errInvalidParam string
+
+func (e errInvalidParam) Error() string { return "invalid " + string(e) }
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/slb-uk/rest-go-webservice/project/pkg/breaker"
+	"github.com/slb-uk/rest-go-webservice/project/pkg/problem"
+)
+
+var (
+	breakerState = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "apisvc_kafka_breaker_state",
+		Help: "Kafka produce circuit breaker per endpoint: 0 closed, 1 half-open, 2 open.",
+	}, []string{"endpoint"})
+
+	breakerRejectedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "apisvc_kafka_breaker_rejected_total",
+		Help: "Commands failed fast because the endpoint's Kafka breaker was open.",
+	}, []string{"endpoint"})
+
+	// breakerSettings get Failures (0 disables), OpenFor and Probes from
+	// the config in main.
+	breakerSettings = breaker.Settings{
+		Failures: 5,
+		OpenFor:  30 * time.Second,
+		Probes:   1,
+		OnStateChange: func(name string, from, to breaker.State) {
+			slog.Warn("kafka breaker", "endpoint", name, "from", from.String(), "to", to.String())
+			breakerState.WithLabelValues(name).Set(float64(to))
+		},
+	}
+
+	breakersMu sync.Mutex
+	breakers   = map[string]*breaker.Breaker{}
+)
+
+// endpointBreaker returns the breaker for one API endpoint, named after the
+// command it produces (Create is POST /messages, Read is GET /messages/{id},
+// ...). Separate breakers keep a topic that is down for one kind of command
+// from failing the others.
+func endpointBreaker(cmd string) *breaker.Breaker {
+	breakersMu.Lock()
+	defer breakersMu.Unlock()
+	b, ok := breakers[cmd]
+	if !ok {
+		b = breaker.New(cmd, breakerSettings)
+		breakers[cmd] = b
+		breakerState.WithLabelValues(cmd).Set(float64(breaker.Closed))
+	}
+	return b
+}
+
+// writeEnqueueError answers a command that could not be produced with 503
+// and a Retry-After hint: the time until the breaker probes again, or one
+// second for a single failed produce.
+func writeEnqueueError(w http.ResponseWriter, r *http.Request, err error) {
+	retry := time.Second
+	code, msg := problem.CodeEnqueueFailed, "enqueue failed"
+	var open *breaker.OpenError
+	if errors.As(err, &open) {
+		retry = open.RetryAfter
+		code, msg = problem.CodeKafkaUnavailable, "kafka unavailable"
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(max(retry, time.Second).Seconds()))))
+	problem.Write(w, r, http.StatusServiceUnavailable, code, msg)
+}
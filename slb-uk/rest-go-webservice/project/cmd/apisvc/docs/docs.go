@@ -1,5 +1,5 @@
-// Package docs Code generated by swaggo/swag. DO NOT EDIT
-package docs
+// Package swagout Code generated by swaggo/swag. DO NOT EDIT
+package swagout
 
 import "github.com/swaggo/swag"
 
@@ -24,9 +24,14 @@ const docTemplate = `{
     "host": "{{.Host}}",
     "basePath": "{{.BasePath}}",
     "paths": {
-        "/messages": {
+        "/admin/messages:deleteByQuery": {
             "post": {
-                "description": "Receives a message payload and publishes to Kafka",
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Enqueues a DeleteByQuery command. consumersvc deletes the matching messages of the\ntenant CONSUMER_BULK_CHUNK at a time, each chunk in its own transaction, and sends a\nPROGRESS ack after every chunk: /operations/{trace_id} and its events report the\npercent done until the final ack, whose payload has processed and total. Every\ndeleted message gets an audit entry. Unknown query fields are rejected rather than\nignored. Needs a token with AUTH_JWT_ADMIN_SCOPE on a route AUTH_JWT_ROUTES covers;\nwithout one the route answers 401.",
                 "consumes": [
                     "application/json"
                 ],
@@ -34,18 +39,30 @@ const docTemplate = `{
                     "application/json"
                 ],
                 "tags": [
-                    "messages"
+                    "admin"
                 ],
-                "summary": "Create a new message",
+                "summary": "Delete the messages a query matches",
                 "parameters": [
                     {
-                        "description": "Message payload",
-                        "name": "message",
+                        "type": "string",
+                        "description": "Tenant (defaults to \\",
+                        "name": "X-Tenant-ID",
+                        "in": "header"
+                    },
+                    {
+                        "description": "At least one query criterion",
+                        "name": "request",
                         "in": "body",
                         "required": true,
                         "schema": {
-                            "$ref": "#/definitions/main.messageBody"
+                            "$ref": "#/definitions/bulk.DeleteRequest"
                         }
+                    },
+                    {
+                        "type": "string",
+                        "description": "Retry-safe key, 1-255 visible ASCII; a retry within IDEMPOTENCY_TTL returns the first trace_id or its ack",
+                        "name": "Idempotency-Key",
+                        "in": "header"
                     }
                 ],
                 "responses": {
@@ -56,162 +73,325 @@ const docTemplate = `{
                         }
                     },
                     "400": {
-                        "description": "invalid body",
+                        "description": "INVALID_BODY or INVALID_HEADER",
                         "schema": {
-                            "type": "string"
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "401": {
+                        "description": "INVALID_REQUEST, also when AUTH_JWT_ROUTES does not cover the route, or INVALID_TOKEN",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "403": {
+                        "description": "UNKNOWN_TENANT; INSUFFICIENT_SCOPE without the admin scope",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "422": {
+                        "description": "IDEMPOTENCY_KEY_REUSED: the key was used for a different request",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "503": {
+                        "description": "KAFKA_UNAVAILABLE or ENQUEUE_FAILED, with Retry-After",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
                         }
                     }
                 }
             }
         },
-        "/messages/{id}": {
-            "get": {
+        "/admin/messages:updateByQuery": {
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Enqueues an UpdateByQuery command, which sets every matching message to message.\nIt runs in chunks with PROGRESS acks like deleteByQuery; messages that already have\nthe new text are not counted or changed, so running it again after an interruption\nonly does the rest. Attachments are kept. Needs a token with AUTH_JWT_ADMIN_SCOPE\non a route AUTH_JWT_ROUTES covers; without one the route answers 401.",
                 "consumes": [
                     "application/json"
                 ],
                 "produces": [
-                    "application/json",
                     "application/json"
                 ],
                 "tags": [
-                    "messages",
-                    "messages",
-                    "messages"
+                    "admin"
                 ],
-                "summary": "Delete a message",
+                "summary": "Set the text of the messages a query matches",
                 "parameters": [
                     {
                         "type": "string",
-                        "description": "Message ID",
-                        "name": "id",
-                        "in": "path",
-                        "required": true
-                    },
-                    {
-                        "type": "string",
-                        "description": "Message ID",
-                        "name": "id",
-                        "in": "path",
-                        "required": true
+                        "description": "Tenant (defaults to \\",
+                        "name": "X-Tenant-ID",
+                        "in": "header"
                     },
                     {
-                        "description": "Updated message",
-                        "name": "message",
+                        "description": "At least one query criterion and a non-empty message",
+                        "name": "request",
                         "in": "body",
                         "required": true,
                         "schema": {
-                            "$ref": "#/definitions/main.messageBody"
+                            "$ref": "#/definitions/bulk.UpdateRequest"
                         }
                     },
                     {
                         "type": "string",
-                        "description": "Message ID",
-                        "name": "id",
-                        "in": "path",
-                        "required": true
+                        "description": "Retry-safe key, 1-255 visible ASCII; a retry within IDEMPOTENCY_TTL returns the first trace_id or its ack",
+                        "name": "Idempotency-Key",
+                        "in": "header"
                     }
                 ],
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/main.Ack"
+                            "$ref": "#/definitions/main.acceptedResp"
                         }
                     },
-                    "204": {
-                        "description": "No Content"
+                    "400": {
+                        "description": "INVALID_BODY or INVALID_HEADER",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "401": {
+                        "description": "INVALID_REQUEST, also when AUTH_JWT_ROUTES does not cover the route, or INVALID_TOKEN",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "403": {
+                        "description": "UNKNOWN_TENANT; INSUFFICIENT_SCOPE without the admin scope",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "422": {
+                        "description": "IDEMPOTENCY_KEY_REUSED: the key was used for a different request",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "503": {
+                        "description": "KAFKA_UNAVAILABLE or ENQUEUE_FAILED, with Retry-After",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
                     }
                 }
-            },
-            "put": {
-                "consumes": [
-                    "application/json"
+            }
+        },
+        "/audit": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
                 ],
+                "description": "Who changed what and when, newest first. Every Create, Update and Delete is recorded\nby the consumer with the caller's X-Auth-Subject (set by the gateway), the command's\ntrace id and a field diff; failed commands are listed with their error code. Pass\nnext_cursor back as cursor for the next page. Read from the read model when\nREAD_MODEL_DSN is set.",
                 "produces": [
-                    "application/json",
                     "application/json"
                 ],
                 "tags": [
-                    "messages",
-                    "messages",
-                    "messages"
+                    "audit"
                 ],
-                "summary": "Delete a message",
+                "summary": "List audit entries",
                 "parameters": [
                     {
                         "type": "string",
-                        "description": "Message ID",
-                        "name": "id",
-                        "in": "path",
-                        "required": true
+                        "description": "Resource, e.g. Message",
+                        "name": "resource",
+                        "in": "query"
                     },
                     {
                         "type": "string",
-                        "description": "Message ID",
+                        "description": "Resource id",
                         "name": "id",
-                        "in": "path",
-                        "required": true
+                        "in": "query"
                     },
                     {
-                        "description": "Updated message",
-                        "name": "message",
-                        "in": "body",
-                        "required": true,
+                        "type": "string",
+                        "description": "Authenticated subject",
+                        "name": "actor",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Create, Update or Delete",
+                        "name": "command",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "RFC 3339 time, inclusive",
+                        "name": "since",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "RFC 3339 time, exclusive",
+                        "name": "until",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Page size (default 20, max 100)",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "next_cursor of the previous page",
+                        "name": "cursor",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Tenant (defaults to \\",
+                        "name": "X-Tenant-ID",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/main.messageBody"
+                            "$ref": "#/definitions/audit.Page"
+                        }
+                    },
+                    "400": {
+                        "description": "INVALID_PARAM or BAD_REQUEST",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "401": {
+                        "description": "INVALID_REQUEST or INVALID_TOKEN (when AUTH_JWT_ROUTES covers the route)",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "403": {
+                        "description": "UNKNOWN_TENANT",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "504": {
+                        "description": "TIMEOUT",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
                         }
+                    }
+                }
+            }
+        },
+        "/messages": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Newest first, straight from the read model. Needs READ_MODEL_DSN; a replica may\nnot show the latest writes yet.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "messages"
+                ],
+                "summary": "List messages",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Page size (default 20, max 100)",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "next_cursor of the previous page",
+                        "name": "cursor",
+                        "in": "query"
                     },
                     {
                         "type": "string",
-                        "description": "Message ID",
-                        "name": "id",
-                        "in": "path",
-                        "required": true
+                        "description": "Tenant (defaults to \\",
+                        "name": "X-Tenant-ID",
+                        "in": "header"
                     }
                 ],
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/main.Ack"
+                            "$ref": "#/definitions/readmodel.Page"
                         }
                     },
-                    "204": {
-                        "description": "No Content"
+                    "400": {
+                        "description": "INVALID_PARAM",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "401": {
+                        "description": "INVALID_REQUEST or INVALID_TOKEN (when AUTH_JWT_ROUTES covers the route)",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "403": {
+                        "description": "UNKNOWN_TENANT",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "500": {
+                        "description": "DB_ERROR",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "501": {
+                        "description": "NOT_IMPLEMENTED: no read model configured",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
                     }
                 }
             },
-            "delete": {
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Receives a message payload and publishes to Kafka. Send multipart/form-data with a\n\"message\" field and an \"attachment\" file to attach a binary; the file goes to the\nblob store and only its reference is put on Kafka.",
                 "consumes": [
-                    "application/json"
+                    "application/json",
+                    "multipart/form-data"
                 ],
                 "produces": [
-                    "application/json",
                     "application/json"
                 ],
                 "tags": [
-                    "messages",
-                    "messages",
                     "messages"
                 ],
-                "summary": "Delete a message",
+                "summary": "Create a new message",
                 "parameters": [
                     {
                         "type": "string",
-                        "description": "Message ID",
-                        "name": "id",
-                        "in": "path",
-                        "required": true
-                    },
-                    {
-                        "type": "string",
-                        "description": "Message ID",
-                        "name": "id",
-                        "in": "path",
-                        "required": true
+                        "description": "Tenant (defaults to \\",
+                        "name": "X-Tenant-ID",
+                        "in": "header"
                     },
                     {
-                        "description": "Updated message",
+                        "description": "Message payload",
                         "name": "message",
                         "in": "body",
                         "required": true,
@@ -221,41 +401,91 @@ const docTemplate = `{
                     },
                     {
                         "type": "string",
-                        "description": "Message ID",
-                        "name": "id",
-                        "in": "path",
-                        "required": true
+                        "description": "Retry-safe key, 1-255 visible ASCII; a retry within IDEMPOTENCY_TTL returns the first trace_id or its ack",
+                        "name": "Idempotency-Key",
+                        "in": "header"
                     }
                 ],
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/main.Ack"
+                            "$ref": "#/definitions/main.acceptedResp"
+                        },
+                        "headers": {
+                            "Idempotent-Replayed": {
+                                "type": "string",
+                                "description": "true when answered from an earlier request with the same Idempotency-Key"
+                            }
                         }
                     },
-                    "204": {
-                        "description": "No Content"
+                    "400": {
+                        "description": "INVALID_BODY or INVALID_HEADER",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "401": {
+                        "description": "INVALID_REQUEST or INVALID_TOKEN (when AUTH_JWT_ROUTES covers the route)",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "403": {
+                        "description": "UNKNOWN_TENANT",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "413": {
+                        "description": "TOO_LARGE: attachment over MAX_ATTACHMENT_BYTES",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "422": {
+                        "description": "IDEMPOTENCY_KEY_REUSED: the key was used for a different request",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "503": {
+                        "description": "KAFKA_UNAVAILABLE or ENQUEUE_FAILED, with Retry-After",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
                     }
                 }
             }
         },
-        "/operations/{trace_id}": {
+        "/messages/{id}": {
             "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Served from the read cache when possible (X-Cache: HIT), then from the read model\n(READ_MODEL_DSN); both answer with the full Ack. Otherwise a Read command is\nenqueued and the body is the PENDING acceptedResp.",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "operations"
+                    "messages"
                 ],
-                "summary": "Get operation status",
+                "summary": "Get a message by ID",
                 "parameters": [
                     {
-                        "type": "string",
-                        "description": "Trace ID",
-                        "name": "trace_id",
+                        "type": "integer",
+                        "description": "Message ID",
+                        "name": "id",
                         "in": "path",
                         "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Tenant (defaults to \\",
+                        "name": "X-Tenant-ID",
+                        "in": "header"
                     }
                 ],
                 "responses": {
@@ -265,10 +495,536 @@ const docTemplate = `{
                             "$ref": "#/definitions/main.Ack"
                         }
                     },
-                    "204": {
-                        "description": "No Content",
+                    "400": {
+                        "description": "INVALID_PARAM: id is not a positive integer",
                         "schema": {
-                            "type": "string"
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "401": {
+                        "description": "INVALID_REQUEST or INVALID_TOKEN (when AUTH_JWT_ROUTES covers the route)",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "403": {
+                        "description": "UNKNOWN_TENANT",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "404": {
+                        "description": "NOT_FOUND (read model only)",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "503": {
+                        "description": "KAFKA_UNAVAILABLE or ENQUEUE_FAILED, with Retry-After",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    }
+                }
+            },
+            "put": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "consumes": [
+                    "application/json",
+                    "multipart/form-data"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "messages"
+                ],
+                "summary": "Update a message",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Message ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Tenant (defaults to \\",
+                        "name": "X-Tenant-ID",
+                        "in": "header"
+                    },
+                    {
+                        "description": "Updated message",
+                        "name": "message",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.messageBody"
+                        }
+                    },
+                    {
+                        "type": "string",
+                        "description": "Retry-safe key, 1-255 visible ASCII; a retry within IDEMPOTENCY_TTL returns the first trace_id or its ack",
+                        "name": "Idempotency-Key",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.Ack"
+                        }
+                    },
+                    "400": {
+                        "description": "INVALID_PARAM, INVALID_BODY or INVALID_HEADER",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "401": {
+                        "description": "INVALID_REQUEST or INVALID_TOKEN (when AUTH_JWT_ROUTES covers the route)",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "403": {
+                        "description": "UNKNOWN_TENANT",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "422": {
+                        "description": "IDEMPOTENCY_KEY_REUSED: the key was used for a different request",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "503": {
+                        "description": "KAFKA_UNAVAILABLE or ENQUEUE_FAILED, with Retry-After",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "tags": [
+                    "messages"
+                ],
+                "summary": "Delete a message",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Message ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Tenant (defaults to \\",
+                        "name": "X-Tenant-ID",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "No Content"
+                    },
+                    "400": {
+                        "description": "INVALID_PARAM: id is not a positive integer",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "401": {
+                        "description": "INVALID_REQUEST or INVALID_TOKEN (when AUTH_JWT_ROUTES covers the route)",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "403": {
+                        "description": "UNKNOWN_TENANT",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "503": {
+                        "description": "KAFKA_UNAVAILABLE or ENQUEUE_FAILED, with Retry-After",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    }
+                }
+            }
+        },
+        "/messages/{id}/attachment": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "produces": [
+                    "application/octet-stream"
+                ],
+                "tags": [
+                    "messages"
+                ],
+                "summary": "Download a message's attachment",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Message ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Tenant (defaults to \\",
+                        "name": "X-Tenant-ID",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "file"
+                        }
+                    },
+                    "400": {
+                        "description": "INVALID_PARAM: id is not a positive integer",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "401": {
+                        "description": "INVALID_REQUEST or INVALID_TOKEN (when AUTH_JWT_ROUTES covers the route)",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "404": {
+                        "description": "NOT_FOUND: no such message or no attachment",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "501": {
+                        "description": "NOT_IMPLEMENTED: attachments are disabled",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "502": {
+                        "description": "STORAGE_ERROR",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "504": {
+                        "description": "TIMEOUT",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    }
+                }
+            }
+        },
+        "/messages:withQuota": {
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Like POST /messages, but the consumer also charges the message to the tenant's\nquota (tenant_quotas; tenants without one have no limit). The message, its\nattachment and the charge are separate transactions: when one fails, the ones\nbefore it are undone, and the operation's Ack is a FAILURE with code QUOTA_EXCEEDED\n(403) or DB_ERROR (500) and the steps it compensated.",
+                "consumes": [
+                    "application/json",
+                    "multipart/form-data"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "messages"
+                ],
+                "summary": "Create a new message within the tenant's quota",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Tenant (defaults to \\",
+                        "name": "X-Tenant-ID",
+                        "in": "header"
+                    },
+                    {
+                        "description": "Message payload",
+                        "name": "message",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.messageBody"
+                        }
+                    },
+                    {
+                        "type": "string",
+                        "description": "Retry-safe key, 1-255 visible ASCII; a retry within IDEMPOTENCY_TTL returns the first trace_id or its ack",
+                        "name": "Idempotency-Key",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.acceptedResp"
+                        },
+                        "headers": {
+                            "Idempotent-Replayed": {
+                                "type": "string",
+                                "description": "true when answered from an earlier request with the same Idempotency-Key"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "INVALID_BODY or INVALID_HEADER",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "401": {
+                        "description": "INVALID_REQUEST or INVALID_TOKEN (when AUTH_JWT_ROUTES covers the route)",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "403": {
+                        "description": "UNKNOWN_TENANT",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "413": {
+                        "description": "TOO_LARGE: attachment over MAX_ATTACHMENT_BYTES",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "422": {
+                        "description": "IDEMPOTENCY_KEY_REUSED: the key was used for a different request",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "503": {
+                        "description": "KAFKA_UNAVAILABLE or ENQUEUE_FAILED, with Retry-After",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    }
+                }
+            }
+        },
+        "/operations/stream": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Upgrades to a WebSocket and sends each requested operation's Ack as a JSON text\nframe as soon as it is available. Acks that arrived before the connection are sent\nfirst, so reconnecting with the same trace ids never misses a result. PROGRESS acks of\nbulk commands are sent too, but only a final ack completes an operation. The server\ncloses normally (1000) once every final ack was sent, or after STREAM_TIMEOUT (1001).",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "operations"
+                ],
+                "summary": "Stream operation results",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Trace id; repeat or comma-separate for several (max 100)",
+                        "name": "trace_id",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Tenant (defaults to \\",
+                        "name": "X-Tenant-ID",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "101": {
+                        "description": "Switching Protocols; one Ack per frame",
+                        "schema": {
+                            "$ref": "#/definitions/main.Ack"
+                        }
+                    },
+                    "400": {
+                        "description": "INVALID_PARAM: missing trace_id",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "401": {
+                        "description": "INVALID_REQUEST or INVALID_TOKEN (when AUTH_JWT_ROUTES covers the route)",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "403": {
+                        "description": "UNKNOWN_TENANT",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    }
+                }
+            }
+        },
+        "/operations/{trace_id}": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "The Ack of a successful operation. A failed one is answered with a problem+json body\nwhose status and code come from the Ack's error; its trace_id is the operation's.\nWhile a DeleteByQuery or UpdateByQuery runs, this is its latest PROGRESS Ack, whose\npayload has processed, total and percent; poll until the status is final.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "operations"
+                ],
+                "summary": "Get operation status",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Trace ID",
+                        "name": "trace_id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Tenant (defaults to \\",
+                        "name": "X-Tenant-ID",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.Ack"
+                        }
+                    },
+                    "204": {
+                        "description": "No Content",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "400": {
+                        "description": "BAD_REQUEST or UNSUPPORTED",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "401": {
+                        "description": "INVALID_REQUEST or INVALID_TOKEN (when AUTH_JWT_ROUTES covers the route)",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "403": {
+                        "description": "UNKNOWN_TENANT",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "404": {
+                        "description": "NOT_FOUND",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "500": {
+                        "description": "DB_ERROR or INTERNAL",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "502": {
+                        "description": "unknown consumer error code",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    }
+                }
+            }
+        },
+        "/operations/{trace_id}/events": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Holds the connection open and sends the Ack as an \"ack\" event as soon as the ack\nconsumer receives it (immediately if it already arrived), then ends the response.\nThe PROGRESS acks of a bulk command go out as \"progress\" events before it.\nWhile waiting, a \": ping\" comment goes out every SSE_KEEPALIVE. After SSE_TIMEOUT\nwithout an ack a \"timeout\" event is sent instead. EventSource reconnects when a response ends,\nso clients should close it on either event.",
+                "produces": [
+                    "text/event-stream"
+                ],
+                "tags": [
+                    "operations"
+                ],
+                "summary": "Push operation status over Server-Sent Events",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Trace ID",
+                        "name": "trace_id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Tenant (defaults to \\",
+                        "name": "X-Tenant-ID",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "event: ack (or progress), data: the Ack",
+                        "schema": {
+                            "$ref": "#/definitions/main.Ack"
+                        }
+                    },
+                    "401": {
+                        "description": "INVALID_REQUEST or INVALID_TOKEN (when AUTH_JWT_ROUTES covers the route)",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "403": {
+                        "description": "UNKNOWN_TENANT",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
                         }
                     }
                 }
@@ -276,6 +1032,132 @@ const docTemplate = `{
         }
     },
     "definitions": {
+        "audit.Change": {
+            "type": "object",
+            "properties": {
+                "from": {},
+                "to": {}
+            }
+        },
+        "audit.Entry": {
+            "type": "object",
+            "properties": {
+                "actor": {
+                    "type": "string"
+                },
+                "changes": {
+                    "type": "object",
+                    "additionalProperties": {
+                        "$ref": "#/definitions/audit.Change"
+                    }
+                },
+                "command": {
+                    "type": "string"
+                },
+                "created_at": {
+                    "type": "string"
+                },
+                "error_code": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "resource": {
+                    "type": "string"
+                },
+                "resource_id": {
+                    "type": "string"
+                },
+                "status": {
+                    "type": "string"
+                },
+                "tenant_id": {
+                    "type": "string"
+                },
+                "trace_id": {
+                    "type": "string"
+                }
+            }
+        },
+        "audit.Page": {
+            "type": "object",
+            "properties": {
+                "entries": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/audit.Entry"
+                    }
+                },
+                "next_cursor": {
+                    "type": "integer"
+                }
+            }
+        },
+        "blob.Ref": {
+            "type": "object",
+            "properties": {
+                "content_type": {
+                    "type": "string"
+                },
+                "filename": {
+                    "type": "string"
+                },
+                "key": {
+                    "type": "string"
+                },
+                "sha256": {
+                    "type": "string"
+                },
+                "size": {
+                    "type": "integer"
+                }
+            }
+        },
+        "bulk.DeleteRequest": {
+            "type": "object",
+            "properties": {
+                "query": {
+                    "$ref": "#/definitions/bulk.Query"
+                }
+            }
+        },
+        "bulk.Query": {
+            "type": "object",
+            "properties": {
+                "contains": {
+                    "description": "substring of the message; the collation decides about case",
+                    "type": "string"
+                },
+                "max_id": {
+                    "description": "inclusive",
+                    "type": "integer"
+                },
+                "min_id": {
+                    "description": "inclusive",
+                    "type": "integer"
+                },
+                "since": {
+                    "description": "created_at, inclusive",
+                    "type": "string"
+                },
+                "until": {
+                    "description": "created_at, exclusive",
+                    "type": "string"
+                }
+            }
+        },
+        "bulk.UpdateRequest": {
+            "type": "object",
+            "properties": {
+                "message": {
+                    "type": "string"
+                },
+                "query": {
+                    "$ref": "#/definitions/bulk.Query"
+                }
+            }
+        },
         "main.Ack": {
             "type": "object",
             "properties": {
@@ -297,9 +1179,16 @@ const docTemplate = `{
                     "type": "object",
                     "additionalProperties": {}
                 },
+                "replayed": {
+                    "description": "result of an earlier identical command",
+                    "type": "boolean"
+                },
                 "status": {
                     "type": "string"
                 },
+                "tenant_id": {
+                    "type": "string"
+                },
                 "trace_id": {
                     "type": "string"
                 }
@@ -323,6 +1212,82 @@ const docTemplate = `{
                     "type": "string"
                 }
             }
+        },
+        "problem.Details": {
+            "type": "object",
+            "properties": {
+                "code": {
+                    "type": "string",
+                    "example": "NOT_FOUND"
+                },
+                "detail": {
+                    "type": "string",
+                    "example": "id=42"
+                },
+                "instance": {
+                    "type": "string",
+                    "example": "/v1/operations/6f1c2a0e-0d7b-4c55-9c64-8a3c1f0e9b11"
+                },
+                "status": {
+                    "type": "integer",
+                    "example": 404
+                },
+                "title": {
+                    "type": "string",
+                    "example": "Not Found"
+                },
+                "trace_id": {
+                    "description": "TraceID is the operation's trace id when the error came back in its\nack, otherwise the request's; quote it when reporting a problem.",
+                    "type": "string",
+                    "example": "6f1c2a0e-0d7b-4c55-9c64-8a3c1f0e9b11"
+                },
+                "type": {
+                    "type": "string",
+                    "example": "https://example.com/problems/not-found"
+                }
+            }
+        },
+        "readmodel.Message": {
+            "type": "object",
+            "properties": {
+                "attachment": {
+                    "$ref": "#/definitions/blob.Ref"
+                },
+                "created_at": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "message": {
+                    "type": "string"
+                },
+                "updated_at": {
+                    "type": "string"
+                }
+            }
+        },
+        "readmodel.Page": {
+            "type": "object",
+            "properties": {
+                "messages": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/readmodel.Message"
+                    }
+                },
+                "next_cursor": {
+                    "type": "integer"
+                }
+            }
+        }
+    },
+    "securityDefinitions": {
+        "BearerAuth": {
+            "description": "\"Bearer \u003cJWT\u003e\"; required on the routes listed in AUTH_JWT_ROUTES",
+            "type": "apiKey",
+            "name": "Authorization",
+            "in": "header"
         }
     }
 }`
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/slb-uk/rest-go-webservice/project/pkg/config"
+	"github.com/slb-uk/rest-go-webservice/project/pkg/problem"
+)
+
+// IdempotencyHeader lets a client retry a command without running it
+// twice. The key becomes the Kafka message key, which the consumers
+// already deduplicate on per tenant, and apisvc remembers which trace id
+// it was first sent under: a retry within IDEMPOTENCY_TTL is not published
+// again but answered with the original trace id, or with the ack itself
+// once it has arrived. Reusing a key for a different command or payload is
+// rejected with 422.
+const IdempotencyHeader = "Idempotency-Key"
+
+// maxIdempotencyKey bounds the header; UUIDs and ULIDs fit many times over.
+const maxIdempotencyKey = 255
+
+// idemRecord is what apisvc remembers about the first request with a key.
+type idemRecord struct {
+	TraceID     string    `json:"trace_id"`
+	Command     string    `json:"command"`
+	Fingerprint string    `json:"fingerprint"` // of command and payload
+	Created     time.Time `json:"created"`
+}
+
+// idempotencyStore maps tenant and key to the first request's record.
+type idempotencyStore interface {
+	// Reserve stores rec unless the key is taken, in which case it returns
+	// the record already there and true.
+	Reserve(ctx context.Context, tenantID, key string, rec idemRecord) (idemRecord, bool, error)
+	// Release frees a key whose command could not be published, so the
+	// client's retry is sent.
+	Release(ctx context.Context, tenantID, key string)
+}
+
+// idemKeys is nil when IDEMPOTENCY_STORE=off; the key then only reaches
+// the consumers.
+var idemKeys idempotencyStore
+
+func openIdempotencyStore(conf *config.API) (idempotencyStore, error) {
+	ttl := conf.Idempotency.TTL
+	switch conf.Idempotency.Store {
+	case "memory":
+		return newMemIdempotencyStore(ttl), nil
+	case "redis":
+		c, err := openRedis(conf.RedisAddr)
+		if err != nil {
+			return nil, err
+		}
+		return &redisIdempotencyStore{c: c, ttl: ttl}, nil
+	case "off":
+		return nil, nil
+	default:
+		return nil, errors.New("unknown IDEMPOTENCY_STORE")
+	}
+}
+
+// idempotencyKey returns the request's key, "" without one, and false when
+// the header is malformed. Keys are 1-255 visible ASCII characters.
+func idempotencyKey(r *http.Request) (string, bool) {
+	vals := r.Header.Values(IdempotencyHeader)
+	if len(vals) == 0 {
+		return "", true
+	}
+	k := vals[0]
+	if len(vals) > 1 || k == "" || len(k) > maxIdempotencyKey {
+		return "", false
+	}
+	for i := 0; i < len(k); i++ {
+		if k[i] < 0x21 || k[i] > 0x7e {
+			return "", false
+		}
+	}
+	return k, true
+}
+
+// fingerprint identifies a command and its payload. The blob key of an
+// attachment is new on every upload, so the attachment counts by content.
+func fingerprint(cmd string, payload map[string]any) string {
+	p := make(map[string]any, len(payload))
+	for k, v := range payload {
+		p[k] = v
+	}
+	if att, ok := p["attachment"].(map[string]any); ok {
+		a := make(map[string]any, len(att))
+		for k, v := range att {
+			if k != "key" {
+				a[k] = v
+			}
+		}
+		p["attachment"] = a
+	}
+	b, _ := json.Marshal(map[string]any{"command": cmd, "payload": p}) // map keys are sorted
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// replayIdempotent answers a retry of the request that first used prev's
+// key: 422 for a different command or payload, else the original ack if it
+// is still in the ack store, else the original trace id as PENDING.
+func replayIdempotent(w http.ResponseWriter, r *http.Request, tenantID, fp string, prev idemRecord) {
+	if prev.Fingerprint != fp {
+		idempotentReplaysTotal.WithLabelValues(prev.Command, "mismatch").Inc()
+		p := problem.New(http.StatusUnprocessableEntity, problem.CodeIdempotencyKeyReused,
+			IdempotencyHeader+" was already used for a different request")
+		p.TraceID = prev.TraceID
+		p.Write(w, r)
+		return
+	}
+	w.Header().Set("Idempotent-Replayed", "true")
+	w.Header().Set("Content-Type", "application/json")
+	if a, ok := getAck(prev.TraceID); ok && ackTenant(a) == tenantID {
+		idempotentReplaysTotal.WithLabelValues(prev.Command, "result").Inc()
+		_ = json.NewEncoder(w).Encode(a)
+		return
+	}
+	idempotentReplaysTotal.WithLabelValues(prev.Command, "pending").Inc()
+	_ = json.NewEncoder(w).Encode(acceptedResp{TraceID: prev.TraceID, Status: "PENDING"})
+}
+
+type memIdemEntry struct {
+	rec     idemRecord
+	expires time.Time
+}
+
+type memIdempotencyStore struct {
+	mu  sync.Mutex
+	m   map[string]memIdemEntry
+	ttl time.Duration
+}
+
+func newMemIdempotencyStore(ttl time.Duration) *memIdempotencyStore {
+	s := &memIdempotencyStore{m: make(map[string]memIdemEntry), ttl: ttl}
+	go func() {
+		for range time.Tick(time.Minute) {
+			s.mu.Lock()
+			for k, e := range s.m {
+				if time.Now().After(e.expires) {
+					delete(s.m, k)
+				}
+			}
+			s.mu.Unlock()
+		}
+	}()
+	return s
+}
+
+func (s *memIdempotencyStore) Reserve(_ context.Context, tenantID, key string, rec idemRecord) (idemRecord, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k := tenantID + "/" + key
+	if e, ok := s.m[k]; ok && time.Now().Before(e.expires) {
+		return e.rec, true, nil
+	}
+	s.m[k] = memIdemEntry{rec: rec, expires: time.Now().Add(s.ttl)}
+	return rec, false, nil
+}
+
+func (s *memIdempotencyStore) Release(_ context.Context, tenantID, key string) {
+	s.mu.Lock()
+	delete(s.m, tenantID+"/"+key)
+	s.mu.Unlock()
+}
+
+// redisIdempotencyStore shares keys between apisvc replicas, so a retry
+// that lands on another replica is still recognised.
+type redisIdempotencyStore struct {
+	c   *redis.Client
+	ttl time.Duration
+}
+
+func (s *redisIdempotencyStore) key(tenantID, key string) string {
+	return "apisvc:idem:" + tenantID + ":" + key
+}
+
+func (s *redisIdempotencyStore) Reserve(ctx context.Context, tenantID, key string, rec idemRecord) (idemRecord, bool, error) {
+	b, _ := json.Marshal(rec)
+	k := s.key(tenantID, key)
+	for range 2 { // the holder may expire between SET NX and GET
+		ok, err := s.c.SetNX(ctx, k, b, s.ttl).Result()
+		if err != nil {
+			return idemRecord{}, false, err
+		}
+		if ok {
+			return rec, false, nil
+		}
+		stored, err := s.c.Get(ctx, k).Bytes()
+		if errors.Is(err, redis.Nil) {
+			continue
+		}
+		if err != nil {
+			return idemRecord{}, false, err
+		}
+		var prev idemRecord
+		if err := json.Unmarshal(stored, &prev); err != nil {
+			return idemRecord{}, false, err
+		}
+		return prev, true, nil
+	}
+	return idemRecord{}, false, errors.New("idempotency key vanished twice")
+}
+
+func (s *redisIdempotencyStore) Release(ctx context.Context, tenantID, key string) {
+	if err := s.c.Del(ctx, s.key(tenantID, key)).Err(); err != nil {
+		slog.WarnContext(ctx, "idempotency release", "tenant_id", tenantID, "key", key, "err", err)
+	}
+}
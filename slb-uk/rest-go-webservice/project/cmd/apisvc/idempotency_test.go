@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	saramamocks "github.com/IBM/sarama/mocks"
+
+	"github.com/slb-uk/rest-go-webservice/project/pkg/problem"
+)
+
+func TestIdempotencyKey(t *testing.T) {
+	cases := []struct {
+		name   string
+		values []string
+		key    string
+		ok     bool
+	}{
+		{"none", nil, "", true},
+		{"uuid", []string{"6f1c2a0e-0d7b-4c55-9c64-8a3c1f0e9b11"}, "6f1c2a0e-0d7b-4c55-9c64-8a3c1f0e9b11", true},
+		{"longest", []string{strings.Repeat("k", 255)}, strings.Repeat("k", 255), true},
+		{"too long", []string{strings.Repeat("k", 256)}, "", false},
+		{"empty", []string{""}, "", false},
+		{"space", []string{"order 1"}, "", false},
+		{"not ascii", []string{"clé"}, "", false},
+		{"twice", []string{"k-1", "k-1"}, "", false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/v1/messages", nil)
+			for _, v := range tc.values {
+				r.Header.Add(IdempotencyHeader, v)
+			}
+			if key, ok := idempotencyKey(r); key != tc.key || ok != tc.ok {
+				t.Fatalf("idempotencyKey = %q, %v", key, ok)
+			}
+		})
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	withAttachment := func(key, sha string) map[string]any {
+		return map[string]any{"message": "hi", "attachment": map[string]any{"key": key, "filename": "a.txt", "sha256": sha}}
+	}
+	base := fingerprint("Create", map[string]any{"message": "hi", "id": "7"})
+	cases := []struct {
+		name    string
+		cmd     string
+		payload map[string]any
+		same    bool
+	}{
+		{"same", "Create", map[string]any{"id": "7", "message": "hi"}, true},
+		{"other command", "Update", map[string]any{"message": "hi", "id": "7"}, false},
+		{"other message", "Create", map[string]any{"message": "ho", "id": "7"}, false},
+		{"extra field", "Create", map[string]any{"message": "hi", "id": "7", "x": true}, false},
+	}
+	for _, tc := range cases {
+		if got := fingerprint(tc.cmd, tc.payload) == base; got != tc.same {
+			t.Errorf("%s: same fingerprint = %v", tc.name, got)
+		}
+	}
+
+	// a retried upload stores the bytes under a new blob key
+	first := withAttachment("default/a1", "ab")
+	if fingerprint("Create", first) != fingerprint("Create", withAttachment("default/a2", "ab")) {
+		t.Error("the blob key changed the fingerprint")
+	}
+	if fingerprint("Create", first) == fingerprint("Create", withAttachment("default/a1", "cd")) {
+		t.Error("other content, same fingerprint")
+	}
+	if first["attachment"].(map[string]any)["key"] != "default/a1" {
+		t.Error("fingerprint changed the payload")
+	}
+}
+
+func TestMemIdempotencyStore(t *testing.T) {
+	ctx := context.Background()
+	s := newMemIdempotencyStore(time.Hour)
+	first := idemRecord{TraceID: "t-1", Command: "Create", Fingerprint: "f"}
+	if _, seen, _ := s.Reserve(ctx, "default", "k-1", first); seen {
+		t.Fatal("a new key was seen")
+	}
+	if prev, seen, _ := s.Reserve(ctx, "default", "k-1", idemRecord{TraceID: "t-2"}); !seen || prev.TraceID != "t-1" {
+		t.Fatalf("retry: %+v, %v", prev, seen)
+	}
+	if _, seen, _ := s.Reserve(ctx, "acme", "k-1", idemRecord{TraceID: "t-3"}); seen {
+		t.Fatal("keys are shared between tenants")
+	}
+	s.Release(ctx, "default", "k-1")
+	if _, seen, _ := s.Reserve(ctx, "default", "k-1", idemRecord{TraceID: "t-4"}); seen {
+		t.Fatal("a released key was seen")
+	}
+
+	s = newMemIdempotencyStore(time.Millisecond)
+	s.Reserve(ctx, "default", "k-1", first)
+	time.Sleep(5 * time.Millisecond)
+	if _, seen, _ := s.Reserve(ctx, "default", "k-1", idemRecord{TraceID: "t-2"}); seen {
+		t.Fatal("an expired key was seen")
+	}
+}
+
+// testAcks is an ackStore on a map.
+type testAcks map[string]Ack
+
+func (s testAcks) Put(_ context.Context, a Ack) { s[a.TraceID] = a }
+
+func (s testAcks) Get(_ context.Context, id string) (Ack, bool) {
+	a, ok := s[id]
+	return a, ok
+}
+
+func withAcks(t *testing.T, acks ...Ack) {
+	prev := results
+	s := testAcks{}
+	for _, a := range acks {
+		s[a.TraceID] = a
+	}
+	results = s
+	t.Cleanup(func() { results = prev })
+}
+
+func TestReplayIdempotent(t *testing.T) {
+	withAcks(t,
+		Ack{TraceID: "t-done", Status: "SUCCESS", Event: "MessageCreated", Payload: map[string]any{"id": float64(42)}},
+		Ack{TraceID: "t-acme", Status: "SUCCESS", TenantID: "acme"})
+	cases := []struct {
+		name   string
+		prev   idemRecord
+		fp     string
+		status int
+		body   string // in the JSON answer
+		replay bool   // Idempotent-Replayed is set
+	}{
+		{"other request", idemRecord{TraceID: "t-done", Command: "Create", Fingerprint: "f1"}, "f2",
+			http.StatusUnprocessableEntity, `"code":"IDEMPOTENCY_KEY_REUSED"`, false},
+		{"answered", idemRecord{TraceID: "t-done", Command: "Create", Fingerprint: "f1"}, "f1",
+			http.StatusOK, `"event":"MessageCreated"`, true},
+		{"not answered yet", idemRecord{TraceID: "t-wait", Command: "Create", Fingerprint: "f1"}, "f1",
+			http.StatusOK, `{"trace_id":"t-wait","status":"PENDING"}`, true},
+		// another tenant's ack is never handed out
+		{"other tenant's ack", idemRecord{TraceID: "t-acme", Command: "Create", Fingerprint: "f1"}, "f1",
+			http.StatusOK, `{"trace_id":"t-acme","status":"PENDING"}`, true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			replayIdempotent(w, httptest.NewRequest(http.MethodPost, "/v1/messages", nil), "default", tc.fp, tc.prev)
+			if w.Code != tc.status || !strings.Contains(w.Body.String(), tc.body) {
+				t.Fatalf("%d %s", w.Code, w.Body)
+			}
+			if got := w.Header().Get("Idempotent-Replayed") == "true"; got != tc.replay {
+				t.Errorf("Idempotent-Replayed: %q", w.Header().Get("Idempotent-Replayed"))
+			}
+			if tc.status == http.StatusUnprocessableEntity && !strings.Contains(w.Body.String(), `"trace_id":"t-done"`) {
+				t.Errorf("the problem does not name the first request: %s", w.Body)
+			}
+		})
+	}
+}
+
+// TestEnqueueIdempotent runs retries through enqueueCommand: a retry is
+// answered without producing, and a key whose produce failed is free again.
+func TestEnqueueIdempotent(t *testing.T) {
+	prev := idemKeys
+	idemKeys = newMemIdempotencyStore(time.Hour)
+	t.Cleanup(func() { idemKeys = prev })
+	withAcks(t)
+	producer := saramamocks.NewSyncProducer(t, nil)
+	t.Cleanup(func() { _ = producer.Close() })
+
+	enqueue := func(key, message string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/v1/messages", nil)
+		if key != "" {
+			r.Header.Set(IdempotencyHeader, key)
+		}
+		w := httptest.NewRecorder()
+		enqueueCommand(w, r, producer, "messages.commands", "default", "alice", "stable", "Create", map[string]any{"message": message})
+		return w
+	}
+	traceID := func(w *httptest.ResponseRecorder) string {
+		var a acceptedResp
+		if err := json.Unmarshal(w.Body.Bytes(), &a); err != nil || a.Status != "PENDING" {
+			t.Fatalf("%d %s", w.Code, w.Body)
+		}
+		return a.TraceID
+	}
+
+	producer.ExpectSendMessageWithMessageCheckerFunctionAndSucceed(func(m *sarama.ProducerMessage) error {
+		if k, _ := m.Key.Encode(); string(k) != "k-1" {
+			return errors.New("not keyed by the idempotency key")
+		}
+		return nil
+	})
+	first := traceID(enqueue("k-1", "hi"))
+	w := enqueue("k-1", "hi")
+	if traceID(w) != first || w.Header().Get("Idempotent-Replayed") != "true" {
+		t.Fatalf("retry answered %s, first was %s", w.Body, first)
+	}
+	if w := enqueue("k-1", "ho"); w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("reused key: %d %s", w.Code, w.Body)
+	}
+
+	producer.ExpectSendMessageAndFail(sarama.ErrOutOfBrokers)
+	if w := enqueue("k-2", "hi"); w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("failed produce: %d %s", w.Code, w.Body)
+	}
+	producer.ExpectSendMessageAndSucceed()
+	if w := enqueue("k-2", "hi"); w.Header().Get("Idempotent-Replayed") != "" {
+		t.Fatal("the retry of a failed produce was not sent")
+	}
+
+	if w := enqueue("order 1", "hi"); w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), problem.CodeInvalidHeader) {
+		t.Fatalf("bad key: %d %s", w.Code, w.Body)
+	}
+}
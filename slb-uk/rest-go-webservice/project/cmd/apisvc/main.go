@@ -14,21 +14,45 @@
 // @host localhost:8080
 // @BasePath /v1
 
+// @securityDefinitions.apikey BearerAuth
+// @in header
+// @name Authorization
+// @description "Bearer <JWT>"; required on the routes listed in AUTH_JWT_ROUTES
+
 //go:generate swag init --parseDependency --parseInternal --dir . --output docs
 package main
 
 import (
 	"context"
 	"encoding/json"
-	"log"
+	"errors"
+	"flag"
+	"log/slog"
 	"net/http"
 	"os"
 	"strings"
-	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/IBM/sarama"
+	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	oteltrace "go.opentelemetry.io/otel/trace"
+
+	"github.com/slb-uk/rest-go-webservice/project/pkg/audit"
+	"github.com/slb-uk/rest-go-webservice/project/pkg/blob"
+	"github.com/slb-uk/rest-go-webservice/project/pkg/breaker"
+	"github.com/slb-uk/rest-go-webservice/project/pkg/config"
+	"github.com/slb-uk/rest-go-webservice/project/pkg/contracts"
+	"github.com/slb-uk/rest-go-webservice/project/pkg/deployment"
+	kafkahelper "github.com/slb-uk/rest-go-webservice/project/pkg/kafka"
+	"github.com/slb-uk/rest-go-webservice/project/pkg/observability"
+	"github.com/slb-uk/rest-go-webservice/project/pkg/problem"
+	"github.com/slb-uk/rest-go-webservice/project/pkg/readmodel"
+	"github.com/slb-uk/rest-go-webservice/project/pkg/startup"
+	"github.com/slb-uk/rest-go-webservice/project/pkg/tenant"
 )
 
 type messageBody struct {
@@ -41,192 +65,435 @@ type acceptedResp struct {
 }
 
 type Ack struct {
-	TraceID string                 `json:"trace_id"`
-	Status  string                 `json:"status"`
-	Event   string                 `json:"event"`
-	Payload map[string]any         `json:"payload,omitempty"`
-	Error   *struct{ Code, Detail string } `json:"error,omitempty"`
+	TraceID  string                 `json:"trace_id"`
+	Status   string                 `json:"status"`
+	Event    string                 `json:"event"`
+	Payload  map[string]any         `json:"payload,omitempty"`
+	Error    *struct{ Code, Detail string } `json:"error,omitempty"`
+	Replayed bool                   `json:"replayed,omitempty"` // result of an earlier identical command
+	TenantID string                 `json:"tenant_id,omitempty"`
 }
 
+// Validate checks a against the ack contract.
+func (a Ack) Validate() error { return contracts.ValidateAck(a) }
+
 var (
-	resultCache   = make(map[string]Ack)
-	resultExpires = make(map[string]time.Time)
-	cacheMu       sync.RWMutex
-	cacheTTL      = 2 * time.Minute
+	// tenantTopics routes commands to "<tenant>.<topic>" (TENANT_TOPIC_PREFIX=true)
+	tenantTopics bool
+	// allowedTenants is the TENANTS list; requests for other tenants are rejected
+	allowedTenants = map[string]bool{}
+	// codec encodes commands (KAFKA_CODEC) and is the ack encoding we ask for
+	codec contracts.Codec = contracts.JSONCodec{}
+	// canaryRouting (CANARY_ROUTING) decides whether canary commands get
+	// their own topic or only a header, see pkg/deployment
+	canaryRouting = deployment.RoutingHeader
 )
 
-func putAck(a Ack) {
-	cacheMu.Lock()
-	resultCache[a.TraceID] = a
-	resultExpires[a.TraceID] = time.Now().Add(cacheTTL)
-	cacheMu.Unlock()
-}
-
-func getAck(id string) (Ack, bool) {
-	cacheMu.RLock()
-	a, ok := resultCache[id]
-	exp := resultExpires[id]
-	cacheMu.RUnlock()
-	if !ok || time.Now().After(exp) {
-		return Ack{}, false
+// resolveTenant reads the tenant from the request and writes the error
+// response itself when it is missing or unknown.
+func resolveTenant(w http.ResponseWriter, r *http.Request) (string, bool) {
+	id, err := tenant.FromRequest(r)
+	if err != nil {
+		problem.Write(w, r, http.StatusBadRequest, problem.CodeInvalidHeader, "invalid "+tenant.Header)
+		return "", false
 	}
-	return a, true
-}
-
-func sweeper() {
-	for range time.Tick(30 * time.Second) {
-		cacheMu.Lock()
-		for k, t := range resultExpires {
-			if time.Now().After(t) {
-				delete(resultExpires, k)
-				delete(resultCache, k)
-			}
-		}
-		cacheMu.Unlock()
+	if !allowedTenants[id] {
+		problem.Write(w, r, http.StatusForbidden, problem.CodeUnknownTenant, "unknown tenant "+id)
+		return "", false
 	}
+	return id, true
 }
 
 // @Summary Create a new message
-// @Description Receives a message payload and publishes to Kafka
+// @Description Receives a message payload and publishes to Kafka. Send multipart/form-data with a
+// @Description "message" field and an "attachment" file to attach a binary; the file goes to the
+// @Description blob store and only its reference is put on Kafka.
 // @Tags messages
 // @Accept json
+// @Accept mpfd
 // @Produce json
+// @Param X-Tenant-ID header string false "Tenant (defaults to \"default\")"
 // @Param message body messageBody true "Message payload"
+// @Param Idempotency-Key header string false "Retry-safe key, 1-255 visible ASCII; a retry within IDEMPOTENCY_TTL returns the first trace_id or its ack"
 // @Success 200 {object} acceptedResp
-// @Failure 400 {string} string "invalid body"
+// @Header 200 {string} Idempotent-Replayed "true when answered from an earlier request with the same Idempotency-Key"
+// @Failure 400 {object} problem.Details "INVALID_BODY or INVALID_HEADER"
+// @Failure 422 {object} problem.Details "IDEMPOTENCY_KEY_REUSED: the key was used for a different request"
+// @Failure 403 {object} problem.Details "UNKNOWN_TENANT"
+// @Failure 413 {object} problem.Details "TOO_LARGE: attachment over MAX_ATTACHMENT_BYTES"
+// @Failure 503 {object} problem.Details "KAFKA_UNAVAILABLE or ENQUEUE_FAILED, with Retry-After"
+// @Failure 401 {object} problem.Details "INVALID_REQUEST or INVALID_TOKEN (when AUTH_JWT_ROUTES covers the route)"
+// @Security BearerAuth
 // @Router /messages [post]
 func createMessageHandler(producer sarama.SyncProducer, cmdTopic string) http.HandlerFunc {
+	return createHandler(producer, cmdTopic, "Create")
+}
+
+// @Summary Create a new message within the tenant's quota
+// @Description Like POST /messages, but the consumer also charges the message to the tenant's
+// @Description quota (tenant_quotas; tenants without one have no limit). The message, its
+// @Description attachment and the charge are separate transactions: when one fails, the ones
+// @Description before it are undone, and the operation's Ack is a FAILURE with code QUOTA_EXCEEDED
+// @Description (403) or DB_ERROR (500) and the steps it compensated.
+// @Tags messages
+// @Accept json
+// @Accept mpfd
+// @Produce json
+// @Param X-Tenant-ID header string false "Tenant (defaults to \"default\")"
+// @Param message body messageBody true "Message payload"
+// @Param Idempotency-Key header string false "Retry-safe key, 1-255 visible ASCII; a retry within IDEMPOTENCY_TTL returns the first trace_id or its ack"
+// @Success 200 {object} acceptedResp
+// @Header 200 {string} Idempotent-Replayed "true when answered from an earlier request with the same Idempotency-Key"
+// @Failure 400 {object} problem.Details "INVALID_BODY or INVALID_HEADER"
+// @Failure 422 {object} problem.Details "IDEMPOTENCY_KEY_REUSED: the key was used for a different request"
+// @Failure 403 {object} problem.Details "UNKNOWN_TENANT"
+// @Failure 413 {object} problem.Details "TOO_LARGE: attachment over MAX_ATTACHMENT_BYTES"
+// @Failure 503 {object} problem.Details "KAFKA_UNAVAILABLE or ENQUEUE_FAILED, with Retry-After"
+// @Failure 401 {object} problem.Details "INVALID_REQUEST or INVALID_TOKEN (when AUTH_JWT_ROUTES covers the route)"
+// @Security BearerAuth
+// @Router /messages:withQuota [post]
+func createWithQuotaHandler(producer sarama.SyncProducer, cmdTopic string) http.HandlerFunc {
+	return createHandler(producer, cmdTopic, "CreateWithQuota")
+}
+
+// createHandler enqueues command with the message of the request body and
+// the reference of its attachment, if any.
+func createHandler(producer sarama.SyncProducer, cmdTopic, command string) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != http.MethodPost {
-			w.WriteHeader(http.StatusMethodNotAllowed)
+		tid, ok := resolveTenant(w, r)
+		if !ok {
 			return
 		}
-		var b messageBody
-		if json.NewDecoder(r.Body).Decode(&b) != nil || strings.TrimSpace(b.Message) == "" {
-			http.Error(w, "invalid body", 400)
+		b, ref, err := readMessageBody(r, tid)
+		if err != nil {
+			writeBodyError(w, r, err)
 			return
 		}
-		enqueueCommand(w, producer, cmdTopic, "Create", map[string]any{"message": b.Message})
+		payload := map[string]any{"message": b.Message}
+		if ref != nil {
+			payload["attachment"] = ref.Map()
+		}
+		enqueueCommand(w, r, producer, cmdTopic, tid, audit.FromRequest(r), deployment.FromContext(r.Context()), command, payload)
 	}
 }
 
 // @Summary Get a message by ID
+// @Description Served from the read cache when possible (X-Cache: HIT), then from the read model
+// @Description (READ_MODEL_DSN); both answer with the full Ack. Otherwise a Read command is
+// @Description enqueued and the body is the PENDING acceptedResp.
 // @Tags messages
 // @Produce json
-// @Param id path string true "Message ID"
+// @Param id path int true "Message ID"
+// @Param X-Tenant-ID header string false "Tenant (defaults to \"default\")"
 // @Success 200 {object} Ack
+// @Failure 400 {object} problem.Details "INVALID_PARAM: id is not a positive integer"
+// @Failure 403 {object} problem.Details "UNKNOWN_TENANT"
+// @Failure 404 {object} problem.Details "NOT_FOUND (read model only)"
+// @Failure 503 {object} problem.Details "KAFKA_UNAVAILABLE or ENQUEUE_FAILED, with Retry-After"
+// @Failure 401 {object} problem.Details "INVALID_REQUEST or INVALID_TOKEN (when AUTH_JWT_ROUTES covers the route)"
+// @Security BearerAuth
 // @Router /messages/{id} [get]
+func getMessageHandler(producer sarama.SyncProducer, cmdTopic string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		idStr := chi.URLParam(r, "id")
+		tid, ok := resolveTenant(w, r)
+		if !ok {
+			return
+		}
+		if serveCachedRead(w, r, tid, idStr) || serveModelRead(w, r, tid, idStr) {
+			return
+		}
+		enqueueCommand(w, r, producer, cmdTopic, tid, audit.FromRequest(r), deployment.FromContext(r.Context()), "Read", map[string]any{"id": idStr})
+	}
+}
+
 // @Summary Update a message
 // @Tags messages
 // @Accept json
+// @Accept mpfd
 // @Produce json
-// @Param id path string true "Message ID"
+// @Param id path int true "Message ID"
+// @Param X-Tenant-ID header string false "Tenant (defaults to \"default\")"
 // @Param message body messageBody true "Updated message"
+// @Param Idempotency-Key header string false "Retry-safe key, 1-255 visible ASCII; a retry within IDEMPOTENCY_TTL returns the first trace_id or its ack"
 // @Success 200 {object} Ack
+// @Failure 400 {object} problem.Details "INVALID_PARAM, INVALID_BODY or INVALID_HEADER"
+// @Failure 422 {object} problem.Details "IDEMPOTENCY_KEY_REUSED: the key was used for a different request"
+// @Failure 403 {object} problem.Details "UNKNOWN_TENANT"
+// @Failure 503 {object} problem.Details "KAFKA_UNAVAILABLE or ENQUEUE_FAILED, with Retry-After"
+// @Failure 401 {object} problem.Details "INVALID_REQUEST or INVALID_TOKEN (when AUTH_JWT_ROUTES covers the route)"
+// @Security BearerAuth
 // @Router /messages/{id} [put]
+func updateMessageHandler(producer sarama.SyncProducer, cmdTopic string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		tid, ok := resolveTenant(w, r)
+		if !ok {
+			return
+		}
+		b, ref, err := readMessageBody(r, tid)
+		if err != nil {
+			writeBodyError(w, r, err)
+			return
+		}
+		payload := map[string]any{"id": chi.URLParam(r, "id"), "message": b.Message}
+		if ref != nil {
+			payload["attachment"] = ref.Map()
+		}
+		enqueueCommand(w, r, producer, cmdTopic, tid, audit.FromRequest(r), deployment.FromContext(r.Context()), "Update", payload)
+	}
+}
+
 // @Summary Delete a message
 // @Tags messages
-// @Param id path string true "Message ID"
+// @Param id path int true "Message ID"
+// @Param X-Tenant-ID header string false "Tenant (defaults to \"default\")"
 // @Success 204
+// @Failure 400 {object} problem.Details "INVALID_PARAM: id is not a positive integer"
+// @Failure 403 {object} problem.Details "UNKNOWN_TENANT"
+// @Failure 503 {object} problem.Details "KAFKA_UNAVAILABLE or ENQUEUE_FAILED, with Retry-After"
+// @Failure 401 {object} problem.Details "INVALID_REQUEST or INVALID_TOKEN (when AUTH_JWT_ROUTES covers the route)"
+// @Security BearerAuth
 // @Router /messages/{id} [delete]
-func messageByIDHandler(producer sarama.SyncProducer, cmdTopic string) http.HandlerFunc {
+func deleteMessageHandler(producer sarama.SyncProducer, cmdTopic string) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		idStr := strings.TrimPrefix(r.URL.Path, "/v1/messages/")
-		switch r.Method {
-		case http.MethodGet:
-			enqueueCommand(w, producer, cmdTopic, "Read", map[string]any{"id": idStr})
-		case http.MethodPut:
-			var b messageBody
-			if json.NewDecoder(r.Body).Decode(&b) != nil || strings.TrimSpace(b.Message) == "" {
-				http.Error(w, "invalid body", 400)
-				return
-			}
-			enqueueCommand(w, producer, cmdTopic, "Update", map[string]any{"id": idStr, "message": b.Message})
-		case http.MethodDelete:
-			enqueueCommand(w, producer, cmdTopic, "Delete", map[string]any{"id": idStr})
-		default:
-			w.WriteHeader(http.StatusMethodNotAllowed)
+		tid, ok := resolveTenant(w, r)
+		if !ok {
+			return
 		}
+		enqueueCommand(w, r, producer, cmdTopic, tid, audit.FromRequest(r), deployment.FromContext(r.Context()), "Delete", map[string]any{"id": chi.URLParam(r, "id")})
 	}
 }
 
 // @Summary Get operation status
+// @Description The Ack of a successful operation. A failed one is answered with a problem+json body
+// @Description whose status and code come from the Ack's error; its trace_id is the operation's.
+// @Description While a DeleteByQuery or UpdateByQuery runs, this is its latest PROGRESS Ack, whose
+// @Description payload has processed, total and percent; poll until the status is final.
 // @Tags operations
 // @Produce json
 // @Param trace_id path string true "Trace ID"
+// @Param X-Tenant-ID header string false "Tenant (defaults to \"default\")"
 // @Success 200 {object} Ack
 // @Success 204 {string} string "No Content"
+// @Failure 400 {object} problem.Details "BAD_REQUEST or UNSUPPORTED"
+// @Failure 404 {object} problem.Details "NOT_FOUND"
+// @Failure 403 {object} problem.Details "UNKNOWN_TENANT"
+// @Failure 502 {object} problem.Details "unknown consumer error code"
+// @Failure 500 {object} problem.Details "DB_ERROR or INTERNAL"
+// @Failure 401 {object} problem.Details "INVALID_REQUEST or INVALID_TOKEN (when AUTH_JWT_ROUTES covers the route)"
+// @Security BearerAuth
 // @Router /operations/{trace_id} [get]
 func operationResultHandler() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		traceID := strings.TrimPrefix(r.URL.Path, "/v1/operations/")
+		traceID := chi.URLParam(r, "trace_id")
+		tid, ok := resolveTenant(w, r)
+		if !ok {
+			return
+		}
 		ctx, cancel := context.WithTimeout(r.Context(), 15*time.Second)
 		defer cancel()
-		for {
-			if a, ok := getAck(traceID); ok {
-				w.Header().Set("Content-Type", "application/json")
-				_ = json.NewEncoder(w).Encode(a)
-				return
-			}
-			select {
-			case <-ctx.Done():
-				w.WriteHeader(http.StatusNoContent)
-				return
-			case <-time.After(200 * time.Millisecond):
+		a, ok := awaitAck(ctx, traceID, tid)
+		if !ok {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		if a.Error != nil {
+			problem.FromAck(a.TraceID, a.Error.Code, a.Error.Detail).Write(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(a)
+	}
+}
+
+// awaitAck returns the ack for traceID from the result cache, or waits on
+// acksBus until it arrives or ctx is done. The request's span gets an
+// "ack" event when it does.
+func awaitAck(ctx context.Context, traceID, tenantID string) (Ack, bool) {
+	acks, unsubscribe := acksBus.subscribe(traceID)
+	defer unsubscribe()
+	span := oteltrace.SpanFromContext(ctx)
+	// acks of other tenants are invisible, even with a known trace id
+	if a, ok := getAck(traceID); ok && ackTenant(a) == tenantID {
+		span.AddEvent("ack", oteltrace.WithAttributes(attrOperation.String(traceID), attrStatus.String(a.Status)))
+		return a, true
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return Ack{}, false
+		case a := <-acks:
+			if ackTenant(a) == tenantID {
+				span.AddEvent("ack", oteltrace.WithAttributes(attrOperation.String(traceID), attrStatus.String(a.Status),
+					attribute.Bool("app.ack.waited", true)))
+				return a, true
 			}
 		}
 	}
 }
 
-func enqueueCommand(w http.ResponseWriter, p sarama.SyncProducer, topic, cmd string, payload map[string]any) {
+// serveCachedRead answers a GET from the read cache. The cached ack is
+// stored under a fresh trace id, so clients that go on to poll
+// /operations/{trace_id} get it immediately; the body is the full ack, a
+// superset of acceptedResp.
+func serveCachedRead(w http.ResponseWriter, r *http.Request, tenantID, idStr string) bool {
+	if reads == nil {
+		return false
+	}
+	id, ok := messageID(idStr)
+	if !ok {
+		return false
+	}
+	a, ok := reads.Get(r.Context(), tenantID, id)
+	if !ok {
+		w.Header().Set("X-Cache", "MISS")
+		return false
+	}
+	a.TraceID = uuid.NewString()
+	putAck(a)
+	w.Header().Set("X-Cache", "HIT")
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(a)
+	return true
+}
+
+// timedOut answers a request that gave up waiting for the ack of traceID;
+// the operation may still complete and can be polled.
+func timedOut(w http.ResponseWriter, r *http.Request, traceID string) {
+	p := problem.New(http.StatusGatewayTimeout, problem.CodeTimeout, "no result yet; poll /v1/operations/"+traceID)
+	p.TraceID = traceID
+	p.Write(w, r)
+}
+
+func ackTenant(a Ack) string {
+	if a.TenantID == "" {
+		return tenant.Default
+	}
+	return a.TenantID
+}
+
+func enqueueCommand(w http.ResponseWriter, r *http.Request, p sarama.SyncProducer, topic, tenantID, actor, track, cmd string, payload map[string]any) {
+	key, ok := idempotencyKey(r)
+	if !ok {
+		problem.Write(w, r, http.StatusBadRequest, problem.CodeInvalidHeader, "invalid "+IdempotencyHeader)
+		return
+	}
 	traceID := uuid.NewString()
-	idemp := uuid.NewString()
+	if key != "" && idemKeys != nil {
+		fp := fingerprint(cmd, payload)
+		prev, seen, err := idemKeys.Reserve(r.Context(), tenantID, key, idemRecord{TraceID: traceID, Command: cmd, Fingerprint: fp, Created: time.Now()})
+		switch {
+		case err != nil:
+			// the consumers still deduplicate on the key
+			slog.WarnContext(r.Context(), "idempotency reserve", "tenant_id", tenantID, "key", key, "err", err)
+		case seen:
+			replayIdempotent(w, r, tenantID, fp, prev)
+			return
+		}
+	}
+
+	traceID, err := publishKeyedCommand(r.Context(), p, topic, tenantID, actor, track, cmd, traceID, key, payload)
+	if err != nil {
+		if key != "" && idemKeys != nil {
+			idemKeys.Release(context.WithoutCancel(r.Context()), tenantID, key)
+		}
+		writeEnqueueError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(acceptedResp{TraceID: traceID, Status: "PENDING"})
+}
+
+// publishCommand sends one command on behalf of actor to the workers of
+// track and returns its trace id. The produce goes through the endpoint's
+// circuit breaker, so while Kafka is down it fails fast with a
+// *breaker.OpenError. The record carries the span context of ctx, so the
+// consumer's work shows up in the request's trace.
+func publishCommand(ctx context.Context, p sarama.SyncProducer, topic, tenantID, actor, track, cmd string, payload map[string]any) (string, error) {
+	return publishKeyedCommand(ctx, p, topic, tenantID, actor, track, cmd, uuid.NewString(), "", payload)
+}
+
+// publishKeyedCommand is publishCommand under a given trace id and
+// idempotency key; an empty key gets a fresh one, so the command is never
+// deduplicated.
+func publishKeyedCommand(ctx context.Context, p sarama.SyncProducer, topic, tenantID, actor, track, cmd, traceID, idemp string, payload map[string]any) (string, error) {
+	if idemp == "" {
+		idemp = uuid.NewString()
+	}
 	m := map[string]any{
 		"trace_id": traceID,
 		"command":  cmd,
 		"resource": "Message",
 		"payload":  payload,
+		"metadata": map[string]any{tenant.MetadataKey: tenantID, audit.MetadataKey: actor, deployment.MetadataKey: track},
+	}
+	b, err := codec.EncodeCommand(m)
+	if err != nil {
+		slog.ErrorContext(ctx, "encode command", "trace_id", traceID, "command", cmd, "err", err)
+		return "", err
 	}
-	b, _ := json.Marshal(m)
 
 	headers := []sarama.RecordHeader{
 		{Key: []byte("trace_id"), Value: []byte(traceID)},
 		{Key: []byte("command"), Value: []byte(cmd)},
+		{Key: []byte(tenant.MetadataKey), Value: []byte(tenantID)},
+		{Key: []byte(audit.MetadataKey), Value: []byte(actor)},
+		{Key: []byte(deployment.MetadataKey), Value: []byte(track)},
+		{Key: []byte(contracts.HeaderContentType), Value: []byte(codec.ContentType())},
+		{Key: []byte(contracts.HeaderAccept), Value: []byte(codec.ContentType())},
 	}
 
 	msg := &sarama.ProducerMessage{
-		Topic:   topic,
+		Topic:   tenant.Topic(tenantTopics, tenantID, deployment.Topic(canaryRouting, track, topic)),
 		Key:     sarama.ByteEncoder(idemp),
 		Value:   sarama.ByteEncoder(b),
 		Headers: headers,
 	}
 
-	if _, _, err := p.SendMessage(msg); err != nil {
-		http.Error(w, "enqueue failed", 503)
-		return
+	_, span := kafkahelper.StartProduce(ctx, msg, attrOperation.String(traceID), attrCommand.String(cmd), attrTenant.String(tenantID))
+	var partition int32
+	var offset int64
+	err = endpointBreaker(cmd).Do(func() error {
+		var err error
+		partition, offset, err = p.SendMessage(msg)
+		return err
+	})
+	kafkahelper.EndProduce(span, partition, offset, err)
+	var open *breaker.OpenError
+	switch {
+	case errors.As(err, &open):
+		breakerRejectedTotal.WithLabelValues(cmd).Inc()
+	case err != nil:
+		kafkaPublishErrorsTotal.WithLabelValues(cmd).Inc()
 	}
-
-	w.Header().Set("Content-Type", "application/json")
-	_ = json.NewEncoder(w).Encode(acceptedResp{TraceID: traceID, Status: "PENDING"})
+	if err != nil {
+		slog.WarnContext(ctx, "publish command", "trace_id", traceID, "command", cmd, "tenant_id", tenantID, "err", err)
+		return "", err
+	}
+	slog.DebugContext(ctx, "command published", "trace_id", traceID, "command", cmd, "tenant_id", tenantID,
+		"partition", partition, "offset", offset)
+	noteOperation(ctx, traceID)
+	pending.add(traceID, cmd)
+	return traceID, nil
 }
 
-func startAckConsumer(brokers []string, topic string) {
-	cfg := sarama.NewConfig()
-	cfg.Consumer.Group.Rebalance.Strategy = sarama.BalanceStrategyRange
-	cfg.Consumer.Offsets.Initial = sarama.OffsetOldest
-	cfg.Version = sarama.V2_6_0_0
-
-	group, err := sarama.NewConsumerGroup(brokers, "api-acks", cfg)
+// startAckConsumer joins the api-acks group, waiting for the brokers as
+// policy allows, and consumes acks in the background.
+func startAckConsumer(ctx context.Context, policy startup.Policy, brokers []string, opts kafkahelper.Options, topics []string) {
+	group, err := startup.Connect(ctx, policy, "kafka ack consumer group", func(context.Context) (sarama.ConsumerGroup, error) {
+		return kafkahelper.NewConsumerGroup(brokers, "api-acks", opts)
+	})
 	if err != nil {
-		log.Fatal(err)
+		observability.Fatal("kafka ack consumer group", "err", err)
 	}
 
 	handler := &ackHandler{}
 
 	go func() {
 		for {
-			if err := group.Consume(context.Background(), []string{topic}, handler); err != nil {
-				log.Println("ack consume error:", err)
+			if err := group.Consume(context.Background(), topics, handler); err != nil {
+				slog.Error("ack consume", "err", err)
 				time.Sleep(time.Second)
 			}
 		}
@@ -239,48 +506,189 @@ func (ackHandler) Setup(sarama.ConsumerGroupSession) error   { return nil }
 func (ackHandler) Cleanup(sarama.ConsumerGroupSession) error { return nil }
 func (ackHandler) ConsumeClaim(sess sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
 	for msg := range claim.Messages() {
+		// decode by the header, not KAFKA_CODEC: consumers may still be
+		// replying in the old encoding during a rollout
+		c, err := contracts.ForContentType(kafkahelper.Header(msg.Headers, contracts.HeaderContentType))
+		if err != nil {
+			slog.Warn("ack: unknown content type", "partition", msg.Partition, "offset", msg.Offset, "err", err)
+			continue
+		}
+		// continues the trace of the request that sent the command
+		_, span := kafkahelper.StartConsume(sess.Context(), msg, "api-acks")
 		var a Ack
-		if err := json.Unmarshal(msg.Value, &a); err == nil && a.TraceID != "" {
+		err = c.DecodeAck(msg.Value, &a)
+		if err == nil {
+			// a malformed ack must not become the cached result of an operation
+			err = a.Validate()
+		}
+		if err == nil {
+			span.SetAttributes(attrOperation.String(a.TraceID), attrStatus.String(a.Status), attrTenant.String(ackTenant(a)))
+			slog.Debug("ack received", "trace_id", a.TraceID, "event", a.Event, "status", a.Status,
+				"partition", msg.Partition, "offset", msg.Offset)
 			putAck(a)
+			observeAck(a)
+			observeAckForCache(a)
 			sess.MarkMessage(msg, "")
+		} else {
+			slog.Warn("ack: invalid", "trace_id", a.TraceID, "partition", msg.Partition, "offset", msg.Offset, "err", err)
+			invalidAcksTotal.Inc()
+			span.SetStatus(codes.Error, "invalid ack")
 		}
+		span.End()
 	}
 	return nil
 }
 
-func getenv(k, d string) string {
-	if v := os.Getenv(k); v != "" {
-		return v
+// startGate answers 503 STARTING until open is called with the router, so
+// the port can serve probes while the dependencies are connected.
+type startGate struct{ next atomic.Pointer[http.Handler] }
+
+func (g *startGate) open(h http.Handler) { g.next.Store(&h) }
+
+func (g *startGate) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h := g.next.Load(); h != nil {
+		(*h).ServeHTTP(w, r)
+		return
 	}
-	return d
+	w.Header().Set("Retry-After", "1")
+	problem.Write(w, r, http.StatusServiceUnavailable, problem.CodeStarting, "connecting to dependencies")
 }
 
+// Span attributes tying spans to the operation they are part of.
+var (
+	attrOperation = attribute.Key("app.operation.trace_id")
+	attrCommand   = attribute.Key("app.command")
+	attrTenant    = attribute.Key("app.tenant_id")
+	attrStatus    = attribute.Key("app.ack.status")
+)
+
 func main() {
-	brokers := strings.Split(getenv("KAFKA_BROKERS", "kafka:9092"), ",")
-	cmdTopic := getenv("KAFKA_TOPIC_COMMANDS", "messages.commands")
-	acksTopic := getenv("KAFKA_TOPIC_ACKS", "messages.acks")
-	addr := getenv("API_HTTP_ADDR", ":8080")
-
-	cfg := sarama.NewConfig()
-	cfg.Producer.RequiredAcks = sarama.WaitForAll
-	cfg.Producer.Idempotent = true
-	cfg.Producer.Return.Successes = true
-	cfg.Net.MaxOpenRequests = 1
-
-	producer, err := sarama.NewSyncProducer(brokers, cfg)
+	var conf config.API
+	if err := config.Load(&conf, "apisvc", os.Args[1:]); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			os.Exit(0)
+		}
+		observability.Fatal("config", "err", err)
+	}
+	defer observability.MustStart(conf.Observability.Config("apisvc"))()
+	brokers := conf.Kafka.Brokers
+	cmdTopic, acksTopic := conf.Kafka.CommandsTopic, conf.Kafka.AcksTopic
+	addr := conf.Addr
+	tenantTopics = conf.Tenancy.TopicPrefix
+	tenants := conf.Tenancy.IDs()
+	codec, _ = contracts.ByName(conf.Kafka.Codec) // validated by Load
+	for _, id := range tenants {
+		allowedTenants[id] = true
+	}
+	canaryRouting = conf.CanaryRouting
+	breakerSettings.Failures = conf.Breaker.Failures
+	breakerSettings.OpenFor = conf.Breaker.Open
+	breakerSettings.Probes = conf.Breaker.Probes
+	authCfg := conf.Auth.Config()
+	canaryPercent := conf.CanaryPercent
+
+	problem.TypeBase = conf.ProblemTypeBase
+
+	// the port comes up first: /readyz fails and the API answers 503
+	// STARTING until Kafka and the stores are connected
+	probes := conf.Health.Checker()
+	started := probes.Starting()
+	api := &startGate{}
+	root := http.NewServeMux()
+	// probes skip auth, tracing and the request metrics
+	probes.Register(root)
+	root.Handle("/", api)
+	serveErr := make(chan error, 1)
+	go func() { serveErr <- http.ListenAndServe(addr, root) }()
+	slog.Info("API listening", "addr", addr)
+	ctx, policy := context.Background(), conf.Startup.Policy()
+
+	producer, err := startup.Connect(ctx, policy, "kafka producer", func(context.Context) (sarama.SyncProducer, error) {
+		return kafkahelper.NewIdempotentProducer(brokers, conf.Kafka.Options())
+	})
 	if err != nil {
-		log.Fatal(err)
+		observability.Fatal("kafka producer", "err", err)
 	}
 	defer producer.Close()
 
-	go startAckConsumer(brokers, acksTopic)
-	go sweeper()
+	// commands are framed with the schema id of contracts.proto, registered
+	// under the subject of every topic they can go to
+	if reg := conf.Kafka.Registry(); reg != nil {
+		var topics []string
+		for _, track := range []string{deployment.Stable, deployment.Canary} {
+			topics = append(topics, tenant.Topics(tenantTopics, tenants, deployment.Topic(canaryRouting, track, cmdTopic))...)
+		}
+		subjects := conf.Kafka.SchemaRegistry.Subjects
+		rc, err := startup.Connect(ctx, policy, "schema registry", func(ctx context.Context) (contracts.RegistryCodec, error) {
+			return contracts.RegisterCommands(ctx, reg, subjects, topics)
+		})
+		if err != nil {
+			observability.Fatal("schema registry", "err", err)
+		}
+		codec = rc
+		slog.Info("schema registry: commands registered", "schema_id", rc.SchemaID, "subjects", subjects)
+	}
+
+	kafkaHealth, err := startup.Connect(ctx, policy, "kafka health client", func(context.Context) (sarama.Client, error) {
+		return kafkahelper.NewHealthClient(brokers, conf.Kafka.Options())
+	})
+	if err != nil {
+		observability.Fatal("kafka health client", "err", err)
+	}
+	defer kafkaHealth.Close()
+	probes.Add("kafka", kafkahelper.HealthCheck(kafkaHealth,
+		append(tenant.Topics(tenantTopics, tenants, cmdTopic), tenant.Topics(tenantTopics, tenants, acksTopic)...)...))
+
+	// each store retries on its own, so the log names the one being waited for
+	if blobs, err = startup.Connect(ctx, policy, "blob store", func(context.Context) (blob.Store, error) {
+		return openBlobStore(&conf)
+	}); err != nil {
+		observability.Fatal("blob store", "err", err)
+	}
+	maxAttachmentBytes = conf.Attachments.MaxBytes
+
+	if results, err = startup.Connect(ctx, policy, "ack store", func(context.Context) (ackStore, error) {
+		return openAckStore(&conf)
+	}); err != nil {
+		observability.Fatal("ack store", "err", err)
+	}
+	if reads, err = startup.Connect(ctx, policy, "read cache", func(context.Context) (readCache, error) {
+		return openReadCache(&conf)
+	}); err != nil {
+		observability.Fatal("read cache", "err", err)
+	}
+	if idemKeys, err = startup.Connect(ctx, policy, "idempotency store", func(context.Context) (idempotencyStore, error) {
+		return openIdempotencyStore(&conf)
+	}); err != nil {
+		observability.Fatal("idempotency store", "err", err)
+	}
+	if readModel, err = startup.Connect(ctx, policy, "read model", func(context.Context) (*readmodel.Store, error) {
+		return openReadModel(&conf)
+	}); err != nil {
+		observability.Fatal("read model", "err", err)
+	}
+	if readModel != nil {
+		defer readModel.Close()
+		probes.Add("mysql", readModel.Ping)
+	}
+	if s, ok := results.(*redisAckStore); ok {
+		probes.Add("redis", func(ctx context.Context) error { return s.c.Ping(ctx).Err() })
+	}
+	streamTimeout = conf.StreamTimeout
+	eventsTimeout, eventsKeepAlive = conf.SSETimeout, conf.SSEKeepAlive
+	pending.ttl = conf.PendingTTL
+	go pending.expire(30 * time.Second)
 
-	mux := http.NewServeMux()
-	mux.HandleFunc("/v1/messages", createMessageHandler(producer, cmdTopic))
-	mux.HandleFunc("/v1/messages/", messageByIDHandler(producer, cmdTopic))
-	mux.HandleFunc("/v1/operations/", operationResultHandler())
+	startAckConsumer(ctx, policy, brokers, conf.Kafka.Options(), tenant.Topics(tenantTopics, tenants, acksTopic))
 
-	log.Println("API listening on", addr)
-	log.Fatal(http.ListenAndServe(addr, mux))
+	if canaryPercent > 0 {
+		slog.Info("canary", "percent", canaryPercent, "routing", canaryRouting)
+	}
+	if authCfg.Enabled() {
+		slog.Info("auth: bearer JWT required", "routes", strings.Join(authCfg.Routes, ","))
+	}
+	api.open(newRouter(producer, cmdTopic, authCfg, canaryPercent, conf.AccessLog))
+	started()
+	slog.Info("API ready")
+	observability.Fatal("http server", "err", <-serveErr)
 }
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/felixge/httpsnoop"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/slb-uk/rest-go-webservice/project/pkg/observability"
+)
+
+var (
+	httpRequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "apisvc_http_requests_total",
+		Help: "HTTP requests, by route, method and status code.",
+	}, []string{"route", "method", "code"})
+
+	// up to 15s: GET /v1/operations/{trace_id} long-polls that long
+	httpRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "apisvc_http_request_duration_seconds",
+		Help:    "HTTP request latency, by route and method. Stream routes last as long as the stream.",
+		Buckets: []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 15},
+	}, []string{"route", "method"})
+
+	httpInFlight = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "apisvc_http_requests_in_flight",
+		Help: "HTTP requests being served, including open streams.",
+	})
+
+	pendingOperations = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "apisvc_pending_operations",
+		Help: "Commands this replica published whose ack has not arrived yet.",
+	})
+
+	operationDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "apisvc_operation_duration_seconds",
+		Help:    "Time from publishing a command to receiving its ack, by command and ack status.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"command", "status"})
+
+	operationsAbandonedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "apisvc_operations_abandoned_total",
+		Help: "Pending operations dropped after PENDING_TTL without an ack.",
+	}, []string{"command"})
+
+	kafkaPublishErrorsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "apisvc_kafka_publish_errors_total",
+		Help: "Commands the Kafka producer failed to send. Breaker rejections are counted in apisvc_kafka_breaker_rejected_total instead.",
+	}, []string{"endpoint"})
+
+	acksTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "apisvc_acks_total",
+		Help: "Acks consumed, by status; replayed=\"true\" are idempotency hits, the result of an earlier identical command.",
+	}, []string{"status", "replayed"})
+
+	invalidAcksTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "apisvc_acks_invalid_total",
+		Help: "Acks dropped because they did not decode or broke the ack schema.",
+	})
+
+	idempotentReplaysTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "apisvc_idempotent_replays_total",
+		Help: "Requests answered from an earlier request with the same Idempotency-Key, by outcome: result, pending or mismatch (422).",
+	}, []string{"command", "outcome"})
+)
+
+// withMetrics counts and times every request by route. It runs inside the
+// tracing handler, so latency observations carry the request's trace as an
+// exemplar. httpsnoop keeps the Flusher and Hijacker of w that the SSE and
+// WebSocket routes need.
+func withMetrics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		httpInFlight.Inc()
+		defer httpInFlight.Dec()
+		m := httpsnoop.CaptureMetrics(next, w, r)
+		route, method := routeOf(r), metricMethod(r.Method)
+		httpRequestsTotal.WithLabelValues(route, method, strconv.Itoa(m.Code)).Inc()
+		obs := httpRequestDuration.WithLabelValues(route, method)
+		if ex := observability.Exemplar(r.Context()); ex != nil {
+			obs.(prometheus.ExemplarObserver).ObserveWithExemplar(m.Duration.Seconds(), ex)
+		} else {
+			obs.Observe(m.Duration.Seconds())
+		}
+	})
+}
+
+// metricMethod keeps the method label to the methods the API serves.
+func metricMethod(m string) string {
+	switch m {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete, http.MethodOptions:
+		return m
+	}
+	return "other"
+}
+
+// pendingOps tracks the commands this replica published until their ack
+// arrives, either from the ack consumer or relayed by another replica
+// (ACK_STORE=redis). With the memory store and more than one replica an
+// ack consumed elsewhere never arrives here; such operations are dropped
+// after PENDING_TTL and counted as abandoned.
+type pendingOps struct {
+	mu  sync.Mutex
+	ops map[string]pendingOp
+	ttl time.Duration
+}
+
+type pendingOp struct {
+	command string
+	start   time.Time
+}
+
+var pending = &pendingOps{ops: map[string]pendingOp{}, ttl: 5 * time.Minute}
+
+func (p *pendingOps) add(traceID, command string) {
+	p.mu.Lock()
+	p.ops[traceID] = pendingOp{command: command, start: time.Now()}
+	pendingOperations.Set(float64(len(p.ops)))
+	p.mu.Unlock()
+}
+
+// done records the ack of a pending operation; acks of operations this
+// replica did not publish are ignored, and so are PROGRESS acks, which
+// leave the operation running.
+func (p *pendingOps) done(a Ack) {
+	if a.Status == "PROGRESS" {
+		return
+	}
+	p.mu.Lock()
+	op, ok := p.ops[a.TraceID]
+	delete(p.ops, a.TraceID)
+	pendingOperations.Set(float64(len(p.ops)))
+	p.mu.Unlock()
+	if ok {
+		operationDuration.WithLabelValues(op.command, a.Status).Observe(time.Since(op.start).Seconds())
+	}
+}
+
+// expire drops operations older than ttl, every interval.
+func (p *pendingOps) expire(interval time.Duration) {
+	for range time.Tick(interval) {
+		p.mu.Lock()
+		for id, op := range p.ops {
+			if time.Since(op.start) > p.ttl {
+				delete(p.ops, id)
+				operationsAbandonedTotal.WithLabelValues(op.command).Inc()
+			}
+		}
+		pendingOperations.Set(float64(len(p.ops)))
+		p.mu.Unlock()
+	}
+}
+
+// observeAck counts an ack consumed from Kafka and closes its operation.
+func observeAck(a Ack) {
+	acksTotal.WithLabelValues(a.Status, strconv.FormatBool(a.Replayed)).Inc()
+	pending.done(a)
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"sync"
+)
+
+// acksBus is the waiter registry: putAck signals it, keyed by trace id, and
+// handlers blocked on a result (the /operations/{trace_id} long poll, SSE,
+// WebSocket) receive the ack on their channel the moment it is stored.
+var acksBus = &ackBus{subs: map[string]map[*ackSub]struct{}{}}
+
+// progressSlack is how many PROGRESS acks a listener may have buffered on
+// top of the final ones it waits for.
+const progressSlack = 8
+
+type ackBus struct {
+	mu   sync.Mutex
+	subs map[string]map[*ackSub]struct{}
+}
+
+// ackSub is one listener. Every trace id in open still has a slot in ch
+// for its final ack; PROGRESS acks only take the slots beyond those.
+type ackSub struct {
+	ch   chan Ack
+	open map[string]bool
+}
+
+// subscribe returns a channel receiving the acks for traceIDs and a func to
+// unsubscribe. Subscribe before checking the result cache so an ack landing
+// in between is not missed; the caller deduplicates.
+func (b *ackBus) subscribe(traceIDs ...string) (<-chan Ack, func()) {
+	s := &ackSub{ch: make(chan Ack, len(traceIDs)+progressSlack), open: make(map[string]bool, len(traceIDs))}
+	b.mu.Lock()
+	for _, id := range traceIDs {
+		if b.subs[id] == nil {
+			b.subs[id] = map[*ackSub]struct{}{}
+		}
+		b.subs[id][s] = struct{}{}
+		s.open[id] = true
+	}
+	b.mu.Unlock()
+	return s.ch, func() {
+		b.mu.Lock()
+		for _, id := range traceIDs {
+			delete(b.subs[id], s)
+			if len(b.subs[id]) == 0 {
+				delete(b.subs, id)
+			}
+		}
+		b.mu.Unlock()
+	}
+}
+
+// publish never blocks. A listener gets the first final ack of each trace
+// id it asked for, and PROGRESS acks before it while it keeps up; one that
+// falls behind misses some of those, never the final one.
+func (b *ackBus) publish(a Ack) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for s := range b.subs[a.TraceID] {
+		if !s.open[a.TraceID] {
+			continue
+		}
+		if a.Status == "PROGRESS" {
+			// receivers only ever shrink len(s.ch), so the check holds
+			if len(s.ch) >= cap(s.ch)-len(s.open) {
+				continue
+			}
+		} else {
+			delete(s.open, a.TraceID)
+		}
+		select {
+		case s.ch <- a:
+		default:
+		}
+	}
+}
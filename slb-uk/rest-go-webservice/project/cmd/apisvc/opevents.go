@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/slb-uk/rest-go-webservice/project/pkg/problem"
+)
+
+var (
+	// eventsTimeout (SSE_TIMEOUT) bounds how long an events request waits
+	// for the next ack; eventsKeepAlive (SSE_KEEPALIVE) is the interval between
+	// comment lines that keep proxies from closing an idle connection.
+	eventsTimeout   = 60 * time.Second
+	eventsKeepAlive = 15 * time.Second
+)
+
+// @Summary Push operation status over Server-Sent Events
+// @Description Holds the connection open and sends the Ack as an "ack" event as soon as the ack
+// @Description consumer receives it (immediately if it already arrived), then ends the response.
+// @Description The PROGRESS acks of a bulk command go out as "progress" events before it.
+// @Description While waiting, a ": ping" comment goes out every SSE_KEEPALIVE. After SSE_TIMEOUT
+// @Description without an ack a "timeout" event is sent instead. EventSource reconnects when a response ends,
+// @Description so clients should close it on either event.
+// @Tags operations
+// @Produce text/event-stream
+// @Param trace_id path string true "Trace ID"
+// @Param X-Tenant-ID header string false "Tenant (defaults to \"default\")"
+// @Success 200 {object} Ack "event: ack (or progress), data: the Ack"
+// @Failure 403 {object} problem.Details "UNKNOWN_TENANT"
+// @Failure 401 {object} problem.Details "INVALID_REQUEST or INVALID_TOKEN (when AUTH_JWT_ROUTES covers the route)"
+// @Security BearerAuth
+// @Router /operations/{trace_id}/events [get]
+func operationEventsHandler(w http.ResponseWriter, r *http.Request) {
+	traceID := chi.URLParam(r, "trace_id")
+	tid, ok := resolveTenant(w, r)
+	if !ok {
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		problem.Write(w, r, http.StatusInternalServerError, problem.CodeStreamingUnsupported, "the connection does not support streaming")
+		return
+	}
+
+	acks, unsubscribe := acksBus.subscribe(traceID)
+	defer unsubscribe()
+
+	h := w.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	h.Set("X-Accel-Buffering", "no") // nginx would otherwise buffer the stream
+	w.WriteHeader(http.StatusOK)
+
+	// acks of other tenants are invisible, even with a known trace id
+	if a, ok := getAck(traceID); ok && ackTenant(a) == tid {
+		if a.Status != "PROGRESS" {
+			writeEvent(w, flusher, "ack", traceID, a)
+			return
+		}
+		writeEvent(w, flusher, "progress", traceID, a)
+	}
+	flusher.Flush()
+
+	ping := time.NewTicker(eventsKeepAlive)
+	defer ping.Stop()
+	timeout := time.NewTimer(eventsTimeout)
+	defer timeout.Stop()
+	for {
+		select {
+		case a := <-acks:
+			switch {
+			case ackTenant(a) != tid:
+			case a.Status == "PROGRESS":
+				writeEvent(w, flusher, "progress", traceID, a)
+				timeout.Reset(eventsTimeout) // still running
+			default:
+				writeEvent(w, flusher, "ack", traceID, a)
+				return
+			}
+		case <-ping.C:
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-timeout.C:
+			writeEvent(w, flusher, "timeout", traceID, acceptedResp{TraceID: traceID, Status: "PENDING"})
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// writeEvent sends v as one SSE event; encoding/json never emits newlines,
+// so the data fits on a single data line.
+func writeEvent(w http.ResponseWriter, f http.Flusher, event, id string, v any) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return
+	}
+	fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", id, event, b)
+	f.Flush()
+}
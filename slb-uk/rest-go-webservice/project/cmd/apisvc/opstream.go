@@ -0,0 +1,141 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/slb-uk/rest-go-webservice/project/pkg/problem"
+)
+
+const (
+	maxStreamTraceIDs = 100
+	streamWriteWait   = 5 * time.Second
+	streamPongWait    = 60 * time.Second
+)
+
+var (
+	streamTimeout = 5 * time.Minute
+
+	upgrader = websocket.Upgrader{
+		ReadBufferSize:  1024,
+		WriteBufferSize: 4096,
+		// the API is meant to be called from other origins (Swagger UI, SPAs);
+		// tenant checks still apply
+		CheckOrigin: func(*http.Request) bool { return true },
+	}
+)
+
+// @Summary Stream operation results
+// @Description Upgrades to a WebSocket and sends each requested operation's Ack as a JSON text
+// @Description frame as soon as it is available. Acks that arrived before the connection are sent
+// @Description first, so reconnecting with the same trace ids never misses a result. PROGRESS acks of
+// @Description bulk commands are sent too, but only a final ack completes an operation. The server
+// @Description closes normally (1000) once every final ack was sent, or after STREAM_TIMEOUT (1001).
+// @Tags operations
+// @Produce json
+// @Param trace_id query string true "Trace id; repeat or comma-separate for several (max 100)"
+// @Param X-Tenant-ID header string false "Tenant (defaults to \"default\")"
+// @Success 101 {object} Ack "Switching Protocols; one Ack per frame"
+// @Failure 400 {object} problem.Details "INVALID_PARAM: missing trace_id"
+// @Failure 403 {object} problem.Details "UNKNOWN_TENANT"
+// @Failure 401 {object} problem.Details "INVALID_REQUEST or INVALID_TOKEN (when AUTH_JWT_ROUTES covers the route)"
+// @Security BearerAuth
+// @Router /operations/stream [get]
+func operationStreamHandler(w http.ResponseWriter, r *http.Request) {
+	tid, ok := resolveTenant(w, r)
+	if !ok {
+		return
+	}
+	var ids []string
+	seen := map[string]bool{}
+	for _, v := range r.URL.Query()["trace_id"] {
+		for _, id := range strings.Split(v, ",") {
+			if id = strings.TrimSpace(id); id != "" && !seen[id] {
+				seen[id] = true
+				ids = append(ids, id)
+			}
+		}
+	}
+	if len(ids) == 0 || len(ids) > maxStreamTraceIDs {
+		problem.Write(w, r, http.StatusBadRequest, problem.CodeInvalidParam, "between 1 and 100 trace_id values required")
+		return
+	}
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return // Upgrade already replied
+	}
+	defer conn.Close()
+
+	acks, unsubscribe := acksBus.subscribe(ids...)
+	defer unsubscribe()
+
+	pending := len(ids)
+	send := func(a Ack) bool {
+		// acks of other tenants are invisible, even with a known trace id
+		if !seen[a.TraceID] || ackTenant(a) != tid {
+			return true
+		}
+		if a.Status != "PROGRESS" {
+			seen[a.TraceID] = false
+			pending--
+		}
+		_ = conn.SetWriteDeadline(time.Now().Add(streamWriteWait))
+		return conn.WriteJSON(a) == nil
+	}
+	// catch up on acks that arrived before this connection
+	for _, id := range ids {
+		if a, ok := getAck(id); ok && !send(a) {
+			return
+		}
+	}
+
+	// the read loop only processes control frames and notices disconnects
+	gone := make(chan struct{})
+	go func() {
+		defer close(gone)
+		conn.SetReadLimit(512)
+		_ = conn.SetReadDeadline(time.Now().Add(streamPongWait))
+		conn.SetPongHandler(func(string) error { return conn.SetReadDeadline(time.Now().Add(streamPongWait)) })
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	ping := time.NewTicker(streamPongWait * 9 / 10)
+	defer ping.Stop()
+	timeout := time.NewTimer(streamTimeout)
+	defer timeout.Stop()
+	for pending > 0 {
+		select {
+		case a := <-acks:
+			if !send(a) {
+				return
+			}
+		case <-ping.C:
+			_ = conn.SetWriteDeadline(time.Now().Add(streamWriteWait))
+			if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		case <-timeout.C:
+			closeStream(conn, websocket.CloseGoingAway, "timeout")
+			return
+		case <-gone:
+			return
+		}
+	}
+	closeStream(conn, websocket.CloseNormalClosure, "done")
+}
+
+func closeStream(conn *websocket.Conn, code int, reason string) {
+	msg := websocket.FormatCloseMessage(code, reason)
+	if err := conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(streamWriteWait)); err != nil {
+		slog.Warn("operation stream close", "err", err)
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/slb-uk/rest-go-webservice/project/pkg/config"
+)
+
+// readCache holds the last successful Read ack per message so hot messages
+// skip the Kafka round trip. Entries are filled from MessageRead acks and
+// dropped when a MessageUpdated or MessageDeleted ack for the same message
+// arrives, or a PROGRESS ack of a bulk command that changed it; the TTL
+// bounds staleness when an invalidation is missed.
+type readCache interface {
+	Get(ctx context.Context, tenantID, id string) (Ack, bool)
+	Set(ctx context.Context, tenantID, id string, a Ack)
+	Delete(ctx context.Context, tenantID, id string)
+}
+
+// reads is nil when READ_CACHE=off.
+var reads readCache
+
+func openReadCache(conf *config.API) (readCache, error) {
+	ttl := conf.ReadCache.TTL
+	switch conf.ReadCache.Store {
+	case "memory":
+		return newMemReadCache(ttl), nil
+	case "redis":
+		c, err := openRedis(conf.RedisAddr)
+		if err != nil {
+			return nil, err
+		}
+		return &redisReadCache{c: c, ttl: ttl}, nil
+	case "off":
+		return nil, nil
+	default:
+		return nil, errors.New("unknown READ_CACHE")
+	}
+}
+
+// messageID normalises ids from URLs ("007") and ack payloads (JSON
+// numbers) to the same cache key.
+func messageID(v any) (string, bool) {
+	switch id := v.(type) {
+	case string:
+		n, err := strconv.ParseInt(id, 10, 64)
+		if err != nil {
+			return "", false
+		}
+		return strconv.FormatInt(n, 10), true
+	case float64:
+		return strconv.FormatInt(int64(id), 10), true
+	case int64:
+		return strconv.FormatInt(id, 10), true
+	}
+	return "", false
+}
+
+// observeAckForCache keeps the read cache in step with consumer results.
+// The PROGRESS acks of DeleteByQuery and UpdateByQuery list the messages
+// each chunk changed.
+func observeAckForCache(a Ack) {
+	if reads == nil || a.Replayed {
+		return
+	}
+	if a.Status == "PROGRESS" {
+		ids, _ := a.Payload["ids"].([]any)
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		for _, v := range ids {
+			if id, ok := messageID(v); ok {
+				reads.Delete(ctx, ackTenant(a), id)
+			}
+		}
+		return
+	}
+	if a.Status != "SUCCESS" {
+		return
+	}
+	id, ok := messageID(a.Payload["id"])
+	if !ok {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	switch a.Event {
+	case "MessageRead":
+		reads.Set(ctx, ackTenant(a), id, a)
+	case "MessageUpdated", "MessageDeleted":
+		reads.Delete(ctx, ackTenant(a), id)
+	}
+}
+
+type memEntry struct {
+	ack     Ack
+	expires time.Time
+}
+
+type memReadCache struct {
+	mu  sync.Mutex
+	m   map[string]memEntry
+	ttl time.Duration
+}
+
+func newMemReadCache(ttl time.Duration) *memReadCache {
+	c := &memReadCache{m: make(map[string]memEntry), ttl: ttl}
+	go func() {
+		for range time.Tick(time.Minute) {
+			c.mu.Lock()
+			for k, e := range c.m {
+				if time.Now().After(e.expires) {
+					delete(c.m, k)
+				}
+			}
+			c.mu.Unlock()
+		}
+	}()
+	return c
+}
+
+func (c *memReadCache) Get(_ context.Context, tenantID, id string) (Ack, bool) {
+	c.mu.Lock()
+	e, ok := c.m[tenantID+"/"+id]
+	c.mu.Unlock()
+	if !ok || time.Now().After(e.expires) {
+		return Ack{}, false
+	}
+	return e.ack, true
+}
+
+func (c *memReadCache) Set(_ context.Context, tenantID, id string, a Ack) {
+	c.mu.Lock()
+	c.m[tenantID+"/"+id] = memEntry{ack: a, expires: time.Now().Add(c.ttl)}
+	c.mu.Unlock()
+}
+
+func (c *memReadCache) Delete(_ context.Context, tenantID, id string) {
+	c.mu.Lock()
+	delete(c.m, tenantID+"/"+id)
+	c.mu.Unlock()
+}
+
+// redisReadCache shares entries between apisvc replicas, so an
+// invalidation seen by one replica applies to all of them.
+type redisReadCache struct {
+	c   *redis.Client
+	ttl time.Duration
+}
+
+func (c *redisReadCache) key(tenantID, id string) string {
+	return "apisvc:read:" + tenantID + ":" + id
+}
+
+func (c *redisReadCache) Get(ctx context.Context, tenantID, id string) (Ack, bool) {
+	b, err := c.c.Get(ctx, c.key(tenantID, id)).Bytes()
+	if err != nil {
+		if !errors.Is(err, redis.Nil) {
+			slog.WarnContext(ctx, "read cache get", "tenant_id", tenantID, "id", id, "err", err)
+		}
+		return Ack{}, false
+	}
+	var a Ack
+	if json.Unmarshal(b, &a) != nil {
+		return Ack{}, false
+	}
+	return a, true
+}
+
+func (c *redisReadCache) Set(ctx context.Context, tenantID, id string, a Ack) {
+	b, _ := json.Marshal(a)
+	if err := c.c.Set(ctx, c.key(tenantID, id), b, c.ttl).Err(); err != nil {
+		slog.WarnContext(ctx, "read cache set", "tenant_id", tenantID, "id", id, "err", err)
+	}
+}
+
+func (c *redisReadCache) Delete(ctx context.Context, tenantID, id string) {
+	if err := c.c.Del(ctx, c.key(tenantID, id)).Err(); err != nil {
+		slog.WarnContext(ctx, "read cache delete", "tenant_id", tenantID, "id", id, "err", err)
+	}
+}
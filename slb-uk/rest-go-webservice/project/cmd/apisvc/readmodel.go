@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/google/uuid"
+
+	"github.com/slb-uk/rest-go-webservice/project/pkg/config"
+	"github.com/slb-uk/rest-go-webservice/project/pkg/problem"
+	"github.com/slb-uk/rest-go-webservice/project/pkg/readmodel"
+)
+
+// readModel answers GET and list requests from MySQL; nil when
+// READ_MODEL_DSN is empty, and reads go through Kafka like writes. A read
+// the database cannot answer also falls back to Kafka, except listing
+// messages, which only the read model can do.
+var readModel *readmodel.Store
+
+func openReadModel(conf *config.API) (*readmodel.Store, error) {
+	if conf.ReadModel.DSN == "" {
+		return nil, nil
+	}
+	return readmodel.Open(context.Background(), conf.ReadModel.DSN, conf.ReadModel.MaxConns, conf.ReadModel.Timeout)
+}
+
+// readMessage looks message id of tenantID up in the read model. ok is
+// false when there is none or the query failed; the caller then asks the
+// consumer. err is readmodel.ErrNotFound for a message that does not
+// exist.
+func readMessage(ctx context.Context, tenantID, idStr string) (m readmodel.Message, ok bool, err error) {
+	if readModel == nil {
+		return m, false, nil
+	}
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		return m, false, nil
+	}
+	m, err = readModel.Get(ctx, tenantID, id)
+	if err != nil && !errors.Is(err, readmodel.ErrNotFound) {
+		slog.WarnContext(ctx, "read model get, falling back to kafka", "tenant_id", tenantID, "id", id, "err", err)
+		return m, false, nil
+	}
+	return m, true, err
+}
+
+// serveModelRead answers a GET from the read model with the Ack the
+// consumer would have sent, plus the row's timestamps, stored under a fresh
+// trace id like a cache hit, and puts it in the read cache.
+func serveModelRead(w http.ResponseWriter, r *http.Request, tenantID, idStr string) bool {
+	m, ok, err := readMessage(r.Context(), tenantID, idStr)
+	if !ok {
+		return false
+	}
+	if err != nil {
+		problem.Write(w, r, http.StatusNotFound, problem.CodeNotFound, "id="+idStr)
+		return true
+	}
+	payload := map[string]any{"id": m.ID, "message": m.Message, "created_at": m.CreatedAt, "updated_at": m.UpdatedAt}
+	if m.Attachment != nil {
+		payload["attachment"] = m.Attachment.Map()
+	}
+	a := Ack{TraceID: uuid.NewString(), Status: "SUCCESS", Event: "MessageRead", Payload: payload, TenantID: tenantID}
+	putAck(a)
+	if reads != nil {
+		reads.Set(r.Context(), tenantID, strconv.FormatInt(m.ID, 10), a)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(a)
+	return true
+}
+
+// @Summary List messages
+// @Description Newest first, straight from the read model. Needs READ_MODEL_DSN; a replica may
+// @Description not show the latest writes yet.
+// @Tags messages
+// @Produce json
+// @Param limit query int false "Page size (default 20, max 100)"
+// @Param cursor query int false "next_cursor of the previous page"
+// @Param X-Tenant-ID header string false "Tenant (defaults to \"default\")"
+// @Success 200 {object} readmodel.Page
+// @Failure 400 {object} problem.Details "INVALID_PARAM"
+// @Failure 403 {object} problem.Details "UNKNOWN_TENANT"
+// @Failure 501 {object} problem.Details "NOT_IMPLEMENTED: no read model configured"
+// @Failure 500 {object} problem.Details "DB_ERROR"
+// @Failure 401 {object} problem.Details "INVALID_REQUEST or INVALID_TOKEN (when AUTH_JWT_ROUTES covers the route)"
+// @Security BearerAuth
+// @Router /messages [get]
+func listMessagesHandler(w http.ResponseWriter, r *http.Request) {
+	tid, ok := resolveTenant(w, r)
+	if !ok {
+		return
+	}
+	if readModel == nil {
+		problem.Write(w, r, http.StatusNotImplemented, problem.CodeNotImplemented, "listing messages needs READ_MODEL_DSN")
+		return
+	}
+	f, err := parseListFilter(r)
+	if err != nil {
+		problem.Write(w, r, http.StatusBadRequest, problem.CodeInvalidParam, err.Error())
+		return
+	}
+	f.TenantID = tid
+	page, err := readModel.List(r.Context(), f)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "read model list", "tenant_id", tid, "err", err)
+		problem.Write(w, r, http.StatusInternalServerError, problem.CodeDBError, "read model query failed")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(page)
+}
+
+func parseListFilter(r *http.Request) (readmodel.Filter, error) {
+	q := r.URL.Query()
+	var f readmodel.Filter
+	var err error
+	if v := q.Get("limit"); v != "" {
+		if f.Limit, err = strconv.Atoi(v); err != nil || f.Limit < 1 || f.Limit > readmodel.MaxLimit {
+			return f, errInvalidParam("limit")
+		}
+	}
+	if v := q.Get("cursor"); v != "" {
+		if f.Cursor, err = strconv.ParseInt(v, 10, 64); err != nil || f.Cursor < 1 {
+			return f, errInvalidParam("cursor")
+		}
+	}
+	return f, nil
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/IBM/sarama"
+	"github.com/felixge/httpsnoop"
+	"github.com/go-chi/chi/v5"
+
+	"github.com/slb-uk/rest-go-webservice/project/pkg/auth"
+	"github.com/slb-uk/rest-go-webservice/project/pkg/deployment"
+	"github.com/slb-uk/rest-go-webservice/project/pkg/observability"
+	"github.com/slb-uk/rest-go-webservice/project/pkg/problem"
+	"github.com/slb-uk/rest-go-webservice/project/pkg/tenant"
+)
+
+// newRouter maps the API onto its handlers. Every request is traced,
+// counted by its route pattern and, with ACCESS_LOG, logged. The /v1 group
+// adds JWT auth and the canary track; /messages/{id} rejects ids that are
+// not positive integers before any handler runs, and /admin also wants the
+// admin scope of a token. Unknown paths and methods are answered with
+// problem+json.
+func newRouter(producer sarama.SyncProducer, cmdTopic string, authCfg auth.Config, canaryPercent float64, accessLog bool) http.Handler {
+	r := chi.NewRouter()
+	r.Use(traced, withMetrics)
+	if accessLog {
+		r.Use(logRequests)
+	}
+	r.NotFound(func(w http.ResponseWriter, req *http.Request) {
+		problem.Write(w, req, http.StatusNotFound, problem.CodeNotFound, "no route for "+req.URL.Path)
+	})
+	r.MethodNotAllowed(func(w http.ResponseWriter, req *http.Request) {
+		route, allow := allowedMethods(r, req.URL.Path)
+		// chi records no pattern for a 405; name the route for routeOf
+		chi.RouteContext(req.Context()).RoutePatterns = []string{route}
+		problem.MethodNotAllowed(w, req, allow...)
+	})
+
+	r.Route("/v1", func(r chi.Router) {
+		r.Use(
+			func(next http.Handler) http.Handler { return auth.Middleware(authCfg, next) },
+			func(next http.Handler) http.Handler { return deployment.Middleware(canaryPercent, next) },
+		)
+
+		r.Get("/messages", listMessagesHandler)
+		r.Post("/messages", createMessageHandler(producer, cmdTopic))
+		r.Post("/messages:withQuota", createWithQuotaHandler(producer, cmdTopic))
+		r.Group(func(r chi.Router) {
+			r.Use(positiveIntParam("id"))
+			r.Get("/messages/{id}", getMessageHandler(producer, cmdTopic))
+			r.Put("/messages/{id}", updateMessageHandler(producer, cmdTopic))
+			r.Delete("/messages/{id}", deleteMessageHandler(producer, cmdTopic))
+			r.Get("/messages/{id}/attachment", attachmentHandler(producer, cmdTopic))
+		})
+
+		r.Get("/operations/stream", operationStreamHandler)
+		r.Get("/operations/{trace_id}", operationResultHandler())
+		r.Get("/operations/{trace_id}/events", operationEventsHandler)
+
+		r.Get("/audit", auditHandler(producer, cmdTopic))
+
+		r.Group(func(r chi.Router) {
+			r.Use(func(next http.Handler) http.Handler { return auth.RequireScope(authCfg.AdminScope, next) })
+			r.Post("/admin/messages:deleteByQuery", deleteByQueryHandler(producer, cmdTopic))
+			r.Post("/admin/messages:updateByQuery", updateByQueryHandler(producer, cmdTopic))
+		})
+	})
+	return r
+}
+
+// routeOf is the pattern of the route r matched, for span names and
+// metric labels; "other" when none did. It is only complete once the
+// router has served r.
+func routeOf(r *http.Request) string {
+	p := chi.RouteContext(r.Context()).RoutePattern()
+	// an unmatched path under a group ends at the group's mount wildcard
+	if p == "" || strings.HasSuffix(p, "*") {
+		return "other"
+	}
+	return p
+}
+
+// traced runs the rest of the chain in a server span named after the
+// matched route.
+func traced(next http.Handler) http.Handler {
+	return observability.HTTPHandler(next, "apisvc", routeOf)
+}
+
+// positiveIntParam answers 400 INVALID_PARAM unless the URL parameter
+// name is a positive integer.
+func positiveIntParam(name string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if n, err := strconv.ParseInt(chi.URLParam(r, name), 10, 64); err != nil || n <= 0 {
+				problem.Write(w, r, http.StatusBadRequest, problem.CodeInvalidParam, name+" must be a positive integer")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// allowedMethods returns the pattern of the route at path and the methods
+// it has handlers for, for the Allow header of a 405.
+func allowedMethods(mux *chi.Mux, path string) (route string, allow []string) {
+	for _, m := range []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete} {
+		if p := mux.Find(chi.NewRouteContext(), m, path); p != "" {
+			route, allow = p, append(allow, m)
+		}
+	}
+	return route, allow
+}
+
+// logRequests logs one record per request once it is served, with the
+// span's ids and the trace_id of the command it published, if any. 5xx
+// responses are logged at error level.
+func logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var operation string
+		r = r.WithContext(context.WithValue(r.Context(), operationKey{}, &operation))
+		m := httpsnoop.CaptureMetrics(next, w, r)
+		level := slog.LevelInfo
+		if m.Code >= 500 {
+			level = slog.LevelError
+		}
+		tid, _ := tenant.FromRequest(r)
+		attrs := []any{"method", r.Method, "path", r.URL.Path, "route", routeOf(r), "status", m.Code,
+			"bytes", m.Written, "duration_ms", m.Duration.Milliseconds(), "tenant_id", tid}
+		if operation != "" {
+			attrs = append(attrs, "trace_id", operation)
+		}
+		slog.Log(r.Context(), level, "http request", attrs...)
+	})
+}
+
+type operationKey struct{}
+
+// noteOperation tells the access log of the request in ctx which command
+// it published.
+func noteOperation(ctx context.Context, traceID string) {
+	if p, ok := ctx.Value(operationKey{}).(*string); ok {
+		*p = traceID
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strconv"
+
+	"github.com/IBM/sarama"
+
+	"github.com/slb-uk/rest-go-webservice/project/pkg/audit"
+	"github.com/slb-uk/rest-go-webservice/project/pkg/bulk"
+	"github.com/slb-uk/rest-go-webservice/project/pkg/repo"
+)
+
+// DeleteByQuery and UpdateByQuery, sent by apisvc's admin routes, change
+// every message of the tenant a bulk.Query matches. One transaction would
+// hold the locks of all of them for as long as it runs, so the matches are
+// counted once and then changed in id order, CONSUMER_BULK_CHUNK at a time,
+// each chunk in its own transaction together with its audit rows. A
+// PROGRESS ack follows every chunk, with processed, total and percent and
+// the ids the chunk changed, for apisvc's read cache. The SUCCESS ack comes
+// last, once the idempotency key is marked.
+//
+// A run that is cut short has committed the chunks before it, and running
+// the command again does the rest: deleted messages no longer match, and an
+// update skips the ones that already have the new text. A transient error
+// goes down the retry ladder like any command; a run interrupted by a
+// rebalance is left unmarked and unanswered for the partition's next owner.
+// While it runs, it holds up the other commands of its worker.
+
+var bulkCommands = map[string]bool{"DeleteByQuery": true, "UpdateByQuery": true}
+
+// bulk runs a DeleteByQuery or UpdateByQuery command, sending its PROGRESS
+// acks, and returns the final ack, or the original one when the command's
+// key was processed already.
+func (h *consumerHandler) bulk(ctx context.Context, msg *sarama.ConsumerMessage, l *slog.Logger, cmd Command, tid string) (Ack, *Ack, error) {
+	ack := Ack{TraceID: cmd.TraceID, Status: "SUCCESS", Payload: map[string]any{}, TenantID: tid}
+	key := string(msg.Key)
+	if key == "" {
+		key = cmd.TraceID
+	}
+	var prev *Ack
+	var processed bool
+	err := withTx(ctx, h.repo, cmd.Command, func(tx repo.Tx) (err error) {
+		prev, processed, err = checkIdempotent(ctx, tx, tid, key)
+		return err
+	})
+	if err != nil || processed {
+		return ack, prev, err
+	}
+
+	event, step := "MessagesDeleted", "DeleteMessagesByQuery"
+	if cmd.Command == "UpdateByQuery" {
+		event, step = "MessagesUpdated", "UpdateMessagesByQuery"
+	}
+	ack.Event = event
+	var req bulk.UpdateRequest
+	b, err := json.Marshal(cmd.Payload)
+	if err == nil {
+		err = json.Unmarshal(b, &req)
+	}
+	if err == nil {
+		err = req.Query.Validate()
+	}
+	if err != nil {
+		ack.Status = "FAILURE"
+		ack.Error = &struct{ Code, Detail string }{"BAD_REQUEST", err.Error()}
+		return ack, nil, h.bulkDone(ctx, cmd, tid, key, step, ack)
+	}
+	q := req.Query
+	q.TenantID = tid
+	if cmd.Command == "UpdateByQuery" {
+		q.Except = &req.Message
+	}
+
+	total, last, err := h.repo.CountMessages(ctx, q)
+	if err != nil {
+		return ack, nil, err
+	}
+	l.InfoContext(ctx, "bulk command", "matched", total, "last_id", last)
+	var done, after int64
+	for after < last {
+		var rows []bulk.Row
+		err := withTx(ctx, h.repo, cmd.Command, func(tx repo.Tx) (err error) {
+			if rows, err = tx.ChunkMessages(ctx, q, after, last, h.bulkChunk); err != nil || len(rows) == 0 {
+				return err
+			}
+			return applyChunk(ctx, tx, cmd, tid, rows, req.Message)
+		})
+		if err != nil {
+			return ack, nil, err
+		}
+		if len(rows) == 0 {
+			break
+		}
+		after = rows[len(rows)-1].ID
+		done += int64(len(rows))
+		bulkMessagesTotal.WithLabelValues(cmd.Command).Add(float64(len(rows)))
+		ids := bulkIDs(rows)
+		h.sendAck(ctx, msg, l, Ack{TraceID: cmd.TraceID, Status: "PROGRESS", Event: event, TenantID: tid,
+			Payload: map[string]any{"processed": done, "total": total, "percent": bulk.Percent(done, total), "ids": ids}})
+	}
+	ack.Payload = map[string]any{"processed": done, "total": total, "percent": 100}
+	return ack, nil, h.bulkDone(ctx, cmd, tid, key, step, ack)
+}
+
+// applyChunk deletes or updates rows in tx, with an audit row each.
+func applyChunk(ctx context.Context, tx repo.Tx, cmd Command, tid string, rows []bulk.Row, message string) error {
+	ids := bulkIDs(rows)
+	var before map[int64]map[string]any
+	if cmd.Command == "DeleteByQuery" {
+		// the audit rows name the attachments that go with the messages
+		before = make(map[int64]map[string]any, len(rows))
+		for _, r := range rows {
+			fields, err := storedFields(ctx, tx, tid, repo.Message{ID: r.ID, Message: r.Message})
+			if err != nil {
+				return err
+			}
+			before[r.ID] = fields
+		}
+		// the blobs themselves are left for the storage lifecycle policy
+		if err := tx.DeleteMessages(ctx, tid, ids); err != nil {
+			return err
+		}
+	} else if err := tx.UpdateMessages(ctx, tid, ids, message); err != nil {
+		return err
+	}
+
+	actor := audit.FromMetadata(cmd.Metadata)
+	for _, r := range rows {
+		changes := audit.Diff(map[string]any{"message": r.Message}, map[string]any{"message": message})
+		if before != nil {
+			changes = audit.Diff(before[r.ID], nil)
+		}
+		if err := tx.RecordAudit(ctx, audit.Entry{TenantID: tid, Resource: "Message", ResourceID: strconv.FormatInt(r.ID, 10),
+			Command: cmd.Command, Actor: actor, TraceID: cmd.TraceID, Status: "SUCCESS", Changes: changes}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// bulkDone marks the command's key with its final ack and logs the saga
+// step.
+func (h *consumerHandler) bulkDone(ctx context.Context, cmd Command, tid, key, step string, ack Ack) error {
+	return withTx(ctx, h.repo, cmd.Command, func(tx repo.Tx) error {
+		code, detail := "", ""
+		if ack.Error != nil {
+			code, detail = ack.Error.Code, ack.Error.Detail
+		}
+		logSaga(ctx, tx, tid, cmd.TraceID, step, ack.Status, code, detail)
+		return markIdempotent(ctx, tx, tid, key, ack)
+	})
+}
+
+func bulkIDs(rows []bulk.Row) []int64 {
+	ids := make([]int64, len(rows))
+	for i, r := range rows {
+		ids[i] = r.ID
+	}
+	return ids
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/IBM/sarama"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	oteltrace "go.opentelemetry.io/otel/trace"
+
+	kafkahelper "github.com/slb-uk/rest-go-webservice/project/pkg/kafka"
+)
+
+// A command that can never be processed (an unknown content type, a value
+// that does not decode, one that breaks schema/command.json in
+// pkg/contracts, or an invalid tenant) is moved to the dead-letter topic
+// (KAFKA_TOPIC_DLQ) instead of being dropped. So is one whose transaction
+// fails for good, with reason processing: on a database error that is not
+// transient, or after the last retry topic (see retry.go).
+// The record keeps its key, value and headers and gets the dlq-* headers of
+// pkg/kafka (reason, error, attempts, original topic, partition and
+// offset), so it can be inspected and, once fixed, replayed with
+// cmd/dlqreplay.
+
+// reject dead-letters msg for reason, ending its span, and reports whether
+// it may be marked. A record that cannot be dead-lettered is logged and left
+// unmarked, but the group still commits past it once a later record is
+// marked.
+func (h *consumerHandler) reject(ctx context.Context, msg *sarama.ConsumerMessage,
+	span oteltrace.Span, l *slog.Logger, reason string, err error) bool {
+	defer span.End()
+	l.WarnContext(ctx, "bad command", "reason", reason, "err", err)
+	badCommandsTotal.Inc()
+	span.SetStatus(codes.Error, err.Error())
+	return h.deadLetter(ctx, msg, span, l, reason, err, 1)
+}
+
+// deadLetter produces msg to the dead-letter topic and reports whether it
+// got there.
+func (h *consumerHandler) deadLetter(ctx context.Context, msg *sarama.ConsumerMessage,
+	span oteltrace.Span, l *slog.Logger, reason string, err error, attempts int) bool {
+	span.SetAttributes(attribute.String("app.dlq.reason", reason), attribute.Int("app.dlq.attempts", attempts))
+	out := kafkahelper.DeadLetter{Topic: h.dlqTopic, Reason: reason, Err: err, Attempts: attempts,
+		Group: h.group, Time: time.Now()}.Message(msg)
+	_, pspan := kafkahelper.StartProduce(ctx, out, attribute.String("app.dlq.reason", reason))
+	partition, offset, perr := h.producer.SendMessage(out)
+	kafkahelper.EndProduce(pspan, partition, offset, perr)
+	if perr != nil {
+		l.ErrorContext(ctx, "dead-letter produce", "dlq", h.dlqTopic, "err", perr)
+		deadLetterFailuresTotal.Inc()
+		return false
+	}
+	deadLetteredTotal.WithLabelValues(reason).Inc()
+	return true
+}
@@ -1,259 +1,660 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
 	"strconv"
 	"time"
 
 	"github.com/IBM/sarama"
 	_ "github.com/go-sql-driver/mysql"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	oteltrace "go.opentelemetry.io/otel/trace"
+
+	"github.com/slb-uk/rest-go-webservice/project/pkg/audit"
+	"github.com/slb-uk/rest-go-webservice/project/pkg/blob"
+	"github.com/slb-uk/rest-go-webservice/project/pkg/config"
+	"github.com/slb-uk/rest-go-webservice/project/pkg/contracts"
+	"github.com/slb-uk/rest-go-webservice/project/pkg/deployment"
+	kafkahelper "github.com/slb-uk/rest-go-webservice/project/pkg/kafka"
+	"github.com/slb-uk/rest-go-webservice/project/pkg/migrate"
+	"github.com/slb-uk/rest-go-webservice/project/pkg/observability"
+	"github.com/slb-uk/rest-go-webservice/project/pkg/repo"
+	"github.com/slb-uk/rest-go-webservice/project/pkg/startup"
+	"github.com/slb-uk/rest-go-webservice/project/pkg/tenant"
+	"github.com/slb-uk/rest-go-webservice/project/pkg/trace"
 )
 
+var tracer = observability.Tracer("consumersvc")
+
 type Command struct {
 	TraceID  string                 `json:"trace_id"`
 	Command  string                 `json:"command"`
 	Resource string                 `json:"resource"`
 	Payload  map[string]any         `json:"payload"`
+	Metadata map[string]any         `json:"metadata,omitempty"`
 }
 
+// Validate checks cmd against the command contract.
+func (cmd Command) Validate() error { return contracts.ValidateCommand(cmd) }
+
 type Ack struct {
-	TraceID string                 `json:"trace_id"`
-	Status  string                 `json:"status"`
-	Event   string                 `json:"event"`
-	Payload map[string]any         `json:"payload,omitempty"`
-	Error   *struct{ Code, Detail string } `json:"error,omitempty"`
+	TraceID  string                 `json:"trace_id"`
+	Status   string                 `json:"status"`
+	Event    string                 `json:"event"`
+	Payload  map[string]any         `json:"payload,omitempty"`
+	Error    *struct{ Code, Detail string } `json:"error,omitempty"`
+	Replayed bool                   `json:"replayed,omitempty"`
+	TenantID string                 `json:"tenant_id,omitempty"`
 }
 
 func main() {
-	brokers := []string{getenv("KAFKA_BROKERS", "kafka:9092")}
-	cmdTopic := getenv("KAFKA_TOPIC_COMMANDS", "messages.commands")
-	acksTopic := getenv("KAFKA_TOPIC_ACKS", "messages.acks")
-	dsn := getenv("MYSQL_DSN", "root:root@tcp(mysql:3306)/app?parseTime=true")
+	var conf config.Consumer
+	if err := config.Load(&conf, "consumersvc", os.Args[1:]); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			os.Exit(0)
+		}
+		observability.Fatal("config", "err", err)
+	}
+	defer observability.MustStart(conf.Observability.Config("consumersvc"))()
+	brokers := conf.Kafka.Brokers
+	cmdTopic, acksTopic := conf.Kafka.CommandsTopic, conf.Kafka.AcksTopic
+	dsn := conf.MySQLDSN
+	tenantTopics := conf.Tenancy.TopicPrefix
+	tenants := conf.Tenancy.IDs()
+	ackCodec, _ := contracts.ByName(conf.Kafka.Codec) // validated by Load
+	track, routing := conf.Track, conf.CanaryRouting
+
+	// probes come up first: /readyz fails until Kafka and MySQL are
+	// connected, and /healthz stays ok while they are being waited for
+	probes := conf.Health.Checker()
+	started := probes.Starting()
+	probes.Serve(conf.HealthAddr)
+	ctx, policy := context.Background(), conf.Startup.Policy()
 
 	db, err := sql.Open("mysql", dsn)
 	if err != nil {
-		log.Fatal(err)
+		observability.Fatal("mysql", "err", err)
 	}
 	defer db.Close()
 
-	if err := db.Ping(); err != nil {
-		log.Fatal("db ping:", err)
+	if err := startup.Retry(ctx, policy, "mysql", db.PingContext); err != nil {
+		observability.Fatal("mysql", "err", err)
 	}
 
-	cfg := sarama.NewConfig()
-	cfg.Consumer.Offsets.Initial = sarama.OffsetOldest
-	cfg.Version = sarama.V2_6_0_0
-	cfg.Consumer.Return.Errors = true
-	cfg.Producer.Return.Successes = true
-	cfg.Producer.Idempotent = true
+	// the schema is brought up to this build before any command is
+	// consumed; replicas starting together wait for each other's lock
+	if conf.Migrate.OnStart {
+		st, err := migrate.Up(dsn, uint(conf.Migrate.Baseline))
+		if err != nil {
+			observability.Fatal("migrate", "err", err, "version", st.Version, "dirty", st.Dirty)
+		}
+		slog.Info("migrate: schema up to date", "version", st.Version)
+	}
 
-	consumerGroup, err := sarama.NewConsumerGroup(brokers, "message-worker", cfg)
+	group := deployment.GroupID("message-worker", track)
+	consumerGroup, err := startup.Connect(ctx, policy, "kafka consumer group", func(context.Context) (sarama.ConsumerGroup, error) {
+		return kafkahelper.NewConsumerGroup(brokers, group, conf.Kafka.Options())
+	})
 	if err != nil {
-		log.Fatal(err)
+		observability.Fatal("kafka consumer group", "err", err)
 	}
 	defer consumerGroup.Close()
 
-	producer, err := sarama.NewSyncProducer(brokers, cfg)
+	producer, err := startup.Connect(ctx, policy, "kafka producer", func(context.Context) (sarama.SyncProducer, error) {
+		return kafkahelper.NewIdempotentProducer(brokers, conf.Kafka.Options())
+	})
 	if err != nil {
-		log.Fatal(err)
+		observability.Fatal("kafka producer", "err", err)
 	}
 	defer producer.Close()
 
-	handler := &consumerHandler{db: db, producer: producer, ackTopic: acksTopic}
+	// acks are framed with the schema id of contracts.proto, registered
+	// under the subject of every ack topic
+	if reg := conf.Kafka.Registry(); reg != nil {
+		subjects := conf.Kafka.SchemaRegistry.Subjects
+		rc, err := startup.Connect(ctx, policy, "schema registry", func(ctx context.Context) (contracts.RegistryCodec, error) {
+			return contracts.RegisterAcks(ctx, reg, subjects, tenant.Topics(tenantTopics, tenants, acksTopic))
+		})
+		if err != nil {
+			observability.Fatal("schema registry", "err", err)
+		}
+		ackCodec = rc
+		slog.Info("schema registry: acks registered", "schema_id", rc.SchemaID, "subjects", subjects)
+	}
+
+	handler := &consumerHandler{repo: repo.NewMySQL(db), producer: producer, ackTopic: acksTopic, dlqTopic: conf.DLQTopic, tenantTopics: tenantTopics, ackCodec: ackCodec,
+		track: track, filterTrack: routing == deployment.RoutingHeader, group: group, workers: conf.Workers, maxInFlight: conf.MaxInFlight,
+		retryStages: kafkahelper.RetryStages(deployment.Topic(routing, track, cmdTopic), conf.RetryDelays), bulkChunk: conf.BulkChunk}
+	if conf.Verify.Enabled {
+		if handler.verify, err = newVerifier(conf.Verify.Log, conf.Verify.Instance); err != nil {
+			observability.Fatal("verify log", "err", err)
+		}
+	}
+
+	topics := tenant.Topics(tenantTopics, tenants, deployment.Topic(routing, track, cmdTopic))
+	for _, st := range handler.retryStages {
+		topics = append(topics, st.Topic)
+	}
+
+	probes.Add("mysql", db.PingContext)
+	probes.AddProbe("migrations", migrate.Check(db))
+	kafkaHealth, err := startup.Connect(ctx, policy, "kafka health client", func(context.Context) (sarama.Client, error) {
+		return kafkahelper.NewHealthClient(brokers, conf.Kafka.Options())
+	})
+	if err != nil {
+		observability.Fatal("kafka health client", "err", err)
+	}
+	defer kafkaHealth.Close()
+	probes.Add("kafka", kafkahelper.HealthCheck(kafkaHealth, append(topics, tenant.Topics(tenantTopics, tenants, acksTopic)...)...))
+	started()
 
-	log.Println("consumer running…")
+	// warns about idle members and lag imbalance; see scaling.go
+	if guard, err := newScalingGuard(&conf, group, topics); err != nil {
+		slog.Warn("scaling guard disabled", "err", err)
+	} else {
+		go guard.run(30 * time.Second)
+	}
+
+	deploymentInfo.WithLabelValues(track, routing).Set(1)
+	slog.Info("consumer running", "group", group, "track", track, "topics", topics, "workers", conf.Workers)
 	for {
-		if err := consumerGroup.Consume(nil, []string{cmdTopic}, handler); err != nil {
-			log.Println("consume error:", err)
+		if err := consumerGroup.Consume(nil, topics, handler); err != nil {
+			slog.Error("consume", "err", err)
 			time.Sleep(time.Second)
 		}
 	}
 }
 
 type consumerHandler struct {
-	db       *sql.DB
+	// repo runs the command's queries; see pkg/repo
+	repo     repo.Repository
 	producer sarama.SyncProducer
 	ackTopic string
+	// dlqTopic (KAFKA_TOPIC_DLQ) gets the commands that cannot be
+	// processed; see deadletter.go
+	dlqTopic string
+	// tenantTopics publishes acks to "<tenant>.<ackTopic>" instead of ackTopic
+	tenantTopics bool
+	// ackCodec (KAFKA_CODEC) encodes acks for commands that carry no accept
+	// header, i.e. ones from an apisvc that predates codec negotiation
+	ackCodec contracts.Codec
+	verify   *verifier // nil unless VERIFY_MODE=true
+	// track (DEPLOYMENT_TRACK) is stable or canary; with header routing
+	// (filterTrack) commands tagged for the other track are skipped
+	track       string
+	filterTrack bool
+	group       string // consumer group, for spans
+	// workers (CONSUMER_WORKERS) process the commands of one partition at
+	// once, at most maxInFlight (CONSUMER_MAX_IN_FLIGHT) ahead of the last
+	// marked offset; see pool.go
+	workers     int
+	maxInFlight int
+	// retryStages (CONSUMER_RETRY_DELAYS) take commands that failed on a
+	// transient error; see retry.go
+	retryStages []kafkahelper.RetryStage
+	// bulkChunk (CONSUMER_BULK_CHUNK) is how many messages one transaction
+	// of a DeleteByQuery or UpdateByQuery changes; see bulk.go
+	bulkChunk int
+}
+
+func (h *consumerHandler) Setup(sess sarama.ConsumerGroupSession) error {
+	if h.verify != nil {
+		h.verify.claims(sess)
+	}
+	return nil
 }
 
-func (h *consumerHandler) Setup(_ sarama.ConsumerGroupSession) error   { return nil }
 func (h *consumerHandler) Cleanup(_ sarama.ConsumerGroupSession) error { return nil }
 
 func (h *consumerHandler) ConsumeClaim(sess sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	p := newClaimPool(sess, h.workers, h.maxInFlight, h.process)
 	for msg := range claim.Messages() {
-		var cmd Command
-		if err := json.Unmarshal(msg.Value, &cmd); err != nil {
-			log.Println("bad command:", err)
-			continue
+		if !p.submit(msg) {
+			break
+		}
+	}
+	p.wait()
+	return nil
+}
+
+// process handles one command and reports whether its offset may be
+// committed; the claim pool marks it once every earlier one is done too.
+func (h *consumerHandler) process(ctx context.Context, msg *sarama.ConsumerMessage) bool {
+	if !due(ctx, msg) {
+		return false // revoked while waiting; the next owner waits the rest
+	}
+	start := time.Now()
+	// a child of apisvc's publish span: the command's trace continues here
+	ctx, span := kafkahelper.StartConsume(ctx, msg, h.group)
+	// every record logged for this message names it; trace_id and
+	// command are added once the command is decoded
+	l := slog.With("topic", msg.Topic, "partition", msg.Partition, "offset", msg.Offset)
+	if h.filterTrack && deployment.FromHeader(kafkahelper.Header(msg.Headers, deployment.MetadataKey)) != h.track {
+		// the other track's group handles it
+		otherTrackTotal.Inc()
+		span.SetAttributes(attribute.Bool("app.skipped", true))
+		span.End()
+		return true
+	}
+	cmdCodec, cerr := contracts.ForContentType(kafkahelper.Header(msg.Headers, contracts.HeaderContentType))
+	if cerr != nil {
+		return h.reject(ctx, msg, span, l, "content-type", cerr)
+	}
+	var cmd Command
+	if err := cmdCodec.DecodeCommand(msg.Value, &cmd); err != nil {
+		return h.reject(ctx, msg, span, l, "decode", err)
+	}
+	tid := tenant.FromMetadata(cmd.Metadata)
+	ctx = trace.WithTraceID(ctx, cmd.TraceID)
+	l = l.With("command", cmd.Command, "tenant_id", tid)
+	span.SetAttributes(attribute.String("app.operation.trace_id", cmd.TraceID), attribute.String("app.command", cmd.Command),
+		attribute.String("app.tenant_id", tid))
+	if err := cmd.Validate(); err != nil {
+		return h.reject(ctx, msg, span, l, "schema", err)
+	}
+	if err := tenant.Validate(tid); err != nil {
+		return h.reject(ctx, msg, span, l, "tenant", err)
+	}
+	if bulkCommands[cmd.Command] || sagaCommands[cmd.Command] {
+		// chunked transactions with PROGRESS acks, see bulk.go, or a saga
+		// of transactions undone when a later one fails, see saga.go
+		run := h.bulk
+		if sagaCommands[cmd.Command] {
+			run = h.saga
 		}
+		ack, replay, err := run(ctx, msg, l, cmd, tid)
+		if err != nil && ctx.Err() != nil {
+			// revoked mid-run: the partition's next owner does the rest
+			l.InfoContext(ctx, "command interrupted", "err", err)
+			span.End()
+			return false
+		}
+		return h.finish(ctx, msg, span, l, cmd, tid, start, ack, replay, err)
+	}
 
-		status := "SUCCESS"
-		event := ""
-		payload := map[string]any{}
-		var e *struct{ Code, Detail string }
+	status := "SUCCESS"
+	event := ""
+	payload := map[string]any{}
+	var e *struct{ Code, Detail string }
+	var replay *Ack
+	actor := audit.FromMetadata(cmd.Metadata)
 
-		err := withTx(h.db, func(tx *sql.Tx) error {
-			key := string(msg.Key)
-			if key == "" {
-				key = cmd.TraceID
-			}
-			processed, err := checkIdempotent(tx, key)
+	err := withTx(ctx, h.repo, cmd.Command, func(tx repo.Tx) error {
+		key := string(msg.Key)
+		if key == "" {
+			key = cmd.TraceID
+		}
+		prev, processed, err := checkIdempotent(ctx, tx, tid, key)
+		if err != nil {
+			return err
+		}
+		if processed {
+			// already applied: answer with the original result instead of
+			// staying silent, otherwise a retried request never gets an ack
+			replay = prev
+			return nil
+		}
+		// resourceID and changes feed the audit row of write commands
+		var resourceID string
+		var changes map[string]audit.Change
+
+		switch cmd.Command {
+		case "Create":
+			m, _ := cmd.Payload["message"].(string)
+			id, err := tx.InsertMessage(ctx, tid, m)
 			if err != nil {
+				if transient(err) {
+					return err // retried; see retry.go
+				}
+				status = "FAILURE"
+				e = &struct{ Code, Detail string }{"DB_ERROR", err.Error()}
+				logSaga(ctx, tx, tid, cmd.TraceID, "CreateMessage", "FAILURE", "DB_ERROR", err.Error())
+				return nil
+			}
+			if err := saveAttachment(ctx, tx, tid, id, cmd.Payload, payload); err != nil {
 				return err
 			}
-			if processed {
+			payload["id"] = id
+			payload["message"] = m
+			resourceID = strconv.FormatInt(id, 10)
+			changes = audit.Diff(nil, messageFields(payload))
+			event = "MessageCreated"
+			logSaga(ctx, tx, tid, cmd.TraceID, "CreateMessage", "SUCCESS", "", "")
+		case "Read":
+			idStr, _ := cmd.Payload["id"].(string)
+			id, _ := strconv.ParseInt(idStr, 10, 64)
+			m, err := tx.GetMessage(ctx, tid, id)
+			if err != nil {
+				status = "FAILURE"
+				e = &struct{ Code, Detail string }{"NOT_FOUND", fmt.Sprintf("id=%d", id)}
+				logSaga(ctx, tx, tid, cmd.TraceID, "ReadMessage", "FAILURE", "NOT_FOUND", e.Detail)
 				return nil
 			}
-
-			switch cmd.Command {
-			case "Create":
-				m, _ := cmd.Payload["message"].(string)
-				res, err := tx.Exec("INSERT INTO messages(message) VALUES(?)", m)
-				if err != nil {
-					status = "FAILURE"
-					e = &struct{ Code, Detail string }{"DB_ERROR", err.Error()}
-					logSaga(tx, cmd.TraceID, "CreateMessage", "FAILURE", "DB_ERROR", err.Error())
-					return nil
-				}
-				id, _ := res.LastInsertId()
-				payload["id"] = id
-				payload["message"] = m
-				event = "MessageCreated"
-				logSaga(tx, cmd.TraceID, "CreateMessage", "SUCCESS", "", "")
-			case "Read":
-				idStr, _ := cmd.Payload["id"].(string)
-				id, _ := strconv.ParseInt(idStr, 10, 64)
-				row := tx.QueryRow("SELECT id, message FROM messages WHERE id=?", id)
-				var mid int64
-				var m string
-				if err := row.Scan(&mid, &m); err != nil {
-					status = "FAILURE"
-					e = &struct{ Code, Detail string }{"NOT_FOUND", fmt.Sprintf("id=%d", id)}
-					logSaga(tx, cmd.TraceID, "ReadMessage", "FAILURE", "NOT_FOUND", e.Detail)
-					return nil
-				}
-				payload["id"] = mid
-				payload["message"] = m
-				event = "MessageRead"
-				logSaga(tx, cmd.TraceID, "ReadMessage", "SUCCESS", "", "")
-			case "Update":
-				idStr, _ := cmd.Payload["id"].(string)
-				id, _ := strconv.ParseInt(idStr, 10, 64)
-				m, _ := cmd.Payload["message"].(string)
-				res, err := tx.Exec("UPDATE messages SET message=? WHERE id=?", m, id)
-				if err != nil {
-					status = "FAILURE"
-					e = &struct{ Code, Detail string }{"DB_ERROR", err.Error()}
-					logSaga(tx, cmd.TraceID, "UpdateMessage", "FAILURE", "DB_ERROR", err.Error())
-					return nil
-				}
-				affected, _ := res.RowsAffected()
-				if affected == 0 {
-					status = "FAILURE"
-					e = &struct{ Code, Detail string }{"NOT_FOUND", fmt.Sprintf("id=%d", id)}
-					logSaga(tx, cmd.TraceID, "UpdateMessage", "FAILURE", "NOT_FOUND", e.Detail)
-					return nil
-				}
-				payload["id"] = id
-				payload["message"] = m
-				event = "MessageUpdated"
-				logSaga(tx, cmd.TraceID, "UpdateMessage", "SUCCESS", "", "")
-			case "Delete":
-				idStr, _ := cmd.Payload["id"].(string)
-				id, _ := strconv.ParseInt(idStr, 10, 64)
-				res, err := tx.Exec("DELETE FROM messages WHERE id=?", id)
-				if err != nil {
-					status = "FAILURE"
-					e = &struct{ Code, Detail string }{"DB_ERROR", err.Error()}
-					logSaga(tx, cmd.TraceID, "DeleteMessage", "FAILURE", "DB_ERROR", err.Error())
-					return nil
+			if att, err := tx.GetAttachment(ctx, tid, m.ID); err != nil {
+				return err
+			} else if att != nil {
+				payload["attachment"] = att.Map()
+			}
+			payload["id"] = m.ID
+			payload["message"] = m.Message
+			event = "MessageRead"
+			logSaga(ctx, tx, tid, cmd.TraceID, "ReadMessage", "SUCCESS", "", "")
+		case "Update":
+			idStr, _ := cmd.Payload["id"].(string)
+			id, _ := strconv.ParseInt(idStr, 10, 64)
+			m, _ := cmd.Payload["message"].(string)
+			resourceID = strconv.FormatInt(id, 10)
+			before, err := loadMessageFields(ctx, tx, tid, id)
+			if err != nil {
+				return err
+			}
+			if err := tx.UpdateMessage(ctx, tid, id, m); errors.Is(err, repo.ErrNotFound) {
+				status = "FAILURE"
+				e = &struct{ Code, Detail string }{"NOT_FOUND", fmt.Sprintf("id=%d", id)}
+				logSaga(ctx, tx, tid, cmd.TraceID, "UpdateMessage", "FAILURE", "NOT_FOUND", e.Detail)
+				return nil
+			} else if err != nil {
+				if transient(err) {
+					return err // retried; see retry.go
 				}
-				affected, _ := res.RowsAffected()
-				if affected == 0 {
-					status = "FAILURE"
-					e = &struct{ Code, Detail string }{"NOT_FOUND", fmt.Sprintf("id=%d", id)}
-					logSaga(tx, cmd.TraceID, "DeleteMessage", "FAILURE", "NOT_FOUND", e.Detail)
-					return nil
+				status = "FAILURE"
+				e = &struct{ Code, Detail string }{"DB_ERROR", err.Error()}
+				logSaga(ctx, tx, tid, cmd.TraceID, "UpdateMessage", "FAILURE", "DB_ERROR", err.Error())
+				return nil
+			}
+			if err := saveAttachment(ctx, tx, tid, id, cmd.Payload, payload); err != nil {
+				return err
+			}
+			payload["id"] = id
+			payload["message"] = m
+			after := messageFields(payload)
+			if _, ok := after["attachment"]; !ok && before["attachment"] != nil {
+				after["attachment"] = before["attachment"] // kept as it was
+			}
+			changes = audit.Diff(before, after)
+			event = "MessageUpdated"
+			logSaga(ctx, tx, tid, cmd.TraceID, "UpdateMessage", "SUCCESS", "", "")
+		case "Delete":
+			idStr, _ := cmd.Payload["id"].(string)
+			id, _ := strconv.ParseInt(idStr, 10, 64)
+			resourceID = strconv.FormatInt(id, 10)
+			before, err := loadMessageFields(ctx, tx, tid, id)
+			if err != nil {
+				return err
+			}
+			// the blob itself is left for the storage lifecycle policy
+			if err := tx.DeleteMessage(ctx, tid, id); errors.Is(err, repo.ErrNotFound) {
+				status = "FAILURE"
+				e = &struct{ Code, Detail string }{"NOT_FOUND", fmt.Sprintf("id=%d", id)}
+				logSaga(ctx, tx, tid, cmd.TraceID, "DeleteMessage", "FAILURE", "NOT_FOUND", e.Detail)
+				return nil
+			} else if err != nil {
+				if transient(err) {
+					return err // retried; see retry.go
 				}
-				payload["id"] = id
-				event = "MessageDeleted"
-				logSaga(tx, cmd.TraceID, "DeleteMessage", "SUCCESS", "", "")
-			default:
 				status = "FAILURE"
-				e = &struct{ Code, Detail string }{"UNSUPPORTED", "unknown command"}
+				e = &struct{ Code, Detail string }{"DB_ERROR", err.Error()}
+				logSaga(ctx, tx, tid, cmd.TraceID, "DeleteMessage", "FAILURE", "DB_ERROR", err.Error())
+				return nil
 			}
-
-			return markIdempotent(tx, key, cmd.TraceID, status)
-		})
-
-		if err != nil {
-			log.Println("tx error:", err)
+			payload["id"] = id
+			changes = audit.Diff(before, nil)
+			event = "MessageDeleted"
+			logSaga(ctx, tx, tid, cmd.TraceID, "DeleteMessage", "SUCCESS", "", "")
+		case "QueryAudit":
+			var f audit.Filter
+			if b, err := json.Marshal(cmd.Payload); err != nil || json.Unmarshal(b, &f) != nil {
+				status = "FAILURE"
+				e = &struct{ Code, Detail string }{"BAD_REQUEST", "invalid audit filter"}
+				break
+			}
+			f.TenantID = tid
+			page, err := tx.ListAudit(ctx, f)
+			if err != nil {
+				return err
+			}
+			payload["entries"] = page.Entries
+			if page.NextCursor > 0 {
+				payload["next_cursor"] = page.NextCursor
+			}
+			event = "AuditQueried"
+		default:
 			status = "FAILURE"
-			event = "Error"
-			e = &struct{ Code, Detail string }{"INTERNAL", err.Error()}
+			e = &struct{ Code, Detail string }{"UNSUPPORTED", "unknown command"}
 		}
 
-		ack := Ack{TraceID: cmd.TraceID, Status: status, Event: event, Payload: payload, Error: e}
-		b, _ := json.Marshal(ack)
-		ackMsg := &sarama.ProducerMessage{
-		    Topic: h.ackTopic,
-			Key:   sarama.ByteEncoder(msg.Key), // still using the consumer msg's key
-			Value: sarama.ByteEncoder(b),
+		switch cmd.Command {
+		case "Create", "Update", "Delete":
+			entry := audit.Entry{TenantID: tid, Resource: "Message", ResourceID: resourceID, Command: cmd.Command,
+				Actor: actor, TraceID: cmd.TraceID, Status: status, Changes: changes}
+			if e != nil {
+				entry.ErrorCode = e.Code
+			}
+			if err := tx.RecordAudit(ctx, entry); err != nil {
+				return err
+			}
 		}
 
-		if _, _, err := h.producer.SendMessage(ackMsg); err != nil {
-			log.Println("ack produce:", err)
+		return markIdempotent(ctx, tx, tid, key, Ack{TraceID: cmd.TraceID, Status: status, Event: event, Payload: payload, Error: e, TenantID: tid})
+	})
+	return h.finish(ctx, msg, span, l, cmd, tid, start,
+		Ack{TraceID: cmd.TraceID, Status: status, Event: event, Payload: payload, Error: e, TenantID: tid}, replay, err)
+}
+
+// finish sends ack, the result of cmd, or replay, the original result of
+// an idempotent retry. After err the command goes down the retry ladder
+// instead, or is answered INTERNAL and dead-lettered. It ends the span and
+// reports whether the offset may be committed.
+func (h *consumerHandler) finish(ctx context.Context, msg *sarama.ConsumerMessage, span oteltrace.Span, l *slog.Logger,
+	cmd Command, tid string, start time.Time, ack Ack, replay *Ack, err error) bool {
+	mark := true
+	if err != nil {
+		// a transient error (a lost connection, a deadlock) sends the
+		// command down the retry ladder; the client gets no ack until it
+		// is through, or has fallen off the end into the dead-letter topic
+		attempt := kafkahelper.RetryAttempt(msg) + 1
+		if transient(err) && attempt <= len(h.retryStages) && ctx.Err() == nil {
+			if h.retry(ctx, msg, span, l, h.retryStages[attempt-1], attempt, err) {
+				span.End()
+				return true
+			}
 		}
+		l.ErrorContext(ctx, "tx error", "attempts", attempt, "err", err)
+		ack.Status = "FAILURE"
+		ack.Event = "Error"
+		ack.Error = &struct{ Code, Detail string }{"INTERNAL", err.Error()}
+		replay = nil
+		// the client gets the INTERNAL ack; the command waits on the
+		// dead-letter topic for a replay, unless the partition was revoked
+		// meanwhile and its next owner tries it again
+		mark = ctx.Err() == nil && h.deadLetter(ctx, msg, span, l, "processing", err, attempt)
+	}
 
-		sess.MarkMessage(msg, "")
+	if replay != nil {
+		ack = *replay
+		ack.TraceID = cmd.TraceID // the retry is tracked under its own trace id
+		ack.Replayed = true
+		ack.TenantID = tid
+		l.InfoContext(ctx, "idempotent replay", "key", string(msg.Key))
 	}
-	return nil
+	span.SetAttributes(attribute.String("app.ack.status", ack.Status), attribute.Bool("app.ack.replayed", ack.Replayed))
+	if ack.Error != nil {
+		span.SetAttributes(attribute.String("app.ack.error_code", ack.Error.Code))
+		if ack.Error.Code == "DB_ERROR" || ack.Error.Code == "INTERNAL" {
+			span.SetStatus(codes.Error, ack.Error.Detail)
+		}
+	}
+	h.sendAck(ctx, msg, l, ack)
+	observeCommand(ack, cmd.Command, tid, start)
+	done := []any{"status", ack.Status, "event", ack.Event, "replayed", ack.Replayed, "duration_ms", time.Since(start).Milliseconds()}
+	if ack.Error != nil {
+		done = append(done, "error_code", ack.Error.Code)
+	}
+	l.InfoContext(ctx, "command processed", done...)
+	if h.verify != nil {
+		h.verify.processed(msg, start)
+	}
+
+	span.End()
+	return mark
 }
 
-func withTx(db *sql.DB, fn func(*sql.Tx) error) error {
-	tx, err := db.Begin()
+// sendAck produces ack for the command msg to the tenant's acks topic,
+// under msg's key so the acks of one command stay in order.
+func (h *consumerHandler) sendAck(ctx context.Context, msg *sarama.ConsumerMessage, l *slog.Logger, ack Ack) {
+	c := h.replyCodec(msg)
+	b, err := c.EncodeAck(ack)
 	if err != nil {
-		return err
+		l.ErrorContext(ctx, "encode ack", "err", err)
+		ackPublishFailuresTotal.Inc()
+		return
 	}
+	out := &sarama.ProducerMessage{
+		Topic: tenant.Topic(h.tenantTopics, ack.TenantID, h.ackTopic),
+		Key:   sarama.ByteEncoder(msg.Key), // still using the consumer msg's key
+		Value: sarama.ByteEncoder(b),
+		Headers: []sarama.RecordHeader{
+			{Key: []byte(contracts.HeaderContentType), Value: []byte(c.ContentType())},
+			{Key: []byte(deployment.MetadataKey), Value: []byte(h.track)},
+		},
+	}
+	_, pspan := kafkahelper.StartProduce(ctx, out, attribute.String("app.operation.trace_id", ack.TraceID))
+	partition, offset, err := h.producer.SendMessage(out)
+	kafkahelper.EndProduce(pspan, partition, offset, err)
+	if err != nil {
+		l.ErrorContext(ctx, "ack produce", "err", err)
+		ackPublishFailuresTotal.Inc()
+	}
+}
+
+// replyCodec answers in the encoding the command's accept header asks for,
+// falling back to KAFKA_CODEC. Registry framing needs the schema id this
+// service registered; without KAFKA_SCHEMA_REGISTRY_URL the ack is plain
+// protobuf, which apisvc reads as well.
+func (h *consumerHandler) replyCodec(msg *sarama.ConsumerMessage) contracts.Codec {
+	if accept := kafkahelper.Header(msg.Headers, contracts.HeaderAccept); accept != "" {
+		if c, err := contracts.ForContentType(accept); err == nil {
+			if c.ContentType() != contracts.ContentTypeProtobufRegistry {
+				return c
+			}
+			if rc, ok := h.ackCodec.(contracts.RegistryCodec); ok {
+				return rc
+			}
+			return contracts.ProtobufCodec{}
+		}
+	}
+	return h.ackCodec
+}
+
+// withTx runs fn in a transaction of r under a client span named after the
+// command, so the trace shows how long the database work took and whether
+// it committed.
+func withTx(ctx context.Context, r repo.Repository, command string, fn func(repo.Tx) error) (err error) {
+	ctx, span := tracer.Start(ctx, "mysql tx "+command, oteltrace.WithSpanKind(oteltrace.SpanKindClient),
+		oteltrace.WithAttributes(semconv.DBSystemMySQL, semconv.DBOperationName(command)))
 	defer func() {
 		if err != nil {
-			_ = tx.Rollback()
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
 		}
+		span.End()
 	}()
-	if err = fn(tx); err != nil {
-		_ = tx.Rollback()
+	outcome := "commit"
+	err = r.InTx(ctx, func(tx repo.Tx) error {
+		if err := fn(tx); err != nil {
+			outcome = "rollback"
+			return err
+		}
+		return nil
+	})
+	span.SetAttributes(attribute.String("app.tx.outcome", outcome))
+	return err
+}
+
+// checkIdempotent reports whether key was already processed and, if so,
+// the ack that was sent the first time. Rows written before ack_payload
+// existed only carry the status.
+func checkIdempotent(ctx context.Context, tx repo.Tx, tenantID, key string) (*Ack, bool, error) {
+	rec, ok, err := tx.CheckIdempotency(ctx, tenantID, key)
+	if err != nil || !ok {
+		return nil, false, err
+	}
+	ack := Ack{TraceID: rec.TraceID, Status: rec.Status}
+	if len(rec.Ack) > 0 {
+		if err := json.Unmarshal(rec.Ack, &ack); err != nil {
+			return nil, false, err
+		}
+	}
+	return &ack, true, nil
+}
+
+func markIdempotent(ctx context.Context, tx repo.Tx, tenantID, key string, ack Ack) error {
+	b, err := json.Marshal(ack)
+	if err != nil {
 		return err
 	}
-	return tx.Commit()
+	return tx.MarkIdempotent(ctx, tenantID, key, repo.Idempotency{Status: ack.Status, TraceID: ack.TraceID, Ack: b})
 }
 
-func checkIdempotent(tx *sql.Tx, key string) (bool, error) {
-	row := tx.QueryRow("SELECT 1 FROM idempotency_keys WHERE idempotency_key=?", key)
-	var one int
-	if err := row.Scan(&one); err == sql.ErrNoRows {
-		return false, nil
-	} else if err != nil {
-		return false, err
+// saveAttachment records the blob reference carried by a Create/Update
+// command (the bytes are already in the blob store) and echoes it in the ack.
+func saveAttachment(ctx context.Context, tx repo.Tx, tenantID string, messageID int64, in, out map[string]any) error {
+	m, _ := in["attachment"].(map[string]any)
+	ref, ok := blob.RefFromMap(m)
+	if !ok {
+		return nil
+	}
+	if err := tx.SaveAttachment(ctx, tenantID, messageID, ref); err != nil {
+		return err
 	}
-	return true, nil
+	out["attachment"] = ref.Map()
+	return nil
 }
 
-func markIdempotent(tx *sql.Tx, key, traceID, status string) error {
-	_, err := tx.Exec("INSERT IGNORE INTO idempotency_keys(idempotency_key, last_status, trace_id) VALUES(?,?,?)", key, status, traceID)
-	return err
+// loadMessageFields returns the audited fields of a message before a
+// change, locking the row until the transaction ends. nil if it does not
+// exist.
+func loadMessageFields(ctx context.Context, tx repo.Tx, tenantID string, id int64) (map[string]any, error) {
+	m, err := tx.LockMessage(ctx, tenantID, id)
+	if errors.Is(err, repo.ErrNotFound) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return storedFields(ctx, tx, tenantID, m)
 }
 
-func logSaga(tx *sql.Tx, traceID, step, status, code, detail string) {
-	_, _ = tx.Exec("INSERT INTO saga_log(trace_id, step, status, error_code, error_detail) VALUES(?,?,?,?,?)", traceID, step, status, code, detail)
+// storedFields returns the audited fields of the stored message m.
+func storedFields(ctx context.Context, tx repo.Tx, tenantID string, m repo.Message) (map[string]any, error) {
+	fields := map[string]any{"message": m.Message}
+	att, err := tx.GetAttachment(ctx, tenantID, m.ID)
+	if err != nil {
+		return nil, err
+	}
+	if att != nil {
+		fields["attachment"] = att.Map()
+	}
+	return fields, nil
 }
 
-func getenv(k, d string) string {
-	if v := os.Getenv(k); v != "" {
-		return v
+// messageFields picks the audited fields out of an ack payload.
+func messageFields(payload map[string]any) map[string]any {
+	fields := map[string]any{"message": payload["message"]}
+	if att, ok := payload["attachment"]; ok {
+		fields["attachment"] = att
 	}
-	return d
+	return fields
+}
+
+func logSaga(ctx context.Context, tx repo.Tx, tenantID, traceID, step, status, code, detail string) {
+	_ = tx.LogSaga(ctx, tenantID, traceID, step, status, code, detail)
 }
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	saramamocks "github.com/IBM/sarama/mocks"
+	"github.com/go-sql-driver/mysql"
+	"github.com/golang/mock/gomock"
+
+	"github.com/slb-uk/rest-go-webservice/project/pkg/audit"
+	"github.com/slb-uk/rest-go-webservice/project/pkg/contracts"
+	kafkahelper "github.com/slb-uk/rest-go-webservice/project/pkg/kafka"
+	"github.com/slb-uk/rest-go-webservice/project/pkg/repo"
+	"github.com/slb-uk/rest-go-webservice/project/pkg/repo/mocks"
+)
+
+// handlerTest is a consumerHandler on mocks: every transaction runs on tx,
+// through inTx when set, and the records it produces, acks and others, are
+// kept in produced.
+type handlerTest struct {
+	h        *consumerHandler
+	repo     *mocks.MockRepository
+	tx       *mocks.MockTx
+	inTx     func(fn func(repo.Tx) error) error
+	producer *saramamocks.SyncProducer
+	produced []*sarama.ProducerMessage
+}
+
+func newHandlerTest(t *testing.T) *handlerTest {
+	ctrl := gomock.NewController(t)
+	ht := &handlerTest{repo: mocks.NewMockRepository(ctrl), tx: mocks.NewMockTx(ctrl), producer: saramamocks.NewSyncProducer(t, nil)}
+	ht.repo.EXPECT().InTx(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, fn func(repo.Tx) error) error {
+		if ht.inTx != nil {
+			return ht.inTx(fn)
+		}
+		return fn(ht.tx)
+	}).AnyTimes()
+	ht.h = &consumerHandler{repo: ht.repo, producer: ht.producer, ackTopic: "messages.acks", dlqTopic: "messages.commands.dlq",
+		ackCodec: contracts.JSONCodec{}, track: "stable", group: "consumersvc", workers: 1, maxInFlight: 1, bulkChunk: 500,
+		retryStages: []kafkahelper.RetryStage{{Topic: "messages.commands.retry.5s", Delay: 5 * time.Second}}}
+	t.Cleanup(func() {
+		if err := ht.producer.Close(); err != nil {
+			t.Error(err)
+		}
+	})
+	return ht
+}
+
+// expectProduce expects n records to be produced.
+func (ht *handlerTest) expectProduce(n int) {
+	for range n {
+		ht.producer.ExpectSendMessageWithMessageCheckerFunctionAndSucceed(func(m *sarama.ProducerMessage) error {
+			ht.produced = append(ht.produced, m)
+			return nil
+		})
+	}
+}
+
+func (ht *handlerTest) topics() []string {
+	var out []string
+	for _, m := range ht.produced {
+		out = append(out, m.Topic)
+	}
+	return out
+}
+
+// ack decodes the last record produced to the acks topic.
+func (ht *handlerTest) ack(t *testing.T) Ack {
+	t.Helper()
+	for i := len(ht.produced) - 1; i >= 0; i-- {
+		if m := ht.produced[i]; m.Topic == "messages.acks" {
+			b, _ := m.Value.Encode()
+			var a Ack
+			if err := json.Unmarshal(b, &a); err != nil {
+				t.Fatal(err)
+			}
+			return a
+		}
+	}
+	t.Fatalf("no ack among %v", ht.topics())
+	return Ack{}
+}
+
+func TestProcess(t *testing.T) {
+	errTooLong := &mysql.MySQLError{Number: 1406, Message: "Data too long for column 'message'"}
+	cases := []struct {
+		name     string
+		cmd      Command
+		setup    func(tx *mocks.MockTx)
+		topics   []string // produced, in order
+		mark     bool
+		status   string // of the ack
+		code     string
+		payload  map[string]any
+		audit    string // status of the audit row; "" for none
+		auditErr error
+		recorded bool // the result is kept for idempotent replays
+	}{{
+		name: "create",
+		cmd:  Command{Command: "Create", Payload: map[string]any{"message": "hello"}},
+		setup: func(tx *mocks.MockTx) {
+			tx.EXPECT().InsertMessage(gomock.Any(), "default", "hello").Return(int64(42), nil)
+		},
+		topics: []string{"messages.acks"}, mark: true, status: "SUCCESS",
+		payload: map[string]any{"id": float64(42), "message": "hello"}, audit: "SUCCESS", recorded: true,
+	}, {
+		name: "create with attachment",
+		cmd: Command{Command: "Create", Payload: map[string]any{"message": "hello",
+			"attachment": map[string]any{"key": "default/a1", "filename": "a.txt", "content_type": "text/plain", "size": 3, "sha256": "ab"}}},
+		setup: func(tx *mocks.MockTx) {
+			tx.EXPECT().InsertMessage(gomock.Any(), "default", "hello").Return(int64(43), nil)
+			tx.EXPECT().SaveAttachment(gomock.Any(), "default", int64(43), gomock.Any()).Return(nil)
+		},
+		topics: []string{"messages.acks"}, mark: true, status: "SUCCESS", audit: "SUCCESS", recorded: true,
+	}, {
+		// a failure is answered, but neither audited nor kept for replays
+		name: "create database error",
+		cmd:  Command{Command: "Create", Payload: map[string]any{"message": "hello"}},
+		setup: func(tx *mocks.MockTx) {
+			tx.EXPECT().InsertMessage(gomock.Any(), "default", "hello").Return(int64(0), errTooLong)
+		},
+		topics: []string{"messages.acks"}, mark: true, status: "FAILURE", code: "DB_ERROR",
+	}, {
+		name: "read",
+		cmd:  Command{Command: "Read", Payload: map[string]any{"id": "7"}},
+		setup: func(tx *mocks.MockTx) {
+			tx.EXPECT().GetMessage(gomock.Any(), "default", int64(7)).Return(repo.Message{ID: 7, Message: "hi"}, nil)
+			tx.EXPECT().GetAttachment(gomock.Any(), "default", int64(7)).Return(nil, nil)
+		},
+		topics: []string{"messages.acks"}, mark: true, status: "SUCCESS",
+		payload: map[string]any{"id": float64(7), "message": "hi"}, recorded: true,
+	}, {
+		name: "read missing",
+		cmd:  Command{Command: "Read", Payload: map[string]any{"id": "7"}},
+		setup: func(tx *mocks.MockTx) {
+			tx.EXPECT().GetMessage(gomock.Any(), "default", int64(7)).Return(repo.Message{}, repo.ErrNotFound)
+		},
+		topics: []string{"messages.acks"}, mark: true, status: "FAILURE", code: "NOT_FOUND",
+	}, {
+		name: "update",
+		cmd:  Command{Command: "Update", Payload: map[string]any{"id": "7", "message": "new"}},
+		setup: func(tx *mocks.MockTx) {
+			tx.EXPECT().LockMessage(gomock.Any(), "default", int64(7)).Return(repo.Message{ID: 7, Message: "old"}, nil)
+			tx.EXPECT().GetAttachment(gomock.Any(), "default", int64(7)).Return(nil, nil)
+			tx.EXPECT().UpdateMessage(gomock.Any(), "default", int64(7), "new").Return(nil)
+		},
+		topics: []string{"messages.acks"}, mark: true, status: "SUCCESS",
+		payload: map[string]any{"id": float64(7), "message": "new"}, audit: "SUCCESS", recorded: true,
+	}, {
+		name: "update missing",
+		cmd:  Command{Command: "Update", Payload: map[string]any{"id": "7", "message": "new"}},
+		setup: func(tx *mocks.MockTx) {
+			tx.EXPECT().LockMessage(gomock.Any(), "default", int64(7)).Return(repo.Message{}, repo.ErrNotFound)
+			tx.EXPECT().UpdateMessage(gomock.Any(), "default", int64(7), "new").Return(repo.ErrNotFound)
+		},
+		topics: []string{"messages.acks"}, mark: true, status: "FAILURE", code: "NOT_FOUND",
+	}, {
+		name: "delete",
+		cmd:  Command{Command: "Delete", Payload: map[string]any{"id": "7"}},
+		setup: func(tx *mocks.MockTx) {
+			tx.EXPECT().LockMessage(gomock.Any(), "default", int64(7)).Return(repo.Message{ID: 7, Message: "old"}, nil)
+			tx.EXPECT().GetAttachment(gomock.Any(), "default", int64(7)).Return(nil, nil)
+			tx.EXPECT().DeleteMessage(gomock.Any(), "default", int64(7)).Return(nil)
+		},
+		topics: []string{"messages.acks"}, mark: true, status: "SUCCESS",
+		payload: map[string]any{"id": float64(7)}, audit: "SUCCESS", recorded: true,
+	}, {
+		name:   "unknown command",
+		cmd:    Command{Command: "Archive", Payload: map[string]any{}},
+		setup:  func(*mocks.MockTx) {},
+		topics: []string{"messages.acks"}, mark: true, status: "FAILURE", code: "UNSUPPORTED", recorded: true,
+	}, {
+		// a deadlock goes down the retry ladder, unanswered for now
+		name: "transient error",
+		cmd:  Command{Command: "Create", Payload: map[string]any{"message": "hello"}},
+		setup: func(tx *mocks.MockTx) {
+			tx.EXPECT().InsertMessage(gomock.Any(), "default", "hello").Return(int64(0), &mysql.MySQLError{Number: 1213})
+		},
+		topics: []string{"messages.commands.retry.5s"}, mark: true,
+	}, {
+		// the transaction itself fails: INTERNAL, and dead-lettered
+		name: "audit fails",
+		cmd:  Command{Command: "Delete", Payload: map[string]any{"id": "7"}},
+		setup: func(tx *mocks.MockTx) {
+			tx.EXPECT().LockMessage(gomock.Any(), "default", int64(7)).Return(repo.Message{ID: 7, Message: "old"}, nil)
+			tx.EXPECT().GetAttachment(gomock.Any(), "default", int64(7)).Return(nil, nil)
+			tx.EXPECT().DeleteMessage(gomock.Any(), "default", int64(7)).Return(nil)
+		},
+		auditErr: errors.New("audit_log is read-only"),
+		topics:   []string{"messages.commands.dlq", "messages.acks"}, mark: true, status: "FAILURE", code: "INTERNAL",
+	}}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			ht := newHandlerTest(t)
+			tc.cmd.TraceID, tc.cmd.Resource = "t-1", "Message"
+			var audited audit.Entry
+			ht.tx.EXPECT().CheckIdempotency(gomock.Any(), "default", "k-1").Return(repo.Idempotency{}, false, nil)
+			ht.tx.EXPECT().LogSaga(gomock.Any(), "default", "t-1", gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(nil).AnyTimes()
+			tc.setup(ht.tx)
+			if tc.audit != "" || tc.auditErr != nil {
+				ht.tx.EXPECT().RecordAudit(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, e audit.Entry) error {
+					audited = e
+					return tc.auditErr
+				})
+			}
+			if tc.recorded {
+				ht.tx.EXPECT().MarkIdempotent(gomock.Any(), "default", "k-1", gomock.Any()).Return(nil)
+			}
+			ht.expectProduce(len(tc.topics))
+
+			msg := commandMessage(t, 0, tc.cmd)
+			msg.Key = []byte("k-1")
+			if mark := ht.h.process(context.Background(), msg); mark != tc.mark {
+				t.Fatalf("mark = %v", mark)
+			}
+			if got := ht.topics(); len(got) != len(tc.topics) || (len(got) > 0 && got[0] != tc.topics[0]) {
+				t.Fatalf("produced to %v, want %v", got, tc.topics)
+			}
+			if tc.status == "" {
+				return
+			}
+			ack := ht.ack(t)
+			if ack.TraceID != "t-1" || ack.Status != tc.status || ack.TenantID != "default" || ack.Replayed {
+				t.Fatalf("ack %+v", ack)
+			}
+			if tc.code != "" && (ack.Error == nil || ack.Error.Code != tc.code) {
+				t.Fatalf("ack error %+v, want %s", ack.Error, tc.code)
+			}
+			for k, v := range tc.payload {
+				if ack.Payload[k] != v {
+					t.Errorf("payload[%s] = %v, want %v", k, ack.Payload[k], v)
+				}
+			}
+			if tc.audit != "" && (audited.Status != tc.audit || audited.TraceID != "t-1" || audited.Command != tc.cmd.Command) {
+				t.Errorf("audit %+v", audited)
+			}
+		})
+	}
+}
+
+// A key that was processed already is answered with the first ack, under
+// the retry's trace id, without running the command again.
+func TestProcessIdempotentReplay(t *testing.T) {
+	ht := newHandlerTest(t)
+	first, _ := json.Marshal(Ack{TraceID: "t-0", Status: "SUCCESS", Event: "MessageCreated", Payload: map[string]any{"id": 42}})
+	ht.tx.EXPECT().CheckIdempotency(gomock.Any(), "default", "k-1").
+		Return(repo.Idempotency{Status: "SUCCESS", TraceID: "t-0", Ack: first}, true, nil)
+	ht.expectProduce(1)
+
+	msg := commandMessage(t, 0, Command{TraceID: "t-1", Command: "Create", Resource: "Message", Payload: map[string]any{"message": "hello"}})
+	msg.Key = []byte("k-1")
+	if !ht.h.process(context.Background(), msg) {
+		t.Fatal("a replay was not marked")
+	}
+	ack := ht.ack(t)
+	if ack.TraceID != "t-1" || !ack.Replayed || ack.Event != "MessageCreated" || ack.Payload["id"] != float64(42) {
+		t.Fatalf("ack %+v", ack)
+	}
+}
+
+// A command that does not decode or fails the contract is dead-lettered
+// without an ack or a transaction.
+func TestProcessRejects(t *testing.T) {
+	for name, value := range map[string][]byte{
+		"decode":   []byte("{"),
+		"contract": []byte(`{"trace_id":"t-1","command":"Create","resource":"Message","payload":{}}`),
+	} {
+		t.Run(name, func(t *testing.T) {
+			ht := newHandlerTest(t)
+			ht.expectProduce(1)
+			if !ht.h.process(context.Background(), &sarama.ConsumerMessage{Topic: "messages.commands", Value: value}) {
+				t.Fatal("a dead-lettered command was not marked")
+			}
+			if got := ht.topics(); len(got) != 1 || got[0] != "messages.commands.dlq" {
+				t.Fatalf("produced to %v", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	commandsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "consumersvc_commands_total",
+		Help: "Commands consumed, by tenant, command type and final status.",
+	}, []string{"tenant", "command", "status"})
+
+	commandDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "consumersvc_command_duration_seconds",
+		Help:    "Time from receiving a command to publishing its ack.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"tenant", "command"})
+
+	dbErrorsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "consumersvc_db_errors_total",
+		Help: "Commands that failed because of a database error.",
+	}, []string{"command"})
+
+	notFoundTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "consumersvc_not_found_total",
+		Help: "Commands that targeted a message id that does not exist.",
+	}, []string{"command"})
+
+	idempotentHitsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "consumersvc_idempotent_hits_total",
+		Help: "Commands skipped because their idempotency key was already processed. Divide by consumersvc_commands_total for the hit rate.",
+	}, []string{"command"})
+
+	ackPublishFailuresTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "consumersvc_ack_publish_failures_total",
+		Help: "Acks that could not be produced to Kafka.",
+	})
+
+	badCommandsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "consumersvc_bad_commands_total",
+		Help: "Messages on the command topic that could not be processed: an unknown encoding, undecodable, against the schema or for an invalid tenant.",
+	})
+
+	deadLetteredTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "consumersvc_dead_lettered_total",
+		Help: "Commands moved to the dead-letter topic, by reason: content-type, decode, schema, tenant or processing.",
+	}, []string{"reason"})
+
+	deadLetterFailuresTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "consumersvc_dead_letter_failures_total",
+		Help: "Commands that could not be produced to the dead-letter topic.",
+	})
+
+	commandsRetriedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "consumersvc_commands_retried_total",
+		Help: "Commands sent to a retry topic after a transient database error, by retry topic.",
+	}, []string{"topic"})
+
+	retryPublishFailuresTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "consumersvc_retry_publish_failures_total",
+		Help: "Commands that could not be produced to their retry topic and were dead-lettered instead.",
+	})
+
+	bulkMessagesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "consumersvc_bulk_messages_total",
+		Help: "Messages deleted or updated by DeleteByQuery and UpdateByQuery, by command, counted as each chunk commits.",
+	}, []string{"command"})
+
+	sagaCompensationsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "consumersvc_saga_compensations_total",
+		Help: "Saga steps undone because a later step of the command failed, by command and step.",
+	}, []string{"command", "step"})
+
+	commandsInFlight = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "consumersvc_commands_in_flight",
+		Help: "Commands taken from Kafka whose offsets are not marked yet: queued for a worker, running, or done but waiting on an earlier offset. Stuck at CONSUMER_MAX_IN_FLIGHT per partition means one slow command is holding its partition back.",
+	})
+
+	otherTrackTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "consumersvc_other_track_skipped_total",
+		Help: "Commands skipped because they are tagged for the other deployment track (header routing).",
+	})
+
+	deploymentInfo = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "consumersvc_deployment_info",
+		Help: "Always 1; the deployment track and canary routing mode of this worker. Join on it to compare canary and stable.",
+	}, []string{"track", "routing"})
+)
+
+// observeCommand records the outcome of one processed command.
+func observeCommand(ack Ack, command, tenantID string, start time.Time) {
+	commandsTotal.WithLabelValues(tenantID, command, ack.Status).Inc()
+	commandDuration.WithLabelValues(tenantID, command).Observe(time.Since(start).Seconds())
+	if ack.Replayed {
+		idempotentHitsTotal.WithLabelValues(command).Inc()
+	}
+	if ack.Error != nil {
+		switch ack.Error.Code {
+		case "DB_ERROR", "INTERNAL":
+			dbErrorsTotal.WithLabelValues(command).Inc()
+		case "NOT_FOUND":
+			notFoundTotal.WithLabelValues(command).Inc()
+		}
+	}
+}
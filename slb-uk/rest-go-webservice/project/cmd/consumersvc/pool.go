@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"hash/fnv"
+	"log/slog"
+	"sync"
+
+	"github.com/IBM/sarama"
+)
+
+// claimPool processes the messages of one partition claim on up to
+// CONSUMER_WORKERS goroutines. Messages with the same key always go to the
+// same worker, so the commands of one key still run one at a time and in
+// offset order; keyless messages all share the first worker for the same
+// reason. Commands with different keys overlap.
+//
+// Offsets are marked in order: a message is only marked once it and every
+// message before it on the partition have finished, so a crash or a
+// rebalance never commits past a command that was still running. It only
+// redelivers some that had finished, which the idempotency keys absorb.
+// A message process answers false for is a hold: nothing from it on is
+// marked for the rest of the claim, so the next owner starts there.
+//
+// At most CONSUMER_MAX_IN_FLIGHT messages are taken and not yet marked.
+// When one slow command holds the marks back, submit blocks rather than
+// buffering the rest of the partition behind it.
+type claimPool struct {
+	sess    sarama.ConsumerGroupSession
+	process func(context.Context, *sarama.ConsumerMessage) bool
+	lanes   []chan *pending
+	slots   chan struct{} // one per message in window
+	wg      sync.WaitGroup
+
+	mu     sync.Mutex
+	window []*pending // taken and not yet marked, in offset order
+	held   bool       // a message was not to be marked; see done
+}
+
+// pending is one message of the window; mark is process's answer.
+type pending struct {
+	msg        *sarama.ConsumerMessage
+	done, mark bool
+}
+
+func newClaimPool(sess sarama.ConsumerGroupSession, workers, maxInFlight int,
+	process func(context.Context, *sarama.ConsumerMessage) bool) *claimPool {
+	p := &claimPool{sess: sess, process: process, slots: make(chan struct{}, maxInFlight)}
+	for range workers {
+		// as deep as the window, so handing a message over never blocks
+		lane := make(chan *pending, maxInFlight)
+		p.lanes = append(p.lanes, lane)
+		p.wg.Add(1)
+		go p.work(lane)
+	}
+	return p
+}
+
+// submit hands msg to the worker of its key, waiting for room in the
+// window. It returns false once the session ends: the partition is being
+// revoked and no more messages should be taken.
+func (p *claimPool) submit(msg *sarama.ConsumerMessage) bool {
+	select {
+	case p.slots <- struct{}{}:
+	case <-p.sess.Context().Done():
+		return false
+	}
+	m := &pending{msg: msg}
+	p.mu.Lock()
+	p.window = append(p.window, m)
+	p.mu.Unlock()
+	commandsInFlight.Inc()
+	p.lanes[p.lane(msg.Key)] <- m
+	return true
+}
+
+func (p *claimPool) lane(key []byte) int {
+	if len(key) == 0 || len(p.lanes) == 1 {
+		return 0
+	}
+	h := fnv.New32a()
+	h.Write(key)
+	return int(h.Sum32() % uint32(len(p.lanes)))
+}
+
+func (p *claimPool) work(lane <-chan *pending) {
+	defer p.wg.Done()
+	for m := range lane {
+		if p.sess.Context().Err() != nil {
+			// revoked: what is left stays unmarked for the next owner
+			continue
+		}
+		p.done(m, p.process(p.sess.Context(), m.msg))
+	}
+}
+
+// done records that m has finished and marks the latest message of the
+// finished run at the start of the window. Marking past a message process
+// asked not to mark would commit it although it was not done, e.g. when
+// the partition was revoked in the middle of it, so the first such message
+// holds the marks where they are. The window still moves on, and the
+// workers with it, but nothing more is marked in this claim; neither is
+// anything once the session has ended.
+func (p *claimPool) done(m *pending, mark bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	m.done, m.mark = true, mark
+	var last *sarama.ConsumerMessage
+	n := 0
+	for ; n < len(p.window) && p.window[n].done; n++ {
+		w := p.window[n]
+		if !w.mark && !p.held {
+			p.held = true
+			slog.Info("offsets held", "topic", w.msg.Topic, "partition", w.msg.Partition, "offset", w.msg.Offset)
+		}
+		if !p.held {
+			last = w.msg
+		}
+	}
+	if last != nil && p.sess.Context().Err() == nil {
+		p.sess.MarkMessage(last, "")
+	}
+	clear(p.window[:n])
+	p.window = p.window[n:]
+	for range n {
+		<-p.slots
+	}
+	commandsInFlight.Sub(float64(n))
+}
+
+// wait lets the workers finish what they were given and stops them.
+func (p *claimPool) wait() {
+	for _, lane := range p.lanes {
+		close(lane)
+	}
+	p.wg.Wait()
+	p.mu.Lock()
+	commandsInFlight.Sub(float64(len(p.window))) // left for the next owner
+	p.mu.Unlock()
+}
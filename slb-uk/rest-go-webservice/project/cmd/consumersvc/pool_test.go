@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/IBM/sarama"
+)
+
+// fakeSession records the offsets marked; the rest of
+// sarama.ConsumerGroupSession is not used by the pool.
+type fakeSession struct {
+	sarama.ConsumerGroupSession
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu     sync.Mutex
+	marked []int64
+}
+
+func newFakeSession() *fakeSession {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &fakeSession{ctx: ctx, cancel: cancel}
+}
+
+func (s *fakeSession) Context() context.Context { return s.ctx }
+
+func (s *fakeSession) MarkMessage(msg *sarama.ConsumerMessage, _ string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.marked = append(s.marked, msg.Offset)
+}
+
+func (s *fakeSession) marks() []int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.marked)
+}
+
+// gatedPool is a two-lane pool whose process answers, for each offset,
+// what the test sends on that offset's gate.
+type gatedPool struct {
+	*claimPool
+	sess  *fakeSession
+	gates map[int64]chan bool
+	keyA  []byte // keys of the two lanes
+	keyB  []byte
+}
+
+func newGatedPool(t *testing.T, maxInFlight int) *gatedPool {
+	g := &gatedPool{sess: newFakeSession(), gates: map[int64]chan bool{}}
+	for i := range 100 {
+		g.gates[int64(i)] = make(chan bool, 1)
+	}
+	g.claimPool = newClaimPool(g.sess, 2, maxInFlight, func(_ context.Context, m *sarama.ConsumerMessage) bool {
+		return <-g.gates[m.Offset]
+	})
+	for i := 0; g.keyA == nil || g.keyB == nil; i++ {
+		k := []byte(fmt.Sprintf("key-%d", i))
+		if g.lane(k) == 0 && g.keyA == nil {
+			g.keyA = k
+		} else if g.lane(k) == 1 && g.keyB == nil {
+			g.keyB = k
+		}
+	}
+	t.Cleanup(g.sess.cancel)
+	return g
+}
+
+func (g *gatedPool) submitAt(t *testing.T, offset int64, key []byte) {
+	t.Helper()
+	if !g.submit(&sarama.ConsumerMessage{Topic: "messages.commands", Offset: offset, Key: key}) {
+		t.Fatalf("offset %d not taken", offset)
+	}
+}
+
+func TestClaimPoolMarksInOrder(t *testing.T) {
+	g := newGatedPool(t, 8)
+	g.submitAt(t, 0, g.keyA)
+	g.submitAt(t, 1, g.keyB)
+	g.gates[1] <- true
+	g.gates[0] <- true
+	g.wait()
+	// 1 finished first but waited for 0; then both went at once
+	if got := g.sess.marks(); !slices.Equal(got, []int64{1}) && !slices.Equal(got, []int64{0, 1}) {
+		t.Fatalf("marked %v", got)
+	}
+}
+
+func TestClaimPoolHoldsAtUnmarked(t *testing.T) {
+	g := newGatedPool(t, 4)
+	// lane B: 0 and 2; lane A: 1, which is not to be marked
+	g.submitAt(t, 0, g.keyB)
+	g.submitAt(t, 1, g.keyA)
+	g.submitAt(t, 2, g.keyB)
+	g.gates[0] <- true
+	g.gates[2] <- true
+	g.gates[1] <- false
+
+	// the window moves on past the hold: more than maxInFlight messages
+	// are still taken and processed, but none of them is marked
+	for off := int64(3); off < 10; off++ {
+		g.gates[off] <- true
+		g.submitAt(t, off, g.keyB)
+	}
+	g.wait()
+	if got := g.sess.marks(); !slices.Equal(got, []int64{0}) {
+		t.Fatalf("marked %v, want only 0: nothing from the held offset 1 on", got)
+	}
+}
+
+func TestClaimPoolStopsMarkingOnRevoke(t *testing.T) {
+	g := newGatedPool(t, 4)
+	g.submitAt(t, 0, g.keyA)
+	g.submitAt(t, 1, g.keyB)
+	g.gates[0] <- true
+	for len(g.sess.marks()) == 0 {
+		runtime.Gosched() // 0 is marked while the session is live
+	}
+
+	g.sess.cancel()
+	g.gates[1] <- true // finished, but after the revoke
+	g.wait()
+	if got := g.sess.marks(); !slices.Equal(got, []int64{0}) {
+		t.Fatalf("marked %v after the session ended", got)
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"log/slog"
+	"net"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/go-sql-driver/mysql"
+	"go.opentelemetry.io/otel/attribute"
+	oteltrace "go.opentelemetry.io/otel/trace"
+
+	kafkahelper "github.com/slb-uk/rest-go-webservice/project/pkg/kafka"
+)
+
+// A command whose transaction fails on a transient error is not answered
+// with FAILURE right away. It goes down a ladder of retry topics, one per
+// CONSUMER_RETRY_DELAYS (messages.commands.retry.5s, .30s and .2m by
+// default), which consumersvc consumes as well: a retried command waits
+// until its retry-not-before header is due and is processed again. One that
+// still fails after the last rung is answered INTERNAL and dead-lettered
+// with reason processing. Other database errors are not retried.
+//
+// The headers (see pkg/kafka) count the attempts and keep where the
+// command was first consumed, so its dead-letter record, and a replay of
+// it, point back at the command topic. A retried command leaves its
+// partition, so a later command with another idempotency key may overtake
+// it; the client still gets exactly one ack for it.
+
+// mysql error numbers worth trying again: the lock waits and deadlocks
+// another transaction caused, and a server going away or out of
+// connections
+var transientMySQL = map[uint16]bool{
+	1040: true, // ER_CON_COUNT_ERROR
+	1053: true, // ER_SERVER_SHUTDOWN
+	1205: true, // ER_LOCK_WAIT_TIMEOUT
+	1213: true, // ER_LOCK_DEADLOCK
+	2006: true, // CR_SERVER_GONE_ERROR
+	2013: true, // CR_SERVER_LOST
+}
+
+// transient reports whether err may well not happen again: a lost
+// connection, a deadlock, a timeout.
+func transient(err error) bool {
+	var me *mysql.MySQLError
+	if errors.As(err, &me) {
+		return transientMySQL[me.Number]
+	}
+	var ne net.Error
+	return errors.Is(err, driver.ErrBadConn) || errors.Is(err, mysql.ErrInvalidConn) ||
+		errors.Is(err, context.DeadlineExceeded) || errors.As(err, &ne)
+}
+
+// retry produces msg, failed for the attempt-th time with err, to stage and
+// reports whether it got there.
+func (h *consumerHandler) retry(ctx context.Context, msg *sarama.ConsumerMessage,
+	span oteltrace.Span, l *slog.Logger, stage kafkahelper.RetryStage, attempt int, err error) bool {
+	span.SetAttributes(attribute.Int("app.retry.attempt", attempt), attribute.String("app.retry.topic", stage.Topic))
+	out := stage.Message(msg, attempt, err, time.Now())
+	_, pspan := kafkahelper.StartProduce(ctx, out, attribute.Int("app.retry.attempt", attempt))
+	partition, offset, perr := h.producer.SendMessage(out)
+	kafkahelper.EndProduce(pspan, partition, offset, perr)
+	if perr != nil {
+		l.ErrorContext(ctx, "retry produce", "retry_topic", stage.Topic, "err", perr)
+		retryPublishFailuresTotal.Inc()
+		return false
+	}
+	l.WarnContext(ctx, "tx error, retrying later", "attempt", attempt, "retry_topic", stage.Topic, "err", err)
+	commandsRetriedTotal.WithLabelValues(stage.Topic).Inc()
+	return true
+}
+
+// due waits until a retried msg is due and reports false if ctx ended
+// first. A record that was never retried is due at once.
+func due(ctx context.Context, msg *sarama.ConsumerMessage) bool {
+	wait := time.Until(kafkahelper.RetryNotBefore(msg))
+	if wait <= 0 {
+		return true
+	}
+	t := time.NewTimer(wait)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	saramamocks "github.com/IBM/sarama/mocks"
+	"github.com/golang/mock/gomock"
+
+	kafkahelper "github.com/slb-uk/rest-go-webservice/project/pkg/kafka"
+	"github.com/slb-uk/rest-go-webservice/project/pkg/repo"
+	"github.com/slb-uk/rest-go-webservice/project/pkg/repo/mocks"
+)
+
+func retryAt(msg *sarama.ConsumerMessage, at time.Time) *sarama.ConsumerMessage {
+	msg.Headers = append(msg.Headers,
+		&sarama.RecordHeader{Key: []byte(kafkahelper.HeaderRetryAttempt), Value: []byte("1")},
+		&sarama.RecordHeader{Key: []byte(kafkahelper.HeaderRetryNotBefore), Value: []byte(strconv.FormatInt(at.UnixMilli(), 10))})
+	return msg
+}
+
+func TestDue(t *testing.T) {
+	if !due(context.Background(), &sarama.ConsumerMessage{}) {
+		t.Error("a first attempt is not due")
+	}
+	if !due(context.Background(), retryAt(&sarama.ConsumerMessage{}, time.Now().Add(-time.Second))) {
+		t.Error("a retry past its time is not due")
+	}
+	if !due(context.Background(), retryAt(&sarama.ConsumerMessage{}, time.Now().Add(20*time.Millisecond))) {
+		t.Error("a retry was not waited for")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	start := time.Now()
+	if due(ctx, retryAt(&sarama.ConsumerMessage{}, time.Now().Add(time.Hour))) {
+		t.Error("a retry an hour away was due")
+	}
+	if waited := time.Since(start); waited > 5*time.Second {
+		t.Errorf("due waited %s after the revoke", waited)
+	}
+}
+
+// revokeHandler is a consumerHandler whose producer expects nothing, so an
+// ack, retry or dead-letter record sent for a revoked command fails the
+// test.
+func revokeHandler(t *testing.T, r repo.Repository) *consumerHandler {
+	producer := saramamocks.NewSyncProducer(t, nil)
+	t.Cleanup(func() { _ = producer.Close() })
+	return &consumerHandler{repo: r, producer: producer, ackTopic: "messages.acks", dlqTopic: "messages.commands.dlq",
+		group: "consumersvc", workers: 2, maxInFlight: 4, bulkChunk: 500,
+		retryStages: []kafkahelper.RetryStage{{Topic: "messages.commands.retry.5s", Delay: 5 * time.Second}}}
+}
+
+func commandMessage(t *testing.T, offset int64, cmd Command) *sarama.ConsumerMessage {
+	t.Helper()
+	b, err := json.Marshal(cmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &sarama.ConsumerMessage{Topic: "messages.commands", Offset: offset, Key: []byte(cmd.TraceID), Value: b}
+}
+
+// A retried command the partition is revoked from while it waits is left
+// unmarked, without touching the database, for the next owner to wait out.
+func TestRevokedWhileWaitingForRetry(t *testing.T) {
+	h := revokeHandler(t, mocks.NewMockRepository(gomock.NewController(t)))
+	sess := newFakeSession()
+	p := newClaimPool(sess, h.workers, h.maxInFlight, h.process)
+	msg := commandMessage(t, 0, Command{TraceID: "t-1", Command: "Create", Resource: "Message", Payload: map[string]any{"message": "hi"}})
+	p.submit(retryAt(msg, time.Now().Add(time.Hour)))
+	time.AfterFunc(20*time.Millisecond, sess.cancel)
+	p.wait()
+	if got := sess.marks(); len(got) != 0 {
+		t.Fatalf("marked %v", got)
+	}
+}
+
+// A bulk command or saga cut short by a revoke is neither answered nor
+// marked: its next owner runs it again and carries on from what was
+// committed.
+func TestRevokedMidRun(t *testing.T) {
+	cases := []Command{
+		{TraceID: "t-saga", Command: "CreateWithQuota", Resource: "Message", Payload: map[string]any{"message": "hi"}},
+		{TraceID: "t-bulk", Command: "DeleteByQuery", Resource: "Message", Payload: map[string]any{"query": map[string]any{"contains": "x"}}},
+	}
+	for _, cmd := range cases {
+		t.Run(cmd.Command, func(t *testing.T) {
+			sess := newFakeSession()
+			ctrl := gomock.NewController(t)
+			r := mocks.NewMockRepository(ctrl)
+			tx := mocks.NewMockTx(ctrl)
+			tx.EXPECT().CheckIdempotency(gomock.Any(), "default", cmd.TraceID).Return(repo.Idempotency{}, false, nil).AnyTimes()
+			tx.EXPECT().SagaSteps(gomock.Any(), "default", cmd.TraceID).Return(nil, nil).AnyTimes()
+			r.EXPECT().CountMessages(gomock.Any(), gomock.Any()).Return(int64(2000), int64(2000), nil).AnyTimes()
+			// the first transaction commits; the partition is revoked
+			// during the second, which the driver answers with ctx's error
+			calls := 0
+			r.EXPECT().InTx(gomock.Any(), gomock.Any()).DoAndReturn(func(ctx context.Context, fn func(repo.Tx) error) error {
+				if calls++; calls == 1 {
+					return fn(tx)
+				}
+				sess.cancel()
+				return ctx.Err()
+			}).MinTimes(2)
+
+			h := revokeHandler(t, r)
+			p := newClaimPool(sess, h.workers, h.maxInFlight, h.process)
+			p.submit(commandMessage(t, 0, cmd))
+			p.wait()
+			if got := sess.marks(); len(got) != 0 {
+				t.Fatalf("marked %v", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"strconv"
+
+	"github.com/IBM/sarama"
+
+	"github.com/slb-uk/rest-go-webservice/project/pkg/audit"
+	"github.com/slb-uk/rest-go-webservice/project/pkg/blob"
+	"github.com/slb-uk/rest-go-webservice/project/pkg/repo"
+)
+
+// CreateWithQuota, sent by apisvc's POST /v1/messages:withQuota, is Create
+// charged to the tenant's quota in tenant_quotas. It runs as a saga: the
+// message, its attachment and the quota charge are each a transaction of
+// their own, committed together with a SUCCESS row in saga_log that holds
+// what undoing the step needs. When a step fails for good, with
+// QUOTA_EXCEEDED or a database error, its FAILURE row is logged and the
+// steps before it are undone newest first, each in a transaction with a
+// COMPENSATED row. The ack is sent once the command's key is marked, after
+// the audit row.
+//
+// saga_log is also how a saga picks up where it stopped. A transient error
+// goes down the retry ladder like any command, and a run interrupted by a
+// rebalance is left for the partition's next owner; either way the command
+// comes back with the same trace id, skips the steps logged SUCCESS and
+// carries on, or, after a FAILURE row, undoes the ones not COMPENSATED yet.
+// A compensation that keeps failing ends on the dead-letter topic, and
+// replaying it resumes the same way.
+
+var sagaCommands = map[string]bool{"CreateWithQuota": true}
+
+// sagaState is what the steps of a saga have done, logged as the data of
+// every SUCCESS row; resuming reads it back from them in order.
+type sagaState struct {
+	MessageID  int64          `json:"message_id,omitempty"`
+	Attachment map[string]any `json:"attachment,omitempty"`
+}
+
+// sagaStep is a local transaction of a saga and the one that undoes it.
+type sagaStep struct {
+	name string
+	do   func(ctx context.Context, tx repo.Tx, st *sagaState) error
+	// undo is nil for a step with nothing to undo
+	undo func(ctx context.Context, tx repo.Tx, st *sagaState) error
+}
+
+// createWithQuotaSteps are the steps of a CreateWithQuota for tenant tid.
+// SaveAttachment is left out when the command has no attachment.
+func createWithQuotaSteps(tid string, payload map[string]any) []sagaStep {
+	steps := []sagaStep{{
+		name: "CreateMessage",
+		do: func(ctx context.Context, tx repo.Tx, st *sagaState) (err error) {
+			m, _ := payload["message"].(string)
+			st.MessageID, err = tx.InsertMessage(ctx, tid, m)
+			return err
+		},
+		undo: func(ctx context.Context, tx repo.Tx, st *sagaState) error {
+			if err := tx.DeleteMessage(ctx, tid, st.MessageID); !errors.Is(err, repo.ErrNotFound) {
+				return err
+			}
+			return nil // already gone
+		},
+	}}
+	m, _ := payload["attachment"].(map[string]any)
+	if ref, ok := blob.RefFromMap(m); ok {
+		steps = append(steps, sagaStep{
+			name: "SaveAttachment",
+			do: func(ctx context.Context, tx repo.Tx, st *sagaState) error {
+				if err := tx.SaveAttachment(ctx, tid, st.MessageID, ref); err != nil {
+					return err
+				}
+				st.Attachment = ref.Map()
+				return nil
+			},
+			undo: func(ctx context.Context, tx repo.Tx, st *sagaState) error {
+				return tx.DeleteAttachment(ctx, tid, st.MessageID)
+			},
+		})
+	}
+	return append(steps, sagaStep{
+		name: "ChargeQuota",
+		do: func(ctx context.Context, tx repo.Tx, _ *sagaState) error {
+			return tx.ChargeQuota(ctx, tid, 1)
+		},
+	})
+}
+
+// saga runs a saga command from where its saga_log left it and returns the
+// final ack, or the original one when the command's key was processed
+// already.
+func (h *consumerHandler) saga(ctx context.Context, msg *sarama.ConsumerMessage, l *slog.Logger, cmd Command, tid string) (Ack, *Ack, error) {
+	ack := Ack{TraceID: cmd.TraceID, Status: "SUCCESS", Event: "MessageCreated", Payload: map[string]any{}, TenantID: tid}
+	key := string(msg.Key)
+	if key == "" {
+		key = cmd.TraceID
+	}
+	var prev *Ack
+	var processed bool
+	var logged []repo.SagaStep
+	err := withTx(ctx, h.repo, cmd.Command, func(tx repo.Tx) (err error) {
+		if prev, processed, err = checkIdempotent(ctx, tx, tid, key); err != nil || processed {
+			return err
+		}
+		logged, err = tx.SagaSteps(ctx, tid, cmd.TraceID)
+		return err
+	})
+	if err != nil || processed {
+		return ack, prev, err
+	}
+
+	steps := createWithQuotaSteps(tid, cmd.Payload)
+	var st sagaState
+	done, compensated := map[string]bool{}, map[string]bool{}
+	var failed *repo.SagaStep
+	for i, s := range logged {
+		switch s.Status {
+		case "SUCCESS":
+			done[s.Step] = true
+			if len(s.Data) > 0 {
+				if err := json.Unmarshal(s.Data, &st); err != nil {
+					return ack, nil, err
+				}
+			}
+		case "FAILURE":
+			failed = &logged[i]
+		case "COMPENSATED":
+			compensated[s.Step] = true
+		}
+	}
+	if len(logged) > 0 {
+		l.InfoContext(ctx, "saga resumed", "steps_logged", len(logged), "failed", failed != nil)
+	}
+
+	for _, s := range steps {
+		if failed != nil {
+			break
+		}
+		if done[s.name] {
+			continue
+		}
+		// the step works on a copy, kept only once its transaction commits
+		next := st
+		err := withTx(ctx, h.repo, cmd.Command, func(tx repo.Tx) error {
+			if err := s.do(ctx, tx, &next); err != nil {
+				return err
+			}
+			data, err := json.Marshal(next)
+			if err != nil {
+				return err
+			}
+			return tx.RecordSagaStep(ctx, tid, cmd.TraceID, repo.SagaStep{Step: s.name, Status: "SUCCESS", Data: data})
+		})
+		if err == nil {
+			st = next
+			done[s.name] = true
+			continue
+		}
+		if transient(err) {
+			return ack, nil, err // retried; the steps done so far are skipped
+		}
+		code := "DB_ERROR"
+		if errors.Is(err, repo.ErrQuotaExceeded) {
+			code = "QUOTA_EXCEEDED"
+		}
+		failed = &repo.SagaStep{Step: s.name, Status: "FAILURE", Code: code, Detail: err.Error()}
+		if err := withTx(ctx, h.repo, cmd.Command, func(tx repo.Tx) error {
+			return tx.RecordSagaStep(ctx, tid, cmd.TraceID, *failed)
+		}); err != nil {
+			return ack, nil, err
+		}
+		l.InfoContext(ctx, "saga step failed", "step", s.name, "error_code", code, "err", err)
+	}
+
+	if failed == nil {
+		ack.Payload["id"] = st.MessageID
+		ack.Payload["message"] = cmd.Payload["message"]
+		if st.Attachment != nil {
+			ack.Payload["attachment"] = st.Attachment
+		}
+		return ack, nil, h.sagaDone(ctx, cmd, tid, key, ack, strconv.FormatInt(st.MessageID, 10), audit.Diff(nil, messageFields(ack.Payload)))
+	}
+
+	undone := []string{}
+	for i := len(steps) - 1; i >= 0; i-- {
+		s := steps[i]
+		if !done[s.name] || s.undo == nil {
+			continue
+		}
+		if !compensated[s.name] {
+			err := withTx(ctx, h.repo, cmd.Command, func(tx repo.Tx) error {
+				if err := s.undo(ctx, tx, &st); err != nil {
+					return err
+				}
+				return tx.RecordSagaStep(ctx, tid, cmd.TraceID, repo.SagaStep{Step: s.name, Status: "COMPENSATED"})
+			})
+			if err != nil {
+				return ack, nil, err
+			}
+			sagaCompensationsTotal.WithLabelValues(cmd.Command, s.name).Inc()
+		}
+		undone = append(undone, s.name)
+	}
+	ack.Status = "FAILURE"
+	ack.Event = ""
+	ack.Error = &struct{ Code, Detail string }{failed.Code, failed.Detail}
+	ack.Payload = map[string]any{"failed_step": failed.Step, "compensated": undone}
+	return ack, nil, h.sagaDone(ctx, cmd, tid, key, ack, "", nil)
+}
+
+// sagaDone records the audit row of a finished saga and marks the
+// command's key with its final ack.
+func (h *consumerHandler) sagaDone(ctx context.Context, cmd Command, tid, key string, ack Ack, resourceID string, changes map[string]audit.Change) error {
+	return withTx(ctx, h.repo, cmd.Command, func(tx repo.Tx) error {
+		entry := audit.Entry{TenantID: tid, Resource: "Message", ResourceID: resourceID, Command: cmd.Command,
+			Actor: audit.FromMetadata(cmd.Metadata), TraceID: cmd.TraceID, Status: ack.Status, Changes: changes}
+		if ack.Error != nil {
+			entry.ErrorCode = ack.Error.Code
+		}
+		if err := tx.RecordAudit(ctx, entry); err != nil {
+			return err
+		}
+		return markIdempotent(ctx, tx, tid, key, ack)
+	})
+}
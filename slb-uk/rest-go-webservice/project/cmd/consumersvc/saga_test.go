@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/golang/mock/gomock"
+
+	"github.com/slb-uk/rest-go-webservice/project/pkg/audit"
+	"github.com/slb-uk/rest-go-webservice/project/pkg/repo"
+	"github.com/slb-uk/rest-go-webservice/project/pkg/repo/mocks"
+)
+
+var sagaAttachment = map[string]any{"key": "default/a1", "filename": "a.txt", "content_type": "text/plain", "size": float64(3), "sha256": "ab"}
+
+// logged is a saga_log row; data, when not nil, is marshalled the way the
+// saga logs its state.
+func logged(step, status string, data *sagaState) repo.SagaStep {
+	s := repo.SagaStep{Step: step, Status: status}
+	if data != nil {
+		s.Data, _ = json.Marshal(data)
+	}
+	if status == "FAILURE" {
+		s.Code, s.Detail = "QUOTA_EXCEEDED", repo.ErrQuotaExceeded.Error()
+	}
+	return s
+}
+
+func TestCreateWithQuota(t *testing.T) {
+	errTooLong := &mysql.MySQLError{Number: 1406, Message: "Data too long for column 'filename'"}
+	created := &sagaState{MessageID: 42}
+	attached := &sagaState{MessageID: 42, Attachment: sagaAttachment}
+	cases := []struct {
+		name        string
+		before      []repo.SagaStep // saga_log when the command comes in
+		setup       func(tx *mocks.MockTx)
+		topics      []string // produced, in order
+		status      string   // of the ack
+		code        string
+		failedStep  string
+		compensated []string
+		after       []string // saga_log once it is through, as "step status"
+	}{{
+		name: "all steps",
+		setup: func(tx *mocks.MockTx) {
+			tx.EXPECT().InsertMessage(gomock.Any(), "default", "hello").Return(int64(42), nil)
+			tx.EXPECT().SaveAttachment(gomock.Any(), "default", int64(42), gomock.Any()).Return(nil)
+			tx.EXPECT().ChargeQuota(gomock.Any(), "default", int64(1)).Return(nil)
+		},
+		topics: []string{"messages.acks"}, status: "SUCCESS",
+		after: []string{"CreateMessage SUCCESS", "SaveAttachment SUCCESS", "ChargeQuota SUCCESS"},
+	}, {
+		name: "CreateMessage fails",
+		setup: func(tx *mocks.MockTx) {
+			tx.EXPECT().InsertMessage(gomock.Any(), "default", "hello").Return(int64(0), errTooLong)
+		},
+		topics: []string{"messages.acks"}, status: "FAILURE", code: "DB_ERROR", failedStep: "CreateMessage",
+		after: []string{"CreateMessage FAILURE"},
+	}, {
+		name: "SaveAttachment fails",
+		setup: func(tx *mocks.MockTx) {
+			tx.EXPECT().InsertMessage(gomock.Any(), "default", "hello").Return(int64(42), nil)
+			tx.EXPECT().SaveAttachment(gomock.Any(), "default", int64(42), gomock.Any()).Return(errTooLong)
+			tx.EXPECT().DeleteMessage(gomock.Any(), "default", int64(42)).Return(nil)
+		},
+		topics: []string{"messages.acks"}, status: "FAILURE", code: "DB_ERROR", failedStep: "SaveAttachment",
+		compensated: []string{"CreateMessage"},
+		after:       []string{"CreateMessage SUCCESS", "SaveAttachment FAILURE", "CreateMessage COMPENSATED"},
+	}, {
+		name: "ChargeQuota fails",
+		setup: func(tx *mocks.MockTx) {
+			tx.EXPECT().InsertMessage(gomock.Any(), "default", "hello").Return(int64(42), nil)
+			tx.EXPECT().SaveAttachment(gomock.Any(), "default", int64(42), gomock.Any()).Return(nil)
+			tx.EXPECT().ChargeQuota(gomock.Any(), "default", int64(1)).Return(repo.ErrQuotaExceeded)
+			// newest first
+			gomock.InOrder(
+				tx.EXPECT().DeleteAttachment(gomock.Any(), "default", int64(42)).Return(nil),
+				tx.EXPECT().DeleteMessage(gomock.Any(), "default", int64(42)).Return(nil),
+			)
+		},
+		topics: []string{"messages.acks"}, status: "FAILURE", code: "QUOTA_EXCEEDED", failedStep: "ChargeQuota",
+		compensated: []string{"SaveAttachment", "CreateMessage"},
+		after: []string{"CreateMessage SUCCESS", "SaveAttachment SUCCESS", "ChargeQuota FAILURE",
+			"SaveAttachment COMPENSATED", "CreateMessage COMPENSATED"},
+	}, {
+		// retried later, from the last step logged
+		name: "ChargeQuota deadlocks",
+		setup: func(tx *mocks.MockTx) {
+			tx.EXPECT().InsertMessage(gomock.Any(), "default", "hello").Return(int64(42), nil)
+			tx.EXPECT().SaveAttachment(gomock.Any(), "default", int64(42), gomock.Any()).Return(nil)
+			tx.EXPECT().ChargeQuota(gomock.Any(), "default", int64(1)).Return(&mysql.MySQLError{Number: 1213})
+		},
+		topics: []string{"messages.commands.retry.5s"},
+		after:  []string{"CreateMessage SUCCESS", "SaveAttachment SUCCESS"},
+	}, {
+		// dead-lettered; replaying it resumes the compensation
+		name: "compensation fails",
+		setup: func(tx *mocks.MockTx) {
+			tx.EXPECT().InsertMessage(gomock.Any(), "default", "hello").Return(int64(42), nil)
+			tx.EXPECT().SaveAttachment(gomock.Any(), "default", int64(42), gomock.Any()).Return(nil)
+			tx.EXPECT().ChargeQuota(gomock.Any(), "default", int64(1)).Return(repo.ErrQuotaExceeded)
+			tx.EXPECT().DeleteAttachment(gomock.Any(), "default", int64(42)).Return(errors.New("attachments is read-only"))
+		},
+		topics: []string{"messages.commands.dlq", "messages.acks"}, status: "FAILURE", code: "INTERNAL",
+		after: []string{"CreateMessage SUCCESS", "SaveAttachment SUCCESS", "ChargeQuota FAILURE"},
+	}, {
+		name:   "resume after CreateMessage",
+		before: []repo.SagaStep{logged("CreateMessage", "SUCCESS", created)},
+		setup: func(tx *mocks.MockTx) {
+			tx.EXPECT().SaveAttachment(gomock.Any(), "default", int64(42), gomock.Any()).Return(nil)
+			tx.EXPECT().ChargeQuota(gomock.Any(), "default", int64(1)).Return(nil)
+		},
+		topics: []string{"messages.acks"}, status: "SUCCESS",
+		after: []string{"CreateMessage SUCCESS", "SaveAttachment SUCCESS", "ChargeQuota SUCCESS"},
+	}, {
+		name:   "resume after SaveAttachment",
+		before: []repo.SagaStep{logged("CreateMessage", "SUCCESS", created), logged("SaveAttachment", "SUCCESS", attached)},
+		setup: func(tx *mocks.MockTx) {
+			tx.EXPECT().ChargeQuota(gomock.Any(), "default", int64(1)).Return(nil)
+		},
+		topics: []string{"messages.acks"}, status: "SUCCESS",
+		after: []string{"CreateMessage SUCCESS", "SaveAttachment SUCCESS", "ChargeQuota SUCCESS"},
+	}, {
+		// every step committed, but the command was not marked
+		name: "resume after ChargeQuota",
+		before: []repo.SagaStep{logged("CreateMessage", "SUCCESS", created), logged("SaveAttachment", "SUCCESS", attached),
+			logged("ChargeQuota", "SUCCESS", attached)},
+		setup:  func(*mocks.MockTx) {},
+		topics: []string{"messages.acks"}, status: "SUCCESS",
+		after: []string{"CreateMessage SUCCESS", "SaveAttachment SUCCESS", "ChargeQuota SUCCESS"},
+	}, {
+		name: "resume after FAILURE",
+		before: []repo.SagaStep{logged("CreateMessage", "SUCCESS", created), logged("SaveAttachment", "SUCCESS", attached),
+			logged("ChargeQuota", "FAILURE", nil)},
+		setup: func(tx *mocks.MockTx) {
+			gomock.InOrder(
+				tx.EXPECT().DeleteAttachment(gomock.Any(), "default", int64(42)).Return(nil),
+				tx.EXPECT().DeleteMessage(gomock.Any(), "default", int64(42)).Return(nil),
+			)
+		},
+		topics: []string{"messages.acks"}, status: "FAILURE", code: "QUOTA_EXCEEDED", failedStep: "ChargeQuota",
+		compensated: []string{"SaveAttachment", "CreateMessage"},
+		after: []string{"CreateMessage SUCCESS", "SaveAttachment SUCCESS", "ChargeQuota FAILURE",
+			"SaveAttachment COMPENSATED", "CreateMessage COMPENSATED"},
+	}, {
+		// the message is gone already, which undoes the step as well
+		name: "resume mid-compensation",
+		before: []repo.SagaStep{logged("CreateMessage", "SUCCESS", created), logged("SaveAttachment", "SUCCESS", attached),
+			logged("ChargeQuota", "FAILURE", nil), logged("SaveAttachment", "COMPENSATED", nil)},
+		setup: func(tx *mocks.MockTx) {
+			tx.EXPECT().DeleteMessage(gomock.Any(), "default", int64(42)).Return(repo.ErrNotFound)
+		},
+		topics: []string{"messages.acks"}, status: "FAILURE", code: "QUOTA_EXCEEDED", failedStep: "ChargeQuota",
+		compensated: []string{"SaveAttachment", "CreateMessage"},
+		after: []string{"CreateMessage SUCCESS", "SaveAttachment SUCCESS", "ChargeQuota FAILURE",
+			"SaveAttachment COMPENSATED", "CreateMessage COMPENSATED"},
+	}, {
+		name: "resume compensated",
+		before: []repo.SagaStep{logged("CreateMessage", "SUCCESS", created), logged("SaveAttachment", "SUCCESS", attached),
+			logged("ChargeQuota", "FAILURE", nil), logged("SaveAttachment", "COMPENSATED", nil), logged("CreateMessage", "COMPENSATED", nil)},
+		setup:  func(*mocks.MockTx) {},
+		topics: []string{"messages.acks"}, status: "FAILURE", code: "QUOTA_EXCEEDED", failedStep: "ChargeQuota",
+		compensated: []string{"SaveAttachment", "CreateMessage"},
+		after: []string{"CreateMessage SUCCESS", "SaveAttachment SUCCESS", "ChargeQuota FAILURE",
+			"SaveAttachment COMPENSATED", "CreateMessage COMPENSATED"},
+	}}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			ht := newHandlerTest(t)
+			// saga_log of the trace: rows are kept only when their
+			// transaction commits
+			sagaLog := slices.Clone(tc.before)
+			var pending []repo.SagaStep
+			ht.inTx = func(fn func(repo.Tx) error) error {
+				pending = nil
+				if err := fn(ht.tx); err != nil {
+					return err
+				}
+				sagaLog = append(sagaLog, pending...)
+				return nil
+			}
+			ht.tx.EXPECT().CheckIdempotency(gomock.Any(), "default", "k-1").Return(repo.Idempotency{}, false, nil)
+			ht.tx.EXPECT().SagaSteps(gomock.Any(), "default", "t-1").DoAndReturn(func(context.Context, string, string) ([]repo.SagaStep, error) {
+				return slices.Clone(sagaLog), nil
+			})
+			ht.tx.EXPECT().RecordSagaStep(gomock.Any(), "default", "t-1", gomock.Any()).DoAndReturn(func(_ context.Context, _, _ string, s repo.SagaStep) error {
+				pending = append(pending, s)
+				return nil
+			}).AnyTimes()
+			tc.setup(ht.tx)
+			var audited audit.Entry
+			if tc.code != "INTERNAL" && tc.status != "" {
+				ht.tx.EXPECT().RecordAudit(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, e audit.Entry) error {
+					audited = e
+					return nil
+				})
+				ht.tx.EXPECT().MarkIdempotent(gomock.Any(), "default", "k-1", gomock.Any()).Return(nil)
+			}
+			ht.expectProduce(len(tc.topics))
+
+			msg := commandMessage(t, 0, Command{TraceID: "t-1", Command: "CreateWithQuota", Resource: "Message",
+				Payload: map[string]any{"message": "hello", "attachment": sagaAttachment}})
+			msg.Key = []byte("k-1")
+			if !ht.h.process(context.Background(), msg) {
+				t.Fatal("not marked")
+			}
+			if got := ht.topics(); !slices.Equal(got, tc.topics) {
+				t.Fatalf("produced to %v, want %v", got, tc.topics)
+			}
+			var steps []string
+			for _, s := range sagaLog {
+				steps = append(steps, s.Step+" "+s.Status)
+			}
+			if !slices.Equal(steps, tc.after) {
+				t.Errorf("saga_log %v, want %v", steps, tc.after)
+			}
+			if tc.status == "" {
+				return
+			}
+
+			ack := ht.ack(t)
+			if ack.TraceID != "t-1" || ack.Status != tc.status {
+				t.Fatalf("ack %+v", ack)
+			}
+			switch {
+			case tc.code == "INTERNAL":
+				if ack.Error == nil || ack.Error.Code != tc.code {
+					t.Fatalf("ack error %+v", ack.Error)
+				}
+			case tc.code != "":
+				if ack.Error == nil || ack.Error.Code != tc.code || ack.Payload["failed_step"] != tc.failedStep ||
+					fmt.Sprint(ack.Payload["compensated"]) != fmt.Sprint(tc.compensated) {
+					t.Fatalf("ack error %+v, payload %v", ack.Error, ack.Payload)
+				}
+				if audited.Status != "FAILURE" || audited.ErrorCode != tc.code {
+					t.Errorf("audit %+v", audited)
+				}
+			default:
+				if ack.Payload["id"] != float64(42) || ack.Payload["attachment"] == nil {
+					t.Fatalf("ack payload %v", ack.Payload)
+				}
+				if audited.Status != "SUCCESS" || audited.ResourceID != "42" || audited.Command != "CreateWithQuota" {
+					t.Errorf("audit %+v", audited)
+				}
+			}
+		})
+	}
+}
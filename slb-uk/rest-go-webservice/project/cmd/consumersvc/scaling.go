@@ -0,0 +1,298 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/slb-uk/rest-go-webservice/project/pkg/config"
+	kafkahelper "github.com/slb-uk/rest-go-webservice/project/pkg/kafka"
+)
+
+// Scaling guard rails. A consumer group never uses more members than its
+// topics have partitions: with 6 partitions the 7th worker gets nothing and
+// sits idle, and a worker that owns a hot partition can lag while the
+// others wait. Every worker describes its group and topics shortly after
+// start and then every SCALING_CHECK_INTERVAL, exports what it saw, and
+// logs a warning when
+//
+//   - the group has more members than a topic has partitions, or members
+//     without any partition, or
+//   - the most lagging member is more than SCALING_LAG_IMBALANCE times the
+//     mean member lag, once that lag is over SCALING_MIN_LAG records.
+//
+// SCALING_AUTO_PARTITIONS=true also grows a topic to the member count, up
+// to SCALING_MAX_PARTITIONS. Kafka cannot shrink it again, and keys hash to
+// different partitions afterwards: records of one key produced before and
+// after the change may be processed out of order while the old partition
+// drains. Leave it off where per-key order matters.
+
+var (
+	topicPartitions = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "consumersvc_topic_partitions",
+		Help: "Partitions of each consumed topic at the last scaling check.",
+	}, []string{"topic"})
+
+	groupMembers = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "consumersvc_group_members",
+		Help: "Live members of the consumer group at the last scaling check.",
+	}, []string{"group"})
+
+	groupIdleMembers = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "consumersvc_group_idle_members",
+		Help: "Group members with no partition assigned; more than 0 means more workers than partitions.",
+	}, []string{"group"})
+
+	groupLag = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "consumersvc_group_lag",
+		Help: "Records not yet committed by the group, per topic.",
+	}, []string{"group", "topic"})
+
+	groupLagImbalance = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "consumersvc_group_lag_imbalance_ratio",
+		Help: "Lag of the most lagging member over the mean lag of all members (1 = balanced).",
+	}, []string{"group"})
+
+	partitionsCreatedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "consumersvc_partitions_created_total",
+		Help: "Partitions added by SCALING_AUTO_PARTITIONS.",
+	}, []string{"topic"})
+)
+
+type scalingGuard struct {
+	client sarama.Client
+	admin  sarama.ClusterAdmin
+	group  string
+	topics []string
+
+	interval       time.Duration
+	imbalance      float64 // warn above this max/mean member lag
+	minLag         int64   // ...once the busiest member lags at least this much
+	autoPartitions bool
+	maxPartitions  int32
+}
+
+func newScalingGuard(conf *config.Consumer, group string, topics []string) (*scalingGuard, error) {
+	sc := conf.Scaling
+	g := &scalingGuard{group: group, topics: topics, interval: sc.CheckInterval, imbalance: sc.LagImbalance,
+		minLag: sc.MinLag, autoPartitions: sc.AutoPartitions, maxPartitions: sc.MaxPartitions}
+
+	cfg, err := kafkahelper.NewConfig(conf.Kafka.Options())
+	if err != nil {
+		return nil, err
+	}
+	client, err := sarama.NewClient(conf.Kafka.Brokers, cfg)
+	if err != nil {
+		return nil, err
+	}
+	if g.admin, err = sarama.NewClusterAdminFromClient(client); err != nil {
+		client.Close()
+		return nil, err
+	}
+	g.client = client
+	return g, nil
+}
+
+// run checks after the group had time to settle, then every interval.
+func (g *scalingGuard) run(settle time.Duration) {
+	time.Sleep(settle)
+	for {
+		if err := g.check(); err != nil {
+			slog.Warn("scaling check", "group", g.group, "err", err)
+		}
+		time.Sleep(g.interval)
+	}
+}
+
+// groupState is what one check observed.
+type groupState struct {
+	partitions map[string]int32              // per topic
+	members    map[string]map[string][]int32 // member id -> topic -> partitions
+	lag        map[string]map[int32]int64    // topic -> partition -> lag
+	stable     bool                          // no rebalance in progress
+}
+
+func (g *scalingGuard) check() error {
+	st, err := g.describe()
+	if err != nil {
+		return err
+	}
+	g.report(st)
+	if g.autoPartitions && st.stable {
+		g.growPartitions(st)
+	}
+	return nil
+}
+
+func (g *scalingGuard) describe() (groupState, error) {
+	st := groupState{partitions: map[string]int32{}, members: map[string]map[string][]int32{}, lag: map[string]map[int32]int64{}}
+	metas, err := g.admin.DescribeTopics(g.topics)
+	if err != nil {
+		return st, err
+	}
+	wanted := map[string][]int32{}
+	for _, m := range metas {
+		if m.Err != sarama.ErrNoError {
+			slog.Warn("scaling check", "group", g.group, "topic", m.Name, "err", m.Err)
+			continue
+		}
+		st.partitions[m.Name] = int32(len(m.Partitions))
+		for _, p := range m.Partitions {
+			wanted[m.Name] = append(wanted[m.Name], p.ID)
+		}
+	}
+
+	groups, err := g.admin.DescribeConsumerGroups([]string{g.group})
+	if err != nil {
+		return st, err
+	}
+	if len(groups) == 1 {
+		if groups[0].Err != sarama.ErrNoError {
+			return st, groups[0].Err
+		}
+		st.stable = groups[0].State == "Stable"
+		for id, m := range groups[0].Members {
+			a, err := m.GetMemberAssignment()
+			st.members[id] = map[string][]int32{}
+			if err != nil || a == nil {
+				continue // joined, but not assigned yet
+			}
+			for t, ps := range a.Topics {
+				st.members[id][t] = ps
+			}
+		}
+	}
+
+	offsets, err := g.admin.ListConsumerGroupOffsets(g.group, wanted)
+	if err != nil {
+		return st, err
+	}
+	for t, ps := range wanted {
+		st.lag[t] = map[int32]int64{}
+		for _, p := range ps {
+			high, err := g.client.GetOffset(t, p, sarama.OffsetNewest)
+			if err != nil {
+				return st, err
+			}
+			committed := int64(-1)
+			if b := offsets.GetBlock(t, p); b != nil && b.Err == sarama.ErrNoError {
+				committed = b.Offset
+			}
+			if committed < 0 {
+				// nothing committed yet: the group starts from the oldest record
+				if committed, err = g.client.GetOffset(t, p, sarama.OffsetOldest); err != nil {
+					return st, err
+				}
+			}
+			st.lag[t][p] = max(high-committed, 0)
+		}
+	}
+	return st, nil
+}
+
+// report exports the state and logs what needs attention.
+func (g *scalingGuard) report(st groupState) {
+	members := len(st.members)
+	groupMembers.WithLabelValues(g.group).Set(float64(members))
+	for _, t := range sortedKeys(st.partitions) {
+		n := st.partitions[t]
+		topicPartitions.WithLabelValues(t).Set(float64(n))
+		if int32(members) > n {
+			slog.Warn("scaling: more members than partitions; scale down or add partitions (SCALING_AUTO_PARTITIONS)",
+				"group", g.group, "members", members, "topic", t, "partitions", n, "without_partition", int32(members)-n)
+		}
+	}
+
+	var idle []string
+	memberLag := map[string]int64{}
+	for id, topics := range st.members {
+		assigned := 0
+		for t, ps := range topics {
+			assigned += len(ps)
+			for _, p := range ps {
+				memberLag[id] += st.lag[t][p]
+			}
+		}
+		if assigned == 0 {
+			idle = append(idle, id)
+		}
+	}
+	groupIdleMembers.WithLabelValues(g.group).Set(float64(len(idle)))
+	if len(idle) > 0 && st.stable {
+		sort.Strings(idle)
+		slog.Warn("scaling: idle members with no partitions", "group", g.group, "idle", idle)
+	}
+
+	for t, ps := range st.lag {
+		var sum int64
+		for _, l := range ps {
+			sum += l
+		}
+		groupLag.WithLabelValues(g.group, t).Set(float64(sum))
+	}
+
+	ratio, busiest := lagImbalance(memberLag)
+	groupLagImbalance.WithLabelValues(g.group).Set(ratio)
+	if st.stable && ratio > g.imbalance && memberLag[busiest] >= g.minLag {
+		slog.Warn("scaling: lag is imbalanced; the member's partitions are hotter than the rest and adding workers will not help it",
+			"group", g.group, "member", busiest, "lag", memberLag[busiest], "times_mean", math.Round(ratio*10)/10)
+	}
+}
+
+// lagImbalance is the largest member lag over the mean of all members,
+// and that member; 1 when there is no lag at all.
+func lagImbalance(memberLag map[string]int64) (float64, string) {
+	var sum, top int64
+	busiest := ""
+	for _, id := range sortedKeys(memberLag) {
+		l := memberLag[id]
+		sum += l
+		if l > top {
+			top, busiest = l, id
+		}
+	}
+	if sum == 0 {
+		return 1, ""
+	}
+	mean := float64(sum) / float64(len(memberLag))
+	return float64(top) / mean, busiest
+}
+
+// growPartitions raises each topic with fewer partitions than members to
+// the member count, capped at maxPartitions. Every worker runs this; the
+// ones that lose the race get ErrInvalidPartitions and move on.
+func (g *scalingGuard) growPartitions(st groupState) {
+	members := int32(len(st.members))
+	for _, t := range sortedKeys(st.partitions) {
+		n := st.partitions[t]
+		target := min(members, g.maxPartitions)
+		if target <= n {
+			continue
+		}
+		err := g.admin.CreatePartitions(t, target, nil, false)
+		switch {
+		case err == nil:
+			partitionsCreatedTotal.WithLabelValues(t).Add(float64(target - n))
+			slog.Info("scaling: grew topic", "topic", t, "from", n, "to", target, "members", members)
+		case errors.Is(err, sarama.ErrInvalidPartitions):
+			// already grown by another worker
+		default:
+			slog.Error("scaling: grow topic", "topic", t, "to", target, "err", err)
+		}
+	}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+// verifyRecord is one line of the verification log. cmd/partitioncheck
+// reads these from every instance and asserts that no key (and no
+// partition) was processed by two workers at the same time.
+type verifyRecord struct {
+	Kind       string  `json:"kind"` // "claim" or "process"
+	Instance   string  `json:"instance"`
+	Generation int32   `json:"generation,omitempty"`
+	Topic      string  `json:"topic,omitempty"`
+	Partition  int32   `json:"partition"`
+	Partitions []int32 `json:"partitions,omitempty"`
+	Offset     int64   `json:"offset,omitempty"`
+	Key        string  `json:"key,omitempty"`
+	StartNs    int64   `json:"start_ns,omitempty"`
+	EndNs      int64   `json:"end_ns,omitempty"`
+}
+
+// verifier appends verifyRecords as JSON lines. Enabled with VERIFY_MODE=true;
+// the file (VERIFY_LOG) usually sits on a volume shared by all replicas.
+type verifier struct {
+	mu       sync.Mutex
+	enc      *json.Encoder
+	instance string
+}
+
+// newVerifier appends to path; instance defaults to the hostname.
+func newVerifier(path, instance string) (*verifier, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	if instance == "" {
+		instance, _ = os.Hostname()
+	}
+	return &verifier{enc: json.NewEncoder(f), instance: instance}, nil
+}
+
+func (v *verifier) write(r verifyRecord) {
+	r.Instance = v.instance
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if err := v.enc.Encode(r); err != nil {
+		slog.Error("verify log", "err", err)
+	}
+}
+
+func (v *verifier) claims(sess sarama.ConsumerGroupSession) {
+	for topic, parts := range sess.Claims() {
+		slog.Info("verify: claims", "instance", v.instance, "generation", sess.GenerationID(), "topic", topic, "partitions", parts)
+		v.write(verifyRecord{Kind: "claim", Generation: sess.GenerationID(), Topic: topic, Partitions: parts})
+	}
+}
+
+func (v *verifier) processed(msg *sarama.ConsumerMessage, start time.Time) {
+	v.write(verifyRecord{
+		Kind:      "process",
+		Topic:     msg.Topic,
+		Partition: msg.Partition,
+		Offset:    msg.Offset,
+		Key:       string(msg.Key),
+		StartNs:   start.UnixNano(),
+		EndNs:     time.Now().UnixNano(),
+	})
+}
@@ -0,0 +1,157 @@
+// Command dlqreplay produces the commands consumersvc dead-lettered back to
+// the topic they came from, once whatever made them fail is fixed.
+//
+// It reads every partition of KAFKA_TOPIC_DLQ up to where it ended when the
+// replay started, so records dead-lettered again during the replay are not
+// picked up twice. The replayed record has the key, value and headers of
+// the original, without the dlq-* headers but with dlq-replays counting up.
+// Replaying is safe to repeat: consumersvc answers a command whose
+// idempotency key it has already applied with the stored ack.
+//
+//	go run ./cmd/dlqreplay -replay-reason processing -replay-since 2h -replay-dry-run
+//
+// Each record is printed as topic/partition@offset with its key and
+// dlq-reason; the tool exits 1 when any of them could not be produced.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/IBM/sarama"
+
+	"github.com/slb-uk/rest-go-webservice/project/pkg/config"
+	kafkahelper "github.com/slb-uk/rest-go-webservice/project/pkg/kafka"
+)
+
+func main() {
+	var conf config.Replay
+	if err := config.Load(&conf, "dlqreplay", os.Args[1:]); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			os.Exit(0)
+		}
+		log.Fatal(err)
+	}
+
+	cfg, err := kafkahelper.NewConfig(conf.Kafka.Options())
+	if err != nil {
+		log.Fatal(err)
+	}
+	client, err := sarama.NewClient(conf.Kafka.Brokers, cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer client.Close()
+	consumer, err := sarama.NewConsumerFromClient(client)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer consumer.Close()
+	var producer sarama.SyncProducer
+	if !conf.DryRun {
+		if producer, err = kafkahelper.NewIdempotentProducer(conf.Kafka.Brokers, conf.Kafka.Options()); err != nil {
+			log.Fatal(err)
+		}
+		defer producer.Close()
+	}
+
+	partitions, err := client.Partitions(conf.DLQTopic)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var matched, replayed, failed int
+	for _, p := range partitions {
+		recs, err := read(client, consumer, conf, p, conf.Limit-matched)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, msg := range recs {
+			matched++
+			where := fmt.Sprintf("%s/%d@%d key=%q reason=%s", msg.Topic, msg.Partition, msg.Offset, msg.Key,
+				kafkahelper.Header(msg.Headers, kafkahelper.HeaderDLQReason))
+			if conf.DryRun {
+				fmt.Println("would replay", where, "error:", kafkahelper.Header(msg.Headers, kafkahelper.HeaderDLQError))
+				continue
+			}
+			out, err := kafkahelper.Replay(msg, conf.Topic)
+			if err == nil {
+				_, _, err = producer.SendMessage(out)
+			}
+			if err != nil {
+				failed++
+				fmt.Println("FAILED", where, "err:", err)
+				continue
+			}
+			replayed++
+			fmt.Println("replayed", where, "to", out.Topic)
+		}
+		if conf.Limit > 0 && matched >= conf.Limit {
+			break
+		}
+	}
+	fmt.Printf("%d matching record(s), %d replayed, %d failed\n", matched, replayed, failed)
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// read returns the records of partition p that pass the filters, from the
+// start (or REPLAY_SINCE ago) up to the end it has now. limit > 0 stops it
+// after that many.
+func read(client sarama.Client, consumer sarama.Consumer, conf config.Replay, p int32, limit int) ([]*sarama.ConsumerMessage, error) {
+	end, err := client.GetOffset(conf.DLQTopic, p, sarama.OffsetNewest)
+	if err != nil {
+		return nil, err
+	}
+	start := sarama.OffsetOldest
+	if conf.Since > 0 {
+		// the first offset written at or after the time; -1 when none is
+		if start, err = client.GetOffset(conf.DLQTopic, p, time.Now().Add(-conf.Since).UnixMilli()); err != nil {
+			return nil, err
+		}
+		if start < 0 {
+			return nil, nil
+		}
+	}
+	if oldest, err := client.GetOffset(conf.DLQTopic, p, sarama.OffsetOldest); err != nil {
+		return nil, err
+	} else if oldest >= end {
+		return nil, nil // empty, or everything is past retention
+	}
+	pc, err := consumer.ConsumePartition(conf.DLQTopic, p, start)
+	if err != nil {
+		return nil, err
+	}
+	defer pc.Close()
+
+	var out []*sarama.ConsumerMessage
+	for {
+		select {
+		case msg := <-pc.Messages():
+			if match(msg, conf) {
+				out = append(out, msg)
+				if limit > 0 && len(out) >= limit {
+					return out, nil
+				}
+			}
+			if msg.Offset >= end-1 {
+				return out, nil
+			}
+		case err := <-pc.Errors():
+			return nil, err
+		case <-time.After(30 * time.Second):
+			return nil, fmt.Errorf("%s/%d: no record for 30s before offset %d", conf.DLQTopic, p, end)
+		}
+	}
+}
+
+func match(msg *sarama.ConsumerMessage, conf config.Replay) bool {
+	if conf.Reason != "" && kafkahelper.Header(msg.Headers, kafkahelper.HeaderDLQReason) != conf.Reason {
+		return false
+	}
+	return conf.Key == "" || string(msg.Key) == conf.Key
+}
@@ -0,0 +1,184 @@
+// Command partitioncheck reads the verification logs written by
+// consumersvc instances running with VERIFY_MODE=true and asserts Kafka's
+// partition guarantees held:
+//
+//   - a key is never processed by two workers at the same time
+//   - a partition is never processed by two instances at the same time
+//   - within one instance, the offsets of a key only move forward (keyless
+//     messages count as one key)
+//
+// It exits 1 and prints each violation otherwise.
+//
+//	go run ./cmd/partitioncheck /var/log/consumersvc/*.jsonl
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"time"
+)
+
+type record struct {
+	Kind      string `json:"kind"`
+	Instance  string `json:"instance"`
+	Topic     string `json:"topic"`
+	Partition int32  `json:"partition"`
+	Offset    int64  `json:"offset"`
+	Key       string `json:"key"`
+	StartNs   int64  `json:"start_ns"`
+	EndNs     int64  `json:"end_ns"`
+}
+
+type partitionID struct {
+	Topic     string
+	Partition int32
+}
+
+func main() {
+	verbose := flag.Bool("v", false, "print per-instance partition summary")
+	flag.Parse()
+	if flag.NArg() == 0 {
+		log.Fatal("usage: partitioncheck [-v] verify.jsonl...")
+	}
+
+	var recs []record
+	for _, path := range flag.Args() {
+		r, err := readLog(path)
+		if err != nil {
+			log.Fatal(err)
+		}
+		recs = append(recs, r...)
+	}
+
+	var violations []string
+	violations = append(violations, checkOverlap(recs, "key", func(r record) string { return r.Key })...)
+	violations = append(violations, checkOverlap(recs, "partition", func(r record) string {
+		return fmt.Sprintf("%s/%d", r.Topic, r.Partition)
+	})...)
+	violations = append(violations, checkOffsets(recs)...)
+
+	if *verbose {
+		printSummary(recs)
+	}
+	fmt.Printf("checked %d processed messages from %d file(s)\n", len(recs), flag.NArg())
+	if len(violations) > 0 {
+		for _, v := range violations {
+			fmt.Println("VIOLATION:", v)
+		}
+		os.Exit(1)
+	}
+	fmt.Println("OK: no key or partition was processed concurrently")
+}
+
+func readLog(path string) ([]record, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var out []record
+	sc := bufio.NewScanner(f)
+	for line := 1; sc.Scan(); line++ {
+		var r record
+		if err := json.Unmarshal(sc.Bytes(), &r); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		if r.Kind == "process" {
+			out = append(out, r)
+		}
+	}
+	return out, sc.Err()
+}
+
+// checkOverlap groups records by groupBy and reports any two processing
+// intervals in the same group that overlap in time on different instances
+// (or, for keys, on any two workers).
+func checkOverlap(recs []record, what string, groupBy func(record) string) []string {
+	groups := map[string][]record{}
+	for _, r := range recs {
+		k := groupBy(r)
+		if k == "" {
+			continue
+		}
+		groups[k] = append(groups[k], r)
+	}
+	var out []string
+	for k, rs := range groups {
+		sort.Slice(rs, func(i, j int) bool { return rs[i].StartNs < rs[j].StartNs })
+		// track the interval that ends last so far; anything starting before
+		// it ends overlaps
+		last := rs[0]
+		for _, r := range rs[1:] {
+			if r.StartNs < last.EndNs && (what == "key" || r.Instance != last.Instance) {
+				out = append(out, fmt.Sprintf("%s %q: %s@%d and %s@%d overlap by %s",
+					what, k, last.Instance, last.Offset, r.Instance, r.Offset, time.Duration(last.EndNs-r.StartNs)))
+			}
+			if r.EndNs > last.EndNs {
+				last = r
+			}
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// checkOffsets reports an instance starting a key's message before an
+// earlier one of the same partition. Different keys of a partition run
+// concurrently with CONSUMER_WORKERS > 1, so only the order per key is
+// guaranteed.
+func checkOffsets(recs []record) []string {
+	type instKey struct {
+		Instance string
+		partitionID
+		Key string
+	}
+	lastOffset := map[instKey]int64{}
+	sorted := append([]record(nil), recs...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].StartNs < sorted[j].StartNs })
+	var out []string
+	for _, r := range sorted {
+		k := instKey{r.Instance, partitionID{r.Topic, r.Partition}, r.Key}
+		if prev, ok := lastOffset[k]; ok && r.Offset <= prev {
+			out = append(out, fmt.Sprintf("instance %s went backwards on %s/%d key %q: offset %d after %d",
+				r.Instance, r.Topic, r.Partition, r.Key, r.Offset, prev))
+		}
+		lastOffset[k] = r.Offset
+	}
+	return out
+}
+
+func printSummary(recs []record) {
+	counts := map[string]map[partitionID]int{}
+	for _, r := range recs {
+		if counts[r.Instance] == nil {
+			counts[r.Instance] = map[partitionID]int{}
+		}
+		counts[r.Instance][partitionID{r.Topic, r.Partition}]++
+	}
+	instances := make([]string, 0, len(counts))
+	for i := range counts {
+		instances = append(instances, i)
+	}
+	sort.Strings(instances)
+	for _, inst := range instances {
+		fmt.Printf("%s:\n", inst)
+		parts := make([]partitionID, 0, len(counts[inst]))
+		for p := range counts[inst] {
+			parts = append(parts, p)
+		}
+		sort.Slice(parts, func(i, j int) bool {
+			if parts[i].Topic != parts[j].Topic {
+				return parts[i].Topic < parts[j].Topic
+			}
+			return parts[i].Partition < parts[j].Partition
+		})
+		for _, p := range parts {
+			fmt.Printf("  %s/%d: %d messages\n", p.Topic, p.Partition, counts[inst][p])
+		}
+	}
+}
@@ -0,0 +1,4 @@
+-- Keep the original ack next to the idempotency key so replays of an
+-- already-processed command can be answered with the same result.
+ALTER TABLE idempotency_keys
+  ADD COLUMN ack_payload JSON NULL AFTER trace_id;
@@ -0,0 +1,18 @@
+-- Multi-tenancy: every row belongs to a tenant. Existing rows move to the
+-- "default" tenant, which is also what requests without X-Tenant-ID use.
+ALTER TABLE messages
+  ADD COLUMN tenant_id VARCHAR(64) NOT NULL DEFAULT 'default' AFTER id,
+  ADD INDEX idx_messages_tenant (tenant_id, id);
+
+ALTER TABLE saga_log
+  ADD COLUMN tenant_id VARCHAR(64) NOT NULL DEFAULT 'default' AFTER id,
+  ADD INDEX idx_saga_log_tenant_trace (tenant_id, trace_id);
+
+-- idempotency keys are only unique within a tenant
+ALTER TABLE idempotency_keys
+  ADD COLUMN tenant_id VARCHAR(64) NOT NULL DEFAULT 'default' FIRST,
+  DROP PRIMARY KEY,
+  ADD PRIMARY KEY (tenant_id, idempotency_key);
+
+ALTER TABLE outbox
+  ADD COLUMN tenant_id VARCHAR(64) NOT NULL DEFAULT 'default' AFTER id;
@@ -0,0 +1,14 @@
+-- Attachment metadata. The bytes live in the blob store (filesystem, S3 or
+-- MinIO); only the reference is stored here and sent through Kafka.
+CREATE TABLE IF NOT EXISTS message_attachments (
+  tenant_id VARCHAR(64) NOT NULL,
+  message_id BIGINT NOT NULL,
+  blob_key VARCHAR(255) NOT NULL,
+  filename VARCHAR(255) NOT NULL DEFAULT '',
+  content_type VARCHAR(255) NOT NULL,
+  size_bytes BIGINT NOT NULL,
+  sha256 CHAR(64) NOT NULL,
+  created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+  updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+  PRIMARY KEY (tenant_id, message_id)
+);
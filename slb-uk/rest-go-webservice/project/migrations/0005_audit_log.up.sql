@@ -0,0 +1,20 @@
+-- Audit trail: one row per write command (Create/Update/Delete), written by
+-- consumersvc in the same transaction as the change. `changes` holds the
+-- field diff, {"message": {"from": "a", "to": "b"}}. Failed commands are
+-- recorded too, with their error code and no changes.
+CREATE TABLE IF NOT EXISTS audit_log (
+  id BIGINT AUTO_INCREMENT PRIMARY KEY,
+  tenant_id VARCHAR(64) NOT NULL,
+  resource VARCHAR(64) NOT NULL,
+  resource_id VARCHAR(64) NOT NULL DEFAULT '',
+  command VARCHAR(32) NOT NULL,
+  actor VARCHAR(255) NOT NULL,
+  trace_id VARCHAR(64) NOT NULL,
+  status VARCHAR(16) NOT NULL,
+  error_code VARCHAR(64) NOT NULL DEFAULT '',
+  changes JSON NULL,
+  created_at TIMESTAMP(3) DEFAULT CURRENT_TIMESTAMP(3),
+  INDEX idx_audit_resource (tenant_id, resource, resource_id, id),
+  INDEX idx_audit_actor (tenant_id, actor, id),
+  INDEX idx_audit_created (tenant_id, created_at)
+);
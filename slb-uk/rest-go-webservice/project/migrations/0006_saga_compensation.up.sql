@@ -0,0 +1,15 @@
+-- CreateWithQuota runs as a saga of local transactions. Each step logs what
+-- undoing it needs in data, and a step that was undone is logged again as
+-- COMPENSATED.
+ALTER TABLE saga_log
+  MODIFY COLUMN status ENUM('PENDING','SUCCESS','FAILURE','COMPENSATED') NOT NULL,
+  ADD COLUMN data JSON NULL AFTER error_detail;
+
+-- Messages a tenant may create with CreateWithQuota. Tenants without a row
+-- have no limit.
+CREATE TABLE IF NOT EXISTS tenant_quotas (
+  tenant_id VARCHAR(64) PRIMARY KEY,
+  max_messages BIGINT NOT NULL,
+  used_messages BIGINT NOT NULL DEFAULT 0,
+  updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP
+);
@@ -0,0 +1,4 @@
+-- apisvc accepts Idempotency-Key headers of up to 255 characters, and the
+-- key is what the consumers deduplicate on. CHAR(36) only fit UUIDs.
+ALTER TABLE idempotency_keys
+  MODIFY COLUMN idempotency_key VARCHAR(255) NOT NULL;
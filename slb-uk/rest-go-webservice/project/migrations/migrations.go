@@ -0,0 +1,10 @@
+// Package migrations embeds the SQL files of this directory, so consumersvc
+// can apply them itself (pkg/migrate). The files are numbered for
+// golang-migrate, NNNN_title.up.sql, and are plain SQL, which is also how
+// docker-entrypoint-initdb.d and make migrate apply them.
+package migrations
+
+import "embed"
+
+//go:embed *.sql
+var FS embed.FS
@@ -0,0 +1,201 @@
+// Package audit records who changed what and when. consumersvc writes one
+// row per write command inside the command's transaction and answers
+// QueryAudit commands from apisvc's GET /v1/audit, which has no database of
+// its own.
+package audit
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// Header carries the authenticated subject on incoming HTTP requests. Like
+// X-Tenant-ID it is set by the gateway from the verified token (the "sub"
+// claim); clients must not be able to send it directly. With AUTH_JWT_ROUTES
+// set, pkg/auth sets it from the token and drops it everywhere else.
+const Header = "X-Auth-Subject"
+
+// MetadataKey is the Command.Metadata / Kafka header key for the actor.
+const MetadataKey = "actor"
+
+// Anonymous is the actor of requests without a subject.
+const Anonymous = "anonymous"
+
+const maxActorLen = 255
+
+// FromRequest returns the subject named by the request header, or Anonymous.
+func FromRequest(r *http.Request) string {
+	return normalize(r.Header.Get(Header))
+}
+
+// FromMetadata reads the actor from a command's metadata, or Anonymous.
+func FromMetadata(md map[string]any) string {
+	s, _ := md[MetadataKey].(string)
+	return normalize(s)
+}
+
+func normalize(s string) string {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return Anonymous
+	}
+	if len(s) > maxActorLen {
+		s = s[:maxActorLen]
+	}
+	return s
+}
+
+// Change is one field's value before and after a command. From is nil for
+// fields that did not exist (Create), To for fields that are gone (Delete).
+type Change struct {
+	From any `json:"from"`
+	To   any `json:"to"`
+}
+
+// Diff returns the fields whose values differ between before and after.
+func Diff(before, after map[string]any) map[string]Change {
+	out := map[string]Change{}
+	for k, v := range before {
+		if w, ok := after[k]; !ok || !reflect.DeepEqual(v, w) {
+			out[k] = Change{From: v, To: after[k]}
+		}
+	}
+	for k, w := range after {
+		if _, ok := before[k]; !ok {
+			out[k] = Change{To: w}
+		}
+	}
+	return out
+}
+
+// Entry is one row of audit_log.
+type Entry struct {
+	ID         int64             `json:"id"`
+	TenantID   string            `json:"tenant_id"`
+	Resource   string            `json:"resource"`
+	ResourceID string            `json:"resource_id"`
+	Command    string            `json:"command"`
+	Actor      string            `json:"actor"`
+	TraceID    string            `json:"trace_id"`
+	Status     string            `json:"status"`
+	ErrorCode  string            `json:"error_code,omitempty"`
+	Changes    map[string]Change `json:"changes,omitempty"`
+	CreatedAt  time.Time         `json:"created_at"`
+}
+
+// Record inserts e in the caller's transaction, so the audit row commits or
+// rolls back together with the change it describes.
+func Record(tx *sql.Tx, e Entry) error {
+	changes, err := json.Marshal(e.Changes)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`INSERT INTO audit_log(tenant_id, resource, resource_id, command, actor, trace_id, status, error_code, changes)
+		VALUES(?,?,?,?,?,?,?,?,?)`,
+		e.TenantID, e.Resource, e.ResourceID, e.Command, e.Actor, e.TraceID, e.Status, e.ErrorCode, changes)
+	return err
+}
+
+// Page sizes for List.
+const (
+	DefaultLimit = 20
+	MaxLimit     = 100
+)
+
+// Filter selects entries of one tenant. Empty fields match everything. It
+// travels as the QueryAudit command payload; the tenant never does, the
+// consumer takes it from the command metadata.
+type Filter struct {
+	TenantID   string    `json:"-"`
+	Resource   string    `json:"resource,omitempty"`
+	ResourceID string    `json:"id,omitempty"`
+	Actor      string    `json:"actor,omitempty"`
+	Command    string    `json:"command,omitempty"`
+	Since      time.Time `json:"since"` // inclusive
+	Until      time.Time `json:"until"` // exclusive
+	// Cursor is the NextCursor of the previous page; 0 starts at the newest.
+	Cursor int64 `json:"cursor,omitempty"`
+	Limit  int   `json:"limit,omitempty"`
+}
+
+// Querier is satisfied by *sql.DB and *sql.Tx.
+type Querier interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+// Page is one page of entries, newest first. NextCursor is 0 on the last
+// page.
+type Page struct {
+	Entries    []Entry `json:"entries"`
+	NextCursor int64   `json:"next_cursor,omitempty"`
+}
+
+// List returns entries matching f, newest first. Pages are keyed on the id,
+// so rows written while a client pages through are never skipped or
+// repeated.
+func List(ctx context.Context, db Querier, f Filter) (Page, error) {
+	if f.Limit <= 0 {
+		f.Limit = DefaultLimit
+	}
+	f.Limit = min(f.Limit, MaxLimit)
+	where := []string{"tenant_id=?"}
+	args := []any{f.TenantID}
+	for _, c := range []struct{ col, v string }{
+		{"resource", f.Resource}, {"resource_id", f.ResourceID}, {"actor", f.Actor}, {"command", f.Command},
+	} {
+		if c.v != "" {
+			where = append(where, c.col+"=?")
+			args = append(args, c.v)
+		}
+	}
+	if !f.Since.IsZero() {
+		where = append(where, "created_at>=?")
+		args = append(args, f.Since.UTC())
+	}
+	if !f.Until.IsZero() {
+		where = append(where, "created_at<?")
+		args = append(args, f.Until.UTC())
+	}
+	if f.Cursor > 0 {
+		where = append(where, "id<?")
+		args = append(args, f.Cursor)
+	}
+	// one extra row tells whether there is a next page
+	args = append(args, f.Limit+1)
+
+	rows, err := db.QueryContext(ctx, `SELECT id, tenant_id, resource, resource_id, command, actor, trace_id, status, error_code, changes, created_at
+		FROM audit_log WHERE `+strings.Join(where, " AND ")+` ORDER BY id DESC LIMIT ?`, args...)
+	if err != nil {
+		return Page{}, err
+	}
+	defer rows.Close()
+
+	page := Page{Entries: []Entry{}}
+	for rows.Next() {
+		var e Entry
+		var changes []byte
+		if err := rows.Scan(&e.ID, &e.TenantID, &e.Resource, &e.ResourceID, &e.Command, &e.Actor, &e.TraceID,
+			&e.Status, &e.ErrorCode, &changes, &e.CreatedAt); err != nil {
+			return Page{}, err
+		}
+		if len(changes) > 0 {
+			if err := json.Unmarshal(changes, &e.Changes); err != nil {
+				return Page{}, err
+			}
+		}
+		page.Entries = append(page.Entries, e)
+	}
+	if err := rows.Err(); err != nil {
+		return Page{}, err
+	}
+	if len(page.Entries) > f.Limit {
+		page.Entries = page.Entries[:f.Limit]
+		page.NextCursor = page.Entries[f.Limit-1].ID
+	}
+	return page, nil
+}
@@ -0,0 +1,273 @@
+// Package auth verifies Bearer JWTs in front of the /v1 routes, taking the
+// gateway's job described in pkg/tenant and pkg/audit: the tenant and the
+// subject of an authenticated request come from the verified token, not
+// from whatever X-Tenant-ID / X-Auth-Subject the client sent.
+//
+// Tokens are signed with HS256 (a shared secret) or RS256 (a PEM public key,
+// or the keys of a JWKS URL, picked by the token's "kid"). exp and nbf are
+// always checked; iss and aud when configured.
+//
+// Failures are answered with a problem (pkg/problem) whose code is the
+// RFC 6750 error in upper case, and the matching WWW-Authenticate header:
+//
+//   - 401 INVALID_REQUEST / INVALID_TOKEN: no token, a malformed, expired or
+//     badly signed one
+//   - 403 INSUFFICIENT_SCOPE: a valid token without the required scope, or
+//     without the admin scope on an admin route
+//   - 403 INVALID_TENANT: a token for another tenant than X-Tenant-ID names
+package auth
+
+import (
+	"context"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"github.com/slb-uk/rest-go-webservice/project/pkg/audit"
+	"github.com/slb-uk/rest-go-webservice/project/pkg/problem"
+	"github.com/slb-uk/rest-go-webservice/project/pkg/tenant"
+)
+
+// Claims are the parts of a verified token the services use.
+type Claims struct {
+	Subject string
+	Tenant  string   // empty when the token has no tenant claim
+	Scopes  []string // "scope" (space-separated) or "scp" (list)
+	Raw     jwt.MapClaims
+}
+
+// HasScope reports whether s is among the token's scopes.
+func (c *Claims) HasScope(s string) bool {
+	for _, have := range c.Scopes {
+		if have == s {
+			return true
+		}
+	}
+	return false
+}
+
+type ctxKey string
+
+const claimsKey ctxKey = "jwt_claims"
+
+func WithClaims(ctx context.Context, c *Claims) context.Context {
+	return context.WithValue(ctx, claimsKey, c)
+}
+
+// FromContext returns the claims of the request's token, or nil on routes
+// that are not protected.
+func FromContext(ctx context.Context) *Claims {
+	c, _ := ctx.Value(claimsKey).(*Claims)
+	return c
+}
+
+// Config selects the keys and the routes. A zero Config protects nothing.
+// apisvc gets it from the AUTH_JWT_* settings of pkg/config.
+type Config struct {
+	Routes      []string       // path prefixes that need a token, e.g. "/v1/"
+	HMACSecret  []byte         // enables HS256
+	RSAKey      *rsa.PublicKey // enables RS256 with a fixed key
+	JWKS        *JWKS          // enables RS256 with keys from a JWKS URL
+	Issuer      string
+	Audience    string
+	Scope       string // required on every protected route when set
+	AdminScope  string // required on top of Scope by RequireScope routes
+	TenantClaim string // default "tenant_id"
+	Leeway      time.Duration
+}
+
+// Enabled reports whether any route is protected.
+func (c Config) Enabled() bool { return len(c.Routes) > 0 }
+
+func (c Config) protects(path string) bool {
+	for _, p := range c.Routes {
+		if strings.HasPrefix(path, p) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c Config) methods() []string {
+	var m []string
+	if c.HMACSecret != nil {
+		m = append(m, "HS256")
+	}
+	if c.RSAKey != nil || c.JWKS != nil {
+		m = append(m, "RS256")
+	}
+	return m
+}
+
+// key picks the verification key for t. The allowed-methods check has run
+// already, so the algorithm is one we have a key for.
+func (c Config) key(t *jwt.Token) (any, error) {
+	switch t.Method.Alg() {
+	case "HS256":
+		return c.HMACSecret, nil
+	case "RS256":
+		kid, _ := t.Header["kid"].(string)
+		if c.JWKS != nil && (kid != "" || c.RSAKey == nil) {
+			return c.JWKS.Key(context.Background(), kid)
+		}
+		return c.RSAKey, nil
+	}
+	return nil, fmt.Errorf("unexpected signing method %s", t.Method.Alg())
+}
+
+// Verify parses and verifies a compact JWS and returns its claims.
+func (c Config) Verify(token string) (*Claims, error) {
+	opts := []jwt.ParserOption{jwt.WithValidMethods(c.methods()), jwt.WithLeeway(c.Leeway), jwt.WithExpirationRequired()}
+	if c.Issuer != "" {
+		opts = append(opts, jwt.WithIssuer(c.Issuer))
+	}
+	if c.Audience != "" {
+		opts = append(opts, jwt.WithAudience(c.Audience))
+	}
+	mc := jwt.MapClaims{}
+	if _, err := jwt.ParseWithClaims(token, mc, c.key, opts...); err != nil {
+		return nil, err
+	}
+	claim := c.TenantClaim
+	if claim == "" {
+		claim = "tenant_id"
+	}
+	out := &Claims{Raw: mc}
+	out.Subject, _ = mc["sub"].(string)
+	out.Tenant, _ = mc[claim].(string)
+	if s, ok := mc["scope"].(string); ok {
+		out.Scopes = strings.Fields(s)
+	}
+	if l, ok := mc["scp"].([]any); ok {
+		for _, s := range l {
+			if s, ok := s.(string); ok {
+				out.Scopes = append(out.Scopes, s)
+			}
+		}
+	}
+	return out, nil
+}
+
+// bearer returns the request's token: the Authorization header, or for GET
+// requests the access_token query parameter, since browsers cannot set
+// headers on WebSocket and EventSource requests.
+func bearer(r *http.Request) (string, bool) {
+	if h := r.Header.Get("Authorization"); h != "" {
+		scheme, tok, ok := strings.Cut(h, " ")
+		if !ok || !strings.EqualFold(scheme, "Bearer") || strings.TrimSpace(tok) == "" {
+			return "", false
+		}
+		return strings.TrimSpace(tok), true
+	}
+	if r.Method == http.MethodGet {
+		if tok := r.URL.Query().Get("access_token"); tok != "" {
+			return tok, true
+		}
+	}
+	return "", false
+}
+
+// deny writes the error response, a problem whose code is the RFC 6750
+// error in upper case (problem.CodeInvalidToken, ...). Per RFC 6750 the
+// challenge of a request without any token carries no error code.
+func deny(w http.ResponseWriter, r *http.Request, status int, code, desc string) {
+	challenge := `Bearer realm="apisvc"`
+	if code != "" {
+		challenge += fmt.Sprintf(`, error=%q, error_description=%q`, code, desc)
+	} else {
+		code = "invalid_request"
+	}
+	w.Header().Set("WWW-Authenticate", challenge)
+	problem.Write(w, r, status, strings.ToUpper(code), desc)
+}
+
+// Middleware checks the token on protected routes. The claims go into the
+// request context, the subject into audit.Header and the tenant into
+// tenant.Header, replacing what the client sent. Other routes pass
+// through, with a client-sent audit.Header removed.
+func Middleware(c Config, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !c.protects(r.URL.Path) {
+			if c.Enabled() && r.Header.Get(audit.Header) != "" {
+				r = r.Clone(r.Context())
+				r.Header.Del(audit.Header)
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+		tok, ok := bearer(r)
+		if !ok {
+			deny(w, r, http.StatusUnauthorized, "", "missing bearer token")
+			return
+		}
+		claims, err := c.Verify(tok)
+		if err != nil {
+			deny(w, r, http.StatusUnauthorized, "invalid_token", describe(err))
+			return
+		}
+		if c.Scope != "" && !claims.HasScope(c.Scope) {
+			deny(w, r, http.StatusForbidden, "insufficient_scope", "token lacks scope "+c.Scope)
+			return
+		}
+		if claims.Tenant != "" && r.Header.Get(tenant.Header) != "" {
+			if asked, _ := tenant.FromRequest(r); asked != claims.Tenant {
+				deny(w, r, http.StatusForbidden, "invalid_tenant", "token is for tenant "+claims.Tenant)
+				return
+			}
+		}
+		r = r.Clone(WithClaims(r.Context(), claims))
+		if claims.Tenant != "" {
+			r.Header.Set(tenant.Header, claims.Tenant)
+		}
+		r.Header.Set(audit.Header, claims.Subject)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RequireScope answers 403 INSUFFICIENT_SCOPE when the request's token
+// lacks scope. It runs behind Middleware and fails closed: a request
+// without verified claims, because Middleware does not protect its route,
+// is answered 401 INVALID_REQUEST, so admin routes stay shut until
+// AUTH_JWT_ROUTES covers them.
+func RequireScope(scope string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c := FromContext(r.Context())
+		if c == nil {
+			deny(w, r, http.StatusUnauthorized, "", "this route needs a bearer token verified under AUTH_JWT_ROUTES")
+			return
+		}
+		if scope != "" && !c.HasScope(scope) {
+			deny(w, r, http.StatusForbidden, "insufficient_scope", "token lacks scope "+scope)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// describe turns a parse error into a client-safe description.
+func describe(err error) string {
+	switch {
+	case errors.Is(err, jwt.ErrTokenExpired):
+		return "token is expired"
+	case errors.Is(err, jwt.ErrTokenNotValidYet):
+		return "token is not valid yet"
+	case errors.Is(err, jwt.ErrTokenMalformed):
+		return "token is malformed"
+	case errors.Is(err, jwt.ErrTokenSignatureInvalid):
+		return "token signature is invalid"
+	case errors.Is(err, jwt.ErrTokenUnverifiable):
+		return "token could not be verified" // unknown kid, JWKS unreachable, ...
+	case errors.Is(err, jwt.ErrTokenInvalidIssuer):
+		return "token issuer is not accepted"
+	case errors.Is(err, jwt.ErrTokenInvalidAudience):
+		return "token audience is not accepted"
+	case errors.Is(err, jwt.ErrTokenRequiredClaimMissing):
+		return "token has no exp claim"
+	}
+	return "token is invalid"
+}
@@ -0,0 +1,97 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"github.com/slb-uk/rest-go-webservice/project/pkg/problem"
+)
+
+func TestRequireScope(t *testing.T) {
+	cases := []struct {
+		name   string
+		claims *Claims
+		status int
+		code   string
+	}{
+		// a route Middleware does not protect: closed, not open
+		{"no claims", nil, http.StatusUnauthorized, problem.CodeInvalidRequest},
+		{"without the scope", &Claims{Subject: "alice", Scopes: []string{"messages"}}, http.StatusForbidden, problem.CodeInsufficientScope},
+		{"with the scope", &Claims{Subject: "root", Scopes: []string{"messages", "admin"}}, http.StatusOK, ""},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			h := RequireScope("admin", http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+			r := httptest.NewRequest(http.MethodPost, "/v1/admin/messages:deleteByQuery", nil)
+			if tc.claims != nil {
+				r = r.WithContext(WithClaims(r.Context(), tc.claims))
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			if w.Code != tc.status {
+				t.Fatalf("status %d, want %d", w.Code, tc.status)
+			}
+			if tc.code == "" {
+				return
+			}
+			var p problem.Details
+			if ct := w.Header().Get("Content-Type"); ct != problem.ContentType {
+				t.Errorf("Content-Type %q", ct)
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &p); err != nil || p.Code != tc.code {
+				t.Errorf("body %s", w.Body)
+			}
+			if w.Header().Get("WWW-Authenticate") == "" {
+				t.Error("no WWW-Authenticate challenge")
+			}
+		})
+	}
+}
+
+func TestMiddlewareDenies(t *testing.T) {
+	c := Config{Routes: []string{"/v1/"}, HMACSecret: []byte("secret"), Scope: "messages", Leeway: time.Second}
+	sign := func(claims jwt.MapClaims) string {
+		s, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(c.HMACSecret)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+	exp := time.Now().Add(time.Hour).Unix()
+	cases := []struct {
+		name, token string
+		status      int
+		code        string
+		challenge   string
+	}{
+		{"no token", "", http.StatusUnauthorized, problem.CodeInvalidRequest, `Bearer realm="apisvc"`},
+		{"expired", sign(jwt.MapClaims{"sub": "a", "scope": "messages", "exp": time.Now().Add(-time.Hour).Unix()}),
+			http.StatusUnauthorized, problem.CodeInvalidToken, `Bearer realm="apisvc", error="invalid_token", error_description="token is expired"`},
+		{"scope", sign(jwt.MapClaims{"sub": "a", "exp": exp}),
+			http.StatusForbidden, problem.CodeInsufficientScope, `Bearer realm="apisvc", error="insufficient_scope", error_description="token lacks scope messages"`},
+		{"ok", sign(jwt.MapClaims{"sub": "a", "scope": "messages", "exp": exp}), http.StatusOK, "", ""},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			h := Middleware(c, http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+			r := httptest.NewRequest(http.MethodGet, "/v1/messages", nil)
+			if tc.token != "" {
+				r.Header.Set("Authorization", "Bearer "+tc.token)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			if w.Code != tc.status || w.Header().Get("WWW-Authenticate") != tc.challenge {
+				t.Fatalf("status %d, challenge %q", w.Code, w.Header().Get("WWW-Authenticate"))
+			}
+			var p problem.Details
+			if tc.code != "" && (json.Unmarshal(w.Body.Bytes(), &p) != nil || p.Code != tc.code || p.Status != tc.status) {
+				t.Errorf("body %s", w.Body)
+			}
+		})
+	}
+}
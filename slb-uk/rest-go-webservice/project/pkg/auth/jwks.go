@@ -0,0 +1,114 @@
+package auth
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// JWKS caches the RSA keys of a JSON Web Key Set URL. The set is fetched on
+// first use, again after TTL, and early when a token names a kid the cache
+// does not know (key rotation), but at most once per MinRefresh so that
+// tokens with made-up kids cannot hammer the identity provider.
+type JWKS struct {
+	URL        string
+	TTL        time.Duration
+	MinRefresh time.Duration
+	Client     *http.Client
+
+	mu      sync.Mutex
+	keys    map[string]*rsa.PublicKey
+	fetched time.Time
+}
+
+var ErrUnknownKey = errors.New("no JWKS key for kid")
+
+// NewJWKS returns a cache for url with a 1h TTL and a 30s MinRefresh.
+func NewJWKS(url string) *JWKS {
+	return &JWKS{URL: url, TTL: time.Hour, MinRefresh: 30 * time.Second,
+		Client: &http.Client{Timeout: 5 * time.Second}}
+}
+
+// Key returns the key for kid. An empty kid matches a set with one key.
+func (j *JWKS) Key(ctx context.Context, kid string) (*rsa.PublicKey, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	k, ok := j.lookup(kid)
+	age := time.Since(j.fetched)
+	if (!ok && age >= j.MinRefresh) || age >= j.TTL {
+		if err := j.refresh(ctx); err != nil {
+			if ok {
+				return k, nil // keep verifying with the stale set
+			}
+			return nil, err
+		}
+		k, ok = j.lookup(kid)
+	}
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownKey, kid)
+	}
+	return k, nil
+}
+
+func (j *JWKS) lookup(kid string) (*rsa.PublicKey, bool) {
+	if kid == "" && len(j.keys) == 1 {
+		for _, k := range j.keys {
+			return k, true
+		}
+	}
+	k, ok := j.keys[kid]
+	return k, ok
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+// refresh replaces the cached set; keys other than RSA signing keys are
+// skipped.
+func (j *JWKS) refresh(ctx context.Context) error {
+	j.fetched = time.Now()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, j.URL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := j.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("jwks: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("jwks: %s", resp.Status)
+	}
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return fmt.Errorf("jwks: %w", err)
+	}
+	keys := map[string]*rsa.PublicKey{}
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" || (k.Use != "" && k.Use != "sig") {
+			continue
+		}
+		n, err1 := base64.RawURLEncoding.DecodeString(k.N)
+		e, err2 := base64.RawURLEncoding.DecodeString(k.E)
+		if err1 != nil || err2 != nil || len(e) == 0 || len(e) > 4 {
+			continue
+		}
+		keys[k.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	}
+	j.keys = keys
+	return nil
+}
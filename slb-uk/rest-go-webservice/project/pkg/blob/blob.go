@@ -0,0 +1,63 @@
+package blob
+
+import (
+	"context"
+	"errors"
+	"io"
+)
+
+// ErrNotFound is returned by Get when the key does not exist.
+var ErrNotFound = errors.New("blob not found")
+
+// Info describes a stored object.
+type Info struct {
+	ContentType string
+	Size        int64
+}
+
+// Store is the object-storage port used for message attachments. Bodies are
+// streamed in and out; nothing is buffered whole in memory.
+type Store interface {
+	Put(ctx context.Context, key string, r io.Reader, contentType string) (int64, error)
+	Get(ctx context.Context, key string) (io.ReadCloser, Info, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// Ref is what travels in a Kafka command instead of the bytes themselves.
+type Ref struct {
+	Key         string `json:"key"`
+	Filename    string `json:"filename,omitempty"`
+	ContentType string `json:"content_type"`
+	Size        int64  `json:"size"`
+	SHA256      string `json:"sha256"`
+}
+
+// Map converts the ref to the map form used in Command.Payload.
+func (r Ref) Map() map[string]any {
+	return map[string]any{
+		"key":          r.Key,
+		"filename":     r.Filename,
+		"content_type": r.ContentType,
+		"size":         r.Size,
+		"sha256":       r.SHA256,
+	}
+}
+
+// RefFromMap is the inverse of Map; ok is false when m has no key.
+func RefFromMap(m map[string]any) (Ref, bool) {
+	var r Ref
+	r.Key, _ = m["key"].(string)
+	if r.Key == "" {
+		return Ref{}, false
+	}
+	r.Filename, _ = m["filename"].(string)
+	r.ContentType, _ = m["content_type"].(string)
+	r.SHA256, _ = m["sha256"].(string)
+	switch n := m["size"].(type) { // JSON numbers decode as float64
+	case float64:
+		r.Size = int64(n)
+	case int64:
+		r.Size = n
+	}
+	return r, true
+}
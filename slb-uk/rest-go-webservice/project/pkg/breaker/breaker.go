@@ -0,0 +1,167 @@
+// Package breaker is a circuit breaker for calls to a dependency that can
+// go away, such as a Kafka produce. While the dependency is down every call
+// would otherwise wait out its full timeout; an open breaker fails them at
+// once instead and tells the caller when to come back.
+//
+//   - closed: calls go through; Failures consecutive errors open it.
+//   - open: calls fail with *OpenError until OpenFor has passed.
+//   - half-open: up to Probes calls go through. One success closes the
+//     breaker, one failure opens it again for another OpenFor.
+package breaker
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// State of a breaker.
+type State int
+
+const (
+	Closed State = iota
+	HalfOpen
+	Open
+)
+
+func (s State) String() string {
+	switch s {
+	case Closed:
+		return "closed"
+	case HalfOpen:
+		return "half-open"
+	case Open:
+		return "open"
+	}
+	return fmt.Sprintf("State(%d)", int(s))
+}
+
+// Settings tune a Breaker. Failures 0 disables it: every call goes through.
+type Settings struct {
+	Failures int           // consecutive failures that open the breaker
+	OpenFor  time.Duration // how long it stays open before probing
+	Probes   int           // concurrent calls allowed while half-open; default 1
+
+	// OnStateChange is called with the breaker's lock held; keep it short.
+	OnStateChange func(name string, from, to State)
+}
+
+// OpenError is returned instead of calling through an open breaker.
+type OpenError struct {
+	Name       string
+	RetryAfter time.Duration // until the breaker lets a probe through
+}
+
+func (e *OpenError) Error() string {
+	return fmt.Sprintf("circuit %s open, retry after %s", e.Name, e.RetryAfter.Round(time.Second))
+}
+
+// Breaker guards one dependency. It is safe for concurrent use.
+type Breaker struct {
+	name string
+	s    Settings
+	now  func() time.Time
+
+	mu       sync.Mutex
+	state    State
+	failures int       // consecutive, while closed
+	openedAt time.Time // while open
+	probing  int       // calls in flight while half-open
+}
+
+func New(name string, s Settings) *Breaker {
+	if s.Probes < 1 {
+		s.Probes = 1
+	}
+	return &Breaker{name: name, s: s, now: time.Now}
+}
+
+func (b *Breaker) Name() string { return b.name }
+
+// State reports the current state; an open breaker whose OpenFor has passed
+// reports half-open.
+func (b *Breaker) State() State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.expire()
+	return b.state
+}
+
+// Do calls fn unless the breaker is open and records its result.
+func (b *Breaker) Do(fn func() error) error {
+	if err := b.allow(); err != nil {
+		return err
+	}
+	err := fn()
+	b.record(err)
+	return err
+}
+
+func (b *Breaker) allow() error {
+	if b.s.Failures <= 0 {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.expire()
+	switch b.state {
+	case Open:
+		return &OpenError{Name: b.name, RetryAfter: b.openedAt.Add(b.s.OpenFor).Sub(b.now())}
+	case HalfOpen:
+		if b.probing >= b.s.Probes {
+			// the probes decide; others wait about as long as a probe takes
+			return &OpenError{Name: b.name, RetryAfter: time.Second}
+		}
+		b.probing++
+	}
+	return nil
+}
+
+func (b *Breaker) record(err error) {
+	if b.s.Failures <= 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case Closed:
+		if err == nil {
+			b.failures = 0
+		} else if b.failures++; b.failures >= b.s.Failures {
+			b.setState(Open)
+		}
+	case HalfOpen:
+		if b.probing > 0 { // not a probe from an earlier half-open spell
+			b.probing--
+		}
+		if err == nil {
+			b.setState(Closed)
+		} else {
+			b.setState(Open)
+		}
+	case Open:
+		// a call let through before another one opened the breaker
+	}
+}
+
+// expire moves an open breaker to half-open once OpenFor has passed.
+func (b *Breaker) expire() {
+	if b.state == Open && !b.now().Before(b.openedAt.Add(b.s.OpenFor)) {
+		b.setState(HalfOpen)
+	}
+}
+
+func (b *Breaker) setState(to State) {
+	from := b.state
+	b.state = to
+	b.failures = 0
+	switch to {
+	case Open:
+		b.openedAt = b.now()
+	case HalfOpen:
+		b.probing = 0
+	}
+	if from != to && b.s.OnStateChange != nil {
+		b.s.OnStateChange(b.name, from, to)
+	}
+}
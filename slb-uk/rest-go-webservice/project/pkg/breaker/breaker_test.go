@@ -0,0 +1,165 @@
+package breaker
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+var errDown = errors.New("kafka down")
+
+// clock is a fake time source moved forward by hand.
+type clock struct{ t time.Time }
+
+func (c *clock) now() time.Time          { return c.t }
+func (c *clock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+type transition struct{ from, to State }
+
+func newTestBreaker(s Settings) (*Breaker, *clock, *[]transition) {
+	var seen []transition
+	s.OnStateChange = func(_ string, from, to State) { seen = append(seen, transition{from, to}) }
+	b := New("Create", s)
+	c := &clock{t: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	b.now = c.now
+	return b, c, &seen
+}
+
+func fail() error { return errDown }
+func ok() error   { return nil }
+
+func TestOpensAfterConsecutiveFailures(t *testing.T) {
+	b, _, seen := newTestBreaker(Settings{Failures: 3, OpenFor: 10 * time.Second})
+	_ = b.Do(fail)
+	_ = b.Do(fail)
+	_ = b.Do(ok) // a success resets the count
+	_ = b.Do(fail)
+	_ = b.Do(fail)
+	if b.State() != Closed {
+		t.Fatalf("state %s after 2 consecutive failures", b.State())
+	}
+	_ = b.Do(fail)
+	if b.State() != Open {
+		t.Fatalf("state %s after 3 consecutive failures", b.State())
+	}
+	if len(*seen) != 1 || (*seen)[0] != (transition{Closed, Open}) {
+		t.Fatalf("transitions %v", *seen)
+	}
+}
+
+func TestOpenFailsFastUntilOpenForPasses(t *testing.T) {
+	b, c, _ := newTestBreaker(Settings{Failures: 1, OpenFor: 10 * time.Second})
+	_ = b.Do(fail)
+	c.advance(4 * time.Second)
+
+	called := false
+	err := b.Do(func() error { called = true; return nil })
+	var open *OpenError
+	if !errors.As(err, &open) || called {
+		t.Fatalf("err %v, called %v", err, called)
+	}
+	if open.Name != "Create" || open.RetryAfter != 6*time.Second {
+		t.Fatalf("OpenError %+v", open)
+	}
+
+	c.advance(6 * time.Second)
+	if b.State() != HalfOpen {
+		t.Fatalf("state %s once OpenFor passed", b.State())
+	}
+}
+
+func TestHalfOpen(t *testing.T) {
+	cases := []struct {
+		name  string
+		probe func() error
+		want  State
+		trans []transition
+	}{
+		{"probe succeeds", ok, Closed, []transition{{Closed, Open}, {Open, HalfOpen}, {HalfOpen, Closed}}},
+		{"probe fails", fail, Open, []transition{{Closed, Open}, {Open, HalfOpen}, {HalfOpen, Open}}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			b, c, seen := newTestBreaker(Settings{Failures: 1, OpenFor: 10 * time.Second})
+			_ = b.Do(fail)
+			c.advance(10 * time.Second)
+
+			// while the probe is in flight, other calls are turned away
+			var during error
+			_ = b.Do(func() error {
+				during = b.Do(ok)
+				return tc.probe()
+			})
+			var open *OpenError
+			if !errors.As(during, &open) || open.RetryAfter != time.Second {
+				t.Fatalf("call during the probe: %v", during)
+			}
+
+			if b.State() != tc.want {
+				t.Fatalf("state %s", b.State())
+			}
+			if len(*seen) != len(tc.trans) {
+				t.Fatalf("transitions %v", *seen)
+			}
+			for i := range tc.trans {
+				if (*seen)[i] != tc.trans[i] {
+					t.Fatalf("transitions %v, want %v", *seen, tc.trans)
+				}
+			}
+		})
+	}
+}
+
+func TestReopenedBreakerWaitsOpenForAgain(t *testing.T) {
+	b, c, _ := newTestBreaker(Settings{Failures: 1, OpenFor: 10 * time.Second})
+	_ = b.Do(fail)
+	c.advance(10 * time.Second)
+	_ = b.Do(fail) // the probe fails
+
+	c.advance(9 * time.Second)
+	var open *OpenError
+	if err := b.Do(ok); !errors.As(err, &open) || open.RetryAfter != time.Second {
+		t.Fatalf("err %v, 9s after reopening", err)
+	}
+	c.advance(time.Second)
+	if err := b.Do(ok); err != nil || b.State() != Closed {
+		t.Fatalf("err %v, state %s", err, b.State())
+	}
+}
+
+func TestProbes(t *testing.T) {
+	b, c, _ := newTestBreaker(Settings{Failures: 1, OpenFor: time.Second, Probes: 2})
+	_ = b.Do(fail)
+	c.advance(time.Second)
+
+	// two probes in flight; a third call is turned away
+	var second, third error
+	_ = b.Do(func() error {
+		second = b.Do(func() error {
+			third = b.Do(ok)
+			return nil
+		})
+		return nil
+	})
+	if second != nil {
+		t.Fatalf("second probe: %v", second)
+	}
+	if third == nil {
+		t.Fatal("a third call went through with Probes 2")
+	}
+	if b.State() != Closed {
+		t.Fatalf("state %s", b.State())
+	}
+}
+
+func TestDisabled(t *testing.T) {
+	b, _, seen := newTestBreaker(Settings{})
+	for i := 0; i < 10; i++ {
+		if err := b.Do(fail); err != errDown {
+			t.Fatalf("err %v", err)
+		}
+	}
+	if b.State() != Closed || len(*seen) != 0 {
+		t.Fatalf("state %s, transitions %v", b.State(), *seen)
+	}
+}
@@ -0,0 +1,157 @@
+// Package bulk selects the messages of the admin DeleteByQuery and
+// UpdateByQuery commands. apisvc validates the query of
+// POST /v1/admin/messages:deleteByQuery and :updateByQuery and sends it as
+// the command payload; consumersvc counts the matching messages once and
+// then locks and changes them a chunk at a time, each chunk in its own
+// transaction.
+package bulk
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+)
+
+// MaxContainsLen bounds Query.Contains.
+const MaxContainsLen = 1000
+
+// Query selects messages of one tenant. The criteria combine; at least one
+// is required, so an empty body never matches every message. MinID 1 does,
+// on purpose.
+type Query struct {
+	TenantID string    `json:"-"`
+	MinID    int64     `json:"min_id,omitempty"`   // inclusive
+	MaxID    int64     `json:"max_id,omitempty"`   // inclusive
+	Contains string    `json:"contains,omitempty"` // substring of the message; the collation decides about case
+	Since    time.Time `json:"since"`              // created_at, inclusive
+	Until    time.Time `json:"until"`              // created_at, exclusive
+	// Except leaves out messages that already read so, so an UpdateByQuery
+	// run again after an interruption only counts what is left to do.
+	Except *string `json:"-"`
+}
+
+// DeleteRequest is the body of deleteByQuery and the DeleteByQuery payload.
+type DeleteRequest struct {
+	Query Query `json:"query"`
+}
+
+// UpdateRequest is the body of updateByQuery and the UpdateByQuery payload:
+// every matching message is set to Message.
+type UpdateRequest struct {
+	Query   Query  `json:"query"`
+	Message string `json:"message"`
+}
+
+// Validate checks q before it is sent or run.
+func (q Query) Validate() error {
+	var errs []error
+	if q.MinID == 0 && q.MaxID == 0 && q.Contains == "" && q.Since.IsZero() && q.Until.IsZero() {
+		errs = append(errs, errors.New("query: at least one of min_id, max_id, contains, since and until is required"))
+	}
+	if q.MinID < 0 || q.MaxID < 0 {
+		errs = append(errs, errors.New("query: ids must be positive"))
+	}
+	if q.MinID > 0 && q.MaxID > 0 && q.MinID > q.MaxID {
+		errs = append(errs, errors.New("query: min_id is above max_id"))
+	}
+	if !q.Since.IsZero() && !q.Until.IsZero() && !q.Since.Before(q.Until) {
+		errs = append(errs, errors.New("query: since must be before until"))
+	}
+	if len(q.Contains) > MaxContainsLen {
+		errs = append(errs, errors.New("query: contains is too long"))
+	}
+	return errors.Join(errs...)
+}
+
+// Querier is satisfied by *sql.DB and *sql.Tx.
+type Querier interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+func (q Query) where() (string, []any) {
+	where := []string{"tenant_id=?"}
+	args := []any{q.TenantID}
+	if q.MinID > 0 {
+		where = append(where, "id>=?")
+		args = append(args, q.MinID)
+	}
+	if q.MaxID > 0 {
+		where = append(where, "id<=?")
+		args = append(args, q.MaxID)
+	}
+	if q.Contains != "" {
+		where = append(where, "message LIKE ?")
+		args = append(args, "%"+likeEscaper.Replace(q.Contains)+"%")
+	}
+	if !q.Since.IsZero() {
+		where = append(where, "created_at>=?")
+		args = append(args, q.Since.UTC())
+	}
+	if !q.Until.IsZero() {
+		where = append(where, "created_at<?")
+		args = append(args, q.Until.UTC())
+	}
+	if q.Except != nil {
+		where = append(where, "message<>?")
+		args = append(args, *q.Except)
+	}
+	return strings.Join(where, " AND "), args
+}
+
+// Count returns how many messages q matches and the highest of their ids.
+// Chunks stop at that id, so messages created during the run are left
+// alone.
+func Count(ctx context.Context, db Querier, q Query) (n, last int64, err error) {
+	where, args := q.where()
+	err = db.QueryRowContext(ctx, "SELECT COUNT(*), COALESCE(MAX(id), 0) FROM messages WHERE "+where, args...).Scan(&n, &last)
+	return n, last, err
+}
+
+// Row is a matching message as it was before the change.
+type Row struct {
+	ID      int64
+	Message string
+}
+
+// Chunk locks and returns up to limit messages matching q with ids in
+// (after, last], in id order, for the caller's transaction to change. An
+// empty chunk is the end.
+func Chunk(ctx context.Context, tx Querier, q Query, after, last int64, limit int) ([]Row, error) {
+	where, args := q.where()
+	args = append(args, after, last, limit)
+	rows, err := tx.QueryContext(ctx, "SELECT id, message FROM messages WHERE "+where+
+		" AND id>? AND id<=? ORDER BY id LIMIT ? FOR UPDATE", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []Row
+	for rows.Next() {
+		var r Row
+		if err := rows.Scan(&r.ID, &r.Message); err != nil {
+			return nil, err
+		}
+		out = append(out, r)
+	}
+	return out, rows.Err()
+}
+
+// Placeholders returns "?,?,?" for n values.
+func Placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?,", n), ",")
+}
+
+// Percent is the progress of processed out of total, 100 when there is
+// nothing to do. It stays below 100 until the run is over, even when rows
+// that came to match meanwhile push processed past total.
+func Percent(processed, total int64) int {
+	if total <= 0 {
+		return 100
+	}
+	return int(min(processed*100/total, 99))
+}
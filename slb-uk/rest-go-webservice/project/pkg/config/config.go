@@ -0,0 +1,484 @@
+// Package config is where apisvc, consumersvc and dlqreplay get their
+// settings. Each setting comes from, last one winning: its default, the
+// YAML file named by -config or CONFIG_FILE, its environment variable, and
+// its flag.
+//
+//	# apisvc.yaml
+//	kafka:
+//	  brokers: [kafka-0:9092, kafka-1:9092]
+//	read_cache: { store: redis, ttl: 10m }
+//
+//	CONFIG_FILE=apisvc.yaml READ_CACHE_TTL=1m apisvc -stream-timeout 2m
+//
+// Fields declare their variable and default in tags; the flag is the
+// variable in lower case with dashes (KAFKA_BROKERS is -kafka-brokers), and
+// `apisvc -h` lists them all. Load validates the result, so a bad value
+// stops the service at startup instead of being ignored or failing on first
+// use. That includes the settings of pkg/auth, pkg/observability and
+// pkg/health, which take them as a Config from here and read no variables
+// themselves.
+package config
+
+import (
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/golang-jwt/jwt/v5"
+
+	"github.com/slb-uk/rest-go-webservice/project/pkg/auth"
+	"github.com/slb-uk/rest-go-webservice/project/pkg/contracts"
+	"github.com/slb-uk/rest-go-webservice/project/pkg/deployment"
+	"github.com/slb-uk/rest-go-webservice/project/pkg/health"
+	kafkahelper "github.com/slb-uk/rest-go-webservice/project/pkg/kafka"
+	"github.com/slb-uk/rest-go-webservice/project/pkg/observability"
+	"github.com/slb-uk/rest-go-webservice/project/pkg/startup"
+	"github.com/slb-uk/rest-go-webservice/project/pkg/tenant"
+)
+
+// Kafka is shared by both services.
+type Kafka struct {
+	Brokers       []string `yaml:"brokers" env:"KAFKA_BROKERS" default:"kafka:9092" usage:"bootstrap brokers, comma-separated"`
+	CommandsTopic string   `yaml:"commands_topic" env:"KAFKA_TOPIC_COMMANDS" default:"messages.commands" usage:"topic commands are produced to"`
+	AcksTopic     string   `yaml:"acks_topic" env:"KAFKA_TOPIC_ACKS" default:"messages.acks" usage:"topic acks are produced to"`
+	Codec         string   `yaml:"codec" env:"KAFKA_CODEC" default:"json" usage:"payload encoding: json or protobuf"`
+	ClientID      string   `yaml:"client_id" env:"KAFKA_CLIENT_ID" usage:"client.id the brokers see (default sarama)"`
+	Compression   string   `yaml:"compression" env:"KAFKA_COMPRESSION" default:"none" usage:"none, gzip, snappy, lz4 or zstd"`
+
+	// With a URL, protobuf values are registered and framed with their
+	// schema id; see contracts.RegistryCodec.
+	SchemaRegistry struct {
+		URL      string `yaml:"url" env:"KAFKA_SCHEMA_REGISTRY_URL" usage:"Confluent Schema Registry for KAFKA_CODEC=protobuf; empty sends unframed protobuf"`
+		Subjects string `yaml:"subjects" env:"KAFKA_SCHEMA_SUBJECTS" default:"topic" usage:"subject name strategy: topic, record or topic-record"`
+		User     string `yaml:"user" env:"KAFKA_SCHEMA_REGISTRY_USER"`
+		Password string `yaml:"password" env:"KAFKA_SCHEMA_REGISTRY_PASSWORD"`
+	} `yaml:"schema_registry"`
+
+	TLS struct {
+		Enabled            bool   `yaml:"enabled" env:"KAFKA_TLS" usage:"connect to the brokers over TLS"`
+		CAFile             string `yaml:"ca_file" env:"KAFKA_TLS_CA_FILE" usage:"PEM CA bundle instead of the system roots"`
+		CertFile           string `yaml:"cert_file" env:"KAFKA_TLS_CERT_FILE" usage:"client certificate for mutual TLS"`
+		KeyFile            string `yaml:"key_file" env:"KAFKA_TLS_KEY_FILE" usage:"its private key"`
+		InsecureSkipVerify bool   `yaml:"insecure_skip_verify" env:"KAFKA_TLS_INSECURE_SKIP_VERIFY" usage:"do not verify the brokers' certificates"`
+	} `yaml:"tls"`
+	SASL struct {
+		Mechanism string `yaml:"mechanism" env:"KAFKA_SASL_MECHANISM" usage:"PLAIN, SCRAM-SHA-256 or SCRAM-SHA-512; empty is no SASL"`
+		User      string `yaml:"user" env:"KAFKA_SASL_USER"`
+		Password  string `yaml:"password" env:"KAFKA_SASL_PASSWORD"`
+	} `yaml:"sasl"`
+}
+
+// Options are the connection settings for pkg/kafka.
+func (k Kafka) Options() kafkahelper.Options {
+	return kafkahelper.Options{
+		ClientID:    k.ClientID,
+		Compression: k.Compression,
+		TLS: kafkahelper.TLSOptions{Enabled: k.TLS.Enabled, CAFile: k.TLS.CAFile, CertFile: k.TLS.CertFile,
+			KeyFile: k.TLS.KeyFile, InsecureSkipVerify: k.TLS.InsecureSkipVerify},
+		SASL: kafkahelper.SASLOptions{Mechanism: k.SASL.Mechanism, User: k.SASL.User, Password: k.SASL.Password},
+	}
+}
+
+// Registry is the Schema Registry client, or nil without
+// KAFKA_SCHEMA_REGISTRY_URL.
+func (k Kafka) Registry() *contracts.Registry {
+	sr := k.SchemaRegistry
+	if sr.URL == "" {
+		return nil
+	}
+	return &contracts.Registry{URL: sr.URL, User: sr.User, Password: sr.Password,
+		Client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+// Tenancy is shared by both services.
+type Tenancy struct {
+	Tenants     string `yaml:"tenants" env:"TENANTS" usage:"tenant ids besides the default one, comma-separated"`
+	TopicPrefix bool   `yaml:"topic_prefix" env:"TENANT_TOPIC_PREFIX" usage:"give every tenant its own <tenant>.<topic> topics"`
+	tenantIDs   []string
+}
+
+// IDs is the parsed tenant list, the default tenant first.
+func (t Tenancy) IDs() []string { return t.tenantIDs }
+
+// Startup is shared by both services.
+type Startup struct {
+	MaxWait    time.Duration `yaml:"max_wait" env:"STARTUP_MAX_WAIT" default:"2m" usage:"how long to wait for Kafka, MySQL and Redis at startup; 0 tries once"`
+	Backoff    time.Duration `yaml:"backoff" env:"STARTUP_BACKOFF" default:"500ms" usage:"first retry delay, doubled after each attempt"`
+	MaxBackoff time.Duration `yaml:"max_backoff" env:"STARTUP_MAX_BACKOFF" default:"10s" usage:"longest retry delay"`
+}
+
+// Policy is the retry policy for pkg/startup.
+func (s Startup) Policy() startup.Policy {
+	return startup.Policy{MaxWait: s.MaxWait, Backoff: s.Backoff, MaxBackoff: s.MaxBackoff}
+}
+
+// Observability is shared by both services; see pkg/observability.
+type Observability struct {
+	ServiceName   string  `yaml:"service_name" env:"OTEL_SERVICE_NAME" usage:"service name on traces and logs (default the binary's name)"`
+	Version       string  `yaml:"version" env:"SERVICE_VERSION" default:"dev" usage:"service version on traces"`
+	TraceExporter string  `yaml:"trace_exporter" env:"OTEL_TRACES_EXPORTER" default:"none" usage:"otlp (OTLP over HTTP), stdout or none"`
+	OTLPEndpoint  string  `yaml:"otlp_endpoint" env:"OTEL_EXPORTER_OTLP_ENDPOINT" usage:"collector host:port; an https:// prefix turns on TLS"`
+	SampleRatio   float64 `yaml:"sample_ratio" env:"OTEL_TRACES_SAMPLER_ARG" default:"1" usage:"share of new traces sampled, 0-1; child spans follow their parent"`
+	MetricsAddr   string  `yaml:"metrics_addr" env:"METRICS_ADDR" default:":9102" usage:"address of the Prometheus /metrics listener; off disables it"`
+	LogLevel      string  `yaml:"log_level" env:"LOG_LEVEL" default:"info" usage:"debug, info, warn or error"`
+	LogFormat     string  `yaml:"log_format" env:"LOG_FORMAT" default:"text" usage:"text or json"`
+	logLevel      slog.Level
+}
+
+// Config is the pkg/observability configuration of service.
+func (o Observability) Config(service string) observability.Config {
+	if o.ServiceName != "" {
+		service = o.ServiceName
+	}
+	return observability.Config{
+		Service:       service,
+		Version:       o.Version,
+		TraceExporter: o.TraceExporter,
+		OTLPEndpoint:  strings.TrimPrefix(strings.TrimPrefix(o.OTLPEndpoint, "http://"), "https://"),
+		OTLPInsecure:  !strings.HasPrefix(o.OTLPEndpoint, "https://"),
+		SampleRatio:   o.SampleRatio,
+		MetricsAddr:   o.MetricsAddr,
+		LogLevel:      o.logLevel,
+		LogFormat:     o.LogFormat,
+	}
+}
+
+// Health is shared by both services; see pkg/health.
+type Health struct {
+	CheckTimeout time.Duration `yaml:"check_timeout" env:"HEALTH_CHECK_TIMEOUT" default:"2s" usage:"longest a single dependency check may take"`
+	FailAfter    time.Duration `yaml:"fail_after" env:"HEALTH_FAIL_AFTER" default:"2m" usage:"how long a check fails in a row before /healthz fails too"`
+}
+
+// Checker is a health.Checker with these timeouts.
+func (h Health) Checker() *health.Checker {
+	c := health.New()
+	c.Timeout, c.FailAfter = h.CheckTimeout, h.FailAfter
+	return c
+}
+
+// Auth is apisvc's JWT verification; see pkg/auth.
+type Auth struct {
+	Routes      []string      `yaml:"routes" env:"AUTH_JWT_ROUTES" usage:"path prefixes that need a bearer token, comma-separated; none protects nothing"`
+	HS256Secret string        `yaml:"hs256_secret" env:"AUTH_JWT_HS256_SECRET" usage:"shared secret; enables HS256"`
+	RS256Key    string        `yaml:"rs256_key" env:"AUTH_JWT_RS256_KEY" usage:"PEM RSA public key file; enables RS256"`
+	JWKSURL     string        `yaml:"jwks_url" env:"AUTH_JWT_JWKS_URL" usage:"JWKS endpoint; enables RS256 with the key picked by the token's kid"`
+	Issuer      string        `yaml:"issuer" env:"AUTH_JWT_ISSUER" usage:"required iss"`
+	Audience    string        `yaml:"audience" env:"AUTH_JWT_AUDIENCE" usage:"required aud"`
+	Scope       string        `yaml:"scope" env:"AUTH_JWT_SCOPE" usage:"scope every protected request needs"`
+	AdminScope  string        `yaml:"admin_scope" env:"AUTH_JWT_ADMIN_SCOPE" default:"admin" usage:"scope the /v1/admin routes need as well"`
+	TenantClaim string        `yaml:"tenant_claim" env:"AUTH_JWT_TENANT_CLAIM" default:"tenant_id" usage:"claim that names the tenant"`
+	Leeway      time.Duration `yaml:"leeway" env:"AUTH_JWT_LEEWAY" default:"30s" usage:"clock skew allowed on exp and nbf"`
+	rsaKey      *rsa.PublicKey
+}
+
+// Config is the pkg/auth configuration.
+func (a Auth) Config() auth.Config {
+	c := auth.Config{Routes: a.Routes, RSAKey: a.rsaKey, Issuer: a.Issuer, Audience: a.Audience,
+		Scope: a.Scope, AdminScope: a.AdminScope, TenantClaim: a.TenantClaim, Leeway: a.Leeway}
+	if a.HS256Secret != "" {
+		c.HMACSecret = []byte(a.HS256Secret)
+	}
+	if a.JWKSURL != "" {
+		c.JWKS = auth.NewJWKS(a.JWKSURL)
+	}
+	return c
+}
+
+// API is apisvc's configuration.
+type API struct {
+	Kafka         Kafka         `yaml:"kafka"`
+	Tenancy       Tenancy       `yaml:"tenancy"`
+	Startup       Startup       `yaml:"startup"`
+	Observability Observability `yaml:"observability"`
+	Health        Health        `yaml:"health"`
+	Auth          Auth          `yaml:"auth"`
+
+	Addr            string `yaml:"addr" env:"API_HTTP_ADDR" default:":8080" usage:"HTTP listen address"`
+	ProblemTypeBase string `yaml:"problem_type_base" env:"PROBLEM_TYPE_BASE" default:"https://example.com/problems/" usage:"prefix of problem+json type URIs"`
+	AccessLog       bool   `yaml:"access_log" env:"ACCESS_LOG" default:"true" usage:"log one line per request"`
+	RedisAddr       string `yaml:"redis_addr" env:"REDIS_ADDR" default:"redis:6379" usage:"Redis for the redis stores"`
+
+	CanaryRouting string  `yaml:"canary_routing" env:"CANARY_ROUTING" usage:"how canary commands are routed: header or topic"`
+	CanaryPercent float64 `yaml:"canary_percent" env:"CANARY_PERCENT" usage:"share of commands sent to the canary, 0-100"`
+
+	Breaker struct {
+		Failures int           `yaml:"failures" env:"KAFKA_BREAKER_FAILURES" default:"5" usage:"produce failures in a row that open an endpoint's breaker; 0 disables"`
+		Open     time.Duration `yaml:"open" env:"KAFKA_BREAKER_OPEN" default:"30s" usage:"how long an open breaker fails fast"`
+		Probes   int           `yaml:"probes" env:"KAFKA_BREAKER_PROBES" default:"1" usage:"successful half-open probes that close it"`
+	} `yaml:"breaker"`
+
+	Attachments struct {
+		Store       string `yaml:"store" env:"BLOB_STORE" default:"fs" usage:"fs, s3 (minio) or none"`
+		Dir         string `yaml:"dir" env:"BLOB_DIR" default:"/var/lib/apisvc/blobs" usage:"directory of the fs store"`
+		S3Endpoint  string `yaml:"s3_endpoint" env:"S3_ENDPOINT" default:"minio:9000"`
+		S3Bucket    string `yaml:"s3_bucket" env:"S3_BUCKET" default:"attachments"`
+		S3AccessKey string `yaml:"s3_access_key" env:"S3_ACCESS_KEY"`
+		S3SecretKey string `yaml:"s3_secret_key" env:"S3_SECRET_KEY"`
+		S3UseSSL    bool   `yaml:"s3_use_ssl" env:"S3_USE_SSL"`
+		MaxBytes    int64  `yaml:"max_bytes" env:"MAX_ATTACHMENT_BYTES" default:"10485760" usage:"largest upload accepted"`
+	} `yaml:"attachments"`
+
+	AckStore struct {
+		Store string        `yaml:"store" env:"ACK_STORE" default:"memory" usage:"memory or redis"`
+		TTL   time.Duration `yaml:"ttl" env:"ACK_TTL" default:"2m" usage:"how long results stay readable"`
+	} `yaml:"ack_store"`
+	ReadCache struct {
+		Store string        `yaml:"store" env:"READ_CACHE" default:"memory" usage:"memory, redis or off"`
+		TTL   time.Duration `yaml:"ttl" env:"READ_CACHE_TTL" default:"5m"`
+	} `yaml:"read_cache"`
+	ReadModel struct {
+		DSN      string        `yaml:"dsn" env:"READ_MODEL_DSN" usage:"MySQL (or a replica) GET and list requests read from; empty sends reads through Kafka"`
+		MaxConns int           `yaml:"max_conns" env:"READ_MODEL_MAX_CONNS" default:"10" usage:"open connections to READ_MODEL_DSN"`
+		Timeout  time.Duration `yaml:"timeout" env:"READ_MODEL_TIMEOUT" default:"2s" usage:"longest read model query"`
+	} `yaml:"read_model"`
+	Idempotency struct {
+		Store string        `yaml:"store" env:"IDEMPOTENCY_STORE" default:"memory" usage:"memory, redis or off"`
+		TTL   time.Duration `yaml:"ttl" env:"IDEMPOTENCY_TTL" default:"24h" usage:"how long an Idempotency-Key is remembered"`
+	} `yaml:"idempotency"`
+
+	StreamTimeout time.Duration `yaml:"stream_timeout" env:"STREAM_TIMEOUT" default:"5m" usage:"longest /v1/operations/stream connection"`
+	SSETimeout    time.Duration `yaml:"sse_timeout" env:"SSE_TIMEOUT" default:"60s" usage:"longest /v1/operations/{id}/events connection"`
+	SSEKeepAlive  time.Duration `yaml:"sse_keepalive" env:"SSE_KEEPALIVE" default:"15s" usage:"comment sent this often on idle event streams"`
+	PendingTTL    time.Duration `yaml:"pending_ttl" env:"PENDING_TTL" default:"5m" usage:"commands without an ack after this count as lost"`
+}
+
+// Consumer is consumersvc's configuration.
+type Consumer struct {
+	Kafka         Kafka         `yaml:"kafka"`
+	Tenancy       Tenancy       `yaml:"tenancy"`
+	Startup       Startup       `yaml:"startup"`
+	Observability Observability `yaml:"observability"`
+	Health        Health        `yaml:"health"`
+
+	MySQLDSN      string          `yaml:"mysql_dsn" env:"MYSQL_DSN" default:"root:root@tcp(mysql:3306)/app?parseTime=true" usage:"go-sql-driver DSN"`
+	DLQTopic      string          `yaml:"dlq_topic" env:"KAFKA_TOPIC_DLQ" default:"messages.commands.dlq" usage:"topic commands that cannot be processed are moved to"`
+	HealthAddr    string          `yaml:"health_addr" env:"HEALTH_ADDR" default:":8081" usage:"address of /healthz and /readyz"`
+	Track         string          `yaml:"track" env:"DEPLOYMENT_TRACK" default:"stable" usage:"stable or canary"`
+	CanaryRouting string          `yaml:"canary_routing" env:"CANARY_ROUTING" usage:"how canary commands are routed: header or topic"`
+	Workers       int             `yaml:"workers" env:"CONSUMER_WORKERS" default:"4" usage:"commands of one partition processed at once; a key's commands stay in order"`
+	MaxInFlight   int             `yaml:"max_in_flight" env:"CONSUMER_MAX_IN_FLIGHT" default:"256" usage:"most commands per partition taken ahead of the committed offset"`
+	RetryDelays   []time.Duration `yaml:"retry_delays" env:"CONSUMER_RETRY_DELAYS" default:"5s,30s,2m" usage:"a command failing on a transient database error is tried again after each delay, through <KAFKA_TOPIC_COMMANDS>.retry.<delay>, before it is dead-lettered"`
+	BulkChunk     int             `yaml:"bulk_chunk" env:"CONSUMER_BULK_CHUNK" default:"500" usage:"messages changed per transaction by DeleteByQuery and UpdateByQuery; a PROGRESS ack follows each chunk"`
+
+	Migrate struct {
+		OnStart  bool `yaml:"on_start" env:"MIGRATE_ON_START" usage:"apply the migrations built into consumersvc before consuming"`
+		Baseline int  `yaml:"baseline" env:"MIGRATE_BASELINE" usage:"version to record first on a database that has the tables but no schema_migrations, e.g. one set up by docker-entrypoint-initdb.d"`
+	} `yaml:"migrate"`
+
+	Verify struct {
+		Enabled  bool   `yaml:"enabled" env:"VERIFY_MODE" usage:"log every processed command for partitioncheck"`
+		Log      string `yaml:"log" env:"VERIFY_LOG" default:"/var/log/consumersvc/verify.jsonl"`
+		Instance string `yaml:"instance" env:"INSTANCE_ID" usage:"name in the verify log; default the hostname"`
+	} `yaml:"verify"`
+
+	Scaling struct {
+		CheckInterval  time.Duration `yaml:"check_interval" env:"SCALING_CHECK_INTERVAL" default:"1m"`
+		LagImbalance   float64       `yaml:"lag_imbalance" env:"SCALING_LAG_IMBALANCE" default:"2" usage:"warn when the busiest member lags this many times the mean"`
+		MinLag         int64         `yaml:"min_lag" env:"SCALING_MIN_LAG" default:"1000" usage:"...and lags at least this many records"`
+		AutoPartitions bool          `yaml:"auto_partitions" env:"SCALING_AUTO_PARTITIONS" usage:"add partitions when members outnumber them"`
+		MaxPartitions  int32         `yaml:"max_partitions" env:"SCALING_MAX_PARTITIONS" default:"32"`
+	} `yaml:"scaling"`
+}
+
+var validTopic = regexp.MustCompile(`^[a-zA-Z0-9._-]{1,249}$`)
+
+// Replay is for cmd/dlqreplay, which produces dead-lettered commands
+// again. The filters combine; none of them replays the whole topic.
+type Replay struct {
+	Kafka Kafka `yaml:"kafka"`
+
+	DLQTopic string        `yaml:"dlq_topic" env:"KAFKA_TOPIC_DLQ" default:"messages.commands.dlq" usage:"dead-letter topic to read"`
+	Topic    string        `yaml:"topic" env:"REPLAY_TOPIC" usage:"topic to produce to instead of each record's dlq-original-topic"`
+	Reason   string        `yaml:"reason" env:"REPLAY_REASON" usage:"only records with this dlq-reason"`
+	Key      string        `yaml:"key" env:"REPLAY_KEY" usage:"only records with this key"`
+	Since    time.Duration `yaml:"since" env:"REPLAY_SINCE" usage:"only records dead-lettered in the last duration; 0 is all"`
+	Limit    int           `yaml:"limit" env:"REPLAY_LIMIT" usage:"stop after this many records; 0 is no limit"`
+	DryRun   bool          `yaml:"dry_run" env:"REPLAY_DRY_RUN" usage:"list the records without producing them"`
+}
+
+// check collects the errors of one Validate.
+type check []error
+
+func (c *check) add(env string, ok bool, format string, args ...any) {
+	if !ok {
+		*c = append(*c, fmt.Errorf("%s: "+format, append([]any{env}, args...)...))
+	}
+}
+
+func (c *check) err(env string, err error) {
+	if err != nil {
+		*c = append(*c, fmt.Errorf("%s: %w", env, err))
+	}
+}
+
+func (k Kafka) validate(c *check) {
+	c.add("KAFKA_BROKERS", len(k.Brokers) > 0, "at least one broker")
+	for _, b := range k.Brokers {
+		_, _, err := net.SplitHostPort(b)
+		c.add("KAFKA_BROKERS", err == nil, "%q is not host:port", b)
+	}
+	c.add("KAFKA_TOPIC_COMMANDS", validTopic.MatchString(k.CommandsTopic), "%q is not a topic name", k.CommandsTopic)
+	c.add("KAFKA_TOPIC_ACKS", validTopic.MatchString(k.AcksTopic), "%q is not a topic name", k.AcksTopic)
+	codec, err := contracts.ByName(k.Codec)
+	c.err("KAFKA_CODEC", err)
+	if sr := k.SchemaRegistry; sr.URL != "" {
+		u, err := url.Parse(sr.URL)
+		c.add("KAFKA_SCHEMA_REGISTRY_URL", err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "", "%q is not an http(s) URL", sr.URL)
+		c.add("KAFKA_SCHEMA_REGISTRY_URL", codec == nil || codec.Name() == "protobuf", "needs KAFKA_CODEC=protobuf")
+		_, err = contracts.Subject(sr.Subjects, "", "")
+		c.err("KAFKA_SCHEMA_SUBJECTS", err)
+	}
+	o := k.Options()
+	c.err("KAFKA_COMPRESSION", kafkahelper.Options{Compression: o.Compression}.Validate())
+	c.add("KAFKA_TLS", o.TLS.Enabled || o.TLS.CAFile == "" && o.TLS.CertFile == "", "must be true when TLS files are set")
+	c.err("KAFKA_TLS_CERT_FILE", kafkahelper.Options{TLS: o.TLS}.Validate())
+	c.err("KAFKA_SASL_MECHANISM", kafkahelper.Options{SASL: o.SASL}.Validate())
+}
+
+func (t *Tenancy) validate(c *check) {
+	var err error
+	t.tenantIDs, err = tenant.ParseList(t.Tenants)
+	c.err("TENANTS", err)
+}
+
+func (s Startup) validate(c *check) {
+	c.add("STARTUP_MAX_WAIT", s.MaxWait >= 0, "must be a duration >= 0")
+	c.add("STARTUP_BACKOFF", s.Backoff > 0, "must be a positive duration")
+	c.add("STARTUP_MAX_BACKOFF", s.MaxBackoff >= s.Backoff, "must be at least STARTUP_BACKOFF")
+}
+
+func (o *Observability) validate(c *check) {
+	c.add("OTEL_TRACES_EXPORTER", oneOf(o.TraceExporter, "otlp", "stdout", "none"), "unknown exporter %q", o.TraceExporter)
+	c.add("OTEL_TRACES_SAMPLER_ARG", o.SampleRatio >= 0 && o.SampleRatio <= 1, "must be between 0 and 1")
+	c.add("LOG_LEVEL", o.logLevel.UnmarshalText([]byte(o.LogLevel)) == nil, "unknown level %q", o.LogLevel)
+	c.add("LOG_FORMAT", oneOf(o.LogFormat, "text", "json"), "unknown format %q", o.LogFormat)
+}
+
+func (h Health) validate(c *check) {
+	c.add("HEALTH_CHECK_TIMEOUT", h.CheckTimeout > 0, "must be a positive duration")
+	c.add("HEALTH_FAIL_AFTER", h.FailAfter > 0, "must be a positive duration")
+}
+
+func (a *Auth) validate(c *check) {
+	a.rsaKey = nil
+	if a.RS256Key != "" {
+		pem, err := os.ReadFile(a.RS256Key)
+		if err == nil {
+			a.rsaKey, err = jwt.ParseRSAPublicKeyFromPEM(pem)
+		}
+		c.err("AUTH_JWT_RS256_KEY", err)
+	}
+	if a.JWKSURL != "" {
+		u, err := url.Parse(a.JWKSURL)
+		c.add("AUTH_JWT_JWKS_URL", err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "", "%q is not an http(s) URL", a.JWKSURL)
+	}
+	c.add("AUTH_JWT_ROUTES", len(a.Routes) == 0 || a.HS256Secret != "" || a.RS256Key != "" || a.JWKSURL != "",
+		"needs a key: AUTH_JWT_HS256_SECRET, AUTH_JWT_RS256_KEY or AUTH_JWT_JWKS_URL")
+	c.add("AUTH_JWT_ADMIN_SCOPE", a.AdminScope != "", "must be set")
+	c.add("AUTH_JWT_TENANT_CLAIM", a.TenantClaim != "", "must be set")
+	c.add("AUTH_JWT_LEEWAY", a.Leeway >= 0, "must be a duration >= 0")
+}
+
+func oneOf(v string, allowed ...string) bool {
+	for _, a := range allowed {
+		if v == a {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate checks every field; Load calls it.
+func (a *API) Validate() error {
+	var c check
+	a.Kafka.validate(&c)
+	a.Tenancy.validate(&c)
+	a.Startup.validate(&c)
+	a.Observability.validate(&c)
+	a.Health.validate(&c)
+	a.Auth.validate(&c)
+	c.add("API_HTTP_ADDR", a.Addr != "", "must be set")
+	routing, err := deployment.ParseRouting(a.CanaryRouting)
+	c.err("CANARY_ROUTING", err)
+	a.CanaryRouting = routing
+	c.add("CANARY_PERCENT", a.CanaryPercent >= 0 && a.CanaryPercent <= 100, "must be between 0 and 100")
+	c.add("KAFKA_BREAKER_FAILURES", a.Breaker.Failures >= 0, "must be a count >= 0")
+	c.add("KAFKA_BREAKER_OPEN", a.Breaker.Open > 0, "must be a positive duration")
+	c.add("KAFKA_BREAKER_PROBES", a.Breaker.Probes >= 1, "must be >= 1")
+	c.add("BLOB_STORE", oneOf(a.Attachments.Store, "fs", "s3", "minio", "none"), "unknown store %q", a.Attachments.Store)
+	c.add("MAX_ATTACHMENT_BYTES", a.Attachments.MaxBytes > 0, "must be positive")
+	c.add("ACK_STORE", oneOf(a.AckStore.Store, "memory", "redis"), "unknown store %q", a.AckStore.Store)
+	c.add("READ_CACHE", oneOf(a.ReadCache.Store, "memory", "redis", "off"), "unknown store %q", a.ReadCache.Store)
+	c.add("IDEMPOTENCY_STORE", oneOf(a.Idempotency.Store, "memory", "redis", "off"), "unknown store %q", a.Idempotency.Store)
+	if a.ReadModel.DSN != "" {
+		dsn, err := mysql.ParseDSN(a.ReadModel.DSN)
+		c.err("READ_MODEL_DSN", err)
+		c.add("READ_MODEL_DSN", err != nil || dsn.ParseTime, "needs parseTime=true")
+	}
+	c.add("READ_MODEL_MAX_CONNS", a.ReadModel.MaxConns >= 1, "must be >= 1")
+	for _, d := range []struct {
+		env string
+		d   time.Duration
+	}{{"ACK_TTL", a.AckStore.TTL}, {"READ_CACHE_TTL", a.ReadCache.TTL}, {"IDEMPOTENCY_TTL", a.Idempotency.TTL},
+		{"READ_MODEL_TIMEOUT", a.ReadModel.Timeout}, {"STREAM_TIMEOUT", a.StreamTimeout}, {"SSE_TIMEOUT", a.SSETimeout},
+		{"SSE_KEEPALIVE", a.SSEKeepAlive}, {"PENDING_TTL", a.PendingTTL}} {
+		c.add(d.env, d.d > 0, "must be a positive duration")
+	}
+	return errors.Join(c...)
+}
+
+// Validate checks every field; Load calls it.
+func (s *Consumer) Validate() error {
+	var c check
+	s.Kafka.validate(&c)
+	s.Tenancy.validate(&c)
+	s.Startup.validate(&c)
+	s.Observability.validate(&c)
+	s.Health.validate(&c)
+	c.add("KAFKA_TOPIC_DLQ", validTopic.MatchString(s.DLQTopic), "%q is not a topic name", s.DLQTopic)
+	_, err := mysql.ParseDSN(s.MySQLDSN)
+	c.err("MYSQL_DSN", err)
+	track, err := deployment.Parse(s.Track)
+	c.err("DEPLOYMENT_TRACK", err)
+	if track != "" {
+		s.Track = track
+	}
+	routing, err := deployment.ParseRouting(s.CanaryRouting)
+	c.err("CANARY_ROUTING", err)
+	s.CanaryRouting = routing
+	c.add("CONSUMER_WORKERS", s.Workers > 0, "must be positive")
+	c.add("CONSUMER_MAX_IN_FLIGHT", s.MaxInFlight >= s.Workers, "must be at least CONSUMER_WORKERS")
+	c.add("CONSUMER_BULK_CHUNK", s.BulkChunk > 0 && s.BulkChunk <= 10000, "must be between 1 and 10000")
+	for _, d := range s.RetryDelays {
+		c.add("CONSUMER_RETRY_DELAYS", d > 0, "%s is not a positive duration", d)
+	}
+	c.add("SCALING_CHECK_INTERVAL", s.Scaling.CheckInterval > 0, "must be a positive duration")
+	c.add("SCALING_LAG_IMBALANCE", s.Scaling.LagImbalance >= 1, "must be >= 1")
+	c.add("SCALING_MIN_LAG", s.Scaling.MinLag >= 0, "must be >= 0")
+	c.add("SCALING_MAX_PARTITIONS", s.Scaling.MaxPartitions > 0, "must be positive")
+	c.add("MIGRATE_BASELINE", s.Migrate.Baseline >= 0, "must be >= 0")
+	c.add("MIGRATE_BASELINE", s.Migrate.Baseline == 0 || s.Migrate.OnStart, "only applies with MIGRATE_ON_START")
+	c.add("VERIFY_LOG", !s.Verify.Enabled || s.Verify.Log != "", "must be set with VERIFY_MODE")
+	return errors.Join(c...)
+}
+
+// Validate checks every field; Load calls it.
+func (r *Replay) Validate() error {
+	var c check
+	r.Kafka.validate(&c)
+	c.add("KAFKA_TOPIC_DLQ", validTopic.MatchString(r.DLQTopic), "%q is not a topic name", r.DLQTopic)
+	c.add("REPLAY_TOPIC", r.Topic == "" || validTopic.MatchString(r.Topic), "%q is not a topic name", r.Topic)
+	c.add("REPLAY_TOPIC", r.Topic != r.DLQTopic, "must not be KAFKA_TOPIC_DLQ")
+	c.add("REPLAY_SINCE", r.Since >= 0, "must be a duration >= 0")
+	c.add("REPLAY_LIMIT", r.Limit >= 0, "must be >= 0")
+	return errors.Join(c...)
+}
@@ -0,0 +1,207 @@
+package config
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func loadAPI(t *testing.T, yaml string, env map[string]string, args ...string) (API, error) {
+	t.Helper()
+	if yaml != "" {
+		file := filepath.Join(t.TempDir(), "apisvc.yaml")
+		if err := os.WriteFile(file, []byte(yaml), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		args = append([]string{"-config", file}, args...)
+	}
+	var conf API
+	err := load(&conf, "apisvc", args, func(k string) string { return env[k] }, io.Discard)
+	return conf, err
+}
+
+func TestPrecedence(t *testing.T) {
+	cases := []struct {
+		name string
+		yaml string
+		env  map[string]string
+		args []string
+		want time.Duration
+	}{
+		{"default", "", nil, nil, 5 * time.Minute},
+		{"file", "stream_timeout: 3m", nil, nil, 3 * time.Minute},
+		{"env over file", "stream_timeout: 3m", map[string]string{"STREAM_TIMEOUT": "4m"}, nil, 4 * time.Minute},
+		{"flag over env", "stream_timeout: 3m", map[string]string{"STREAM_TIMEOUT": "4m"}, []string{"-stream-timeout", "2m"}, 2 * time.Minute},
+		{"flag alone", "", nil, []string{"-stream-timeout", "2m"}, 2 * time.Minute},
+		// an empty variable is unset, not a value
+		{"empty env", "stream_timeout: 3m", map[string]string{"STREAM_TIMEOUT": ""}, nil, 3 * time.Minute},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			conf, err := loadAPI(t, tc.yaml, tc.env, tc.args...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if conf.StreamTimeout != tc.want {
+				t.Fatalf("StreamTimeout = %s, want %s", conf.StreamTimeout, tc.want)
+			}
+		})
+	}
+}
+
+func TestLayersMix(t *testing.T) {
+	conf, err := loadAPI(t, `
+kafka:
+  brokers: [kafka-0:9092, kafka-1:9092]
+read_cache: { store: redis, ttl: 10m }
+health: { fail_after: 5m }
+observability: { log_format: json, log_level: warn }
+auth: { routes: [/v1/], hs256_secret: from-file }
+`, map[string]string{
+		"READ_CACHE_TTL":        "1m",
+		"HEALTH_CHECK_TIMEOUT":  "3s",
+		"LOG_LEVEL":             "debug",
+		"AUTH_JWT_HS256_SECRET": "from-env",
+	}, "-auth-jwt-admin-scope", "ops")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(conf.Kafka.Brokers) != 2 || conf.ReadCache.Store != "redis" || conf.ReadCache.TTL != time.Minute {
+		t.Errorf("kafka %v, read cache %+v", conf.Kafka.Brokers, conf.ReadCache)
+	}
+	if conf.SSETimeout != 60*time.Second || conf.Kafka.CommandsTopic != "messages.commands" {
+		t.Errorf("defaults lost: SSETimeout %s, commands topic %q", conf.SSETimeout, conf.Kafka.CommandsTopic)
+	}
+
+	if c := conf.Health.Checker(); c.Timeout != 3*time.Second || c.FailAfter != 5*time.Minute {
+		t.Errorf("health checker timeout %s, fail after %s", c.Timeout, c.FailAfter)
+	}
+
+	o := conf.Observability.Config("apisvc")
+	if o.Service != "apisvc" || o.LogLevel != slog.LevelDebug || o.LogFormat != "json" || o.MetricsAddr != ":9102" || o.SampleRatio != 1 {
+		t.Errorf("observability %+v", o)
+	}
+
+	a := conf.Auth.Config()
+	if !a.Enabled() || string(a.HMACSecret) != "from-env" || a.AdminScope != "ops" || a.TenantClaim != "tenant_id" || a.Leeway != 30*time.Second {
+		t.Errorf("auth %+v", a)
+	}
+}
+
+func TestObservabilityEndpoint(t *testing.T) {
+	for endpoint, insecure := range map[string]bool{
+		"collector:4318":         true,
+		"http://collector:4318":  true,
+		"https://collector:4318": false,
+	} {
+		conf, err := loadAPI(t, "", map[string]string{"OTEL_EXPORTER_OTLP_ENDPOINT": endpoint, "OTEL_SERVICE_NAME": "api"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		o := conf.Observability.Config("apisvc")
+		if o.OTLPEndpoint != "collector:4318" || o.OTLPInsecure != insecure || o.Service != "api" {
+			t.Errorf("%s: %+v", endpoint, o)
+		}
+	}
+}
+
+func TestAuthRS256Key(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(t.TempDir(), "jwt.pub")
+	if err := os.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	conf, err := loadAPI(t, "", map[string]string{"AUTH_JWT_ROUTES": "/v1/", "AUTH_JWT_RS256_KEY": file})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a := conf.Auth.Config(); a.RSAKey == nil || !a.RSAKey.Equal(&key.PublicKey) || a.HMACSecret != nil {
+		t.Fatalf("auth %+v", a)
+	}
+}
+
+func TestValidationErrors(t *testing.T) {
+	cases := []struct {
+		name string
+		env  map[string]string
+		args []string
+		want []string
+	}{
+		{"broker", map[string]string{"KAFKA_BROKERS": "kafka"}, nil, []string{`KAFKA_BROKERS: "kafka" is not host:port`}},
+		{"percent", map[string]string{"CANARY_PERCENT": "101"}, nil, []string{"CANARY_PERCENT: must be between 0 and 100"}},
+		{"read model", map[string]string{"READ_MODEL_DSN": "root@tcp(mysql:3306)/app"}, nil, []string{"READ_MODEL_DSN: needs parseTime=true"}},
+		{"log level", map[string]string{"LOG_LEVEL": "loud"}, nil, []string{`LOG_LEVEL: unknown level "loud"`}},
+		{"exporter", map[string]string{"OTEL_TRACES_EXPORTER": "zipkin"}, nil, []string{`OTEL_TRACES_EXPORTER: unknown exporter "zipkin"`}},
+		{"sampler", map[string]string{"OTEL_TRACES_SAMPLER_ARG": "2"}, nil, []string{"OTEL_TRACES_SAMPLER_ARG: must be between 0 and 1"}},
+		{"health", map[string]string{"HEALTH_FAIL_AFTER": "0s"}, nil, []string{"HEALTH_FAIL_AFTER: must be a positive duration"}},
+		{"auth without key", map[string]string{"AUTH_JWT_ROUTES": "/v1/"}, nil, []string{"AUTH_JWT_ROUTES: needs a key"}},
+		{"auth key file", map[string]string{"AUTH_JWT_RS256_KEY": "/nonexistent.pem"}, nil, []string{"AUTH_JWT_RS256_KEY: open /nonexistent.pem"}},
+		{"jwks", map[string]string{"AUTH_JWT_JWKS_URL": "keys.json"}, nil, []string{`AUTH_JWT_JWKS_URL: "keys.json" is not an http(s) URL`}},
+		{"leeway", nil, []string{"-auth-jwt-leeway", "-1s"}, []string{"AUTH_JWT_LEEWAY: must be a duration >= 0"}},
+		// every problem is reported at once
+		{"several", map[string]string{"ACK_STORE": "disk", "SSE_TIMEOUT": "0s", "LOG_FORMAT": "xml"}, nil,
+			[]string{`ACK_STORE: unknown store "disk"`, "SSE_TIMEOUT: must be a positive duration", `LOG_FORMAT: unknown format "xml"`}},
+		// values that do not parse are named too
+		{"bad duration", map[string]string{"STREAM_TIMEOUT": "soon"}, nil, []string{"STREAM_TIMEOUT: time: invalid duration"}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := loadAPI(t, "", tc.env, tc.args...)
+			if err == nil {
+				t.Fatal("no error")
+			}
+			for _, want := range tc.want {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("error %q does not contain %q", err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	if _, err := loadAPI(t, "read_cache: { size: 10 }", nil); err == nil || !strings.Contains(err.Error(), "field size not found") {
+		t.Errorf("unknown YAML key: %v", err)
+	}
+	if _, err := loadAPI(t, "", nil, "-stream-timeout", "soon"); err == nil {
+		t.Error("bad flag value accepted")
+	}
+	if _, err := loadAPI(t, "", nil, "extra"); err == nil {
+		t.Error("positional argument accepted")
+	}
+	if _, err := loadAPI(t, "", nil, "-h"); !errors.Is(err, flag.ErrHelp) {
+		t.Errorf("-h: %v", err)
+	}
+}
+
+func TestConsumerValidate(t *testing.T) {
+	var conf Consumer
+	env := map[string]string{"CONSUMER_WORKERS": "8", "CONSUMER_MAX_IN_FLIGHT": "4", "MIGRATE_BASELINE": "5", "HEALTH_CHECK_TIMEOUT": "-1s"}
+	err := load(&conf, "consumersvc", nil, func(k string) string { return env[k] }, io.Discard)
+	for _, want := range []string{
+		"CONSUMER_MAX_IN_FLIGHT: must be at least CONSUMER_WORKERS",
+		"MIGRATE_BASELINE: only applies with MIGRATE_ON_START",
+		"HEALTH_CHECK_TIMEOUT: must be a positive duration",
+	} {
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("error %v does not contain %q", err, want)
+		}
+	}
+}
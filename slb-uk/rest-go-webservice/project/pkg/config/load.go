@@ -0,0 +1,191 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Validator is implemented by API, Consumer and Replay.
+type Validator interface {
+	Validate() error
+}
+
+// setting is one tagged field.
+type setting struct {
+	env, def, usage string
+	v               reflect.Value
+}
+
+func (s setting) flagName() string {
+	return strings.ReplaceAll(strings.ToLower(s.env), "_", "-")
+}
+
+// settings lists the fields of v with an env tag, descending into structs.
+func settings(v reflect.Value) []setting {
+	var out []setting
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		if env, ok := f.Tag.Lookup("env"); ok {
+			out = append(out, setting{env: env, def: f.Tag.Get("default"), usage: f.Tag.Get("usage"), v: v.Field(i)})
+		} else if f.Type.Kind() == reflect.Struct {
+			out = append(out, settings(v.Field(i))...)
+		}
+	}
+	return out
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// set parses s into the setting's field.
+func (st setting) set(s string) error {
+	v := st.v
+	switch {
+	case v.Type() == durationType:
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+	case v.Kind() == reflect.String:
+		v.SetString(s)
+	case v.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case v.CanInt():
+		n, err := strconv.ParseInt(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case v.CanFloat():
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	case v.Kind() == reflect.Slice && v.Type().Elem() == durationType:
+		var list []time.Duration
+		for _, item := range strings.Split(s, ",") {
+			if item = strings.TrimSpace(item); item == "" {
+				continue
+			}
+			d, err := time.ParseDuration(item)
+			if err != nil {
+				return err
+			}
+			list = append(list, d)
+		}
+		v.Set(reflect.ValueOf(list))
+	case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.String:
+		var list []string
+		for _, item := range strings.Split(s, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+		v.Set(reflect.ValueOf(list))
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+	return nil
+}
+
+// flagValue records a flag for Load to apply after the environment.
+type flagValue struct {
+	st  setting
+	set map[string]string
+}
+
+func (f flagValue) String() string { return "" }
+func (f flagValue) Set(s string) error {
+	f.set[f.st.env] = s
+	return f.st.set(s) // reject bad values while parsing, with the flag named
+}
+func (f flagValue) IsBoolFlag() bool { return f.st.v.Kind() == reflect.Bool }
+
+// Load fills cfg, a *API, *Consumer or *Replay, from the defaults, the YAML file,
+// the environment and args (usually os.Args[1:]), and validates it. -h
+// prints the settings and returns flag.ErrHelp.
+func Load(cfg Validator, name string, args []string) error {
+	return load(cfg, name, args, os.Getenv, os.Stderr)
+}
+
+func load(cfg Validator, name string, args []string, getenv func(string) string, usageOut io.Writer) error {
+	sts := settings(reflect.ValueOf(cfg).Elem())
+	for _, st := range sts {
+		if st.def == "" {
+			continue
+		}
+		if err := st.set(st.def); err != nil {
+			panic(fmt.Sprintf("config: default of %s: %v", st.env, err))
+		}
+	}
+	defaults := reflect.ValueOf(cfg).Elem().Interface()
+
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(usageOut)
+	file := fs.String("config", getenv("CONFIG_FILE"), "YAML file with settings ($CONFIG_FILE)")
+	flags := map[string]string{}
+	for _, st := range sts {
+		usage := st.usage
+		if usage != "" {
+			usage += " "
+		}
+		usage += "($" + st.env + ")"
+		if st.def != "" {
+			usage += " (default " + st.def + ")"
+		}
+		fs.Var(flagValue{st, flags}, st.flagName(), usage)
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return fmt.Errorf("unexpected arguments %q", fs.Args())
+	}
+	// parsing flags has set their fields already; start over from the
+	// defaults so the file and the environment cannot override them
+	reflect.ValueOf(cfg).Elem().Set(reflect.ValueOf(defaults))
+
+	if *file != "" {
+		b, err := os.ReadFile(*file)
+		if err != nil {
+			return err
+		}
+		dec := yaml.NewDecoder(bytes.NewReader(b))
+		dec.KnownFields(true)
+		if err := dec.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+			return fmt.Errorf("%s: %w", *file, err)
+		}
+	}
+	for _, st := range sts {
+		if v := getenv(st.env); v != "" {
+			if err := st.set(v); err != nil {
+				return fmt.Errorf("%s: %w", st.env, err)
+			}
+		}
+	}
+	for _, st := range sts {
+		if v, ok := flags[st.env]; ok {
+			_ = st.set(v) // checked while parsing
+		}
+	}
+	return cfg.Validate()
+}
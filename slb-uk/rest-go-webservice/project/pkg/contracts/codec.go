@@ -0,0 +1,112 @@
+package contracts
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/slb-uk/rest-go-webservice/project/pkg/contracts/contractspb"
+)
+
+// Kafka header keys. HeaderContentType says how a message value is encoded;
+// HeaderAccept on a command asks for the ack in that encoding.
+const (
+	HeaderContentType = "content-type"
+	HeaderAccept      = "accept"
+)
+
+const (
+	ContentTypeJSON     = "application/json"
+	ContentTypeProtobuf = "application/x-protobuf"
+	// protobuf framed with a Schema Registry id, see RegistryCodec
+	ContentTypeProtobufRegistry = "application/vnd.confluent.protobuf"
+)
+
+// Codec encodes commands and acks for Kafka. Values are the services' own
+// structs (or maps); their JSON form is the contract, and ProtobufCodec
+// checks it against contractspb on the way in and out.
+type Codec interface {
+	Name() string
+	ContentType() string
+	EncodeCommand(v any) ([]byte, error)
+	DecodeCommand(b []byte, v any) error
+	EncodeAck(v any) ([]byte, error)
+	DecodeAck(b []byte, v any) error
+}
+
+// ByName returns the codec for a KAFKA_CODEC value: "json" or "protobuf".
+func ByName(name string) (Codec, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "", "json":
+		return JSONCodec{}, nil
+	case "protobuf", "proto":
+		return ProtobufCodec{}, nil
+	}
+	return nil, fmt.Errorf("unknown codec %q (want json or protobuf)", name)
+}
+
+// ForContentType returns the codec for a content-type header. Messages
+// without one predate the header and are JSON.
+func ForContentType(ct string) (Codec, error) {
+	mt, _, _ := strings.Cut(ct, ";")
+	switch strings.ToLower(strings.TrimSpace(mt)) {
+	case "", ContentTypeJSON:
+		return JSONCodec{}, nil
+	case ContentTypeProtobuf:
+		return ProtobufCodec{}, nil
+	case ContentTypeProtobufRegistry:
+		return RegistryCodec{}, nil // decodes; see RegistryCodec
+	}
+	return nil, fmt.Errorf("unsupported content-type %q", ct)
+}
+
+// JSONCodec is the original encoding.
+type JSONCodec struct{}
+
+func (JSONCodec) Name() string                        { return "json" }
+func (JSONCodec) ContentType() string                 { return ContentTypeJSON }
+func (JSONCodec) EncodeCommand(v any) ([]byte, error) { return json.Marshal(v) }
+func (JSONCodec) DecodeCommand(b []byte, v any) error { return json.Unmarshal(b, v) }
+func (JSONCodec) EncodeAck(v any) ([]byte, error)     { return json.Marshal(v) }
+func (JSONCodec) DecodeAck(b []byte, v any) error     { return json.Unmarshal(b, v) }
+
+// ProtobufCodec writes contractspb messages. A value with a field the schema
+// does not know, or of the wrong type, fails to encode instead of being sent.
+type ProtobufCodec struct{}
+
+func (ProtobufCodec) Name() string        { return "protobuf" }
+func (ProtobufCodec) ContentType() string { return ContentTypeProtobuf }
+
+func (ProtobufCodec) EncodeCommand(v any) ([]byte, error) { return toProto(v, &contractspb.Command{}) }
+func (ProtobufCodec) DecodeCommand(b []byte, v any) error {
+	return fromProto(b, &contractspb.Command{}, v)
+}
+func (ProtobufCodec) EncodeAck(v any) ([]byte, error) { return toProto(v, &contractspb.Ack{}) }
+func (ProtobufCodec) DecodeAck(b []byte, v any) error { return fromProto(b, &contractspb.Ack{}, v) }
+
+// toProto goes through JSON: the proto field names are the JSON keys, so
+// protojson maps v onto m and rejects anything outside the schema.
+func toProto(v any, m proto.Message) ([]byte, error) {
+	j, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	if err := protojson.Unmarshal(j, m); err != nil {
+		return nil, fmt.Errorf("%s: %w", m.ProtoReflect().Descriptor().Name(), err)
+	}
+	return proto.Marshal(m)
+}
+
+func fromProto(b []byte, m proto.Message, v any) error {
+	if err := proto.Unmarshal(b, m); err != nil {
+		return err
+	}
+	j, err := protojson.MarshalOptions{UseProtoNames: true}.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(j, v)
+}
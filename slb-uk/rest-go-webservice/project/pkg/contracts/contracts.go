@@ -1,24 +1,28 @@
 package contracts
 
+// Command and Ack are the JSON form of the Kafka contracts; schema/*.json
+// has the rules, see Validate.
 type Command struct {
 	TraceID       string                 `json:"trace_id"`
-	CorrelationID string                 `json:"correlation_id"`
-	Timestamp     string                 `json:"timestamp"`
+	CorrelationID string                 `json:"correlation_id,omitempty"`
+	Timestamp     string                 `json:"timestamp,omitempty"`
 	Command       string                 `json:"command"`
 	Resource      string                 `json:"resource"`
 	Payload       map[string]any         `json:"payload"`
-	Metadata      map[string]any         `json:"metadata"`
+	Metadata      map[string]any         `json:"metadata,omitempty"`
 }
 
 type Ack struct {
 	TraceID       string         `json:"trace_id"`
-	CorrelationID string         `json:"correlation_id"`
-	Timestamp     string         `json:"timestamp"`
+	CorrelationID string         `json:"correlation_id,omitempty"`
+	Timestamp     string         `json:"timestamp,omitempty"`
 	Status        string         `json:"status"`
 	Event         string         `json:"event"`
-	Payload       map[string]any `json:"payload"`
+	Payload       map[string]any `json:"payload,omitempty"`
 	Error         *struct {
-		Code   string `json:"code"`
-		Detail string `json:"detail"`
+		Code   string `json:"Code"`
+		Detail string `json:"Detail"`
 	} `json:"error,omitempty"`
+	Replayed bool   `json:"replayed,omitempty"`
+	TenantID string `json:"tenant_id,omitempty"`
 }
\ No newline at end of file
@@ -0,0 +1,239 @@
+package contracts
+
+import (
+	"bytes"
+	"context"
+	_ "embed"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/slb-uk/rest-go-webservice/project/pkg/contracts/contractspb"
+)
+
+// contractsProto is the schema registered with the Schema Registry. Command
+// and Ack share the file, so they share one schema id; the message indexes
+// in each value say which of the two it is.
+//
+//go:embed contractspb/contracts.proto
+var contractsProto string
+
+// Message indexes of Command and Ack in contracts.proto, in the order they
+// are declared.
+var (
+	commandIndexes = []int64{0}
+	ackIndexes     = []int64{1}
+)
+
+// Subject name strategies, as Confluent's serializers name them: the
+// subject a schema is registered under, and whose compatibility rules
+// apply, is
+//
+//	topic         <topic>-value (TopicNameStrategy, the default)
+//	record        contracts.v1.Command (RecordNameStrategy)
+//	topic-record  <topic>-contracts.v1.Command (TopicRecordNameStrategy)
+const (
+	SubjectTopic       = "topic"
+	SubjectRecord      = "record"
+	SubjectTopicRecord = "topic-record"
+)
+
+// Subject returns the subject of record values on topic under strategy.
+func Subject(strategy, topic, record string) (string, error) {
+	switch strategy {
+	case "", SubjectTopic:
+		return topic + "-value", nil
+	case SubjectRecord:
+		return record, nil
+	case SubjectTopicRecord:
+		return topic + "-" + record, nil
+	}
+	return "", fmt.Errorf("unknown subject strategy %q (want topic, record or topic-record)", strategy)
+}
+
+// Registry is a client of a Confluent compatible Schema Registry.
+type Registry struct {
+	URL            string // e.g. http://schema-registry:8081
+	User, Password string // HTTP basic auth, when set
+	Client         *http.Client
+}
+
+// RegistryError is an error answer from the registry, e.g. 409 when a
+// schema is not compatible with the subject's latest version.
+type RegistryError struct {
+	Status  int
+	Code    int    `json:"error_code"`
+	Message string `json:"message"`
+}
+
+func (e *RegistryError) Error() string {
+	return fmt.Sprintf("schema registry: %s (%d)", e.Message, e.Code)
+}
+
+// Register adds schema to subject, unless it is already there, and returns
+// its id. The registry checks it against the subject's compatibility level
+// first, so a breaking change fails here rather than in a consumer.
+func (r *Registry) Register(ctx context.Context, subject, schemaType, schema string) (uint32, error) {
+	body, err := json.Marshal(map[string]string{"schemaType": schemaType, "schema": schema})
+	if err != nil {
+		return 0, err
+	}
+	u := strings.TrimRight(r.URL, "/") + "/subjects/" + url.PathEscape(subject) + "/versions"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/vnd.schemaregistry.v1+json")
+	if r.User != "" {
+		req.SetBasicAuth(r.User, r.Password)
+	}
+	client := r.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		e := &RegistryError{Status: resp.StatusCode}
+		if json.NewDecoder(resp.Body).Decode(e) != nil || e.Message == "" {
+			e.Code, e.Message = resp.StatusCode, resp.Status
+		}
+		return 0, fmt.Errorf("register %s: %w", subject, e)
+	}
+	var out struct {
+		ID uint32 `json:"id"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return 0, fmt.Errorf("register %s: %w", subject, err)
+	}
+	return out.ID, nil
+}
+
+// RegisterCommands registers contracts.proto as the schema of the commands
+// on topics and returns the codec that frames them with its id.
+func RegisterCommands(ctx context.Context, r *Registry, strategy string, topics []string) (RegistryCodec, error) {
+	return register(ctx, r, strategy, string((&contractspb.Command{}).ProtoReflect().Descriptor().FullName()), topics)
+}
+
+// RegisterAcks is RegisterCommands for the acks on topics.
+func RegisterAcks(ctx context.Context, r *Registry, strategy string, topics []string) (RegistryCodec, error) {
+	return register(ctx, r, strategy, string((&contractspb.Ack{}).ProtoReflect().Descriptor().FullName()), topics)
+}
+
+func register(ctx context.Context, r *Registry, strategy, record string, topics []string) (RegistryCodec, error) {
+	var id uint32
+	done := map[string]bool{}
+	for _, topic := range topics {
+		subject, err := Subject(strategy, topic, record)
+		if err != nil {
+			return RegistryCodec{}, err
+		}
+		if done[subject] {
+			continue
+		}
+		done[subject] = true
+		// google/protobuf/struct.proto is one of the registry's built-in
+		// dependencies, so the schema needs no references
+		got, err := r.Register(ctx, subject, "PROTOBUF", contractsProto)
+		if err != nil {
+			return RegistryCodec{}, err
+		}
+		if id != 0 && got != id {
+			return RegistryCodec{}, fmt.Errorf("schema registry: %s has id %d, other subjects %d", subject, got, id)
+		}
+		id = got
+	}
+	return RegistryCodec{SchemaID: id}, nil
+}
+
+// RegistryCodec is ProtobufCodec in Confluent's wire format, so the values
+// can be read by any Schema Registry aware client: a zero magic byte, the
+// big-endian schema id, the message indexes of the record within the
+// schema, then the protobuf bytes.
+//
+// It decodes with the compiled contractspb whatever schema id a value
+// carries: the registry only accepts versions compatible with it, so
+// readers do not need to fetch the writer's schema, and a registry outage
+// never stops them. Encoding needs the id, so only a codec returned by
+// RegisterCommands or RegisterAcks can produce.
+type RegistryCodec struct {
+	SchemaID uint32
+}
+
+func (RegistryCodec) Name() string        { return "protobuf" }
+func (RegistryCodec) ContentType() string { return ContentTypeProtobufRegistry }
+
+func (c RegistryCodec) EncodeCommand(v any) ([]byte, error) {
+	return c.encode(commandIndexes, ProtobufCodec{}.EncodeCommand, v)
+}
+
+func (RegistryCodec) DecodeCommand(b []byte, v any) error {
+	return decodeFramed(b, commandIndexes, ProtobufCodec{}.DecodeCommand, v)
+}
+
+func (c RegistryCodec) EncodeAck(v any) ([]byte, error) {
+	return c.encode(ackIndexes, ProtobufCodec{}.EncodeAck, v)
+}
+
+func (RegistryCodec) DecodeAck(b []byte, v any) error {
+	return decodeFramed(b, ackIndexes, ProtobufCodec{}.DecodeAck, v)
+}
+
+// ErrNotRegistered is returned when a RegistryCodec without a schema id
+// encodes.
+var ErrNotRegistered = errors.New("contracts: schema not registered (no KAFKA_SCHEMA_REGISTRY_URL)")
+
+func (c RegistryCodec) encode(indexes []int64, enc func(any) ([]byte, error), v any) ([]byte, error) {
+	if c.SchemaID == 0 {
+		return nil, ErrNotRegistered
+	}
+	b, err := enc(v)
+	if err != nil {
+		return nil, err
+	}
+	out := binary.BigEndian.AppendUint32([]byte{0}, c.SchemaID)
+	if slices.Equal(indexes, []int64{0}) {
+		out = append(out, 0) // the first message is written as an empty list
+	} else {
+		out = binary.AppendVarint(out, int64(len(indexes)))
+		for _, i := range indexes {
+			out = binary.AppendVarint(out, i)
+		}
+	}
+	return append(out, b...), nil
+}
+
+func decodeFramed(b []byte, want []int64, dec func([]byte, any) error, v any) error {
+	if len(b) < 6 || b[0] != 0 {
+		return errors.New("contracts: not in schema registry wire format (no magic byte)")
+	}
+	rest := b[5:]
+	n, k := binary.Varint(rest)
+	if k <= 0 || n < 0 || n > 16 {
+		return errors.New("contracts: bad message indexes")
+	}
+	rest = rest[k:]
+	indexes := []int64{0}
+	if n > 0 {
+		indexes = indexes[:0]
+		for ; n > 0; n-- {
+			i, k := binary.Varint(rest)
+			if k <= 0 {
+				return errors.New("contracts: bad message indexes")
+			}
+			indexes, rest = append(indexes, i), rest[k:]
+		}
+	}
+	if !slices.Equal(indexes, want) {
+		return fmt.Errorf("contracts: message indexes %v (schema id %d) are not %v", indexes, binary.BigEndian.Uint32(b[1:5]), want)
+	}
+	return dec(rest, v)
+}
@@ -0,0 +1,99 @@
+package contracts
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRegistryCodecRoundTrip(t *testing.T) {
+	c := RegistryCodec{SchemaID: 0x01020304}
+	cmd := Command{TraceID: "t-1", Command: "Create", Resource: "Message", Payload: map[string]any{"message": "hello"}}
+	b, err := c.EncodeCommand(cmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// magic byte, big-endian id, and Command's [0] as the single 0 byte
+	if want := []byte{0, 1, 2, 3, 4, 0}; !bytes.HasPrefix(b, want) {
+		t.Fatalf("command framed as % x, want prefix % x", b[:6], want)
+	}
+	var gotCmd Command
+	if err := c.DecodeCommand(b, &gotCmd); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(gotCmd, cmd) {
+		t.Fatalf("decoded %+v, want %+v", gotCmd, cmd)
+	}
+
+	ack := Ack{TraceID: "t-1", Status: "SUCCESS", Event: "MessageCreated", Payload: map[string]any{"id": float64(42)}, TenantID: "default"}
+	b, err = c.EncodeAck(ack)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Ack's [1]: a count of 1 and the index 1, both zigzag varints
+	if want := []byte{0, 1, 2, 3, 4, 2, 2}; !bytes.HasPrefix(b, want) {
+		t.Fatalf("ack framed as % x, want prefix % x", b[:7], want)
+	}
+	var gotAck Ack
+	if err := c.DecodeAck(b, &gotAck); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(gotAck, ack) {
+		t.Fatalf("decoded %+v, want %+v", gotAck, ack)
+	}
+
+	// a writer may spell [0] out as a list; readers take both
+	cmdBody, _ := ProtobufCodec{}.EncodeCommand(cmd)
+	gotCmd = Command{}
+	if err := (RegistryCodec{}).DecodeCommand(append([]byte{0, 0, 0, 0, 9, 2, 0}, cmdBody...), &gotCmd); err != nil || gotCmd.TraceID != "t-1" {
+		t.Fatalf("[0] as a list: %v, %+v", err, gotCmd)
+	}
+}
+
+func TestRegistryCodecNotRegistered(t *testing.T) {
+	if _, err := (RegistryCodec{}).EncodeCommand(Command{TraceID: "t-1"}); !errors.Is(err, ErrNotRegistered) {
+		t.Fatalf("err = %v", err)
+	}
+}
+
+func TestRegistryCodecRejects(t *testing.T) {
+	c := RegistryCodec{SchemaID: 7}
+	cmd, _ := c.EncodeCommand(Command{TraceID: "t-1", Command: "Create", Resource: "Message", Payload: map[string]any{"message": "hi"}})
+	ack, _ := c.EncodeAck(Ack{TraceID: "t-1", Status: "SUCCESS"})
+	plain, _ := ProtobufCodec{}.EncodeCommand(Command{TraceID: "t-1", Command: "Create", Resource: "Message"})
+	header := []byte{0, 0, 0, 0, 7}
+	cases := []struct {
+		name  string
+		value []byte
+		ack   bool // decoded as an Ack rather than a Command
+		want  string
+	}{
+		{"empty", nil, false, "no magic byte"},
+		{"too short", []byte{0, 0, 0, 7}, false, "no magic byte"},
+		{"plain protobuf", plain, false, "no magic byte"},
+		{"magic byte", append([]byte{1}, cmd[1:]...), false, "no magic byte"},
+		{"negative count", append(header, 1), false, "bad message indexes"},
+		{"count too large", append(header, 0x22), false, "bad message indexes"},
+		// a count of 2 with one index after it
+		{"truncated indexes", append(header, 4, 2), false, "bad message indexes"},
+		{"unterminated varint", append(header, 2, 0x80), false, "bad message indexes"},
+		{"ack as command", ack, false, "message indexes [1] (schema id 7) are not [0]"},
+		{"command as ack", cmd, true, "message indexes [0] (schema id 7) are not [1]"},
+		{"nested index", append(header, 4, 0, 2), false, "message indexes [0 1] (schema id 7) are not [0]"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var err error
+			if tc.ack {
+				err = c.DecodeAck(tc.value, &Ack{})
+			} else {
+				err = c.DecodeCommand(tc.value, &Command{})
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Fatalf("err = %v, want %q", err, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,103 @@
+package contracts
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+// Schemas holds the JSON Schemas of the contracts. They check the JSON
+// form, whichever codec carried the message, and are exported for tools
+// that produce commands without going through apisvc.
+//
+//go:embed schema/*.json
+var Schemas embed.FS
+
+var commandSchema, ackSchema = mustCompile("schema/command.json"), mustCompile("schema/ack.json")
+
+func mustCompile(name string) *jsonschema.Schema {
+	b, err := Schemas.ReadFile(name)
+	if err != nil {
+		panic(err)
+	}
+	c := jsonschema.NewCompiler()
+	c.Draft = jsonschema.Draft2020
+	c.AssertFormat = true
+	if err := c.AddResource(name, bytes.NewReader(b)); err != nil {
+		panic(fmt.Sprintf("%s: %v", name, err))
+	}
+	return c.MustCompile(name)
+}
+
+// ValidationError lists what is wrong with a command or an ack, one
+// problem per schema violation.
+type ValidationError struct {
+	Contract string   // command or ack
+	Problems []string // e.g. "/payload: missing properties: 'id'"
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid " + e.Contract + ": " + strings.Join(e.Problems, "; ")
+}
+
+// ValidateCommand checks the JSON form of v, a command struct or map,
+// against schema/command.json. It returns a *ValidationError when v does not
+// conform.
+func ValidateCommand(v any) error { return validate("command", commandSchema, v) }
+
+// ValidateAck checks the JSON form of v against schema/ack.json; see
+// ValidateCommand.
+func ValidateAck(v any) error { return validate("ack", ackSchema, v) }
+
+// Validate checks c against the command schema.
+func (c Command) Validate() error { return ValidateCommand(c) }
+
+// Validate checks a against the ack schema.
+func (a Ack) Validate() error { return ValidateAck(a) }
+
+func validate(contract string, s *jsonschema.Schema, v any) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+	var doc any
+	if err := d.Decode(&doc); err != nil {
+		return err
+	}
+	err = s.Validate(doc)
+	var ve *jsonschema.ValidationError
+	if !errors.As(err, &ve) {
+		return err
+	}
+	return &ValidationError{Contract: contract, Problems: problems(ve)}
+}
+
+// problems flattens the tree of a schema error to its leaves: the parents
+// only say that a keyword such as allOf failed.
+func problems(ve *jsonschema.ValidationError) []string {
+	var out []string
+	var walk func(e *jsonschema.ValidationError)
+	walk = func(e *jsonschema.ValidationError) {
+		if len(e.Causes) == 0 {
+			loc := e.InstanceLocation
+			if loc == "" {
+				loc = "/"
+			}
+			out = append(out, loc+": "+e.Message)
+		}
+		for _, c := range e.Causes {
+			walk(c)
+		}
+	}
+	walk(ve)
+	sort.Strings(out)
+	return out
+}
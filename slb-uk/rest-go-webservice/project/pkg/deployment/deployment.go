@@ -0,0 +1,155 @@
+// Package deployment routes commands between the stable consumersvc
+// workers and a canary version of them, so a new worker version can take a
+// share of the traffic before it takes all of it.
+//
+// apisvc tags every command with a track, "stable" or "canary": the one the
+// X-Deployment request header asks for, otherwise canary for CANARY_PERCENT
+// percent of requests. How the tag reaches the right workers depends on
+// CANARY_ROUTING:
+//
+//   - header: all commands go to the usual topic with an x-deployment Kafka
+//     header. Stable and canary workers read it in separate consumer groups
+//     and each skips the other's commands.
+//   - topic: canary commands go to "<topic>.canary", which only the canary
+//     group reads. Nothing is skipped, and canary commands wait in Kafka
+//     while no canary worker runs.
+package deployment
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net/http"
+	"strings"
+
+	"github.com/slb-uk/rest-go-webservice/project/pkg/problem"
+)
+
+// Header on incoming HTTP requests pins the track; it is echoed on the
+// response with the track that was picked.
+const Header = "X-Deployment"
+
+// MetadataKey is the Command.Metadata / Kafka header key for the track.
+const MetadataKey = "x-deployment"
+
+// Tracks.
+const (
+	Stable = "stable"
+	Canary = "canary"
+)
+
+// Routing modes, see the package comment.
+const (
+	RoutingHeader = "header"
+	RoutingTopic  = "topic"
+)
+
+var ErrInvalid = errors.New(`invalid deployment, want "stable" or "canary"`)
+
+// Parse normalizes a track name. The empty string stays empty: no
+// preference.
+func Parse(s string) (string, error) {
+	switch s = strings.ToLower(strings.TrimSpace(s)); s {
+	case "", Stable, Canary:
+		return s, nil
+	}
+	return "", ErrInvalid
+}
+
+// ParseRouting checks a CANARY_ROUTING value; empty means header.
+func ParseRouting(s string) (string, error) {
+	switch s {
+	case "", RoutingHeader:
+		return RoutingHeader, nil
+	case RoutingTopic:
+		return RoutingTopic, nil
+	}
+	return "", errors.New("invalid CANARY_ROUTING " + s + `, want "header" or "topic"`)
+}
+
+// FromMetadata reads the track from a command's metadata, or Stable for
+// commands sent before canary routing existed.
+func FromMetadata(md map[string]any) string {
+	if t, _ := md[MetadataKey].(string); t == Canary {
+		return Canary
+	}
+	return Stable
+}
+
+// FromHeader is FromMetadata for the Kafka header value.
+func FromHeader(v string) string {
+	if v == Canary {
+		return Canary
+	}
+	return Stable
+}
+
+// Topic returns the topic commands of track are sent to and read from:
+// base, or "<base>.canary" for canary commands with topic routing.
+func Topic(routing, track, base string) string {
+	if routing == RoutingTopic && track == Canary {
+		return base + "." + Canary
+	}
+	return base
+}
+
+// Topics applies Topic to each of bases.
+func Topics(routing, track string, bases []string) []string {
+	out := make([]string, 0, len(bases))
+	for _, b := range bases {
+		out = append(out, Topic(routing, track, b))
+	}
+	return out
+}
+
+// GroupID is the consumer group of track's workers. The groups must differ
+// so that with header routing both see every command.
+func GroupID(base, track string) string {
+	if track == Canary {
+		return base + "-" + Canary
+	}
+	return base
+}
+
+type ctxKey string
+
+const trackKey ctxKey = "deployment"
+
+func WithTrack(ctx context.Context, track string) context.Context {
+	return context.WithValue(ctx, trackKey, track)
+}
+
+// FromContext returns the track picked by Middleware, or Stable.
+func FromContext(ctx context.Context) string {
+	if t, ok := ctx.Value(trackKey).(string); ok && t != "" {
+		return t
+	}
+	return Stable
+}
+
+// Pick returns the requested track, or canary for percent percent of calls
+// when none was requested.
+func Pick(requested string, percent float64) string {
+	if requested != "" {
+		return requested
+	}
+	if percent > 0 && rand.Float64()*100 < percent {
+		return Canary
+	}
+	return Stable
+}
+
+// Middleware picks the track of each request (400 for an invalid
+// X-Deployment) and stores it in the request context.
+func Middleware(percent float64, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested, err := Parse(r.Header.Get(Header))
+		if err != nil {
+			problem.Write(w, r, http.StatusBadRequest, problem.CodeInvalidHeader, Header+": "+err.Error())
+			return
+		}
+		track := Pick(requested, percent)
+		w.Header().Set(Header, track)
+		next.ServeHTTP(w, r.WithContext(WithTrack(r.Context(), track)))
+	})
+}
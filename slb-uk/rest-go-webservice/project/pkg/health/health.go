@@ -0,0 +1,229 @@
+// Package health serves Kubernetes liveness and readiness probes that
+// actively check a service's dependencies:
+//
+//	GET /readyz  200 {"status":"ok","checks":{"kafka":{"status":"ok","latency_ms":3},...}}
+//	             503 {"status":"fail","checks":{"mysql":{"status":"fail","error":"dial tcp ...",...}}}
+//
+// A check added as a Probe also describes its dependency, e.g. the schema
+// version, in its "detail".
+//
+// /readyz fails as soon as any check fails, which takes the pod out of its
+// Service until the dependency is back. /healthz runs the same checks but
+// only fails once one has been failing for longer than FailAfter: restarting
+// a pod does not bring back its database, so a short outage should not
+// restart every replica at once.
+//
+// While a service is still connecting to its dependencies (Starting),
+// /readyz fails with a "startup" check and /healthz stays ok.
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Check reports whether a dependency is usable. It should respect ctx,
+// which carries the per-check timeout.
+type Check func(ctx context.Context) error
+
+// Probe is a Check that also returns a detail for the probe response,
+// encoded as JSON. The detail is shown whether or not err is nil.
+type Probe func(ctx context.Context) (detail any, err error)
+
+// Status of one check in a probe response.
+type Status struct {
+	Status      string     `json:"status"` // ok | fail
+	Error       string     `json:"error,omitempty"`
+	Detail      any        `json:"detail,omitempty"`
+	LatencyMS   int64      `json:"latency_ms"`
+	LastSuccess *time.Time `json:"last_success,omitempty"`
+	// FailingFor is how long the check has failed in a row, e.g. "45s".
+	FailingFor string `json:"failing_for,omitempty"`
+}
+
+// Report is the body of /healthz and /readyz.
+type Report struct {
+	Status string            `json:"status"`
+	Checks map[string]Status `json:"checks"`
+}
+
+// Checker runs the registered checks concurrently on every probe.
+type Checker struct {
+	// Timeout bounds each check (default 2s).
+	Timeout time.Duration
+	// FailAfter is how long a check must fail in a row before /healthz
+	// fails too (default 2m).
+	FailAfter time.Duration
+
+	mu       sync.Mutex
+	starting bool
+	checks   map[string]Probe
+	lastOK   map[string]time.Time
+	failFrom map[string]time.Time // start of the current run of failures
+	now      func() time.Time
+}
+
+// New returns a Checker with the default timeouts.
+func New() *Checker {
+	return &Checker{Timeout: 2 * time.Second, FailAfter: 2 * time.Minute,
+		checks: map[string]Probe{}, lastOK: map[string]time.Time{}, failFrom: map[string]time.Time{}, now: time.Now}
+}
+
+// Add registers a check under name, replacing one of the same name.
+func (c *Checker) Add(name string, check Check) {
+	c.AddProbe(name, func(ctx context.Context) (any, error) { return nil, check(ctx) })
+}
+
+// AddProbe registers a probe under name, replacing a check of the same
+// name.
+func (c *Checker) AddProbe(name string, probe Probe) {
+	c.mu.Lock()
+	c.checks[name] = probe
+	c.mu.Unlock()
+}
+
+// Starting fails /readyz until the returned func is called, for a service
+// that serves its probes before its dependencies are connected. /healthz
+// is not affected, so waiting on a slow dependency does not restart the
+// pod.
+func (c *Checker) Starting() (done func()) {
+	c.mu.Lock()
+	c.starting = true
+	c.mu.Unlock()
+	return func() {
+		c.mu.Lock()
+		c.starting = false
+		c.mu.Unlock()
+	}
+}
+
+// Run runs every check and returns the report and whether the service is
+// ready (all ok) and live (no check failing for FailAfter or longer).
+func (c *Checker) Run(ctx context.Context) (r Report, ready, live bool) {
+	c.mu.Lock()
+	checks := make(map[string]Probe, len(c.checks))
+	for n, ch := range c.checks {
+		checks[n] = ch
+	}
+	c.mu.Unlock()
+
+	type result struct {
+		name    string
+		detail  any
+		err     error
+		latency time.Duration
+	}
+	results := make(chan result, len(checks))
+	for n, ch := range checks {
+		go func(n string, ch Probe) {
+			cctx, cancel := context.WithTimeout(ctx, c.Timeout)
+			defer cancel()
+			start := c.now()
+			detail, err := runCheck(cctx, ch)
+			results <- result{n, detail, err, c.now().Sub(start)}
+		}(n, ch)
+	}
+
+	r = Report{Status: "ok", Checks: make(map[string]Status, len(checks))}
+	ready, live = true, true
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for range checks {
+		res := <-results
+		now := c.now()
+		st := Status{Status: "ok", Detail: res.detail, LatencyMS: res.latency.Milliseconds()}
+		if res.err == nil {
+			c.lastOK[res.name] = now
+			delete(c.failFrom, res.name)
+		} else {
+			if _, ok := c.failFrom[res.name]; !ok {
+				c.failFrom[res.name] = now
+			}
+			failing := now.Sub(c.failFrom[res.name])
+			st.Status, st.Error, st.FailingFor = "fail", res.err.Error(), failing.Round(time.Second).String()
+			r.Status, ready = "fail", false
+			if failing >= c.FailAfter {
+				live = false
+			}
+		}
+		if t, ok := c.lastOK[res.name]; ok {
+			st.LastSuccess = &t
+		}
+		r.Checks[res.name] = st
+	}
+	if c.starting {
+		r.Status, ready = "fail", false
+		r.Checks["startup"] = Status{Status: "fail", Error: "connecting to dependencies"}
+	}
+	return r, ready, live
+}
+
+// runCheck returns ctx's error if ch ignores it and runs past the timeout.
+func runCheck(ctx context.Context, ch Probe) (detail any, err error) {
+	type result struct {
+		detail any
+		err    error
+	}
+	done := make(chan result, 1)
+	go func() {
+		defer func() {
+			if p := recover(); p != nil {
+				done <- result{err: fmt.Errorf("check panicked: %v", p)}
+			}
+		}()
+		detail, err := ch(ctx)
+		done <- result{detail, err}
+	}()
+	select {
+	case r := <-done:
+		return r.detail, r.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Readyz answers 503 while any check fails.
+func (c *Checker) Readyz(w http.ResponseWriter, r *http.Request) {
+	rep, ready, _ := c.Run(r.Context())
+	write(w, rep, ready)
+}
+
+// Healthz answers 503 once a check has failed for FailAfter in a row.
+func (c *Checker) Healthz(w http.ResponseWriter, r *http.Request) {
+	rep, _, live := c.Run(r.Context())
+	write(w, rep, live)
+}
+
+// Register mounts /healthz and /readyz on mux.
+func (c *Checker) Register(mux *http.ServeMux) {
+	mux.HandleFunc("/healthz", c.Healthz)
+	mux.HandleFunc("/readyz", c.Readyz)
+}
+
+// Serve listens on addr in the background with only /healthz and /readyz,
+// for services without an HTTP server of their own.
+func (c *Checker) Serve(addr string) {
+	mux := http.NewServeMux()
+	c.Register(mux)
+	go func() {
+		slog.Info("health probes listening", "addr", addr)
+		if err := http.ListenAndServe(addr, mux); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("health server", "err", err)
+		}
+	}()
+}
+
+func write(w http.ResponseWriter, rep Report, ok bool) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if !ok {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	_ = json.NewEncoder(w).Encode(rep)
+}
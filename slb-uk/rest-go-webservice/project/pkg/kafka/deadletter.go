@@ -0,0 +1,106 @@
+package kafkahelper
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+// Headers of a dead-letter record. It keeps the key, value and headers of
+// the record that failed, so it can be produced again as it was, and these
+// say why it failed and where it came from.
+const (
+	HeaderDLQReason    = "dlq-reason"         // e.g. decode or processing
+	HeaderDLQError     = "dlq-error"          // the last error, for people
+	HeaderDLQAttempts  = "dlq-attempts"       // times it was processed
+	HeaderDLQTopic     = "dlq-original-topic" // where it was consumed from
+	HeaderDLQPartition = "dlq-original-partition"
+	HeaderDLQOffset    = "dlq-original-offset"
+	HeaderDLQGroup     = "dlq-group" // the consumer group that gave up
+	HeaderDLQTime      = "dlq-time"  // RFC 3339
+	// HeaderDLQReplays counts how often the record was replayed from the
+	// dead-letter topic. Unlike the others it stays on the replayed record,
+	// so a command that keeps coming back shows it.
+	HeaderDLQReplays = "dlq-replays"
+)
+
+// DeadLetter says why a record is moved to a dead-letter topic.
+type DeadLetter struct {
+	Topic    string // the dead-letter topic
+	Reason   string
+	Err      error
+	Attempts int
+	Group    string
+	Time     time.Time
+}
+
+// Message returns the dead-letter record of msg. The dlq-* headers of an
+// earlier dead-lettering are replaced, except dlq-replays; the dlq-original-*
+// ones of a retried record name where it was first consumed.
+func (d DeadLetter) Message(msg *sarama.ConsumerMessage) *sarama.ProducerMessage {
+	headers := withoutHeaders(msg.Headers, func(k string) bool {
+		return strings.HasPrefix(k, "dlq-") && k != HeaderDLQReplays
+	})
+	add := func(k, v string) {
+		headers = append(headers, sarama.RecordHeader{Key: []byte(k), Value: []byte(v)})
+	}
+	add(HeaderDLQReason, d.Reason)
+	if d.Err != nil {
+		add(HeaderDLQError, d.Err.Error())
+	}
+	add(HeaderDLQAttempts, strconv.Itoa(d.Attempts))
+	topic, partition, offset := Origin(msg)
+	add(HeaderDLQTopic, topic)
+	add(HeaderDLQPartition, strconv.Itoa(int(partition)))
+	add(HeaderDLQOffset, strconv.FormatInt(offset, 10))
+	if d.Group != "" {
+		add(HeaderDLQGroup, d.Group)
+	}
+	add(HeaderDLQTime, d.Time.UTC().Format(time.RFC3339))
+	return &sarama.ProducerMessage{
+		Topic:   d.Topic,
+		Key:     sarama.ByteEncoder(msg.Key),
+		Value:   sarama.ByteEncoder(msg.Value),
+		Headers: headers,
+	}
+}
+
+// Replay turns dead-letter record msg back into the record that failed,
+// produced to topic, or to the topic it was consumed from when topic is
+// "". The dlq-* and retry-* headers go, so it starts over with its first
+// attempt, and dlq-replays goes up by one.
+func Replay(msg *sarama.ConsumerMessage, topic string) (*sarama.ProducerMessage, error) {
+	if topic == "" {
+		topic = Header(msg.Headers, HeaderDLQTopic)
+	}
+	if topic == "" {
+		return nil, errors.New("no " + HeaderDLQTopic + " header; name the topic to replay to")
+	}
+	replays, _ := strconv.Atoi(Header(msg.Headers, HeaderDLQReplays))
+	headers := withoutHeaders(msg.Headers, func(k string) bool {
+		return strings.HasPrefix(k, "dlq-") || strings.HasPrefix(k, "retry-")
+	})
+	headers = append(headers, sarama.RecordHeader{Key: []byte(HeaderDLQReplays), Value: []byte(strconv.Itoa(replays + 1))})
+	return &sarama.ProducerMessage{
+		Topic:   topic,
+		Key:     sarama.ByteEncoder(msg.Key),
+		Value:   sarama.ByteEncoder(msg.Value),
+		Headers: headers,
+	}, nil
+}
+
+// withoutHeaders copies headers, leaving out those whose key drop
+// reports.
+func withoutHeaders(headers []*sarama.RecordHeader, drop func(key string) bool) []sarama.RecordHeader {
+	out := make([]sarama.RecordHeader, 0, len(headers)+10)
+	for _, h := range headers {
+		if h == nil || drop(string(h.Key)) {
+			continue
+		}
+		out = append(out, *h)
+	}
+	return out
+}
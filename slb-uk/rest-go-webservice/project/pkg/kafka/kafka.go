@@ -1,16 +1,64 @@
 package kafkahelper
 
 import (
+	"context"
+	"fmt"
+	"time"
+
 	"github.com/IBM/sarama"
 )
 
-func NewIdempotentProducer(brokers []string) (sarama.SyncProducer, error) {
-	config := sarama.NewConfig()
+// NewIdempotentProducer returns a producer that waits for all in-sync
+// replicas and never writes a record twice, connecting with o.
+func NewIdempotentProducer(brokers []string, o Options) (sarama.SyncProducer, error) {
+	config, err := NewConfig(o)
+	if err != nil {
+		return nil, err
+	}
 	config.Producer.RequiredAcks = sarama.WaitForAll
 	config.Producer.Idempotent = true
 	config.Producer.Return.Successes = true
 	config.Net.MaxOpenRequests = 1
-	config.Version = sarama.V2_6_0_0
 
 	return sarama.NewSyncProducer(brokers, config)
 }
+
+// Header returns the value of the first header named key, or "".
+func Header(headers []*sarama.RecordHeader, key string) string {
+	for _, h := range headers {
+		if h != nil && string(h.Key) == key {
+			return string(h.Value)
+		}
+	}
+	return ""
+}
+
+// NewHealthClient returns a client for HealthCheck that fails fast instead
+// of retrying, so a probe reports a broker outage within its timeout.
+func NewHealthClient(brokers []string, o Options) (sarama.Client, error) {
+	config, err := NewConfig(o)
+	if err != nil {
+		return nil, err
+	}
+	config.Net.DialTimeout = 2 * time.Second
+	config.Net.ReadTimeout = 2 * time.Second
+	config.Metadata.Retry.Max = 0
+	config.Metadata.Full = false
+	return sarama.NewClient(brokers, config)
+}
+
+// HealthCheck asks the brokers for the metadata of topics, which needs a
+// live broker connection and fails for topics that do not exist.
+func HealthCheck(client sarama.Client, topics ...string) func(context.Context) error {
+	return func(context.Context) error {
+		if err := client.RefreshMetadata(topics...); err != nil {
+			return err
+		}
+		for _, t := range topics {
+			if _, err := client.Partitions(t); err != nil {
+				return fmt.Errorf("topic %s: %w", t, err)
+			}
+		}
+		return nil
+	}
+}
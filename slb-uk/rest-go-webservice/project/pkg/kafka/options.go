@@ -0,0 +1,167 @@
+package kafkahelper
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/IBM/sarama"
+	"github.com/xdg-go/scram"
+)
+
+// SASL mechanisms Options accepts.
+const (
+	SASLPlain       = sarama.SASLTypePlaintext
+	SASLSCRAMSHA256 = sarama.SASLTypeSCRAMSHA256
+	SASLSCRAMSHA512 = sarama.SASLTypeSCRAMSHA512
+)
+
+// Options are the connection settings every client of a service shares.
+// The zero value is a plaintext, unauthenticated connection.
+type Options struct {
+	ClientID    string
+	Compression string // none (or ""), gzip, snappy, lz4 or zstd
+	TLS         TLSOptions
+	SASL        SASLOptions
+}
+
+// TLSOptions turn on TLS. CAFile replaces the system roots; CertFile and
+// KeyFile, set together, are the client certificate for mutual TLS.
+type TLSOptions struct {
+	Enabled            bool
+	CAFile             string
+	CertFile, KeyFile  string
+	InsecureSkipVerify bool
+}
+
+// SASLOptions authenticate the connection with Mechanism, one of the
+// SASL* constants; an empty Mechanism leaves SASL off.
+type SASLOptions struct {
+	Mechanism      string
+	User, Password string
+}
+
+var compressions = map[string]sarama.CompressionCodec{
+	"": sarama.CompressionNone, "none": sarama.CompressionNone, "gzip": sarama.CompressionGZIP,
+	"snappy": sarama.CompressionSnappy, "lz4": sarama.CompressionLZ4, "zstd": sarama.CompressionZSTD,
+}
+
+// Validate checks o without reading the certificate files; Apply does that.
+func (o Options) Validate() error {
+	var errs []error
+	if _, ok := compressions[o.Compression]; !ok {
+		errs = append(errs, fmt.Errorf("unknown compression %q", o.Compression))
+	}
+	if (o.TLS.CertFile == "") != (o.TLS.KeyFile == "") {
+		errs = append(errs, errors.New("TLS certificate and key must be set together"))
+	}
+	switch o.SASL.Mechanism {
+	case "":
+	case SASLPlain, SASLSCRAMSHA256, SASLSCRAMSHA512:
+		if o.SASL.User == "" {
+			errs = append(errs, errors.New("SASL needs a user"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("unknown SASL mechanism %q", o.SASL.Mechanism))
+	}
+	return errors.Join(errs...)
+}
+
+// Apply sets o on cfg.
+func (o Options) Apply(cfg *sarama.Config) error {
+	if err := o.Validate(); err != nil {
+		return err
+	}
+	if o.ClientID != "" {
+		cfg.ClientID = o.ClientID
+	}
+	cfg.Producer.Compression = compressions[o.Compression]
+
+	if o.TLS.Enabled {
+		tc, err := o.TLS.config()
+		if err != nil {
+			return err
+		}
+		cfg.Net.TLS.Enable = true
+		cfg.Net.TLS.Config = tc
+	}
+
+	if o.SASL.Mechanism != "" {
+		cfg.Net.SASL.Enable = true
+		cfg.Net.SASL.Handshake = true
+		cfg.Net.SASL.Mechanism = sarama.SASLMechanism(o.SASL.Mechanism)
+		cfg.Net.SASL.User = o.SASL.User
+		cfg.Net.SASL.Password = o.SASL.Password
+		switch o.SASL.Mechanism {
+		case SASLSCRAMSHA256:
+			cfg.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient { return &scramClient{hash: scram.SHA256} }
+		case SASLSCRAMSHA512:
+			cfg.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient { return &scramClient{hash: scram.SHA512} }
+		}
+	}
+	return nil
+}
+
+func (t TLSOptions) config() (*tls.Config, error) {
+	tc := &tls.Config{MinVersion: tls.VersionTLS12, InsecureSkipVerify: t.InsecureSkipVerify}
+	if t.CAFile != "" {
+		pem, err := os.ReadFile(t.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("kafka TLS CA: %w", err)
+		}
+		tc.RootCAs = x509.NewCertPool()
+		if !tc.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("kafka TLS CA: no certificates in %s", t.CAFile)
+		}
+	}
+	if t.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("kafka TLS certificate: %w", err)
+		}
+		tc.Certificates = []tls.Certificate{cert}
+	}
+	return tc, nil
+}
+
+// scramClient is the sarama.SCRAMClient of the SCRAM mechanisms.
+type scramClient struct {
+	hash scram.HashGeneratorFcn
+	conv *scram.ClientConversation
+}
+
+func (c *scramClient) Begin(user, password, authzID string) error {
+	client, err := c.hash.NewClient(user, password, authzID)
+	if err != nil {
+		return err
+	}
+	c.conv = client.NewConversation()
+	return nil
+}
+
+func (c *scramClient) Step(challenge string) (string, error) { return c.conv.Step(challenge) }
+func (c *scramClient) Done() bool                            { return c.conv.Done() }
+
+// NewConfig returns the base config of the services' clients with o
+// applied.
+func NewConfig(o Options) (*sarama.Config, error) {
+	cfg := sarama.NewConfig()
+	cfg.Version = sarama.V2_6_0_0
+	if err := o.Apply(cfg); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// NewConsumerGroup joins group with o. A group without committed offsets
+// starts from the oldest record.
+func NewConsumerGroup(brokers []string, group string, o Options) (sarama.ConsumerGroup, error) {
+	cfg, err := NewConfig(o)
+	if err != nil {
+		return nil, err
+	}
+	cfg.Consumer.Offsets.Initial = sarama.OffsetOldest
+	return sarama.NewConsumerGroup(brokers, group, cfg)
+}
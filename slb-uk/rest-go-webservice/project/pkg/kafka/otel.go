@@ -0,0 +1,102 @@
+package kafkahelper
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/IBM/sarama"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/slb-uk/rest-go-webservice/project/pkg/kafka"
+
+// producerCarrier lets the propagator write traceparent/tracestate into the
+// headers of a record about to be produced, replacing stale values.
+type producerCarrier struct{ m *sarama.ProducerMessage }
+
+func (c producerCarrier) Get(key string) string {
+	for _, h := range c.m.Headers {
+		if string(h.Key) == key {
+			return string(h.Value)
+		}
+	}
+	return ""
+}
+
+func (c producerCarrier) Set(key, value string) {
+	for i, h := range c.m.Headers {
+		if string(h.Key) == key {
+			c.m.Headers[i].Value = []byte(value)
+			return
+		}
+	}
+	c.m.Headers = append(c.m.Headers, sarama.RecordHeader{Key: []byte(key), Value: []byte(value)})
+}
+
+func (c producerCarrier) Keys() []string {
+	keys := make([]string, len(c.m.Headers))
+	for i, h := range c.m.Headers {
+		keys[i] = string(h.Key)
+	}
+	return keys
+}
+
+// consumerCarrier reads the trace context of a consumed record.
+type consumerCarrier []*sarama.RecordHeader
+
+func (c consumerCarrier) Get(key string) string { return Header(c, key) }
+func (c consumerCarrier) Set(string, string)    {}
+func (c consumerCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for _, h := range c {
+		if h != nil {
+			keys = append(keys, string(h.Key))
+		}
+	}
+	return keys
+}
+
+// StartProduce starts a producer span for m under ctx and injects its
+// context into m's headers, so the consumer's span continues the trace.
+// Finish it with EndProduce once SendMessage returns.
+func StartProduce(ctx context.Context, m *sarama.ProducerMessage, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	ctx, span := otel.Tracer(tracerName).Start(ctx, m.Topic+" publish",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(semconv.MessagingSystemKafka, semconv.MessagingDestinationName(m.Topic),
+			semconv.MessagingOperationTypePublish),
+		trace.WithAttributes(attrs...),
+	)
+	otel.GetTextMapPropagator().Inject(ctx, producerCarrier{m})
+	return ctx, span
+}
+
+// EndProduce records where the record landed, or the error, and ends span.
+func EndProduce(span trace.Span, partition int32, offset int64, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	} else {
+		span.SetAttributes(semconv.MessagingDestinationPartitionID(strconv.Itoa(int(partition))),
+			semconv.MessagingKafkaMessageOffset(int(offset)))
+	}
+	span.End()
+}
+
+// StartConsume starts the span processing msg, as a child of the span that
+// produced it when msg carries a trace context. The caller ends it.
+func StartConsume(ctx context.Context, msg *sarama.ConsumerMessage, group string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	ctx = otel.GetTextMapPropagator().Extract(ctx, consumerCarrier(msg.Headers))
+	return otel.Tracer(tracerName).Start(ctx, msg.Topic+" process",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(semconv.MessagingSystemKafka, semconv.MessagingDestinationName(msg.Topic),
+			semconv.MessagingOperationTypeDeliver, semconv.MessagingKafkaConsumerGroup(group),
+			semconv.MessagingDestinationPartitionID(strconv.Itoa(int(msg.Partition))),
+			semconv.MessagingKafkaMessageOffset(int(msg.Offset)),
+			semconv.MessagingKafkaMessageKey(string(msg.Key))),
+		trace.WithAttributes(attrs...),
+	)
+}
@@ -0,0 +1,105 @@
+package kafkahelper
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+// Headers of a record on a retry topic. Like a dead-letter record it keeps
+// the key, value and headers of the one that failed.
+const (
+	HeaderRetryAttempt   = "retry-attempt"    // failed attempts so far
+	HeaderRetryError     = "retry-error"      // the last error, for people
+	HeaderRetryNotBefore = "retry-not-before" // unix milliseconds
+	// where the first attempt was consumed from; a dead-letter record of a
+	// retried command names these instead of the retry topic
+	HeaderRetryTopic     = "retry-original-topic"
+	HeaderRetryPartition = "retry-original-partition"
+	HeaderRetryOffset    = "retry-original-offset"
+)
+
+// RetryStage is one rung of a retry ladder: a topic whose records are
+// processed again Delay after they were sent to it.
+type RetryStage struct {
+	Topic string
+	Delay time.Duration
+}
+
+// RetryStages returns a stage per delay, named after topic:
+// messages.commands.retry.5s, messages.commands.retry.2m and so on.
+func RetryStages(topic string, delays []time.Duration) []RetryStage {
+	stages := make([]RetryStage, len(delays))
+	for i, d := range delays {
+		stages[i] = RetryStage{Topic: topic + ".retry." + shortDuration(d), Delay: d}
+	}
+	return stages
+}
+
+// shortDuration is d without zero units: 2m rather than 2m0s.
+func shortDuration(d time.Duration) string {
+	s := d.String()
+	if strings.HasSuffix(s, "m0s") {
+		s = s[:len(s)-2]
+	}
+	if strings.HasSuffix(s, "h0m") {
+		s = s[:len(s)-2]
+	}
+	return s
+}
+
+// Message returns the record that sends msg, which failed for the
+// attempt-th time with err, to stage s. The retry-* headers of an earlier
+// stage are replaced, except where the command was first consumed.
+func (s RetryStage) Message(msg *sarama.ConsumerMessage, attempt int, err error, now time.Time) *sarama.ProducerMessage {
+	headers := withoutHeaders(msg.Headers, func(k string) bool { return strings.HasPrefix(k, "retry-") })
+	add := func(k, v string) {
+		headers = append(headers, sarama.RecordHeader{Key: []byte(k), Value: []byte(v)})
+	}
+	add(HeaderRetryAttempt, strconv.Itoa(attempt))
+	if err != nil {
+		add(HeaderRetryError, err.Error())
+	}
+	add(HeaderRetryNotBefore, strconv.FormatInt(now.Add(s.Delay).UnixMilli(), 10))
+	topic, partition, offset := Origin(msg)
+	add(HeaderRetryTopic, topic)
+	add(HeaderRetryPartition, strconv.Itoa(int(partition)))
+	add(HeaderRetryOffset, strconv.FormatInt(offset, 10))
+	return &sarama.ProducerMessage{
+		Topic:   s.Topic,
+		Key:     sarama.ByteEncoder(msg.Key),
+		Value:   sarama.ByteEncoder(msg.Value),
+		Headers: headers,
+	}
+}
+
+// RetryAttempt returns the failed attempts recorded on msg, 0 for a record
+// that was never retried.
+func RetryAttempt(msg *sarama.ConsumerMessage) int {
+	n, _ := strconv.Atoi(Header(msg.Headers, HeaderRetryAttempt))
+	return n
+}
+
+// RetryNotBefore returns when msg is due, the zero time for a record that
+// was never retried.
+func RetryNotBefore(msg *sarama.ConsumerMessage) time.Time {
+	ms, err := strconv.ParseInt(Header(msg.Headers, HeaderRetryNotBefore), 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	return time.UnixMilli(ms)
+}
+
+// Origin returns where msg was first consumed: the retry-original-*
+// headers of a retried record, its own position otherwise.
+func Origin(msg *sarama.ConsumerMessage) (topic string, partition int32, offset int64) {
+	topic = Header(msg.Headers, HeaderRetryTopic)
+	if topic == "" {
+		return msg.Topic, msg.Partition, msg.Offset
+	}
+	p, _ := strconv.ParseInt(Header(msg.Headers, HeaderRetryPartition), 10, 32)
+	o, _ := strconv.ParseInt(Header(msg.Headers, HeaderRetryOffset), 10, 64)
+	return topic, int32(p), o
+}
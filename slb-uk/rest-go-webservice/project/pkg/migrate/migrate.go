@@ -0,0 +1,172 @@
+// Package migrate applies the SQL migrations embedded from migrations/ to
+// consumersvc's database with golang-migrate, and reports the version the
+// schema is at. The applied version is kept in schema_migrations:
+//
+//	version  dirty
+//	5        0
+//
+// A database set up before that table existed, by docker-entrypoint-initdb.d
+// or make migrate, has the tables but no version. Up with a baseline
+// records that version without running anything, so the migrations that
+// are not idempotent (ALTER TABLE ... ADD COLUMN) are not applied twice.
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"strings"
+	"sync"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/golang-migrate/migrate/v4"
+	migratemysql "github.com/golang-migrate/migrate/v4/database/mysql"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
+
+	"github.com/slb-uk/rest-go-webservice/project/migrations"
+	"github.com/slb-uk/rest-go-webservice/project/pkg/health"
+)
+
+// Status is where a database's schema is.
+type Status struct {
+	// Version is the last migration applied; 0 is none.
+	Version uint `json:"version"`
+	// Dirty is set when Version failed halfway. The schema has to be
+	// repaired by hand, then the version forced, before Up runs again.
+	Dirty bool `json:"dirty"`
+	// Latest is the last migration embedded in this build.
+	Latest uint `json:"latest"`
+}
+
+// Latest returns the number of the last embedded migration.
+var Latest = sync.OnceValues(func() (uint, error) {
+	src, err := iofs.New(migrations.FS, ".")
+	if err != nil {
+		return 0, err
+	}
+	defer src.Close()
+	v, err := src.First()
+	if err != nil {
+		return 0, err
+	}
+	for {
+		next, err := src.Next(v)
+		if errors.Is(err, fs.ErrNotExist) {
+			return v, nil
+		} else if err != nil {
+			return 0, err
+		}
+		v = next
+	}
+})
+
+// Up applies the migrations the database of dsn does not have yet, under
+// a MySQL lock so replicas starting together take turns. A database
+// without a version is first recorded at baseline when baseline is not 0.
+// Up opens a connection of its own, with multiStatements set, since a
+// migration file holds several statements.
+func Up(dsn string, baseline uint) (Status, error) {
+	cfg, err := mysql.ParseDSN(dsn)
+	if err != nil {
+		return Status{}, err
+	}
+	cfg.MultiStatements = true
+	db, err := sql.Open("mysql", cfg.FormatDSN())
+	if err != nil {
+		return Status{}, err
+	}
+	driver, err := migratemysql.WithInstance(db, &migratemysql.Config{})
+	if err != nil {
+		db.Close()
+		return Status{}, err
+	}
+	latest, err := Latest()
+	if err != nil {
+		driver.Close()
+		return Status{}, err
+	}
+	src, err := iofs.New(migrations.FS, ".")
+	if err != nil {
+		driver.Close()
+		return Status{}, err
+	}
+	m, err := migrate.NewWithInstance("iofs", src, "mysql", driver)
+	if err != nil {
+		driver.Close()
+		return Status{}, err
+	}
+	defer m.Close()
+	m.Log = logger{}
+
+	st := Status{Latest: latest}
+	st.Version, st.Dirty, err = m.Version()
+	switch {
+	case errors.Is(err, migrate.ErrNilVersion) && baseline > 0:
+		if baseline > latest {
+			return st, fmt.Errorf("migrate: baseline %d is past the last migration, %d", baseline, latest)
+		}
+		slog.Info("migrate: recording baseline", "version", baseline)
+		if err := m.Force(int(baseline)); err != nil {
+			return st, err
+		}
+	case err != nil && !errors.Is(err, migrate.ErrNilVersion):
+		return st, err
+	case st.Dirty:
+		return st, fmt.Errorf("migrate: version %d is dirty; repair the schema and force the version", st.Version)
+	}
+
+	if err := m.Up(); err != nil && !errors.Is(err, migrate.ErrNoChange) {
+		st.Version, st.Dirty, _ = m.Version()
+		return st, err
+	}
+	st.Version, st.Dirty, err = m.Version()
+	return st, err
+}
+
+// Current reads the version of db without locking or changing anything.
+// A database without schema_migrations is at version 0.
+func Current(ctx context.Context, db *sql.DB) (Status, error) {
+	latest, err := Latest()
+	if err != nil {
+		return Status{}, err
+	}
+	st := Status{Latest: latest}
+	var version int64
+	err = db.QueryRowContext(ctx, "SELECT version, dirty FROM "+migratemysql.DefaultMigrationsTable+" LIMIT 1").Scan(&version, &st.Dirty)
+	var me *mysql.MySQLError
+	switch {
+	case errors.Is(err, sql.ErrNoRows), errors.As(err, &me) && me.Number == 1146: // ER_NO_SUCH_TABLE
+		return st, nil
+	case err != nil:
+		return st, err
+	}
+	if version > 0 {
+		st.Version = uint(version)
+	}
+	return st, nil
+}
+
+// Check is a health probe of the schema: its detail is the Status, and it
+// fails while the schema is dirty. A database behind this build is only
+// reported, since it is not migrated without MIGRATE_ON_START.
+func Check(db *sql.DB) health.Probe {
+	return func(ctx context.Context) (any, error) {
+		st, err := Current(ctx, db)
+		if err == nil && st.Dirty {
+			err = fmt.Errorf("version %d is dirty", st.Version)
+		}
+		return st, err
+	}
+}
+
+// logger logs the migrations Up applies, "1/u init (18ms)".
+type logger struct{}
+
+func (logger) Printf(format string, v ...any) {
+	slog.Info("migrate: " + strings.TrimSpace(fmt.Sprintf(format, v...)))
+}
+
+func (logger) Verbose() bool { return false }
@@ -0,0 +1,34 @@
+package observability
+
+import (
+	"net/http"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	oteltrace "go.opentelemetry.io/otel/trace"
+)
+
+// HTTPHandler traces every request to next as a server span named
+// "METHOD route", continuing the caller's trace when it sends traceparent.
+// route returns the template of the request's route (/v1/messages/{id}) so
+// span names stay few; it is called once next has served the request, so
+// a router inside next can have matched it by then. The span's context
+// goes back in the traceparent response header: a client that sends it on
+// follow-up requests, such as polling for the result of an operation, puts
+// them in the same trace.
+func HTTPHandler(next http.Handler, service string, route func(r *http.Request) string) http.Handler {
+	inner := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		otel.GetTextMapPropagator().Inject(r.Context(), propagation.HeaderCarrier(w.Header()))
+		next.ServeHTTP(w, r)
+		rt := route(r)
+		span := oteltrace.SpanFromContext(r.Context())
+		span.SetName(r.Method + " " + rt)
+		span.SetAttributes(semconv.HTTPRoute(rt))
+	})
+	return otelhttp.NewHandler(inner, service,
+		otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string {
+			return r.Method
+		}))
+}
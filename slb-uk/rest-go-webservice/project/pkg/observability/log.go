@@ -0,0 +1,51 @@
+package observability
+
+import (
+	"context"
+	"log/slog"
+	"os"
+
+	oteltrace "go.opentelemetry.io/otel/trace"
+
+	"github.com/slb-uk/rest-go-webservice/project/pkg/trace"
+)
+
+// LogHandler adds correlation ids from the context to every record:
+//   - trace_id: the operation id clients see (pkg/trace), when set
+//   - otel.trace_id / otel.span_id: the active span, when recording
+//
+// Use the *Context logging functions (slog.InfoContext, ...) so the handler
+// can see the context.
+type LogHandler struct {
+	slog.Handler
+}
+
+// NewLogHandler wraps next and tags every record with service.
+func NewLogHandler(next slog.Handler, service string) *LogHandler {
+	return &LogHandler{Handler: next.WithAttrs([]slog.Attr{slog.String("service", service)})}
+}
+
+func (h *LogHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id, ok := trace.GetTraceID(ctx); ok {
+		r.AddAttrs(slog.String("trace_id", id))
+	}
+	if sc := oteltrace.SpanContextFromContext(ctx); sc.IsValid() {
+		r.AddAttrs(slog.String("otel.trace_id", sc.TraceID().String()), slog.String("otel.span_id", sc.SpanID().String()))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h *LogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &LogHandler{Handler: h.Handler.WithAttrs(attrs)}
+}
+
+func (h *LogHandler) WithGroup(name string) slog.Handler {
+	return &LogHandler{Handler: h.Handler.WithGroup(name)}
+}
+
+// Fatal logs msg and args at error level and exits, the slog counterpart of
+// log.Fatal for main functions. Deferred calls do not run.
+func Fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
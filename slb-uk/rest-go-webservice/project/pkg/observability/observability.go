@@ -0,0 +1,157 @@
+// Package observability wires traces, metrics and logs for a service from
+// one Config, so they correlate: every log line and histogram exemplar
+// carries the ids of the span it was written under.
+//
+// A new command loads its Config with pkg/config and needs one line:
+//
+//	defer observability.MustStart(conf.Observability.Config("mycmd"))()
+package observability
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	oteltrace "go.opentelemetry.io/otel/trace"
+)
+
+// Config selects the exporters. The zero value logs text at info level and
+// exports nothing.
+type Config struct {
+	Service string
+	Version string
+
+	// TraceExporter is "otlp" (OTLP over HTTP to OTLPEndpoint), "stdout"
+	// or "none".
+	TraceExporter string
+	OTLPEndpoint  string // host:port; empty uses the exporter's default
+	OTLPInsecure  bool
+	SampleRatio   float64 // of new root traces; child spans follow the parent
+
+	// MetricsAddr serves the default Prometheus registry at /metrics; empty
+	// or "off" disables the listener (metrics are still collected).
+	MetricsAddr string
+
+	LogLevel  slog.Level
+	LogFormat string // "text" or "json"
+}
+
+// Setup installs the global tracer provider, propagator and slog default
+// logger (which the standard log package also writes through), and starts
+// the metrics listener. shutdown flushes pending spans.
+func Setup(ctx context.Context, cfg Config) (shutdown func(context.Context) error, err error) {
+	slog.SetDefault(slog.New(NewLogHandler(newBaseHandler(cfg), cfg.Service)))
+
+	exp, err := newSpanExporter(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	res := resource.NewWithAttributes(semconv.SchemaURL,
+		semconv.ServiceName(cfg.Service),
+		semconv.ServiceVersion(cfg.Version),
+	)
+	opts := []sdktrace.TracerProviderOption{
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRatio))),
+	}
+	if exp != nil {
+		opts = append(opts, sdktrace.WithBatcher(exp))
+	}
+	tp := sdktrace.NewTracerProvider(opts...)
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	// go_build_info; registered once even if Setup is called again
+	_ = prometheus.Register(collectors.NewBuildInfoCollector())
+	if cfg.MetricsAddr != "" && cfg.MetricsAddr != "off" {
+		serveMetrics(cfg.MetricsAddr)
+	}
+	slog.Info("observability ready", "traces", cfg.TraceExporter, "metrics_addr", cfg.MetricsAddr, "log_level", cfg.LogLevel.String())
+	return tp.Shutdown, nil
+}
+
+// MustStart is Setup for main functions; it exits when the exporter
+// cannot be created. The returned func flushes spans, waiting up to 5s.
+func MustStart(cfg Config) func() {
+	shutdown, err := Setup(context.Background(), cfg)
+	if err != nil {
+		Fatal("observability", "err", err)
+	}
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdown(ctx); err != nil {
+			slog.Warn("observability shutdown", "err", err)
+		}
+	}
+}
+
+// Tracer returns a tracer from the global provider.
+func Tracer(name string) oteltrace.Tracer { return otel.Tracer(name) }
+
+// Exemplar returns the labels linking an observation to the current span,
+// for ObserveWithExemplar/AddWithExemplar; nil when ctx is not sampled.
+func Exemplar(ctx context.Context) prometheus.Labels {
+	sc := oteltrace.SpanContextFromContext(ctx)
+	if !sc.IsSampled() {
+		return nil
+	}
+	return prometheus.Labels{"trace_id": sc.TraceID().String()}
+}
+
+func newSpanExporter(ctx context.Context, cfg Config) (sdktrace.SpanExporter, error) {
+	switch cfg.TraceExporter {
+	case "", "none":
+		return nil, nil
+	case "stdout":
+		return stdouttrace.New(stdouttrace.WithWriter(os.Stderr))
+	case "otlp":
+		var opts []otlptracehttp.Option
+		if cfg.OTLPEndpoint != "" {
+			opts = append(opts, otlptracehttp.WithEndpoint(cfg.OTLPEndpoint))
+		}
+		if cfg.OTLPInsecure {
+			opts = append(opts, otlptracehttp.WithInsecure())
+		}
+		return otlptracehttp.New(ctx, opts...)
+	default:
+		return nil, fmt.Errorf("unknown trace exporter %q (want otlp, stdout or none)", cfg.TraceExporter)
+	}
+}
+
+func newBaseHandler(cfg Config) slog.Handler {
+	opts := &slog.HandlerOptions{Level: cfg.LogLevel}
+	if cfg.LogFormat == "json" {
+		return slog.NewJSONHandler(os.Stderr, opts)
+	}
+	return slog.NewTextHandler(os.Stderr, opts)
+}
+
+// serveMetrics exposes the default registry on addr in the background, in
+// OpenMetrics format when asked so exemplars are visible. Scrapes are
+// themselves counted in promhttp_metric_handler_requests_total.
+func serveMetrics(addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.InstrumentMetricHandler(prometheus.DefaultRegisterer,
+		promhttp.HandlerFor(prometheus.DefaultGatherer, promhttp.HandlerOpts{EnableOpenMetrics: true})))
+	go func() {
+		slog.Info("metrics listening", "addr", addr)
+		if err := http.ListenAndServe(addr, mux); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("metrics server", "err", err)
+		}
+	}()
+}
@@ -0,0 +1,158 @@
+// Package problem writes error responses as RFC 7807 problem details:
+//
+//	HTTP/1.1 404 Not Found
+//	Content-Type: application/problem+json
+//
+//	{"type":"https://example.com/problems/not-found","title":"Not Found","status":404,
+//	 "detail":"id=42","instance":"/v1/operations/6f1c…","code":"NOT_FOUND","trace_id":"6f1c…"}
+//
+// The type is derived from code, a stable machine-readable error code shared
+// with the consumers' Ack.Error, so clients can branch on either.
+package problem
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+	oteltrace "go.opentelemetry.io/otel/trace"
+
+	"github.com/slb-uk/rest-go-webservice/project/pkg/trace"
+)
+
+// ContentType is the media type of a problem response.
+const ContentType = "application/problem+json"
+
+// TypeBase prefixes the type URI of every problem; apisvc sets it from
+// PROBLEM_TYPE_BASE.
+var TypeBase = "https://example.com/problems/"
+
+// Details is the problem+json body.
+type Details struct {
+	Type     string `json:"type" example:"https://example.com/problems/not-found"`
+	Title    string `json:"title" example:"Not Found"`
+	Status   int    `json:"status" example:"404"`
+	Detail   string `json:"detail,omitempty" example:"id=42"`
+	Instance string `json:"instance,omitempty" example:"/v1/operations/6f1c2a0e-0d7b-4c55-9c64-8a3c1f0e9b11"`
+	Code     string `json:"code" example:"NOT_FOUND"`
+	// TraceID is the operation's trace id when the error came back in its
+	// ack, otherwise the request's; quote it when reporting a problem.
+	TraceID string `json:"trace_id" example:"6f1c2a0e-0d7b-4c55-9c64-8a3c1f0e9b11"`
+}
+
+// Codes for errors raised by the API itself; consumer codes come from
+// Ack.Error.
+const (
+	CodeInvalidBody          = "INVALID_BODY"
+	CodeInvalidParam         = "INVALID_PARAM"
+	CodeNotFound             = "NOT_FOUND"
+	CodeInvalidHeader        = "INVALID_HEADER"
+	CodeUnknownTenant        = "UNKNOWN_TENANT"
+	CodeMethodNotAllowed     = "METHOD_NOT_ALLOWED"
+	CodeTimeout              = "TIMEOUT"
+	CodeKafkaUnavailable     = "KAFKA_UNAVAILABLE"
+	CodeEnqueueFailed        = "ENQUEUE_FAILED"
+	CodeNotImplemented       = "NOT_IMPLEMENTED"
+	CodeStarting             = "STARTING"
+	CodeTooLarge             = "TOO_LARGE"
+	CodeStorageError         = "STORAGE_ERROR"
+	CodeDBError              = "DB_ERROR"
+	CodeStreamingUnsupported = "STREAMING_UNSUPPORTED"
+	CodeIdempotencyKeyReused = "IDEMPOTENCY_KEY_REUSED"
+
+	// pkg/auth's, the RFC 6750 error codes in upper case
+	CodeInvalidRequest    = "INVALID_REQUEST"
+	CodeInvalidToken      = "INVALID_TOKEN"
+	CodeInsufficientScope = "INSUFFICIENT_SCOPE"
+	CodeInvalidTenant     = "INVALID_TENANT"
+)
+
+// New returns a problem of the given status and code.
+func New(status int, code, detail string) *Details {
+	return &Details{
+		Type:   TypeBase + strings.ReplaceAll(strings.ToLower(code), "_", "-"),
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+		Code:   code,
+	}
+}
+
+// ackStatus maps the consumers' Ack.Error codes to HTTP statuses.
+var ackStatus = map[string]int{
+	"BAD_REQUEST":    http.StatusBadRequest,
+	"UNSUPPORTED":    http.StatusBadRequest, // a command consumersvc does not know
+	"NOT_FOUND":      http.StatusNotFound,
+	"DB_ERROR":       http.StatusInternalServerError, // transient ones are retried by the consumer first
+	"INTERNAL":       http.StatusInternalServerError,
+	"QUOTA_EXCEEDED": http.StatusForbidden, // CreateWithQuota; see tenant_quotas
+}
+
+// StatusForCode is the HTTP status for a consumer error code; codes it does
+// not know are a failure of the upstream worker, 502.
+func StatusForCode(code string) int {
+	if s, ok := ackStatus[code]; ok {
+		return s
+	}
+	return http.StatusBadGateway
+}
+
+// FromAck turns the error of a failed operation into a problem carrying the
+// operation's trace id.
+func FromAck(traceID, code, detail string) *Details {
+	if code == "" {
+		code = "INTERNAL"
+	}
+	p := New(StatusForCode(code), code, detail)
+	p.TraceID = traceID
+	return p
+}
+
+// TraceID returns the trace id of ctx: the one set with trace.WithTraceID,
+// else the active span's, else "".
+func TraceID(ctx context.Context) string {
+	if id, ok := trace.GetTraceID(ctx); ok {
+		return id
+	}
+	if sc := oteltrace.SpanContextFromContext(ctx); sc.IsValid() {
+		return sc.TraceID().String()
+	}
+	return ""
+}
+
+// Write sends p as the response to r. Instance defaults to the request
+// path and TraceID to the request's, or a new id that is logged with 5xx
+// problems so they can be found later.
+func (p *Details) Write(w http.ResponseWriter, r *http.Request) {
+	if p.Instance == "" {
+		p.Instance = r.URL.Path
+	}
+	if p.TraceID == "" {
+		p.TraceID = TraceID(r.Context())
+	}
+	if p.TraceID == "" {
+		p.TraceID = uuid.NewString()
+	}
+	if p.Status >= 500 {
+		slog.ErrorContext(r.Context(), "problem", "trace_id", p.TraceID, "status", p.Status, "code", p.Code,
+			"instance", p.Instance, "detail", p.Detail)
+	}
+	w.Header().Set("Content-Type", ContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(p.Status)
+	_ = json.NewEncoder(w).Encode(p)
+}
+
+// Write is New(status, code, detail).Write(w, r).
+func Write(w http.ResponseWriter, r *http.Request, status int, code, detail string) {
+	New(status, code, detail).Write(w, r)
+}
+
+// MethodNotAllowed answers 405 with the allowed methods.
+func MethodNotAllowed(w http.ResponseWriter, r *http.Request, allow ...string) {
+	w.Header().Set("Allow", strings.Join(allow, ", "))
+	Write(w, r, http.StatusMethodNotAllowed, CodeMethodNotAllowed, r.Method+" is not supported here")
+}
@@ -0,0 +1,46 @@
+package problem
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// Every Ack.Error code consumersvc sends, and what the API answers it with.
+func TestStatusForCode(t *testing.T) {
+	cases := []struct {
+		code   string
+		status int
+	}{
+		{"BAD_REQUEST", http.StatusBadRequest}, // QueryAudit's filter, a bulk query
+		{"UNSUPPORTED", http.StatusBadRequest}, // unknown command
+		{"NOT_FOUND", http.StatusNotFound},     // Read, Update, Delete
+		{"DB_ERROR", http.StatusInternalServerError},
+		{"INTERNAL", http.StatusInternalServerError}, // a failed transaction, dead-lettered
+		{"QUOTA_EXCEEDED", http.StatusForbidden},     // CreateWithQuota
+		// not a code of ours: the worker misbehaved
+		{"FROBNICATED", http.StatusBadGateway},
+	}
+	for _, tc := range cases {
+		if got := StatusForCode(tc.code); got != tc.status {
+			t.Errorf("StatusForCode(%s) = %d, want %d", tc.code, got, tc.status)
+		}
+	}
+}
+
+func TestFromAck(t *testing.T) {
+	p := FromAck("t-1", "", "boom")
+	if p.Code != "INTERNAL" || p.Status != http.StatusInternalServerError || p.TraceID != "t-1" {
+		t.Fatalf("%+v", p)
+	}
+	p = FromAck("t-1", "UNSUPPORTED", "unknown command")
+	if p.Status != http.StatusBadRequest || p.Type != TypeBase+"unsupported" || p.Title != "Bad Request" {
+		t.Fatalf("%+v", p)
+	}
+
+	w := httptest.NewRecorder()
+	p.Write(w, httptest.NewRequest(http.MethodGet, "/v1/operations/t-1", nil))
+	if w.Code != http.StatusBadRequest || w.Header().Get("Content-Type") != ContentType || p.Instance != "/v1/operations/t-1" {
+		t.Fatalf("status %d, headers %v, instance %q", w.Code, w.Header(), p.Instance)
+	}
+}
@@ -0,0 +1,182 @@
+// Package readmodel reads messages straight from the MySQL tables
+// consumersvc writes, so apisvc can answer GET and list requests without
+// a round trip through Kafka. Writes still go through Kafka; pointed at a
+// replica, reads may trail them by the replication lag.
+package readmodel
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	_ "github.com/go-sql-driver/mysql"
+
+	"github.com/slb-uk/rest-go-webservice/project/pkg/audit"
+	"github.com/slb-uk/rest-go-webservice/project/pkg/blob"
+)
+
+// ErrNotFound is returned by Get for a message the tenant does not have.
+var ErrNotFound = errors.New("readmodel: message not found")
+
+// Page sizes for List.
+const (
+	DefaultLimit = 20
+	MaxLimit     = 100
+)
+
+// Message is a stored message with its attachment, if any.
+type Message struct {
+	ID         int64     `json:"id"`
+	Message    string    `json:"message"`
+	Attachment *blob.Ref `json:"attachment,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// Filter selects a page of one tenant's messages.
+type Filter struct {
+	TenantID string
+	// Cursor is the NextCursor of the previous page; 0 starts at the newest.
+	Cursor int64
+	Limit  int
+}
+
+// Page is one page of messages, newest first. NextCursor is 0 on the last
+// page.
+type Page struct {
+	Messages   []Message `json:"messages"`
+	NextCursor int64     `json:"next_cursor,omitempty"`
+}
+
+// Store queries the read model.
+type Store struct {
+	db      *sql.DB
+	timeout time.Duration
+}
+
+// Open connects to the MySQL database at dsn, a go-sql-driver DSN that
+// needs parseTime=true. Every query gives up after timeout, so a slow
+// replica fails a read instead of holding the request.
+func Open(ctx context.Context, dsn string, maxConns int, timeout time.Duration) (*Store, error) {
+	db, err := sql.Open("mysql", dsn)
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(maxConns)
+	db.SetMaxIdleConns(maxConns)
+	s := New(db, timeout)
+	if err := s.Ping(ctx); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// New returns a Store on db.
+func New(db *sql.DB, timeout time.Duration) *Store {
+	return &Store{db: db, timeout: timeout}
+}
+
+// Close closes the database.
+func (s *Store) Close() error { return s.db.Close() }
+
+// Ping checks the database is reachable, for the readiness probe.
+func (s *Store) Ping(ctx context.Context) error {
+	ctx, cancel := s.ctx(ctx)
+	defer cancel()
+	return s.db.PingContext(ctx)
+}
+
+func (s *Store) ctx(ctx context.Context) (context.Context, context.CancelFunc) {
+	if s.timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, s.timeout)
+}
+
+const selectMessages = `SELECT m.id, m.message, m.created_at, m.updated_at,
+		a.blob_key, a.filename, a.content_type, a.size_bytes, a.sha256
+	FROM messages m
+	LEFT JOIN message_attachments a ON a.tenant_id = m.tenant_id AND a.message_id = m.id`
+
+// Get returns message id of tenantID.
+func (s *Store) Get(ctx context.Context, tenantID string, id int64) (Message, error) {
+	ctx, cancel := s.ctx(ctx)
+	defer cancel()
+	rows, err := s.db.QueryContext(ctx, selectMessages+` WHERE m.tenant_id=? AND m.id=?`, tenantID, id)
+	if err != nil {
+		return Message{}, err
+	}
+	ms, err := scanMessages(rows)
+	if err != nil {
+		return Message{}, err
+	}
+	if len(ms) == 0 {
+		return Message{}, ErrNotFound
+	}
+	return ms[0], nil
+}
+
+// List returns a page of f.TenantID's messages, newest first. Pages are
+// keyed on the id, like audit.List, so messages created while a client
+// pages through are never skipped or repeated.
+func (s *Store) List(ctx context.Context, f Filter) (Page, error) {
+	if f.Limit <= 0 {
+		f.Limit = DefaultLimit
+	}
+	f.Limit = min(f.Limit, MaxLimit)
+	where := []string{"m.tenant_id=?"}
+	args := []any{f.TenantID}
+	if f.Cursor > 0 {
+		where = append(where, "m.id<?")
+		args = append(args, f.Cursor)
+	}
+	// one extra row tells whether there is a next page
+	args = append(args, f.Limit+1)
+
+	ctx, cancel := s.ctx(ctx)
+	defer cancel()
+	rows, err := s.db.QueryContext(ctx, selectMessages+` WHERE `+strings.Join(where, " AND ")+` ORDER BY m.id DESC LIMIT ?`, args...)
+	if err != nil {
+		return Page{}, err
+	}
+	ms, err := scanMessages(rows)
+	if err != nil {
+		return Page{}, err
+	}
+	page := Page{Messages: ms}
+	if len(ms) > f.Limit {
+		page.Messages = ms[:f.Limit]
+		page.NextCursor = ms[f.Limit-1].ID
+	}
+	return page, nil
+}
+
+// Audit lists audit_log entries; see audit.List.
+func (s *Store) Audit(ctx context.Context, f audit.Filter) (audit.Page, error) {
+	ctx, cancel := s.ctx(ctx)
+	defer cancel()
+	return audit.List(ctx, s.db, f)
+}
+
+func scanMessages(rows *sql.Rows) ([]Message, error) {
+	defer rows.Close()
+	ms := []Message{}
+	for rows.Next() {
+		var m Message
+		var key, filename, contentType, sha sql.NullString
+		var size sql.NullInt64
+		if err := rows.Scan(&m.ID, &m.Message, &m.CreatedAt, &m.UpdatedAt,
+			&key, &filename, &contentType, &size, &sha); err != nil {
+			return nil, err
+		}
+		if key.Valid {
+			m.Attachment = &blob.Ref{Key: key.String, Filename: filename.String, ContentType: contentType.String,
+				Size: size.Int64, SHA256: sha.String}
+		}
+		ms = append(ms, m)
+	}
+	return ms, rows.Err()
+}
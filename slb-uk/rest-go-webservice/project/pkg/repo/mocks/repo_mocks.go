@@ -0,0 +1,367 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: repo.go
+
+// Package mocks is a generated GoMock package.
+package mocks
+
+import (
+	context "context"
+	reflect "reflect"
+
+	gomock "github.com/golang/mock/gomock"
+	audit "github.com/slb-uk/rest-go-webservice/project/pkg/audit"
+	blob "github.com/slb-uk/rest-go-webservice/project/pkg/blob"
+	bulk "github.com/slb-uk/rest-go-webservice/project/pkg/bulk"
+	repo "github.com/slb-uk/rest-go-webservice/project/pkg/repo"
+)
+
+// MockRepository is a mock of Repository interface.
+type MockRepository struct {
+	ctrl     *gomock.Controller
+	recorder *MockRepositoryMockRecorder
+}
+
+// MockRepositoryMockRecorder is the mock recorder for MockRepository.
+type MockRepositoryMockRecorder struct {
+	mock *MockRepository
+}
+
+// NewMockRepository creates a new mock instance.
+func NewMockRepository(ctrl *gomock.Controller) *MockRepository {
+	mock := &MockRepository{ctrl: ctrl}
+	mock.recorder = &MockRepositoryMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockRepository) EXPECT() *MockRepositoryMockRecorder {
+	return m.recorder
+}
+
+// CountMessages mocks base method.
+func (m *MockRepository) CountMessages(ctx context.Context, q bulk.Query) (int64, int64, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CountMessages", ctx, q)
+	ret0, _ := ret[0].(int64)
+	ret1, _ := ret[1].(int64)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// CountMessages indicates an expected call of CountMessages.
+func (mr *MockRepositoryMockRecorder) CountMessages(ctx, q interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CountMessages", reflect.TypeOf((*MockRepository)(nil).CountMessages), ctx, q)
+}
+
+// InTx mocks base method.
+func (m *MockRepository) InTx(ctx context.Context, fn func(repo.Tx) error) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "InTx", ctx, fn)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// InTx indicates an expected call of InTx.
+func (mr *MockRepositoryMockRecorder) InTx(ctx, fn interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "InTx", reflect.TypeOf((*MockRepository)(nil).InTx), ctx, fn)
+}
+
+// MockTx is a mock of Tx interface.
+type MockTx struct {
+	ctrl     *gomock.Controller
+	recorder *MockTxMockRecorder
+}
+
+// MockTxMockRecorder is the mock recorder for MockTx.
+type MockTxMockRecorder struct {
+	mock *MockTx
+}
+
+// NewMockTx creates a new mock instance.
+func NewMockTx(ctrl *gomock.Controller) *MockTx {
+	mock := &MockTx{ctrl: ctrl}
+	mock.recorder = &MockTxMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockTx) EXPECT() *MockTxMockRecorder {
+	return m.recorder
+}
+
+// ChargeQuota mocks base method.
+func (m *MockTx) ChargeQuota(ctx context.Context, tenantID string, n int64) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ChargeQuota", ctx, tenantID, n)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ChargeQuota indicates an expected call of ChargeQuota.
+func (mr *MockTxMockRecorder) ChargeQuota(ctx, tenantID, n interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ChargeQuota", reflect.TypeOf((*MockTx)(nil).ChargeQuota), ctx, tenantID, n)
+}
+
+// CheckIdempotency mocks base method.
+func (m *MockTx) CheckIdempotency(ctx context.Context, tenantID, key string) (repo.Idempotency, bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CheckIdempotency", ctx, tenantID, key)
+	ret0, _ := ret[0].(repo.Idempotency)
+	ret1, _ := ret[1].(bool)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// CheckIdempotency indicates an expected call of CheckIdempotency.
+func (mr *MockTxMockRecorder) CheckIdempotency(ctx, tenantID, key interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CheckIdempotency", reflect.TypeOf((*MockTx)(nil).CheckIdempotency), ctx, tenantID, key)
+}
+
+// ChunkMessages mocks base method.
+func (m *MockTx) ChunkMessages(ctx context.Context, q bulk.Query, after, last int64, limit int) ([]bulk.Row, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ChunkMessages", ctx, q, after, last, limit)
+	ret0, _ := ret[0].([]bulk.Row)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ChunkMessages indicates an expected call of ChunkMessages.
+func (mr *MockTxMockRecorder) ChunkMessages(ctx, q, after, last, limit interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ChunkMessages", reflect.TypeOf((*MockTx)(nil).ChunkMessages), ctx, q, after, last, limit)
+}
+
+// DeleteAttachment mocks base method.
+func (m *MockTx) DeleteAttachment(ctx context.Context, tenantID string, messageID int64) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteAttachment", ctx, tenantID, messageID)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteAttachment indicates an expected call of DeleteAttachment.
+func (mr *MockTxMockRecorder) DeleteAttachment(ctx, tenantID, messageID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteAttachment", reflect.TypeOf((*MockTx)(nil).DeleteAttachment), ctx, tenantID, messageID)
+}
+
+// DeleteMessage mocks base method.
+func (m *MockTx) DeleteMessage(ctx context.Context, tenantID string, id int64) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteMessage", ctx, tenantID, id)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteMessage indicates an expected call of DeleteMessage.
+func (mr *MockTxMockRecorder) DeleteMessage(ctx, tenantID, id interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteMessage", reflect.TypeOf((*MockTx)(nil).DeleteMessage), ctx, tenantID, id)
+}
+
+// DeleteMessages mocks base method.
+func (m *MockTx) DeleteMessages(ctx context.Context, tenantID string, ids []int64) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteMessages", ctx, tenantID, ids)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteMessages indicates an expected call of DeleteMessages.
+func (mr *MockTxMockRecorder) DeleteMessages(ctx, tenantID, ids interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteMessages", reflect.TypeOf((*MockTx)(nil).DeleteMessages), ctx, tenantID, ids)
+}
+
+// GetAttachment mocks base method.
+func (m *MockTx) GetAttachment(ctx context.Context, tenantID string, messageID int64) (*blob.Ref, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetAttachment", ctx, tenantID, messageID)
+	ret0, _ := ret[0].(*blob.Ref)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetAttachment indicates an expected call of GetAttachment.
+func (mr *MockTxMockRecorder) GetAttachment(ctx, tenantID, messageID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetAttachment", reflect.TypeOf((*MockTx)(nil).GetAttachment), ctx, tenantID, messageID)
+}
+
+// GetMessage mocks base method.
+func (m *MockTx) GetMessage(ctx context.Context, tenantID string, id int64) (repo.Message, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetMessage", ctx, tenantID, id)
+	ret0, _ := ret[0].(repo.Message)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetMessage indicates an expected call of GetMessage.
+func (mr *MockTxMockRecorder) GetMessage(ctx, tenantID, id interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetMessage", reflect.TypeOf((*MockTx)(nil).GetMessage), ctx, tenantID, id)
+}
+
+// InsertMessage mocks base method.
+func (m *MockTx) InsertMessage(ctx context.Context, tenantID, message string) (int64, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "InsertMessage", ctx, tenantID, message)
+	ret0, _ := ret[0].(int64)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// InsertMessage indicates an expected call of InsertMessage.
+func (mr *MockTxMockRecorder) InsertMessage(ctx, tenantID, message interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "InsertMessage", reflect.TypeOf((*MockTx)(nil).InsertMessage), ctx, tenantID, message)
+}
+
+// ListAudit mocks base method.
+func (m *MockTx) ListAudit(ctx context.Context, f audit.Filter) (audit.Page, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListAudit", ctx, f)
+	ret0, _ := ret[0].(audit.Page)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListAudit indicates an expected call of ListAudit.
+func (mr *MockTxMockRecorder) ListAudit(ctx, f interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListAudit", reflect.TypeOf((*MockTx)(nil).ListAudit), ctx, f)
+}
+
+// LockMessage mocks base method.
+func (m *MockTx) LockMessage(ctx context.Context, tenantID string, id int64) (repo.Message, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "LockMessage", ctx, tenantID, id)
+	ret0, _ := ret[0].(repo.Message)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// LockMessage indicates an expected call of LockMessage.
+func (mr *MockTxMockRecorder) LockMessage(ctx, tenantID, id interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "LockMessage", reflect.TypeOf((*MockTx)(nil).LockMessage), ctx, tenantID, id)
+}
+
+// LogSaga mocks base method.
+func (m *MockTx) LogSaga(ctx context.Context, tenantID, traceID, step, status, code, detail string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "LogSaga", ctx, tenantID, traceID, step, status, code, detail)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// LogSaga indicates an expected call of LogSaga.
+func (mr *MockTxMockRecorder) LogSaga(ctx, tenantID, traceID, step, status, code, detail interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "LogSaga", reflect.TypeOf((*MockTx)(nil).LogSaga), ctx, tenantID, traceID, step, status, code, detail)
+}
+
+// MarkIdempotent mocks base method.
+func (m *MockTx) MarkIdempotent(ctx context.Context, tenantID, key string, rec repo.Idempotency) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "MarkIdempotent", ctx, tenantID, key, rec)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// MarkIdempotent indicates an expected call of MarkIdempotent.
+func (mr *MockTxMockRecorder) MarkIdempotent(ctx, tenantID, key, rec interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "MarkIdempotent", reflect.TypeOf((*MockTx)(nil).MarkIdempotent), ctx, tenantID, key, rec)
+}
+
+// RecordAudit mocks base method.
+func (m *MockTx) RecordAudit(ctx context.Context, e audit.Entry) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RecordAudit", ctx, e)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RecordAudit indicates an expected call of RecordAudit.
+func (mr *MockTxMockRecorder) RecordAudit(ctx, e interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RecordAudit", reflect.TypeOf((*MockTx)(nil).RecordAudit), ctx, e)
+}
+
+// RecordSagaStep mocks base method.
+func (m *MockTx) RecordSagaStep(ctx context.Context, tenantID, traceID string, s repo.SagaStep) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RecordSagaStep", ctx, tenantID, traceID, s)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RecordSagaStep indicates an expected call of RecordSagaStep.
+func (mr *MockTxMockRecorder) RecordSagaStep(ctx, tenantID, traceID, s interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RecordSagaStep", reflect.TypeOf((*MockTx)(nil).RecordSagaStep), ctx, tenantID, traceID, s)
+}
+
+// SagaSteps mocks base method.
+func (m *MockTx) SagaSteps(ctx context.Context, tenantID, traceID string) ([]repo.SagaStep, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SagaSteps", ctx, tenantID, traceID)
+	ret0, _ := ret[0].([]repo.SagaStep)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// SagaSteps indicates an expected call of SagaSteps.
+func (mr *MockTxMockRecorder) SagaSteps(ctx, tenantID, traceID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SagaSteps", reflect.TypeOf((*MockTx)(nil).SagaSteps), ctx, tenantID, traceID)
+}
+
+// SaveAttachment mocks base method.
+func (m *MockTx) SaveAttachment(ctx context.Context, tenantID string, messageID int64, ref blob.Ref) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SaveAttachment", ctx, tenantID, messageID, ref)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SaveAttachment indicates an expected call of SaveAttachment.
+func (mr *MockTxMockRecorder) SaveAttachment(ctx, tenantID, messageID, ref interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveAttachment", reflect.TypeOf((*MockTx)(nil).SaveAttachment), ctx, tenantID, messageID, ref)
+}
+
+// UpdateMessage mocks base method.
+func (m *MockTx) UpdateMessage(ctx context.Context, tenantID string, id int64, message string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpdateMessage", ctx, tenantID, id, message)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// UpdateMessage indicates an expected call of UpdateMessage.
+func (mr *MockTxMockRecorder) UpdateMessage(ctx, tenantID, id, message interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateMessage", reflect.TypeOf((*MockTx)(nil).UpdateMessage), ctx, tenantID, id, message)
+}
+
+// UpdateMessages mocks base method.
+func (m *MockTx) UpdateMessages(ctx context.Context, tenantID string, ids []int64, message string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpdateMessages", ctx, tenantID, ids, message)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// UpdateMessages indicates an expected call of UpdateMessages.
+func (mr *MockTxMockRecorder) UpdateMessages(ctx, tenantID, ids, message interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateMessages", reflect.TypeOf((*MockTx)(nil).UpdateMessages), ctx, tenantID, ids, message)
+}
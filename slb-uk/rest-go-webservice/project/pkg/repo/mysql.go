@@ -0,0 +1,232 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/slb-uk/rest-go-webservice/project/pkg/audit"
+	"github.com/slb-uk/rest-go-webservice/project/pkg/blob"
+	"github.com/slb-uk/rest-go-webservice/project/pkg/bulk"
+)
+
+// MySQL is the Repository over the tables of migrations/.
+type MySQL struct {
+	db *sql.DB
+}
+
+func NewMySQL(db *sql.DB) *MySQL { return &MySQL{db: db} }
+
+func (r *MySQL) InTx(ctx context.Context, fn func(Tx) error) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := fn(mysqlTx{tx}); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *MySQL) CountMessages(ctx context.Context, q bulk.Query) (n, last int64, err error) {
+	return bulk.Count(ctx, r.db, q)
+}
+
+type mysqlTx struct{ tx *sql.Tx }
+
+func (t mysqlTx) CheckIdempotency(ctx context.Context, tenantID, key string) (Idempotency, bool, error) {
+	var rec Idempotency
+	err := t.tx.QueryRowContext(ctx, "SELECT last_status, trace_id, ack_payload FROM idempotency_keys WHERE tenant_id=? AND idempotency_key=?", tenantID, key).
+		Scan(&rec.Status, &rec.TraceID, &rec.Ack)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Idempotency{}, false, nil
+	} else if err != nil {
+		return Idempotency{}, false, err
+	}
+	return rec, true, nil
+}
+
+// MarkIdempotent keeps the first record of a key. It is not INSERT IGNORE,
+// which would also turn a key too long for the column into a truncated one
+// instead of an error.
+func (t mysqlTx) MarkIdempotent(ctx context.Context, tenantID, key string, rec Idempotency) error {
+	_, err := t.tx.ExecContext(ctx, "INSERT INTO idempotency_keys(tenant_id, idempotency_key, last_status, trace_id, ack_payload) VALUES(?,?,?,?,?) "+
+		"ON DUPLICATE KEY UPDATE idempotency_key=idempotency_key",
+		tenantID, key, rec.Status, rec.TraceID, rec.Ack)
+	return err
+}
+
+func (t mysqlTx) InsertMessage(ctx context.Context, tenantID, message string) (int64, error) {
+	res, err := t.tx.ExecContext(ctx, "INSERT INTO messages(tenant_id, message) VALUES(?,?)", tenantID, message)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+func (t mysqlTx) GetMessage(ctx context.Context, tenantID string, id int64) (Message, error) {
+	return t.getMessage(ctx, "SELECT id, message FROM messages WHERE tenant_id=? AND id=?", tenantID, id)
+}
+
+func (t mysqlTx) LockMessage(ctx context.Context, tenantID string, id int64) (Message, error) {
+	return t.getMessage(ctx, "SELECT id, message FROM messages WHERE tenant_id=? AND id=? FOR UPDATE", tenantID, id)
+}
+
+func (t mysqlTx) getMessage(ctx context.Context, query, tenantID string, id int64) (Message, error) {
+	var m Message
+	err := t.tx.QueryRowContext(ctx, query, tenantID, id).Scan(&m.ID, &m.Message)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Message{}, ErrNotFound
+	}
+	return m, err
+}
+
+func (t mysqlTx) UpdateMessage(ctx context.Context, tenantID string, id int64, message string) error {
+	res, err := t.tx.ExecContext(ctx, "UPDATE messages SET message=? WHERE tenant_id=? AND id=?", message, tenantID, id)
+	if err != nil {
+		return err
+	}
+	return requireRow(res)
+}
+
+func (t mysqlTx) DeleteMessage(ctx context.Context, tenantID string, id int64) error {
+	res, err := t.tx.ExecContext(ctx, "DELETE FROM messages WHERE tenant_id=? AND id=?", tenantID, id)
+	if err != nil {
+		return err
+	}
+	if err := requireRow(res); err != nil {
+		return err
+	}
+	_, err = t.tx.ExecContext(ctx, "DELETE FROM message_attachments WHERE tenant_id=? AND message_id=?", tenantID, id)
+	return err
+}
+
+func (t mysqlTx) ChunkMessages(ctx context.Context, q bulk.Query, after, last int64, limit int) ([]bulk.Row, error) {
+	return bulk.Chunk(ctx, t.tx, q, after, last, limit)
+}
+
+func (t mysqlTx) UpdateMessages(ctx context.Context, tenantID string, ids []int64, message string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	_, err := t.tx.ExecContext(ctx, "UPDATE messages SET message=? WHERE tenant_id=? AND id IN ("+bulk.Placeholders(len(ids))+")",
+		append([]any{message, tenantID}, anys(ids)...)...)
+	return err
+}
+
+func (t mysqlTx) DeleteMessages(ctx context.Context, tenantID string, ids []int64) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	in := "(" + bulk.Placeholders(len(ids)) + ")"
+	args := append([]any{tenantID}, anys(ids)...)
+	if _, err := t.tx.ExecContext(ctx, "DELETE FROM messages WHERE tenant_id=? AND id IN "+in, args...); err != nil {
+		return err
+	}
+	_, err := t.tx.ExecContext(ctx, "DELETE FROM message_attachments WHERE tenant_id=? AND message_id IN "+in, args...)
+	return err
+}
+
+func (t mysqlTx) SaveAttachment(ctx context.Context, tenantID string, messageID int64, ref blob.Ref) error {
+	_, err := t.tx.ExecContext(ctx, `INSERT INTO message_attachments(tenant_id, message_id, blob_key, filename, content_type, size_bytes, sha256)
+		VALUES(?,?,?,?,?,?,?)
+		ON DUPLICATE KEY UPDATE blob_key=VALUES(blob_key), filename=VALUES(filename), content_type=VALUES(content_type),
+		size_bytes=VALUES(size_bytes), sha256=VALUES(sha256)`,
+		tenantID, messageID, ref.Key, ref.Filename, ref.ContentType, ref.Size, ref.SHA256)
+	return err
+}
+
+func (t mysqlTx) GetAttachment(ctx context.Context, tenantID string, messageID int64) (*blob.Ref, error) {
+	var ref blob.Ref
+	err := t.tx.QueryRowContext(ctx, "SELECT blob_key, filename, content_type, size_bytes, sha256 FROM message_attachments WHERE tenant_id=? AND message_id=?", tenantID, messageID).
+		Scan(&ref.Key, &ref.Filename, &ref.ContentType, &ref.Size, &ref.SHA256)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return &ref, nil
+}
+
+func (t mysqlTx) DeleteAttachment(ctx context.Context, tenantID string, messageID int64) error {
+	_, err := t.tx.ExecContext(ctx, "DELETE FROM message_attachments WHERE tenant_id=? AND message_id=?", tenantID, messageID)
+	return err
+}
+
+func (t mysqlTx) ChargeQuota(ctx context.Context, tenantID string, n int64) error {
+	res, err := t.tx.ExecContext(ctx, "UPDATE tenant_quotas SET used_messages=used_messages+? WHERE tenant_id=? AND used_messages+?<=max_messages",
+		n, tenantID, n)
+	if err != nil {
+		return err
+	}
+	if err := requireRow(res); !errors.Is(err, ErrNotFound) {
+		return err
+	}
+	// no row changed: over the quota, or no quota at all
+	var one int
+	err = t.tx.QueryRowContext(ctx, "SELECT 1 FROM tenant_quotas WHERE tenant_id=?", tenantID).Scan(&one)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	return ErrQuotaExceeded
+}
+
+func (t mysqlTx) RecordAudit(_ context.Context, e audit.Entry) error {
+	return audit.Record(t.tx, e)
+}
+
+func (t mysqlTx) ListAudit(ctx context.Context, f audit.Filter) (audit.Page, error) {
+	return audit.List(ctx, t.tx, f)
+}
+
+func (t mysqlTx) LogSaga(ctx context.Context, tenantID, traceID, step, status, code, detail string) error {
+	_, err := t.tx.ExecContext(ctx, "INSERT INTO saga_log(tenant_id, trace_id, step, status, error_code, error_detail) VALUES(?,?,?,?,?,?)",
+		tenantID, traceID, step, status, code, detail)
+	return err
+}
+
+func (t mysqlTx) RecordSagaStep(ctx context.Context, tenantID, traceID string, s SagaStep) error {
+	_, err := t.tx.ExecContext(ctx, "INSERT INTO saga_log(tenant_id, trace_id, step, status, error_code, error_detail, data) VALUES(?,?,?,?,?,?,?)",
+		tenantID, traceID, s.Step, s.Status, s.Code, s.Detail, s.Data)
+	return err
+}
+
+func (t mysqlTx) SagaSteps(ctx context.Context, tenantID, traceID string) ([]SagaStep, error) {
+	rows, err := t.tx.QueryContext(ctx, `SELECT step, status, COALESCE(error_code, ''), COALESCE(error_detail, ''), data
+		FROM saga_log WHERE tenant_id=? AND trace_id=? ORDER BY id`, tenantID, traceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []SagaStep
+	for rows.Next() {
+		var s SagaStep
+		if err := rows.Scan(&s.Step, &s.Status, &s.Code, &s.Detail, &s.Data); err != nil {
+			return nil, err
+		}
+		out = append(out, s)
+	}
+	return out, rows.Err()
+}
+
+func requireRow(res sql.Result) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func anys(ids []int64) []any {
+	out := make([]any, len(ids))
+	for i, id := range ids {
+		out[i] = id
+	}
+	return out
+}
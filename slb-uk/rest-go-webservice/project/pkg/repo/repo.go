@@ -1,9 +1,111 @@
+// Package repo is consumersvc's access to MySQL: every query the command
+// handler runs goes through Repository and the Tx it hands out, so the
+// handler can be tested against the generated mocks in repo/mocks
+// (make mocks regenerates them) instead of a database.
 package repo
 
-import "database/sql"
+//go:generate mockgen -source=repo.go -destination=./mocks/repo_mocks.go -package=mocks
 
-type Repo struct{ DB *sql.DB }
+import (
+	"context"
+	"errors"
 
-func (r *Repo) CheckIdempotency(tx *sql.Tx, key string) (bool, error) { return false, nil }
-func (r *Repo) MarkIdempotent(tx *sql.Tx, key, traceID, status string) error { return nil }
-func (r *Repo) InsertMessage(tx *sql.Tx, msg string) (int64, error) { return 0, nil }
\ No newline at end of file
+	"github.com/slb-uk/rest-go-webservice/project/pkg/audit"
+	"github.com/slb-uk/rest-go-webservice/project/pkg/blob"
+	"github.com/slb-uk/rest-go-webservice/project/pkg/bulk"
+)
+
+// ErrNotFound is returned for a message the tenant does not have.
+var ErrNotFound = errors.New("repo: message not found")
+
+// ErrQuotaExceeded is returned by ChargeQuota when the tenant has used up
+// its quota.
+var ErrQuotaExceeded = errors.New("repo: quota exceeded")
+
+// Message is a stored message.
+type Message struct {
+	ID      int64
+	Message string
+}
+
+// Idempotency is what is kept of a processed command: its final status
+// and trace id, and the ack it was answered with. Ack is nil on rows
+// written before acks were kept.
+type Idempotency struct {
+	Status  string
+	TraceID string
+	Ack     []byte
+}
+
+// SagaStep is a row of saga_log. Data is JSON, what undoing the step
+// needs; nil for steps that log none.
+type SagaStep struct {
+	Step   string
+	Status string
+	Code   string
+	Detail string
+	Data   []byte
+}
+
+// Repository runs transactions. Driver errors are returned as they are, so
+// the caller can tell the transient ones apart.
+type Repository interface {
+	// InTx runs fn in a transaction, committed when fn returns nil and
+	// rolled back otherwise.
+	InTx(ctx context.Context, fn func(Tx) error) error
+	// CountMessages returns how many messages q matches and the highest of
+	// their ids, outside any transaction; see bulk.Count.
+	CountMessages(ctx context.Context, q bulk.Query) (n, last int64, err error)
+}
+
+// Tx is the work of one transaction. Every method is scoped to a tenant.
+type Tx interface {
+	// CheckIdempotency returns the record of key and whether there is one.
+	CheckIdempotency(ctx context.Context, tenantID, key string) (Idempotency, bool, error)
+	// MarkIdempotent records key as processed; the first record of a key
+	// wins.
+	MarkIdempotent(ctx context.Context, tenantID, key string, rec Idempotency) error
+
+	InsertMessage(ctx context.Context, tenantID, message string) (int64, error)
+	// GetMessage returns ErrNotFound when there is no such message.
+	GetMessage(ctx context.Context, tenantID string, id int64) (Message, error)
+	// LockMessage is GetMessage, locking the row until the transaction
+	// ends.
+	LockMessage(ctx context.Context, tenantID string, id int64) (Message, error)
+	// UpdateMessage returns ErrNotFound when no row changed, which is also
+	// the case when the message already has that text.
+	UpdateMessage(ctx context.Context, tenantID string, id int64, message string) error
+	// DeleteMessage deletes the message and its attachment reference. The
+	// blob itself is left for the storage lifecycle policy.
+	DeleteMessage(ctx context.Context, tenantID string, id int64) error
+
+	// ChunkMessages locks and returns the next chunk of a bulk query; see
+	// bulk.Chunk.
+	ChunkMessages(ctx context.Context, q bulk.Query, after, last int64, limit int) ([]bulk.Row, error)
+	UpdateMessages(ctx context.Context, tenantID string, ids []int64, message string) error
+	// DeleteMessages is DeleteMessage for many ids; missing ones are
+	// skipped.
+	DeleteMessages(ctx context.Context, tenantID string, ids []int64) error
+
+	// SaveAttachment sets the blob reference of a message, replacing the
+	// one it had.
+	SaveAttachment(ctx context.Context, tenantID string, messageID int64, ref blob.Ref) error
+	// GetAttachment returns nil for a message without an attachment.
+	GetAttachment(ctx context.Context, tenantID string, messageID int64) (*blob.Ref, error)
+	// DeleteAttachment removes the blob reference of a message, if it has
+	// one. The blob is left for the storage lifecycle policy.
+	DeleteAttachment(ctx context.Context, tenantID string, messageID int64) error
+
+	// ChargeQuota counts n more messages against the tenant's quota and
+	// returns ErrQuotaExceeded, charging nothing, when that would go over
+	// it. A tenant without a quota is not charged.
+	ChargeQuota(ctx context.Context, tenantID string, n int64) error
+
+	RecordAudit(ctx context.Context, e audit.Entry) error
+	ListAudit(ctx context.Context, f audit.Filter) (audit.Page, error)
+	LogSaga(ctx context.Context, tenantID, traceID, step, status, code, detail string) error
+	// RecordSagaStep is LogSaga with the step's data.
+	RecordSagaStep(ctx context.Context, tenantID, traceID string, s SagaStep) error
+	// SagaSteps returns the saga_log rows of a trace, oldest first.
+	SagaSteps(ctx context.Context, tenantID, traceID string) ([]SagaStep, error)
+}
@@ -0,0 +1,82 @@
+// Package startup connects a service to its dependencies when it starts.
+// Under docker-compose or Kubernetes, Kafka and MySQL are often still
+// coming up when apisvc and consumersvc start, so a failed first attempt is
+// retried with jittered exponential backoff until the dependency answers or
+// Policy.MaxWait runs out:
+//
+//	db, err := startup.Connect(ctx, policy, "mysql", func(ctx context.Context) (*sql.DB, error) {
+//		...
+//	})
+//
+// Every failed attempt is logged with the time waited so far, so a service
+// stuck on a dependency says which one.
+package startup
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"time"
+)
+
+// Policy bounds the retries of one dependency.
+type Policy struct {
+	// MaxWait is how long to keep retrying; 0 tries once.
+	MaxWait time.Duration
+	// Backoff is the delay before the first retry. It doubles after each
+	// attempt, up to MaxBackoff, and each delay is jittered by up to half
+	// so replicas started together do not retry in step.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+}
+
+// Retry calls connect until it returns nil; see Connect.
+func Retry(ctx context.Context, p Policy, name string, connect func(ctx context.Context) error) error {
+	_, err := Connect(ctx, p, name, func(ctx context.Context) (struct{}, error) {
+		return struct{}{}, connect(ctx)
+	})
+	return err
+}
+
+// Connect calls connect until it succeeds and returns its result. It gives
+// up with the last error once p.MaxWait has passed or ctx is done.
+func Connect[T any](ctx context.Context, p Policy, name string, connect func(ctx context.Context) (T, error)) (T, error) {
+	start := time.Now()
+	deadline := start.Add(p.MaxWait)
+	delay := p.Backoff
+	for attempt := 1; ; attempt++ {
+		v, err := connect(ctx)
+		if err == nil {
+			if attempt > 1 {
+				slog.Info("startup: ready", "dependency", name, "attempts", attempt, "waited", time.Since(start).Round(time.Millisecond))
+			}
+			return v, nil
+		}
+		wait := jitter(delay)
+		if left := time.Until(deadline); wait > left {
+			wait = left
+		}
+		if wait <= 0 {
+			return v, fmt.Errorf("startup: %s not ready after %d attempts (%s): %w", name, attempt, time.Since(start).Round(time.Millisecond), err)
+		}
+		slog.Warn("startup: waiting", "dependency", name, "attempt", attempt,
+			"waited", time.Since(start).Round(time.Millisecond), "retry_in", wait.Round(time.Millisecond), "err", err)
+		t := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return v, fmt.Errorf("startup: %s: %w (last error: %v)", name, ctx.Err(), err)
+		case <-t.C:
+		}
+		delay = min(2*delay, p.MaxBackoff)
+	}
+}
+
+// jitter returns a random delay in [d/2, d].
+func jitter(d time.Duration) time.Duration {
+	if d <= 1 {
+		return d
+	}
+	return d/2 + rand.N(d/2+1)
+}
@@ -0,0 +1,106 @@
+package tenant
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// Header carries the tenant on incoming HTTP requests. In a real deployment
+// the gateway copies it from the verified token claim; pkg/auth does the same
+// on the routes it protects.
+const Header = "X-Tenant-ID"
+
+// MetadataKey is the Command.Metadata / Kafka header key for the tenant.
+const MetadataKey = "tenant_id"
+
+// Default is used when a request carries no tenant, so single-tenant
+// clients keep working.
+const Default = "default"
+
+type ctxKey string
+
+const tenantKey ctxKey = "tenant_id"
+
+var validID = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,62}$`)
+
+var ErrInvalid = errors.New("invalid tenant id")
+
+// Validate checks that id is safe to use in SQL values and topic names.
+func Validate(id string) error {
+	if !validID.MatchString(id) {
+		return ErrInvalid
+	}
+	return nil
+}
+
+// FromRequest returns the tenant named by the request header, or Default.
+func FromRequest(r *http.Request) (string, error) {
+	id := strings.ToLower(strings.TrimSpace(r.Header.Get(Header)))
+	if id == "" {
+		return Default, nil
+	}
+	return id, Validate(id)
+}
+
+// FromMetadata reads the tenant from a command's metadata, or Default.
+func FromMetadata(md map[string]any) string {
+	if id, _ := md[MetadataKey].(string); id != "" {
+		return id
+	}
+	return Default
+}
+
+func WithTenant(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, tenantKey, id)
+}
+
+func FromContext(ctx context.Context) string {
+	if id, ok := ctx.Value(tenantKey).(string); ok && id != "" {
+		return id
+	}
+	return Default
+}
+
+// Topic returns the per-tenant topic name when prefixing is enabled,
+// e.g. "acme.messages.commands", otherwise base unchanged.
+func Topic(prefix bool, id, base string) string {
+	if !prefix {
+		return base
+	}
+	return id + "." + base
+}
+
+// Topics expands base into one topic per tenant (or just base when
+// prefixing is off).
+func Topics(prefix bool, ids []string, base string) []string {
+	if !prefix {
+		return []string{base}
+	}
+	out := make([]string, 0, len(ids))
+	for _, id := range ids {
+		out = append(out, Topic(true, id, base))
+	}
+	return out
+}
+
+// ParseList splits a comma-separated TENANTS value, always including
+// Default.
+func ParseList(s string) ([]string, error) {
+	seen := map[string]bool{Default: true}
+	out := []string{Default}
+	for _, id := range strings.Split(s, ",") {
+		id = strings.ToLower(strings.TrimSpace(id))
+		if id == "" || seen[id] {
+			continue
+		}
+		if err := Validate(id); err != nil {
+			return nil, errors.New("invalid tenant id " + id)
+		}
+		seen[id] = true
+		out = append(out, id)
+	}
+	return out, nil
+}
@@ -0,0 +1,237 @@
+// Command archiver copies completed sagas into Parquet files for cold
+// storage and analytics. It reads the last step's output topic, batches the
+// events (pkg/archive) and writes one file per completion date and step to
+// an object store. Offsets are committed only after every file of a batch
+// has been written, so a crash or a failing store replays the batch instead
+// of losing it.
+//
+// Environment:
+//
+//	KAFKA_BROKERS           brokers (kafka:9092)
+//	TOPIC_IN                topic to archive (saga.step5.completed)
+//	GROUP_ID                consumer group (archiver)
+//	ARCHIVE_BATCH_SIZE      flush after this many events (1000)
+//	ARCHIVE_FLUSH_INTERVAL  flush a non-empty batch after this long (1m)
+//	ARCHIVE_PREFIX          key prefix inside the store (sagas)
+//	ARCHIVE_STORE, ...      where files go, see archive.StoreFromEnv
+//
+// With PRIORITY_LANES=true it reads every lane of TOPIC_IN in one group.
+// With REGIONS set it archives the active region's copy; restart it after a
+// failover.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/segmentio/kafka-go"
+
+	"example.com/saga-choreo-lab/pkg/archive"
+	"example.com/saga-choreo-lab/pkg/common"
+)
+
+var (
+	archived = prometheus.NewCounterVec(
+		prometheus.CounterOpts{Name: "saga_archive_events_total", Help: "events by result (archived, skipped)"},
+		[]string{"result"},
+	)
+	flushes = prometheus.NewCounterVec(
+		prometheus.CounterOpts{Name: "saga_archive_flushes_total", Help: "batch flushes by result"},
+		[]string{"result"},
+	)
+	flushSeconds = prometheus.NewHistogram(
+		prometheus.HistogramOpts{Name: "saga_archive_flush_seconds", Help: "time to write one batch to the store",
+			Buckets: prometheus.ExponentialBuckets(0.01, 4, 7)},
+	)
+	pending = prometheus.NewGauge(
+		prometheus.GaugeOpts{Name: "saga_archive_pending_events", Help: "events in the batch not yet flushed"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(archived, flushes, flushSeconds, pending)
+}
+
+type archiver struct {
+	reader *kafka.Reader
+	store  archive.Store
+	prefix string
+	size   int
+	every  time.Duration
+
+	batch *archive.Batch
+	// last is the newest message per partition, committed after a flush;
+	// it includes skipped events, which must not be read again either.
+	last map[partition]kafka.Message
+}
+
+type partition struct {
+	topic string
+	id    int
+}
+
+func main() {
+	brokers := getenv("KAFKA_BROKERS", "kafka:9092")
+	topic := getenv("TOPIC_IN", "saga.step5.completed")
+	group := getenv("GROUP_ID", "archiver")
+	size, err := strconv.Atoi(getenv("ARCHIVE_BATCH_SIZE", "1000"))
+	if err != nil || size <= 0 {
+		log.Fatalf("ARCHIVE_BATCH_SIZE: %q is not a positive number", os.Getenv("ARCHIVE_BATCH_SIZE"))
+	}
+	every, err := time.ParseDuration(getenv("ARCHIVE_FLUSH_INTERVAL", "1m"))
+	if err != nil || every <= 0 {
+		log.Fatalf("ARCHIVE_FLUSH_INTERVAL: %q is not a positive duration", os.Getenv("ARCHIVE_FLUSH_INTERVAL"))
+	}
+	store, where, err := archive.StoreFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := common.RegionsFromEnv(); err != nil {
+		log.Fatal(err)
+	}
+
+	common.ServeMetrics()
+	in := []string{common.RegionTopic(topic)}
+	if common.LanesEnabled() {
+		in = in[:0]
+		for _, p := range common.Priorities {
+			in = append(in, common.LaneTopic(common.RegionTopic(topic), p))
+		}
+	}
+	a := &archiver{
+		reader: kafka.NewReader(kafka.ReaderConfig{
+			Brokers:     strings.Split(brokers, ","),
+			GroupID:     group,
+			GroupTopics: in,
+			MinBytes:    1,
+			MaxBytes:    10e6,
+		}),
+		store:  store,
+		prefix: getenv("ARCHIVE_PREFIX", "sagas"),
+		size:   size,
+		every:  every,
+		batch:  archive.NewBatch(),
+		last:   map[partition]kafka.Message{},
+	}
+	defer a.reader.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	log.Printf("[archiver] %v -> %s/%s, batches of %d or every %s", in, where, a.prefix, size, every)
+	a.run(ctx)
+
+	// a last flush on shutdown, so the next start does not re-read the batch
+	final, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := a.flush(final); err != nil {
+		log.Printf("[archiver] final flush: %v", err)
+	}
+}
+
+// run fetches until ctx is done, flushing when the batch is full or old.
+func (a *archiver) run(ctx context.Context) {
+	for ctx.Err() == nil {
+		wait := a.every
+		if a.batch.Len() > 0 {
+			wait = a.every - a.batch.Age(time.Now())
+		}
+		fctx, cancel := context.WithTimeout(ctx, max(wait, time.Millisecond))
+		m, err := a.reader.FetchMessage(fctx)
+		cancel()
+		switch {
+		case err == nil:
+			a.add(ctx, m)
+		case errors.Is(err, context.DeadlineExceeded):
+		case ctx.Err() != nil:
+			return
+		default:
+			log.Printf("[archiver] fetch: %v", err)
+			time.Sleep(time.Second)
+		}
+
+		if a.batch.Len() >= a.size || (a.batch.Len() > 0 && a.batch.Age(time.Now()) >= a.every) {
+			for backoff := time.Second; ctx.Err() == nil; backoff = min(2*backoff, time.Minute) {
+				err := a.flush(ctx)
+				if err == nil {
+					break
+				}
+				// keep the batch and the offsets; nothing is committed
+				log.Printf("[archiver] flush failed, retrying in %s: %v", backoff, err)
+				select {
+				case <-ctx.Done():
+				case <-time.After(backoff):
+				}
+			}
+		}
+	}
+}
+
+func (a *archiver) add(ctx context.Context, m kafka.Message) {
+	a.last[partition{m.Topic, m.Partition}] = m
+	evt, err := common.DecodeEvent(ctx, m)
+	if err != nil {
+		log.Printf("[archiver] skipping %s/%d/%d: %v", m.Topic, m.Partition, m.Offset, err)
+		archived.WithLabelValues("skipped").Inc()
+		return
+	}
+	payload, _ := json.Marshal(evt.Payload)
+	a.batch.Add(archive.Record{
+		SagaID:        evt.SagaID,
+		Step:          int32(evt.Step),
+		SchemaVersion: int32(evt.SchemaVersion),
+		Priority:      common.NormalizePriority(evt.Priority),
+		EventTs:       evt.Ts.UnixMilli(),
+		CompletedAt:   m.Time.UnixMilli(),
+		Payload:       string(payload),
+		Topic:         m.Topic,
+		Partition:     int32(m.Partition),
+		Offset:        m.Offset,
+	}, time.Now())
+	pending.Set(float64(a.batch.Len()))
+}
+
+// flush writes the batch and then commits everything read so far.
+func (a *archiver) flush(ctx context.Context) error {
+	if len(a.last) == 0 {
+		return nil
+	}
+	n := a.batch.Len()
+	t0 := time.Now()
+	files, err := a.batch.Flush(ctx, a.store, a.prefix)
+	if err != nil {
+		flushes.WithLabelValues("error").Inc()
+		return err
+	}
+	flushSeconds.Observe(time.Since(t0).Seconds())
+	archived.WithLabelValues("archived").Add(float64(n))
+	pending.Set(0)
+	msgs := make([]kafka.Message, 0, len(a.last))
+	for _, m := range a.last {
+		msgs = append(msgs, m)
+	}
+	if err := a.reader.CommitMessages(ctx, msgs...); err != nil {
+		// the files are written; a restart rewrites the same keys
+		flushes.WithLabelValues("commit_error").Inc()
+		return err
+	}
+	a.last = map[partition]kafka.Message{}
+	flushes.WithLabelValues("ok").Inc()
+	log.Printf("[archiver] flushed %d events in %d files (%s)", n, len(files), time.Since(t0).Round(time.Millisecond))
+	return nil
+}
+
+func getenv(k, d string) string {
+	if v := os.Getenv(k); v != "" {
+		return v
+	}
+	return d
+}
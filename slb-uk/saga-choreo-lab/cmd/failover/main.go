@@ -0,0 +1,135 @@
+// Command failover moves the saga pipeline to another region for a DR
+// drill: it tells every service to switch to the standby region's topics,
+// waits for them to move, and then replays the sagas that were still in
+// flight from the saga store into the new region.
+//
+//	REGIONS=eu,us SAGA_STATE_TOPIC=saga.state go run ./cmd/failover -to us
+//
+// A saga is in flight when its last record in the store went to a topic
+// that a service of the pipeline manifest consumes; sagas that completed or
+// sit in the DLQ are left alone. Replayed records keep their key, so each
+// saga stays ordered, and carry x-failover-from with the old region.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+
+	"example.com/saga-choreo-lab/pkg/common"
+)
+
+func main() {
+	brokers := flag.String("brokers", getenv("KAFKA_BROKERS", "localhost:9092"), "Kafka brokers")
+	to := flag.String("to", "", "region to fail over to (required)")
+	manifest := flag.String("manifest", getenv("PIPELINE_MANIFEST", "pipeline.json"), "pipeline manifest, to tell in-flight sagas from finished ones")
+	settle := flag.Duration("settle", 10*time.Second, "how long to let services switch before replaying")
+	dryRun := flag.Bool("dry-run", false, "list the sagas that would be replayed and change nothing")
+	flag.Parse()
+
+	if err := common.RegionsFromEnv(); err != nil {
+		log.Fatal(err)
+	}
+	stateTopic := os.Getenv("SAGA_STATE_TOPIC")
+	if len(common.Regions()) == 0 || stateTopic == "" {
+		log.Fatal("REGIONS and SAGA_STATE_TOPIC must be set as for the services")
+	}
+	// The services' view of the active region, not REGION_ACTIVE, is what
+	// counts; FollowFailover replays the failover topic to get it.
+	common.FollowFailover(*brokers, "failover")
+	from := common.ActiveRegion()
+	if *to == "" {
+		log.Fatalf("-to is required (regions %v, active %s)", common.Regions(), from)
+	}
+	if *to == from {
+		log.Fatalf("%s is already the active region", *to)
+	}
+	m, err := common.LoadManifest(*manifest)
+	if err != nil {
+		log.Fatal(err)
+	}
+	consumed := map[string]bool{}
+	for _, s := range m.Services {
+		if s.Kind != "replayer" && s.In != "" {
+			consumed[s.In] = true
+		}
+	}
+
+	ctx := context.Background()
+	if !*dryRun {
+		w := common.NewFailoverWriter(*brokers)
+		cmd := common.FailoverCommand{Region: *to, From: from, At: time.Now().UTC()}
+		if err := w.WriteMessages(ctx, kafka.Message{Value: common.MustJSON(cmd)}); err != nil {
+			log.Fatalf("publish failover command: %v", err)
+		}
+		w.Close()
+		log.Printf("failover %s -> %s published to %s; waiting %s for services to switch", from, *to, common.FailoverTopic(), *settle)
+		time.Sleep(*settle)
+	}
+
+	states, err := common.ReadSagaStates(ctx, *brokers, stateTopic)
+	if err != nil {
+		log.Fatalf("saga store: %v", err)
+	}
+	var inFlight []common.SagaState
+	for _, st := range states {
+		// lane suffixes are not in the manifest
+		if st.Region != *to && (consumed[st.Topic] || consumed[baseLane(st.Topic)]) {
+			inFlight = append(inFlight, st)
+		}
+	}
+	sort.Slice(inFlight, func(i, j int) bool { return inFlight[i].Ts.Before(inFlight[j].Ts) })
+	log.Printf("saga store: %d sagas, %d in flight outside %s", len(states), len(inFlight), *to)
+
+	if *dryRun {
+		for _, st := range inFlight {
+			fmt.Printf("%s\t%s\t%s\t%s\n", st.SagaID, st.Region, st.Topic, st.Ts.Format(time.RFC3339))
+		}
+		return
+	}
+
+	w := common.NewWriter(*brokers)
+	defer w.Close()
+	replayed := 0
+	for _, st := range inFlight {
+		msg := kafka.Message{
+			Topic:   common.RegionTopicIn(*to, st.Topic),
+			Key:     st.Key,
+			Value:   st.Value,
+			Headers: append(st.Headers, kafka.Header{Key: "x-failover-from", Value: []byte(st.Region)}),
+		}
+		if err := w.WriteMessages(ctx, common.WithState(st.SagaID, msg)...); err != nil {
+			log.Printf("replay saga %s: %v", st.SagaID, err)
+			continue
+		}
+		replayed++
+	}
+	log.Printf("replayed %d/%d in-flight sagas into %s", replayed, len(inFlight), *to)
+	if replayed < len(inFlight) {
+		os.Exit(1)
+	}
+}
+
+// baseLane strips a priority lane suffix (.high, .low).
+func baseLane(topic string) string {
+	for _, p := range []string{common.PriorityHigh, common.PriorityLow} {
+		if t, ok := strings.CutSuffix(topic, "."+p); ok {
+			return t
+		}
+	}
+	return topic
+}
+
+func getenv(k, d string) string {
+	if v := os.Getenv(k); v != "" {
+		return v
+	}
+	return d
+}
@@ -0,0 +1,261 @@
+// Command lab runs the whole choreography on a laptop, without Kafka or
+// Docker: the emitter, the five steps and the DLQ replayer of pipeline.json
+// run as goroutines in one process and talk through pkg/localbus, a broker
+// kept in SQLite. A summary of saga progress is redrawn in the terminal, or
+// logged when the output is not one.
+//
+//	go run ./cmd/lab
+//	go run ./cmd/lab -fail-mode fatal -sagas 50
+//	go run ./cmd/lab -db lab.db   # keep topics and offsets across runs
+//
+// The steps run the same logic as cmd/step*: common.Handle under FAIL_MODE
+// and RETRY_MAX, dead-lettering with x-original-topic, and the replayer
+// sends DLQ records back where they failed. FAIL_MODE can be switched while
+// it runs with PUT :8080/fail-mode. Approval gates, priority lanes, regions,
+// compensation and step output contracts are not simulated.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+
+	"example.com/saga-choreo-lab/pkg/common"
+	"example.com/saga-choreo-lab/pkg/localbus"
+)
+
+func main() {
+	pipeline := flag.String("pipeline", envOr("PIPELINE", "pipeline.json"), "pipeline manifest to run")
+	db := flag.String("db", os.Getenv("LAB_DB"), "SQLite file for the broker; empty keeps it in memory")
+	every := flag.Duration("every", 200*time.Millisecond, "time between two sagas")
+	sagas := flag.Int("sagas", 0, "stop after this many sagas have settled; 0 runs until interrupted")
+	drain := flag.Duration("drain", 30*time.Second, "with -sagas, how long to wait for the last ones to settle")
+	failMode := flag.String("fail-mode", envOr("FAIL_MODE", "flaky:0.4"), "step 5 FAIL_MODE: none, retryable, fatal or flaky:<p>")
+	interval := flag.Duration("report", time.Second, "time between two summaries")
+	flag.Parse()
+
+	m, err := common.LoadManifest(*pipeline)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := common.SetFailMode(*failMode); err != nil {
+		log.Fatal(err)
+	}
+	retry, err := common.RetryFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	// without retries in place nothing is ever dead-lettered under flaky
+	if os.Getenv("RETRY_MAX") == "" {
+		retry.Max = 2
+	}
+	bus, err := localbus.Open(*db)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer bus.Close()
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	t := newTracker(m, bus, *db, retry)
+	tty := isTerminal(os.Stdout)
+	if tty {
+		// service logs go to the summary's event list instead of
+		// scrolling it away
+		log.SetOutput(t)
+		log.SetFlags(0)
+	}
+	common.ServeMetrics()
+
+	var wg sync.WaitGroup
+	run := func(f func()) {
+		wg.Add(1)
+		go func() { defer wg.Done(); f() }()
+	}
+	for _, s := range m.Services {
+		s := s
+		switch s.Kind {
+		case "emitter":
+			run(func() { emit(ctx, bus, s, *every, *sagas, t) })
+		case "step":
+			run(func() { step(ctx, bus, s, retry, t) })
+		case "replayer":
+			run(func() { replay(ctx, bus, s, t) })
+		}
+	}
+	if done := t.doneTopic(); done != "" {
+		run(func() { watchDone(ctx, bus, done, t) })
+	} else {
+		log.Printf("[lab] %s has no step; nothing will complete", *pipeline)
+	}
+
+	tick := time.NewTicker(*interval)
+	defer tick.Stop()
+	var deadline <-chan time.Time
+	for settled := false; !settled; {
+		select {
+		case <-ctx.Done():
+			settled = true
+		case <-tick.C:
+			t.render(os.Stdout, tty)
+			if *sagas > 0 && t.emittedAll(*sagas) {
+				if deadline == nil {
+					deadline = time.After(*drain)
+				}
+				settled = t.inFlight() == 0
+			}
+		case <-deadline:
+			log.Printf("[lab] %d saga(s) still in flight after %s", t.inFlight(), *drain)
+			settled = true
+		}
+	}
+	cancel()
+	wg.Wait()
+	t.render(os.Stdout, false)
+}
+
+// emit starts a saga every interval, like common.RunEmitter; after max sagas
+// (when set) it stops.
+func emit(ctx context.Context, bus *localbus.Bus, s common.ServiceManifest, every time.Duration, max int, t *tracker) {
+	tick := time.NewTicker(every)
+	defer tick.Stop()
+	for n := 0; max == 0 || n < max; {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick.C:
+		}
+		sagaID := fmt.Sprintf("%d-%d", time.Now().UnixNano(), rand.Intn(100000))
+		evt := common.Event{SagaID: sagaID, Step: 1, SchemaVersion: 1, Ts: time.Now(), Payload: map[string]any{"demo": "start"}}
+		value, headers, err := common.EncodeEvent(ctx, &evt, []kafka.Header{{Key: "x-saga-id", Value: []byte(sagaID)}})
+		if err != nil {
+			log.Printf("[emitter] claim check: %v", err)
+			continue
+		}
+		msg := kafka.Message{Topic: s.Out, Key: []byte(sagaID), Value: value, Headers: headers}
+		if err := bus.WriteMessages(ctx, common.WithState(sagaID, msg)...); err != nil {
+			if ctx.Err() == nil {
+				log.Printf("[emitter] produce err: %v", err)
+			}
+			continue
+		}
+		n++
+		t.emitted(s.Name, sagaID)
+	}
+}
+
+// step is the loop of common.RunStepService on the local bus.
+func step(ctx context.Context, bus *localbus.Bus, s common.ServiceManifest, retry common.RetryPolicy, t *tracker) {
+	r := bus.Reader(s.In, s.Group)
+	for {
+		m, err := r.ReadMessage(ctx)
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, localbus.ErrClosed) {
+				return
+			}
+			log.Printf("[%s] read error: %v", s.Name, err)
+			continue
+		}
+		evt, err := common.DecodeEvent(ctx, m)
+		if err != nil {
+			log.Printf("[%s] bad event: %v", s.Name, err)
+			continue
+		}
+		next, fatal := common.Handle(s.Step, retry, &evt)
+		value, headers, err := common.EncodeEvent(ctx, next, m.Headers)
+		if err != nil {
+			log.Printf("[%s] claim check: %v", s.Name, err)
+			continue
+		}
+		msg := kafka.Message{Topic: s.Out, Key: m.Key, Value: value,
+			Headers: append(headers, kafka.Header{Key: "x-saga-id", Value: []byte(evt.SagaID)})}
+		if fatal {
+			msg.Topic = s.DLQ
+			msg.Headers = append(msg.Headers, kafka.Header{Key: "x-original-topic", Value: []byte(m.Topic)})
+			common.DLQTotal.WithLabelValues(msg.Topic).Inc()
+		}
+		if err := bus.WriteMessages(ctx, common.WithState(evt.SagaID, msg)...); err != nil {
+			if ctx.Err() == nil {
+				log.Printf("[%s] produce err: %v", s.Name, err)
+			}
+			continue
+		}
+		t.handled(s, evt.SagaID, fatal)
+	}
+}
+
+// replay is common.RunDLQReplayer on the local bus: a record goes back to
+// its x-original-topic, or to the manifest's out.
+func replay(ctx context.Context, bus *localbus.Bus, s common.ServiceManifest, t *tracker) {
+	r := bus.Reader(s.In, s.Group)
+	for {
+		m, err := r.ReadMessage(ctx)
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, localbus.ErrClosed) {
+				return
+			}
+			log.Printf("[dlq] read err: %v", err)
+			continue
+		}
+		evt, err := common.DecodeEvent(ctx, m)
+		if err != nil {
+			log.Printf("[dlq] bad json: %v", err)
+			continue
+		}
+		orig := s.Out
+		for _, h := range m.Headers {
+			if h.Key == "x-original-topic" {
+				orig = string(h.Value)
+			}
+		}
+		msg := kafka.Message{Topic: orig, Key: m.Key, Value: m.Value, Headers: m.Headers}
+		if err := bus.WriteMessages(ctx, common.WithState(evt.SagaID, msg)...); err != nil {
+			if ctx.Err() == nil {
+				log.Printf("[dlq] produce err: %v", err)
+			}
+			continue
+		}
+		t.replayed(s, evt.SagaID, orig)
+	}
+}
+
+// watchDone counts the sagas that reach the last step's output.
+func watchDone(ctx context.Context, bus *localbus.Bus, topic string, t *tracker) {
+	r := bus.Reader(topic, "lab")
+	for {
+		m, err := r.ReadMessage(ctx)
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, localbus.ErrClosed) {
+				return
+			}
+			log.Printf("[lab] read %s: %v", topic, err)
+			continue
+		}
+		if evt, err := common.DecodeEvent(ctx, m); err == nil {
+			t.completed(evt)
+		}
+	}
+}
+
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+func envOr(k, def string) string {
+	if v := os.Getenv(k); v != "" {
+		return v
+	}
+	return def
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"example.com/saga-choreo-lab/pkg/common"
+	"example.com/saga-choreo-lab/pkg/localbus"
+)
+
+// recentEvents is how many dead-letters, replays and log lines the summary
+// keeps.
+const recentEvents = 8
+
+// tracker follows the sagas this run started through the pipeline.
+type tracker struct {
+	m     *common.Manifest
+	bus   *localbus.Bus
+	db    string
+	retry common.RetryPolicy
+	start time.Time
+
+	mu      sync.Mutex
+	at      map[string]string // saga -> service that last handed it on; "" once completed
+	emits   int
+	dones   int
+	dlqs    int
+	replays int
+	total   time.Duration // end-to-end time of the completed sagas
+	slowest time.Duration
+	perSvc  map[string]*svcStats
+	recent  []string
+}
+
+type svcStats struct{ handled, dlq int }
+
+func newTracker(m *common.Manifest, bus *localbus.Bus, db string, retry common.RetryPolicy) *tracker {
+	t := &tracker{m: m, bus: bus, db: db, retry: retry, start: time.Now(),
+		at: map[string]string{}, perSvc: map[string]*svcStats{}}
+	for _, s := range m.Services {
+		t.perSvc[s.Name] = &svcStats{}
+	}
+	return t
+}
+
+// doneTopic is the output of the last step: a saga there has completed.
+func (t *tracker) doneTopic() string {
+	last := -1
+	topic := ""
+	for _, s := range t.m.Services {
+		if s.Kind == "step" && s.Step > last {
+			last, topic = s.Step, s.Out
+		}
+	}
+	return topic
+}
+
+func (t *tracker) emitted(emitter, id string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.at[id] = emitter
+	t.emits++
+	t.perSvc[emitter].handled++
+}
+
+func (t *tracker) handled(s common.ServiceManifest, id string, dlq bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	st := t.perSvc[s.Name]
+	st.handled++
+	if _, ours := t.at[id]; !ours {
+		return
+	}
+	t.at[id] = s.Name
+	if dlq {
+		st.dlq++
+		t.dlqs++
+		t.event("%s dead-lettered saga %s", s.Name, id)
+	}
+}
+
+func (t *tracker) replayed(s common.ServiceManifest, id, to string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.perSvc[s.Name].handled++
+	if _, ours := t.at[id]; !ours {
+		return
+	}
+	t.replays++
+	t.event("replayed saga %s to %s", id, to)
+}
+
+func (t *tracker) completed(evt common.Event) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if at, ours := t.at[evt.SagaID]; !ours || at == "" {
+		return // another run's, or seen again after a replay
+	}
+	t.at[evt.SagaID] = ""
+	t.dones++
+	d := time.Since(evt.Ts)
+	t.total += d
+	if d > t.slowest {
+		t.slowest = d
+	}
+}
+
+func (t *tracker) emittedAll(n int) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.emits >= n
+}
+
+func (t *tracker) inFlight() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.emits - t.dones
+}
+
+// event adds a line to the recent events; t.mu is held.
+func (t *tracker) event(format string, args ...any) {
+	line := time.Now().Format("15:04:05 ") + fmt.Sprintf(format, args...)
+	t.recent = append(t.recent, line)
+	if len(t.recent) > recentEvents {
+		t.recent = t.recent[len(t.recent)-recentEvents:]
+	}
+}
+
+// Write takes the standard logger's output while the summary owns the
+// terminal.
+func (t *tracker) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, line := range strings.Split(strings.TrimRight(string(p), "\n"), "\n") {
+		t.event("%s", line)
+	}
+	return len(p), nil
+}
+
+// render prints the summary. On a terminal it redraws the screen in place;
+// otherwise it is appended to the output like a log entry.
+func (t *tracker) render(out io.Writer, redraw bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	var b bytes.Buffer
+	if redraw {
+		b.WriteString("\033[H\033[2J")
+	}
+
+	t.mu.Lock()
+	store := "memory"
+	if t.db != "" {
+		store = t.db
+	}
+	fmt.Fprintf(&b, "%s  up %s  FAIL_MODE=%s  RETRY_MAX=%d  bus=%s\n\n", t.m.Name,
+		time.Since(t.start).Round(time.Second), common.CurrentFailMode(), t.retry.Max, store)
+	mean := time.Duration(0)
+	if t.dones > 0 {
+		mean = t.total / time.Duration(t.dones)
+	}
+	fmt.Fprintf(&b, "sagas: %d started, %d completed, %d in flight, %d dead-lettered, %d replayed; end-to-end mean %s, slowest %s\n\n",
+		t.emits, t.dones, t.emits-t.dones, t.dlqs, t.replays, mean.Round(time.Millisecond), t.slowest.Round(time.Millisecond))
+
+	// where the sagas in flight wait: on the output of the last service
+	// that handed them on
+	waiting := map[string]int{}
+	for _, at := range t.at {
+		if at != "" {
+			waiting[at]++
+		}
+	}
+	tw := tabwriter.NewWriter(&b, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "SERVICE\tIN\tOUT\tHANDLED\tDLQ\tLAG\tWAITING AFTER")
+	for _, s := range t.m.Services {
+		st := t.perSvc[s.Name]
+		lag := "-"
+		if s.In != "" {
+			if n, err := t.bus.Lag(ctx, s.In, s.Group); err == nil {
+				lag = fmt.Sprint(n)
+			}
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%d\t%s\t%d\n", s.Name, dash(s.In), dash(s.Out), st.handled, st.dlq, lag, waiting[s.Name])
+	}
+	tw.Flush()
+
+	if len(t.recent) > 0 {
+		b.WriteString("\nrecent:\n")
+		for _, line := range t.recent {
+			b.WriteString("  " + line + "\n")
+		}
+	}
+	t.mu.Unlock()
+
+	if redraw {
+		b.WriteString("\nCtrl-C to stop; PUT :8080/fail-mode to change FAIL_MODE\n")
+	} else {
+		b.WriteString("\n")
+	}
+	out.Write(b.Bytes())
+}
+
+func dash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
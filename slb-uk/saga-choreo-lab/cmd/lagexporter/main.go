@@ -0,0 +1,191 @@
+// Command lagexporter exports the backlog of every consumer group in the
+// pipeline manifest, so the dashboards show where sagas pile up while a
+// step is failing:
+//
+//	saga_topic_lag{topic,group}            sum over partitions
+//	saga_partition_lag{topic,group,partition}
+//
+// Lag is the partition's high water mark minus the group's committed offset.
+// A group that has not committed yet starts at the oldest offset (the
+// kafka-go reader default), so its lag is the whole partition.
+//
+// Environment: KAFKA_BROKERS, PIPELINE_MANIFEST (default pipeline.json),
+// LAG_INTERVAL (default 15s) and PRIORITY_LANES, which adds the .high/.low
+// lanes and their per-lane groups.
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/segmentio/kafka-go"
+
+	"example.com/saga-choreo-lab/pkg/common"
+)
+
+var (
+	topicLag = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{Name: "saga_topic_lag", Help: "messages in topic not yet committed by group"},
+		[]string{"topic", "group"},
+	)
+	partitionLag = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{Name: "saga_partition_lag", Help: "messages in one partition not yet committed by group"},
+		[]string{"topic", "group", "partition"},
+	)
+	lagErrors = prometheus.NewCounterVec(
+		prometheus.CounterOpts{Name: "saga_lag_errors_total", Help: "failed lag queries by topic/group"},
+		[]string{"topic", "group"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(topicLag, partitionLag, lagErrors)
+}
+
+// watch is one consumer group reading one topic.
+type watch struct{ Topic, Group string }
+
+// watches lists what the manifest's services consume. With lanes a step
+// reads each lane with its own group, see common.NewLaneReader. With
+// regions every region's copy is watched, so a failover drill shows the
+// lag moving from one to the other.
+func watches(m *common.Manifest, lanes bool, regions []string) []watch {
+	if len(regions) > 0 {
+		var out []watch
+		for _, w := range watches(m, lanes, nil) {
+			for _, r := range regions {
+				out = append(out, watch{common.RegionTopicIn(r, w.Topic), w.Group})
+			}
+		}
+		return out
+	}
+	var out []watch
+	for _, s := range m.Services {
+		if s.In == "" || s.Group == "" {
+			continue
+		}
+		if s.Kind == "step" && lanes {
+			for _, p := range common.Priorities {
+				out = append(out, watch{common.LaneTopic(s.In, p), s.Group + "." + p})
+			}
+			continue
+		}
+		out = append(out, watch{s.In, s.Group})
+	}
+	return out
+}
+
+func main() {
+	brokers := getenv("KAFKA_BROKERS", "kafka:9092")
+	interval, err := time.ParseDuration(getenv("LAG_INTERVAL", "15s"))
+	if err != nil || interval <= 0 {
+		log.Fatalf("LAG_INTERVAL: %q is not a positive duration", os.Getenv("LAG_INTERVAL"))
+	}
+	m, err := common.LoadManifest(getenv("PIPELINE_MANIFEST", "pipeline.json"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := common.RegionsFromEnv(); err != nil {
+		log.Fatal(err)
+	}
+	ws := watches(m, common.LanesEnabled(), common.Regions())
+	if len(ws) == 0 {
+		log.Fatal("manifest has no consumer groups")
+	}
+
+	common.ServeMetrics()
+	client := &kafka.Client{Addr: kafka.TCP(strings.Split(brokers, ",")...), Timeout: 10 * time.Second}
+	log.Printf("[lagexporter] watching %d groups every %s", len(ws), interval)
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), interval)
+		for _, w := range ws {
+			if err := export(ctx, client, w); err != nil {
+				log.Printf("[lagexporter] %s @ %s: %v", w.Group, w.Topic, err)
+				lagErrors.WithLabelValues(w.Topic, w.Group).Inc()
+			}
+		}
+		cancel()
+		time.Sleep(interval)
+	}
+}
+
+// export queries one group's lag and sets its gauges. Topics that do not
+// exist yet (a lane nobody produced to) count as empty.
+func export(ctx context.Context, c *kafka.Client, w watch) error {
+	meta, err := c.Metadata(ctx, &kafka.MetadataRequest{Topics: []string{w.Topic}})
+	if err != nil {
+		return err
+	}
+	if len(meta.Topics) != 1 {
+		return fmt.Errorf("metadata for %d topics", len(meta.Topics))
+	}
+	t := meta.Topics[0]
+	if t.Error != nil {
+		topicLag.WithLabelValues(w.Topic, w.Group).Set(0)
+		return nil
+	}
+
+	var reqs []kafka.OffsetRequest
+	var ids []int
+	for _, p := range t.Partitions {
+		reqs = append(reqs, kafka.FirstOffsetOf(p.ID), kafka.LastOffsetOf(p.ID))
+		ids = append(ids, p.ID)
+	}
+	ends, err := c.ListOffsets(ctx, &kafka.ListOffsetsRequest{Topics: map[string][]kafka.OffsetRequest{w.Topic: reqs}})
+	if err != nil {
+		return err
+	}
+	committed, err := c.OffsetFetch(ctx, &kafka.OffsetFetchRequest{GroupID: w.Group, Topics: map[string][]int{w.Topic: ids}})
+	if err != nil {
+		return err
+	}
+	if committed.Error != nil {
+		return committed.Error
+	}
+	commits := map[int]int64{}
+	for _, p := range committed.Topics[w.Topic] {
+		if p.Error != nil {
+			return fmt.Errorf("partition %d: %w", p.Partition, p.Error)
+		}
+		commits[p.Partition] = p.CommittedOffset
+	}
+
+	var total int64
+	for _, p := range ends.Topics[w.Topic] {
+		if p.Error != nil {
+			return fmt.Errorf("partition %d: %w", p.Partition, p.Error)
+		}
+		off, ok := commits[p.Partition]
+		if !ok {
+			off = -1
+		}
+		lag := partitionLagOf(p.FirstOffset, p.LastOffset, off)
+		partitionLag.WithLabelValues(w.Topic, w.Group, strconv.Itoa(p.Partition)).Set(float64(lag))
+		total += lag
+	}
+	topicLag.WithLabelValues(w.Topic, w.Group).Set(float64(total))
+	return nil
+}
+
+// partitionLagOf is end minus where the group resumes: its commit, or the
+// oldest offset when it has none or retention deleted past it.
+func partitionLagOf(first, end, committed int64) int64 {
+	next := committed
+	if next < first {
+		next = first
+	}
+	return max(0, end-next)
+}
+
+func getenv(k, d string) string {
+	if v := os.Getenv(k); v != "" {
+		return v
+	}
+	return d
+}
@@ -0,0 +1,64 @@
+// Command sagagen generates the pipeline files from saga.yaml: pipeline.json,
+// the topics job and a deployment per service under k8s/, docker-compose.yaml,
+// saga.mk (the STEPS list the Makefile builds and deploys) and a cmd/<step>
+// stub for every step that has none yet. Stubs are never overwritten.
+//
+//	go run ./cmd/sagagen              # write the files
+//	go run ./cmd/sagagen -check       # exit 1 if any generated file is stale
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"example.com/saga-choreo-lab/pkg/sagadef"
+)
+
+func main() {
+	def := flag.String("f", "saga.yaml", "saga definition")
+	out := flag.String("out", ".", "module root to write into")
+	check := flag.Bool("check", false, "only report files that differ from saga.yaml")
+	dryRun := flag.Bool("dry-run", false, "print what would be written")
+	flag.Parse()
+
+	d, err := sagadef.Load(*def)
+	if err != nil {
+		log.Fatal(err)
+	}
+	files, err := sagadef.Generate(d)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	stale := 0
+	for _, f := range files {
+		path := filepath.Join(*out, f.Path)
+		old, err := os.ReadFile(path)
+		exists := err == nil
+		switch {
+		case f.Stub && exists, bytes.Equal(old, f.Data) && exists:
+			continue
+		case *check:
+			fmt.Printf("stale: %s\n", f.Path)
+			stale++
+		case *dryRun:
+			fmt.Printf("would write %s (%d bytes)\n", f.Path, len(f.Data))
+		default:
+			if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+				log.Fatal(err)
+			}
+			if err := os.WriteFile(path, f.Data, 0o644); err != nil {
+				log.Fatal(err)
+			}
+			fmt.Printf("wrote %s\n", f.Path)
+		}
+	}
+	if stale > 0 {
+		fmt.Printf("%d file(s) out of date; run go run ./cmd/sagagen\n", stale)
+		os.Exit(1)
+	}
+}
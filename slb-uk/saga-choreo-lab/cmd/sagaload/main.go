@@ -0,0 +1,322 @@
+// Command sagaload drives the saga pipeline with a scripted failure scenario
+// and checks the outcome.
+//
+// It emits sagas at a steady rate into the first topic while switching step
+// FAIL_MODE per phase (via the services' /fail-mode endpoint), watches the
+// completed and DLQ topics, and after a drain period prints a per-phase
+// report. The exit code is 1 when any expectation fails.
+//
+//	go run ./cmd/sagaload -scenario soak -fail-url http://localhost:8080/fail-mode
+package main
+
+import (
+	"context"
+	"embed"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+
+	"example.com/saga-choreo-lab/pkg/common"
+)
+
+//go:embed scenarios/*.json
+var builtin embed.FS
+
+type Scenario struct {
+	RatePerSec float64  `json:"rate_per_sec"`
+	Phases     []Phase  `json:"phases"`
+	Drain      Duration `json:"drain"`
+}
+
+type Phase struct {
+	Name     string   `json:"name"`
+	Duration Duration `json:"duration"`
+	FailMode string   `json:"fail_mode"`
+	Expect   Expect   `json:"expect"`
+}
+
+// Expect bounds are ratios of the sagas emitted during the phase. A saga that
+// was dead-lettered and later replayed to completion counts for both.
+type Expect struct {
+	MinCompleted *float64 `json:"min_completed,omitempty"`
+	MinDLQ       *float64 `json:"min_dlq,omitempty"`
+	MaxDLQ       *float64 `json:"max_dlq,omitempty"`
+}
+
+// Duration accepts "5m" style strings in JSON.
+type Duration struct{ time.Duration }
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	d.Duration = v
+	return err
+}
+
+func loadScenario(name string) (*Scenario, error) {
+	b, err := os.ReadFile(name)
+	if os.IsNotExist(err) && !strings.ContainsAny(name, "/.") {
+		b, err = builtin.ReadFile("scenarios/" + name + ".json")
+	}
+	if err != nil {
+		return nil, err
+	}
+	var sc Scenario
+	if err := json.Unmarshal(b, &sc); err != nil {
+		return nil, fmt.Errorf("parse scenario %s: %w", name, err)
+	}
+	if sc.RatePerSec <= 0 || len(sc.Phases) == 0 {
+		return nil, fmt.Errorf("scenario %s: rate_per_sec and phases are required", name)
+	}
+	for i, p := range sc.Phases {
+		if p.Duration.Duration <= 0 {
+			return nil, fmt.Errorf("scenario %s: phase %d has no duration", name, i)
+		}
+		if p.Name == "" {
+			sc.Phases[i].Name = fmt.Sprintf("phase%d", i+1)
+		}
+	}
+	return &sc, nil
+}
+
+// tracker records what happened to every saga this run emitted.
+type tracker struct {
+	mu        sync.Mutex
+	phaseOf   map[string]int
+	emitted   []int
+	completed map[string]bool
+	dlq       map[string]bool
+}
+
+func (t *tracker) emit(id string, phase int) {
+	t.mu.Lock()
+	t.phaseOf[id] = phase
+	t.emitted[phase]++
+	t.mu.Unlock()
+}
+
+func (t *tracker) forget(id string, phase int) {
+	t.mu.Lock()
+	delete(t.phaseOf, id)
+	t.emitted[phase]--
+	t.mu.Unlock()
+}
+
+func (t *tracker) mark(set map[string]bool, id string) {
+	t.mu.Lock()
+	if _, ours := t.phaseOf[id]; ours {
+		set[id] = true
+	}
+	t.mu.Unlock()
+}
+
+func main() {
+	brokers := flag.String("brokers", envOr("KAFKA_BROKERS", "localhost:9092"), "comma separated Kafka brokers")
+	scenarioName := flag.String("scenario", "smoke", "scenario file, or the name of a built-in one (smoke, soak)")
+	topicIn := flag.String("topic-in", "saga.step1", "topic sagas are started on")
+	topicDone := flag.String("topic-done", "saga.step5.completed", "topic of completed sagas")
+	dlqTopic := flag.String("dlq", "saga.dlq", "dead-letter topic")
+	failURLs := flag.String("fail-url", envOr("FAIL_MODE_URLS", "http://step5:8080/fail-mode"), "comma separated /fail-mode endpoints to switch per phase")
+	warmup := flag.Duration("warmup", 10*time.Second, "time for the watchers to join their groups before emitting")
+	flag.Parse()
+
+	sc, err := loadScenario(*scenarioName)
+	if err != nil {
+		log.Fatal(err)
+	}
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	run := fmt.Sprintf("load-%d", time.Now().Unix())
+	t := &tracker{phaseOf: map[string]int{}, emitted: make([]int, len(sc.Phases)), completed: map[string]bool{}, dlq: map[string]bool{}}
+
+	// watchers start at the end of the topics; only this run's sagas count
+	watchCtx, stopWatch := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+	for topic, set := range map[string]map[string]bool{*topicDone: t.completed, *dlqTopic: t.dlq} {
+		wg.Add(1)
+		go func(topic string, set map[string]bool) {
+			defer wg.Done()
+			watch(watchCtx, *brokers, topic, func(id string) { t.mark(set, id) })
+		}(topic, set)
+	}
+
+	select {
+	case <-ctx.Done():
+	case <-time.After(*warmup):
+	}
+
+	writer := common.NewWriter(*brokers)
+	defer writer.Close()
+	urls := strings.Split(*failURLs, ",")
+
+	log.Printf("[sagaload] run=%s rate=%.1f/s phases=%d", run, sc.RatePerSec, len(sc.Phases))
+	interval := time.Duration(float64(time.Second) / sc.RatePerSec)
+	n := 0
+	for i, p := range sc.Phases {
+		if err := setFailMode(ctx, urls, p.FailMode); err != nil {
+			log.Fatalf("[sagaload] phase %s: %v", p.Name, err)
+		}
+		log.Printf("[sagaload] phase %s: %s with FAIL_MODE=%s", p.Name, p.Duration, p.FailMode)
+		end := time.Now().Add(p.Duration.Duration)
+		tick := time.NewTicker(interval)
+		for time.Now().Before(end) && ctx.Err() == nil {
+			select {
+			case <-ctx.Done():
+			case <-tick.C:
+				n++
+				id := fmt.Sprintf("%s-%s-%d", run, p.Name, n)
+				evt := common.Event{SagaID: id, Step: 1, SchemaVersion: 1, Ts: time.Now(), Payload: map[string]any{"run": run, "phase": p.Name}}
+				msg := kafka.Message{Topic: *topicIn, Key: []byte(id), Value: common.MustJSON(evt), Headers: []kafka.Header{{Key: "x-saga-id", Value: []byte(id)}}}
+				// register first so a fast completion can't be missed
+				t.emit(id, i)
+				if err := writer.WriteMessages(ctx, msg); err != nil {
+					log.Printf("[sagaload] produce err: %v", err)
+					t.forget(id, i)
+				}
+			}
+		}
+		tick.Stop()
+	}
+
+	// let in-flight sagas (and DLQ replays) settle with failures switched off
+	if err := setFailMode(context.Background(), urls, "none"); err != nil {
+		log.Printf("[sagaload] reset FAIL_MODE: %v", err)
+	}
+	log.Printf("[sagaload] draining for %s", sc.Drain.Duration)
+	select {
+	case <-ctx.Done():
+	case <-time.After(sc.Drain.Duration):
+	}
+	stopWatch()
+	wg.Wait()
+
+	if !report(os.Stdout, sc, t) {
+		os.Exit(1)
+	}
+}
+
+func watch(ctx context.Context, brokers, topic string, seen func(id string)) {
+	r := kafka.NewReader(kafka.ReaderConfig{
+		Brokers:     strings.Split(brokers, ","),
+		Topic:       topic,
+		GroupID:     fmt.Sprintf("sagaload-%s-%d", topic, time.Now().UnixNano()),
+		StartOffset: kafka.LastOffset,
+		MinBytes:    1,
+		MaxBytes:    10e6,
+	})
+	defer r.Close()
+	for {
+		m, err := r.ReadMessage(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Printf("[sagaload] read %s: %v", topic, err)
+			continue
+		}
+		var evt common.Event
+		if err := json.Unmarshal(m.Value, &evt); err == nil {
+			seen(evt.SagaID)
+		}
+	}
+}
+
+func setFailMode(ctx context.Context, urls []string, mode string) error {
+	if mode == "" {
+		mode = "none"
+	}
+	for _, u := range urls {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPut, strings.TrimSpace(u), strings.NewReader(mode))
+		if err != nil {
+			return err
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("%s: %s: %s", u, resp.Status, strings.TrimSpace(string(body)))
+		}
+	}
+	return nil
+}
+
+// report prints per-phase outcomes and returns whether every expectation held.
+func report(out io.Writer, sc *Scenario, t *tracker) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	completed := make([]int, len(sc.Phases))
+	dlq := make([]int, len(sc.Phases))
+	for id := range t.completed {
+		completed[t.phaseOf[id]]++
+	}
+	for id := range t.dlq {
+		dlq[t.phaseOf[id]]++
+	}
+
+	ok := true
+	tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "PHASE\tFAIL_MODE\tEMITTED\tCOMPLETED\tDLQ\tRESULT")
+	for i, p := range sc.Phases {
+		var failures []string
+		cr, dr := ratio(completed[i], t.emitted[i]), ratio(dlq[i], t.emitted[i])
+		if p.Expect.MinCompleted != nil && cr < *p.Expect.MinCompleted {
+			failures = append(failures, fmt.Sprintf("completed < %.2f", *p.Expect.MinCompleted))
+		}
+		if p.Expect.MinDLQ != nil && dr < *p.Expect.MinDLQ {
+			failures = append(failures, fmt.Sprintf("dlq < %.2f", *p.Expect.MinDLQ))
+		}
+		if p.Expect.MaxDLQ != nil && dr > *p.Expect.MaxDLQ {
+			failures = append(failures, fmt.Sprintf("dlq > %.2f", *p.Expect.MaxDLQ))
+		}
+		result := "PASS"
+		if t.emitted[i] == 0 {
+			failures = append(failures, "nothing emitted")
+		}
+		if len(failures) > 0 {
+			ok = false
+			result = "FAIL: " + strings.Join(failures, ", ")
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%d (%.1f%%)\t%d (%.1f%%)\t%s\n",
+			p.Name, p.FailMode, t.emitted[i], completed[i], 100*cr, dlq[i], 100*dr, result)
+	}
+	tw.Flush()
+	if ok {
+		fmt.Fprintln(out, "sagaload: PASS")
+	} else {
+		fmt.Fprintln(out, "sagaload: FAIL")
+	}
+	return ok
+}
+
+func ratio(n, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(n) / float64(total)
+}
+
+func envOr(k, def string) string {
+	if v := os.Getenv(k); v != "" {
+		return v
+	}
+	return def
+}
@@ -0,0 +1,131 @@
+// Package archive batches completed saga events into Parquet files for cold
+// storage and analytics. Files are partitioned Hive-style by completion date
+// and step:
+//
+//	<prefix>/date=2024-05-01/step=6/<topic>-<partition>-<first offset>.parquet
+//
+// A file is named after the first record it holds, so flushing the same
+// batch again (after a failed flush or a restart) rewrites the same objects
+// instead of adding new ones. Records may still appear in two files when a
+// restart cuts a batch differently; topic/partition/offset identify a record
+// for deduplication.
+package archive
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+// Record is one row of an archive file.
+type Record struct {
+	SagaID        string `parquet:"name=saga_id, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Step          int32  `parquet:"name=step, type=INT32"`
+	SchemaVersion int32  `parquet:"name=schema_version, type=INT32"`
+	Priority      string `parquet:"name=priority, type=BYTE_ARRAY, convertedtype=UTF8"`
+	EventTs       int64  `parquet:"name=event_ts, type=INT64, convertedtype=TIMESTAMP_MILLIS"`
+	CompletedAt   int64  `parquet:"name=completed_at, type=INT64, convertedtype=TIMESTAMP_MILLIS"`
+	Payload       string `parquet:"name=payload, type=BYTE_ARRAY, convertedtype=UTF8"` // JSON
+	Topic         string `parquet:"name=topic, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Partition     int32  `parquet:"name=partition, type=INT32"`
+	Offset        int64  `parquet:"name=offset, type=INT64"`
+}
+
+// Encode writes records as one Snappy-compressed Parquet file.
+func Encode(records []Record) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := writer.NewParquetWriterFromWriter(&buf, new(Record), 1)
+	if err != nil {
+		return nil, err
+	}
+	w.CompressionType = parquet.CompressionCodec_SNAPPY
+	for _, r := range records {
+		if err := w.Write(r); err != nil {
+			return nil, err
+		}
+	}
+	if err := w.WriteStop(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+type group struct {
+	date string
+	step int32
+}
+
+// Batch collects records until they are flushed. It is not safe for
+// concurrent use.
+type Batch struct {
+	groups  map[group][]Record
+	n       int
+	started time.Time
+}
+
+func NewBatch() *Batch { return &Batch{groups: map[group][]Record{}} }
+
+// Add appends r to the file of its completion date and step.
+func (b *Batch) Add(r Record, now time.Time) {
+	if b.n == 0 {
+		b.started = now
+	}
+	g := group{time.UnixMilli(r.CompletedAt).UTC().Format("2006-01-02"), r.Step}
+	b.groups[g] = append(b.groups[g], r)
+	b.n++
+}
+
+// Len is the number of records in the batch.
+func (b *Batch) Len() int { return b.n }
+
+// Age is how long ago the first record was added; 0 for an empty batch.
+func (b *Batch) Age(now time.Time) time.Duration {
+	if b.n == 0 {
+		return 0
+	}
+	return now.Sub(b.started)
+}
+
+// File is one object of a flush.
+type File struct {
+	Key     string
+	Records []Record
+}
+
+// Files lists the objects the batch is written as, in key order.
+func (b *Batch) Files(prefix string) []File {
+	var out []File
+	for g, recs := range b.groups {
+		first := recs[0]
+		name := fmt.Sprintf("%s-%d-%d.parquet", first.Topic, first.Partition, first.Offset)
+		out = append(out, File{
+			Key:     path.Join(prefix, "date="+g.date, fmt.Sprintf("step=%d", g.step), name),
+			Records: recs,
+		})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Key < out[j].Key })
+	return out
+}
+
+// Flush writes every file of the batch to store and empties the batch. On
+// error the batch is kept as it is, so calling Flush again retries it.
+func (b *Batch) Flush(ctx context.Context, store Store, prefix string) ([]File, error) {
+	files := b.Files(prefix)
+	for _, f := range files {
+		data, err := Encode(f.Records)
+		if err != nil {
+			return nil, fmt.Errorf("archive: encode %s: %w", f.Key, err)
+		}
+		if err := store.Put(ctx, f.Key, data); err != nil {
+			return nil, fmt.Errorf("archive: put %s: %w", f.Key, err)
+		}
+	}
+	b.groups, b.n = map[group][]Record{}, 0
+	return files, nil
+}
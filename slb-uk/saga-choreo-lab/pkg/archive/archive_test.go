@@ -0,0 +1,181 @@
+package archive
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/xitongsys/parquet-go-source/buffer"
+	"github.com/xitongsys/parquet-go/reader"
+)
+
+var day1 = time.Date(2024, 5, 1, 23, 59, 0, 0, time.UTC)
+
+func rec(partition int32, offset int64, step int32, completed time.Time) Record {
+	return Record{SagaID: "saga-1", Step: step, SchemaVersion: 1, Priority: "normal",
+		EventTs: completed.Add(-time.Second).UnixMilli(), CompletedAt: completed.UnixMilli(),
+		Payload: `{"demo":"start"}`, Topic: "saga.step5.completed", Partition: partition, Offset: offset}
+}
+
+func decode(t *testing.T, data []byte) []Record {
+	t.Helper()
+	f, err := buffer.NewBufferFile(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := reader.NewParquetReader(f, new(Record), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.ReadStop()
+	out := make([]Record, r.GetNumRows())
+	if err := r.Read(&out); err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func TestEncodeRoundTrip(t *testing.T) {
+	in := []Record{rec(0, 10, 6, day1), rec(1, 3, 6, day1.Add(time.Minute))}
+	in[1].Payload = `{"note":"héllo"}`
+	data, err := Encode(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data[:4]) != "PAR1" {
+		t.Fatalf("not a Parquet file: %q", data[:4])
+	}
+	if got := decode(t, data); !reflect.DeepEqual(got, in) {
+		t.Fatalf("round trip:\n got %+v\nwant %+v", got, in)
+	}
+}
+
+func TestFilesArePartitionedByDateAndStep(t *testing.T) {
+	b := NewBatch()
+	now := time.Now()
+	b.Add(rec(0, 10, 6, day1), now)
+	b.Add(rec(1, 4, 6, day1), now)
+	b.Add(rec(0, 11, 6, day1.Add(2*time.Minute)), now) // next day
+	b.Add(rec(2, 7, 5, day1), now)
+	if b.Len() != 4 {
+		t.Fatalf("Len = %d", b.Len())
+	}
+
+	var keys []string
+	for _, f := range b.Files("sagas") {
+		keys = append(keys, f.Key)
+	}
+	want := []string{
+		"sagas/date=2024-05-01/step=5/saga.step5.completed-2-7.parquet",
+		"sagas/date=2024-05-01/step=6/saga.step5.completed-0-10.parquet",
+		"sagas/date=2024-05-02/step=6/saga.step5.completed-0-11.parquet",
+	}
+	if !reflect.DeepEqual(keys, want) {
+		t.Fatalf("keys:\n got %q\nwant %q", keys, want)
+	}
+}
+
+func TestAge(t *testing.T) {
+	b := NewBatch()
+	t0 := time.Now()
+	if b.Age(t0) != 0 {
+		t.Fatal("empty batch has an age")
+	}
+	b.Add(rec(0, 1, 6, day1), t0)
+	b.Add(rec(0, 2, 6, day1), t0.Add(time.Second))
+	if got := b.Age(t0.Add(5 * time.Second)); got != 5*time.Second {
+		t.Fatalf("Age = %s, want 5s from the first record", got)
+	}
+}
+
+type failing struct {
+	*Memory
+	fail bool
+}
+
+func (f *failing) Put(ctx context.Context, key string, data []byte) error {
+	if f.fail {
+		return errors.New("store down")
+	}
+	return f.Memory.Put(ctx, key, data)
+}
+
+func TestFlushKeepsBatchOnError(t *testing.T) {
+	ctx := context.Background()
+	store := &failing{Memory: NewMemory(), fail: true}
+	b := NewBatch()
+	b.Add(rec(0, 10, 6, day1), time.Now())
+	b.Add(rec(0, 11, 6, day1), time.Now())
+
+	if _, err := b.Flush(ctx, store, "sagas"); err == nil {
+		t.Fatal("Flush succeeded against a failing store")
+	}
+	if b.Len() != 2 {
+		t.Fatalf("batch lost records on a failed flush: Len = %d", b.Len())
+	}
+
+	store.fail = false
+	files, err := b.Flush(ctx, store, "sagas")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.Len() != 0 || len(files) != 1 {
+		t.Fatalf("after flush: Len = %d, %d files", b.Len(), len(files))
+	}
+	data, ok := store.Get(files[0].Key)
+	if !ok {
+		t.Fatalf("%s not stored (have %q)", files[0].Key, store.Keys())
+	}
+	if got := decode(t, data); len(got) != 2 || got[1].Offset != 11 {
+		t.Fatalf("stored records: %+v", got)
+	}
+}
+
+func TestFSWritesPartitionDirectories(t *testing.T) {
+	dir := t.TempDir()
+	fs := &FS{Dir: dir}
+	key := "sagas/date=2024-05-01/step=6/saga.step5.completed-0-10.parquet"
+	if err := fs.Put(context.Background(), key, []byte("PAR1")); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(key)))
+	if err != nil || string(b) != "PAR1" {
+		t.Fatalf("read back: %q, %v", b, err)
+	}
+	leftovers, _ := filepath.Glob(filepath.Join(dir, "sagas/date=2024-05-01/step=6/.put-*"))
+	if len(leftovers) != 0 {
+		t.Fatalf("temp files left: %v", leftovers)
+	}
+	if err := fs.Put(context.Background(), "../escape", nil); err == nil {
+		t.Fatal("key with .. accepted")
+	}
+}
+
+func TestStoreFromEnv(t *testing.T) {
+	t.Setenv("ARCHIVE_STORE", "")
+	t.Setenv("ARCHIVE_DIR", "/data/archive")
+	s, where, err := StoreFromEnv()
+	if fs, ok := s.(*FS); err != nil || !ok || fs.Dir != "/data/archive" || where != "fs:/data/archive" {
+		t.Fatalf("default: %T %q %v", s, where, err)
+	}
+
+	t.Setenv("ARCHIVE_STORE", "s3")
+	t.Setenv("ARCHIVE_S3_ENDPOINT", "")
+	if _, _, err := StoreFromEnv(); err == nil {
+		t.Fatal("s3 without endpoint accepted")
+	}
+	t.Setenv("ARCHIVE_S3_ENDPOINT", "minio:9000")
+	t.Setenv("ARCHIVE_S3_BUCKET", "sagas")
+	if s, where, err := StoreFromEnv(); err != nil || where != "s3://sagas" {
+		t.Fatalf("s3: %T %q %v", s, where, err)
+	}
+
+	t.Setenv("ARCHIVE_STORE", "gcs")
+	if _, _, err := StoreFromEnv(); err == nil {
+		t.Fatal("unknown store accepted")
+	}
+}
@@ -0,0 +1,131 @@
+package archive
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// Store is where archive files go. Keys are slash-separated paths.
+type Store interface {
+	Put(ctx context.Context, key string, data []byte) error
+}
+
+// StoreFromEnv opens the store named by ARCHIVE_STORE:
+//
+//	fs (default)  files under ARCHIVE_DIR (default ./archive)
+//	s3            objects in ARCHIVE_S3_BUCKET on ARCHIVE_S3_ENDPOINT, with
+//	              ARCHIVE_S3_ACCESS_KEY / ARCHIVE_S3_SECRET_KEY; TLS unless
+//	              ARCHIVE_S3_INSECURE=true (MinIO in the cluster)
+func StoreFromEnv() (Store, string, error) {
+	switch kind := os.Getenv("ARCHIVE_STORE"); kind {
+	case "", "fs":
+		dir := os.Getenv("ARCHIVE_DIR")
+		if dir == "" {
+			dir = "archive"
+		}
+		return &FS{Dir: dir}, "fs:" + dir, nil
+	case "s3":
+		endpoint, bucket := os.Getenv("ARCHIVE_S3_ENDPOINT"), os.Getenv("ARCHIVE_S3_BUCKET")
+		if endpoint == "" || bucket == "" {
+			return nil, "", fmt.Errorf("ARCHIVE_STORE=s3 needs ARCHIVE_S3_ENDPOINT and ARCHIVE_S3_BUCKET")
+		}
+		s, err := NewS3(endpoint, bucket, os.Getenv("ARCHIVE_S3_ACCESS_KEY"), os.Getenv("ARCHIVE_S3_SECRET_KEY"),
+			os.Getenv("ARCHIVE_S3_INSECURE") != "true")
+		return s, "s3://" + bucket, err
+	default:
+		return nil, "", fmt.Errorf("unknown ARCHIVE_STORE %q (fs, s3)", kind)
+	}
+}
+
+// FS writes files under Dir, creating the partition directories.
+type FS struct{ Dir string }
+
+func (f *FS) Put(_ context.Context, key string, data []byte) error {
+	if strings.Contains(key, "..") {
+		return fmt.Errorf("archive: invalid key %q", key)
+	}
+	p := filepath.Join(f.Dir, filepath.FromSlash(key))
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return err
+	}
+	// write then rename so readers never see a partial file
+	tmp, err := os.CreateTemp(filepath.Dir(p), ".put-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), p)
+}
+
+// S3 puts objects into a bucket of any S3-compatible service.
+type S3 struct {
+	client *minio.Client
+	bucket string
+}
+
+func NewS3(endpoint, bucket, accessKey, secretKey string, secure bool) (*S3, error) {
+	c, err := minio.New(endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(accessKey, secretKey, ""),
+		Secure: secure,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &S3{client: c, bucket: bucket}, nil
+}
+
+func (s *S3) Put(ctx context.Context, key string, data []byte) error {
+	_, err := s.client.PutObject(ctx, s.bucket, key, bytes.NewReader(data), int64(len(data)),
+		minio.PutObjectOptions{ContentType: "application/vnd.apache.parquet"})
+	return err
+}
+
+// Memory keeps objects in process, for tests.
+type Memory struct {
+	mu sync.Mutex
+	m  map[string][]byte
+}
+
+func NewMemory() *Memory { return &Memory{m: map[string][]byte{}} }
+
+func (s *Memory) Put(_ context.Context, key string, data []byte) error {
+	s.mu.Lock()
+	s.m[key] = append([]byte(nil), data...)
+	s.mu.Unlock()
+	return nil
+}
+
+// Get returns the object at key.
+func (s *Memory) Get(key string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, ok := s.m[key]
+	return b, ok
+}
+
+// Keys lists the stored keys.
+func (s *Memory) Keys() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var out []string
+	for k := range s.m {
+		out = append(out, k)
+	}
+	return out
+}
@@ -0,0 +1,171 @@
+// Package claimcheck keeps large payloads out of Kafka. Payloads above a
+// threshold are written to a Store and only a reference travels with the
+// message; consumers fetch the payload back by that reference.
+package claimcheck
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Header carries the reference of an offloaded payload.
+const Header = "x-claim-check"
+
+// ErrNotFound is returned when a referenced payload is not in the store.
+var ErrNotFound = errors.New("claimcheck: payload not found")
+
+// Store holds offloaded payloads by key.
+type Store interface {
+	Put(ctx context.Context, key string, data []byte) error
+	Get(ctx context.Context, key string) ([]byte, error)
+}
+
+var (
+	payloadBytes = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "saga_payload_bytes",
+		Help:    "Encoded payload size by where it went (inline or offloaded)",
+		Buckets: prometheus.ExponentialBuckets(1024, 4, 8), // 1KiB .. 16MiB
+	}, []string{"placement"})
+	claimChecks = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "saga_claim_check_total",
+		Help: "Claim-check operations by op (offload, fetch) and result",
+	}, []string{"op", "result"})
+)
+
+// Checker decides which payloads to offload. References are content
+// addressed ("sha256:<hex>"), so a retried offload rewrites the same blob.
+type Checker struct {
+	store     Store
+	threshold int
+}
+
+// New offloads payloads larger than threshold bytes to store.
+func New(store Store, threshold int) *Checker {
+	return &Checker{store: store, threshold: threshold}
+}
+
+// Offload returns ("", nil) when data is small enough to send inline;
+// otherwise it stores data and returns its reference.
+func (c *Checker) Offload(ctx context.Context, data []byte) (string, error) {
+	if c == nil || len(data) <= c.threshold {
+		payloadBytes.WithLabelValues("inline").Observe(float64(len(data)))
+		return "", nil
+	}
+	sum := sha256.Sum256(data)
+	ref := "sha256:" + hex.EncodeToString(sum[:])
+	if err := c.store.Put(ctx, ref, data); err != nil {
+		claimChecks.WithLabelValues("offload", "error").Inc()
+		return "", fmt.Errorf("claimcheck: store %s: %w", ref, err)
+	}
+	claimChecks.WithLabelValues("offload", "ok").Inc()
+	payloadBytes.WithLabelValues("offloaded").Observe(float64(len(data)))
+	return ref, nil
+}
+
+// Fetch loads an offloaded payload and verifies it against its reference.
+func (c *Checker) Fetch(ctx context.Context, ref string) ([]byte, error) {
+	data, err := c.store.Get(ctx, ref)
+	if err == nil {
+		sum := sha256.Sum256(data)
+		if want := strings.TrimPrefix(ref, "sha256:"); hex.EncodeToString(sum[:]) != want {
+			err = fmt.Errorf("claimcheck: %s: content does not match reference", ref)
+		}
+	}
+	if err != nil {
+		claimChecks.WithLabelValues("fetch", "error").Inc()
+		return nil, err
+	}
+	claimChecks.WithLabelValues("fetch", "ok").Inc()
+	return data, nil
+}
+
+// FS stores payloads as files under Dir, which every service must share
+// (e.g. a ReadWriteMany volume).
+type FS struct{ Dir string }
+
+func NewFS(dir string) (*FS, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &FS{Dir: dir}, nil
+}
+
+func (f *FS) path(key string) (string, error) {
+	name := strings.TrimPrefix(key, "sha256:")
+	if len(name) < 3 || strings.ContainsAny(name, `/\.`) {
+		return "", fmt.Errorf("claimcheck: invalid key %q", key)
+	}
+	return filepath.Join(f.Dir, name[:2], name), nil
+}
+
+func (f *FS) Put(_ context.Context, key string, data []byte) error {
+	p, err := f.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return err
+	}
+	// write then rename so readers never see a partial payload
+	tmp, err := os.CreateTemp(filepath.Dir(p), ".put-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), p)
+}
+
+func (f *FS) Get(_ context.Context, key string) ([]byte, error) {
+	p, err := f.path(key)
+	if err != nil {
+		return nil, err
+	}
+	b, err := os.ReadFile(p)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, key)
+	}
+	return b, err
+}
+
+// Memory is an in-process Store for tests and single-process runs.
+type Memory struct {
+	mu sync.RWMutex
+	m  map[string][]byte
+}
+
+func NewMemory() *Memory { return &Memory{m: map[string][]byte{}} }
+
+func (s *Memory) Put(_ context.Context, key string, data []byte) error {
+	s.mu.Lock()
+	s.m[key] = append([]byte(nil), data...)
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *Memory) Get(_ context.Context, key string) ([]byte, error) {
+	s.mu.RLock()
+	b, ok := s.m[key]
+	s.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, key)
+	}
+	return b, nil
+}
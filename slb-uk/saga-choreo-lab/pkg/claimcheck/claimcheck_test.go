@@ -0,0 +1,98 @@
+package claimcheck
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestSmallPayloadStaysInline(t *testing.T) {
+	c := New(NewMemory(), 1024)
+	ref, err := c.Offload(context.Background(), bytes.Repeat([]byte("x"), 1024))
+	if err != nil || ref != "" {
+		t.Fatalf("Offload = %q, %v; want inline", ref, err)
+	}
+}
+
+func TestMultiMBRoundTrip(t *testing.T) {
+	stores := map[string]Store{"memory": NewMemory()}
+	fs, err := NewFS(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	stores["fs"] = fs
+
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			c := New(store, 256<<10)
+			for _, size := range []int{1 << 20, 5 << 20, 12 << 20} {
+				data := make([]byte, size)
+				if _, err := rand.Read(data); err != nil {
+					t.Fatal(err)
+				}
+				before := testutil.ToFloat64(claimChecks.WithLabelValues("offload", "ok"))
+				ref, err := c.Offload(ctx, data)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !strings.HasPrefix(ref, "sha256:") {
+					t.Fatalf("ref = %q", ref)
+				}
+				if got := testutil.ToFloat64(claimChecks.WithLabelValues("offload", "ok")); got != before+1 {
+					t.Errorf("offload counter = %v, want %v", got, before+1)
+				}
+				back, err := c.Fetch(ctx, ref)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(back, data) {
+					t.Fatalf("%d bytes: rehydrated payload differs", size)
+				}
+			}
+		})
+	}
+}
+
+func TestOffloadIsContentAddressed(t *testing.T) {
+	c := New(NewMemory(), 10)
+	data := bytes.Repeat([]byte("saga"), 1000)
+	a, _ := c.Offload(context.Background(), data)
+	b, _ := c.Offload(context.Background(), data)
+	if a == "" || a != b {
+		t.Fatalf("refs %q and %q should be equal", a, b)
+	}
+}
+
+func TestFetchErrors(t *testing.T) {
+	dir := t.TempDir()
+	fs, _ := NewFS(dir)
+	c := New(fs, 10)
+	ctx := context.Background()
+
+	if _, err := c.Fetch(ctx, "sha256:"+strings.Repeat("ab", 32)); !errors.Is(err, ErrNotFound) {
+		t.Errorf("missing payload: err = %v, want ErrNotFound", err)
+	}
+	if _, err := c.Fetch(ctx, "sha256:../../etc/passwd"); err == nil {
+		t.Error("path traversal key accepted")
+	}
+
+	ref, err := c.Offload(ctx, bytes.Repeat([]byte("z"), 100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := strings.TrimPrefix(ref, "sha256:")
+	if err := os.WriteFile(filepath.Join(dir, name[:2], name), []byte("tampered"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Fetch(ctx, ref); err == nil {
+		t.Error("tampered payload accepted")
+	}
+}
@@ -0,0 +1,321 @@
+package common
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/segmentio/kafka-go"
+)
+
+// An approval step (STEP_TYPE=approval, sagagen's `type: approval`) runs no
+// handler. It holds every saga it consumes until someone decides over HTTP:
+//
+//	GET  /approvals                         pending sagas, oldest deadline first
+//	POST /approvals/{saga_id}/approve       hand the saga on to TOPIC_OUT
+//	POST /approvals/{saga_id}/reject        compensate it, like a fatal failure
+//
+// A POST body of {"by": "...", "reason": "..."} is optional and ends up in
+// the x-approval-by / x-approval-reason headers. A saga nobody decides on
+// within APPROVAL_TIMEOUT (default 24h) is rejected with reason "timeout".
+//
+// Pending sagas are kept in APPROVAL_STATE_TOPIC, a compacted topic keyed
+// by saga ID with SagaState records, and read back on startup; a decision
+// writes a tombstone. The gate keeps them in memory and reads the whole
+// topic, so an approval step runs as a single replica.
+const (
+	ApprovalHeader       = "x-approval" // approved, rejected or timed_out
+	ApprovalByHeader     = "x-approval-by"
+	ApprovalReasonHeader = "x-approval-reason"
+)
+
+var (
+	ApprovalsPending = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{Name: "saga_approvals_pending", Help: "sagas waiting for a decision by approval step"},
+		[]string{"step"},
+	)
+	ApprovalDecisions = prometheus.NewCounterVec(
+		prometheus.CounterOpts{Name: "saga_approval_decisions_total", Help: "approval decisions by step and decision (approved, rejected, timed_out)"},
+		[]string{"step", "decision"},
+	)
+	ApprovalWait = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{Name: "saga_approval_wait_seconds", Help: "time from a saga reaching an approval step to the decision",
+			Buckets: []float64{1, 10, 60, 300, 900, 3600, 4 * 3600, 24 * 3600}},
+		[]string{"step", "decision"},
+	)
+)
+
+func init() { prometheus.MustRegister(ApprovalsPending, ApprovalDecisions, ApprovalWait) }
+
+// MessageWriter is the part of *kafka.Writer the gate uses.
+type MessageWriter interface {
+	WriteMessages(ctx context.Context, msgs ...kafka.Message) error
+}
+
+// PendingApproval is a saga held by an approval step.
+type PendingApproval struct {
+	SagaID   string         `json:"saga_id"`
+	Priority string         `json:"priority"`
+	Payload  map[string]any `json:"payload"`
+	Since    time.Time      `json:"since"`
+	Deadline time.Time      `json:"deadline"`
+
+	evt Event
+	msg kafka.Message // as consumed, Topic without region prefix
+}
+
+// ApprovalGate holds sagas for an approval step.
+type ApprovalGate struct {
+	Step       int
+	Out        string // TOPIC_OUT
+	Lanes      bool
+	StateTopic string
+	Timeout    time.Duration
+	Comp       Compensation
+
+	w   MessageWriter
+	now func() time.Time
+
+	mu      sync.Mutex
+	pending map[string]*PendingApproval
+}
+
+// ApprovalGateFromEnv returns the gate for STEP_TYPE=approval, nil for any
+// other step.
+func ApprovalGateFromEnv(step int, out string, comp Compensation, w MessageWriter) (*ApprovalGate, error) {
+	switch t := os.Getenv("STEP_TYPE"); t {
+	case "", "service":
+		return nil, nil
+	case "approval":
+	default:
+		return nil, fmt.Errorf("invalid STEP_TYPE %q (service, approval)", t)
+	}
+	g := &ApprovalGate{Step: step, Out: out, Lanes: LanesEnabled(), StateTopic: os.Getenv("APPROVAL_STATE_TOPIC"),
+		Timeout: 24 * time.Hour, Comp: comp, w: w, now: time.Now, pending: map[string]*PendingApproval{}}
+	if g.StateTopic == "" {
+		return nil, fmt.Errorf("STEP_TYPE=approval needs APPROVAL_STATE_TOPIC")
+	}
+	if v := os.Getenv("APPROVAL_TIMEOUT"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid APPROVAL_TIMEOUT %q", v)
+		}
+		g.Timeout = d
+	}
+	return g, nil
+}
+
+func (g *ApprovalGate) label() string { return strconv.Itoa(g.Step) }
+
+// Restore loads the sagas that were pending when the step last stopped. A
+// state topic that does not exist yet has none.
+func (g *ApprovalGate) Restore(ctx context.Context, brokers string) error {
+	states, err := ReadSagaStates(ctx, brokers, g.StateTopic)
+	if errors.Is(err, kafka.UnknownTopicOrPartition) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for id, st := range states {
+		m := kafka.Message{Topic: st.Topic, Key: st.Key, Value: st.Value, Headers: st.Headers}
+		evt, err := DecodeEvent(ctx, m)
+		if err != nil {
+			log.Printf("[step%d] approval state of saga %s: %v", g.Step, id, err)
+			continue
+		}
+		g.add(g.pendingFor(m, evt, st.Ts))
+	}
+	log.Printf("[step%d] %d sagas awaiting approval", g.Step, len(states))
+	return nil
+}
+
+func (g *ApprovalGate) pendingFor(m kafka.Message, evt Event, since time.Time) *PendingApproval {
+	return &PendingApproval{SagaID: evt.SagaID, Priority: MessagePriority(m), Payload: evt.Payload,
+		Since: since, Deadline: since.Add(g.Timeout), evt: evt, msg: m}
+}
+
+func (g *ApprovalGate) add(p *PendingApproval) {
+	g.mu.Lock()
+	g.pending[p.SagaID] = p
+	ApprovalsPending.WithLabelValues(g.label()).Set(float64(len(g.pending)))
+	g.mu.Unlock()
+}
+
+// take removes a pending saga so that only one decision acts on it.
+func (g *ApprovalGate) take(sagaID string) (*PendingApproval, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	p, ok := g.pending[sagaID]
+	if ok {
+		delete(g.pending, sagaID)
+		ApprovalsPending.WithLabelValues(g.label()).Set(float64(len(g.pending)))
+	}
+	return p, ok
+}
+
+// Hold persists a consumed saga and starts its wait. A saga that is
+// already pending (a redelivery) keeps its original deadline.
+func (g *ApprovalGate) Hold(ctx context.Context, m kafka.Message, evt Event) error {
+	g.mu.Lock()
+	_, dup := g.pending[evt.SagaID]
+	g.mu.Unlock()
+	if dup {
+		return nil
+	}
+	m.Topic = BaseTopic(m.Topic)
+	p := g.pendingFor(m, evt, g.now())
+	st := SagaState{SagaID: evt.SagaID, Topic: m.Topic, Key: m.Key, Value: m.Value, Headers: m.Headers, Ts: p.Since}
+	if err := g.w.WriteMessages(ctx, kafka.Message{Topic: g.StateTopic, Key: []byte(evt.SagaID), Value: MustJSON(st)}); err != nil {
+		return err
+	}
+	g.add(p)
+	log.Printf("[step%d] saga %s awaiting approval until %s", g.Step, evt.SagaID, p.Deadline.Format(time.RFC3339))
+	return nil
+}
+
+// Pending lists the held sagas, oldest deadline first.
+func (g *ApprovalGate) Pending() []*PendingApproval {
+	g.mu.Lock()
+	out := make([]*PendingApproval, 0, len(g.pending))
+	for _, p := range g.pending {
+		out = append(out, p)
+	}
+	g.mu.Unlock()
+	sort.Slice(out, func(i, j int) bool {
+		if !out[i].Deadline.Equal(out[j].Deadline) {
+			return out[i].Deadline.Before(out[j].Deadline)
+		}
+		return out[i].SagaID < out[j].SagaID
+	})
+	return out
+}
+
+// ErrNotPending is returned for a saga the gate does not hold.
+var ErrNotPending = errors.New("saga is not awaiting approval")
+
+// Decide approves or rejects a held saga: "approved" hands it on to
+// TOPIC_OUT, "rejected" and "timed_out" start compensation. The saga stays
+// pending if its records cannot be written.
+func (g *ApprovalGate) Decide(ctx context.Context, sagaID, decision, by, reason string) error {
+	p, ok := g.take(sagaID)
+	if !ok {
+		return ErrNotPending
+	}
+	if err := g.decide(ctx, p, decision, by, reason); err != nil {
+		g.add(p)
+		return err
+	}
+	ApprovalDecisions.WithLabelValues(g.label(), decision).Inc()
+	ApprovalWait.WithLabelValues(g.label(), decision).Observe(g.now().Sub(p.Since).Seconds())
+	log.Printf("[step%d] saga %s %s (by %q, reason %q)", g.Step, sagaID, decision, by, reason)
+	return nil
+}
+
+func (g *ApprovalGate) decide(ctx context.Context, p *PendingApproval, decision, by, reason string) error {
+	headers := append(withoutHeader(p.msg.Headers, "x-saga-id"), kafka.Header{Key: "x-saga-id", Value: []byte(p.SagaID)},
+		kafka.Header{Key: ApprovalHeader, Value: []byte(decision)})
+	if by != "" {
+		headers = append(headers, kafka.Header{Key: ApprovalByHeader, Value: []byte(by)})
+	}
+	if reason != "" {
+		headers = append(headers, kafka.Header{Key: ApprovalReasonHeader, Value: []byte(reason)})
+	}
+	tombstone := kafka.Message{Topic: g.StateTopic, Key: []byte(p.SagaID)}
+
+	if decision != "approved" {
+		if g.Comp.Out == "" {
+			log.Printf("[step%d] saga %s %s, nothing to compensate", g.Step, p.SagaID, decision)
+			return g.w.WriteMessages(ctx, tombstone)
+		}
+		msg := kafka.Message{Topic: RegionTopic(g.Comp.Out), Key: p.msg.Key, Value: p.msg.Value, Headers: append(headers,
+			kafka.Header{Key: "x-compensate-from", Value: []byte(g.label())})}
+		return g.w.WriteMessages(ctx, msg, tombstone)
+	}
+
+	next := p.evt
+	next.Step = g.Step + 1
+	value, headers, err := EncodeEvent(ctx, &next, headers)
+	if err != nil {
+		return fmt.Errorf("claim check: %w", err)
+	}
+	msg := kafka.Message{Topic: RegionTopic(g.Out), Key: p.msg.Key, Value: value, Headers: headers}
+	if g.Lanes {
+		msg.Topic = LaneTopic(msg.Topic, p.Priority)
+	}
+	return g.w.WriteMessages(ctx, append(WithState(p.SagaID, msg), tombstone)...)
+}
+
+// Expire rejects sagas past their deadline, checking every second until
+// ctx is done.
+func (g *ApprovalGate) Expire(ctx context.Context) {
+	t := time.NewTicker(time.Second)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+		now := g.now()
+		for _, p := range g.Pending() {
+			if p.Deadline.After(now) {
+				break
+			}
+			if err := g.Decide(ctx, p.SagaID, "timed_out", "", "timeout"); err != nil && !errors.Is(err, ErrNotPending) {
+				log.Printf("[step%d] approval timeout of saga %s: %v", g.Step, p.SagaID, err)
+			}
+		}
+	}
+}
+
+// ServeHTTP serves /approvals and /approvals/{saga_id}/{approve,reject}.
+func (g *ApprovalGate) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rest := strings.Trim(strings.TrimPrefix(r.URL.Path, "/approvals"), "/")
+	if rest == "" {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(g.Pending())
+		return
+	}
+	sagaID, action, ok := strings.Cut(rest, "/")
+	decision := map[string]string{"approve": "approved", "reject": "rejected"}[action]
+	if !ok || sagaID == "" || decision == "" {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var body struct{ By, Reason string }
+	if b, _ := io.ReadAll(io.LimitReader(r.Body, 4096)); len(strings.TrimSpace(string(b))) > 0 {
+		if err := json.Unmarshal(b, &body); err != nil {
+			http.Error(w, "body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	switch err := g.Decide(r.Context(), sagaID, decision, body.By, body.Reason); {
+	case errors.Is(err, ErrNotPending):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusBadGateway)
+	default:
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]string{"saga_id": sagaID, "decision": decision})
+	}
+}
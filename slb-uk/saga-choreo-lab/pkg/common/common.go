@@ -27,6 +27,7 @@ type Event struct {
 	SchemaVersion int                    `json:"schema_version"`
 	Ts            time.Time              `json:"ts"`
 	Payload       map[string]any         `json:"payload"`
+	Priority      string                 `json:"priority,omitempty"` // high, normal (default) or low
 }
 
 var (
@@ -51,7 +52,10 @@ func init() {
 func ServeMetrics() {
 	go func() {
 		http.Handle("/metrics", promhttp.Handler())
-		log.Println("[metrics] listening on :8080/metrics")
+		http.Handle("/topology", TopologyHandler())
+		http.Handle("/fail-mode", FailModeHandler())
+		http.Handle("/region", RegionHandler())
+		log.Println("[metrics] listening on :8080/metrics (+ /topology, /fail-mode, /region)")
 		_ = http.ListenAndServe(":8080", nil)
 	}()
 }
@@ -117,7 +121,30 @@ func Process(step int, failMode string, evt *Event) (*Event, bool) {
 	return &next, false
 }
 
-// RunStepService runs a consumer->handler->producer loop with DLQ support.
+// Handle runs a step's logic on evt under the retry policy and records its
+// latency. Process signals a retryable failure by returning evt itself;
+// with RETRY_MAX set it is retried here and turned fatal, for the DLQ, once
+// the attempts are used up.
+func Handle(step int, retry RetryPolicy, evt *Event) (*Event, bool) {
+	stepStr := strconv.Itoa(step)
+	t0 := time.Now()
+	next, fatal := Process(step, CurrentFailMode(), evt)
+	for attempt, backoff := 0, retry.Backoff; retry.Max > 0 && !fatal && next == evt; attempt++ {
+		if attempt == retry.Max {
+			RetriesTotal.WithLabelValues(stepStr, "exhausted").Inc()
+			fatal = true
+			break
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+		next, fatal = Process(step, CurrentFailMode(), evt)
+	}
+	StepLatency.WithLabelValues(stepStr).Observe(time.Since(t0).Seconds())
+	return next, fatal
+}
+
+// RunStepService runs a consumer->handler->producer loop with DLQ support,
+// or an approval gate when STEP_TYPE=approval (see ApprovalGate).
 func RunStepService() error {
 	ServeMetrics()
 	shutdown := InitOTel()
@@ -129,28 +156,103 @@ func RunStepService() error {
 	dlqTopic := os.Getenv("DLQ_TOPIC")
 	group := os.Getenv("GROUP_ID")
 	stepStr := os.Getenv("STEP")
-	failMode := os.Getenv("FAIL_MODE")
+	if err := SetFailMode(os.Getenv("FAIL_MODE")); err != nil {
+		return err
+	}
+	retry, err := RetryFromEnv()
+	if err != nil {
+		return err
+	}
+	if err := RegionsFromEnv(); err != nil {
+		return err
+	}
 
 	if brokers == "" || topicIn == "" || topicOut == "" || group == "" || stepStr == "" || dlqTopic == "" {
 		return fmt.Errorf("missing required envs: KAFKA_BROKERS, TOPIC_IN, TOPIC_OUT, DLQ_TOPIC, GROUP_ID, STEP")
 	}
 	step, _ := strconv.Atoi(stepStr)
+	FollowFailover(brokers, "step"+stepStr)
 
-	reader := NewReader(brokers, topicIn, group)
+	lanes := LanesEnabled()
+	var weights map[string]int
+	if lanes {
+		if weights, err = ParseWeights(getenvDefault("PRIORITY_WEIGHTS", "high=6,normal=3,low=1")); err != nil {
+			return fmt.Errorf("PRIORITY_WEIGHTS: %w", err)
+		}
+		log.Printf("[step%d] priority lanes on, weights %v", step, weights)
+	}
+	// open returns read for TOPIC_IN in the active region, which yields the
+	// next message and its priority class. With lanes on it polls the
+	// high/normal/low topics by PRIORITY_WEIGHTS. It is opened again after
+	// a failover.
+	open := func() (func(context.Context) (kafka.Message, string, error), func() error) {
+		in := RegionTopic(topicIn)
+		if lanes {
+			lr := NewLaneReader(brokers, in, group, weights)
+			return lr.ReadMessage, lr.Close
+		}
+		reader := NewReader(brokers, in, group)
+		return func(ctx context.Context) (kafka.Message, string, error) {
+			m, err := reader.ReadMessage(ctx)
+			return m, MessagePriority(m), err
+		}, reader.Close
+	}
+	read, closeRead := open()
+	defer func() { closeRead() }()
+	regionCtx, regionDone := RegionContext(context.Background())
 	writer := NewWriter(brokers)
-	defer reader.Close()
+	comp := CompensationFromEnv(stepStr)
+	if comp.In != "" {
+		go comp.Run(context.Background(), brokers, group, writer)
+	}
+	contract, err := OutputContractFromEnv(step)
+	if err != nil {
+		return err
+	}
+	gate, err := ApprovalGateFromEnv(step, topicOut, comp, writer)
+	if err != nil {
+		return err
+	}
+	if gate != nil {
+		if err := gate.Restore(context.Background(), brokers); err != nil {
+			return fmt.Errorf("approval state: %w", err)
+		}
+		http.Handle("/approvals", gate)
+		http.Handle("/approvals/", gate)
+		go gate.Expire(context.Background())
+	}
 
 	tracer := otel.Tracer(fmt.Sprintf("saga-step-%d", step))
 
 	for {
-		m, err := reader.ReadMessage(context.Background())
+		m, prio, err := read(regionCtx)
 		if err != nil {
+			if regionCtx.Err() != nil {
+				regionDone()
+				closeRead()
+				read, closeRead = open()
+				regionCtx, regionDone = RegionContext(context.Background())
+				log.Printf("[step%d] failover: reading %s", step, RegionTopic(topicIn))
+				continue
+			}
 			log.Printf("[step%d] read error: %v", step, err)
 			continue
 		}
-		var evt Event
-		if err := json.Unmarshal(m.Value, &evt); err != nil {
-			log.Printf("[step%d] bad json: %v", step, err)
+		LaneWait.WithLabelValues(stepStr, prio).Observe(time.Since(m.Time).Seconds())
+		evt, err := DecodeEvent(context.Background(), m)
+		if err != nil {
+			log.Printf("[step%d] bad event: %v", step, err)
+			continue
+		}
+		SagaAge.WithLabelValues(stepStr, prio).Observe(time.Since(evt.Ts).Seconds())
+
+		if gate != nil {
+			// the saga is only safe once its state record is written
+			for err := gate.Hold(context.Background(), m, evt); err != nil; err = gate.Hold(context.Background(), m, evt) {
+				RetriesTotal.WithLabelValues(stepStr, "approval_state").Inc()
+				log.Printf("[step%d] approval state produce err: %v", step, err)
+				time.Sleep(time.Second)
+			}
 			continue
 		}
 
@@ -158,32 +260,53 @@ func RunStepService() error {
 			sdktrace.WithAttributes(
 				attribute.String("saga_id", evt.SagaID),
 				attribute.Int("step", step),
+				attribute.String("priority", prio),
 			),
 		)
-		t0 := time.Now()
-		next, fatal := Process(step, failMode, &evt)
-		StepLatency.WithLabelValues(strconv.Itoa(step)).Observe(time.Since(t0).Seconds())
+		next, fatal := Handle(step, retry, &evt)
 		span.End()
 
+		// an output the next step would choke on, or worse, misread, stops
+		// here instead of going downstream
+		if vs := contract.Check(&evt, next); len(vs) > 0 {
+			if err := writer.WriteMessages(ctx, WithState(evt.SagaID, contract.Reject(m, vs))...); err != nil {
+				log.Printf("[step%d] contract violation produce err: %v", step, err)
+			}
+			ContractViolationsTotal.WithLabelValues(stepStr).Inc()
+			log.Printf("[step%d] saga %s breaks the output contract: %v", step, evt.SagaID, vs)
+			continue
+		}
+
+		value, headers, err := EncodeEvent(ctx, next, m.Headers)
+		if err != nil {
+			RetriesTotal.WithLabelValues(strconv.Itoa(step), "claim_check").Inc()
+			log.Printf("[step%d] claim check: %v", step, err)
+			time.Sleep(time.Second)
+			continue
+		}
 		msg := kafka.Message{
 			Key:   m.Key, // preserve per-saga ordering
-			Value: MustJSON(next),
-			Headers: append(m.Headers, kafka.Header{Key: "x-saga-id", Value: []byte(evt.SagaID)}),
+			Value: value,
+			Headers: append(headers, kafka.Header{Key: "x-saga-id", Value: []byte(evt.SagaID)}),
 		}
 
 		if fatal {
 			// Send to DLQ; remember original topic for replay
-			msg.Topic = dlqTopic
-			msg.Headers = append(msg.Headers, kafka.Header{Key: "x-original-topic", Value: []byte(topicIn)})
-			if err := writer.WriteMessages(context.Background(), msg); err != nil {
+			msg.Topic = RegionTopic(dlqTopic)
+			msg.Headers = append(msg.Headers, kafka.Header{Key: "x-original-topic", Value: []byte(m.Topic)})
+			if err := writer.WriteMessages(context.Background(), WithState(evt.SagaID, msg)...); err != nil {
 				log.Printf("[step%d] dlq produce err: %v", step, err)
 			}
-			DLQTotal.WithLabelValues(dlqTopic).Inc()
+			DLQTotal.WithLabelValues(msg.Topic).Inc()
+			comp.Trigger(ctx, writer, msg, step)
 			continue
 		}
 
-		msg.Topic = topicOut
-		if err := writer.WriteMessages(ctx, msg); err != nil {
+		msg.Topic = RegionTopic(topicOut)
+		if lanes {
+			msg.Topic = LaneTopic(msg.Topic, prio)
+		}
+		if err := writer.WriteMessages(ctx, WithState(evt.SagaID, msg)...); err != nil {
 			RetriesTotal.WithLabelValues(strconv.Itoa(step), "produce_error").Inc()
 			log.Printf("[step%d] produce err: %v", step, err)
 			time.Sleep(time.Second)
@@ -206,15 +329,42 @@ func RunEmitter() error {
 	if brokers == "" || topic == "" {
 		return fmt.Errorf("missing envs: KAFKA_BROKERS, TOPIC_OUT")
 	}
+	if err := RegionsFromEnv(); err != nil {
+		return err
+	}
+	FollowFailover(brokers, "emitter")
+	padding := 0 // EMIT_PAYLOAD_BYTES pads payloads, e.g. to exercise the claim check
+	if v := os.Getenv("EMIT_PAYLOAD_BYTES"); v != "" {
+		padding, _ = strconv.Atoi(v)
+	}
+	// EMIT_PRIORITY_MIX gives the odds of each class, e.g. "high=1,normal=6,low=3";
+	// with PRIORITY_LANES=true each saga starts on its class's lane.
+	mix, err := ParseWeights(getenvDefault("EMIT_PRIORITY_MIX", "normal=1"))
+	if err != nil {
+		return fmt.Errorf("EMIT_PRIORITY_MIX: %w", err)
+	}
+	lanes := LanesEnabled()
 	writer := NewWriter(brokers)
 
 	ticker := time.NewTicker(time.Duration(rateMs) * time.Millisecond)
 	defer ticker.Stop()
 	for range ticker.C {
 		sagaID := fmt.Sprintf("%d-%d", time.Now().UnixNano(), rand.Intn(100000))
-		evt := Event{SagaID: sagaID, Step: 1, SchemaVersion: 1, Ts: time.Now(), Payload: map[string]any{"demo":"start"}}
-		msg := kafka.Message{Topic: topic, Key: []byte(sagaID), Value: MustJSON(evt), Headers: []kafka.Header{{Key:"x-saga-id", Value: []byte(sagaID)}}}
-		if err := writer.WriteMessages(context.Background(), msg); err != nil {
+		prio := PickPriority(mix)
+		evt := Event{SagaID: sagaID, Step: 1, SchemaVersion: 1, Ts: time.Now(), Payload: map[string]any{"demo":"start"}, Priority: prio}
+		if padding > 0 {
+			evt.Payload["blob"] = strings.Repeat("x", padding)
+		}
+		value, headers, err := EncodeEvent(context.Background(), &evt, []kafka.Header{{Key:"x-saga-id", Value: []byte(sagaID)}, {Key: PriorityHeader, Value: []byte(prio)}})
+		if err != nil {
+			log.Printf("[emitter] claim check: %v", err)
+			continue
+		}
+		msg := kafka.Message{Topic: RegionTopic(topic), Key: []byte(sagaID), Value: value, Headers: headers}
+		if lanes {
+			msg.Topic = LaneTopic(msg.Topic, prio)
+		}
+		if err := writer.WriteMessages(context.Background(), WithState(sagaID, msg)...); err != nil {
 			log.Printf("[emitter] produce err: %v", err)
 		}
 	}
@@ -236,12 +386,25 @@ func RunDLQReplayer() error {
 	if brokers == "" || dlqTopic == "" || group == "" {
 		return fmt.Errorf("missing envs: KAFKA_BROKERS, DLQ_TOPIC, GROUP_ID")
 	}
-	reader := NewReader(brokers, dlqTopic, group)
+	if err := RegionsFromEnv(); err != nil {
+		return err
+	}
+	FollowFailover(brokers, "dlq")
+	reader := NewReader(brokers, RegionTopic(dlqTopic), group)
 	writer := NewWriter(brokers)
-	defer reader.Close()
+	defer func() { reader.Close() }()
+	regionCtx, regionDone := RegionContext(context.Background())
 
 	for {
-		m, err := reader.ReadMessage(context.Background())
+		m, err := reader.ReadMessage(regionCtx)
+		if err != nil && regionCtx.Err() != nil {
+			regionDone()
+			reader.Close()
+			reader = NewReader(brokers, RegionTopic(dlqTopic), group)
+			regionCtx, regionDone = RegionContext(context.Background())
+			log.Printf("[dlq] failover: reading %s", RegionTopic(dlqTopic))
+			continue
+		}
 		if err != nil { log.Printf("[dlq] read err: %v", err); continue }
 		var evt Event
 		if err := json.Unmarshal(m.Value, &evt); err != nil { log.Printf("[dlq] bad json: %v", err); continue }
@@ -253,9 +416,10 @@ func RunDLQReplayer() error {
 			if h.Key == "x-original-topic" { orig = string(h.Value) }
 		}
 		if orig == "" { log.Printf("[dlq] no replay target for saga %s", evt.SagaID); continue }
+		orig = RegionTopic(BaseTopic(orig)) // back into the active region
 
 		msg := kafka.Message{Topic: orig, Key: m.Key, Value: m.Value, Headers: m.Headers}
-		if err := writer.WriteMessages(context.Background(), msg); err != nil {
+		if err := writer.WriteMessages(context.Background(), WithState(evt.SagaID, msg)...); err != nil {
 			log.Printf("[dlq] produce err: %v", err)
 		} else {
 			log.Printf("[dlq] replayed saga=%s to %s", evt.SagaID, orig)
@@ -0,0 +1,114 @@
+package common
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/segmentio/kafka-go"
+)
+
+// CompensationsTotal counts compensations a step ran for a saga that failed
+// further down the pipeline.
+var CompensationsTotal = prometheus.NewCounterVec(
+	prometheus.CounterOpts{Name: "saga_compensations_total", Help: "compensations run by step"},
+	[]string{"step"},
+)
+
+func init() { prometheus.MustRegister(CompensationsTotal) }
+
+// RetryPolicy is RETRY_MAX / RETRY_BACKOFF. Max 0 keeps the old behaviour:
+// a retryable failure is passed on without being retried in place.
+type RetryPolicy struct {
+	Max     int
+	Backoff time.Duration
+}
+
+// RetryFromEnv reads RETRY_MAX (default 0) and RETRY_BACKOFF (default 200ms,
+// doubled after every attempt).
+func RetryFromEnv() (RetryPolicy, error) {
+	p := RetryPolicy{Backoff: 200 * time.Millisecond}
+	if v := os.Getenv("RETRY_MAX"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return p, fmt.Errorf("invalid RETRY_MAX %q", v)
+		}
+		p.Max = n
+	}
+	if v := os.Getenv("RETRY_BACKOFF"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			return p, fmt.Errorf("invalid RETRY_BACKOFF %q", v)
+		}
+		p.Backoff = d
+	}
+	return p, nil
+}
+
+// Compensation wires a step into the compensation chain sagagen generates:
+// In is the step's own compensation topic, Out the nearest earlier step's.
+// A step that fails for good starts the chain on Out; every step listening
+// on In undoes its work and passes the saga on to Out.
+type Compensation struct {
+	Step    string
+	In, Out string
+}
+
+// CompensationFromEnv reads COMPENSATE_TOPIC_IN and COMPENSATE_TOPIC_OUT;
+// both are optional.
+func CompensationFromEnv(step string) Compensation {
+	return Compensation{Step: step, In: os.Getenv("COMPENSATE_TOPIC_IN"), Out: os.Getenv("COMPENSATE_TOPIC_OUT")}
+}
+
+// Trigger starts compensation for a dead-lettered message.
+func (c Compensation) Trigger(ctx context.Context, w *kafka.Writer, msg kafka.Message, step int) {
+	if c.Out == "" {
+		return
+	}
+	msg.Topic = RegionTopic(c.Out)
+	msg.Headers = append(msg.Headers, kafka.Header{Key: "x-compensate-from", Value: []byte(strconv.Itoa(step))})
+	if err := w.WriteMessages(ctx, msg); err != nil {
+		log.Printf("[step%s] compensate produce err: %v", c.Step, err)
+	}
+}
+
+// Run consumes In until ctx is done. The lab steps have no side effects to
+// undo, so compensating is logging and counting before passing the saga on.
+func (c Compensation) Run(ctx context.Context, brokers, group string, w *kafka.Writer) {
+	for ctx.Err() == nil {
+		rctx, cancel := RegionContext(ctx)
+		c.run(rctx, brokers, group, w)
+		cancel()
+	}
+}
+
+// run consumes In in the active region until ctx, which ends on failover,
+// is done.
+func (c Compensation) run(ctx context.Context, brokers, group string, w *kafka.Writer) {
+	r := NewReader(brokers, RegionTopic(c.In), group+".compensate")
+	defer r.Close()
+	for {
+		m, err := r.ReadMessage(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Printf("[step%s] compensate read error: %v", c.Step, err)
+			continue
+		}
+		log.Printf("[step%s] compensating saga %s (failed at step %s)", c.Step,
+			headerValue(m.Headers, "x-saga-id"), headerValue(m.Headers, "x-compensate-from"))
+		CompensationsTotal.WithLabelValues(c.Step).Inc()
+		if c.Out == "" {
+			continue
+		}
+		out := kafka.Message{Topic: RegionTopic(c.Out), Key: m.Key, Value: m.Value, Headers: m.Headers}
+		if err := w.WriteMessages(ctx, out); err != nil {
+			log.Printf("[step%s] compensate produce err: %v", c.Step, err)
+		}
+	}
+}
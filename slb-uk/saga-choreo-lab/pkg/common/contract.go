@@ -0,0 +1,75 @@
+package common
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/segmentio/kafka-go"
+
+	"example.com/saga-choreo-lab/pkg/stepcontract"
+)
+
+// ContractViolationsTotal counts step outputs that broke their contract and
+// went to CONTRACT_TOPIC instead of the next step.
+var ContractViolationsTotal = prometheus.NewCounterVec(
+	prometheus.CounterOpts{Name: "saga_contract_violations_total", Help: "step outputs rejected by their output schema, by step"},
+	[]string{"step"},
+)
+
+func init() { prometheus.MustRegister(ContractViolationsTotal) }
+
+// OutputContract checks what a step produces against the schema embedded
+// for its step index (pkg/stepcontract). Topic is CONTRACT_TOPIC, where an
+// output that breaks it goes instead of TOPIC_OUT.
+type OutputContract struct {
+	Topic    string
+	contract *stepcontract.Contract
+}
+
+// OutputContractFromEnv reads CONTRACT_TOPIC. Without it, or without a
+// schema for step, Check accepts everything.
+func OutputContractFromEnv(step int) (OutputContract, error) {
+	c := OutputContract{Topic: os.Getenv("CONTRACT_TOPIC")}
+	if c.Topic == "" {
+		return c, nil
+	}
+	sc, err := stepcontract.For(step)
+	if err != nil {
+		return c, fmt.Errorf("output contract: %w", err)
+	}
+	if sc == nil {
+		log.Printf("[step%d] no output contract embedded; outputs are not checked", step)
+		return c, nil
+	}
+	c.contract = sc
+	return c, nil
+}
+
+// Check returns how next, the output of a step for in, breaks the
+// contract; nil when it keeps it. A retryable failure passed on as it came
+// (next is in) is the previous step's output and is not checked.
+func (c OutputContract) Check(in, next *Event) []stepcontract.Violation {
+	if next == in {
+		return nil
+	}
+	return c.contract.Check(MustJSON(next))
+}
+
+// Reject returns the record for CONTRACT_TOPIC when the output for in, the
+// record the step consumed, breaks the contract. It is in itself, with
+// x-original-topic set like a dead letter, so the DLQ replayer can send it
+// through the step again once the step is fixed. The schema and the
+// violations go in headers, the violations as a JSON list:
+//
+//	x-contract-violations: [{"path":"/payload","rule":"/properties/payload/required","message":"missing properties: 'demo'","want":["demo"]}]
+func (c OutputContract) Reject(in kafka.Message, vs []stepcontract.Violation) kafka.Message {
+	headers := append(append([]kafka.Header{}, in.Headers...),
+		kafka.Header{Key: "x-original-topic", Value: []byte(in.Topic)},
+		kafka.Header{Key: "x-contract-schema", Value: []byte(c.contract.Schema)},
+		kafka.Header{Key: "x-contract-step", Value: []byte(strconv.Itoa(c.contract.Step))},
+		kafka.Header{Key: "x-contract-violations", Value: MustJSON(vs)})
+	return kafka.Message{Topic: RegionTopic(c.Topic), Key: in.Key, Value: in.Value, Headers: headers}
+}
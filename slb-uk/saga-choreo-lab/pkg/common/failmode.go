@@ -0,0 +1,61 @@
+package common
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// failMode holds the FAIL_MODE a step service is currently running with. It
+// starts from the env and can be switched at runtime through /fail-mode, so
+// load scenarios (cmd/sagaload) don't need a pod restart per phase.
+var failMode atomic.Value
+
+func init() { failMode.Store("none") }
+
+// CurrentFailMode returns the active FAIL_MODE.
+func CurrentFailMode() string { return failMode.Load().(string) }
+
+// SetFailMode validates and switches FAIL_MODE. Accepted values are none
+// (or empty), retryable, fatal and flaky:<p> with 0 <= p <= 1.
+func SetFailMode(mode string) error {
+	mode = strings.TrimSpace(mode)
+	switch {
+	case mode == "" || mode == "none":
+		mode = "none"
+	case mode == "retryable" || mode == "fatal":
+	case strings.HasPrefix(mode, "flaky:"):
+		p, err := strconv.ParseFloat(strings.TrimPrefix(mode, "flaky:"), 64)
+		if err != nil || p < 0 || p > 1 {
+			return fmt.Errorf("invalid FAIL_MODE %q: flaky probability must be in [0,1]", mode)
+		}
+	default:
+		return fmt.Errorf("invalid FAIL_MODE %q (none, retryable, fatal, flaky:<p>)", mode)
+	}
+	failMode.Store(mode)
+	return nil
+}
+
+// FailModeHandler serves GET /fail-mode and PUT /fail-mode (plain text body).
+func FailModeHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPut, http.MethodPost:
+			b, _ := io.ReadAll(io.LimitReader(r.Body, 64))
+			if err := SetFailMode(string(b)); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			log.Printf("[fail-mode] set to %s by %s", CurrentFailMode(), r.RemoteAddr)
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		_, _ = fmt.Fprintln(w, CurrentFailMode())
+	})
+}
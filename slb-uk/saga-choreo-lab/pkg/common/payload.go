@@ -0,0 +1,95 @@
+package common
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+
+	"github.com/segmentio/kafka-go"
+
+	"example.com/saga-choreo-lab/pkg/claimcheck"
+)
+
+// claims is set when CLAIM_CHECK_DIR is configured. Payloads bigger than
+// CLAIM_CHECK_THRESHOLD_BYTES are then written there and the event travels
+// with an empty payload and an x-claim-check header.
+var claims = initClaimCheck()
+
+func initClaimCheck() *claimcheck.Checker {
+	dir := os.Getenv("CLAIM_CHECK_DIR")
+	if dir == "" {
+		return nil
+	}
+	threshold := 256 << 10
+	if v, err := strconv.Atoi(os.Getenv("CLAIM_CHECK_THRESHOLD_BYTES")); err == nil && v > 0 {
+		threshold = v
+	}
+	store, err := claimcheck.NewFS(dir)
+	if err != nil {
+		log.Printf("[claimcheck] disabled: %v", err)
+		return nil
+	}
+	return claimcheck.New(store, threshold)
+}
+
+// EncodeEvent marshals evt for Kafka, offloading a large payload. The
+// returned headers replace any claim-check header of the incoming message.
+func EncodeEvent(ctx context.Context, evt *Event, headers []kafka.Header) ([]byte, []kafka.Header, error) {
+	headers = withoutHeader(headers, claimcheck.Header)
+	if claims == nil || evt.Payload == nil {
+		return MustJSON(evt), headers, nil
+	}
+	payload, err := json.Marshal(evt.Payload)
+	if err != nil {
+		return nil, nil, err
+	}
+	ref, err := claims.Offload(ctx, payload)
+	if err != nil || ref == "" {
+		return MustJSON(evt), headers, err
+	}
+	slim := *evt
+	slim.Payload = nil
+	return MustJSON(&slim), append(headers, kafka.Header{Key: claimcheck.Header, Value: []byte(ref)}), nil
+}
+
+// DecodeEvent unmarshals a message and rehydrates an offloaded payload.
+func DecodeEvent(ctx context.Context, m kafka.Message) (Event, error) {
+	var evt Event
+	if err := json.Unmarshal(m.Value, &evt); err != nil {
+		return evt, err
+	}
+	ref := headerValue(m.Headers, claimcheck.Header)
+	if ref == "" {
+		return evt, nil
+	}
+	if claims == nil {
+		return evt, fmt.Errorf("payload offloaded to %s but CLAIM_CHECK_DIR is not set", ref)
+	}
+	b, err := claims.Fetch(ctx, ref)
+	if err != nil {
+		return evt, err
+	}
+	return evt, json.Unmarshal(b, &evt.Payload)
+}
+
+func headerValue(hs []kafka.Header, key string) string {
+	for _, h := range hs {
+		if h.Key == key {
+			return string(h.Value)
+		}
+	}
+	return ""
+}
+
+func withoutHeader(hs []kafka.Header, key string) []kafka.Header {
+	out := make([]kafka.Header, 0, len(hs))
+	for _, h := range hs {
+		if h.Key != key {
+			out = append(out, h)
+		}
+	}
+	return out
+}
@@ -0,0 +1,244 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/segmentio/kafka-go"
+)
+
+// Priority classes. With PRIORITY_LANES=true every topic gets a lane per
+// class: normal keeps the base name, high and low get a ".high"/".low"
+// suffix, so existing consumers of the base topic keep working.
+const (
+	PriorityHigh   = "high"
+	PriorityNormal = "normal"
+	PriorityLow    = "low"
+
+	PriorityHeader = "x-saga-priority"
+)
+
+var Priorities = []string{PriorityHigh, PriorityNormal, PriorityLow}
+
+var (
+	LaneWait = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{Name: "saga_lane_wait_seconds", Help: "time a message sat in its lane before the step picked it up", Buckets: []float64{.01, .05, .1, .5, 1, 5, 15, 60, 300}},
+		[]string{"step", "priority"},
+	)
+	SagaAge = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{Name: "saga_age_seconds", Help: "time since the saga was emitted, observed at each step", Buckets: []float64{.05, .1, .5, 1, 5, 15, 60, 300, 900}},
+		[]string{"step", "priority"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(LaneWait, SagaAge)
+}
+
+// LanesEnabled reports whether PRIORITY_LANES is on.
+func LanesEnabled() bool { return os.Getenv("PRIORITY_LANES") == "true" }
+
+// NormalizePriority maps anything unknown (including "") to normal.
+func NormalizePriority(p string) string {
+	switch p = strings.ToLower(strings.TrimSpace(p)); p {
+	case PriorityHigh, PriorityLow:
+		return p
+	}
+	return PriorityNormal
+}
+
+// LaneTopic is the topic carrying prio's sagas for base.
+func LaneTopic(base, prio string) string {
+	if prio = NormalizePriority(prio); prio == PriorityNormal {
+		return base
+	}
+	return base + "." + prio
+}
+
+// ParseWeights reads "high=6,normal=3,low=1". Classes left out get 0: the
+// emitter never picks them, and a step still drains them at weight 1.
+func ParseWeights(s string) (map[string]int, error) {
+	w := map[string]int{}
+	for _, kv := range strings.Split(s, ",") {
+		if kv = strings.TrimSpace(kv); kv == "" {
+			continue
+		}
+		k, v, ok := strings.Cut(kv, "=")
+		n, err := strconv.Atoi(strings.TrimSpace(v))
+		if !ok || err != nil || n < 0 {
+			return nil, fmt.Errorf("bad weight %q (want class=n)", kv)
+		}
+		if p := strings.TrimSpace(k); NormalizePriority(p) != p {
+			return nil, fmt.Errorf("unknown priority %q", p)
+		}
+		w[strings.TrimSpace(k)] = n
+	}
+	return w, nil
+}
+
+// PickPriority draws a class for a new saga using weights as odds.
+func PickPriority(weights map[string]int) string {
+	total := 0
+	for _, p := range Priorities {
+		total += weights[p]
+	}
+	if total == 0 {
+		return PriorityNormal
+	}
+	n := rand.Intn(total)
+	for _, p := range Priorities {
+		if n -= weights[p]; n < 0 {
+			return p
+		}
+	}
+	return PriorityNormal
+}
+
+// MessagePriority returns the x-saga-priority header, defaulting to normal.
+func MessagePriority(m kafka.Message) string {
+	return NormalizePriority(headerValue(m.Headers, PriorityHeader))
+}
+
+type lane struct {
+	prio    string
+	weight  int
+	current int
+	reader  *kafka.Reader
+	ch      chan kafka.Message
+	pending *kafka.Message
+}
+
+// LaneReader consumes every lane of a topic with one reader each and hands
+// messages out by smooth weighted round-robin over the lanes that have one
+// ready. It is work-conserving: an empty high lane does not hold back normal
+// or low, but while all three are backlogged high gets weight/total of the
+// step's throughput instead of waiting behind the whole backlog.
+type LaneReader struct {
+	lanes []*lane
+	errs  chan error
+}
+
+// NewLaneReader starts readers for topic's lanes; each lane has its own
+// consumer group (group + "." + class) so their offsets are independent.
+func NewLaneReader(brokers, topic, group string, weights map[string]int) *LaneReader {
+	lr := &LaneReader{errs: make(chan error, 1)}
+	for _, p := range Priorities {
+		w := weights[p]
+		if w == 0 {
+			w = 1
+		} // still drained, just last
+		l := &lane{prio: p, weight: w, reader: NewReader(brokers, LaneTopic(topic, p), group+"."+p), ch: make(chan kafka.Message)}
+		lr.lanes = append(lr.lanes, l)
+		go lr.fetch(l)
+	}
+	return lr
+}
+
+func (lr *LaneReader) fetch(l *lane) {
+	for {
+		m, err := l.reader.FetchMessage(context.Background())
+		if errors.Is(err, io.EOF) {
+			return // reader closed
+		}
+		if err != nil {
+			select {
+			case lr.errs <- fmt.Errorf("lane %s: %w", l.prio, err):
+			default:
+			}
+			time.Sleep(time.Second)
+			continue
+		}
+		l.ch <- m
+	}
+}
+
+// ReadMessage returns the next message and its lane, committing it like
+// kafka.Reader.ReadMessage does.
+func (lr *LaneReader) ReadMessage(ctx context.Context) (kafka.Message, string, error) {
+	for {
+		ready := 0
+		for _, l := range lr.lanes {
+			if l.pending == nil {
+				select {
+				case m := <-l.ch:
+					l.pending = &m
+				default:
+				}
+			}
+			if l.pending != nil {
+				ready++
+			}
+		}
+		if ready == 0 {
+			if err := lr.wait(ctx); err != nil {
+				return kafka.Message{}, "", err
+			}
+			continue
+		}
+
+		var best *lane
+		total := 0
+		for _, l := range lr.lanes {
+			if l.pending == nil {
+				continue
+			}
+			l.current += l.weight
+			total += l.weight
+			if best == nil || l.current > best.current {
+				best = l
+			}
+		}
+		best.current -= total
+		m := *best.pending
+		best.pending = nil
+		if err := best.reader.CommitMessages(ctx, m); err != nil {
+			log.Printf("[lanes] commit %s: %v", best.prio, err)
+		}
+		return m, best.prio, nil
+	}
+}
+
+// wait blocks until some lane has a message, ctx ends or a fetch fails.
+func (lr *LaneReader) wait(ctx context.Context) error {
+	cases := []reflect.SelectCase{
+		{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(ctx.Done())},
+		{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(lr.errs)},
+	}
+	for _, l := range lr.lanes {
+		cases = append(cases, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(l.ch)})
+	}
+	i, v, _ := reflect.Select(cases)
+	switch i {
+	case 0:
+		return ctx.Err()
+	case 1:
+		return v.Interface().(error)
+	}
+	m := v.Interface().(kafka.Message)
+	lr.lanes[i-2].pending = &m
+	return nil
+}
+
+func (lr *LaneReader) Close() error {
+	for _, l := range lr.lanes {
+		_ = l.reader.Close()
+	}
+	return nil
+}
+
+func getenvDefault(k, d string) string {
+	if v := os.Getenv(k); v != "" {
+		return v
+	}
+	return d
+}
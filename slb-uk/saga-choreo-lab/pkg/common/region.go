@@ -0,0 +1,263 @@
+package common
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/segmentio/kafka-go"
+)
+
+// Multi-region mode. With REGIONS="eu,us" every saga topic is prefixed with
+// the active region: the emitter writes eu.saga.step1, step1 reads it and
+// writes eu.saga.step1.completed, and so on. The first region listed is
+// active at startup unless REGION_ACTIVE names another.
+//
+// The active region is switched for the whole pipeline at once by
+// cmd/failover, which publishes a FailoverCommand to FAILOVER_TOPIC
+// (default saga.failover, never prefixed). Every service follows that
+// topic from the beginning, so a restarted pod comes up in the region of
+// the last failover rather than the one in its env, and moves its readers
+// and writers to the new prefix when a command arrives.
+const DefaultFailoverTopic = "saga.failover"
+
+// FailoverCommand is a record on the failover topic.
+type FailoverCommand struct {
+	Region string    `json:"region"`
+	From   string    `json:"from,omitempty"`
+	At     time.Time `json:"at"`
+}
+
+var ActiveRegionGauge = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{Name: "saga_active_region", Help: "1 for the region this service currently reads and writes"},
+	[]string{"region"},
+)
+
+func init() { prometheus.MustRegister(ActiveRegionGauge) }
+
+var regions = struct {
+	sync.Mutex
+	names   []string
+	active  string
+	changed chan struct{} // closed and replaced on every switch
+}{changed: make(chan struct{})}
+
+// RegionsFromEnv reads REGIONS and REGION_ACTIVE. Without REGIONS topics
+// are used unprefixed and failover is off.
+func RegionsFromEnv() error {
+	var names []string
+	for _, r := range strings.Split(os.Getenv("REGIONS"), ",") {
+		if r = strings.TrimSpace(r); r != "" {
+			names = append(names, r)
+		}
+	}
+	if len(names) == 0 {
+		return nil
+	}
+	active := getenvDefault("REGION_ACTIVE", names[0])
+	if !contains(names, active) {
+		return fmt.Errorf("REGION_ACTIVE %q is not one of REGIONS %v", active, names)
+	}
+	regions.Lock()
+	regions.names, regions.active = names, active
+	regions.Unlock()
+	ActiveRegionGauge.Reset()
+	ActiveRegionGauge.WithLabelValues(active).Set(1)
+	return nil
+}
+
+// Regions returns the configured regions, nil when multi-region is off.
+func Regions() []string {
+	regions.Lock()
+	defer regions.Unlock()
+	return regions.names
+}
+
+// ActiveRegion returns the region in use, "" when multi-region is off.
+func ActiveRegion() string {
+	regions.Lock()
+	defer regions.Unlock()
+	return regions.active
+}
+
+// RegionTopic is base in the active region.
+func RegionTopic(base string) string {
+	return RegionTopicIn(ActiveRegion(), base)
+}
+
+// RegionTopicIn is base in region; region "" leaves it as is.
+func RegionTopicIn(region, base string) string {
+	if region == "" || base == "" {
+		return base
+	}
+	return region + "." + base
+}
+
+// BaseTopic strips a configured region prefix from topic.
+func BaseTopic(topic string) string {
+	_, base := splitRegion(topic)
+	return base
+}
+
+// TopicRegion is the region prefix of topic, "" when it has none.
+func TopicRegion(topic string) string {
+	region, _ := splitRegion(topic)
+	return region
+}
+
+func splitRegion(topic string) (region, base string) {
+	for _, r := range Regions() {
+		if t, ok := strings.CutPrefix(topic, r+"."); ok {
+			return r, t
+		}
+	}
+	return "", topic
+}
+
+// SwitchRegion makes to the active region. It reports whether anything
+// changed; every RegionContext taken before a change is cancelled.
+func SwitchRegion(to string) (bool, error) {
+	regions.Lock()
+	defer regions.Unlock()
+	if !contains(regions.names, to) {
+		return false, fmt.Errorf("region %q is not one of REGIONS %v", to, regions.names)
+	}
+	if to == regions.active {
+		return false, nil
+	}
+	regions.active = to
+	close(regions.changed)
+	regions.changed = make(chan struct{})
+	ActiveRegionGauge.Reset()
+	ActiveRegionGauge.WithLabelValues(to).Set(1)
+	return true, nil
+}
+
+// RegionContext returns a context that is cancelled when parent is or when
+// the active region changes. Readers bound to a region's topics read with
+// it and are reopened once it ends.
+func RegionContext(parent context.Context) (context.Context, context.CancelFunc) {
+	regions.Lock()
+	changed := regions.changed
+	regions.Unlock()
+	ctx, cancel := context.WithCancel(parent)
+	go func() {
+		select {
+		case <-changed:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+// FailoverTopic is FAILOVER_TOPIC or saga.failover.
+func FailoverTopic() string { return getenvDefault("FAILOVER_TOPIC", DefaultFailoverTopic) }
+
+// FollowFailover applies the failover topic's history before returning, so
+// the caller starts in the current region, and then keeps following it in
+// the background. It does nothing when multi-region is off.
+func FollowFailover(brokers, name string) {
+	if len(Regions()) == 0 {
+		return
+	}
+	topic := FailoverTopic()
+	r := kafka.NewReader(kafka.ReaderConfig{
+		Brokers:  strings.Split(brokers, ","),
+		Topic:    topic,
+		MinBytes: 1,
+		MaxBytes: 1e6,
+	})
+	apply := func(m kafka.Message) {
+		var cmd FailoverCommand
+		if err := json.Unmarshal(m.Value, &cmd); err != nil {
+			log.Printf("[%s] bad failover command at offset %d: %v", name, m.Offset, err)
+			return
+		}
+		switched, err := SwitchRegion(cmd.Region)
+		if err != nil {
+			log.Printf("[%s] failover: %v", name, err)
+		} else if switched {
+			log.Printf("[%s] failover: now in region %s", name, cmd.Region)
+		}
+	}
+
+	// Catch up with the history; a missing topic means no failover yet.
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	if last, err := partitionEnd(ctx, strings.Split(brokers, ",")[0], topic, 0); err != nil {
+		log.Printf("[%s] failover topic %s: %v; starting in region %s", name, topic, err, ActiveRegion())
+	} else {
+		for next := int64(0); next < last; {
+			m, err := r.ReadMessage(ctx)
+			if err != nil {
+				log.Printf("[%s] failover catch-up: %v", name, err)
+				break
+			}
+			apply(m)
+			next = m.Offset + 1
+		}
+	}
+	cancel()
+	log.Printf("[%s] region %s of %v", name, ActiveRegion(), Regions())
+
+	go func() {
+		defer r.Close()
+		for {
+			m, err := r.ReadMessage(context.Background())
+			if err != nil {
+				log.Printf("[%s] failover read: %v", name, err)
+				time.Sleep(time.Second)
+				continue
+			}
+			apply(m)
+		}
+	}()
+}
+
+// NewFailoverWriter writes failover commands. They all go to partition 0,
+// the one FollowFailover reads, so they stay in order whatever the topic's
+// partition count.
+func NewFailoverWriter(brokers string) *kafka.Writer {
+	return &kafka.Writer{Addr: kafka.TCP(strings.Split(brokers, ",")...), Topic: FailoverTopic(),
+		Balancer: firstPartition{}, AllowAutoTopicCreation: true}
+}
+
+type firstPartition struct{}
+
+func (firstPartition) Balance(_ kafka.Message, partitions ...int) int {
+	first := partitions[0]
+	for _, p := range partitions {
+		if p < first {
+			first = p
+		}
+	}
+	return first
+}
+
+// RegionHandler serves GET /region.
+func RegionHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{"active": ActiveRegion(), "regions": Regions()})
+	})
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
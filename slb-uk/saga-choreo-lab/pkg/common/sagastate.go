@@ -0,0 +1,101 @@
+package common
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// The saga store is a compacted topic (SAGA_STATE_TOPIC, e.g. saga.state,
+// never region-prefixed) keyed by saga ID. Whenever the emitter or a step
+// hands a saga on, it writes the record it produced there too, so the last
+// record per key is where the saga is waiting now. cmd/failover reads it to
+// find the sagas that were in flight in the failed region and writes their
+// records again in the new one.
+//
+// The state record goes out in the same WriteMessages call as the saga's
+// own record but not atomically with it. A saga can therefore be replayed
+// one step back, which the steps must tolerate like any other redelivery.
+var stateTopic = os.Getenv("SAGA_STATE_TOPIC")
+
+// SagaState is one record of the saga store.
+type SagaState struct {
+	SagaID string `json:"saga_id"`
+	// Topic is where the saga's last record went, without region prefix.
+	Topic   string         `json:"topic"`
+	Region  string         `json:"region,omitempty"`
+	Key     []byte         `json:"key"`
+	Value   []byte         `json:"value"`
+	Headers []kafka.Header `json:"headers,omitempty"`
+	Ts      time.Time      `json:"ts"`
+}
+
+// WithState returns msg, followed by its saga store record when
+// SAGA_STATE_TOPIC is set.
+func WithState(sagaID string, msg kafka.Message) []kafka.Message {
+	if stateTopic == "" {
+		return []kafka.Message{msg}
+	}
+	st := SagaState{SagaID: sagaID, Topic: BaseTopic(msg.Topic), Region: TopicRegion(msg.Topic),
+		Key: msg.Key, Value: msg.Value, Headers: msg.Headers, Ts: time.Now()}
+	return []kafka.Message{msg, {Topic: stateTopic, Key: []byte(sagaID), Value: MustJSON(st)}}
+}
+
+// ReadSagaStates reads topic from the start of every partition up to its
+// current end and returns the latest state per saga.
+func ReadSagaStates(ctx context.Context, brokers, topic string) (map[string]SagaState, error) {
+	addrs := strings.Split(brokers, ",")
+	conn, err := kafka.DialContext(ctx, "tcp", addrs[0])
+	if err != nil {
+		return nil, err
+	}
+	parts, err := conn.ReadPartitions(topic)
+	conn.Close()
+	if err != nil {
+		return nil, fmt.Errorf("partitions of %s: %w", topic, err)
+	}
+
+	states := map[string]SagaState{}
+	for _, p := range parts {
+		last, err := partitionEnd(ctx, addrs[0], topic, p.ID)
+		if err != nil {
+			return nil, err
+		}
+		if last == 0 {
+			continue
+		}
+		r := kafka.NewReader(kafka.ReaderConfig{Brokers: addrs, Topic: topic, Partition: p.ID, MinBytes: 1, MaxBytes: 10e6})
+		for {
+			m, err := r.ReadMessage(ctx)
+			if err != nil {
+				r.Close()
+				return nil, fmt.Errorf("read %s/%d: %w", topic, p.ID, err)
+			}
+			var st SagaState
+			if err := json.Unmarshal(m.Value, &st); err == nil && st.SagaID != "" {
+				states[st.SagaID] = st
+			} else if m.Value == nil {
+				delete(states, string(m.Key)) // tombstone
+			}
+			if m.Offset+1 >= last {
+				break
+			}
+		}
+		r.Close()
+	}
+	return states, nil
+}
+
+func partitionEnd(ctx context.Context, broker, topic string, partition int) (int64, error) {
+	conn, err := kafka.DialLeader(ctx, "tcp", broker, topic, partition)
+	if err != nil {
+		return 0, fmt.Errorf("leader of %s/%d: %w", topic, partition, err)
+	}
+	defer conn.Close()
+	return conn.ReadLastOffset()
+}
@@ -0,0 +1,204 @@
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Manifest describes how the saga services are wired together. It is the
+// source for GET /topology; see pipeline.json at the repo root.
+type Manifest struct {
+	Name     string            `json:"name"`
+	Services []ServiceManifest `json:"services"`
+}
+
+// ServiceManifest is one deployment in the pipeline. Kind is emitter, step
+// or replayer; a replayer reads In (the DLQ) and re-emits to Out.
+type ServiceManifest struct {
+	Name  string `json:"name"`
+	Kind  string `json:"kind"`
+	Step  int    `json:"step,omitempty"`
+	In    string `json:"in,omitempty"`
+	Out   string `json:"out,omitempty"`
+	Group string `json:"group,omitempty"`
+	DLQ   string `json:"dlq,omitempty"`
+}
+
+// Topology is the rendered graph: services and topics as nodes, with an edge
+// for every consume, produce, dead-letter and replay path.
+type Topology struct {
+	Name     string            `json:"name"`
+	Services []ServiceManifest `json:"services"`
+	Topics   []TopicNode       `json:"topics"`
+	Edges    []Edge            `json:"edges"`
+}
+
+type TopicNode struct {
+	Name string `json:"name"`
+	DLQ  bool   `json:"dlq,omitempty"`
+}
+
+type Edge struct {
+	From  string `json:"from"`
+	To    string `json:"to"`
+	Kind  string `json:"kind"` // consume, produce, dead-letter, replay
+	Group string `json:"group,omitempty"`
+}
+
+// LoadManifest reads and validates a pipeline manifest.
+func LoadManifest(path string) (*Manifest, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var m Manifest
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	seen := map[string]bool{}
+	for _, s := range m.Services {
+		if s.Name == "" || seen[s.Name] {
+			return nil, fmt.Errorf("%s: service names must be set and unique (%q)", path, s.Name)
+		}
+		seen[s.Name] = true
+		switch s.Kind {
+		case "emitter":
+			if s.Out == "" {
+				return nil, fmt.Errorf("%s: emitter %s needs out", path, s.Name)
+			}
+		case "step", "replayer":
+			if s.In == "" || s.Out == "" || s.Group == "" {
+				return nil, fmt.Errorf("%s: %s %s needs in, out and group", path, s.Kind, s.Name)
+			}
+		default:
+			return nil, fmt.Errorf("%s: service %s has unknown kind %q", path, s.Name, s.Kind)
+		}
+	}
+	return &m, nil
+}
+
+// manifestFromEnv describes just this process, for when no manifest is
+// mounted. It reads the same envs the services themselves use.
+func manifestFromEnv() *Manifest {
+	s := ServiceManifest{
+		In:    os.Getenv("TOPIC_IN"),
+		Out:   os.Getenv("TOPIC_OUT"),
+		Group: os.Getenv("GROUP_ID"),
+		DLQ:   os.Getenv("DLQ_TOPIC"),
+	}
+	switch {
+	case os.Getenv("STEP") != "":
+		s.Kind = "step"
+		s.Step, _ = strconv.Atoi(os.Getenv("STEP"))
+		s.Name = "step" + os.Getenv("STEP")
+	case s.In == "" && s.DLQ != "":
+		s.Kind, s.Name = "replayer", "dlq-replayer"
+		s.In, s.Out, s.DLQ = s.DLQ, os.Getenv("REPLAY_TARGET"), ""
+	default:
+		s.Kind, s.Name = "emitter", "emitter"
+	}
+	return &Manifest{Name: "local", Services: []ServiceManifest{s}}
+}
+
+// Topology expands the manifest into a graph.
+func (m *Manifest) Topology() Topology {
+	t := Topology{Name: m.Name, Services: m.Services}
+	topics := map[string]bool{} // name -> is DLQ
+	addTopic := func(name string, dlq bool) {
+		if name != "" {
+			topics[name] = topics[name] || dlq
+		}
+	}
+	for _, s := range m.Services {
+		addTopic(s.In, s.Kind == "replayer")
+		addTopic(s.Out, false)
+		addTopic(s.DLQ, true)
+		if s.In != "" {
+			t.Edges = append(t.Edges, Edge{From: s.In, To: s.Name, Kind: "consume", Group: s.Group})
+		}
+		if s.Out != "" {
+			kind := "produce"
+			if s.Kind == "replayer" {
+				kind = "replay"
+			}
+			t.Edges = append(t.Edges, Edge{From: s.Name, To: s.Out, Kind: kind})
+		}
+		if s.DLQ != "" {
+			t.Edges = append(t.Edges, Edge{From: s.Name, To: s.DLQ, Kind: "dead-letter"})
+		}
+	}
+	for name, dlq := range topics {
+		t.Topics = append(t.Topics, TopicNode{Name: name, DLQ: dlq})
+	}
+	sort.Slice(t.Topics, func(i, j int) bool { return t.Topics[i].Name < t.Topics[j].Name })
+	return t
+}
+
+// DOT renders the topology for graphviz: services are boxes, topics are
+// ellipses and DLQ paths are red.
+func (t Topology) DOT() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "digraph %q {\n\trankdir=LR;\n", t.Name)
+	for _, s := range t.Services {
+		// labels are written raw so graphviz sees the \n line break
+		label := s.Name
+		if s.Group != "" {
+			label += `\n(` + s.Group + ")"
+		}
+		fmt.Fprintf(&b, "\t%q [shape=box, label=\"%s\"];\n", s.Name, label)
+	}
+	for _, tp := range t.Topics {
+		if tp.DLQ {
+			fmt.Fprintf(&b, "\t%q [shape=ellipse, color=red, fontcolor=red];\n", tp.Name)
+		} else {
+			fmt.Fprintf(&b, "\t%q [shape=ellipse];\n", tp.Name)
+		}
+	}
+	for _, e := range t.Edges {
+		attrs := ""
+		switch e.Kind {
+		case "dead-letter":
+			attrs = ` [color=red, style=dashed, label="dlq"]`
+		case "replay":
+			attrs = ` [color=orange, style=dashed, label="replay"]`
+		}
+		fmt.Fprintf(&b, "\t%q -> %q%s;\n", e.From, e.To, attrs)
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// TopologyHandler serves GET /topology as JSON, or as DOT with ?format=dot.
+// The graph comes from the manifest at PIPELINE_MANIFEST; without one the
+// service only describes itself from its envs.
+func TopologyHandler() http.Handler {
+	m := manifestFromEnv()
+	if path := os.Getenv("PIPELINE_MANIFEST"); path != "" {
+		loaded, err := LoadManifest(path)
+		if err != nil {
+			log.Printf("[topology] %v; falling back to local view", err)
+		} else {
+			m = loaded
+		}
+	}
+	t := m.Topology()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if r.URL.Query().Get("format") == "dot" {
+			w.Header().Set("Content-Type", "text/vnd.graphviz")
+			_, _ = w.Write([]byte(t.DOT()))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(t)
+	})
+}
@@ -0,0 +1,209 @@
+// Package localbus is a stand-in for Kafka that lives in the process, so
+// the whole lab can run without a cluster (cmd/lab). Topics are tables in
+// SQLite: kept in memory by default, or in a file that survives a restart.
+//
+// It keeps the parts of Kafka the saga services rely on and nothing else:
+// every topic is one ordered partition, records keep key, value and
+// headers, and a consumer group resumes from its committed offset. Records
+// use kafka.Message, so the services' encoding code works unchanged.
+package localbus
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/segmentio/kafka-go"
+)
+
+const schema = `
+CREATE TABLE IF NOT EXISTS records (
+	topic   TEXT    NOT NULL,
+	off     INTEGER NOT NULL,
+	key     BLOB,
+	value   BLOB,
+	headers TEXT,
+	ts      INTEGER NOT NULL,
+	PRIMARY KEY (topic, off)
+);
+CREATE TABLE IF NOT EXISTS commits (
+	grp   TEXT    NOT NULL,
+	topic TEXT    NOT NULL,
+	next  INTEGER NOT NULL,
+	PRIMARY KEY (grp, topic)
+);`
+
+// ErrClosed is returned by readers once the bus is closed.
+var ErrClosed = errors.New("localbus: closed")
+
+// Bus is a set of topics and consumer group offsets.
+type Bus struct {
+	db *sql.DB
+
+	mu     sync.Mutex
+	wake   chan struct{} // closed and replaced on every write
+	closed chan struct{}
+}
+
+// Open opens the bus stored at path, creating it if needed. An empty path
+// keeps everything in memory.
+func Open(path string) (*Bus, error) {
+	dsn := ":memory:"
+	if path != "" {
+		dsn = "file:" + path + "?_journal_mode=WAL&_busy_timeout=5000"
+	}
+	db, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		return nil, err
+	}
+	// one connection: an in-memory database exists per connection, and
+	// SQLite allows one writer anyway
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(schema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("localbus: %s: %w", dsn, err)
+	}
+	return &Bus{db: db, wake: make(chan struct{}), closed: make(chan struct{})}, nil
+}
+
+// Close wakes every blocked reader with ErrClosed and closes the database.
+func (b *Bus) Close() error {
+	b.mu.Lock()
+	select {
+	case <-b.closed:
+	default:
+		close(b.closed)
+	}
+	b.mu.Unlock()
+	return b.db.Close()
+}
+
+// WriteMessages appends msgs to their topics in one transaction, like
+// (*kafka.Writer).WriteMessages with the topic set per message.
+func (b *Bus) WriteMessages(ctx context.Context, msgs ...kafka.Message) error {
+	tx, err := b.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	now := time.Now()
+	for _, m := range msgs {
+		if m.Topic == "" {
+			return errors.New("localbus: message without topic")
+		}
+		headers, err := json.Marshal(m.Headers)
+		if err != nil {
+			return err
+		}
+		ts := m.Time
+		if ts.IsZero() {
+			ts = now
+		}
+		if _, err := tx.ExecContext(ctx, `INSERT INTO records (topic, off, key, value, headers, ts)
+			SELECT ?, COALESCE(MAX(off) + 1, 0), ?, ?, ?, ? FROM records WHERE topic = ?`,
+			m.Topic, m.Key, m.Value, string(headers), ts.UnixNano(), m.Topic); err != nil {
+			return fmt.Errorf("localbus: write %s: %w", m.Topic, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	b.mu.Lock()
+	close(b.wake)
+	b.wake = make(chan struct{})
+	b.mu.Unlock()
+	return nil
+}
+
+// Reader returns a reader of topic for group. Readers of one group share
+// its offset, so each record goes to one of them.
+func (b *Bus) Reader(topic, group string) *Reader {
+	return &Reader{bus: b, topic: topic, group: group}
+}
+
+// Lag is how many records of topic group has not read yet.
+func (b *Bus) Lag(ctx context.Context, topic, group string) (int64, error) {
+	var lag int64
+	err := b.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM records WHERE topic = ? AND off >=
+		COALESCE((SELECT next FROM commits WHERE grp = ? AND topic = ?), 0)`, topic, group, topic).Scan(&lag)
+	return lag, err
+}
+
+// Len is the number of records in topic.
+func (b *Bus) Len(ctx context.Context, topic string) (int64, error) {
+	var n int64
+	err := b.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM records WHERE topic = ?`, topic).Scan(&n)
+	return n, err
+}
+
+// Reader reads one topic for one consumer group.
+type Reader struct {
+	bus          *Bus
+	topic, group string
+}
+
+// ReadMessage blocks until the group has a record to read in the topic and
+// commits it, like (*kafka.Reader).ReadMessage with a GroupID. A new group
+// starts at the first record.
+func (r *Reader) ReadMessage(ctx context.Context) (kafka.Message, error) {
+	for {
+		r.bus.mu.Lock()
+		wake := r.bus.wake
+		r.bus.mu.Unlock()
+
+		m, ok, err := r.next(ctx)
+		if err != nil || ok {
+			return m, err
+		}
+		select {
+		case <-ctx.Done():
+			return kafka.Message{}, ctx.Err()
+		case <-r.bus.closed:
+			return kafka.Message{}, ErrClosed
+		case <-wake:
+		}
+	}
+}
+
+func (r *Reader) next(ctx context.Context) (kafka.Message, bool, error) {
+	select {
+	case <-r.bus.closed:
+		return kafka.Message{}, false, ErrClosed
+	default:
+	}
+	tx, err := r.bus.db.BeginTx(ctx, nil)
+	if err != nil {
+		return kafka.Message{}, false, err
+	}
+	defer tx.Rollback()
+	m := kafka.Message{Topic: r.topic}
+	var headers string
+	var ts int64
+	err = tx.QueryRowContext(ctx, `SELECT off, key, value, headers, ts FROM records WHERE topic = ? AND off >=
+		COALESCE((SELECT next FROM commits WHERE grp = ? AND topic = ?), 0) ORDER BY off LIMIT 1`,
+		r.topic, r.group, r.topic).Scan(&m.Offset, &m.Key, &m.Value, &headers, &ts)
+	if errors.Is(err, sql.ErrNoRows) {
+		return kafka.Message{}, false, nil
+	}
+	if err != nil {
+		return kafka.Message{}, false, err
+	}
+	if err := json.Unmarshal([]byte(headers), &m.Headers); err != nil {
+		return kafka.Message{}, false, fmt.Errorf("localbus: %s@%d headers: %w", r.topic, m.Offset, err)
+	}
+	m.Time = time.Unix(0, ts)
+	if _, err := tx.ExecContext(ctx, `INSERT INTO commits (grp, topic, next) VALUES (?, ?, ?)
+		ON CONFLICT (grp, topic) DO UPDATE SET next = excluded.next`, r.group, r.topic, m.Offset+1); err != nil {
+		return kafka.Message{}, false, err
+	}
+	return m, true, tx.Commit()
+}
+
+// Close is a no-op; it is there so a Reader can stand in for a
+// *kafka.Reader.
+func (r *Reader) Close() error { return nil }
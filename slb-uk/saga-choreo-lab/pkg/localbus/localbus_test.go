@@ -0,0 +1,144 @@
+package localbus
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+func open(t *testing.T, path string) *Bus {
+	t.Helper()
+	b, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { b.Close() })
+	return b
+}
+
+func read(t *testing.T, r *Reader) kafka.Message {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	m, err := r.ReadMessage(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestGroupsReadInOrder(t *testing.T) {
+	b := open(t, "")
+	ctx := context.Background()
+	err := b.WriteMessages(ctx,
+		kafka.Message{Topic: "saga.step1", Key: []byte("s1"), Value: []byte("a"),
+			Headers: []kafka.Header{{Key: "x-saga-id", Value: []byte("s1")}}},
+		kafka.Message{Topic: "saga.step1", Key: []byte("s2"), Value: []byte("b")},
+		kafka.Message{Topic: "saga.dlq", Value: []byte("c")},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	g1 := b.Reader("saga.step1", "g1")
+	m := read(t, g1)
+	if m.Offset != 0 || string(m.Value) != "a" || string(m.Key) != "s1" || m.Topic != "saga.step1" {
+		t.Fatalf("first record: %+v", m)
+	}
+	if len(m.Headers) != 1 || m.Headers[0].Key != "x-saga-id" || string(m.Headers[0].Value) != "s1" {
+		t.Fatalf("headers: %+v", m.Headers)
+	}
+	if m := read(t, g1); m.Offset != 1 || string(m.Value) != "b" {
+		t.Fatalf("second record: %+v", m)
+	}
+	// another group starts from the beginning; dlq is a separate topic
+	if m := read(t, b.Reader("saga.step1", "g2")); m.Offset != 0 {
+		t.Fatalf("g2 got offset %d", m.Offset)
+	}
+	if m := read(t, b.Reader("saga.dlq", "g1")); m.Offset != 0 || string(m.Value) != "c" {
+		t.Fatalf("dlq: %+v", m)
+	}
+
+	if lag, err := b.Lag(ctx, "saga.step1", "g1"); err != nil || lag != 0 {
+		t.Fatalf("g1 lag = %d, %v", lag, err)
+	}
+	if lag, err := b.Lag(ctx, "saga.step1", "g2"); err != nil || lag != 1 {
+		t.Fatalf("g2 lag = %d, %v", lag, err)
+	}
+	if n, err := b.Len(ctx, "saga.step1"); err != nil || n != 2 {
+		t.Fatalf("len = %d, %v", n, err)
+	}
+}
+
+func TestReadBlocksUntilWrite(t *testing.T) {
+	b := open(t, "")
+	r := b.Reader("t", "g")
+	got := make(chan kafka.Message)
+	go func() {
+		m, err := r.ReadMessage(context.Background())
+		if err != nil {
+			t.Error(err)
+		}
+		got <- m
+	}()
+	select {
+	case m := <-got:
+		t.Fatalf("read %+v from an empty topic", m)
+	case <-time.After(50 * time.Millisecond):
+	}
+	if err := b.WriteMessages(context.Background(), kafka.Message{Topic: "t", Value: []byte("x")}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case m := <-got:
+		if string(m.Value) != "x" {
+			t.Fatalf("got %+v", m)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("reader not woken by the write")
+	}
+}
+
+func TestReadEndsWithContextAndClose(t *testing.T) {
+	b := open(t, "")
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := b.Reader("t", "g").ReadMessage(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got %v", err)
+	}
+
+	done := make(chan error)
+	go func() {
+		_, err := b.Reader("t", "g").ReadMessage(context.Background())
+		done <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	b.Close()
+	if err := <-done; !errors.Is(err, ErrClosed) {
+		t.Fatalf("got %v", err)
+	}
+}
+
+func TestFileSurvivesReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "lab.db")
+	b, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if err := b.WriteMessages(ctx, kafka.Message{Topic: "t", Value: []byte("1")}, kafka.Message{Topic: "t", Value: []byte("2")}); err != nil {
+		t.Fatal(err)
+	}
+	read(t, b.Reader("t", "g"))
+	b.Close()
+
+	// the group resumes after its committed offset
+	b = open(t, path)
+	if m := read(t, b.Reader("t", "g")); m.Offset != 1 || string(m.Value) != "2" {
+		t.Fatalf("after reopen: %+v", m)
+	}
+}
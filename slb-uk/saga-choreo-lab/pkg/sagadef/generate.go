@@ -0,0 +1,425 @@
+package sagadef
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// File is one generated file, Path relative to the module root. Stubs are
+// starting points for hand-written code and are only written when missing.
+type File struct {
+	Path string
+	Data []byte
+	Stub bool
+}
+
+const (
+	generatedHeader = "# Code generated by sagagen from saga.yaml. DO NOT EDIT.\n"
+	modulePath      = "example.com/saga-choreo-lab"
+	jaegerCollector = "http://jaeger-collector:14268/api/traces"
+	pipelinePath    = "/etc/saga/pipeline.json"
+	stateTopic      = "saga.state"
+	failoverTopic   = "saga.failover"
+)
+
+// Generate renders every file derived from d, which must be valid.
+func Generate(d *Definition) ([]File, error) {
+	steps := d.Resolve()
+	var files []File
+	add := func(path string, tmpl *template.Template, data any, stub bool) error {
+		var b bytes.Buffer
+		if err := tmpl.Execute(&b, data); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		files = append(files, File{Path: path, Data: b.Bytes(), Stub: stub})
+		return nil
+	}
+
+	files = append(files, File{Path: "pipeline.json", Data: pipelineJSON(d, steps)})
+	if err := add("k8s/00-topics-job.yaml", topicsTmpl, topicsData(d, steps), false); err != nil {
+		return nil, err
+	}
+	emitterEnv := append([]envVar{{"KAFKA_BROKERS", d.Brokers}, {"TOPIC_OUT", d.Start.Topic}, {"EMIT_EVERY_MS", "1000"},
+		{"JAEGER_COLLECTOR", jaegerCollector}, {"PIPELINE_MANIFEST", pipelinePath}}, regionEnv(d)...)
+	if err := add("k8s/emitter.yaml", sidecarTmpl, service{Name: "emitter", Env: emitterEnv}, false); err != nil {
+		return nil, err
+	}
+	replayerEnv := append([]envVar{{"KAFKA_BROKERS", d.Brokers}, {"GROUP_ID", "dlq-replayer"}, {"DLQ_TOPIC", d.DLQ},
+		{"REPLAY_TARGET", d.ReplayTarget()}, {"JAEGER_COLLECTOR", jaegerCollector}, {"PIPELINE_MANIFEST", pipelinePath}}, regionEnv(d)...)
+	if err := add("k8s/dlq-replayer.yaml", sidecarTmpl, service{Name: "dlq-replayer", Env: replayerEnv}, false); err != nil {
+		return nil, err
+	}
+	lagEnv := []envVar{{"KAFKA_BROKERS", d.Brokers}, {"LAG_INTERVAL", "15s"}, {"PIPELINE_MANIFEST", pipelinePath}}
+	if len(d.Regions) > 0 {
+		lagEnv = append(lagEnv, envVar{"REGIONS", strings.Join(d.Regions, ",")})
+	}
+	if err := add("k8s/lagexporter.yaml", sidecarTmpl, service{Name: "lagexporter", Env: lagEnv}, false); err != nil {
+		return nil, err
+	}
+	// the archiver keeps what leaves the last step; files stay in the pod
+	// unless ARCHIVE_STORE=s3 is added
+	archiverEnv := []envVar{{"KAFKA_BROKERS", d.Brokers}, {"GROUP_ID", "archiver"}, {"TOPIC_IN", steps[len(steps)-1].Out},
+		{"ARCHIVE_DIR", "/tmp/archive"}, {"PIPELINE_MANIFEST", pipelinePath}}
+	if len(d.Regions) > 0 {
+		archiverEnv = append(archiverEnv, envVar{"REGIONS", strings.Join(d.Regions, ",")})
+	}
+	if err := add("k8s/archiver.yaml", sidecarTmpl, service{Name: "archiver", Env: archiverEnv}, false); err != nil {
+		return nil, err
+	}
+	compose := []service{{Name: "emitter", Env: composeEnv(emitterEnv)}}
+	for _, s := range steps {
+		if err := add(filepath.Join("k8s", s.Name+".yaml"), stepTmpl, service{Name: s.Name, Env: stepEnv(d, s)}, false); err != nil {
+			return nil, err
+		}
+		if err := add(filepath.Join("cmd", s.Name, "main.go"), stubTmpl, stubData{ResolvedStep: s, Saga: d.Name, Module: modulePath}, true); err != nil {
+			return nil, err
+		}
+		compose = append(compose, service{Name: s.Name, Env: composeEnv(stepEnv(d, s))})
+	}
+	compose = append(compose, service{Name: "dlq-replayer", Env: composeEnv(replayerEnv)},
+		service{Name: "lagexporter", Env: composeEnv(lagEnv)}, service{Name: "archiver", Env: composeEnv(archiverEnv)})
+	if err := add("docker-compose.yaml", composeTmpl, compose, false); err != nil {
+		return nil, err
+	}
+	names := make([]string, len(steps))
+	for i, s := range steps {
+		names[i] = s.Name
+	}
+	files = append(files, File{Path: "saga.mk", Data: []byte(generatedHeader + "STEPS := " + strings.Join(names, " ") + "\n")})
+	return files, nil
+}
+
+type envVar struct{ Name, Value string }
+
+type service struct {
+	Name string
+	Env  []envVar
+}
+
+type stubData struct {
+	ResolvedStep
+	Saga, Module string
+}
+
+// stepEnv is what RunStepService reads, in the order the step manifests
+// always listed it.
+func stepEnv(d *Definition, s ResolvedStep) []envVar {
+	env := []envVar{{"KAFKA_BROKERS", d.Brokers}, {"GROUP_ID", s.Group}, {"TOPIC_IN", s.In}, {"TOPIC_OUT", s.Out},
+		{"DLQ_TOPIC", d.DLQ}, {"STEP", strconv.Itoa(s.Number)},
+		{"JAEGER_COLLECTOR", jaegerCollector}, {"PIPELINE_MANIFEST", pipelinePath}}
+	if s.Retry != nil {
+		env = append(env, envVar{"RETRY_MAX", strconv.Itoa(s.Retry.Max)}, envVar{"RETRY_BACKOFF", s.Retry.Backoff.String()})
+	}
+	if s.CompensateIn != "" {
+		env = append(env, envVar{"COMPENSATE_TOPIC_IN", s.CompensateIn})
+	}
+	if s.CompensateOut != "" {
+		env = append(env, envVar{"COMPENSATE_TOPIC_OUT", s.CompensateOut})
+	}
+	if d.Contracts != "" && s.Type != TypeApproval {
+		env = append(env, envVar{"CONTRACT_TOPIC", d.Contracts})
+	}
+	if s.Type == TypeApproval {
+		env = append(env, envVar{"STEP_TYPE", TypeApproval}, envVar{"APPROVAL_TIMEOUT", s.ApprovalTimeout.String()},
+			envVar{"APPROVAL_STATE_TOPIC", s.ApprovalTopic})
+	}
+	env = append(env, regionEnv(d)...)
+	keys := make([]string, 0, len(s.Env))
+	for k := range s.Env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		env = append(env, envVar{k, s.Env[k]})
+	}
+	return env
+}
+
+// regionEnv is what multi-region mode adds to the emitter, the steps and the
+// DLQ replayer.
+func regionEnv(d *Definition) []envVar {
+	if len(d.Regions) == 0 {
+		return nil
+	}
+	return []envVar{{"REGIONS", strings.Join(d.Regions, ",")}, {"SAGA_STATE_TOPIC", stateTopic}}
+}
+
+// composeEnv points a k8s env list at the compose broker and drops Jaeger,
+// which compose does not run.
+func composeEnv(env []envVar) []envVar {
+	var out []envVar
+	for _, e := range env {
+		switch e.Name {
+		case "JAEGER_COLLECTOR":
+			continue
+		case "KAFKA_BROKERS":
+			e.Value = "kafka:9092"
+		}
+		out = append(out, e)
+	}
+	return out
+}
+
+// pipelineJSON writes the topology manifest one service per line, the
+// layout pipeline.json always had.
+func pipelineJSON(d *Definition, steps []ResolvedStep) []byte {
+	type kv struct {
+		k string
+		v any
+	}
+	line := func(fields ...kv) string {
+		var parts []string
+		for _, f := range fields {
+			if f.v == "" || f.v == 0 {
+				continue
+			}
+			k, _ := json.Marshal(f.k)
+			v, _ := json.Marshal(f.v)
+			parts = append(parts, string(k)+": "+string(v))
+		}
+		return "    { " + strings.Join(parts, ", ") + " }"
+	}
+	lines := []string{line(kv{"name", "emitter"}, kv{"kind", "emitter"}, kv{"out", d.Start.Topic})}
+	for _, s := range steps {
+		lines = append(lines, line(kv{"name", s.Name}, kv{"kind", "step"}, kv{"step", s.Number}, kv{"in", s.In},
+			kv{"out", s.Out}, kv{"group", s.Group}, kv{"dlq", d.DLQ}))
+	}
+	lines = append(lines, line(kv{"name", "dlq-replayer"}, kv{"kind", "replayer"}, kv{"in", d.DLQ},
+		kv{"out", d.ReplayTarget()}, kv{"group", "dlq-replayer"}))
+	name, _ := json.Marshal(d.Name)
+	return []byte("{\n  \"name\": " + string(name) + ",\n  \"services\": [\n" + strings.Join(lines, ",\n") + "\n  ]\n}\n")
+}
+
+type topics struct {
+	All, Lanes, Regions []string
+	Approvals           []string // compacted, shared by all regions
+	State, Failover     string
+}
+
+func topicsData(d *Definition, steps []ResolvedStep) topics {
+	t := topics{Lanes: []string{d.Start.Topic}, Regions: d.Regions, State: stateTopic, Failover: failoverTopic}
+	for _, s := range steps {
+		t.Lanes = append(t.Lanes, s.Out)
+	}
+	t.All = append(append([]string{}, t.Lanes...), d.DLQ)
+	if d.Contracts != "" {
+		t.All = append(t.All, d.Contracts)
+	}
+	for _, s := range steps {
+		if s.CompensateIn != "" {
+			t.All = append(t.All, s.CompensateIn)
+		}
+		if s.ApprovalTopic != "" {
+			t.Approvals = append(t.Approvals, s.ApprovalTopic)
+		}
+	}
+	return t
+}
+
+var funcs = template.FuncMap{"join": strings.Join, "quote": strconv.Quote}
+
+var topicsTmpl = template.Must(template.New("topics").Funcs(funcs).Parse(generatedHeader + `apiVersion: batch/v1
+kind: Job
+metadata:
+  name: kafka-create-topics
+spec:
+  backoffLimit: 1
+  template:
+    spec:
+      restartPolicy: Never
+      containers:
+      - name: kt
+        image: bitnami/kafka:latest
+        command: ["/bin/bash","-lc"]
+        args:
+        - |
+          set -e
+          broker=kafka:9092
+          for t in {{join .All " "}}; do
+            /opt/bitnami/kafka/bin/kafka-topics.sh --create --if-not-exists --topic $t --bootstrap-server $broker --partitions 3 --replication-factor 1 || true
+          done
+          # priority lanes (PRIORITY_LANES=true); the base topic is the normal lane
+          for t in {{join .Lanes " "}}; do
+            for p in high low; do
+              /opt/bitnami/kafka/bin/kafka-topics.sh --create --if-not-exists --topic $t.$p --bootstrap-server $broker --partitions 3 --replication-factor 1 || true
+            done
+          done
+{{- if .Approvals}}
+          # sagas waiting for approval, one record per saga
+          for t in {{join .Approvals " "}}; do
+            /opt/bitnami/kafka/bin/kafka-topics.sh --create --if-not-exists --topic $t --bootstrap-server $broker --partitions 3 --replication-factor 1 --config cleanup.policy=compact || true
+          done
+{{- end}}
+{{- if .Regions}}
+          # every region's copy of the above (REGIONS={{join .Regions ","}})
+          for r in {{join .Regions " "}}; do
+            for t in {{join .All " "}}; do
+              /opt/bitnami/kafka/bin/kafka-topics.sh --create --if-not-exists --topic $r.$t --bootstrap-server $broker --partitions 3 --replication-factor 1 || true
+            done
+            for t in {{join .Lanes " "}}; do
+              for p in high low; do
+                /opt/bitnami/kafka/bin/kafka-topics.sh --create --if-not-exists --topic $r.$t.$p --bootstrap-server $broker --partitions 3 --replication-factor 1 || true
+              done
+            done
+          done
+          # shared by all regions: the saga store and the failover commands
+          /opt/bitnami/kafka/bin/kafka-topics.sh --create --if-not-exists --topic {{.State}} --bootstrap-server $broker --partitions 3 --replication-factor 1 --config cleanup.policy=compact || true
+          /opt/bitnami/kafka/bin/kafka-topics.sh --create --if-not-exists --topic {{.Failover}} --bootstrap-server $broker --partitions 1 --replication-factor 1 || true
+{{- end}}
+`))
+
+var stepTmpl = template.Must(template.New("step").Funcs(funcs).Parse(generatedHeader + `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: {{.Name}}
+spec:
+  replicas: 1
+  selector:
+    matchLabels: { app: {{.Name}} }
+  template:
+    metadata:
+      labels: { app: {{.Name}} }
+      annotations:
+        prometheus.io/scrape: "true"
+        prometheus.io/port: "8080"
+    spec:
+      containers:
+      - name: {{.Name}}
+        image: saga/{{.Name}}:dev
+        imagePullPolicy: IfNotPresent
+        ports: [{containerPort: 8080, name: metrics}]
+        env:
+{{- range .Env}}
+        - name: {{.Name}}
+          value: {{quote .Value}}
+{{- end}}
+        volumeMounts:
+        - { name: pipeline, mountPath: /etc/saga, readOnly: true }
+        readinessProbe:
+          httpGet: { path: /metrics, port: 8080 }
+          initialDelaySeconds: 3
+      volumes:
+      - name: pipeline
+        configMap: { name: saga-pipeline }
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: {{.Name}}
+  labels:
+    app: {{.Name}}
+    saga-metrics: "true"
+spec:
+  selector: { app: {{.Name}} }
+  ports:
+  - name: metrics
+    port: 8080
+    targetPort: 8080
+`))
+
+// sidecarTmpl is the emitter, the DLQ replayer and the lag exporter: no
+// readiness probe and the compact env form.
+var sidecarTmpl = template.Must(template.New("sidecar").Funcs(funcs).Parse(generatedHeader + `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: {{.Name}}
+spec:
+  replicas: 1
+  selector:
+    matchLabels: { app: {{.Name}} }
+  template:
+    metadata:
+      labels: { app: {{.Name}} }
+      annotations:
+        prometheus.io/scrape: "true"
+        prometheus.io/port: "8080"
+    spec:
+      containers:
+      - name: {{.Name}}
+        image: saga/{{.Name}}:dev
+        imagePullPolicy: IfNotPresent
+        ports: [{containerPort: 8080, name: metrics}]
+        env:
+{{- range .Env}}
+        - { name: {{.Name}}, value: {{quote .Value}} }
+{{- end}}
+        volumeMounts:
+        - { name: pipeline, mountPath: /etc/saga, readOnly: true }
+      volumes:
+      - name: pipeline
+        configMap: { name: saga-pipeline }
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: {{.Name}}
+  labels:
+    app: {{.Name}}
+    saga-metrics: "true"
+spec:
+  selector: { app: {{.Name}} }
+  ports: [{ name: metrics, port: 8080, targetPort: 8080 }]
+`))
+
+var composeTmpl = template.Must(template.New("compose").Funcs(funcs).Parse(generatedHeader + `#
+# The pipeline without minikube: docker compose up --build
+# Topics are auto-created; no Jaeger, Prometheus or Grafana.
+services:
+  kafka:
+    image: bitnami/kafka:3.7.0
+    environment:
+      KAFKA_CFG_NODE_ID: "1"
+      KAFKA_CFG_PROCESS_ROLES: broker,controller
+      KAFKA_CFG_CONTROLLER_QUORUM_VOTERS: 1@kafka:9093
+      KAFKA_CFG_CONTROLLER_LISTENER_NAMES: CONTROLLER
+      KAFKA_CFG_LISTENERS: PLAINTEXT://:9092,CONTROLLER://:9093
+      KAFKA_CFG_ADVERTISED_LISTENERS: PLAINTEXT://kafka:9092
+      KAFKA_CFG_AUTO_CREATE_TOPICS_ENABLE: "true"
+      KAFKA_CFG_NUM_PARTITIONS: "3"
+    healthcheck:
+      test: ["CMD", "kafka-topics.sh", "--bootstrap-server", "localhost:9092", "--list"]
+      interval: 5s
+      retries: 20
+{{range .}}
+  {{.Name}}:
+    build: { context: ., args: { CMD: {{.Name}} } }
+    image: saga/{{.Name}}:dev
+    environment:
+{{- range .Env}}
+      {{.Name}}: {{quote .Value}}
+{{- end}}
+    volumes: ["./pipeline.json:/etc/saga/pipeline.json:ro"]
+    depends_on:
+      kafka: { condition: service_healthy }
+{{end -}}
+`))
+
+var stubTmpl = template.Must(template.New("stub").Funcs(funcs).Parse(`// Command {{.Name}} is step {{.Number}} of the {{.Saga}} saga, {{.In}} -> {{.Out}}.
+{{- if .Consumes}}
+// Consumes: {{join .Consumes ", "}}.
+{{- end}}
+{{- if .Produces}}
+// Produces: {{join .Produces ", "}}.
+{{- end}}
+//
+// Written once by sagagen from saga.yaml; edit freely.
+package main
+
+import (
+	"log"
+
+	"{{.Module}}/pkg/common"
+)
+
+func main() {
+	if err := common.RunStepService(); err != nil {
+		log.Fatal(err)
+	}
+}
+`))
@@ -0,0 +1,362 @@
+// Package sagadef describes a choreographed saga once, as saga.yaml or with
+// the Go builder, and derives everything that used to be copy-pasted per
+// step: topics, consumer groups, deployment manifests and command stubs.
+// cmd/sagagen writes the generated files.
+//
+//	d := sagadef.New("orders").
+//		StartAt("saga.orders", "order_id").
+//		Step("reserve", sagadef.Consumes("order_id"), sagadef.Produces("reservation_id"),
+//			sagadef.Retry(3, 200*time.Millisecond), sagadef.Compensate("saga.reserve.compensate")).
+//		Step("review", sagadef.ApprovalGate(4*time.Hour)).
+//		Step("charge", sagadef.Consumes("order_id", "reservation_id"))
+package sagadef
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Definition is the root of saga.yaml.
+type Definition struct {
+	Name    string `yaml:"name"`
+	Brokers string `yaml:"brokers"`
+	DLQ     string `yaml:"dlq"`
+	Start   Start  `yaml:"start"`
+	// ReplayTo names the step whose input the DLQ replayer falls back to
+	// when a record has no x-original-topic header; default the last step.
+	ReplayTo string `yaml:"replay_to"`
+	// Regions turns on multi-region mode (first region active): services
+	// get REGIONS and SAGA_STATE_TOPIC, and the topics job creates every
+	// region's copy of the topics plus the saga store and failover topics.
+	Regions []string `yaml:"regions,omitempty"`
+	// Contracts is the topic for step outputs that break the schema
+	// embedded for their step (pkg/stepcontract); it turns the checks on.
+	Contracts string `yaml:"contracts,omitempty"`
+	Steps     []Step `yaml:"steps"`
+}
+
+// Start is where the emitter puts new sagas and which payload fields it sets.
+type Start struct {
+	Topic    string   `yaml:"topic"`
+	Produces []string `yaml:"produces"`
+}
+
+// Step is one step service. Only Name is required: In defaults to the
+// previous step's Out (the start topic for the first step), Out to
+// "saga.<name>.completed" and Group to "<name>-group".
+type Step struct {
+	Name string `yaml:"name"`
+	// Type is "service" (the default, the step runs its handler) or
+	// "approval" (the saga waits for a person, see Approval).
+	Type  string `yaml:"type,omitempty"`
+	Group string `yaml:"group,omitempty"`
+	In    string `yaml:"in,omitempty"`
+	Out   string `yaml:"out,omitempty"`
+	// Consumes are the payload fields the step reads; each must be produced
+	// by the emitter or an earlier step. Produces are the fields it adds.
+	Consumes     []string          `yaml:"consumes,omitempty"`
+	Produces     []string          `yaml:"produces,omitempty"`
+	Retry        *RetryPolicy      `yaml:"retry,omitempty"`
+	Compensation *Compensation     `yaml:"compensation,omitempty"`
+	Approval     *Approval         `yaml:"approval,omitempty"`
+	Env          map[string]string `yaml:"env,omitempty"`
+}
+
+// Step types.
+const (
+	TypeService  = "service"
+	TypeApproval = "approval"
+)
+
+// Approval configures an approval step: the saga is held, listed on
+// GET /approvals and moves on when someone approves it. A rejection, or no
+// decision within Timeout (default 24h), compensates it instead.
+type Approval struct {
+	Timeout Duration `yaml:"timeout"`
+	// StateTopic keeps the pending sagas across restarts; default
+	// "saga.<name>.approvals", compacted.
+	StateTopic string `yaml:"state_topic,omitempty"`
+}
+
+// DefaultApprovalTimeout applies when an approval step sets none.
+const DefaultApprovalTimeout = 24 * time.Hour
+
+// RetryPolicy retries a retryable failure Max times, waiting Backoff and
+// doubling it each attempt, before the saga is dead-lettered.
+type RetryPolicy struct {
+	Max     int      `yaml:"max"`
+	Backoff Duration `yaml:"backoff"`
+}
+
+// Compensation is the topic the step listens on to undo its work when a
+// later step fails for good.
+type Compensation struct {
+	Topic string `yaml:"topic"`
+}
+
+// Duration reads "200ms" style strings.
+type Duration struct{ time.Duration }
+
+func (d *Duration) UnmarshalYAML(n *yaml.Node) error {
+	v, err := time.ParseDuration(n.Value)
+	d.Duration = v
+	return err
+}
+
+func (d Duration) MarshalYAML() (any, error) { return d.String(), nil }
+
+// Load reads and validates a saga.yaml.
+func Load(path string) (*Definition, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var d Definition
+	dec := yaml.NewDecoder(bytes.NewReader(b))
+	dec.KnownFields(true)
+	if err := dec.Decode(&d); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	if err := d.Validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &d, nil
+}
+
+// New starts a definition with the lab's defaults.
+func New(name string) *Definition {
+	return &Definition{Name: name, Brokers: "kafka:9092", DLQ: "saga.dlq"}
+}
+
+// StartAt sets the emitter's topic and the payload fields it sets.
+func (d *Definition) StartAt(topic string, produces ...string) *Definition {
+	d.Start = Start{Topic: topic, Produces: produces}
+	return d
+}
+
+// InRegions turns on multi-region mode, see Definition.Regions.
+func (d *Definition) InRegions(regions ...string) *Definition {
+	d.Regions = regions
+	return d
+}
+
+// CheckOutputs turns on the step output contracts, see Definition.Contracts.
+func (d *Definition) CheckOutputs(topic string) *Definition {
+	d.Contracts = topic
+	return d
+}
+
+// StepOption configures a step added with Step.
+type StepOption func(*Step)
+
+func Consumes(fields ...string) StepOption { return func(s *Step) { s.Consumes = fields } }
+func Produces(fields ...string) StepOption { return func(s *Step) { s.Produces = fields } }
+func Group(group string) StepOption        { return func(s *Step) { s.Group = group } }
+func Topics(in, out string) StepOption     { return func(s *Step) { s.In, s.Out = in, out } }
+func Compensate(topic string) StepOption {
+	return func(s *Step) { s.Compensation = &Compensation{Topic: topic} }
+}
+
+// ApprovalGate makes the step an approval step; timeout 0 is the default.
+func ApprovalGate(timeout time.Duration) StepOption {
+	return func(s *Step) { s.Type, s.Approval = TypeApproval, &Approval{Timeout: Duration{timeout}} }
+}
+
+func Retry(max int, backoff time.Duration) StepOption {
+	return func(s *Step) { s.Retry = &RetryPolicy{Max: max, Backoff: Duration{backoff}} }
+}
+
+func Env(k, v string) StepOption {
+	return func(s *Step) {
+		if s.Env == nil {
+			s.Env = map[string]string{}
+		}
+		s.Env[k] = v
+	}
+}
+
+// Step appends a step.
+func (d *Definition) Step(name string, opts ...StepOption) *Definition {
+	s := Step{Name: name}
+	for _, o := range opts {
+		o(&s)
+	}
+	d.Steps = append(d.Steps, s)
+	return d
+}
+
+// ResolvedStep is a step with every default filled in.
+type ResolvedStep struct {
+	Step
+	Number int // 1-based, the STEP env of the service
+	// CompensateIn is this step's own compensation topic; CompensateOut the
+	// nearest earlier step's, where failures and finished compensations go.
+	CompensateIn, CompensateOut string
+	// ApprovalTopic and ApprovalTimeout are set for approval steps.
+	ApprovalTopic   string
+	ApprovalTimeout time.Duration
+}
+
+var (
+	validName  = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`) // k8s DNS label
+	validTopic = regexp.MustCompile(`^[a-zA-Z0-9._-]{1,249}$`)
+	validEnv   = regexp.MustCompile(`^[A-Z_][A-Z0-9_]*$`)
+	// envs the generator sets itself
+	reservedEnv = map[string]bool{"KAFKA_BROKERS": true, "GROUP_ID": true, "TOPIC_IN": true, "TOPIC_OUT": true,
+		"DLQ_TOPIC": true, "STEP": true, "RETRY_MAX": true, "RETRY_BACKOFF": true,
+		"COMPENSATE_TOPIC_IN": true, "COMPENSATE_TOPIC_OUT": true, "REGIONS": true, "SAGA_STATE_TOPIC": true,
+		"STEP_TYPE": true, "APPROVAL_TIMEOUT": true, "APPROVAL_STATE_TOPIC": true, "CONTRACT_TOPIC": true}
+)
+
+// Resolve fills in defaults. It does not validate; see Validate.
+func (d *Definition) Resolve() []ResolvedStep {
+	out := make([]ResolvedStep, len(d.Steps))
+	prevOut, prevComp := d.Start.Topic, ""
+	for i, s := range d.Steps {
+		if s.In == "" {
+			s.In = prevOut
+		}
+		if s.Out == "" {
+			s.Out = "saga." + s.Name + ".completed"
+		}
+		if s.Group == "" {
+			s.Group = s.Name + "-group"
+		}
+		rs := ResolvedStep{Step: s, Number: i + 1, CompensateOut: prevComp}
+		if s.Compensation != nil {
+			rs.CompensateIn = s.Compensation.Topic
+			prevComp = rs.CompensateIn
+		}
+		if s.Type == TypeApproval {
+			rs.ApprovalTopic, rs.ApprovalTimeout = "saga."+s.Name+".approvals", DefaultApprovalTimeout
+			if s.Approval != nil && s.Approval.StateTopic != "" {
+				rs.ApprovalTopic = s.Approval.StateTopic
+			}
+			if s.Approval != nil && s.Approval.Timeout.Duration > 0 {
+				rs.ApprovalTimeout = s.Approval.Timeout.Duration
+			}
+		}
+		out[i] = rs
+		prevOut = s.Out
+	}
+	return out
+}
+
+// ReplayTarget is the topic the DLQ replayer falls back to.
+func (d *Definition) ReplayTarget() string {
+	steps := d.Resolve()
+	if len(steps) == 0 {
+		return ""
+	}
+	for _, s := range steps {
+		if s.Name == d.ReplayTo {
+			return s.In
+		}
+	}
+	return steps[len(steps)-1].In
+}
+
+// Validate checks names, topics and that every consumed field is produced
+// upstream.
+func (d *Definition) Validate() error {
+	var errs []error
+	add := func(format string, args ...any) { errs = append(errs, fmt.Errorf(format, args...)) }
+	if d.Name == "" || d.Brokers == "" || d.DLQ == "" || d.Start.Topic == "" {
+		add("name, brokers, dlq and start.topic are required")
+	}
+	if len(d.Steps) == 0 {
+		add("no steps")
+	}
+	available := map[string]bool{}
+	for _, f := range d.Start.Produces {
+		available[f] = true
+	}
+	names, topics := map[string]bool{}, map[string]string{d.DLQ: "dlq", d.Start.Topic: "start"}
+	if d.Contracts != "" {
+		if !validTopic.MatchString(d.Contracts) {
+			add("invalid contracts topic %q", d.Contracts)
+		}
+		if owner, ok := topics[d.Contracts]; ok {
+			add("contracts topic %s is already used by %s", d.Contracts, owner)
+		}
+		topics[d.Contracts] = "contracts"
+	}
+	replayFound := d.ReplayTo == ""
+	for _, s := range d.Resolve() {
+		if !validName.MatchString(s.Name) {
+			add("step %q: name must be a DNS label (lowercase letters, digits, -)", s.Name)
+		}
+		if names[s.Name] || s.Name == "emitter" || s.Name == "dlq-replayer" || s.Name == "lagexporter" {
+			add("step %q: duplicate or reserved name", s.Name)
+		}
+		names[s.Name] = true
+		replayFound = replayFound || s.Name == d.ReplayTo
+		for _, t := range []string{s.In, s.Out, s.CompensateIn, s.ApprovalTopic} {
+			if t != "" && !validTopic.MatchString(t) {
+				add("step %s: invalid topic %q", s.Name, t)
+			}
+		}
+		if owner, ok := topics[s.Out]; ok {
+			add("step %s: out topic %s is already used by %s", s.Name, s.Out, owner)
+		}
+		topics[s.Out] = s.Name
+		if s.CompensateIn != "" {
+			if owner, ok := topics[s.CompensateIn]; ok {
+				add("step %s: compensation topic %s is already used by %s", s.Name, s.CompensateIn, owner)
+			}
+			topics[s.CompensateIn] = s.Name
+		}
+		switch s.Type {
+		case "", TypeService:
+			if s.Approval != nil {
+				add("step %s: approval is only valid with type: approval", s.Name)
+			}
+		case TypeApproval:
+			if s.Retry != nil {
+				add("step %s: an approval step has nothing to retry", s.Name)
+			}
+			if s.Approval != nil && s.Approval.Timeout.Duration < 0 {
+				add("step %s: approval timeout must not be negative", s.Name)
+			}
+			if owner, ok := topics[s.ApprovalTopic]; ok {
+				add("step %s: approval state topic %s is already used by %s", s.Name, s.ApprovalTopic, owner)
+			}
+			topics[s.ApprovalTopic] = s.Name
+		default:
+			add("step %s: unknown type %q (service, approval)", s.Name, s.Type)
+		}
+		for _, f := range s.Consumes {
+			if !available[f] {
+				add("step %s consumes %q, which neither start nor an earlier step produces", s.Name, f)
+			}
+		}
+		for _, f := range s.Produces {
+			available[f] = true
+		}
+		if s.Retry != nil && (s.Retry.Max < 0 || s.Retry.Backoff.Duration < 0) {
+			add("step %s: retry max and backoff must not be negative", s.Name)
+		}
+		for k := range s.Env {
+			if !validEnv.MatchString(k) || reservedEnv[k] {
+				add("step %s: env %s is invalid or set by the generator", s.Name, k)
+			}
+		}
+	}
+	seenRegion := map[string]bool{}
+	for _, r := range d.Regions {
+		if !validName.MatchString(r) || seenRegion[r] {
+			add("region %q: must be a unique DNS label", r)
+		}
+		seenRegion[r] = true
+	}
+	if !replayFound {
+		add("replay_to %q is not a step", d.ReplayTo)
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,246 @@
+package sagadef
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestResolveDefaults(t *testing.T) {
+	d := New("orders").StartAt("saga.orders", "order_id").
+		Step("reserve", Produces("reservation_id"), Compensate("saga.reserve.undo")).
+		Step("charge", Consumes("order_id", "reservation_id"), Retry(3, 100*time.Millisecond)).
+		Step("ship", Topics("", "saga.shipped"))
+	if err := d.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	steps := d.Resolve()
+	want := []struct{ in, out, group, compIn, compOut string }{
+		{"saga.orders", "saga.reserve.completed", "reserve-group", "saga.reserve.undo", ""},
+		{"saga.reserve.completed", "saga.charge.completed", "charge-group", "", "saga.reserve.undo"},
+		{"saga.charge.completed", "saga.shipped", "ship-group", "", "saga.reserve.undo"},
+	}
+	for i, w := range want {
+		s := steps[i]
+		if s.Number != i+1 || s.In != w.in || s.Out != w.out || s.Group != w.group ||
+			s.CompensateIn != w.compIn || s.CompensateOut != w.compOut {
+			t.Errorf("step %d = %+v, want %+v", i+1, s, w)
+		}
+	}
+	if got := d.ReplayTarget(); got != "saga.charge.completed" {
+		t.Errorf("ReplayTarget = %s, want the last step's input", got)
+	}
+}
+
+func TestValidateErrors(t *testing.T) {
+	cases := map[string]struct {
+		d    *Definition
+		want string
+	}{
+		"unproduced field": {New("s").StartAt("a").Step("x", Consumes("missing")), `consumes "missing"`},
+		"reserved name":    {New("s").StartAt("a").Step("emitter"), "reserved name"},
+		"bad name":         {New("s").StartAt("a").Step("Step_1"), "DNS label"},
+		"duplicate out":    {New("s").StartAt("a").Step("x", Topics("", "a")), "already used by start"},
+		"negative retry":   {New("s").StartAt("a").Step("x", Retry(-1, 0)), "must not be negative"},
+		"reserved env":     {New("s").StartAt("a").Step("x", Env("TOPIC_IN", "b")), "set by the generator"},
+		"no steps":         {New("s").StartAt("a"), "no steps"},
+		"unknown type":     {New("s").StartAt("a").Step("x", func(s *Step) { s.Type = "manual" }), "unknown type"},
+		"approval on service": {New("s").StartAt("a").Step("x", func(s *Step) { s.Approval = &Approval{} }),
+			"only valid with type: approval"},
+		"approval retry":  {New("s").StartAt("a").Step("x", ApprovalGate(0), Retry(1, 0)), "nothing to retry"},
+		"contracts clash": {New("s").StartAt("a").CheckOutputs("saga.dlq").Step("x"), "contracts topic saga.dlq is already used by dlq"},
+	}
+	for name, c := range cases {
+		err := c.d.Validate()
+		if err == nil || !strings.Contains(err.Error(), c.want) {
+			t.Errorf("%s: Validate = %v, want %q", name, err, c.want)
+		}
+	}
+	d := New("s").StartAt("a").Step("x")
+	d.ReplayTo = "nope"
+	if err := d.Validate(); err == nil {
+		t.Error("unknown replay_to: want error")
+	}
+}
+
+func TestLoadMatchesBuilder(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "saga.yaml")
+	yaml := `name: orders
+brokers: kafka:9092
+dlq: saga.dlq
+start: { topic: saga.orders, produces: [order_id] }
+steps:
+  - name: reserve
+    retry: { max: 2, backoff: 50ms }
+    compensation: { topic: saga.reserve.undo }
+  - name: charge
+    consumes: [order_id]
+    env: { FAIL_MODE: fatal }
+`
+	if err := os.WriteFile(path, []byte(yaml), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	got, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := New("orders").StartAt("saga.orders", "order_id").
+		Step("reserve", Retry(2, 50*time.Millisecond), Compensate("saga.reserve.undo")).
+		Step("charge", Consumes("order_id"), Env("FAIL_MODE", "fatal"))
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Load = %+v\nwant    %+v", got, want)
+	}
+
+	if err := os.WriteFile(path, []byte(yaml+"typo: 1\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(path); err == nil {
+		t.Error("unknown field: want error")
+	}
+}
+
+// The checked-in files must be what saga.yaml generates; run
+// `go run ./cmd/sagagen` after editing either.
+func TestGeneratedFilesUpToDate(t *testing.T) {
+	root := filepath.Join("..", "..")
+	d, err := Load(filepath.Join(root, "saga.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files, err := Generate(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range files {
+		if f.Stub {
+			continue
+		}
+		b, err := os.ReadFile(filepath.Join(root, f.Path))
+		if err != nil || string(b) != string(f.Data) {
+			t.Errorf("%s is stale (err %v); run go run ./cmd/sagagen", f.Path, err)
+		}
+	}
+}
+
+func TestGenerateCompensationAndRetry(t *testing.T) {
+	d := New("orders").StartAt("saga.orders").
+		Step("reserve", Compensate("saga.reserve.undo")).
+		Step("charge", Retry(3, 100*time.Millisecond))
+	files, err := Generate(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	byPath := map[string]File{}
+	for _, f := range files {
+		byPath[f.Path] = f
+	}
+	charge := string(byPath["k8s/charge.yaml"].Data)
+	for _, want := range []string{`value: "3"`, `value: "100ms"`, "COMPENSATE_TOPIC_OUT", `value: "saga.reserve.undo"`} {
+		if !strings.Contains(charge, want) {
+			t.Errorf("k8s/charge.yaml lacks %s", want)
+		}
+	}
+	if !strings.Contains(string(byPath["k8s/00-topics-job.yaml"].Data), "saga.dlq saga.reserve.undo;") {
+		t.Error("compensation topic not created")
+	}
+	if stub := byPath["cmd/reserve/main.go"]; !stub.Stub || !strings.Contains(string(stub.Data), "package main") {
+		t.Errorf("cmd/reserve/main.go = %+v, want a stub", stub)
+	}
+	if got := string(byPath["saga.mk"].Data); !strings.HasSuffix(got, "STEPS := reserve charge\n") {
+		t.Errorf("saga.mk = %q", got)
+	}
+}
+
+func TestGenerateRegions(t *testing.T) {
+	d := New("orders").StartAt("saga.orders").InRegions("eu", "us").Step("reserve")
+	files, err := Generate(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	byPath := map[string]File{}
+	for _, f := range files {
+		byPath[f.Path] = f
+	}
+	for _, path := range []string{"k8s/reserve.yaml", "k8s/emitter.yaml", "k8s/dlq-replayer.yaml"} {
+		if !strings.Contains(string(byPath[path].Data), `"eu,us"`) || !strings.Contains(string(byPath[path].Data), "SAGA_STATE_TOPIC") {
+			t.Errorf("%s lacks the region envs", path)
+		}
+	}
+	jobs := string(byPath["k8s/00-topics-job.yaml"].Data)
+	for _, want := range []string{"for r in eu us;", "--topic $r.$t ", "--topic saga.state", "cleanup.policy=compact", "--topic saga.failover"} {
+		if !strings.Contains(jobs, want) {
+			t.Errorf("topics job lacks %s", want)
+		}
+	}
+
+	if err := New("o").StartAt("a").InRegions("eu", "eu").Step("x").Validate(); err == nil {
+		t.Error("duplicate region accepted")
+	}
+}
+
+func TestGenerateApproval(t *testing.T) {
+	d := New("orders").StartAt("saga.orders").
+		Step("reserve", Compensate("saga.reserve.undo")).
+		Step("review", ApprovalGate(0)).
+		Step("charge")
+	if err := d.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	review := d.Resolve()[1]
+	if review.ApprovalTopic != "saga.review.approvals" || review.ApprovalTimeout != DefaultApprovalTimeout ||
+		review.CompensateOut != "saga.reserve.undo" {
+		t.Errorf("review = %+v", review)
+	}
+	files, err := Generate(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	byPath := map[string]File{}
+	for _, f := range files {
+		byPath[f.Path] = f
+	}
+	manifest := string(byPath["k8s/review.yaml"].Data)
+	for _, want := range []string{`value: "approval"`, `value: "24h0m0s"`, `value: "saga.review.approvals"`} {
+		if !strings.Contains(manifest, want) {
+			t.Errorf("k8s/review.yaml lacks %s", want)
+		}
+	}
+	if strings.Contains(string(byPath["k8s/charge.yaml"].Data), "STEP_TYPE") {
+		t.Error("k8s/charge.yaml has STEP_TYPE")
+	}
+	if !strings.Contains(string(byPath["k8s/00-topics-job.yaml"].Data), "for t in saga.review.approvals;") {
+		t.Error("approval state topic not created")
+	}
+
+	taken := New("orders").StartAt("saga.orders").Step("review", ApprovalGate(time.Hour), Topics("", "saga.review.approvals"))
+	if err := taken.Validate(); err == nil || !strings.Contains(err.Error(), "already used") {
+		t.Errorf("approval topic clash: Validate = %v", err)
+	}
+}
+
+func TestGenerateContracts(t *testing.T) {
+	d := New("orders").StartAt("saga.orders").CheckOutputs("saga.contracts").
+		Step("reserve").
+		Step("review", ApprovalGate(0))
+	files, err := Generate(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	byPath := map[string]File{}
+	for _, f := range files {
+		byPath[f.Path] = f
+	}
+	if !strings.Contains(string(byPath["k8s/reserve.yaml"].Data), "CONTRACT_TOPIC") {
+		t.Error("k8s/reserve.yaml lacks CONTRACT_TOPIC")
+	}
+	// an approval step passes sagas on untouched
+	if strings.Contains(string(byPath["k8s/review.yaml"].Data), "CONTRACT_TOPIC") {
+		t.Error("k8s/review.yaml has CONTRACT_TOPIC")
+	}
+	if !strings.Contains(string(byPath["k8s/00-topics-job.yaml"].Data), "saga.dlq saga.contracts;") {
+		t.Error("contracts topic not created")
+	}
+}
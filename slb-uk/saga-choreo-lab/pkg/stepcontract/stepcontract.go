@@ -0,0 +1,155 @@
+// Package stepcontract holds the contract between adjacent steps: for each
+// step index N, schemas/stepN.json is the JSON Schema of the events step N
+// produces for step N+1. A step checks its output before producing it, so
+// a step that stops setting a field the next one reads is caught where it
+// happens, not some steps later.
+//
+//	c, err := stepcontract.For(3)
+//	if vs := c.Check(common.MustJSON(next)); len(vs) > 0 {
+//		// route to the contract-violation topic with vs
+//	}
+//
+// The schemas are embedded in the binary; changing one needs a rebuild of
+// the step it belongs to.
+package stepcontract
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"strconv"
+	"strings"
+
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+//go:embed schemas/*.json
+var schemas embed.FS
+
+// Violation is one difference between an event and its contract.
+type Violation struct {
+	// Path is the JSON pointer of the offending value in the event,
+	// "/payload/demo"; "" is the event itself.
+	Path string `json:"path"`
+	// Rule is the JSON pointer of the schema keyword that failed,
+	// "/properties/step/const".
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+	// Want is the value of that keyword, e.g. the expected const or the
+	// required fields; Got is the value at Path. Got is left out for
+	// objects and arrays, which can be large, and for missing values.
+	Want any `json:"want,omitempty"`
+	Got  any `json:"got,omitempty"`
+}
+
+func (v Violation) String() string {
+	path := v.Path
+	if path == "" {
+		path = "/"
+	}
+	return path + ": " + v.Message
+}
+
+// Contract is the compiled output schema of one step.
+type Contract struct {
+	Step   int
+	Schema string // file name in schemas/, e.g. "step3.json"
+	schema *jsonschema.Schema
+	doc    any // the schema itself, for Violation.Want
+}
+
+// For returns the contract of step's output, or nil when no schema is
+// embedded for step.
+func For(step int) (*Contract, error) {
+	name := fmt.Sprintf("step%d.json", step)
+	b, err := schemas.ReadFile("schemas/" + name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	c := jsonschema.NewCompiler()
+	c.Draft = jsonschema.Draft2020
+	c.AssertFormat = true
+	if err := c.AddResource(name, bytes.NewReader(b)); err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	s, err := c.Compile(name)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	var doc any
+	if err := json.Unmarshal(b, &doc); err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return &Contract{Step: step, Schema: name, schema: s, doc: doc}, nil
+}
+
+// Check returns the violations of event, a JSON document; none when it
+// keeps the contract. A nil Contract accepts everything.
+func (c *Contract) Check(event []byte) []Violation {
+	if c == nil {
+		return nil
+	}
+	var v any
+	if err := json.Unmarshal(event, &v); err != nil {
+		return []Violation{{Message: "not JSON: " + err.Error()}}
+	}
+	err := c.schema.Validate(v)
+	var ve *jsonschema.ValidationError
+	if !errors.As(err, &ve) {
+		return nil
+	}
+	var out []Violation
+	var walk func(e *jsonschema.ValidationError)
+	walk = func(e *jsonschema.ValidationError) {
+		if len(e.Causes) > 0 {
+			for _, cause := range e.Causes {
+				walk(cause)
+			}
+			return
+		}
+		vi := Violation{Path: e.InstanceLocation, Rule: e.KeywordLocation, Message: e.Message}
+		vi.Want, _ = at(c.doc, e.KeywordLocation)
+		if got, ok := at(v, e.InstanceLocation); ok {
+			switch got.(type) {
+			case map[string]any, []any:
+			default:
+				vi.Got = got
+			}
+		}
+		out = append(out, vi)
+	}
+	walk(ve)
+	return out
+}
+
+// at resolves a JSON pointer in a decoded document.
+func at(doc any, ptr string) (any, bool) {
+	if ptr == "" {
+		return doc, true
+	}
+	for _, tok := range strings.Split(strings.TrimPrefix(ptr, "/"), "/") {
+		tok = strings.NewReplacer("~1", "/", "~0", "~").Replace(tok)
+		switch d := doc.(type) {
+		case map[string]any:
+			v, ok := d[tok]
+			if !ok {
+				return nil, false
+			}
+			doc = v
+		case []any:
+			i, err := strconv.Atoi(tok)
+			if err != nil || i < 0 || i >= len(d) {
+				return nil, false
+			}
+			doc = d[i]
+		default:
+			return nil, false
+		}
+	}
+	return doc, true
+}
@@ -0,0 +1,92 @@
+package stepcontract
+
+import (
+	"encoding/json"
+	"io/fs"
+	"testing"
+)
+
+// output is what step produces when it works: the event it read, moved on
+// to the next step.
+func output(step int) map[string]any {
+	return map[string]any{"saga_id": "1700000000-42", "step": step + 1, "schema_version": 1,
+		"ts": "2024-05-01T12:00:00.123456Z", "payload": map[string]any{"demo": "start"}, "priority": "normal"}
+}
+
+func mustJSON(t *testing.T, v any) []byte {
+	t.Helper()
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestEveryEmbeddedSchemaAcceptsItsStep(t *testing.T) {
+	names, err := fs.Glob(schemas, "schemas/*.json")
+	if err != nil || len(names) == 0 {
+		t.Fatalf("no schemas embedded: %v", err)
+	}
+	for step := 1; step <= len(names); step++ {
+		c, err := For(step)
+		if err != nil || c == nil {
+			t.Fatalf("For(%d) = %v, %v", step, c, err)
+		}
+		if vs := c.Check(mustJSON(t, output(step))); len(vs) > 0 {
+			t.Errorf("step %d: %v", step, vs)
+		}
+	}
+}
+
+func TestNoSchema(t *testing.T) {
+	c, err := For(99)
+	if c != nil || err != nil {
+		t.Fatalf("For(99) = %v, %v", c, err)
+	}
+	if vs := c.Check([]byte(`{}`)); vs != nil {
+		t.Fatalf("nil contract: %v", vs)
+	}
+}
+
+func TestDrift(t *testing.T) {
+	c, err := For(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		name string
+		edit func(evt map[string]any)
+		want Violation
+	}{
+		{"field dropped", func(evt map[string]any) { evt["payload"] = map[string]any{"other": 1} },
+			Violation{Path: "/payload", Rule: "/properties/payload/required", Want: []any{"demo"}}},
+		{"field retyped", func(evt map[string]any) { evt["payload"].(map[string]any)["demo"] = 7 },
+			Violation{Path: "/payload/demo", Rule: "/properties/payload/properties/demo/type", Want: "string", Got: float64(7)}},
+		{"step not advanced", func(evt map[string]any) { evt["step"] = 2 },
+			Violation{Path: "/step", Rule: "/properties/step/const", Want: float64(3), Got: float64(2)}},
+		{"bad timestamp", func(evt map[string]any) { evt["ts"] = "yesterday" },
+			Violation{Path: "/ts", Rule: "/properties/ts/format", Want: "date-time", Got: "yesterday"}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			evt := output(2)
+			tc.edit(evt)
+			vs := c.Check(mustJSON(t, evt))
+			if len(vs) != 1 {
+				t.Fatalf("got %d violations: %v", len(vs), vs)
+			}
+			got, want := vs[0], tc.want
+			if got.Path != want.Path || got.Rule != want.Rule || got.Message == "" ||
+				string(mustJSON(t, got.Want)) != string(mustJSON(t, want.Want)) || got.Got != want.Got {
+				t.Errorf("got %+v\nwant %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestNotJSON(t *testing.T) {
+	c, _ := For(1)
+	if vs := c.Check([]byte("{")); len(vs) != 1 {
+		t.Fatalf("got %v", vs)
+	}
+}
@@ -0,0 +1,182 @@
+// Command mutate is a small mutation-testing harness: it plants one bug at a
+// time in a package (flip a comparison, drop an assignment, blank a status
+// string, ...) and runs the tests against each mutant. A mutant the tests
+// still pass on has "survived" and points at behaviour no test pins down.
+//
+//	go run ./cmd/mutate                         # internal/order vs its gomock tests
+//	go run ./cmd/mutate -tests ./internal/order/...,./features
+//
+// Sources are never touched: each mutant is compiled through a `go test
+// -overlay`, so the harness can run in parallel and be interrupted safely.
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type status string
+
+const (
+    killed   status = "killed"   // a test failed (or timed out)
+    survived status = "survived" // every test passed
+    invalid  status = "invalid"  // the mutant did not compile
+)
+
+type result struct {
+    mutant
+    Status status
+}
+
+func main() {
+    pkg := flag.String("pkg", "internal/order", "directory of the package to mutate, relative to the module root")
+    tests := flag.String("tests", "./internal/order/...", "comma-separated packages whose tests must kill the mutants")
+    jobs := flag.Int("j", runtime.NumCPU(), "mutants tested in parallel")
+    timeout := flag.Duration("timeout", 30*time.Second, "per-mutant test timeout; a timeout counts as killed")
+    minScore := flag.Float64("min-score", 0, "exit 1 when the mutation score (killed / (killed+survived)) is below this, 0..1")
+    verbose := flag.Bool("v", false, "list killed and invalid mutants too")
+    flag.Parse()
+
+    root, err := moduleRoot()
+    if err != nil {
+        log.Fatal(err)
+    }
+    testPkgs := strings.Split(*tests, ",")
+
+    files, err := filepath.Glob(filepath.Join(root, *pkg, "*.go"))
+    if err != nil {
+        log.Fatal(err)
+    }
+    var all []mutant
+    for _, f := range files {
+        if strings.HasSuffix(f, "_test.go") {
+            continue
+        }
+        ms, err := mutants(f)
+        if err != nil {
+            log.Fatal(err)
+        }
+        all = append(all, ms...)
+    }
+    if len(all) == 0 {
+        log.Fatalf("no mutants in %s", *pkg)
+    }
+
+    // the suite has to pass unmutated, or every mutant looks killed
+    if st, out := runTests(root, "", testPkgs, *timeout); st != survived {
+        log.Fatalf("tests fail without mutations:\n%s", out)
+    }
+
+    tmp, err := os.MkdirTemp("", "mutate-")
+    if err != nil {
+        log.Fatal(err)
+    }
+    defer os.RemoveAll(tmp)
+
+    results := make([]result, len(all))
+    sem := make(chan struct{}, max(1, *jobs))
+    var wg sync.WaitGroup
+    for i, m := range all {
+        wg.Add(1)
+        sem <- struct{}{}
+        go func() {
+            defer func() { <-sem; wg.Done() }()
+            results[i] = result{mutant: m, Status: test(root, tmp, i, m, testPkgs, *timeout)}
+        }()
+    }
+    wg.Wait()
+
+    score := report(os.Stdout, root, results, *verbose)
+    if score < *minScore {
+        fmt.Printf("mutation score %.2f is below -min-score %.2f\n", score, *minScore)
+        os.Exit(1)
+    }
+}
+
+func moduleRoot() (string, error) {
+    out, err := exec.Command("go", "env", "GOMOD").Output()
+    if err != nil {
+        return "", err
+    }
+    gomod := strings.TrimSpace(string(out))
+    if gomod == "" || gomod == os.DevNull {
+        return "", errors.New("not inside a Go module")
+    }
+    return filepath.Dir(gomod), nil
+}
+
+// test swaps m in through an overlay file and runs the tests.
+func test(root, tmp string, i int, m mutant, pkgs []string, timeout time.Duration) status {
+    src, err := writeTemp(tmp, i, m)
+    if err != nil {
+        log.Fatal(err)
+    }
+    overlay, _ := json.Marshal(map[string]map[string]string{"Replace": {m.File: src}})
+    ovPath := filepath.Join(tmp, fmt.Sprintf("overlay-%03d.json", i))
+    if err := os.WriteFile(ovPath, overlay, 0o644); err != nil {
+        log.Fatal(err)
+    }
+    st, _ := runTests(root, ovPath, pkgs, timeout)
+    return st
+}
+
+func runTests(root, overlay string, pkgs []string, timeout time.Duration) (status, []byte) {
+    ctx, cancel := context.WithTimeout(context.Background(), timeout)
+    defer cancel()
+    // vet is off: a mutant vet rejects is still a mutant the tests should kill
+    args := []string{"test", "-count=1", "-vet=off"}
+    if overlay != "" {
+        args = append(args, "-overlay", overlay)
+    }
+    cmd := exec.CommandContext(ctx, "go", append(args, pkgs...)...)
+    cmd.Dir = root
+    out, err := cmd.CombinedOutput()
+    switch {
+    case err == nil:
+        return survived, out
+    case bytes.Contains(out, []byte("[build failed]")) || bytes.Contains(out, []byte("[setup failed]")):
+        return invalid, out
+    default:
+        return killed, out
+    }
+}
+
+// report prints the survivors (and with verbose everything else) and
+// returns the mutation score.
+func report(w *os.File, root string, results []result, verbose bool) float64 {
+    sort.SliceStable(results, func(i, j int) bool {
+        a, b := results[i], results[j]
+        if a.File != b.File {
+            return a.File < b.File
+        }
+        return a.Line < b.Line || a.Line == b.Line && a.Col < b.Col
+    })
+    count := map[status]int{}
+    for _, r := range results {
+        count[r.Status]++
+        if r.Status == survived || verbose {
+            rel, _ := filepath.Rel(root, r.File)
+            fmt.Fprintf(w, "%-8s %s:%d:%d\t%s\t%s\n", strings.ToUpper(string(r.Status)), rel, r.Line, r.Col, r.Operator, r.Change)
+        }
+    }
+    score := 1.0
+    if n := count[killed] + count[survived]; n > 0 {
+        score = float64(count[killed]) / float64(n)
+    }
+    fmt.Fprintf(w, "\n%d mutants: %d killed, %d survived, %d invalid; mutation score %.2f\n",
+        len(results), count[killed], count[survived], count[invalid], score)
+    return score
+}
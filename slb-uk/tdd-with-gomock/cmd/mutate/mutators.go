@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"os"
+	"strconv"
+)
+
+// mutant is one small change to one file, the kind of bug a test suite is
+// supposed to notice.
+type mutant struct {
+    File     string // absolute path of the mutated file
+    Line     int
+    Col      int
+    Operator string // e.g. "conditional-boundary"
+    Change   string // e.g. "<= -> <"
+    Source   []byte // the whole mutated file
+}
+
+// swaps are the binary operator replacements, grouped by the operator
+// names go-mutesting and PIT use.
+var swaps = map[token.Token]struct {
+    to       token.Token
+    operator string
+}{
+    token.LSS:  {token.LEQ, "conditional-boundary"},
+    token.LEQ:  {token.LSS, "conditional-boundary"},
+    token.GTR:  {token.GEQ, "conditional-boundary"},
+    token.GEQ:  {token.GTR, "conditional-boundary"},
+    token.EQL:  {token.NEQ, "negate-conditional"},
+    token.NEQ:  {token.EQL, "negate-conditional"},
+    token.LAND: {token.LOR, "logical-operator"},
+    token.LOR:  {token.LAND, "logical-operator"},
+    token.ADD:  {token.SUB, "arithmetic"},
+    token.SUB:  {token.ADD, "arithmetic"},
+    token.MUL:  {token.QUO, "arithmetic"},
+    token.QUO:  {token.MUL, "arithmetic"},
+}
+
+// mutants returns every mutant of the Go file at path. Only function bodies
+// are mutated. Operators:
+//
+//	conditional-boundary  < <= > >= swapped with their neighbour
+//	negate-conditional    == and != swapped
+//	logical-operator      && and || swapped
+//	arithmetic            + - * / swapped
+//	string-literal        a non-empty literal outside a call becomes ""
+//	remove-assignment     an assignment to an existing variable or field is dropped
+func mutants(path string) ([]mutant, error) {
+    fset := token.NewFileSet()
+    f, err := parser.ParseFile(fset, path, nil, parser.ParseComments)
+    if err != nil {
+        return nil, err
+    }
+    var out []mutant
+    // emit prints the file in its current (mutated) state.
+    emit := func(pos token.Pos, operator, change string) {
+        var b bytes.Buffer
+        if err := printer.Fprint(&b, fset, f); err != nil {
+            return
+        }
+        p := fset.Position(pos)
+        out = append(out, mutant{File: path, Line: p.Line, Col: p.Column, Operator: operator, Change: change, Source: b.Bytes()})
+    }
+
+    for _, decl := range f.Decls {
+        fn, ok := decl.(*ast.FuncDecl)
+        if !ok || fn.Body == nil {
+            continue
+        }
+        inCall := map[ast.Node]bool{}
+        ast.Inspect(fn.Body, func(n ast.Node) bool {
+            switch n := n.(type) {
+            case *ast.CallExpr:
+                for _, a := range n.Args {
+                    inCall[a] = true // error messages and format strings
+                }
+            case *ast.BinaryExpr:
+                if s, ok := swaps[n.Op]; ok {
+                    orig := n.Op
+                    n.Op = s.to
+                    emit(n.OpPos, s.operator, orig.String()+" -> "+s.to.String())
+                    n.Op = orig
+                }
+            case *ast.BasicLit:
+                if n.Kind == token.STRING && !inCall[n] {
+                    if v, err := strconv.Unquote(n.Value); err == nil && v != "" {
+                        orig := n.Value
+                        n.Value = `""`
+                        emit(n.Pos(), "string-literal", orig+` -> ""`)
+                        n.Value = orig
+                    }
+                }
+            case *ast.AssignStmt:
+                if n.Tok != token.ASSIGN {
+                    break
+                }
+                // assign to _ instead of deleting, so the right side stays used
+                orig := n.Lhs
+                desc := "drop " + render(fset, n)
+                n.Lhs = make([]ast.Expr, len(orig))
+                for i := range n.Lhs {
+                    n.Lhs[i] = ast.NewIdent("_")
+                }
+                emit(n.Pos(), "remove-assignment", desc)
+                n.Lhs = orig
+            }
+            return true
+        })
+    }
+    return out, nil
+}
+
+func render(fset *token.FileSet, n ast.Node) string {
+    var b bytes.Buffer
+    _ = printer.Fprint(&b, fset, n)
+    return b.String()
+}
+
+// writeTemp stores a mutant's source for the go build overlay.
+func writeTemp(dir string, i int, m mutant) (string, error) {
+    path := fmt.Sprintf("%s/mutant-%03d.go", dir, i)
+    return path, os.WriteFile(path, m.Source, 0o644)
+}
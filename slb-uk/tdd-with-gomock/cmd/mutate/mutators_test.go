@@ -0,0 +1,58 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMutants(t *testing.T) {
+    src := `package p
+
+import "fmt"
+
+const limit = 10 // outside a function: never mutated
+
+func check(n int, s string) (string, error) {
+    if n <= 0 || n > limit {
+        return "", fmt.Errorf("bad n %d", n)
+    }
+    s = "ok"
+    return s, nil
+}
+`
+    path := filepath.Join(t.TempDir(), "p.go")
+    if err := os.WriteFile(path, []byte(src), 0o644); err != nil {
+        t.Fatal(err)
+    }
+    ms, err := mutants(path)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    var got []string
+    for _, m := range ms {
+        got = append(got, m.Operator+" "+m.Change)
+        if !strings.Contains(string(m.Source), "package p") {
+            t.Errorf("%s: source is not the whole file", m.Change)
+        }
+    }
+    want := []string{
+        "logical-operator || -> &&",
+        "conditional-boundary <= -> <",
+        "conditional-boundary > -> >=",
+        `remove-assignment drop s = "ok"`,
+        `string-literal "ok" -> ""`,
+    }
+    if strings.Join(got, "\n") != strings.Join(want, "\n") {
+        t.Fatalf("mutants:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+    }
+    if !strings.Contains(string(ms[3].Source), `_ = "ok"`) {
+        t.Errorf("remove-assignment should keep the right side used:\n%s", ms[3].Source)
+    }
+    // the AST is restored after each mutant
+    if !strings.Contains(string(ms[4].Source), "n <= 0 || n > limit") {
+        t.Errorf("earlier mutations leaked:\n%s", ms[4].Source)
+    }
+}
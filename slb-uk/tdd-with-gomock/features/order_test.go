@@ -0,0 +1,61 @@
+package features
+
+import "testing"
+
+func TestPlacingAnOrder(t *testing.T) {
+    feature(t, "Placing an order",
+        Scenario("the card is charged and the order is paid",
+            Given(aCustomerPayingWith("tok_visa")),
+            When(theyPlaceAnOrder("ord_1", 4999, "INR")),
+            Then(theRequestSucceeds()),
+            And(orderIsStoredAs("ord_1", "paid")),
+            And(theCustomerHasBeenCharged(4999)),
+            And(theBooksShowCashOf(4999)),
+            And(theBooksBalance()),
+        ),
+        Scenario("a declined card leaves nothing behind",
+            Given(aCustomerPayingWith("tok_broke")),
+            And(theirCardIsDeclined()),
+            When(theyPlaceAnOrder("ord_2", 4999, "INR")),
+            Then(theRequestFailsWith("card declined")),
+            And(noOrderIsStored("ord_2")),
+            And(theCustomerHasBeenCharged(0)),
+            And(theBooksShowCashOf(0)),
+        ),
+        Scenario("an empty order is refused before charging",
+            Given(aCustomerPayingWith("tok_visa")),
+            When(theyPlaceAnOrder("ord_3", 0, "INR")),
+            Then(theRequestFailsWith("invalid amount")),
+            And(theCustomerHasBeenCharged(0)),
+        ),
+    )
+}
+
+func TestRefundingAnOrder(t *testing.T) {
+    feature(t, "Refunding an order",
+        Scenario("a paid order is refunded in full",
+            Given(aCustomerPayingWith("tok_visa")),
+            And(theyHavePlacedAnOrder("ord_1", 4999, "INR")),
+            When(theyAskForARefundOf("ord_1")),
+            Then(theRequestSucceeds()),
+            And(orderIsStoredAs("ord_1", "refunded")),
+            And(theCustomerHasBeenCharged(0)),
+            And(theBooksShowCashOf(0)),
+            And(theBooksBalance()),
+        ),
+        Scenario("an order is refunded only once",
+            Given(aCustomerPayingWith("tok_visa")),
+            And(theyHavePlacedAnOrder("ord_1", 4999, "INR")),
+            And(theyAskForARefundOf("ord_1")),
+            When(theyAskForARefundOf("ord_1")),
+            Then(theRequestIsRejectedAsNotRefundable()),
+            And(theCustomerHasBeenCharged(0)),
+            And(theBooksShowCashOf(0)),
+        ),
+        Scenario("an unknown order cannot be refunded",
+            Given(aCustomerPayingWith("tok_visa")),
+            When(theyAskForARefundOf("ord_404")),
+            Then(theRequestFailsWith("order not found")),
+        ),
+    )
+}
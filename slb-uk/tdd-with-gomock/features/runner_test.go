@@ -0,0 +1,73 @@
+// Package features is the outer loop of double-loop TDD: business scenarios
+// run against the real order.Service wired to in-memory adapters. A new
+// behaviour starts here as a failing scenario; the unit tests in
+// internal/order drive out the code until it passes.
+package features
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/slb-uk/tdd-with-gomock/internal/adapters/memory"
+	"github.com/slb-uk/tdd-with-gomock/internal/domain"
+	"github.com/slb-uk/tdd-with-gomock/internal/order"
+)
+
+// world is the state one scenario runs in; steps read and change it.
+type world struct {
+    ctx     context.Context
+    pay     *memory.Gateway
+    repo    *memory.OrderRepo
+    books   *memory.Ledger
+    svc     *order.Service
+    source  string
+    last    domain.Order
+    lastErr error
+}
+
+func newWorld() *world {
+    w := &world{ctx: context.Background(), pay: memory.NewGateway(), repo: memory.NewOrderRepo(), books: memory.NewLedger()}
+    w.svc = order.NewService(w.pay, w.repo, w.books)
+    return w
+}
+
+type step struct {
+    keyword string
+    text    string
+    run     func(w *world) error
+}
+
+type scenario struct {
+    name  string
+    steps []step
+}
+
+func feature(t *testing.T, name string, scenarios ...scenario) {
+    t.Helper()
+    t.Logf("Feature: %s", name)
+    for _, sc := range scenarios {
+        sc := sc
+        t.Run(sc.name, func(t *testing.T) {
+            t.Parallel()
+            w := newWorld()
+            for _, st := range sc.steps {
+                if err := st.run(w); err != nil {
+                    t.Fatalf("%s %s: %v", st.keyword, st.text, err)
+                }
+                t.Logf("  %s %s", st.keyword, st.text)
+            }
+        })
+    }
+}
+
+func Scenario(name string, steps ...step) scenario { return scenario{name: name, steps: steps} }
+
+func Given(s step) step { s.keyword = "Given"; return s }
+func When(s step) step  { s.keyword = "When"; return s }
+func Then(s step) step  { s.keyword = "Then"; return s }
+func And(s step) step   { s.keyword = "And"; return s }
+
+func newStep(run func(w *world) error, format string, args ...any) step {
+    return step{text: fmt.Sprintf(format, args...), run: run}
+}
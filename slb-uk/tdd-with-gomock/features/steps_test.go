@@ -0,0 +1,134 @@
+package features
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/slb-uk/tdd-with-gomock/internal/domain"
+	"github.com/slb-uk/tdd-with-gomock/internal/order"
+)
+
+// Steps are phrased in business terms; each one drives or inspects the
+// world only through the service and the adapters' public behaviour.
+
+func aCustomerPayingWith(source string) step {
+    return newStep(func(w *world) error {
+        w.source = source
+        return nil
+    }, "a customer paying with %q", source)
+}
+
+func theirCardIsDeclined() step {
+    return newStep(func(w *world) error {
+        w.pay.Decline(w.source)
+        return nil
+    }, "their card is declined")
+}
+
+func theyPlaceAnOrder(id string, cents int64, currency string) step {
+    return newStep(func(w *world) error {
+        w.last, w.lastErr = w.svc.PlaceOrder(w.ctx, domain.Order{ID: id, AmountCents: cents, Currency: currency, Status: "pending"}, w.source)
+        return nil
+    }, "they place order %s for %d %s", id, cents, currency)
+}
+
+func theyHavePlacedAnOrder(id string, cents int64, currency string) step {
+    place := theyPlaceAnOrder(id, cents, currency)
+    return newStep(func(w *world) error {
+        _ = place.run(w)
+        return w.lastErr
+    }, "they have placed order %s for %d %s", id, cents, currency)
+}
+
+func theyAskForARefundOf(id string) step {
+    return newStep(func(w *world) error {
+        w.last, w.lastErr = w.svc.Refund(w.ctx, id)
+        return nil
+    }, "they ask for a refund of order %s", id)
+}
+
+func theRequestSucceeds() step {
+    return newStep(func(w *world) error {
+        return w.lastErr
+    }, "the request succeeds")
+}
+
+func theRequestFailsWith(substr string) step {
+    return newStep(func(w *world) error {
+        if w.lastErr == nil || !strings.Contains(w.lastErr.Error(), substr) {
+            return fmt.Errorf("want error containing %q, got %v", substr, w.lastErr)
+        }
+        return nil
+    }, "the request fails with %q", substr)
+}
+
+func theRequestIsRejectedAsNotRefundable() step {
+    return newStep(func(w *world) error {
+        if !errors.Is(w.lastErr, order.ErrNotRefundable) {
+            return fmt.Errorf("want ErrNotRefundable, got %v", w.lastErr)
+        }
+        return nil
+    }, "the request is rejected as not refundable")
+}
+
+func orderIsStoredAs(id, status string) step {
+    return newStep(func(w *world) error {
+        o, err := w.repo.Get(w.ctx, id)
+        if err != nil {
+            return err
+        }
+        if o.Status != status {
+            return fmt.Errorf("order %s is %q, want %q", id, o.Status, status)
+        }
+        return nil
+    }, "order %s is stored as %s", id, status)
+}
+
+func noOrderIsStored(id string) step {
+    return newStep(func(w *world) error {
+        if o, err := w.repo.Get(w.ctx, id); err == nil {
+            return fmt.Errorf("order %s was stored as %q", id, o.Status)
+        }
+        return nil
+    }, "no order %s is stored", id)
+}
+
+func theCustomerHasBeenCharged(cents int64) step {
+    return newStep(func(w *world) error {
+        if got := w.pay.Charged(w.source); got != cents {
+            return fmt.Errorf("customer was charged %d, want %d", got, cents)
+        }
+        return nil
+    }, "the customer has been charged %d in total", cents)
+}
+
+func theBooksBalance() step {
+    return newStep(func(w *world) error {
+        var net int64
+        for _, p := range w.books.Postings() {
+            if !p.Balanced() {
+                return fmt.Errorf("posting %s does not balance: %+v", p.ID, p.Entries)
+            }
+        }
+        for _, acct := range []string{domain.AccountCash, domain.AccountSales, domain.AccountRefunds} {
+            net += w.books.Balance(acct)
+        }
+        if net != 0 {
+            return fmt.Errorf("accounts sum to %d", net)
+        }
+        return nil
+    }, "the books balance")
+}
+
+func theBooksShowCashOf(cents int64) step {
+    return newStep(func(w *world) error {
+        if got := w.books.Balance(domain.AccountCash); got != cents {
+            return fmt.Errorf("cash is %d, want %d", got, cents)
+        }
+        if got := w.pay.Charged(w.source); got != cents {
+            return fmt.Errorf("the provider holds %d, the books say %d", got, cents)
+        }
+        return nil
+    }, "the books show %d in cash, as the provider does", cents)
+}
@@ -0,0 +1,159 @@
+// Package memory holds in-memory adapters for the domain ports: fakes that
+// behave like the real thing, for acceptance tests and local runs.
+package memory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/slb-uk/tdd-with-gomock/internal/domain"
+)
+
+var (
+    ErrCardDeclined = errors.New("card declined")
+    ErrUnknownTx    = errors.New("unknown transaction")
+    ErrNotFound     = errors.New("order not found")
+
+    ErrUnbalanced       = errors.New("posting does not balance")
+    ErrDuplicatePosting = errors.New("posting already recorded")
+)
+
+// Gateway is a fake payment provider. Every source is accepted unless it was
+// marked declined; charges and refunds are recorded per source.
+type Gateway struct {
+    mu       sync.Mutex
+    declined map[string]bool
+    txs      map[string]charge
+    balance  map[string]int64 // net cents taken from each source
+    seq      int
+}
+
+type charge struct {
+    source      string
+    amountCents int64
+    refunded    bool
+}
+
+func NewGateway() *Gateway {
+    return &Gateway{declined: map[string]bool{}, txs: map[string]charge{}, balance: map[string]int64{}}
+}
+
+// Decline makes every future charge against source fail.
+func (g *Gateway) Decline(source string) {
+    g.mu.Lock()
+    defer g.mu.Unlock()
+    g.declined[source] = true
+}
+
+func (g *Gateway) Charge(ctx context.Context, amountCents int64, currency, source string) (string, error) {
+    g.mu.Lock()
+    defer g.mu.Unlock()
+    if g.declined[source] {
+        return "", ErrCardDeclined
+    }
+    g.seq++
+    txID := fmt.Sprintf("tx_%d", g.seq)
+    g.txs[txID] = charge{source: source, amountCents: amountCents}
+    g.balance[source] += amountCents
+    return txID, nil
+}
+
+func (g *Gateway) Refund(ctx context.Context, txID string, amountCents int64) error {
+    g.mu.Lock()
+    defer g.mu.Unlock()
+    c, ok := g.txs[txID]
+    if !ok || c.refunded {
+        return ErrUnknownTx
+    }
+    if amountCents > c.amountCents {
+        return fmt.Errorf("refund of %d exceeds charge of %d", amountCents, c.amountCents)
+    }
+    c.refunded = true
+    g.txs[txID] = c
+    g.balance[c.source] -= amountCents
+    return nil
+}
+
+// Charged reports the net amount taken from source after refunds.
+func (g *Gateway) Charged(source string) int64 {
+    g.mu.Lock()
+    defer g.mu.Unlock()
+    return g.balance[source]
+}
+
+// OrderRepo stores orders in a map.
+type OrderRepo struct {
+    mu     sync.Mutex
+    orders map[string]domain.Order
+}
+
+func NewOrderRepo() *OrderRepo { return &OrderRepo{orders: map[string]domain.Order{}} }
+
+func (r *OrderRepo) Save(ctx context.Context, o domain.Order) error {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    r.orders[o.ID] = o
+    return nil
+}
+
+func (r *OrderRepo) Get(ctx context.Context, id string) (domain.Order, error) {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    o, ok := r.orders[id]
+    if !ok {
+        return domain.Order{}, ErrNotFound
+    }
+    return o, nil
+}
+
+// Ledger keeps postings in memory. Record holds the ledger's lock while
+// save runs, so postings are kept in the order their changes were saved.
+type Ledger struct {
+    mu       sync.Mutex
+    postings []domain.Posting
+    ids      map[string]bool
+}
+
+func NewLedger() *Ledger { return &Ledger{ids: map[string]bool{}} }
+
+func (l *Ledger) Record(ctx context.Context, p domain.Posting, save func(ctx context.Context) error) error {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    if !p.Balanced() {
+        return fmt.Errorf("%s: %w", p.ID, ErrUnbalanced)
+    }
+    if l.ids[p.ID] {
+        return fmt.Errorf("%s: %w", p.ID, ErrDuplicatePosting)
+    }
+    if err := save(ctx); err != nil {
+        return err
+    }
+    p.Entries = append([]domain.Entry(nil), p.Entries...)
+    l.postings = append(l.postings, p)
+    l.ids[p.ID] = true
+    return nil
+}
+
+// Postings returns the recorded postings, oldest first.
+func (l *Ledger) Postings() []domain.Posting {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    return append([]domain.Posting(nil), l.postings...)
+}
+
+// Balance is the debits minus the credits posted to account.
+func (l *Ledger) Balance(account string) int64 {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    var b int64
+    for _, p := range l.postings {
+        for _, e := range p.Entries {
+            if e.Account == account {
+                b += e.Debit - e.Credit
+            }
+        }
+    }
+    return b
+}
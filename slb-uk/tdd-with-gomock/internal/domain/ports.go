@@ -6,16 +6,84 @@ import "context"
 
 type PaymentGateway interface {
     Charge(ctx context.Context, amountCents int64, currency, source string) (txID string, err error)
+    Refund(ctx context.Context, txID string, amountCents int64) error
 }
 
 type OrderRepo interface {
     Save(ctx context.Context, o Order) error
+    Get(ctx context.Context, id string) (Order, error)
+}
+
+// SignatureVerifier checks that a webhook payload really comes from the
+// payment provider; signature is the raw signature header.
+type SignatureVerifier interface {
+    Verify(payload []byte, signature string) error
+}
+
+// Ledger is the books: every charge and refund is recorded as a
+// double-entry Posting together with the order change it belongs to.
+// Record runs save, which stores that change, and keeps p only if save
+// succeeds; a SQL ledger does both in one transaction. It rejects, without
+// calling save, a posting that does not balance or whose ID it already
+// holds, so a charge is never booked twice.
+type Ledger interface {
+    Record(ctx context.Context, p Posting, save func(ctx context.Context) error) error
+}
+
+// Accounts the Service posts to.
+const (
+    AccountCash    = "cash"    // money held for us by the payment provider
+    AccountSales   = "sales"   // revenue from paid orders
+    AccountRefunds = "refunds" // revenue given back
+)
+
+// Posting is one transaction in the Ledger. Its entries balance: the debits
+// add up to the credits.
+type Posting struct {
+    ID       string // "charge:<tx>" or "refund:<tx>"
+    OrderID  string
+    TxID     string
+    Currency string
+    Entries  []Entry
+}
+
+// Entry moves cents into (Debit) or out of (Credit) one account; exactly
+// one of the two is positive.
+type Entry struct {
+    Account string
+    Debit   int64
+    Credit  int64
+}
+
+// Balanced reports whether p is a valid posting: at least two entries,
+// each one-sided and positive, with debits equal to credits.
+func (p Posting) Balanced() bool {
+    if len(p.Entries) < 2 {
+        return false
+    }
+    var debit, credit int64
+    for _, e := range p.Entries {
+        if e.Debit < 0 || e.Credit < 0 || (e.Debit == 0) == (e.Credit == 0) {
+            return false
+        }
+        debit += e.Debit
+        credit += e.Credit
+    }
+    return debit == credit
+}
+
+// PaymentConfirmation is the provider's asynchronous verdict on a charge.
+type PaymentConfirmation struct {
+    OrderID     string
+    TxID        string
+    AmountCents int64
+    Succeeded   bool
 }
 
 type Order struct {
     ID          string
     AmountCents int64
     Currency    string
-    Status      string // "pending", "paid", "failed"
+    Status      string // "pending", "paid", "failed", "refunded"
     PaymentTxID string
 }
@@ -0,0 +1,134 @@
+package order_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/slb-uk/tdd-with-gomock/internal/adapters/memory"
+	"github.com/slb-uk/tdd-with-gomock/internal/domain"
+	"github.com/slb-uk/tdd-with-gomock/internal/order"
+)
+
+// flakyRepo fails a share of its saves, like a database under load.
+type flakyRepo struct {
+    *memory.OrderRepo
+    rnd *rand.Rand
+}
+
+func (r flakyRepo) Save(ctx context.Context, o domain.Order) error {
+    if r.rnd.Intn(5) == 0 {
+        return errors.New("db down")
+    }
+    return r.OrderRepo.Save(ctx, o)
+}
+
+// TestLedger_BooksAlwaysBalance runs random places, refunds and webhook
+// confirmations, some of them failing, and checks the books after each:
+// every posting balances, and what the ledger says was earned is exactly
+// what the stored orders say.
+func TestLedger_BooksAlwaysBalance(t *testing.T) {
+    t.Parallel()
+
+    for seed := int64(1); seed <= 20; seed++ {
+        seed := seed
+        t.Run(fmt.Sprint("seed ", seed), func(t *testing.T) {
+            t.Parallel()
+            rnd := rand.New(rand.NewSource(seed))
+            ctx := context.Background()
+            pay, repo, books := memory.NewGateway(), memory.NewOrderRepo(), memory.NewLedger()
+            svc := order.NewService(pay, flakyRepo{repo, rnd}, books)
+            pay.Decline("tok_broke")
+
+            ids := make([]string, 8)
+            for i := range ids {
+                ids[i] = fmt.Sprint("ord_", i)
+            }
+            for step := 0; step < 200; step++ {
+                id := ids[rnd.Intn(len(ids))]
+                amount := int64(1 + rnd.Intn(10000))
+                switch rnd.Intn(4) {
+                case 0:
+                    source := "tok_visa"
+                    if rnd.Intn(4) == 0 {
+                        source = "tok_broke"
+                    }
+                    if _, err := repo.Get(ctx, id); err != nil { // place each order once
+                        _, _ = svc.PlaceOrder(ctx, domain.Order{ID: id, AmountCents: amount, Currency: "INR", Status: "pending"}, source)
+                    }
+                case 1:
+                    _, _ = svc.Refund(ctx, id)
+                case 2:
+                    if _, err := repo.Get(ctx, id); err != nil { // an order awaiting its webhook
+                        _ = repo.Save(ctx, domain.Order{ID: id, AmountCents: amount, Currency: "INR", Status: "pending"})
+                    }
+                case 3:
+                    o, err := repo.Get(ctx, id)
+                    if err != nil {
+                        continue
+                    }
+                    // redeliveries and declines included
+                    _, _ = svc.ConfirmPayment(ctx, domain.PaymentConfirmation{OrderID: id, TxID: "wh_" + id,
+                        AmountCents: o.AmountCents, Succeeded: rnd.Intn(3) > 0})
+                }
+                checkBooks(t, step, books, repo, ids)
+            }
+        })
+    }
+}
+
+func checkBooks(t *testing.T, step int, books *memory.Ledger, repo *memory.OrderRepo, ids []string) {
+    t.Helper()
+    for _, p := range books.Postings() {
+        if !p.Balanced() {
+            t.Fatalf("step %d: posting %s does not balance: %+v", step, p.ID, p.Entries)
+        }
+    }
+    cash, sales, refunds := books.Balance(domain.AccountCash), books.Balance(domain.AccountSales), books.Balance(domain.AccountRefunds)
+    if cash+sales+refunds != 0 {
+        t.Fatalf("step %d: accounts sum to %d", step, cash+sales+refunds)
+    }
+
+    var paid, refunded int64
+    for _, id := range ids {
+        o, err := repo.Get(context.Background(), id)
+        if err != nil {
+            continue
+        }
+        switch o.Status {
+        case "paid":
+            paid += o.AmountCents
+        case "refunded":
+            refunded += o.AmountCents
+        }
+    }
+    if -sales != paid+refunded || refunds != refunded || cash != paid {
+        t.Fatalf("step %d: books say sales %d, refunds %d, cash %d; orders say paid %d, refunded %d",
+            step, -sales, refunds, cash, paid, refunded)
+    }
+}
+
+func TestPosting_Balanced(t *testing.T) {
+    t.Parallel()
+
+    cases := []struct {
+        name    string
+        entries []domain.Entry
+        want    bool
+    }{
+        {"charge", []domain.Entry{{Account: "cash", Debit: 5}, {Account: "sales", Credit: 5}}, true},
+        {"split", []domain.Entry{{Account: "cash", Debit: 5}, {Account: "sales", Credit: 3}, {Account: "tax", Credit: 2}}, true},
+        {"off by one", []domain.Entry{{Account: "cash", Debit: 5}, {Account: "sales", Credit: 4}}, false},
+        {"one entry", []domain.Entry{{Account: "cash", Debit: 5}}, false},
+        {"both sides", []domain.Entry{{Account: "cash", Debit: 5, Credit: 5}, {Account: "sales", Debit: 1, Credit: 1}}, false},
+        {"empty entry", []domain.Entry{{Account: "cash"}, {Account: "sales"}}, false},
+        {"negative", []domain.Entry{{Account: "cash", Debit: -5}, {Account: "sales", Credit: -5}}, false},
+    }
+    for _, tc := range cases {
+        if got := (domain.Posting{Entries: tc.entries}).Balanced(); got != tc.want {
+            t.Errorf("%s: Balanced() = %v, want %v", tc.name, got, tc.want)
+        }
+    }
+}
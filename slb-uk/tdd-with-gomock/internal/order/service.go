@@ -2,21 +2,70 @@ package order
 
 import (
 	"context"
+	"errors"
 	"fmt"
 
 	"github.com/slb-uk/tdd-with-gomock/internal/domain"
 )
 
+// ErrNotRefundable is returned when refunding an order that was never paid
+// or has already been refunded.
+var ErrNotRefundable = errors.New("order is not refundable")
+
+var (
+    // ErrNotPending is returned when a payment confirmation arrives for an
+    // order that was already settled the other way.
+    ErrNotPending = errors.New("order is not pending")
+    // ErrAmountMismatch is returned when the provider confirms a different
+    // amount than the order's.
+    ErrAmountMismatch = errors.New("confirmed amount does not match order")
+)
+
 type Service struct {
-    pay domain.PaymentGateway
-    db  domain.OrderRepo
+    pay    domain.PaymentGateway
+    db     domain.OrderRepo
+    ledger domain.Ledger
+}
+
+func NewService(pay domain.PaymentGateway, db domain.OrderRepo, ledger domain.Ledger) *Service {
+    return &Service{pay: pay, db: db, ledger: ledger}
 }
 
-func NewService(pay domain.PaymentGateway, db domain.OrderRepo) *Service {
-    return &Service{pay: pay, db: db}
+func chargePosting(o domain.Order) domain.Posting {
+    return domain.Posting{ID: "charge:" + o.PaymentTxID, OrderID: o.ID, TxID: o.PaymentTxID, Currency: o.Currency,
+        Entries: []domain.Entry{
+            {Account: domain.AccountCash, Debit: o.AmountCents},
+            {Account: domain.AccountSales, Credit: o.AmountCents},
+        }}
+}
+
+func refundPosting(o domain.Order) domain.Posting {
+    return domain.Posting{ID: "refund:" + o.PaymentTxID, OrderID: o.ID, TxID: o.PaymentTxID, Currency: o.Currency,
+        Entries: []domain.Entry{
+            {Account: domain.AccountRefunds, Debit: o.AmountCents},
+            {Account: domain.AccountCash, Credit: o.AmountCents},
+        }}
+}
+
+// saveWithPosting saves o and records p as one change: the ledger runs the
+// save and keeps p only if it succeeds.
+func (s *Service) saveWithPosting(ctx context.Context, o domain.Order, p domain.Posting) error {
+    var saveErr error
+    err := s.ledger.Record(ctx, p, func(ctx context.Context) error {
+        saveErr = s.db.Save(ctx, o)
+        return saveErr
+    })
+    if saveErr != nil {
+        return fmt.Errorf("save failed: %w", saveErr)
+    }
+    if err != nil {
+        return fmt.Errorf("ledger failed: %w", err)
+    }
+    return nil
 }
 
-// PlaceOrder charges and, if successful, persists the order.
+// PlaceOrder charges and, if successful, books the charge and persists the
+// order.
 func (s *Service) PlaceOrder(ctx context.Context, o domain.Order, source string) (domain.Order, error) {
     if o.AmountCents <= 0 {
         return o, fmt.Errorf("invalid amount")
@@ -31,6 +80,71 @@ func (s *Service) PlaceOrder(ctx context.Context, o domain.Order, source string)
     o.Status = "paid"
     o.PaymentTxID = txID
 
+    if err := s.saveWithPosting(ctx, o, chargePosting(o)); err != nil {
+        return o, err
+    }
+
+    return o, nil
+}
+
+// Refund returns the full amount of a paid order to the customer, books it
+// and marks the order refunded.
+func (s *Service) Refund(ctx context.Context, id string) (domain.Order, error) {
+    o, err := s.db.Get(ctx, id)
+    if err != nil {
+        return o, fmt.Errorf("load failed: %w", err)
+    }
+    if o.Status != "paid" {
+        return o, ErrNotRefundable
+    }
+
+    if err := s.pay.Refund(ctx, o.PaymentTxID, o.AmountCents); err != nil {
+        return o, fmt.Errorf("refund failed: %w", err)
+    }
+
+    o.Status = "refunded"
+
+    if err := s.saveWithPosting(ctx, o, refundPosting(o)); err != nil {
+        return o, err
+    }
+
+    return o, nil
+}
+
+// ConfirmPayment settles a pending order from the provider's asynchronous
+// confirmation: paid with the confirmed transaction, or failed. Providers
+// redeliver webhooks, so a confirmation the order already reflects is a
+// no-op.
+func (s *Service) ConfirmPayment(ctx context.Context, c domain.PaymentConfirmation) (domain.Order, error) {
+    o, err := s.db.Get(ctx, c.OrderID)
+    if err != nil {
+        return o, fmt.Errorf("load failed: %w", err)
+    }
+
+    want := "failed"
+    if c.Succeeded {
+        want = "paid"
+    }
+    if o.Status == want && (!c.Succeeded || o.PaymentTxID == c.TxID) {
+        return o, nil
+    }
+    if o.Status != "pending" {
+        return o, ErrNotPending
+    }
+    if c.AmountCents != o.AmountCents {
+        return o, ErrAmountMismatch
+    }
+
+    o.Status = want
+    if c.Succeeded {
+        // the charge happened at the provider; book it with the order
+        o.PaymentTxID = c.TxID
+        if err := s.saveWithPosting(ctx, o, chargePosting(o)); err != nil {
+            return o, err
+        }
+        return o, nil
+    }
+
     if err := s.db.Save(ctx, o); err != nil {
         return o, fmt.Errorf("save failed: %w", err)
     }
@@ -20,21 +20,27 @@ func TestService_PlaceOrder_Success(t *testing.T) {
 
     mockPay := mocks.NewMockPaymentGateway(ctrl)
     mockRepo := mocks.NewMockOrderRepo(ctrl)
+    mockLedger := mocks.NewMockLedger(ctrl)
 
-    svc := order.NewService(mockPay, mockRepo)
+    svc := order.NewService(mockPay, mockRepo, mockLedger)
 
     in := domain.Order{ID: "ord_1", AmountCents: 4999, Currency: "INR", Status: "pending"}
     source := "tok_visa"
 
-    mockPay.EXPECT().
-        Charge(gomock.Any(), int64(4999), "INR", source).
-        Return("tx_abc123", nil).
-        Times(1)
-
-    mockRepo.EXPECT().
-        Save(gomock.Any(), gomock.AssignableToTypeOf(domain.Order{})).
-        Return(nil).
-        Times(1)
+    // charge, then book the charge with the save inside the ledger's unit
+    gomock.InOrder(
+        mockPay.EXPECT().
+            Charge(gomock.Any(), int64(4999), "INR", source).
+            Return("tx_abc123", nil),
+        mockLedger.EXPECT().
+            Record(gomock.Any(), domain.Posting{ID: "charge:tx_abc123", OrderID: "ord_1", TxID: "tx_abc123", Currency: "INR",
+                Entries: []domain.Entry{{Account: domain.AccountCash, Debit: 4999}, {Account: domain.AccountSales, Credit: 4999}}},
+                gomock.Any()).
+            DoAndReturn(runSave),
+        mockRepo.EXPECT().
+            Save(gomock.Any(), domain.Order{ID: "ord_1", AmountCents: 4999, Currency: "INR", Status: "paid", PaymentTxID: "tx_abc123"}).
+            Return(nil),
+    )
 
     out, err := svc.PlaceOrder(context.Background(), in, source)
     if err != nil {
@@ -51,13 +57,15 @@ func TestService_PlaceOrder_Variants(t *testing.T) {
     cases := []struct {
         name          string
         chargeErr     error
+        ledgerErr     error
         saveErr       error
         wantStatus    string
         wantErrSubstr string
     }{
-        {"success", nil, nil, "paid", ""},
-        {"charge fails", errors.New("card declined"), nil, "failed", "charge failed"},
-        {"save fails", nil, errors.New("db down"), "paid", "save failed"},
+        {"success", nil, nil, nil, "paid", ""},
+        {"charge fails", errors.New("card declined"), nil, nil, "failed", "charge failed"},
+        {"ledger rejects", nil, errors.New("duplicate posting"), nil, "paid", "ledger failed"},
+        {"save fails", nil, nil, errors.New("db down"), "paid", "save failed"},
     }
 
     for _, tc := range cases {
@@ -70,7 +78,8 @@ func TestService_PlaceOrder_Variants(t *testing.T) {
 
             mockPay := mocks.NewMockPaymentGateway(ctrl)
             mockRepo := mocks.NewMockOrderRepo(ctrl)
-            svc := order.NewService(mockPay, mockRepo)
+            mockLedger := mocks.NewMockLedger(ctrl)
+            svc := order.NewService(mockPay, mockRepo, mockLedger)
 
             in := domain.Order{ID: "ord_1", AmountCents: 4999, Currency: "INR", Status: "pending"}
             source := "tok_visa"
@@ -81,13 +90,182 @@ func TestService_PlaceOrder_Variants(t *testing.T) {
                 Times(1)
 
             if tc.chargeErr == nil {
+                record := mockLedger.EXPECT().
+                    Record(gomock.Any(), gomock.AssignableToTypeOf(domain.Posting{}), gomock.Any()).
+                    Times(1)
+                if tc.ledgerErr != nil {
+                    record.Return(tc.ledgerErr) // rejected before the save
+                } else {
+                    record.DoAndReturn(runSave)
+                    mockRepo.EXPECT().
+                        Save(gomock.Any(), gomock.AssignableToTypeOf(domain.Order{})).
+                        Return(tc.saveErr).
+                        Times(1)
+                }
+            }
+
+            out, err := svc.PlaceOrder(context.Background(), in, source)
+
+            if tc.wantErrSubstr == "" && err != nil {
+                t.Fatalf("unexpected err: %v", err)
+            }
+            if tc.wantErrSubstr != "" && (err == nil || !strings.Contains(err.Error(), tc.wantErrSubstr)) {
+                t.Fatalf("want err containing %q, got %v", tc.wantErrSubstr, err)
+            }
+            if out.Status != tc.wantStatus {
+                t.Fatalf("want status %q, got %q", tc.wantStatus, out.Status)
+            }
+        })
+    }
+}
+
+func TestService_Refund(t *testing.T) {
+    t.Parallel()
+
+    paid := domain.Order{ID: "ord_1", AmountCents: 4999, Currency: "INR", Status: "paid", PaymentTxID: "tx_abc123"}
+
+    cases := []struct {
+        name          string
+        stored        domain.Order
+        getErr        error
+        refundErr     error
+        ledgerErr     error
+        saveErr       error
+        wantStatus    string
+        wantErrSubstr string
+    }{
+        {name: "success", stored: paid, wantStatus: "refunded"},
+        {name: "unknown order", getErr: errors.New("not found"), wantErrSubstr: "load failed"},
+        {name: "not paid", stored: domain.Order{ID: "ord_1", Status: "failed"}, wantStatus: "failed", wantErrSubstr: "not refundable"},
+        {name: "gateway refuses", stored: paid, refundErr: errors.New("too late"), wantStatus: "paid", wantErrSubstr: "refund failed"},
+        {name: "ledger rejects", stored: paid, ledgerErr: errors.New("duplicate posting"), wantStatus: "refunded", wantErrSubstr: "ledger failed"},
+        {name: "save fails", stored: paid, saveErr: errors.New("db down"), wantStatus: "refunded", wantErrSubstr: "save failed"},
+    }
+
+    for _, tc := range cases {
+        tc := tc
+        t.Run(tc.name, func(t *testing.T) {
+            t.Parallel()
+
+            ctrl := gomock.NewController(t)
+            defer ctrl.Finish()
+
+            mockPay := mocks.NewMockPaymentGateway(ctrl)
+            mockRepo := mocks.NewMockOrderRepo(ctrl)
+            mockLedger := mocks.NewMockLedger(ctrl)
+            svc := order.NewService(mockPay, mockRepo, mockLedger)
+
+            mockRepo.EXPECT().
+                Get(gomock.Any(), "ord_1").
+                Return(tc.stored, tc.getErr).
+                Times(1)
+
+            if tc.getErr == nil && tc.stored.Status == "paid" {
+                mockPay.EXPECT().
+                    Refund(gomock.Any(), "tx_abc123", int64(4999)).
+                    Return(tc.refundErr).
+                    Times(1)
+            }
+            if tc.wantStatus == "refunded" {
+                record := mockLedger.EXPECT().
+                    Record(gomock.Any(), domain.Posting{ID: "refund:tx_abc123", OrderID: "ord_1", TxID: "tx_abc123", Currency: "INR",
+                        Entries: []domain.Entry{{Account: domain.AccountRefunds, Debit: 4999}, {Account: domain.AccountCash, Credit: 4999}}},
+                        gomock.Any()).
+                    Times(1)
+                if tc.ledgerErr != nil {
+                    record.Return(tc.ledgerErr)
+                } else {
+                    record.DoAndReturn(runSave)
+                    mockRepo.EXPECT().
+                        Save(gomock.Any(), gomock.AssignableToTypeOf(domain.Order{})).
+                        Return(tc.saveErr).
+                        Times(1)
+                }
+            }
+
+            out, err := svc.Refund(context.Background(), "ord_1")
+
+            if tc.wantErrSubstr == "" && err != nil {
+                t.Fatalf("unexpected err: %v", err)
+            }
+            if tc.wantErrSubstr != "" && (err == nil || !strings.Contains(err.Error(), tc.wantErrSubstr)) {
+                t.Fatalf("want err containing %q, got %v", tc.wantErrSubstr, err)
+            }
+            if out.Status != tc.wantStatus {
+                t.Fatalf("want status %q, got %q", tc.wantStatus, out.Status)
+            }
+        })
+    }
+}
+
+func TestService_ConfirmPayment(t *testing.T) {
+    t.Parallel()
+
+    pending := domain.Order{ID: "ord_1", AmountCents: 4999, Currency: "INR", Status: "pending"}
+    paid := domain.Order{ID: "ord_1", AmountCents: 4999, Currency: "INR", Status: "paid", PaymentTxID: "tx_abc123"}
+    ok := domain.PaymentConfirmation{OrderID: "ord_1", TxID: "tx_abc123", AmountCents: 4999, Succeeded: true}
+    declined := domain.PaymentConfirmation{OrderID: "ord_1", TxID: "tx_abc123", AmountCents: 4999}
+
+    cases := []struct {
+        name          string
+        stored        domain.Order
+        getErr        error
+        confirm       domain.PaymentConfirmation
+        saveErr       error
+        wantSave      bool
+        wantStatus    string
+        wantErrSubstr string
+    }{
+        {name: "pending becomes paid", stored: pending, confirm: ok, wantSave: true, wantStatus: "paid"},
+        {name: "pending becomes failed", stored: pending, confirm: declined, wantSave: true, wantStatus: "failed"},
+        {name: "redelivered success is a no-op", stored: paid, confirm: ok, wantStatus: "paid"},
+        {name: "success for another tx", stored: paid, confirm: domain.PaymentConfirmation{OrderID: "ord_1", TxID: "tx_other", AmountCents: 4999, Succeeded: true}, wantStatus: "paid", wantErrSubstr: "not pending"},
+        {name: "failure after paid", stored: paid, confirm: declined, wantStatus: "paid", wantErrSubstr: "not pending"},
+        {name: "amount mismatch", stored: pending, confirm: domain.PaymentConfirmation{OrderID: "ord_1", TxID: "tx_abc123", AmountCents: 1, Succeeded: true}, wantStatus: "pending", wantErrSubstr: "amount does not match"},
+        {name: "unknown order", getErr: errors.New("not found"), confirm: ok, wantErrSubstr: "load failed"},
+        {name: "save fails", stored: pending, confirm: ok, saveErr: errors.New("db down"), wantSave: true, wantStatus: "paid", wantErrSubstr: "save failed"},
+    }
+
+    for _, tc := range cases {
+        tc := tc
+        t.Run(tc.name, func(t *testing.T) {
+            t.Parallel()
+
+            ctrl := gomock.NewController(t)
+            defer ctrl.Finish()
+
+            mockPay := mocks.NewMockPaymentGateway(ctrl)
+            mockRepo := mocks.NewMockOrderRepo(ctrl)
+            mockLedger := mocks.NewMockLedger(ctrl)
+            svc := order.NewService(mockPay, mockRepo, mockLedger)
+
+            mockRepo.EXPECT().
+                Get(gomock.Any(), "ord_1").
+                Return(tc.stored, tc.getErr).
+                Times(1)
+
+            // only a success books anything; a decline moved no money
+            if tc.wantSave && tc.confirm.Succeeded {
+                mockLedger.EXPECT().
+                    Record(gomock.Any(), domain.Posting{ID: "charge:tx_abc123", OrderID: "ord_1", TxID: "tx_abc123", Currency: "INR",
+                        Entries: []domain.Entry{{Account: domain.AccountCash, Debit: 4999}, {Account: domain.AccountSales, Credit: 4999}}},
+                        gomock.Any()).
+                    DoAndReturn(runSave).
+                    Times(1)
+            }
+            if tc.wantSave {
+                want := tc.stored
+                want.Status = tc.wantStatus
+                if tc.confirm.Succeeded {
+                    want.PaymentTxID = tc.confirm.TxID
+                }
                 mockRepo.EXPECT().
-                    Save(gomock.Any(), gomock.AssignableToTypeOf(domain.Order{})).
+                    Save(gomock.Any(), want).
                     Return(tc.saveErr).
                     Times(1)
             }
 
-            out, err := svc.PlaceOrder(context.Background(), in, source)
+            out, err := svc.ConfirmPayment(context.Background(), tc.confirm)
 
             if tc.wantErrSubstr == "" && err != nil {
                 t.Fatalf("unexpected err: %v", err)
@@ -101,3 +279,9 @@ func TestService_PlaceOrder_Variants(t *testing.T) {
         })
     }
 }
+
+// runSave is what a ledger that accepts the posting does: run the save and
+// keep the posting if it succeeds.
+func runSave(ctx context.Context, _ domain.Posting, save func(context.Context) error) error {
+    return save(ctx)
+}
@@ -0,0 +1,81 @@
+package webhook
+
+import (
+    "crypto/hmac"
+    "crypto/sha256"
+    "encoding/hex"
+    "errors"
+    "fmt"
+    "strconv"
+    "strings"
+    "time"
+)
+
+var (
+    ErrBadSignature = errors.New("signature does not match")
+    ErrStale        = errors.New("signature timestamp outside tolerance")
+)
+
+// HMACVerifier checks signatures of the form "t=<unix seconds>,v1=<hex>",
+// where v1 is HMAC-SHA256 over "<t>.<payload>" with the shared secret.
+// Signing the timestamp lets Verify refuse a captured request replayed
+// later.
+type HMACVerifier struct {
+    Secret    []byte
+    Tolerance time.Duration    // 0 means 5 minutes
+    Now       func() time.Time // nil means time.Now
+}
+
+func (v HMACVerifier) Verify(payload []byte, signature string) error {
+    var ts int64
+    var sig []byte
+    for _, part := range strings.Split(signature, ",") {
+        k, val, _ := strings.Cut(strings.TrimSpace(part), "=")
+        switch k {
+        case "t":
+            n, err := strconv.ParseInt(val, 10, 64)
+            if err != nil {
+                return fmt.Errorf("bad timestamp: %w", err)
+            }
+            ts = n
+        case "v1":
+            b, err := hex.DecodeString(val)
+            if err != nil {
+                return fmt.Errorf("bad v1: %w", err)
+            }
+            sig = b
+        }
+    }
+    if ts == 0 || sig == nil {
+        return errors.New("signature needs t and v1")
+    }
+
+    now, tol := time.Now, v.Tolerance
+    if v.Now != nil {
+        now = v.Now
+    }
+    if tol == 0 {
+        tol = 5 * time.Minute
+    }
+    if d := now().Sub(time.Unix(ts, 0)); d > tol || d < -tol {
+        return ErrStale
+    }
+
+    if !hmac.Equal(sig, mac(v.Secret, ts, payload)) {
+        return ErrBadSignature
+    }
+    return nil
+}
+
+// Sign returns the signature header for payload at t, as the provider
+// would send it.
+func Sign(secret, payload []byte, t time.Time) string {
+    return fmt.Sprintf("t=%d,v1=%x", t.Unix(), mac(secret, t.Unix(), payload))
+}
+
+func mac(secret []byte, ts int64, payload []byte) []byte {
+    m := hmac.New(sha256.New, secret)
+    fmt.Fprintf(m, "%d.", ts)
+    m.Write(payload)
+    return m.Sum(nil)
+}
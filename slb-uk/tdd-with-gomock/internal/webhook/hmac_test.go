@@ -0,0 +1,56 @@
+package webhook_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/slb-uk/tdd-with-gomock/internal/webhook"
+)
+
+func TestHMACVerifier(t *testing.T) {
+    t.Parallel()
+
+    secret := []byte("whsec_test")
+    payload := []byte(`{"type":"payment.succeeded","order_id":"ord_1"}`)
+    now := time.Unix(1_700_000_000, 0)
+    v := webhook.HMACVerifier{Secret: secret, Now: func() time.Time { return now }}
+
+    cases := []struct {
+        name      string
+        payload   []byte
+        signature string
+        wantErr   error // with wantAny false, nil means it must verify
+        wantAny   bool
+    }{
+        {name: "valid", payload: payload, signature: webhook.Sign(secret, payload, now)},
+        {name: "within tolerance", payload: payload, signature: webhook.Sign(secret, payload, now.Add(-4*time.Minute))},
+        {name: "tampered body", payload: []byte(`{"type":"payment.succeeded","order_id":"ord_2"}`), signature: webhook.Sign(secret, payload, now), wantErr: webhook.ErrBadSignature},
+        {name: "wrong secret", payload: payload, signature: webhook.Sign([]byte("other"), payload, now), wantErr: webhook.ErrBadSignature},
+        {name: "replayed later", payload: payload, signature: webhook.Sign(secret, payload, now.Add(-10*time.Minute)), wantErr: webhook.ErrStale},
+        {name: "from the future", payload: payload, signature: webhook.Sign(secret, payload, now.Add(10*time.Minute)), wantErr: webhook.ErrStale},
+        {name: "missing header", payload: payload, signature: "", wantAny: true},
+        {name: "garbage", payload: payload, signature: "t=abc,v1=zz", wantAny: true},
+    }
+
+    for _, tc := range cases {
+        tc := tc
+        t.Run(tc.name, func(t *testing.T) {
+            t.Parallel()
+
+            err := v.Verify(tc.payload, tc.signature)
+            switch {
+            case tc.wantAny:
+                if err == nil {
+                    t.Fatal("want an error, got nil")
+                }
+            case tc.wantErr != nil:
+                if !errors.Is(err, tc.wantErr) {
+                    t.Fatalf("want %v, got %v", tc.wantErr, err)
+                }
+            case err != nil:
+                t.Fatalf("unexpected err: %v", err)
+            }
+        })
+    }
+}
@@ -0,0 +1,90 @@
+// Package webhook receives the payment provider's asynchronous payment
+// confirmations and hands them to order.Service once their signature checks
+// out.
+package webhook
+
+import (
+    "encoding/json"
+    "errors"
+    "io"
+    "log"
+    "net/http"
+
+    "github.com/slb-uk/tdd-with-gomock/internal/domain"
+    "github.com/slb-uk/tdd-with-gomock/internal/order"
+)
+
+// SignatureHeader carries the provider's signature of the raw body.
+const SignatureHeader = "X-Signature"
+
+const maxBody = 64 << 10
+
+// Event is the JSON body the provider posts.
+type Event struct {
+    Type        string `json:"type"` // "payment.succeeded" or "payment.failed"
+    OrderID     string `json:"order_id"`
+    TxID        string `json:"tx_id"`
+    AmountCents int64  `json:"amount_cents"`
+}
+
+// Handler answers 2xx once a confirmation is applied (or was already), so
+// the provider stops redelivering; 4xx for requests that will never succeed;
+// and 5xx when loading or saving the order fails, so the provider retries
+// later. That includes an unknown order, which may just not be saved yet.
+type Handler struct {
+    svc    *order.Service
+    verify domain.SignatureVerifier
+}
+
+func NewHandler(svc *order.Service, verify domain.SignatureVerifier) *Handler {
+    return &Handler{svc: svc, verify: verify}
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodPost {
+        w.Header().Set("Allow", http.MethodPost)
+        http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+        return
+    }
+    // verify the exact bytes received, before any parsing
+    body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBody))
+    if err != nil {
+        http.Error(w, "body too large", http.StatusRequestEntityTooLarge)
+        return
+    }
+    if err := h.verify.Verify(body, r.Header.Get(SignatureHeader)); err != nil {
+        http.Error(w, "invalid signature", http.StatusUnauthorized)
+        return
+    }
+
+    var ev Event
+    if err := json.Unmarshal(body, &ev); err != nil || ev.OrderID == "" {
+        http.Error(w, "invalid event", http.StatusBadRequest)
+        return
+    }
+    c := domain.PaymentConfirmation{OrderID: ev.OrderID, TxID: ev.TxID, AmountCents: ev.AmountCents}
+    switch ev.Type {
+    case "payment.succeeded":
+        c.Succeeded = true
+        if c.TxID == "" {
+            http.Error(w, "tx_id required", http.StatusBadRequest)
+            return
+        }
+    case "payment.failed":
+    default:
+        // newer event types must not make the provider retry forever
+        w.WriteHeader(http.StatusNoContent)
+        return
+    }
+
+    _, err = h.svc.ConfirmPayment(r.Context(), c)
+    switch {
+    case err == nil:
+        w.WriteHeader(http.StatusNoContent)
+    case errors.Is(err, order.ErrNotPending), errors.Is(err, order.ErrAmountMismatch):
+        http.Error(w, err.Error(), http.StatusConflict)
+    default:
+        log.Printf("webhook %s for %s: %v", ev.Type, ev.OrderID, err)
+        http.Error(w, "try again later", http.StatusServiceUnavailable)
+    }
+}
@@ -0,0 +1,106 @@
+package webhook_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/slb-uk/tdd-with-gomock/internal/domain"
+	"github.com/slb-uk/tdd-with-gomock/internal/domain/mocks"
+	"github.com/slb-uk/tdd-with-gomock/internal/order"
+	"github.com/slb-uk/tdd-with-gomock/internal/webhook"
+)
+
+func TestHandler(t *testing.T) {
+    t.Parallel()
+
+    pending := domain.Order{ID: "ord_1", AmountCents: 4999, Currency: "INR", Status: "pending"}
+    paid := domain.Order{ID: "ord_1", AmountCents: 4999, Currency: "INR", Status: "paid", PaymentTxID: "tx_1"}
+    succeeded := `{"type":"payment.succeeded","order_id":"ord_1","tx_id":"tx_1","amount_cents":4999}`
+    failed := `{"type":"payment.failed","order_id":"ord_1","amount_cents":4999}`
+
+    cases := []struct {
+        name      string
+        method    string
+        body      string
+        verifyErr error
+        stored    *domain.Order // nil: the repo is not consulted
+        getErr    error
+        saved     *domain.Order // nil: nothing is saved
+        saveErr   error
+        wantCode  int
+    }{
+        {name: "success marks paid", body: succeeded, stored: &pending,
+            saved: &domain.Order{ID: "ord_1", AmountCents: 4999, Currency: "INR", Status: "paid", PaymentTxID: "tx_1"}, wantCode: http.StatusNoContent},
+        {name: "failure marks failed", body: failed, stored: &pending,
+            saved: &domain.Order{ID: "ord_1", AmountCents: 4999, Currency: "INR", Status: "failed"}, wantCode: http.StatusNoContent},
+        {name: "redelivery is acknowledged", body: succeeded, stored: &paid, wantCode: http.StatusNoContent},
+        {name: "bad signature never reaches the repo", body: succeeded, verifyErr: errors.New("mismatch"), wantCode: http.StatusUnauthorized},
+        {name: "not json", body: "nope", wantCode: http.StatusBadRequest},
+        {name: "success without tx", body: `{"type":"payment.succeeded","order_id":"ord_1","amount_cents":4999}`, wantCode: http.StatusBadRequest},
+        {name: "unknown event type is ignored", body: `{"type":"payment.disputed","order_id":"ord_1"}`, wantCode: http.StatusNoContent},
+        {name: "settled the other way", body: failed, stored: &paid, wantCode: http.StatusConflict},
+        {name: "load fails is retried", body: succeeded, getErr: errors.New("db down"), stored: &domain.Order{}, wantCode: http.StatusServiceUnavailable},
+        {name: "save fails is retried", body: succeeded, stored: &pending,
+            saved: &domain.Order{ID: "ord_1", AmountCents: 4999, Currency: "INR", Status: "paid", PaymentTxID: "tx_1"}, saveErr: errors.New("db down"), wantCode: http.StatusServiceUnavailable},
+        {name: "GET is refused", method: http.MethodGet, wantCode: http.StatusMethodNotAllowed},
+    }
+
+    for _, tc := range cases {
+        tc := tc
+        t.Run(tc.name, func(t *testing.T) {
+            t.Parallel()
+
+            ctrl := gomock.NewController(t)
+            defer ctrl.Finish()
+
+            mockPay := mocks.NewMockPaymentGateway(ctrl)
+            mockRepo := mocks.NewMockOrderRepo(ctrl)
+            mockLedger := mocks.NewMockLedger(ctrl)
+            mockVerify := mocks.NewMockSignatureVerifier(ctrl)
+            h := webhook.NewHandler(order.NewService(mockPay, mockRepo, mockLedger), mockVerify)
+
+            method := tc.method
+            if method == "" {
+                method = http.MethodPost
+                // the verifier sees the raw body and header, byte for byte
+                mockVerify.EXPECT().
+                    Verify([]byte(tc.body), "t=1,v1=ab").
+                    Return(tc.verifyErr).
+                    Times(1)
+            }
+            if tc.stored != nil {
+                mockRepo.EXPECT().
+                    Get(gomock.Any(), "ord_1").
+                    Return(*tc.stored, tc.getErr).
+                    Times(1)
+            }
+            if tc.saved != nil && tc.saved.Status == "paid" {
+                // the ledger runs the save with the charge posting
+                mockLedger.EXPECT().
+                    Record(gomock.Any(), gomock.AssignableToTypeOf(domain.Posting{}), gomock.Any()).
+                    DoAndReturn(func(ctx context.Context, _ domain.Posting, save func(context.Context) error) error { return save(ctx) }).
+                    Times(1)
+            }
+            if tc.saved != nil {
+                mockRepo.EXPECT().
+                    Save(gomock.Any(), *tc.saved).
+                    Return(tc.saveErr).
+                    Times(1)
+            }
+
+            req := httptest.NewRequest(method, "/webhooks/payments", strings.NewReader(tc.body)).WithContext(context.Background())
+            req.Header.Set(webhook.SignatureHeader, "t=1,v1=ab")
+            rec := httptest.NewRecorder()
+            h.ServeHTTP(rec, req)
+
+            if rec.Code != tc.wantCode {
+                t.Fatalf("want %d, got %d: %s", tc.wantCode, rec.Code, rec.Body.String())
+            }
+        })
+    }
+}